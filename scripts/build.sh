@@ -33,6 +33,14 @@ case "$1" in
     cd src/delegation_backend
     LD_LIBRARY_PATH="$OUT" $GO test
     ;;
+  bench)
+    cd src/delegation_backend
+    LD_LIBRARY_PATH="$OUT" $GO test -run '^$' -bench . -benchmem
+    ;;
+  contract-test)
+    cd src/delegation_backend
+    LD_LIBRARY_PATH="$OUT" $GO test -v -run '^TestContract'
+    ;;
   integration-test)
     cd src/integration_tests
     $GO test -v --timeout 30m
@@ -44,11 +52,16 @@ case "$1" in
     fi
     # set image name to 673156464838.dkr.ecr.us-west-2.amazonaws.com/uptime-service-backend if IMAGE_NAME is not set
     IMAGE_NAME=${IMAGE_NAME:-uptime-service-backend}
-    docker build -t "$IMAGE_NAME:$TAG" -f dockerfiles/Dockerfile-delegation-backend .
+    docker build -t "$IMAGE_NAME:$TAG" \
+      --build-arg VERSION="$TAG" --build-arg COMMIT="$(git rev-parse HEAD 2>/dev/null)" \
+      -f dockerfiles/Dockerfile-delegation-backend .
     ;;
   "")
     cd src/cmd/delegation_backend
-    $GO build -o "$OUT/bin/delegation_backend"
+    VERSION=${VERSION:-$(git describe --tags --always 2>/dev/null || echo dev)}
+    PKG=block_producers_uptime/delegation_backend
+    LDFLAGS="-X $PKG.Version=$VERSION -X $PKG.Commit=$(git rev-parse HEAD 2>/dev/null) -X $PKG.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+    $GO build -ldflags "$LDFLAGS" -o "$OUT/bin/delegation_backend"
     echo "to run use cmd: LD_LIBRARY_PATH=result ./result/bin/delegation_backend"
     ;;
   *)
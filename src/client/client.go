@@ -0,0 +1,299 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_MAX_ATTEMPTS = 5
+	DEFAULT_BACKOFF      = time.Second
+	DEFAULT_MAX_BACKOFF  = time.Minute
+	DEFAULT_TIMEOUT      = 30 * time.Second
+)
+
+// Responses are read up to this size, those of the backend are small
+const MAX_RESPONSE_SIZE = 1 << 20
+
+const (
+	// The submission was saved
+	STATUS_OK = "ok"
+	// The submission wasn't saved, as its window is already credited
+	STATUS_DUPLICATE = "duplicate"
+)
+
+// Receipt proves the backend accepted the submission, when the backend
+// issues receipts
+type Receipt struct {
+	Version      int       `json:"version"`
+	SubmissionId string    `json:"submission_id"`
+	Submitter    string    `json:"submitter"`
+	BlockHash    string    `json:"block_hash"`
+	ReceivedAt   time.Time `json:"received_at"`
+	KeyId        string    `json:"key_id,omitempty"`
+	Signature    string    `json:"signature"`
+}
+
+// Response of the backend to an accepted submission
+type Response struct {
+	// STATUS_OK or STATUS_DUPLICATE
+	Status string `json:"status"`
+	// Window credited, when submission windows are enforced
+	WindowId *int     `json:"window_id,omitempty"`
+	Receipt  *Receipt `json:"receipt,omitempty"`
+	// Id the backend looks the submission up by, when it indexes receipts
+	ReceiptId string `json:"receipt_id,omitempty"`
+}
+
+// SchemaError is a value of a submission not matching the schema of
+// submissions, at its JSON pointer
+type SchemaError struct {
+	Path string `json:"path"`
+	Msg  string `json:"error"`
+}
+
+// Error is the rejection of a submission by the backend
+type Error struct {
+	StatusCode int    `json:"-"`
+	Msg        string `json:"error"`
+	// Code of the rejection if it has one, e.g. public_key_base58check
+	Code   string        `json:"code,omitempty"`
+	Errors []SchemaError `json:"errors,omitempty"`
+	// What the exporter can do about the rejection, if the backend knows
+	Hint string `json:"hint,omitempty"`
+	// Set by responses with a Retry-After header
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Hint != "" {
+		msg += " (" + e.Hint + ")"
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("submission rejected with status %d (%s): %s", e.StatusCode, e.Code, msg)
+	}
+	return fmt.Sprintf("submission rejected with status %d: %s", e.StatusCode, msg)
+}
+
+// Temporary tells whether the submission may be accepted if submitted
+// again: the request timed out, was rate limited, or the backend failed
+// or is overloaded
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Client submits to a backend
+type Client struct {
+	// Base URL of the backend, e.g. https://uptime-backend.minaprotocol.com
+	URL        string
+	HTTPClient *http.Client
+	// Attempts of a submission before it fails [default: 5]
+	MaxAttempts int
+	// Delay before the first retry, doubled on each retry up to MaxBackoff
+	// [default: 1s, 1m]
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	UserAgent  string
+	// Bodies larger than this are uploaded in chunks of at most this size,
+	// for proxies limiting the size of bodies, none are if zero
+	ChunkSize int
+}
+
+// New client of the backend at the URL
+func New(url string) *Client {
+	return &Client{URL: strings.TrimSuffix(url, "/"), HTTPClient: &http.Client{Timeout: DEFAULT_TIMEOUT}}
+}
+
+func (c *Client) maxAttempts() int {
+	if c.MaxAttempts > 0 {
+		return c.MaxAttempts
+	}
+	return DEFAULT_MAX_ATTEMPTS
+}
+
+// Delay before the attempt following attempt, Retry-After of the
+// rejection prevailing
+func (c *Client) backoff(attempt int, err error) time.Duration {
+	var rejection *Error
+	if errors.As(err, &rejection) && rejection.RetryAfter > 0 {
+		return rejection.RetryAfter
+	}
+	backoff, maxBackoff := c.Backoff, c.MaxBackoff
+	if backoff <= 0 {
+		backoff = DEFAULT_BACKOFF
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DEFAULT_MAX_BACKOFF
+	}
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// Submit the request, retrying failures of the connection and
+// rejections which are temporary. Other rejections are returned as an
+// *Error at once.
+func (c *Client) Submit(ctx context.Context, req *Request) (*Response, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var resp *Response
+		resp, err = c.submitOnce(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		var rejection *Error
+		if errors.As(err, &rejection) && !rejection.Temporary() {
+			return nil, err
+		}
+		if attempt >= c.maxAttempts() {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.backoff(attempt, err)):
+		}
+	}
+	return nil, fmt.Errorf("submission failed after %d attempts: %w", c.maxAttempts(), err)
+}
+
+func (c *Client) submitOnce(ctx context.Context, req *Request) (*Response, error) {
+	path := req.Path
+	if path == "" {
+		path = SUBMIT_PATH_V1
+	}
+	if c.ChunkSize > 0 && len(req.Body) > c.ChunkSize {
+		return c.submitChunked(ctx, path, req)
+	}
+	httpResp, err := c.post(ctx, path, "application/json", req.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	return ParseResponse(httpResp)
+}
+
+func (c *Client) post(ctx context.Context, path string, contentType string, body []byte) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	if c.UserAgent != "" {
+		httpReq.Header.Set("User-Agent", c.UserAgent)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(httpReq)
+}
+
+// Status of a chunked upload, as responded by the backend
+type uploadStatus struct {
+	UploadId  string `json:"upload_id"`
+	Received  int    `json:"received"`
+	ChunkSize int    `json:"chunk_size"`
+}
+
+// Post to an endpoint of chunked uploads, decoding the status of the
+// upload responded
+func (c *Client) postUpload(ctx context.Context, path string, contentType string, body []byte) (*uploadStatus, error) {
+	httpResp, err := c.post(ctx, path, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode == http.StatusConflict {
+		// Chunk at another offset than the bytes received, resumed from there
+		if received, err := strconv.Atoi(httpResp.Header.Get("Upload-Offset")); err == nil {
+			return &uploadStatus{Received: received}, nil
+		}
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		_, err := ParseResponse(httpResp)
+		return nil, err
+	}
+	var status uploadStatus
+	if err := json.NewDecoder(io.LimitReader(httpResp.Body, MAX_RESPONSE_SIZE)).Decode(&status); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	return &status, nil
+}
+
+// Upload the body of the request in chunks to the chunked upload
+// endpoints of the path, and submit it once uploaded
+func (c *Client) submitChunked(ctx context.Context, path string, req *Request) (*Response, error) {
+	digest := sha256.Sum256(req.Body)
+	init, _ := json.Marshal(map[string]interface{}{"size": len(req.Body), "sha256": hex.EncodeToString(digest[:])})
+	status, err := c.postUpload(ctx, path+"/init", "application/json", init)
+	if err != nil {
+		return nil, err
+	}
+	id := status.UploadId
+	chunkSize := c.ChunkSize
+	if status.ChunkSize > 0 && status.ChunkSize < chunkSize {
+		chunkSize = status.ChunkSize
+	}
+	for offset := 0; offset < len(req.Body); {
+		end := offset + chunkSize
+		if end > len(req.Body) {
+			end = len(req.Body)
+		}
+		query := "?upload_id=" + id + "&offset=" + strconv.Itoa(offset)
+		status, err := c.postUpload(ctx, path+"/chunk"+query, "application/octet-stream", req.Body[offset:end])
+		if err != nil {
+			return nil, err
+		}
+		offset = status.Received
+	}
+	httpResp, err := c.post(ctx, path+"/complete?upload_id="+id, "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	return ParseResponse(httpResp)
+}
+
+// ParseResponse decodes the response of the backend to a submission, a
+// rejection is returned as an *Error
+func ParseResponse(httpResp *http.Response) (*Response, error) {
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, MAX_RESPONSE_SIZE))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		rejection := &Error{StatusCode: httpResp.StatusCode}
+		// Responses of proxies in front of the backend may not be JSON
+		if json.Unmarshal(body, rejection) != nil {
+			rejection.Msg = strings.TrimSpace(string(body))
+		}
+		if seconds, err := strconv.Atoi(httpResp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			rejection.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, rejection
+	}
+	var resp Response
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSubmitRetries(t *testing.T) {
+	var bodies []string
+	statuses := []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, r.URL.Path+" "+string(body))
+		status := statuses[0]
+		statuses = statuses[1:]
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{"status":"ok","window_id":42,"receipt":{"version":1,"submission_id":"submissions/2024-05-01/x.json","signature":"s"}}`))
+		} else {
+			w.Write([]byte("upstream unavailable"))
+		}
+	}))
+	defer srv.Close()
+	c := New(srv.URL + "/")
+	c.Backoff = time.Millisecond
+	resp, err := c.Submit(context.Background(), &Request{Path: SUBMIT_PATH_V2, Body: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("expected the submission accepted on the third attempt, got %v", err)
+	}
+	if len(bodies) != 3 || bodies[2] != "/v2/submit {}" {
+		t.Fatalf("unexpected requests %q", bodies)
+	}
+	if resp.Status != STATUS_OK || *resp.WindowId != 42 || resp.Receipt.SubmissionId != "submissions/2024-05-01/x.json" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestSubmitRejected(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "Field submitter is invalid", "code": "public_key_base58check", "hint": "Check submitter is the key of the block producer"})
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+	c.Backoff = time.Millisecond
+	_, err := c.Submit(context.Background(), &Request{Body: []byte(`{}`)})
+	var rejection *Error
+	if !errors.As(err, &rejection) || rejection.StatusCode != http.StatusBadRequest || rejection.Code != "public_key_base58check" || rejection.Temporary() {
+		t.Fatalf("expected the rejection, got %v", err)
+	}
+	if rejection.Error() != "submission rejected with status 400 (public_key_base58check): Field submitter is invalid (Check submitter is the key of the block producer)" {
+		t.Fatalf("unexpected error message %q", rejection.Error())
+	}
+	if attempts != 1 {
+		t.Fatalf("expected rejections not retried, got %d attempts", attempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Client{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if backoff := c.backoff(attempt, errors.New("timeout")); backoff != expected {
+			t.Errorf("unexpected backoff %v after attempt %d, expected %v", backoff, attempt, expected)
+		}
+	}
+	if backoff := c.backoff(1, &Error{StatusCode: http.StatusServiceUnavailable, RetryAfter: 30 * time.Second}); backoff != 30*time.Second {
+		t.Errorf("expected Retry-After respected, got %v", backoff)
+	}
+}
@@ -0,0 +1,259 @@
+// Package client builds, signs and submits the submissions of block
+// producers to the uptime backend, for exporters written in Go.
+//
+// Submissions are built from their fields (Build), so that the sign
+// payload, the encodings of the request and the hash of the block are
+// those the backend checks. Signatures are made by a Signer, with the key
+// of the submitter. Client submits them, retrying failures which may be
+// transient, and decodes the responses of the backend.
+//
+// The package doesn't depend on the packages of the backend, which link
+// the Mina signer library, and is imported as
+// block_producers_uptime/client.
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"block_producers_uptime/metadata"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Versions of the construction of sign payloads
+const (
+	// Fields of the data in a fixed order, strings written as sent
+	SIGN_PAYLOAD_V1 = 1
+	// Canonical JSON of the data along with the version
+	SIGN_PAYLOAD_V2 = 2
+)
+
+// Version byte of the base58check encoding of block hashes
+const BASE58CHECK_VERSION_BLOCK_HASH byte = 0x10
+
+const (
+	SUBMIT_PATH_V1 = "/v1/submit"
+	// Accepts the telemetry of nodes along with submissions
+	SUBMIT_PATH_V2 = "/v2/submit"
+)
+
+// Signer signs the blake2b-256 hash of the sign payload of a submission
+// with the key of the submitter, returning the base58check-encoded
+// signature
+type Signer interface {
+	Sign(hash []byte) (string, error)
+}
+
+// SignerFunc is a function signing hashes as a Signer
+type SignerFunc func(hash []byte) (string, error)
+
+func (f SignerFunc) Sign(hash []byte) (string, error) {
+	return f(hash)
+}
+
+// Submission is the data a block producer submits
+type Submission struct {
+	// Base58check-encoded public key of the submitter
+	Submitter string
+	Block     []byte
+	// Submissions have a precision of one second
+	CreatedAt time.Time
+	PeerId    string
+	// Optional
+	SnarkWork          []byte
+	GraphqlControlPort int
+	BuiltWithCommitSha string
+	// Version of the sign payload, SIGN_PAYLOAD_V2 by default. Backends
+	// accepting version 2 only can be configured to reject version 1.
+	PayloadVersion int
+	// Optional, delegation token of the submitter, the submission is
+	// then signed by the delegate
+	Delegation string
+	// Optional global slot and state hash of the block, as of version 2
+	// of the schema of submissions
+	GlobalSlot *int
+	StateHash  string
+	// Optional, submitted to SUBMIT_PATH_V2 when set
+	Telemetry *metadata.Telemetry
+}
+
+// Request is a submission built and signed, ready to be submitted
+type Request struct {
+	// Path of the endpoint the request is submitted to
+	Path string
+	Body []byte
+	// Hash of the block the backend saves it by, sent along
+	BlockHash       string
+	SignPayloadHash []byte
+}
+
+type requestData struct {
+	Block              string `json:"block"`
+	CreatedAt          string `json:"created_at"`
+	PeerId             string `json:"peer_id"`
+	SnarkWork          string `json:"snark_work,omitempty"`
+	GraphqlControlPort int    `json:"graphql_control_port,omitempty"`
+	BuiltWithCommitSha string `json:"built_with_commit_sha,omitempty"`
+}
+
+type request struct {
+	Submitter      string              `json:"submitter"`
+	Sig            string              `json:"signature"`
+	Data           requestData         `json:"data"`
+	Delegation     string              `json:"delegation,omitempty"`
+	BlockHash      string              `json:"block_hash,omitempty"`
+	GlobalSlot     *int                `json:"global_slot,omitempty"`
+	StateHash      string              `json:"state_hash,omitempty"`
+	Telemetry      *metadata.Telemetry `json:"telemetry,omitempty"`
+	PayloadVersion int                 `json:"payload_version,omitempty"`
+}
+
+// BlockHash is the base58check-encoded blake2b-256 hash of the block, by
+// which the backend saves blocks
+func BlockHash(block []byte) string {
+	hash := blake2b.Sum256(block)
+	return base58.CheckEncode(hash[:], BASE58CHECK_VERSION_BLOCK_HASH)
+}
+
+func (s *Submission) payloadVersion() int {
+	if s.PayloadVersion == 0 {
+		return SIGN_PAYLOAD_V2
+	}
+	return s.PayloadVersion
+}
+
+func (s *Submission) validate() error {
+	switch {
+	case s.Submitter == "":
+		return errors.New("submitter is required")
+	case s.Block == nil:
+		return errors.New("block is required")
+	case s.CreatedAt.IsZero():
+		return errors.New("created_at is required")
+	case s.PeerId == "":
+		return errors.New("peer_id is required")
+	}
+	return nil
+}
+
+func jsonString(s string) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	// Strings always encode
+	_ = enc.Encode(s)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// WriteSignPayload writes the payload signed by the submitter, of the
+// version of the submission
+func (s *Submission) WriteSignPayload(w io.Writer) error {
+	createdAt := s.CreatedAt.UTC().Format(time.RFC3339)
+	var buf bytes.Buffer
+	switch s.payloadVersion() {
+	case SIGN_PAYLOAD_V1:
+		// Strings are written as they are sent, unescaped
+		fmt.Fprintf(&buf, `{"block":"%s","created_at":"%s","peer_id":"%s"`, base64.StdEncoding.EncodeToString(s.Block), createdAt, s.PeerId)
+		if s.SnarkWork != nil {
+			fmt.Fprintf(&buf, `,"snark_work":"%s"`, base64.StdEncoding.EncodeToString(s.SnarkWork))
+		}
+		if s.GraphqlControlPort != 0 {
+			fmt.Fprintf(&buf, `,"graphql_control_port":%d`, s.GraphqlControlPort)
+		}
+		if s.BuiltWithCommitSha != "" {
+			fmt.Fprintf(&buf, `,"built_with_commit_sha":"%s"`, s.BuiltWithCommitSha)
+		}
+	case SIGN_PAYLOAD_V2:
+		// Keys in lexicographic order, the version signed along
+		fmt.Fprintf(&buf, `{"block":"%s"`, base64.StdEncoding.EncodeToString(s.Block))
+		if s.BuiltWithCommitSha != "" {
+			fmt.Fprintf(&buf, `,"built_with_commit_sha":%s`, jsonString(s.BuiltWithCommitSha))
+		}
+		fmt.Fprintf(&buf, `,"created_at":%s`, jsonString(createdAt))
+		if s.GraphqlControlPort != 0 {
+			fmt.Fprintf(&buf, `,"graphql_control_port":%d`, s.GraphqlControlPort)
+		}
+		fmt.Fprintf(&buf, `,"payload_version":%d,"peer_id":%s`, SIGN_PAYLOAD_V2, jsonString(s.PeerId))
+		if s.SnarkWork != nil {
+			fmt.Fprintf(&buf, `,"snark_work":"%s"`, base64.StdEncoding.EncodeToString(s.SnarkWork))
+		}
+	default:
+		return fmt.Errorf("unknown sign payload version %d", s.PayloadVersion)
+	}
+	buf.WriteString("}")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// SignPayloadHash is the blake2b-256 hash of the sign payload, the hash
+// signed by the submitter
+func (s *Submission) SignPayloadHash() ([]byte, error) {
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.WriteSignPayload(h); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Build the request of the submission, signed by signer
+func Build(s Submission, signer Signer) (*Request, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	hash, err := s.SignPayloadHash()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.Sign(hash)
+	if err != nil {
+		return nil, fmt.Errorf("error signing submission: %w", err)
+	}
+	req := request{
+		Submitter: s.Submitter,
+		Sig:       sig,
+		Data: requestData{
+			Block:              base64.StdEncoding.EncodeToString(s.Block),
+			CreatedAt:          s.CreatedAt.UTC().Format(time.RFC3339),
+			PeerId:             s.PeerId,
+			GraphqlControlPort: s.GraphqlControlPort,
+			BuiltWithCommitSha: s.BuiltWithCommitSha,
+		},
+		Delegation: s.Delegation,
+		BlockHash:  BlockHash(s.Block),
+		GlobalSlot: s.GlobalSlot,
+		StateHash:  s.StateHash,
+		Telemetry:  s.Telemetry,
+	}
+	if s.SnarkWork != nil {
+		req.Data.SnarkWork = base64.StdEncoding.EncodeToString(s.SnarkWork)
+	}
+	if version := s.payloadVersion(); version != SIGN_PAYLOAD_V1 {
+		req.PayloadVersion = version
+	}
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(req); err != nil {
+		return nil, err
+	}
+	path := SUBMIT_PATH_V1
+	if s.Telemetry != nil {
+		path = SUBMIT_PATH_V2
+	}
+	return &Request{
+		Path:            path,
+		Body:            bytes.TrimSuffix(body.Bytes(), []byte("\n")),
+		BlockHash:       req.BlockHash,
+		SignPayloadHash: hash,
+	}, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	dg "block_producers_uptime/delegation_backend"
+	"context"
 	"os"
 
 	logging "github.com/ipfs/go-log/v2"
@@ -21,6 +22,13 @@ func main() {
 	log := logging.Logger("delegation backend db migration")
 
 	config := dg.LoadEnv(log)
+	secretResolver, err := dg.NewSecretResolver(context.Background(), config.Secrets)
+	if err != nil {
+		log.Fatalf("Error configuring secrets: %v", err)
+	}
+	if err := dg.ResolveSecrets(&config, secretResolver); err != nil {
+		log.Fatalf("Error resolving secrets: %v", err)
+	}
 
 	if len(os.Args) < 2 {
 		log.Fatal("Missing required command: 'up' or 'down'")
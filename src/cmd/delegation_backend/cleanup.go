@@ -0,0 +1,124 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Delete submissions and blocks older than the retention of backends
+func cleanup(args []string) {
+	flags := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	backends := flags.String("backends", "", "Comma-separated backends to clean up (s3, keyspaces, postgresql, filesystem), those with a retention policy by default")
+	olderThan := flags.Int("older-than", 0, "Delete what is older than this many days, overrides the maximum age of the retention policies")
+	dryRun := flags.Bool("dry-run", false, "List what would be deleted without deleting it")
+	orphanedBlocks := flags.Bool("orphaned-blocks", false, "Also delete blocks of s3 and filesystem referenced by no submission, as set by retention.orphaned_blocks by default")
+	grace := flags.Int("grace", 0, "Hours after being saved during which orphaned blocks are kept, overrides retention.orphaned_blocks_grace_hours")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	log := logging.Logger("delegation backend cleanup")
+	if *olderThan < 0 {
+		log.Fatalf("Invalid -older-than %d, expected a number of days", *olderThan)
+	}
+	if *grace < 0 {
+		log.Fatalf("Invalid -grace %d, expected a number of hours", *grace)
+	}
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	policies := make(map[string]*RetentionPolicy)
+	if appCfg.Retention != nil {
+		policies = appCfg.Retention.Policies()
+	}
+	// Backends are opened with blocks left to the collection
+	if *orphanedBlocks || *grace > 0 {
+		if appCfg.Retention == nil {
+			appCfg.Retention = &RetentionConfig{}
+		}
+		appCfg.Retention.OrphanedBlocks = appCfg.Retention.OrphanedBlocks || *orphanedBlocks
+		if *grace > 0 {
+			appCfg.Retention.OrphanedBlocksGraceHours = *grace
+		}
+	}
+
+	var names []string
+	if *backends == "" {
+		for name := range policies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	} else {
+		configured := make(map[string]bool)
+		for _, name := range configuredBackends(appCfg) {
+			configured[name] = true
+		}
+		for _, name := range strings.Split(*backends, ",") {
+			name = strings.TrimSpace(name)
+			if !configured[name] {
+				log.Fatalf("Backend %q is not configured", name)
+			}
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		log.Fatal("No backend to clean up, configure retention or set -backends")
+	}
+
+	job := NewRetentionJob(time.Now, log)
+	var closers []func()
+	for _, name := range names {
+		maxAge := time.Duration(*olderThan) * 24 * time.Hour
+		if *olderThan == 0 {
+			if policies[name] == nil {
+				log.Fatalf("No retention policy for %s, set the age with -older-than", name)
+			}
+			maxAge = policies[name].MaxAge()
+		}
+		backend, err := openBackend(ctx, name, appCfg, secretResolver, log)
+		if err != nil {
+			log.Fatalf("Error initializing %s backend: %v", name, err)
+		}
+		closers = append(closers, backend.Close)
+		job.Add(name, backend.Cleaner, maxAge)
+		if appCfg.Retention != nil && appCfg.Retention.OrphanedBlocks && backend.BlockGC != nil {
+			job.AddBlockGC(name, backend.BlockGC)
+		}
+	}
+
+	var listed func(string, string)
+	if *dryRun {
+		listed = func(backend string, path string) {
+			fmt.Printf("%s\t%s\n", backend, path)
+		}
+	}
+	reports := job.Run(ctx, *dryRun, listed)
+	for _, closeBackend := range closers {
+		closeBackend()
+	}
+	status := 0
+	for _, report := range reports {
+		verb := "Deleted"
+		if report.DryRun {
+			verb = "Would delete"
+		}
+		total := report.Total()
+		fmt.Printf("%s: %s %d objects (%d bytes) before %s\n", report.Backend, verb, total.Objects, total.Bytes, report.Cutoff.UTC().Format("2006-01-02"))
+		if orphaned := report.OrphanedBlocks; orphaned.Objects > 0 {
+			fmt.Printf("%s: including %d orphaned blocks (%d bytes)\n", report.Backend, orphaned.Objects, orphaned.Bytes)
+		}
+		if report.Error != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", report.Backend, report.Error)
+			status = 1
+		}
+	}
+	if status != 0 {
+		os.Exit(status)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+const CONFIG_FLAG_USAGE = "Path of the configuration file (JSON, YAML or TOML), overrides CONFIG_FILE"
+
+// Default directory of AWS Keyspaces migrations, as in the Docker image
+const DATABASE_MIGRATION_DIR = "/database/migrations"
+
+const USAGE = `Usage: delegation_backend [command] [flags]
+
+Commands:
+  serve                    run the server, the default command
+  validate-config          validate the configuration and probe the backends
+  migrate up|down|version  migrate the AWS Keyspaces and PostgreSQL databases
+  replay                   save submissions of the local filesystem storage or S3 to backends
+  cleanup                  delete submissions older than the retention of backends
+  purge                    delete or quarantine the submissions of a submitter from backends
+  migrate-storage          copy submissions from a storage backend to another
+  rewrite-paths            copy submissions of an object storage to paths of another layout
+  diff-backends            compare the submissions of two backends, repairing those missing
+  upgrade-meta             rewrite metadata of submissions to the current version of its schema
+  export                   write submissions of a range of days as a dataset for scoring
+  verify                   check stored blocks and signatures for corrupt or orphaned objects
+  reverify                 verify quarantined submissions again, restoring those which verify
+  inspect                  print a request body or a stored submission, decoded
+  index                    build indexes of days of submissions for lookups
+  snapshot                 save the state of a running instance (rate limits, replay protection...) to storage
+  restore                  restore a snapshot of the state to a running instance
+  version                  print the version
+
+Run 'delegation_backend <command> -h' for the flags of a command.
+`
+
+func main() {
+	args := os.Args[1:]
+	// Flags without a command are flags of serve
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	} else if len(args) == 1 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		command = "help"
+	}
+	switch command {
+	case "serve":
+		serve(args)
+	case "validate-config":
+		validateConfig(args)
+	case "migrate":
+		migrate(args)
+	case "replay":
+		replay(args)
+	case "cleanup":
+		cleanup(args)
+	case "purge":
+		purge(args)
+	case "migrate-storage":
+		migrateStorage(args)
+	case "rewrite-paths":
+		rewritePaths(args)
+	case "diff-backends":
+		diffBackends(args)
+	case "upgrade-meta":
+		upgradeMeta(args)
+	case "export":
+		export(args)
+	case "verify":
+		verify(args)
+	case "reverify":
+		reverify(args)
+	case "inspect":
+		inspect(args)
+	case "index":
+		index(args)
+	case "snapshot":
+		snapshot(args)
+	case "restore":
+		restore(args)
+	case "version":
+		fmt.Println(GetBuildInfo())
+	case "help":
+		fmt.Print(USAGE)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, USAGE)
+		os.Exit(2)
+	}
+}
+
+func validateConfig(args []string) {
+	flags := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	flags.Parse(args)
+	os.Exit(checkConfig(context.Background(), *configFile, logging.Logger("delegation backend")))
+}
+
+// Print the report of the checks of the configuration,
+// returning the exit status
+func checkConfig(ctx context.Context, configFile string, log *logging.ZapEventLogger) int {
+	if !WriteConfigReport(os.Stdout, CheckConfig(ctx, configFile, log)) {
+		return 1
+	}
+	return 0
+}
+
+func migrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	migrationDir := flags.String("dir", DATABASE_MIGRATION_DIR, "Directory of the AWS Keyspaces migrations, PostgreSQL migrations are embedded")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), "Usage: delegation_backend migrate [flags] up|down|version\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || (flags.Arg(0) != "up" && flags.Arg(0) != "down" && flags.Arg(0) != "version") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	log := logging.Logger("delegation backend db migration")
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	if appCfg.AwsKeyspaces == nil && appCfg.PostgreSQL == nil {
+		log.Fatal("No AWS Keyspaces or PostgreSQL backend configured")
+	}
+	if appCfg.AwsKeyspaces != nil {
+		switch flags.Arg(0) {
+		case "up":
+			if err := MigrationUp(appCfg.AwsKeyspaces, *migrationDir); err != nil {
+				log.Fatalf("AWS Keyspaces migration up failed: %v", err)
+			}
+		case "down":
+			if err := MigrationDown(appCfg.AwsKeyspaces, *migrationDir); err != nil {
+				log.Fatalf("AWS Keyspaces migration down failed: %v", err)
+			}
+		default:
+			log.Warnf("Version of the AWS Keyspaces schema is not reported")
+		}
+	}
+	if appCfg.PostgreSQL != nil {
+		password, err := NewSecret(secretResolver, appCfg.PostgreSQL.Password)
+		if err != nil {
+			log.Fatalf("Error resolving PostgreSQL password: %v", err)
+		}
+		db, err := NewPostgreSQLWithSecret(appCfg.PostgreSQL, password)
+		if err != nil {
+			log.Fatalf("Error initializing PostgreSQL: %v", err)
+		}
+		defer db.Close()
+		switch flags.Arg(0) {
+		case "up":
+			version, err := PostgreSQLMigrationUp(db)
+			if err != nil {
+				log.Fatalf("PostgreSQL migration up failed: %v", err)
+			}
+			fmt.Printf("PostgreSQL schema at version %d\n", version)
+		case "down":
+			if err := PostgreSQLMigrationDown(db); err != nil {
+				log.Fatalf("PostgreSQL migration down failed: %v", err)
+			}
+		default:
+			version, dirty, err := PostgreSQLMigrationVersion(db)
+			if err != nil {
+				log.Fatalf("Error reading PostgreSQL schema version: %v", err)
+			}
+			if dirty {
+				fmt.Printf("PostgreSQL schema at version %d, dirty: the migration failed and needs fixing by hand\n", version)
+			} else {
+				fmt.Printf("PostgreSQL schema at version %d\n", version)
+			}
+		}
+	}
+}
+
+// Load the configuration, set up logging and resolve secrets
+// as on start of the server
+func loadResolvedConfig(ctx context.Context, configFile string, log *logging.ZapEventLogger) (AppConfig, *SecretResolver) {
+	appCfg := LoadConfig(configFile, log)
+	if _, err := ConfigureLogging(appCfg.Logging); err != nil {
+		log.Fatalf("Error configuring logging: %v", err)
+	}
+	secretResolver, err := NewSecretResolver(ctx, appCfg.Secrets)
+	if err != nil {
+		log.Fatalf("Error configuring secrets: %v", err)
+	}
+	if err := ResolveSecrets(&appCfg, secretResolver); err != nil {
+		log.Fatalf("Error resolving secrets: %v", err)
+	}
+	return appCfg, secretResolver
+}
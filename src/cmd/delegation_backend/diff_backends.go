@@ -0,0 +1,87 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Compare the submissions of two backends over a range of days, copying
+// submissions missing from one of them from the other with -repair
+func diffBackends(args []string) {
+	flags := flag.NewFlagSet("diff-backends", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	a := flags.String("a", "", "First backend to compare (s3, keyspaces, postgresql or filesystem) (required)")
+	b := flags.String("b", "", "Second backend to compare (s3, keyspaces, postgresql or filesystem) (required)")
+	since := flags.String("since", "", "Compare submissions of this day (YYYY-MM-DD) and later (required)")
+	until := flags.String("until", "", "Compare submissions of this day (YYYY-MM-DD) and earlier (required)")
+	repair := flags.Bool("repair", false, "Copy submissions missing from a backend from the other, if it is s3 or filesystem")
+	output := flags.String("output", "", "File of the report, standard output by default")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log := logging.Logger("delegation backend diff-backends")
+	if *a == "" || *b == "" || *a == *b {
+		log.Fatal("Set different backends with -a and -b")
+	}
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	configured := make(map[string]bool)
+	for _, name := range configuredBackends(appCfg) {
+		configured[name] = true
+	}
+	var backends []DiffedBackend
+	var closers []func()
+	for _, name := range []string{*a, *b} {
+		if !configured[name] {
+			log.Fatalf("Backend %q is not configured", name)
+		}
+		backend, err := openBackend(ctx, name, appCfg, secretResolver, log)
+		if err != nil {
+			log.Fatalf("Error initializing %s backend: %v", name, err)
+		}
+		closers = append(closers, backend.Close)
+		diffed := DiffedBackend{Name: name, Source: backend.Source, Save: backend.Save}
+		if backend.Source != nil {
+			diffed.Inventory = backend.Source
+		} else {
+			diffed.Inventory = backend.Export.(SubmissionInventory)
+		}
+		backends = append(backends, diffed)
+	}
+
+	diff := &BackendDiff{A: backends[0], B: backends[1], Since: *since, Until: *until, Repair: *repair, Log: log}
+	report, err := diff.Run(ctx)
+	for _, closeBackend := range closers {
+		closeBackend()
+	}
+	if report != nil {
+		bs, _ := json.MarshalIndent(report, "", "  ")
+		if *output == "" {
+			fmt.Println(string(bs))
+		} else if err := os.WriteFile(*output, append(bs, '\n'), 0644); err != nil {
+			log.Errorf("Error writing report: %v", err)
+		}
+		for _, name := range []string{*a, *b} {
+			inventory := report.Backends[name]
+			fmt.Fprintf(os.Stderr, "%s: %d submissions, %d missing, %d repaired, %d failed\n",
+				name, inventory.Submissions, len(inventory.Missing), inventory.Repaired, len(inventory.Failed))
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "Interrupted, the report covers the days compared so far")
+	} else if err != nil {
+		log.Errorf("Comparison failed: %v", err)
+	}
+	if err != nil || report.Unrepaired() > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Write submissions of a range of days as a dataset for the uptime scoring
+func export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	backend := flags.String("backend", "", "Backend to read submissions from (s3, keyspaces, postgresql or filesystem), required if several are configured")
+	since := flags.String("since", "", "Export submissions of this day (YYYY-MM-DD) and later")
+	until := flags.String("until", "", "Export submissions of this day (YYYY-MM-DD) and earlier")
+	format := flags.String("format", "", "Format of the dataset (csv, jsonl or parquet), by extension of the output file or csv by default")
+	output := flags.String("output", "", "File to write the dataset to, standard output by default")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	log := logging.Logger("delegation backend export")
+	for _, day := range []string{*since, *until} {
+		if _, err := time.Parse("2006-01-02", day); day != "" && err != nil {
+			log.Fatalf("Invalid day %q, expected YYYY-MM-DD", day)
+		}
+	}
+	if *format == "" {
+		*format = EXPORT_FORMAT_CSV
+		if ext := strings.TrimPrefix(filepath.Ext(*output), "."); ext != "" {
+			*format = ext
+		}
+	}
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	configured := configuredBackends(appCfg)
+	name := *backend
+	if name == "" {
+		if len(configured) != 1 {
+			log.Fatalf("Several backends are configured (%s), set the one to export with -backend", strings.Join(configured, ", "))
+		}
+		name = configured[0]
+	}
+	found := false
+	for _, c := range configured {
+		found = found || c == name
+	}
+	if !found {
+		log.Fatalf("Backend %q is not configured", name)
+	}
+	if name == "keyspaces" && (*since == "" || *until == "") {
+		log.Fatal("AWS Keyspaces can't list the days stored, set both -since and -until")
+	}
+
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if *output != "" {
+		var err error
+		if file, err = os.Create(*output); err != nil {
+			log.Fatalf("Error creating %s: %v", *output, err)
+		}
+		out = file
+	}
+	w, err := NewExportWriter(*format, out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	source, err := openBackend(ctx, name, appCfg, secretResolver, log)
+	if err != nil {
+		log.Fatalf("Error initializing %s backend: %v", name, err)
+	}
+	count := 0
+	err = source.Export.Export(ctx, ReplayFilter{Since: *since, Until: *until}, func(record ExportRecord) error {
+		count++
+		return w.Write(record)
+	})
+	source.Close()
+	if err == nil {
+		err = w.Close()
+	}
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d submissions from %s\n", count, name)
+}
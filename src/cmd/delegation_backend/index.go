@@ -0,0 +1,83 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os/signal"
+	"syscall"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Build indexes of days of submissions of an object storage, as the
+// leader does when indexes are enabled
+func index(args []string) {
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	backend := flags.String("backend", "", "Backend to index (s3 or filesystem), s3 if configured by default")
+	day := flags.String("day", "", "Index this day (YYYY-MM-DD) only, days missing or with an incomplete index by default")
+	rebuild := flags.Bool("rebuild", false, "Build indexes from scratch rather than adding submissions to existing indexes, e.g. after submissions were replayed to past days")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log := logging.Logger("delegation backend index")
+	if _, err := time.Parse("2006-01-02", *day); *day != "" && err != nil {
+		log.Fatalf("Invalid day %q, expected YYYY-MM-DD", *day)
+	}
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	name := *backend
+	if name == "" {
+		if appCfg.Aws != nil {
+			name = "s3"
+		} else if appCfg.LocalFileSystem != nil {
+			name = "filesystem"
+		} else {
+			log.Fatal("Indexes require S3 or local filesystem storage")
+		}
+	}
+	if name != "s3" && name != "filesystem" {
+		log.Fatalf("Backend %q is not an object storage, set s3 or filesystem", name)
+	}
+	storage, err := openBackend(ctx, name, appCfg, secretResolver, log)
+	if err != nil {
+		log.Fatalf("Error initializing %s backend: %v", name, err)
+	}
+	defer storage.Close()
+
+	indexer := NewSubmissionIndexer(storage.Source, storage.Overwrite, time.Now, log)
+	days := []string{*day}
+	if *day == "" {
+		if days, err = storage.Source.Days(ctx); err != nil {
+			log.Fatalf("Error listing days of submissions: %v", err)
+		}
+	}
+	for _, d := range days {
+		var previous *DayIndex
+		if !*rebuild {
+			previous, err = indexer.Lookup(ctx, d)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Fatalf("Error reading index of %s: %v", d, err)
+			}
+			// Complete indexes are only updated on demand, or to count
+			// references of blocks
+			if previous != nil && previous.Complete && previous.References != nil && *day == "" {
+				continue
+			}
+		}
+		idx, err := indexer.IndexDay(ctx, d, previous)
+		if err != nil {
+			log.Fatalf("Error indexing %s: %v", d, err)
+		}
+		status := "incomplete"
+		if idx.Complete {
+			status = "complete"
+		}
+		fmt.Printf("%s: %d submissions, %d submitters, %d blocks (%s)\n", d, idx.Submissions, len(idx.Submitters), len(idx.Blocks), status)
+	}
+}
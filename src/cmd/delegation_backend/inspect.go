@@ -0,0 +1,171 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Print a request body or a stored submission, decoded
+func inspect(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: delegation_backend inspect [flags] <file, - for standard input, or path in -backend>\n")
+		flags.PrintDefaults()
+	}
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE+", with -backend")
+	backend := flags.String("backend", "", "Read the submission at the path of this backend (s3 or filesystem) along with its block")
+	blockFile := flags.String("block", "", "File of the block of a stored submission, found in the local filesystem storage of the submission by default")
+	network := flags.String("network", "mainnet", "Network name of the signature (mainnet or another network)")
+	networkId := flags.Int("network-id", -1, "Network id of the signature, of the network name by default")
+	signatureScheme := flags.String("signature-scheme", SIGNATURE_SCHEME_MINA_SCHNORR, fmt.Sprintf("Scheme of the signature, one of %v", SignatureSchemeNames()))
+	submittedAt := flags.String("submitted-at", "", "Time of submission (RFC 3339) of the paths of a request, now by default")
+	jsonOutput := flags.Bool("json", false, "Print the inspection as JSON")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	target := flags.Arg(0)
+
+	ctx := context.Background()
+	log := logging.Logger("delegation backend inspect")
+	at := time.Now()
+	if *submittedAt != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, *submittedAt); err != nil {
+			log.Fatalf("Invalid time of submission %q, expected RFC 3339", *submittedAt)
+		}
+	} else if stored, err := SubmittedAtOfPath(target); err == nil {
+		at = stored
+	}
+
+	var doc, block []byte
+	var err error
+	var readBlock func(blockHash string) ([]byte, error)
+	if *backend != "" {
+		appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+		configured := false
+		for _, name := range configuredBackends(appCfg) {
+			configured = configured || name == *backend
+		}
+		if !configured || (*backend != "s3" && *backend != "filesystem") {
+			log.Fatalf("Backend %q is not a configured object storage", *backend)
+		}
+		storage, err := openBackend(ctx, *backend, appCfg, secretResolver, log)
+		if err != nil {
+			log.Fatalf("Error initializing %s backend: %v", *backend, err)
+		}
+		defer storage.Close()
+		if doc, err = storage.Source.Read(ctx, strings.TrimPrefix(target, "/")); err != nil {
+			log.Fatalf("Error reading %s: %v", target, err)
+		}
+		readBlock = func(blockHash string) ([]byte, error) {
+			return storage.Source.Read(ctx, BLOCKS_PREFIX+blockHash+".dat")
+		}
+	} else {
+		if target == "-" {
+			doc, err = io.ReadAll(os.Stdin)
+		} else {
+			doc, err = os.ReadFile(target)
+		}
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", target, err)
+		}
+		// Blocks of the local filesystem storage are under `blocks`, next
+		// to `submissions/<day>/` of the submission
+		readBlock = func(blockHash string) ([]byte, error) {
+			return os.ReadFile(filepath.Join(filepath.Dir(target), "..", "..", "blocks", blockHash+".dat"))
+		}
+	}
+	if *blockFile != "" {
+		if block, err = os.ReadFile(*blockFile); err != nil {
+			log.Fatalf("Error reading %s: %v", *blockFile, err)
+		}
+	} else {
+		var stored struct {
+			BlockHash string `json:"block_hash"`
+		}
+		if json.Unmarshal(doc, &stored) == nil && stored.BlockHash != "" {
+			block, err = readBlock(stored.BlockHash)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Fatalf("Error reading block %s: %v", stored.BlockHash, err)
+			}
+		}
+	}
+
+	signatureNetworkId := NetworkId(*network)
+	if *networkId > 255 {
+		log.Fatalf("Invalid network id %d, expected a byte", *networkId)
+	} else if *networkId >= 0 {
+		signatureNetworkId = uint8(*networkId)
+	}
+	scheme, err := NewSignatureScheme(*signatureScheme, signatureNetworkId)
+	if err != nil {
+		log.Fatal(err)
+	}
+	in, err := Inspect(doc, block, scheme, at)
+	if err != nil {
+		log.Fatalf("Error inspecting %s: %v", target, err)
+	}
+	if *jsonOutput {
+		bs, _ := json.MarshalIndent(in, "", "  ")
+		fmt.Println(string(bs))
+		return
+	}
+	printInspection(os.Stdout, in, *network)
+}
+
+func printInspection(w io.Writer, in *Inspection, network string) {
+	field := func(name string, value interface{}) {
+		if value != "" && value != 0 {
+			fmt.Fprintf(w, "%-24s %v\n", name+":", value)
+		}
+	}
+	field("Kind", in.Kind)
+	field("Submitter", in.Submitter)
+	field("Delegate", in.Delegate)
+	field("Delegation ID", in.DelegationId)
+	field("Created at", in.CreatedAt)
+	field("Peer ID", in.PeerId)
+	field("GraphQL control port", in.GraphqlControlPort)
+	field("Built with commit", in.BuiltWithCommitSha)
+	field("Remote address", in.RemoteAddr)
+	field("Backend version", in.BackendVersion)
+	field("Snark work size", in.SnarkWorkSize)
+	field("Block size", in.BlockSize)
+	field("Block hash", in.BlockHash)
+	if in.StoredBlockHash != "" {
+		match := ""
+		if in.BlockHash != "" && in.BlockHash != in.StoredBlockHash {
+			match = " (MISMATCH with the hash of the block)"
+		}
+		field("Stored block hash", in.StoredBlockHash+match)
+	}
+	field("Signature", in.Signature)
+	validity := "unknown"
+	if in.SignatureValid != nil {
+		validity = "INVALID"
+		if *in.SignatureValid {
+			validity = "valid"
+		}
+	}
+	validity += fmt.Sprintf(" on %s (%s)", network, in.SignatureScheme)
+	if in.SignatureNote != "" {
+		validity += ", " + in.SignatureNote
+	}
+	field("Signature validity", validity)
+	field("Submission path", in.MetaPath)
+	field("Block path", in.BlockPath)
+}
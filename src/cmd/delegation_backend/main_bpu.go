@@ -3,32 +3,81 @@ package main
 import (
 	. "block_producers_uptime/delegation_backend"
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	logging "github.com/ipfs/go-log/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 	"google.golang.org/api/option"
 	sheets "google.golang.org/api/sheets/v4"
 )
 
-func main() {
-	// Setup logging
-	logging.SetupLogging(logging.Config{
-		Format: logging.JSONOutput,
-		Stderr: true,
-		Stdout: false,
-		Level:  logging.LevelDebug,
-		File:   "",
-	})
+// Run the server, the default command
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	listenTo := flags.String("listen", "", "Address to listen to (host:port, port, unix:<path> or systemd[:<name>]), overrides LISTEN_TO")
+	validate := flags.Bool("validate-config", false, "Run the validate-config command instead, kept for compatibility")
+	bootstrapStorage := flags.Bool("bootstrap-storage", false, "Create the S3 bucket, AWS Keyspaces keyspace and tables, and PostgreSQL schema if missing")
+	migrationDir := flags.String("migrations-dir", DATABASE_MIGRATION_DIR, "Directory of the AWS Keyspaces migrations applied by -bootstrap-storage")
+	dev := flags.Bool("dev", false, "Run standalone for development: storage in memory, static whitelist, fake clock and signatures of test vectors or Ed25519")
+	devWhitelist := flags.String("dev-whitelist", "", "Comma-separated public keys whitelisted by -dev along with the submitters of the test vectors")
+	flags.Parse(args)
+
 	log := logging.Logger("delegation backend")
-	log.Infof("delegation backend has the following logging subsystems active: %v", logging.GetSubsystems())
 
 	// Context and app initialization
 	ctx := context.Background()
-	appCfg := LoadEnv(log)
+	if *validate {
+		os.Exit(checkConfig(ctx, *configFile, log))
+	}
+	var appCfg AppConfig
+	if *dev {
+		appCfg = LoadDevConfig(*configFile, log)
+	} else {
+		appCfg = LoadConfig(*configFile, log)
+	}
+	if *listenTo != "" {
+		appCfg.ListenTo = *listenTo
+	}
+	reloader := NewConfigReloader(*configFile, appCfg, time.Now, log)
+
+	// Setup logging, once its configuration is loaded
+	logLevels, err := ConfigureLogging(appCfg.Logging)
+	if err != nil {
+		log.Fatalf("Error configuring logging: %v", err)
+	}
+	log.Infof("Starting delegation backend %s", GetBuildInfo())
+	log.Infof("delegation backend has the following logging subsystems active: %v, levels: %+v", logging.GetSubsystems(), logLevels.Status())
+	reloader.OnReload(func(cfg AppConfig) error { return logLevels.Apply(cfg.Logging) })
+	secretResolver, err := NewSecretResolver(ctx, appCfg.Secrets)
+	if err != nil {
+		log.Fatalf("Error configuring secrets: %v", err)
+	}
+	if err := ResolveSecrets(&appCfg, secretResolver); err != nil {
+		log.Fatalf("Error resolving secrets: %v", err)
+	}
+	// Secrets which are re-fetched periodically if refresh is configured
+	rotatingSecrets := make(map[string]*Secret)
+	adminToken, err := NewSecret(secretResolver, appCfg.AdminToken)
+	if err != nil {
+		log.Fatalf("Error resolving admin token: %v", err)
+	}
+	rotatingSecrets["admin_token"] = adminToken
 	app := new(App)
 	app.IsReady = false
 	app.Log = log
@@ -39,7 +88,187 @@ func main() {
 	if app.VerifySignatureDisabled {
 		log.Warnf("Signature verification is disabled, it is not recommended to run the delegation backend in this mode!")
 	}
-	app.NetworkId = NetworkId(appCfg.NetworkName)
+	app.SignatureScheme, err = appCfg.Signature.Scheme(appCfg.NetworkName)
+	if err != nil {
+		log.Fatalf("Error configuring the signature scheme: %v", err)
+	}
+	log.Infof("Signatures of %s are verified with %s", appCfg.NetworkName, app.SignatureScheme)
+	if appCfg.SignPayloadVersions != nil {
+		app.SignPayloadVersions = appCfg.SignPayloadVersions
+		log.Infof("Sign payloads of versions %v are accepted", app.SignPayloadVersions)
+	}
+	clientIPResolver, err := NewClientIPResolver(appCfg.TrustedProxyCIDRs, appCfg.ClientIPHeader)
+	if err != nil {
+		log.Fatalf("Error configuring trusted proxies: %v", err)
+	}
+	app.ClientIPResolver = clientIPResolver
+	if len(appCfg.TrustedProxyCIDRs) > 0 {
+		log.Infof("Trusted proxy networks: %v", appCfg.TrustedProxyCIDRs)
+	}
+
+	// Tracing, spans are exported only when an OTLP endpoint is configured
+	if appCfg.Tracing != nil {
+		tracerProvider, err := NewOTLPTracerProvider(appCfg.Tracing, log)
+		if err != nil {
+			log.Fatalf("Error configuring tracing: %v", err)
+		}
+		defer tracerProvider.Shutdown(ctx)
+		otel.SetTracerProvider(tracerProvider)
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		log.Infof("Tracing enabled, exporting to %s", appCfg.Tracing.OtlpEndpoint)
+	}
+
+	// Error reporting of panics, storage failure bursts and whitelist refresh failures
+	var storageFailureReporters MultiReporter
+	var errorReporter *SentryReporter
+	storageFailureThreshold := STORAGE_FAILURE_BURST_THRESHOLD
+	if appCfg.ErrorReporting != nil {
+		var err error
+		errorReporter, err = NewSentryReporter(appCfg.ErrorReporting)
+		if err != nil {
+			log.Fatalf("Error configuring error reporting: %v", err)
+		}
+		app.ErrorReporter = errorReporter
+		storageFailureReporters = append(storageFailureReporters, errorReporter)
+		if appCfg.ErrorReporting.StorageFailureThreshold > 0 {
+			storageFailureThreshold = appCfg.ErrorReporting.StorageFailureThreshold
+		}
+		log.Infof("Error reporting to Sentry enabled, environment: %s", appCfg.ErrorReporting.Environment)
+	}
+
+	// Alerts of operational events to Slack, Discord or a webhook
+	var notifier *Notifier
+	if appCfg.Alerting != nil {
+		notifier, err = NewNotifierFromConfig(appCfg.Alerting, log)
+		if err != nil {
+			log.Fatalf("Error configuring alerting: %v", err)
+		}
+		go notifier.Run(ctx)
+		storageFailureReporters = append(storageFailureReporters, notifier)
+		idle := ALERT_DEFAULT_SUBMISSIONS_IDLE
+		if appCfg.Alerting.SubmissionsIdleMinutes > 0 {
+			idle = time.Duration(appCfg.Alerting.SubmissionsIdleMinutes) * time.Minute
+		}
+		app.SubmissionWatch = NewIdleWatch(notifier, idle, time.Now)
+		go app.SubmissionWatch.CheckLoop(time.Minute)
+		log.Infof("Alerting enabled")
+	}
+	// Webhooks of downstream services, fired on events of the backend
+	if appCfg.Webhooks != nil {
+		app.Webhooks = NewWebhooksFromConfig(appCfg.Webhooks, appCfg.NetworkName, time.Now, log)
+		go app.Webhooks.Run(ctx)
+		storageFailureReporters = append(storageFailureReporters, app.Webhooks)
+		log.Infof("Webhooks enabled, endpoints: %d", len(appCfg.Webhooks.Endpoints))
+	}
+	if len(storageFailureReporters) > 0 {
+		app.StorageFailureMonitor = NewStorageFailureMonitor(storageFailureReporters, storageFailureThreshold, STORAGE_FAILURE_BURST_WINDOW, time.Now)
+	}
+
+	// Rolling latency SLO of every endpoint, logged hourly
+	sloTracker := NewSLOTrackerFromConfig(appCfg.SLO, time.Now)
+	go sloTracker.ReportLoop(log, SLO_REPORT_INTERVAL)
+
+	var rootHandler http.Handler = sloTracker.Middleware(http.DefaultServeMux)
+
+	// Load shedding, evaluated once the network filter admitted the request
+	if shedding := appCfg.LoadShedding; shedding != nil {
+		maxInFlight := LOAD_SHEDDING_DEFAULT_MAX_IN_FLIGHT
+		if shedding.MaxInFlight > 0 {
+			maxInFlight = shedding.MaxInFlight
+		}
+		app.LoadShedder = NewLoadShedder(maxInFlight, time.Now)
+		app.LoadShedder.MaxQueueDepth = shedding.MaxQueueDepth
+		app.LoadShedder.MaxHeapBytes = uint64(shedding.MaxHeapMB) << 20
+		app.LoadShedder.ClientIPResolver = clientIPResolver
+		app.LoadShedder.ExemptPaths = map[string]bool{"/health": true, "/readyz": true, "/metrics": true}
+		go app.LoadShedder.SampleLoop(ctx, LOAD_SHEDDING_SAMPLE_INTERVAL)
+		rootHandler = app.LoadShedder.Middleware(rootHandler)
+		log.Infof("Load shedding enabled, max requests in flight: %d", maxInFlight)
+	}
+
+	// Network allow/deny lists, evaluated before anything else
+	if len(appCfg.IPAllowlist) > 0 || len(appCfg.IPDenylist) > 0 {
+		networkFilter, err := NewNetworkFilter(appCfg.IPAllowlist, appCfg.IPDenylist)
+		if err != nil {
+			log.Fatalf("Error configuring network filter: %v", err)
+		}
+		networkFilter.ClientIPResolver = clientIPResolver
+		networkFilter.ExemptPaths = map[string]bool{"/health": true, "/readyz": true}
+		rootHandler = networkFilter.Middleware(rootHandler)
+		log.Infof("Network filter enabled, allowlist: %v, denylist: %v", appCfg.IPAllowlist, appCfg.IPDenylist)
+	}
+
+	// Access log of all requests, separate from application logs
+	if appCfg.AccessLog != nil {
+		accessLog, err := NewAccessLogFromConfig(appCfg.AccessLog, time.Now)
+		if err != nil {
+			log.Fatalf("Error configuring access log: %v", err)
+		}
+		accessLog.ClientIPResolver = clientIPResolver
+		rootHandler = accessLog.Middleware(rootHandler)
+		log.Infof("Access log enabled, format: %s", accessLog.Format())
+	}
+
+	serverTimeouts := appCfg.Server.Timeouts()
+	app.BodyReadTimeout = serverTimeouts.BodyRead
+	app.SubmitTimeout = serverTimeouts.Submit
+	log.Infof("HTTP server timeouts: %+v", serverTimeouts)
+	// Connections are limited across the plain and TLS listeners
+	var connLimiter *ConnLimiter
+	if srv := appCfg.Server; srv != nil && (srv.MaxConnections > 0 || srv.MaxConnectionsPerIP > 0) {
+		connLimiter = NewConnLimiter(srv.MaxConnections, srv.MaxConnectionsPerIP)
+		log.Infof("Connections limited to %d, %d per client IP (0 is no limit)", srv.MaxConnections, srv.MaxConnectionsPerIP)
+	}
+
+	// TLS setup, the HTTPS listener is started alongside the plain one
+	var tlsServer *http.Server
+	var tlsListener net.Listener
+	// Certificate files are reloaded until shutdown
+	tlsCtx, stopTLSReload := context.WithCancel(ctx)
+	if appCfg.TLS != nil {
+		tlsCfg, err := NewServerTLSConfig(tlsCtx, appCfg.TLS, log)
+		if err != nil {
+			log.Fatalf("Error configuring TLS: %v", err)
+		}
+		tlsListenTo := appCfg.TLS.ListenTo
+		if tlsListenTo == "" {
+			tlsListenTo = DELEGATION_BACKEND_TLS_LISTEN_TO
+		}
+		tlsListener, err = Listen(tlsListenTo)
+		if err != nil {
+			log.Fatalf("Error listening for TLS connections on %s: %v", tlsListenTo, err)
+		}
+		if connLimiter != nil {
+			tlsListener = connLimiter.Listener(tlsListener)
+		}
+		tlsServer = NewHTTPServer(tlsListenTo, rootHandler, serverTimeouts)
+		tlsServer.TLSConfig = tlsCfg
+		if err := appCfg.Server.Apply(tlsServer, false); err != nil {
+			log.Fatalf("Error configuring HTTP/2: %v", err)
+		}
+		if appCfg.TLS.ClientCAFile != "" {
+			app.ClientCertAuth, err = LoadClientCertAuth(appCfg.TLS.ClientCertMap)
+			if err != nil {
+				log.Fatalf("Error loading client certificate map: %v", err)
+			}
+			log.Infof("Mutual TLS enabled, submissions require a client certificate")
+		}
+	}
+
+	// Feature flags of risky behaviors, reloaded with the config
+	featureFlags, err := NewFeatureFlags(appCfg.NetworkName, appCfg.FeatureFlags)
+	if err != nil {
+		log.Fatalf("Error configuring feature flags: %v", err)
+	}
+	app.FeatureFlags = featureFlags
+	reloader.OnReload(func(cfg AppConfig) error {
+		return featureFlags.SetConfig(cfg.FeatureFlags)
+	})
+	for _, status := range featureFlags.Status() {
+		if status.Source != "default" {
+			log.Infof("Feature flag %s: enabled %v, rollout %d%%", status.Name, status.Enabled, status.RolloutPercent)
+		}
+	}
 
 	// Storage backend setup
 	if appCfg.Aws != nil {
@@ -49,17 +278,42 @@ func main() {
 			log.Fatalf("Error loading AWS configuration: %v", err)
 		}
 		client := s3.NewFromConfig(awsCfg, S3OptionsFromEnv)
-		awsctx = AwsContext{Client: client, BucketName: aws.String(GetAWSBucketName(appCfg)), Prefix: appCfg.NetworkName, Context: ctx, Log: log}
+		if *bootstrapStorage {
+			if _, err := BootstrapS3Bucket(ctx, client, GetAWSBucketName(appCfg), appCfg.Aws.Region, log); err != nil {
+				log.Fatalf("Error bootstrapping S3 bucket: %v", err)
+			}
+		}
+		awsctx = AwsContext{Client: client, BucketName: aws.String(GetAWSBucketName(appCfg)), Prefix: appCfg.NetworkName, Context: ctx, Log: log, Flags: featureFlags}
 
 	}
+	// Events of accepted submissions of the network to SNS or SQS
+	if eventsCfg := appCfg.SubmissionEvents[appCfg.NetworkName]; eventsCfg != nil {
+		bucket := ""
+		if appCfg.Aws != nil {
+			bucket = GetAWSBucketName(appCfg)
+		}
+		app.SubmissionEvents, err = NewSubmissionEventsFromConfig(ctx, eventsCfg, appCfg.NetworkName, bucket, log)
+		if err != nil {
+			log.Fatalf("Error configuring submission events: %v", err)
+		}
+		go app.SubmissionEvents.Run(ctx)
+		log.Infof("Events of accepted submissions published to SNS topic %q, SQS queue %q", eventsCfg.SNSTopicArn, eventsCfg.SQSQueueURL)
+	}
 
 	if appCfg.AwsKeyspaces != nil {
 		log.Infof("storage backend: AWS Keyspaces")
-		session, err := InitializeKeyspaceSession(appCfg.AwsKeyspaces)
+		if *bootstrapStorage {
+			if err := BootstrapKeyspace(appCfg.AwsKeyspaces, *migrationDir, log); err != nil {
+				log.Fatalf("Error bootstrapping AWS Keyspaces: %v", err)
+			}
+		}
+		session, err := NewKeyspaceSession(appCfg.AwsKeyspaces, log)
 		if err != nil {
 			log.Fatalf("Error initializing Keyspace session: %v", err)
 		}
 		defer session.Close()
+		// Stale sessions are re-established, and credentials refreshed before they expire
+		go session.CheckLoop(ctx, KEYSPACES_PROBE_INTERVAL)
 
 		kc = KeyspaceContext{
 			Session:  session,
@@ -74,90 +328,930 @@ func main() {
 		log.Infof("storage backend: Local File System")
 	}
 
+	var memoryStorage *MemoryStorage
+	if appCfg.MemoryStorage {
+		memoryStorage = NewMemoryStorage()
+		log.Warnf("storage backend: memory, submissions are lost on restart")
+	}
+
 	if appCfg.PostgreSQL != nil {
 		log.Infof("storage backend: PostgreSQL")
-		db, err := NewPostgreSQL(appCfg.PostgreSQL)
+		postgresPassword, err := NewSecret(secretResolver, appCfg.PostgreSQL.Password)
+		if err != nil {
+			log.Fatalf("Error resolving PostgreSQL password: %v", err)
+		}
+		rotatingSecrets["postgresql.password"] = postgresPassword
+		db, err := NewPostgreSQLWithSecret(appCfg.PostgreSQL, postgresPassword)
 		if err != nil {
 			log.Fatalf("Error initializing PostgreSQL: %v", err)
 		}
 		defer db.Close()
+		// Bootstrapping creates the schema also when migrations are skipped
+		if !appCfg.PostgreSQL.SkipMigrations || *bootstrapStorage {
+			version, err := PostgreSQLMigrationUp(db)
+			if err != nil {
+				log.Fatalf("Error migrating PostgreSQL schema: %v", err)
+			}
+			log.Infof("PostgreSQL schema at version %d", version)
+		}
 
 		pctx = PostgreSQLContext{
 			DB:  db,
 			Log: log,
 		}
+		if err := pctx.Prepare(); err != nil {
+			log.Fatalf("Error preparing PostgreSQL statements: %v", err)
+		}
+	}
+
+	var natsPublisher *NATSPublisher
+	if appCfg.NATS != nil {
+		natsPublisher, err = NewNATSPublisher(appCfg.NATS, appCfg.NetworkName)
+		if err != nil {
+			log.Fatalf("Error configuring NATS: %v", err)
+		}
+		if err := natsPublisher.EnsureStream(); err != nil {
+			log.Fatalf("Error configuring NATS JetStream stream %s: %v", natsPublisher.Stream, err)
+		}
+		defer natsPublisher.Close()
+		log.Infof("publishing backend: NATS JetStream, stream %s, subjects %s.>", natsPublisher.Stream, natsPublisher.SubjectPrefix)
+	}
+
+	// Submissions saved concurrently are written in batches, pending
+	// batches are flushed on shutdown
+	var batchers []*WriteBatcher
+	if batching := appCfg.WriteBatching; batching != nil {
+		size, interval := WRITE_BATCH_DEFAULT_SIZE, WRITE_BATCH_DEFAULT_INTERVAL
+		if batching.Size > 0 {
+			size = batching.Size
+		}
+		if batching.IntervalMs > 0 {
+			interval = time.Duration(batching.IntervalMs) * time.Millisecond
+		}
+		if appCfg.AwsKeyspaces != nil {
+			kc.Batcher = NewWriteBatcher(min(size, KEYSPACES_MAX_BATCH_SIZE), interval, kc.InsertSubmissions)
+			batchers = append(batchers, kc.Batcher)
+		}
+		if appCfg.PostgreSQL != nil {
+			pctx.Batcher = NewWriteBatcher(size, interval, pctx.InsertSubmissions)
+			batchers = append(batchers, pctx.Batcher)
+		}
+		log.Infof("Write batching enabled, up to %d submissions every %v", size, interval)
+	}
+	// Draining, on /admin/drain or before shutting down, flushes pending
+	// batches and audit records
+	drain := NewDrain(time.Now, log)
+	drain.OnDrain(func() {
+		for _, batcher := range batchers {
+			batcher.Flush()
+		}
+		for _, auditLog := range app.AuditLogs {
+			auditLog.Flush()
+		}
+	})
+
+	var faults *FaultInjector
+	if appCfg.FaultInjection != nil {
+		faults = NewFaultInjector(appCfg.FaultInjection, log)
+		log.Warnf("Fault injection enabled, for tests only: %s", faults)
 	}
 
-	app.Save = func(objs ObjectsToSave) {
+	app.Save = func(ctx context.Context, objs ObjectsToSave) StorageOutcomes {
+		// Backends are bound to the context of the request, so that its
+		// deadline bounds the save
+		backends := make(map[string]func(context.Context, ObjectsToSave) error)
 		if appCfg.Aws != nil {
-			awsctx.S3Save(objs)
+			backends["s3"] = func(ctx context.Context, objs ObjectsToSave) error {
+				return awsctx.WithContext(ctx).S3SaveVersioned(objs, ObjectVersionRecorder(ctx, "s3"))
+			}
 		}
 		if appCfg.AwsKeyspaces != nil {
-			kc.KeyspaceSave(objs)
+			backends["keyspaces"] = func(ctx context.Context, objs ObjectsToSave) error {
+				return kc.WithContext(ctx).KeyspaceSave(objs)
+			}
 		}
 		if appCfg.PostgreSQL != nil {
-			pctx.PostgreSQLSave(objs)
+			backends["postgresql"] = func(ctx context.Context, objs ObjectsToSave) error {
+				return pctx.WithContext(ctx).PostgreSQLSave(objs)
+			}
 		}
 		if appCfg.LocalFileSystem != nil {
-			LocalFileSystemSave(objs, appCfg.LocalFileSystem.Path, log)
+			backends["filesystem"] = func(_ context.Context, objs ObjectsToSave) error {
+				return LocalFileSystemSave(objs, appCfg.LocalFileSystem.Path, log)
+			}
+		}
+		if natsPublisher != nil {
+			backends["nats"] = natsPublisher.NATSSaveContext
+		}
+		if memoryStorage != nil {
+			backends["memory"] = func(_ context.Context, objs ObjectsToSave) error {
+				return memoryStorage.Save(objs)
+			}
+		}
+		if faults != nil {
+			for backend, save := range backends {
+				backends[backend] = faults.WrapSave(backend, save)
+			}
+		}
+		// NATS publishes asynchronously and memory storage keeps the objects
+		retained := natsPublisher != nil || memoryStorage != nil
+		return SaveToBackends(ctx, objs, backends, featureFlags.Enabled(FEATURE_PARALLEL_SAVE), retained)
+	}
+	// Anomaly findings and audit records are saved only to object
+	// storages, databases hold submissions only. Objects are saved to
+	// every storage, the last error encountered is returned.
+	saveObjects := func(objs ObjectsToSave) error {
+		var saveErr error
+		if appCfg.Aws != nil {
+			if err := awsctx.S3Save(objs); err != nil {
+				saveErr = fmt.Errorf("s3: %w", err)
+			}
+		}
+		if appCfg.LocalFileSystem != nil {
+			if err := LocalFileSystemSave(objs, appCfg.LocalFileSystem.Path, log); err != nil {
+				saveErr = fmt.Errorf("filesystem: %w", err)
+			}
+		}
+		if memoryStorage != nil {
+			if err := memoryStorage.Save(objs); err != nil {
+				saveErr = fmt.Errorf("memory: %w", err)
+			}
+		}
+		return saveErr
+	}
+	// Background records aren't retried, failures are logged
+	saveRecords := func(objs ObjectsToSave) {
+		if err := saveObjects(objs); err != nil {
+			log.Errorf("Error saving %d objects to storage: %v", len(objs), err)
 		}
 	}
 
-	if appCfg.Aws == nil && appCfg.LocalFileSystem == nil && appCfg.AwsKeyspaces == nil {
+	if appCfg.Aws == nil && appCfg.LocalFileSystem == nil && appCfg.AwsKeyspaces == nil && appCfg.PostgreSQL == nil && memoryStorage == nil {
 		log.Fatal("No storage backend configured!")
 	}
 
 	// App other configurations
 	app.Now = func() time.Time { return time.Now() }
-	requestsPerPkHourly := SetRequestsPerPkHourly(log)
-	app.SubmitCounter = NewAttemptCounter(requestsPerPkHourly)
-	log.Infof("Max requests per pk hourly: %v", requestsPerPkHourly)
+	var devClock *DevClock
+	if *dev {
+		start, _ := time.Parse(time.RFC3339, DEV_CLOCK_START)
+		devClock = NewDevClock(start, time.Now)
+		app.Now = devClock.Now
+		log.Warnf("Running in dev mode, the clock starts at %s", DEV_CLOCK_START)
+	}
+	if faults != nil {
+		app.Now = faults.Now(app.Now)
+	}
+	app.SubmitCounter = NewAttemptCounter(appCfg.RequestsPerPkHourly())
+	log.Infof("Max requests per pk hourly: %v", appCfg.RequestsPerPkHourly())
+	verifyWorkers, verifyQueueSize := appCfg.SignatureVerify.Workers, appCfg.SignatureVerify.QueueSize
+	app.VerifyPool = NewVerifyPool(verifyWorkers, verifyQueueSize)
+	log.Infof("Signature verification workers: %v, queue size: %v", verifyWorkers, verifyQueueSize)
+	if app.LoadShedder != nil {
+		app.LoadShedder.QueueDepth = app.VerifyPool.QueueLength
+		if app.LoadShedder.MaxQueueDepth == 0 {
+			app.LoadShedder.MaxQueueDepth = verifyQueueSize
+		}
+	}
+	if verifyCacheTTL := time.Duration(*appCfg.SignatureVerify.CacheTTLSeconds) * time.Second; verifyCacheTTL > 0 {
+		app.VerifyCache = NewVerifyCache(verifyCacheTTL, VERIFY_CACHE_MAX_ENTRIES)
+		log.Infof("Signature verification results are cached for %v", verifyCacheTTL)
+	}
+	if idempotencyKeyTTL := time.Duration(*appCfg.IdempotencyKeyTTLSeconds) * time.Second; idempotencyKeyTTL > 0 {
+		app.Idempotency = NewIdempotencyStore(idempotencyKeyTTL, IDEMPOTENCY_KEY_MAX_ENTRIES)
+		log.Infof("Responses to submissions with an idempotency key are repeated to retries for %v", idempotencyKeyTTL)
+	}
+	app.SubmitSizes = NewSubmitSizes(appCfg.SubmitSize.Limits(appCfg.NetworkName), log)
+	log.Infof("Max size of submissions: %+v", app.SubmitSizes.Limits())
+	reloader.OnReload(func(cfg AppConfig) error {
+		return app.SubmitSizes.SetLimits(cfg.SubmitSize.Limits(cfg.NetworkName))
+	})
+	if validation := appCfg.BlockValidation[appCfg.NetworkName]; validation != nil {
+		app.BlockValidator, err = NewBlockValidator(validation)
+		if err != nil {
+			log.Fatalf("Error configuring block validation: %v", err)
+		}
+		log.Infof("Blocks are validated as %s blocks of %s, report only: %v", validation.Format, appCfg.NetworkName, validation.ReportOnly)
+	}
+	if timing := appCfg.NetworkTiming[appCfg.NetworkName]; timing != nil {
+		app.NetworkTiming, err = NewNetworkTiming(timing)
+		if err != nil {
+			log.Fatalf("Error configuring network timing: %v", err)
+		}
+		log.Infof("Global slots of submissions are recorded, genesis of %s at %s", appCfg.NetworkName, timing.GenesisTimestamp)
+	}
+	if appCfg.SubmissionWindow != nil {
+		app.SubmissionWindows, err = NewSubmissionWindows(appCfg.SubmissionWindow, app.NetworkTiming)
+		if err != nil {
+			log.Fatalf("Error configuring submission windows: %v", err)
+		}
+		log.Infof("Submitters are credited once per window of %d slots, extras are handled with %s", app.SubmissionWindows.Slots, app.SubmissionWindows.Mode)
+	}
+	app.CreatedAtMaxFuture = appCfg.ClockSkew.MaxFuture(appCfg.NetworkName)
+	log.Infof("Max time created_at may be ahead of the clock: %v", app.CreatedAtMaxFuture)
+	// Skew of the clock of the host, checked at start
+	var clockCheck *ClockCheck
+	if appCfg.ClockCheck != nil {
+		clockCheck = NewClockCheck(appCfg.ClockCheck, time.Now, log)
+		go clockCheck.CheckLoop(ctx, appCfg.ClockCheck.Interval())
+	}
+	if appCfg.ClockGuard != nil {
+		app.ClockGuard, err = NewClockGuard(appCfg.ClockGuard, app.Now, clockCheck, log)
+		if err != nil {
+			log.Fatalf("Error configuring the clock guard: %v", err)
+		}
+		app.Now = app.ClockGuard.Now
+		log.Infof("Clock is suspect for %v once it jumps by more than %v, submissions are handled with %s", app.ClockGuard.Settle, app.ClockGuard.MaxJump, app.ClockGuard.Action)
+	}
+	app.CreatedAtMaxAge = time.Duration(appCfg.CreatedAtMaxAgeMinutes) * time.Minute
+	if app.CreatedAtMaxAge > 0 {
+		log.Infof("Max age of created_at: %v", app.CreatedAtMaxAge)
+	}
+	app.DelegationMaxTTL = time.Duration(appCfg.DelegationMaxTTLMinutes) * time.Minute
+	if app.DelegationMaxTTL > 0 {
+		log.Infof("Delegated submissions accepted, max delegation lifetime: %v", app.DelegationMaxTTL)
+	}
+	if appCfg.LivenessCheck != nil {
+		app.LivenessChecker = NewLivenessChecker(appCfg.LivenessCheck, app.Now)
+		log.Infof("Nodes of submitters checked in their GraphQL API, timeout: %v", appCfg.LivenessCheck.Timeout())
+	}
+	requestsPerPkHourlyOverrides, err := appCfg.RateLimit.PkOverrides()
+	if err != nil {
+		log.Fatalf("Error configuring rate limit overrides: %v", err)
+	}
+	app.SubmitCounter.SetOverrides(requestsPerPkHourlyOverrides)
+	for pk, limit := range requestsPerPkHourlyOverrides {
+		log.Infof("Max requests per pk hourly for %s: %v", pk, limit)
+	}
+	reloader.OnReload(func(cfg AppConfig) error {
+		overrides, err := cfg.RateLimit.PkOverrides()
+		if err != nil {
+			return err
+		}
+		app.SubmitCounter.SetMaxAttempt(cfg.RequestsPerPkHourly())
+		app.SubmitCounter.SetOverrides(overrides)
+		return nil
+	})
+
+	lockout := appCfg.SignatureLockout
+	if lockoutThreshold := *lockout.Threshold; lockoutThreshold > 0 {
+		lockoutBase, lockoutMax := time.Duration(lockout.BaseSeconds)*time.Second, time.Duration(lockout.MaxSeconds)*time.Second
+		app.SignatureLockout = NewSignatureLockout(lockoutThreshold, lockoutBase, lockoutMax, app.Now)
+		log.Infof("Lockout after %d invalid signatures, for %v up to %v", lockoutThreshold, lockoutBase, lockoutMax)
+	}
+
+	// State persisted periodically is persisted a last time on shutdown,
+	// once its loops are stopped
+	persistCtx, stopPersisting := context.WithCancel(ctx)
+	var persisting sync.WaitGroup
+	if appCfg.ReplayProtection != nil {
+		replayGuard, err := NewReplayGuard(appCfg.ReplayProtection.StateFile)
+		if err != nil {
+			log.Fatalf("Error initializing replay protection: %v", err)
+		}
+		app.ReplayGuard = replayGuard
+		persisting.Add(1)
+		go func() {
+			defer persisting.Done()
+			replayGuard.PersistLoop(persistCtx, REPLAY_STATE_PERSIST_INTERVAL, log)
+		}()
+		log.Infof("Replay protection enabled, state file: %s", appCfg.ReplayProtection.StateFile)
+	}
+
+	// State shared by replicas, so that they behave as a single service
+	var sharedState SharedState
+	if shared := appCfg.SharedState; shared != nil {
+		prefix := appCfg.NetworkName + ":"
+		switch shared.Backend {
+		case SHARED_STATE_POSTGRESQL:
+			pgState := &PostgreSQLSharedState{DB: pctx.DB, Prefix: prefix}
+			if err := pgState.CreateTableIfNotExists(); err != nil {
+				log.Fatalf("Error creating shared state tables: %v", err)
+			}
+			go pgState.PruneLoop(ctx, SHARED_STATE_PRUNE_INTERVAL, log)
+			sharedState = pgState
+		case SHARED_STATE_REDIS:
+			sharedState = NewRedisSharedState(shared.RedisAddr, shared.RedisPassword, shared.RedisDB, prefix)
+		}
+		app.SubmitCounter.SetSharedState(sharedState)
+		if app.ReplayGuard != nil {
+			app.ReplayGuard.SetSharedState(sharedState)
+		}
+		if app.SubmissionWindows != nil {
+			app.SubmissionWindows.SetSharedState(sharedState)
+		}
+		if app.Idempotency != nil {
+			app.Idempotency.SetSharedState(sharedState)
+		}
+		awsctx.Shared = sharedState
+		log.Infof("State shared by replicas kept in %s", shared.Backend)
+	}
+
+	if appCfg.Receipts != nil {
+		receiptKey, err := LoadReceiptSigningKey(appCfg.Receipts.SigningKeyFile)
+		if err != nil {
+			log.Fatalf("Error loading receipt signing key: %v", err)
+		}
+		app.ReceiptSigner = NewReceiptSigner(receiptKey, appCfg.Receipts.SigningKeyId)
+		log.Infof("Signed receipts enabled, key id: %s", appCfg.Receipts.SigningKeyId)
+	}
+
+	if appCfg.AnomalyDetection != nil {
+		anomalyMonitor, err := NewAnomalyMonitorFromConfig(appCfg.AnomalyDetection, saveRecords, log)
+		if err != nil {
+			log.Fatalf("Error configuring anomaly detection: %v", err)
+		}
+		app.AnomalyMonitor = anomalyMonitor
+		log.Infof("Anomaly detection enabled with %d detectors", anomalyMonitor.Len())
+	}
+
+	if appCfg.Audit != nil {
+		if appCfg.Audit.File != "" {
+			auditLog, err := NewFileAuditLog(appCfg.Audit.File, log)
+			if err != nil {
+				log.Fatalf("Error opening audit log file: %v", err)
+			}
+			app.AuditLogs = append(app.AuditLogs, auditLog)
+			go auditLog.FlushLoop(AUDIT_FILE_FLUSH_INTERVAL)
+			log.Infof("Audit log written to %s", appCfg.Audit.File)
+		}
+		if appCfg.Audit.Storage {
+			auditLog := NewStorageAuditLog(saveRecords, app.Now, log)
+			app.AuditLogs = append(app.AuditLogs, auditLog)
+			go auditLog.FlushLoop(AUDIT_STORAGE_FLUSH_INTERVAL)
+			log.Infof("Audit log saved to storage under %s", AUDIT_PREFIX)
+		}
+	}
+
+	// Per-submitter statistics, persisted if configured
+	statsCfg := appCfg.SubmitterStats
+	if statsCfg == nil {
+		statsCfg = &SubmitterStatsConfig{}
+	}
+	submitterStats, err := NewSubmitterStats(statsCfg.StateFile, app.Now)
+	if err != nil {
+		log.Fatalf("Error initializing submitter statistics: %v", err)
+	}
+	submitterStats.SetWindowDays(appCfg.StatsWindowDays())
+	reloader.OnReload(func(cfg AppConfig) error {
+		submitterStats.SetWindowDays(cfg.StatsWindowDays())
+		return nil
+	})
+	app.SubmitterStats = submitterStats
+	var saveStats func(ObjectsToSave)
+	if statsCfg.Storage {
+		saveStats = saveRecords
+		log.Infof("Submitter statistics saved to storage under %s", STATS_PREFIX)
+	}
+	persisting.Add(1)
+	go func() {
+		defer persisting.Done()
+		submitterStats.PersistLoop(persistCtx, STATS_SNAPSHOT_INTERVAL, saveStats, log)
+	}()
+
+	// Leader election among replicas, the leader runs singleton background
+	// jobs while all replicas serve submissions
+	var election *LeaderElection
+	if appCfg.LeaderElection != nil {
+		lockId := appCfg.LeaderElection.LockId
+		if lockId == 0 {
+			lockId = LEADER_ELECTION_DEFAULT_LOCK_ID
+		}
+		election = NewLeaderElection(&PostgreSQLLeaderLock{DB: pctx.DB, LockId: lockId}, time.Now, log)
+		election.Check(ctx)
+		go election.RunLoop(ctx, LEADER_ELECTION_CHECK_INTERVAL)
+		log.Infof("Leader election enabled, lock id: %d, leader: %v", lockId, election.IsLeader())
+	}
+
+	// End-of-day ingestion summaries, saved to object storages only
+	if appCfg.DailyReports != nil {
+		if appCfg.Aws == nil && appCfg.LocalFileSystem == nil {
+			log.Fatal("Daily reports require S3 or local filesystem storage")
+		}
+		dailyReports, err := NewDailyReports(appCfg.NetworkName, appCfg.DailyReports.StateFile, app.Now)
+		if err != nil {
+			log.Fatalf("Error initializing daily reports: %v", err)
+		}
+		app.DailyReports = dailyReports
+		// Every replica counts its own submissions, reports of the leader are saved
+		saveReports := func(objs ObjectsToSave) {
+			if election.IsLeader() {
+				saveRecords(objs)
+			}
+		}
+		go dailyReports.WriteLoop(REPORTS_CHECK_INTERVAL, saveReports, log)
+		log.Infof("Daily reports saved to storage under %s", REPORTS_PREFIX)
+	}
 
 	// HTTP handlers setup
 	http.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
 		_, _ = rw.Write([]byte("delegation backend service"))
 	})
-	http.Handle("/v1/submit", app.NewSubmitH())
+	submitH := app.NewSubmitH()
+	http.Handle("/v1/submit", submitH)
+	http.Handle("/v2/submit", submitH.WithSchema(SUBMIT_SCHEMA_V2))
+	// Submissions too large for proxies in front of the backend, uploaded in chunks
+	chunkedUploads := NewChunkedUploads(int64(appCfg.ChunkedUpload.ChunkSize), CHUNKED_UPLOAD_TTL, appCfg.ChunkedUpload.MaxUploads, CHUNKED_UPLOAD_MAX_UPLOADS_PER_CLIENT)
+	http.Handle("/v1/submit/", chunkedUploads.Handler(submitH))
+	http.Handle("/v2/submit/", chunkedUploads.Handler(submitH.WithSchema(SUBMIT_SCHEMA_V2)))
+	// Fake clock and objects saved, of dev mode only
+	if devClock != nil {
+		http.Handle("/dev/clock", devClock.Handler())
+	}
+	if memoryStorage != nil && *dev {
+		http.Handle("/dev/storage/", memoryStorage.Handler("/dev/storage/"))
+	}
+
+	// Canary submission running through the whole pipeline
+	var canary *Canary
+	if appCfg.Canary != nil {
+		canary, err = LoadCanary(submitH, appCfg.Canary.RequestFile, app.Now, log)
+		if err != nil {
+			log.Fatalf("Error configuring canary: %v", err)
+		}
+		if notifier != nil {
+			canaryFailures := NewFailureStreak(notifier, ALERT_CANARY_FAILING, "Canary submission failing", 1)
+			canary.OnResult = func(result CanaryResult) {
+				if result.Ok {
+					canaryFailures.Record(nil)
+				} else {
+					canaryFailures.Record(errors.New(result.Error))
+				}
+			}
+		}
+	}
+
+	// API keys guarding non-submission endpoints, stored in PostgreSQL if configured
+	app.APIKeys = &APIKeys{Store: &MemoryAPIKeyStore{}, Now: app.Now}
+	if appCfg.PostgreSQL != nil {
+		apiKeyStore := &PostgreSQLAPIKeyStore{DB: pctx.DB}
+		if err := apiKeyStore.CreateTableIfNotExists(); err != nil {
+			log.Fatalf("Error creating api_keys table: %v", err)
+		}
+		app.APIKeys.Store = apiKeyStore
+	} else {
+		log.Warnf("No PostgreSQL configured, API keys are kept in memory and lost on restart")
+	}
+
+	// Per-backend outcomes of saving submissions, stored in PostgreSQL if configured
+	if appCfg.PostgreSQL != nil {
+		writeOutcomeStore := &PostgreSQLWriteOutcomeStore{DB: pctx.DB}
+		if err := writeOutcomeStore.CreateTableIfNotExists(); err != nil {
+			log.Fatalf("Error creating storage_write_outcomes table: %v", err)
+		}
+		app.WriteOutcomes = writeOutcomeStore
+	} else {
+		app.WriteOutcomes = NewMemoryWriteOutcomeStore(WRITE_OUTCOMES_MEMORY_MAX)
+	}
+	http.Handle("/v1/storage-outcomes", app.APIKeys.RequireAPIKey(SCOPE_READ, WriteOutcomesHandler(app.WriteOutcomes)))
+
+	// Accepted submissions by receipt id, stored in PostgreSQL if configured
+	if appCfg.PostgreSQL != nil {
+		receiptIndex := &PostgreSQLReceiptIndex{DB: pctx.DB}
+		if err := receiptIndex.CreateTableIfNotExists(); err != nil {
+			log.Fatalf("Error creating submission_receipts table: %v", err)
+		}
+		app.ReceiptIndex = receiptIndex
+	} else {
+		app.ReceiptIndex = NewMemoryReceiptIndex(RECEIPT_INDEX_MEMORY_MAX)
+	}
+	http.Handle("/v1/receipts/", app.APIKeys.RequireAPIKey(SCOPE_READ, ReceiptsHandler(app.ReceiptIndex, app.WriteOutcomes)))
+
+	http.Handle("/v1/stats/submitters", app.APIKeys.RequireAPIKey(SCOPE_READ, submitterStats.Handler()))
+	// Series by submitter are served as the statistics, /metrics isn't
+	// authenticated
+	http.Handle("/metrics/submitters", app.APIKeys.RequireAPIKey(SCOPE_READ, PrometheusHandler(submitterStats)))
+	collectors := []PrometheusCollector{submitH.Pipeline(), app.SubmitSizes}
+	if clockCheck != nil {
+		collectors = append(collectors, clockCheck)
+	}
+	if app.ClockGuard != nil {
+		collectors = append(collectors, app.ClockGuard)
+	}
+	if election != nil {
+		collectors = append(collectors, election)
+	}
+	http.Handle("/v1/stats/slo", app.APIKeys.RequireAPIKey(SCOPE_READ, sloTracker.Handler()))
+
+	// Periodic measurement of storage usage, Keyspaces is not measured
+	if appCfg.StorageUsage != nil {
+		meters := make(map[string]UsageMeter)
+		if appCfg.Aws != nil {
+			meters["s3"] = &awsctx
+		}
+		if appCfg.PostgreSQL != nil {
+			meters["postgresql"] = &pctx
+		}
+		if appCfg.LocalFileSystem != nil {
+			meters["filesystem"] = LocalFileSystemUsage{Directory: appCfg.LocalFileSystem.Path}
+		}
+		interval := STORAGE_USAGE_DEFAULT_INTERVAL
+		if appCfg.StorageUsage.IntervalMinutes > 0 {
+			interval = time.Duration(appCfg.StorageUsage.IntervalMinutes) * time.Minute
+		}
+		storageUsage := NewStorageUsageMonitor(meters, app.Now, log)
+		go storageUsage.MeasureLoop(ctx, interval)
+		http.Handle("/v1/stats/storage", app.APIKeys.RequireAPIKey(SCOPE_READ, storageUsage.Handler()))
+		collectors = append(collectors, storageUsage)
+		log.Infof("Storage usage measured every %v", interval)
+	}
+	// Scheduled cleanup of submissions older than the retention of backends,
+	// run by the leader
+	if appCfg.Retention != nil {
+		retention := NewRetentionJob(app.Now, log)
+		for name, policy := range appCfg.Retention.Policies() {
+			switch name {
+			case "s3":
+				cleaner := &S3Cleaner{Aws: &awsctx, ArchivePrefix: policy.ArchiveTo, StorageClass: types.StorageClass(policy.ArchiveStorageClass), SkipBlocks: appCfg.Retention.OrphanedBlocks}
+				retention.Add(name, cleaner, policy.MaxAge())
+				if appCfg.Retention.OrphanedBlocks {
+					retention.AddBlockGC(name, &BlockGC{Source: &S3Source{Aws: &awsctx}, Deleter: cleaner, Grace: appCfg.Retention.OrphanedBlocksGrace()})
+				}
+			case "keyspaces":
+				retention.Add(name, &KeyspacesCleaner{Keyspaces: &kc, LookbackDays: appCfg.Retention.KeyspacesLookbackDays}, policy.MaxAge())
+			case "postgresql":
+				retention.Add(name, &pctx, policy.MaxAge())
+			case "filesystem":
+				cleaner := LocalFileSystemCleaner{Directory: appCfg.LocalFileSystem.Path, ArchiveDirectory: policy.ArchiveTo, SkipBlocks: appCfg.Retention.OrphanedBlocks}
+				retention.Add(name, cleaner, policy.MaxAge())
+				if appCfg.Retention.OrphanedBlocks {
+					retention.AddBlockGC(name, &BlockGC{Source: LocalFileSystemSource{Directory: appCfg.LocalFileSystem.Path}, Deleter: cleaner, Grace: appCfg.Retention.OrphanedBlocksGrace()})
+				}
+			}
+		}
+		go retention.RunLoop(ctx, appCfg.Retention.Interval(), election)
+		collectors = append(collectors, retention)
+		log.Infof("Retention cleanup scheduled every %v", appCfg.Retention.Interval())
+	}
+	// Indexes of days of submissions of the object storage, S3 if
+	// configured, maintained by the leader
+	var indexer *SubmissionIndexer
+	if appCfg.Index != nil {
+		if appCfg.Aws != nil {
+			indexer = NewSubmissionIndexer(&S3Source{Aws: &awsctx}, awsctx.S3Save, app.Now, log)
+		} else if appCfg.LocalFileSystem != nil {
+			// Indexes are updated in place until their day is complete
+			indexer = NewSubmissionIndexer(LocalFileSystemSource{Directory: appCfg.LocalFileSystem.Path}, func(objs ObjectsToSave) error {
+				return LocalFileSystemOverwrite(objs, appCfg.LocalFileSystem.Path)
+			}, app.Now, log)
+		} else {
+			log.Fatal("Indexes require S3 or local filesystem storage")
+		}
+		go indexer.RunLoop(ctx, appCfg.Index.Interval(), election)
+		http.Handle("/v1/index/", app.APIKeys.RequireAPIKey(SCOPE_READ, indexer.Handler()))
+		http.Handle("/v1/blocks/", app.APIKeys.RequireAPIKey(SCOPE_READ, indexer.BlocksHandler()))
+		collectors = append(collectors, indexer)
+		log.Infof("Submissions indexed under %s every %v", INDEX_PREFIX, appCfg.Index.Interval())
+	}
+	// Statuses of the blocks of the object storage, S3 if configured,
+	// looked up in the archive by the leader once final
+	if appCfg.Finality != nil {
+		var checker *FinalityChecker
+		if appCfg.Aws != nil {
+			checker = NewFinalityChecker(&S3Source{Aws: &awsctx}, awsctx.S3Save, appCfg.Finality, app.Now, log)
+		} else if appCfg.LocalFileSystem != nil {
+			checker = NewFinalityChecker(LocalFileSystemSource{Directory: appCfg.LocalFileSystem.Path}, func(objs ObjectsToSave) error {
+				return LocalFileSystemSave(objs, appCfg.LocalFileSystem.Path, log)
+			}, appCfg.Finality, app.Now, log)
+		} else {
+			log.Fatal("Finality requires S3 or local filesystem storage")
+		}
+		go checker.RunLoop(ctx, appCfg.Finality.Interval(), election)
+		http.Handle("/v1/finality/", app.APIKeys.RequireAPIKey(SCOPE_READ, checker.Handler()))
+		collectors = append(collectors, checker)
+		log.Infof("Blocks looked up in the archive at %s %v after the end of their day", appCfg.Finality.ArchiveURL, appCfg.Finality.After())
+	}
+	// Submissions failing signature verification quarantined under
+	// rejected/ of the object storage, S3 if configured, expired ones
+	// deleted by the leader
+	if appCfg.SignatureQuarantine != nil {
+		if appCfg.Aws != nil {
+			app.SignatureQuarantine = NewSignatureQuarantine(&S3Source{Aws: &awsctx}, awsctx.S3Save, &S3Cleaner{Aws: &awsctx}, appCfg.SignatureQuarantine, app.Now, log)
+		} else if appCfg.LocalFileSystem != nil {
+			app.SignatureQuarantine = NewSignatureQuarantine(LocalFileSystemSource{Directory: appCfg.LocalFileSystem.Path}, func(objs ObjectsToSave) error {
+				return LocalFileSystemSave(objs, appCfg.LocalFileSystem.Path, log)
+			}, LocalFileSystemCleaner{Directory: appCfg.LocalFileSystem.Path}, appCfg.SignatureQuarantine, app.Now, log)
+		} else {
+			log.Fatal("Signature quarantine requires S3 or local filesystem storage")
+		}
+		go app.SignatureQuarantine.RunLoop(ctx, SIGNATURE_QUARANTINE_PURGE_INTERVAL, election)
+		collectors = append(collectors, app.SignatureQuarantine)
+		log.Infof("Submissions failing signature verification quarantined under %s for %v", REJECTED_PREFIX, app.SignatureQuarantine.MaxAge)
+	}
+	// Uptime scores of submitters computed from the submissions of the
+	// object storage, S3 if configured, by the leader
+	if appCfg.Scoring != nil {
+		var scorer *Scorer
+		if appCfg.Aws != nil {
+			scorer = NewScorer(&S3Source{Aws: &awsctx}, awsctx.S3Save, appCfg.Scoring, app.Now, log)
+		} else if appCfg.LocalFileSystem != nil {
+			// The latest snapshot is replaced by every run
+			scorer = NewScorer(LocalFileSystemSource{Directory: appCfg.LocalFileSystem.Path}, func(objs ObjectsToSave) error {
+				return LocalFileSystemOverwrite(objs, appCfg.LocalFileSystem.Path)
+			}, appCfg.Scoring, app.Now, log)
+		} else {
+			log.Fatal("Scoring requires S3 or local filesystem storage")
+		}
+		go scorer.RunLoop(ctx, appCfg.Scoring.Interval(), election)
+		http.Handle("/v1/scores", app.APIKeys.RequireAPIKey(SCOPE_READ, scorer.Handler()))
+		collectors = append(collectors, scorer)
+		log.Infof("Uptime scores over windows of %v days saved under %s every %v", appCfg.Scoring.Windows(), SCORES_PREFIX, appCfg.Scoring.Interval())
+		if appCfg.Leaderboard != nil {
+			leaderboard := NewLeaderboard(scorer, appCfg.Leaderboard, app.Now)
+			http.Handle("/v1/leaderboard", app.APIKeys.RequireAPIKey(SCOPE_READ, leaderboard.Handler()))
+			log.Infof("Leaderboard served with %d tiers and %d days of history", len(leaderboard.Tiers), leaderboard.HistoryDays)
+		}
+	}
+	// Submitters without submission for the silence period, checked once
+	// the whitelist is loaded
+	if appCfg.Silence != nil {
+		slotDuration := SLOT_DURATION_DEFAULT
+		if app.NetworkTiming != nil {
+			slotDuration = app.NetworkTiming.SlotDuration()
+		}
+		app.SilenceWatch = NewSilenceWatch(appCfg.Silence.After(slotDuration), func() *Whitelist {
+			if app.Whitelist == nil {
+				return nil
+			}
+			return app.Whitelist.ReadWhitelist()
+		}, notifier, time.Now, log)
+		app.SilenceWatch.Shared = sharedState
+		http.Handle("/v1/submitters/silent", app.APIKeys.RequireAPIKey(SCOPE_READ, app.SilenceWatch.Handler()))
+		collectors = append(collectors, app.SilenceWatch)
+	}
+	if app.LoadShedder != nil {
+		collectors = append(collectors, app.LoadShedder)
+	}
+	if connLimiter != nil {
+		collectors = append(collectors, connLimiter)
+	}
+	http.Handle("/metrics", PrometheusHandler(collectors...))
+
+	// Admin endpoints, enabled only when admin token is configured
+	if appCfg.AdminToken != "" {
+		http.Handle(ADMIN_API_PREFIX+"api-keys", AdminAuthFunc(adminToken.Value, app.APIKeys.AdminHandler()))
+		http.Handle(ADMIN_API_PREFIX+"api-keys/", AdminAuthFunc(adminToken.Value, app.APIKeys.AdminHandler()))
+		http.Handle(ADMIN_API_PREFIX+"log-level", AdminAuthFunc(adminToken.Value, logLevels.AdminHandler()))
+		http.Handle(ADMIN_API_PREFIX+"config", AdminAuthFunc(adminToken.Value, reloader.AdminHandler()))
+		http.Handle(ADMIN_API_PREFIX+"feature-flags", AdminAuthFunc(adminToken.Value, featureFlags.AdminHandler()))
+		http.Handle(ADMIN_API_PREFIX+"submit-size", AdminAuthFunc(adminToken.Value, app.SubmitSizes.AdminHandler()))
+		purges := &SubmitterPurgeJob{Purgers: make(map[string]SubmitterPurger), Now: app.Now, Log: log}
+		if appCfg.Aws != nil {
+			purges.Purgers["s3"] = &S3Cleaner{Aws: &awsctx}
+		}
+		if appCfg.AwsKeyspaces != nil {
+			purges.Purgers["keyspaces"] = &KeyspacesCleaner{Keyspaces: &kc}
+		}
+		if appCfg.PostgreSQL != nil {
+			purges.Purgers["postgresql"] = &pctx
+		}
+		if appCfg.LocalFileSystem != nil {
+			purges.Purgers["filesystem"] = LocalFileSystemCleaner{Directory: appCfg.LocalFileSystem.Path}
+		}
+		purges.Save = saveObjects
+		http.Handle(ADMIN_API_PREFIX+"purges", AdminAuthFunc(adminToken.Value, purges.AdminHandler()))
+		// State is read when snapshots are taken, the whitelist is set up below
+		state := &ServiceState{App: app, Network: appCfg.NetworkName}
+		http.Handle(ADMIN_API_PREFIX+"snapshot", AdminAuthFunc(adminToken.Value, state.AdminHandler()))
+		http.Handle(ADMIN_API_PREFIX+"drain", AdminAuthFunc(adminToken.Value, drain.AdminHandler()))
+		if app.SignatureLockout != nil {
+			http.Handle(ADMIN_API_PREFIX+"lockouts", AdminAuthFunc(adminToken.Value, app.SignatureLockout.AdminHandler()))
+			http.Handle(ADMIN_API_PREFIX+"lockouts/", AdminAuthFunc(adminToken.Value, app.SignatureLockout.AdminHandler()))
+		}
+		// Dashboard page, reading its data from the admin API
+		app.Dashboard = NewDashboard(app, appCfg.NetworkName)
+		http.Handle(ADMIN_API_PREFIX+"dashboard", AdminAuthFunc(adminToken.Value, app.Dashboard.Handler()))
+		http.Handle(ADMIN_API_PREFIX+"ui/", app.Dashboard.PageHandler())
+		log.Infof("Admin API enabled under %s", ADMIN_API_PREFIX)
+	}
 
 	// Health check endpoint
 	http.HandleFunc("/health", HealthHandler(func() bool {
 		return app.IsReady
 	}))
+	http.HandleFunc("/readyz", ReadyzHandler(func() bool {
+		return app.IsReady
+	}, canary, drain))
+	http.HandleFunc("/version", VersionHandler())
 
 	// Sheets service and whitelist loop
-	app.WhitelistDisabled = appCfg.DelegationWhitelistDisabled
-	if app.WhitelistDisabled {
+	app.WhitelistDisabled = appCfg.DelegationWhitelistDisabled && !*dev
+	if *dev {
+		var keys []Pk
+		for _, key := range strings.Split(*devWhitelist, ",") {
+			if key = strings.TrimSpace(key); key == "" {
+				continue
+			}
+			var pk Pk
+			if err := StringToPk(&pk, key); err != nil {
+				log.Fatalf("Invalid public key %s of -dev-whitelist: %v", key, err)
+			}
+			keys = append(keys, pk)
+		}
+		wl := DevWhitelist(keys)
+		app.Whitelist = new(WhitelistMVar)
+		app.Whitelist.Replace(&wl)
+		log.Infof("Delegation whitelist is static, number of BPs: %v", len(wl))
+	} else if app.WhitelistDisabled {
 		log.Infof("Delegation whitelist is disabled")
 	} else {
-		sheetsService, err2 := sheets.NewService(ctx, option.WithScopes(sheets.SpreadsheetsReadonlyScope))
+		sheetsScope := sheets.SpreadsheetsReadonlyScope
+		if appCfg.SheetsStatus != nil {
+			sheetsScope = sheets.SpreadsheetsScope
+		}
+		sheetsOptions := []option.ClientOption{option.WithScopes(sheetsScope)}
+		if appCfg.GsheetCredentials != "" {
+			sheetsOptions = append(sheetsOptions, option.WithCredentialsJSON([]byte(appCfg.GsheetCredentials)))
+		}
+		sheetsService, err2 := sheets.NewService(ctx, sheetsOptions...)
 		if err2 != nil {
 			log.Fatalf("Error creating Sheets service: %v", err2)
 		}
-		initWl, err := RetrieveWhitelist(sheetsService, log, appCfg, 1)
+		// Only the leader retrieves the whitelist from Sheets, and shares it
+		// with the other replicas through PostgreSQL
+		var whitelistStore *PostgreSQLWhitelistStore
+		if election != nil {
+			whitelistStore = &PostgreSQLWhitelistStore{DB: pctx.DB}
+			if err := whitelistStore.CreateTableIfNotExists(); err != nil {
+				log.Fatalf("Error creating delegation_whitelist table: %v", err)
+			}
+		}
+		retrieveWhitelist := func(retries int) (Whitelist, error) {
+			if !election.IsLeader() {
+				wl, _, err := whitelistStore.Load()
+				return wl, err
+			}
+			wl, err := RetrieveWhitelist(sheetsService, log, appCfg, retries)
+			if err == nil && whitelistStore != nil {
+				if err := whitelistStore.Save(wl, time.Now()); err != nil {
+					log.Warnf("Failed to share delegation whitelist with other replicas: %v", err)
+				}
+			}
+			return wl, err
+		}
+		// Faults are injected into refreshes only, the backend doesn't
+		// start without a whitelist
+		refreshWhitelist := retrieveWhitelist
+		if faults != nil {
+			refreshWhitelist = faults.WrapWhitelist(retrieveWhitelist)
+		}
+		initWl, err := retrieveWhitelist(1)
+		if err != nil && !election.IsLeader() {
+			// Nothing shared yet, e.g. the leader is starting as well
+			initWl, err = RetrieveWhitelist(sheetsService, log, appCfg, 1)
+		}
 		if err != nil {
 			log.Fatalf("Failed to initialize whitelist: %v", err)
 		}
 		wlMvar := new(WhitelistMVar)
-		wlMvar.Replace(&initWl)
 		app.Whitelist = wlMvar
-		log.Infof("Delegation whitelist is enabled")
+		app.ReplaceWhitelist(initWl)
+		if limits := initWl.RateLimits(); len(limits) > 0 {
+			log.Infof("Hourly limits of %v keys read from the delegation whitelist", len(limits))
+		}
+		log.Infof("Delegation whitelist is enabled, refresh interval: %v", appCfg.WhitelistRefreshInterval())
+		// Status of block producers written back to the spreadsheet by the leader
+		if statusCfg := appCfg.SheetsStatus; statusCfg != nil {
+			sheet := &GoogleStatusSheet{Service: sheetsService, SpreadsheetId: appCfg.GsheetId}
+			statusWriter := NewSheetsStatusWriter(sheet, statusCfg, indexer, app.NetworkTiming, wlMvar.ReadWhitelist, app.Now, log)
+			go statusWriter.RunLoop(ctx, statusCfg.Interval(), election)
+			log.Infof("Status of block producers written to tab %s every %v", statusWriter.Tab, statusCfg.Interval())
+		}
+		var whitelistFailures *FailureStreak
+		if notifier != nil {
+			threshold := ALERT_DEFAULT_WHITELIST_FAILURE_THRESHOLD
+			if appCfg.Alerting.WhitelistFailureThreshold > 0 {
+				threshold = appCfg.Alerting.WhitelistFailureThreshold
+			}
+			whitelistFailures = NewFailureStreak(notifier, ALERT_WHITELIST_REFRESH_FAILING, "Delegation whitelist refresh failing", threshold)
+		}
 		go func() {
 			for {
-				time.Sleep(SetWhitelistRefreshInterval(log))
-				wl, err := RetrieveWhitelist(sheetsService, log, appCfg, 10)
+				time.Sleep(reloader.Config().WhitelistRefreshInterval())
+				wl, err := refreshWhitelist(10)
+				if whitelistFailures != nil {
+					whitelistFailures.Record(err)
+				}
 				if err != nil {
 					log.Errorf("Failed to refresh delegation whitelist, using previous one, error: %v", err)
+					if app.ErrorReporter != nil {
+						app.ErrorReporter.Report(ErrorEvent{
+							Message: "Failed to refresh delegation whitelist",
+							Err:     err,
+							Tags:    map[string]string{"gsheet_id": appCfg.GsheetId},
+						})
+					}
 				} else {
-					wlMvar.Replace(&wl)
-					log.Infof("Delegation whitelist refreshed, number of BPs: %v", len(wl))
+					if app.Webhooks != nil {
+						app.Webhooks.WhitelistChanged(*wlMvar.ReadWhitelist(), wl)
+					}
+					app.ReplaceWhitelist(wl)
+					log.Infof("Delegation whitelist refreshed, number of BPs: %v, with hourly limits: %v", len(wl), len(wl.RateLimits()))
 				}
 			}
 		}()
 	}
 
+	if appCfg.Secrets != nil && appCfg.Secrets.RefreshIntervalMinutes > 0 {
+		refreshInterval := time.Duration(appCfg.Secrets.RefreshIntervalMinutes) * time.Minute
+		go RefreshSecretsLoop(secretResolver, rotatingSecrets, refreshInterval, log)
+		log.Infof("Secrets are refreshed every %v", refreshInterval)
+	}
+
+	// Settings safe to change at runtime are applied once the config file changes
+	if reloader.Status().ConfigFile != "" {
+		go reloader.WatchLoop(CONFIG_RELOAD_CHECK_INTERVAL)
+		log.Infof("Config file %s is watched for changes", reloader.Status().ConfigFile)
+	}
+
+	if app.SilenceWatch != nil {
+		go app.SilenceWatch.CheckLoop(SILENCE_CHECK_INTERVAL, election)
+		log.Infof("Submitters are silent after %v without submission", app.SilenceWatch.After)
+	}
+
 	// Start server
 	app.IsReady = true
-	log.Infof("Server ready and listening on %s", DELEGATION_BACKEND_LISTEN_TO)
-	log.Infof("Available endpoints: / (root), /v1/submit (submissions), /health (health check)")
-	log.Fatal(http.ListenAndServe(DELEGATION_BACKEND_LISTEN_TO, nil))
+	if canary != nil {
+		interval := CANARY_DEFAULT_INTERVAL
+		if appCfg.Canary.IntervalMinutes > 0 {
+			interval = time.Duration(appCfg.Canary.IntervalMinutes) * time.Minute
+		}
+		go canary.RunLoop(interval)
+		log.Infof("Canary submission enabled, running every %v", interval)
+	}
+	if appCfg.Diagnostics != nil {
+		diagnosticsListenTo := appCfg.Diagnostics.ListenTo
+		if diagnosticsListenTo == "" {
+			diagnosticsListenTo = DIAGNOSTICS_DEFAULT_LISTEN_TO
+		}
+		diagnosticsServer, err := NewDiagnosticsServer(diagnosticsListenTo, adminToken.Value)
+		if err != nil {
+			log.Fatalf("Error configuring diagnostics: %v", err)
+		}
+		go func() {
+			log.Infof("Diagnostics listening on %s", diagnosticsListenTo)
+			log.Fatal(diagnosticsServer.ListenAndServe())
+		}()
+	}
+	if tlsServer != nil {
+		go func() {
+			log.Infof("Server listening for TLS connections on %s", tlsServer.Addr)
+			log.Fatal(tlsServer.ServeTLS(tlsListener, "", ""))
+		}()
+	}
+	listener, err := Listen(appCfg.ListenAddress())
+	if err != nil {
+		log.Fatalf("Error listening on %s: %v", appCfg.ListenAddress(), err)
+	}
+	log.Infof("Server ready and listening on %s", appCfg.ListenAddress())
+	log.Infof("Available endpoints: / (root), /v1/submit and /v2/submit (submissions), /health (health check), /version (build information)")
+	if connLimiter != nil {
+		listener = connLimiter.Listener(listener)
+	}
+	server := NewHTTPServer(appCfg.ListenAddress(), rootHandler, serverTimeouts)
+	if err := appCfg.Server.Apply(server, true); err != nil {
+		log.Fatalf("Error configuring HTTP/2: %v", err)
+	}
+	// On SIGINT or SIGTERM the instance drains for the drain delay, unless
+	// it already did, then requests in flight are completed and pending
+	// writes flushed, and state persisted
+	shutdownDone := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		log.Infof("Received %v, shutting down", <-signals)
+		drain.Start()
+		drain.Wait(appCfg.Server.DrainDelay(), time.Sleep)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), SERVER_SHUTDOWN_TIMEOUT)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("Error shutting down server: %v", err)
+		}
+		stopTLSReload()
+		stopPersisting()
+		persisting.Wait()
+		if app.ReplayGuard != nil {
+			if err := app.ReplayGuard.Persist(); err != nil {
+				log.Errorf("Failed to persist replay protection state: %v", err)
+			}
+		}
+		if err := submitterStats.Persist(saveStats); err != nil {
+			log.Errorf("Failed to persist submitter statistics: %v", err)
+		}
+		for _, batcher := range batchers {
+			batcher.Close()
+		}
+		for _, auditLog := range app.AuditLogs {
+			auditLog.Flush()
+		}
+		if errorReporter != nil && !errorReporter.Flush(ERROR_REPORT_TIMEOUT) {
+			log.Warnf("Error reports still queued at shutdown")
+		}
+		close(shutdownDone)
+	}()
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-shutdownDone
+	log.Infof("Server shut down")
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Copy submissions from a storage backend to another, resuming from the
+// progress file of an earlier run
+func migrateStorage(args []string) {
+	flags := flag.NewFlagSet("migrate-storage", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	from := flags.String("from", "", "Backend to copy submissions from (s3 or filesystem)")
+	to := flags.String("to", "", "Backend to copy submissions to (s3, keyspaces, postgresql or filesystem)")
+	progressFile := flags.String("progress", "", "File of the progress of the migration, resumed from if it exists [default: migrate-storage-<from>-<to>.json]")
+	rate := flags.Float64("rate", 0, "Submissions copied per second, no limit by default")
+	since := flags.String("since", "", "Copy submissions of this day (YYYY-MM-DD) and later")
+	until := flags.String("until", "", "Copy submissions of this day (YYYY-MM-DD) and earlier")
+	noVerify := flags.Bool("no-verify", false, "Don't read objects back from s3 or filesystem destinations to compare checksums")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log := logging.Logger("delegation backend migrate-storage")
+	for _, day := range []string{*since, *until} {
+		if _, err := time.Parse("2006-01-02", day); day != "" && err != nil {
+			log.Fatalf("Invalid day %q, expected YYYY-MM-DD", day)
+		}
+	}
+	if *rate < 0 {
+		log.Fatalf("Invalid -rate %v, expected a positive number", *rate)
+	}
+	if *from == *to {
+		log.Fatal("Set different backends with -from and -to")
+	}
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	configured := make(map[string]bool)
+	for _, name := range configuredBackends(appCfg) {
+		configured[name] = true
+	}
+	for _, name := range []string{*from, *to} {
+		if !configured[name] {
+			log.Fatalf("Backend %q is not configured", name)
+		}
+	}
+
+	source, err := openBackend(ctx, *from, appCfg, secretResolver, log)
+	if err != nil {
+		log.Fatalf("Error initializing %s backend: %v", *from, err)
+	}
+	if source.Source == nil {
+		log.Fatalf("Submissions can't be read from %s, copy from s3 or filesystem", *from)
+	}
+	destination, err := openBackend(ctx, *to, appCfg, secretResolver, log)
+	if err != nil {
+		log.Fatalf("Error initializing %s backend: %v", *to, err)
+	}
+
+	if *progressFile == "" {
+		*progressFile = fmt.Sprintf("migrate-storage-%s-%s.json", *from, *to)
+	}
+	migration := &StorageMigration{
+		From:   *from,
+		To:     *to,
+		Source: source.Source,
+		Save:   destination.Save,
+		// Databases save the block along with every submission
+		DedupBlocks:  destination.Source != nil,
+		ProgressFile: *progressFile,
+		Rate:         *rate,
+		Filter:       ReplayFilter{Since: *since, Until: *until},
+		Log:          log,
+	}
+	if !*noVerify {
+		migration.Verify = destination.Source
+	}
+	log.Infof("Copying submissions from %s to %s, progress saved to %s", *from, *to, *progressFile)
+	progress, err := migration.Run(ctx)
+	source.Close()
+	destination.Close()
+	if progress != nil {
+		fmt.Printf("Copied %d submissions (%d objects, %d bytes), %d failed, %d without block\n",
+			progress.Submissions, progress.Objects, progress.Bytes, len(progress.Failed), progress.MissingBlocks)
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Printf("Interrupted, run the command again to resume from %s\n", *progressFile)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Errorf("Migration failed: %v", err)
+		os.Exit(1)
+	}
+	if len(progress.Failed) > 0 {
+		os.Exit(1)
+	}
+}
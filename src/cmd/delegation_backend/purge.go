@@ -0,0 +1,101 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Delete (or quarantine) the submissions of a submitter of a range of days
+// from backends, writing the manifest of what was removed
+func purge(args []string) {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	submitter := flags.String("submitter", "", "Public key of the submitter to purge (required)")
+	since := flags.String("since", "", "Purge submissions of this day (YYYY-MM-DD) and later (required)")
+	until := flags.String("until", "", "Purge submissions of this day (YYYY-MM-DD) and earlier (required)")
+	backends := flags.String("backends", "", "Comma-separated backends to purge (s3, keyspaces, postgresql, filesystem), all configured by default")
+	quarantine := flags.Bool("quarantine", false, "Move submissions of s3 and filesystem under quarantine/ rather than deleting them, databases can't quarantine")
+	dryRun := flags.Bool("dry-run", false, "List the submissions which would be removed without removing them")
+	reason := flags.String("reason", "", "Reason of the purge, recorded in the manifest")
+	output := flags.String("output", "", "File of the manifest of the purge, standard output by default")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	log := logging.Logger("delegation backend purge")
+	request := SubmitterPurge{Submitter: *submitter, Since: *since, Until: *until, Quarantine: *quarantine, DryRun: *dryRun, Reason: *reason}
+	if err := request.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	names := configuredBackends(appCfg)
+	if *backends != "" {
+		configured := make(map[string]bool)
+		for _, name := range names {
+			configured[name] = true
+		}
+		names = nil
+		for _, name := range strings.Split(*backends, ",") {
+			name = strings.TrimSpace(name)
+			if !configured[name] {
+				log.Fatalf("Backend %q is not configured", name)
+			}
+			names = append(names, name)
+		}
+	}
+
+	job := &SubmitterPurgeJob{Purgers: make(map[string]SubmitterPurger), Now: time.Now, Log: log}
+	var savers []func(ObjectsToSave) error
+	var closers []func()
+	for _, name := range names {
+		backend, err := openBackend(ctx, name, appCfg, secretResolver, log)
+		if err != nil {
+			log.Fatalf("Error initializing %s backend: %v", name, err)
+		}
+		closers = append(closers, backend.Close)
+		job.Purgers[name] = backend.Cleaner.(SubmitterPurger)
+		// Manifests are saved to object storages, along with other records
+		if backend.Source != nil {
+			savers = append(savers, backend.Save)
+		}
+	}
+	job.Save = func(objs ObjectsToSave) error {
+		var saveErr error
+		for _, save := range savers {
+			if err := save(objs); err != nil {
+				saveErr = err
+			}
+		}
+		return saveErr
+	}
+	manifest, err := job.Run(ctx, request)
+	for _, closeBackend := range closers {
+		closeBackend()
+	}
+	if manifest != nil {
+		bs, _ := json.MarshalIndent(manifest, "", "  ")
+		if *output == "" {
+			fmt.Println(string(bs))
+		} else if err := os.WriteFile(*output, append(bs, '\n'), 0644); err != nil {
+			log.Fatalf("Error writing manifest: %v", err)
+		}
+		for _, name := range names {
+			if backend := manifest.Backends[name]; backend.Error != "" {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", name, backend.Error)
+			}
+		}
+	}
+	if err != nil {
+		log.Errorf("Purge failed: %v", err)
+	}
+	if err != nil || manifest.Failed() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Save submissions of the local filesystem storage, or of S3, to storage
+// backends through the save pipeline of the submit handler
+func replay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	from := flags.String("from", "", "Directory of the local filesystem storage, or object storage backend (s3 or filesystem), to replay; the configured filesystem storage by default")
+	since := flags.String("since", "", "Replay submissions of this day (YYYY-MM-DD) or since this time (RFC 3339) and later")
+	until := flags.String("until", "", "Replay submissions of this day (YYYY-MM-DD) or until this time (RFC 3339) and earlier")
+	backends := flags.String("backends", "", "Comma-separated backends to replay to (s3, keyspaces, postgresql, filesystem), all configured but the replayed one by default")
+	dryRun := flags.Bool("dry-run", false, "List submissions to replay without saving them")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log := logging.Logger("delegation backend replay")
+	for _, bound := range []string{*since, *until} {
+		_, dayErr := time.Parse("2006-01-02", bound)
+		_, timeErr := time.Parse(time.RFC3339, bound)
+		if bound != "" && dayErr != nil && timeErr != nil {
+			log.Fatalf("Invalid bound %q, expected YYYY-MM-DD or an RFC 3339 time", bound)
+		}
+	}
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	configured := make(map[string]bool)
+	for _, name := range configuredBackends(appCfg) {
+		configured[name] = true
+	}
+
+	// Backend replayed, if configured, it is not replayed to by default
+	var source StorageSource
+	var sourceName, replayed string
+	var closers []func()
+	switch *from {
+	case "s3", "filesystem":
+		if !configured[*from] {
+			log.Fatalf("Backend %q is not configured", *from)
+		}
+		backend, err := openBackend(ctx, *from, appCfg, secretResolver, log)
+		if err != nil {
+			log.Fatalf("Error initializing %s backend: %v", *from, err)
+		}
+		closers = append(closers, backend.Close)
+		source, sourceName, replayed = backend.Source, *from, *from
+	default:
+		directory := *from
+		if directory == "" {
+			if appCfg.LocalFileSystem == nil {
+				log.Fatal("No local filesystem storage configured, set the directory or backend to replay with -from")
+			}
+			directory = appCfg.LocalFileSystem.Path
+		}
+		if appCfg.LocalFileSystem != nil && filepath.Clean(appCfg.LocalFileSystem.Path) == filepath.Clean(directory) {
+			replayed = "filesystem"
+		}
+		source, sourceName = LocalFileSystemSource{Directory: directory}, directory
+	}
+
+	var names []string
+	if *backends == "" {
+		for _, name := range configuredBackends(appCfg) {
+			if name != replayed {
+				names = append(names, name)
+			}
+		}
+	} else {
+		for _, name := range strings.Split(*backends, ",") {
+			name = strings.TrimSpace(name)
+			if !configured[name] {
+				log.Fatalf("Backend %q is not configured", name)
+			}
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		log.Fatal("No backend to replay to")
+	}
+
+	var save func(ObjectsToSave) StorageOutcomes
+	if *dryRun {
+		save = func(objs ObjectsToSave) StorageOutcomes {
+			for path := range objs {
+				if strings.HasPrefix(path, SUBMISSIONS_PREFIX) {
+					fmt.Println(path)
+				}
+			}
+			return nil
+		}
+	} else {
+		featureFlags, err := NewFeatureFlags(appCfg.NetworkName, appCfg.FeatureFlags)
+		if err != nil {
+			log.Fatalf("Error initializing feature flags: %v", err)
+		}
+		savers := make(map[string]func(context.Context, ObjectsToSave) error, len(names))
+		for _, name := range names {
+			backend, err := openBackend(ctx, name, appCfg, secretResolver, log)
+			if err != nil {
+				log.Fatalf("Error initializing %s backend: %v", name, err)
+			}
+			closers = append(closers, backend.Close)
+			backendSave := backend.Save
+			savers[name] = func(_ context.Context, objs ObjectsToSave) error { return backendSave(objs) }
+		}
+		// Saved as by the submit handler, concurrently if enabled
+		save = func(objs ObjectsToSave) StorageOutcomes {
+			return SaveToBackends(ctx, objs, savers, featureFlags.Enabled(FEATURE_PARALLEL_SAVE), false)
+		}
+	}
+	log.Infof("Replaying submissions of %s to %s", sourceName, strings.Join(names, ", "))
+	result, err := ReplaySubmissions(ctx, source, ReplayFilter{Since: *since, Until: *until}, save, log)
+	for _, closeBackend := range closers {
+		closeBackend()
+	}
+	if err != nil {
+		log.Fatalf("Replay failed: %v", err)
+	}
+	fmt.Printf("Replayed %d submissions, %d failed, %d without block\n", result.Submissions, result.Failed, result.MissingBlocks)
+	for _, name := range names {
+		if failed := result.FailedBackends[name]; failed > 0 {
+			fmt.Printf("%s: %d submissions failed to be saved\n", name, failed)
+		}
+	}
+	if result.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Names of the storage backends of the configuration
+func configuredBackends(appCfg AppConfig) []string {
+	var names []string
+	if appCfg.Aws != nil {
+		names = append(names, "s3")
+	}
+	if appCfg.AwsKeyspaces != nil {
+		names = append(names, "keyspaces")
+	}
+	if appCfg.PostgreSQL != nil {
+		names = append(names, "postgresql")
+	}
+	if appCfg.LocalFileSystem != nil {
+		names = append(names, "filesystem")
+	}
+	return names
+}
+
+// Storage backend connected by a command
+type storageBackend struct {
+	// Values of the objects are only valid for the duration of the call
+	Save func(ObjectsToSave) error
+	// Saves objects replacing existing ones, nil for databases
+	Overwrite func(ObjectsToSave) error
+	// Deletes old submissions, archiving as set by the retention policy
+	// of the backend, if any
+	Cleaner Cleaner
+	// Collects orphaned blocks, nil for databases
+	BlockGC *BlockGC
+	// Reads submissions back, nil for databases
+	Source StorageSource
+	Export ExportSource
+	// Submissions quarantined after failing signature verification, nil
+	// for databases
+	Quarantine *SignatureQuarantine
+	Close      func()
+}
+
+// Connect to the storage backend
+func openBackend(ctx context.Context, name string, appCfg AppConfig, secretResolver *SecretResolver, log *logging.ZapEventLogger) (*storageBackend, error) {
+	policy := &RetentionPolicy{}
+	// Blocks are left to the collection of orphaned blocks, if enabled
+	retention := &RetentionConfig{}
+	if appCfg.Retention != nil {
+		retention = appCfg.Retention
+		if configured := appCfg.Retention.Policies()[name]; configured != nil {
+			policy = configured
+		}
+	}
+	quarantine := appCfg.SignatureQuarantine
+	if quarantine == nil {
+		quarantine = &SignatureQuarantineConfig{}
+	}
+	switch name {
+	case "s3":
+		awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(appCfg.Aws.Region))
+		if err != nil {
+			return nil, err
+		}
+		// Blocks are deduplicated as by the submit handler
+		featureFlags, err := NewFeatureFlags(appCfg.NetworkName, appCfg.FeatureFlags)
+		if err != nil {
+			return nil, err
+		}
+		awsctx := &AwsContext{Client: s3.NewFromConfig(awsCfg, S3OptionsFromEnv), BucketName: aws.String(GetAWSBucketName(appCfg)), Prefix: appCfg.NetworkName, Context: ctx, Log: log, Flags: featureFlags}
+		cleaner := &S3Cleaner{Aws: awsctx, ArchivePrefix: policy.ArchiveTo, StorageClass: types.StorageClass(policy.ArchiveStorageClass), SkipBlocks: retention.OrphanedBlocks}
+		source, err := resolvingSource(ctx, &S3Source{Aws: awsctx})
+		if err != nil {
+			return nil, err
+		}
+		return &storageBackend{
+			Save:       awsctx.S3Save,
+			Overwrite:  awsctx.S3Save,
+			Cleaner:    cleaner,
+			BlockGC:    &BlockGC{Source: &S3Source{Aws: awsctx}, Deleter: cleaner, Grace: retention.OrphanedBlocksGrace()},
+			Source:     source,
+			Export:     ObjectStorageExport{Source: source},
+			Quarantine: NewSignatureQuarantine(&S3Source{Aws: awsctx}, awsctx.S3Save, &S3Cleaner{Aws: awsctx}, quarantine, time.Now, log),
+			Close:      func() {},
+		}, nil
+	case "keyspaces":
+		session, err := NewKeyspaceSession(appCfg.AwsKeyspaces, log)
+		if err != nil {
+			return nil, err
+		}
+		kc := &KeyspaceContext{Session: session, Keyspace: appCfg.AwsKeyspaces.Keyspace, Context: ctx, Log: log}
+		lookback := 0
+		if appCfg.Retention != nil {
+			lookback = appCfg.Retention.KeyspacesLookbackDays
+		}
+		return &storageBackend{Save: kc.KeyspaceSave, Cleaner: &KeyspacesCleaner{Keyspaces: kc, LookbackDays: lookback}, Export: kc, Close: session.Close}, nil
+	case "postgresql":
+		password, err := NewSecret(secretResolver, appCfg.PostgreSQL.Password)
+		if err != nil {
+			return nil, err
+		}
+		db, err := NewPostgreSQLWithSecret(appCfg.PostgreSQL, password)
+		if err != nil {
+			return nil, err
+		}
+		pctx := &PostgreSQLContext{DB: db, Log: log}
+		if err := pctx.Prepare(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return &storageBackend{Save: pctx.PostgreSQLSave, Cleaner: pctx, Export: pctx, Close: func() { db.Close() }}, nil
+	case "filesystem":
+		cleaner := LocalFileSystemCleaner{Directory: appCfg.LocalFileSystem.Path, ArchiveDirectory: policy.ArchiveTo, SkipBlocks: retention.OrphanedBlocks}
+		source, err := resolvingSource(ctx, LocalFileSystemSource{Directory: appCfg.LocalFileSystem.Path})
+		if err != nil {
+			return nil, err
+		}
+		save := func(objs ObjectsToSave) error {
+			return LocalFileSystemSave(objs, appCfg.LocalFileSystem.Path, log)
+		}
+		return &storageBackend{
+			Save: save,
+			Overwrite: func(objs ObjectsToSave) error {
+				return LocalFileSystemOverwrite(objs, appCfg.LocalFileSystem.Path)
+			},
+			Cleaner: cleaner,
+			BlockGC: &BlockGC{Source: LocalFileSystemSource{Directory: appCfg.LocalFileSystem.Path}, Deleter: cleaner, Grace: retention.OrphanedBlocksGrace()},
+			Source:  source,
+			Export:  ObjectStorageExport{Source: source},
+			Quarantine: NewSignatureQuarantine(LocalFileSystemSource{Directory: appCfg.LocalFileSystem.Path}, save,
+				LocalFileSystemCleaner{Directory: appCfg.LocalFileSystem.Path}, quarantine, time.Now, log),
+			Close: func() {},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %s", name)
+}
+
+// Source of an object storage resolving paths of submissions and blocks
+// moved by rewrite-paths, if any
+func resolvingSource(ctx context.Context, source interface {
+	StorageSource
+	BlockLister
+}) (StorageSource, error) {
+	manifest, err := ReadPathManifest(ctx, source)
+	if errors.Is(err, fs.ErrNotExist) {
+		return source, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return PathResolvingSource{Source: source, Manifest: manifest}, nil
+}
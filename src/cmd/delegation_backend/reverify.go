@@ -0,0 +1,130 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Verify submissions quarantined after failing signature verification
+// again, e.g. once the signature scheme of the network is fixed, restoring
+// those which verify to backends
+func reverify(args []string) {
+	flags := flag.NewFlagSet("reverify", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	from := flags.String("from", "", "Object storage of the quarantine (s3 or filesystem), s3 if configured by default, as by the server")
+	since := flags.String("since", "", "Verify submissions quarantined of this day (YYYY-MM-DD) or since this time (RFC 3339) and later")
+	until := flags.String("until", "", "Verify submissions quarantined of this day (YYYY-MM-DD) or until this time (RFC 3339) and earlier")
+	backends := flags.String("backends", "", "Comma-separated backends to restore submissions to (s3, keyspaces, postgresql, filesystem), all configured by default")
+	dryRun := flags.Bool("dry-run", false, "List submissions which verify without restoring them")
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log := logging.Logger("delegation backend reverify")
+	for _, bound := range []string{*since, *until} {
+		_, dayErr := time.Parse("2006-01-02", bound)
+		_, timeErr := time.Parse(time.RFC3339, bound)
+		if bound != "" && dayErr != nil && timeErr != nil {
+			log.Fatalf("Invalid bound %q, expected YYYY-MM-DD or an RFC 3339 time", bound)
+		}
+	}
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	configured := make(map[string]bool)
+	for _, name := range configuredBackends(appCfg) {
+		configured[name] = true
+	}
+	source := *from
+	if source == "" {
+		if configured["s3"] {
+			source = "s3"
+		} else {
+			source = "filesystem"
+		}
+	}
+	if source != "s3" && source != "filesystem" {
+		log.Fatalf("Invalid -from %q, expected s3 or filesystem", source)
+	}
+	if !configured[source] {
+		log.Fatalf("Backend %q is not configured", source)
+	}
+	scheme, err := appCfg.Signature.Scheme(appCfg.NetworkName)
+	if err != nil {
+		log.Fatalf("Error configuring the signature scheme: %v", err)
+	}
+
+	names := configuredBackends(appCfg)
+	if *backends != "" {
+		names = nil
+		for _, name := range strings.Split(*backends, ",") {
+			name = strings.TrimSpace(name)
+			if !configured[name] {
+				log.Fatalf("Backend %q is not configured", name)
+			}
+			names = append(names, name)
+		}
+	}
+	var closers []func()
+	opened := make(map[string]*storageBackend)
+	for _, name := range append([]string{source}, names...) {
+		if opened[name] != nil {
+			continue
+		}
+		backend, err := openBackend(ctx, name, appCfg, secretResolver, log)
+		if err != nil {
+			log.Fatalf("Error initializing %s backend: %v", name, err)
+		}
+		closers = append(closers, backend.Close)
+		opened[name] = backend
+	}
+	featureFlags, err := NewFeatureFlags(appCfg.NetworkName, appCfg.FeatureFlags)
+	if err != nil {
+		log.Fatalf("Error initializing feature flags: %v", err)
+	}
+	savers := make(map[string]func(context.Context, ObjectsToSave) error, len(names))
+	for _, name := range names {
+		backendSave := opened[name].Save
+		savers[name] = func(_ context.Context, objs ObjectsToSave) error { return backendSave(objs) }
+	}
+	// Saved as by the submit handler, concurrently if enabled
+	save := func(objs ObjectsToSave) StorageOutcomes {
+		return SaveToBackends(ctx, objs, savers, featureFlags.Enabled(FEATURE_PARALLEL_SAVE), false)
+	}
+
+	log.Infof("Verifying submissions quarantined in %s with %s", source, scheme)
+	report, err := opened[source].Quarantine.Reverify(ctx, scheme, ReplayFilter{Since: *since, Until: *until}, *dryRun, save)
+	for _, closeBackend := range closers {
+		closeBackend()
+	}
+	if err != nil {
+		log.Fatalf("Verification failed: %v", err)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, path := range report.Restored {
+			fmt.Println(path)
+		}
+		verb := "restored"
+		if *dryRun {
+			verb = "to restore"
+		}
+		fmt.Printf("Verified %d quarantined submissions: %d %s, %d still invalid, %d failed\n", report.Checked, len(report.Restored), verb, report.Invalid, report.Failed)
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
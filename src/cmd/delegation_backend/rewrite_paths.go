@@ -0,0 +1,89 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Copy submissions and blocks of an object storage to paths of another
+// layout, resuming from the progress file of an earlier run
+func rewritePaths(args []string) {
+	defaults := DefaultPathLayout()
+	flags := flag.NewFlagSet("rewrite-paths", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	backend := flags.String("backend", "", "Backend to rewrite (s3 or filesystem), s3 if configured by default")
+	submissions := flags.String("submissions", defaults.Submissions, "Path template of submissions, with the variables {network}, {day}, {time}, {submitter} and {block_hash}")
+	blocks := flags.String("blocks", defaults.Blocks, "Path template of blocks, with the variables {network} and {block_hash}")
+	hash := flags.String("hash", defaults.BlockHash, "Hash scheme of blocks in their path (blake2b or sha256), submissions are updated with the new hash")
+	progressFile := flags.String("progress", "", "File of the progress of the rewrite, resumed from if it exists [default: rewrite-paths-<backend>.json]")
+	deleteOld := flags.Bool("delete-old", false, "Delete objects of the previous layout once all were rewritten, archived as by the retention policy of the backend; paths keep resolving through the manifest")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log := logging.Logger("delegation backend rewrite-paths")
+	layout := PathLayout{Submissions: *submissions, Blocks: *blocks, BlockHash: *hash}
+	if err := layout.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	name := *backend
+	if name == "" {
+		if appCfg.Aws != nil {
+			name = "s3"
+		} else if appCfg.LocalFileSystem != nil {
+			name = "filesystem"
+		} else {
+			log.Fatal("Paths can only be rewritten in S3 or local filesystem storage")
+		}
+	}
+	if name != "s3" && name != "filesystem" {
+		log.Fatalf("Backend %q is not an object storage, set s3 or filesystem", name)
+	}
+	storage, err := openBackend(ctx, name, appCfg, secretResolver, log)
+	if err != nil {
+		log.Fatalf("Error initializing %s backend: %v", name, err)
+	}
+
+	if *progressFile == "" {
+		*progressFile = fmt.Sprintf("rewrite-paths-%s.json", name)
+	}
+	rewrite := &PathRewrite{
+		Network:      appCfg.NetworkName,
+		To:           layout,
+		Source:       storage.Source,
+		Save:         storage.Save,
+		Overwrite:    storage.Overwrite,
+		ProgressFile: *progressFile,
+		Log:          log,
+	}
+	if *deleteOld {
+		rewrite.Deleter = storage.Cleaner.(ObjectDeleter)
+	}
+	log.Infof("Rewriting paths of %s to %s, progress saved to %s", name, layout, *progressFile)
+	progress, err := rewrite.Run(ctx)
+	storage.Close()
+	if progress != nil {
+		fmt.Printf("Rewrote %d submissions (%d objects, %d bytes), %d failed, %d without block\n",
+			progress.Submissions, progress.Objects, progress.Bytes, len(progress.Failed), progress.MissingBlocks)
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Printf("Interrupted, run the command again to resume from %s\n", *progressFile)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Errorf("Rewrite failed: %v", err)
+		os.Exit(1)
+	}
+	if len(progress.Failed) > 0 {
+		os.Exit(1)
+	}
+}
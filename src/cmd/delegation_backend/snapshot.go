@@ -0,0 +1,154 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Timeout of requests to the admin API of the instance
+const SNAPSHOT_REQUEST_TIMEOUT = time.Minute
+
+const SNAPSHOT_URL_USAGE = "Base URL of the instance, whose admin API must be enabled"
+
+// Take a snapshot of the state of a running instance, saving it to object
+// storage or to a file
+func snapshot(args []string) {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	url := flags.String("url", "http://localhost"+DELEGATION_BACKEND_LISTEN_TO, SNAPSHOT_URL_USAGE)
+	backend := flags.String("backend", "", "Backend to save the snapshot to (s3 or filesystem), s3 if configured by default")
+	output := flags.String("output", "", "Write the snapshot to this file rather than to storage")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	log := logging.Logger("delegation backend snapshot")
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	bs, err := adminRequest(ctx, appCfg, secretResolver, http.MethodGet, *url, nil)
+	if err != nil {
+		log.Fatalf("Error taking snapshot: %v", err)
+	}
+	var taken ServiceSnapshot
+	if err := json.Unmarshal(bs, &taken); err != nil {
+		log.Fatalf("Error decoding snapshot: %v", err)
+	}
+	if *output != "" {
+		if err := os.WriteFile(*output, bs, 0600); err != nil {
+			log.Fatalf("Error writing snapshot: %v", err)
+		}
+	} else {
+		storage := openSnapshotStorage(ctx, *backend, appCfg, secretResolver, log)
+		err := storage.Save(ObjectsToSave{taken.Path(): bs})
+		if err == nil {
+			err = storage.Overwrite(ObjectsToSave{SNAPSHOT_LATEST: bs})
+		}
+		storage.Close()
+		if err != nil {
+			log.Fatalf("Error saving snapshot: %v", err)
+		}
+		*output = taken.Path()
+	}
+	fmt.Printf("Snapshot taken at %s saved to %s: attempts of %d submitters, replay state of %d, %d lockouts, whitelist of %d keys\n",
+		taken.TakenAt.Format(time.RFC3339), *output, len(taken.Attempts), len(taken.Replay), len(taken.Lockouts), len(taken.Whitelist))
+}
+
+// Restore a snapshot of object storage, or of a file, to a running instance
+func restore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	url := flags.String("url", "http://localhost"+DELEGATION_BACKEND_LISTEN_TO, SNAPSHOT_URL_USAGE)
+	backend := flags.String("backend", "", "Backend to read the snapshot from (s3 or filesystem), s3 if configured by default")
+	path := flags.String("snapshot", SNAPSHOT_LATEST, "Path of the snapshot in storage")
+	input := flags.String("input", "", "Read the snapshot from this file rather than from storage")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	log := logging.Logger("delegation backend restore")
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	var bs []byte
+	var err error
+	if *input != "" {
+		bs, err = os.ReadFile(*input)
+	} else {
+		storage := openSnapshotStorage(ctx, *backend, appCfg, secretResolver, log)
+		bs, err = storage.Source.Read(ctx, *path)
+		storage.Close()
+	}
+	if err != nil {
+		log.Fatalf("Error reading snapshot: %v", err)
+	}
+	res, err := adminRequest(ctx, appCfg, secretResolver, http.MethodPut, *url, bs)
+	if err != nil {
+		log.Fatalf("Error restoring snapshot: %v", err)
+	}
+	var restored SnapshotRestore
+	if err := json.Unmarshal(res, &restored); err != nil {
+		log.Fatalf("Error decoding response: %v", err)
+	}
+	fmt.Printf("Restored attempts of %d submitters, replay state of %d, %d lockouts, whitelist: %v\n",
+		restored.Attempts, restored.Replay, restored.Lockouts, restored.Whitelist)
+}
+
+// Object storage of snapshots
+func openSnapshotStorage(ctx context.Context, name string, appCfg AppConfig, secretResolver *SecretResolver, log *logging.ZapEventLogger) *storageBackend {
+	if name == "" {
+		if appCfg.Aws != nil {
+			name = "s3"
+		} else if appCfg.LocalFileSystem != nil {
+			name = "filesystem"
+		} else {
+			log.Fatal("Snapshots are kept in S3 or local filesystem storage, set -output or -input otherwise")
+		}
+	}
+	if name != "s3" && name != "filesystem" {
+		log.Fatalf("Backend %q is not an object storage, set s3 or filesystem", name)
+	}
+	storage, err := openBackend(ctx, name, appCfg, secretResolver, log)
+	if err != nil {
+		log.Fatalf("Error initializing %s backend: %v", name, err)
+	}
+	return storage
+}
+
+// Request the snapshot endpoint of the admin API with the admin token of
+// the configuration, returning the body of a successful response
+func adminRequest(ctx context.Context, appCfg AppConfig, secretResolver *SecretResolver, method string, url string, body []byte) ([]byte, error) {
+	token, err := NewSecret(secretResolver, appCfg.AdminToken)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving admin token: %w", err)
+	}
+	if token.Value() == "" {
+		return nil, fmt.Errorf("admin token isn't configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, SNAPSHOT_REQUEST_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(url, "/")+ADMIN_API_PREFIX+"snapshot", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Value())
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %s: %s", url, resp.Status, strings.TrimSpace(string(bs)))
+	}
+	return bs, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"block_producers_uptime/metadata"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Rewrite the metadata of submissions of an object storage saved with an
+// earlier version of its schema
+func upgradeMeta(args []string) {
+	flags := flag.NewFlagSet("upgrade-meta", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	backend := flags.String("backend", "", "Backend to upgrade (s3 or filesystem), s3 if configured by default")
+	since := flags.String("since", "", "Upgrade submissions of this day (YYYY-MM-DD) and later")
+	until := flags.String("until", "", "Upgrade submissions of this day (YYYY-MM-DD) and earlier")
+	dryRun := flags.Bool("dry-run", false, "Count submissions by version of their metadata without upgrading them")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log := logging.Logger("delegation backend upgrade-meta")
+	for _, day := range []string{*since, *until} {
+		if _, err := time.Parse("2006-01-02", day); day != "" && err != nil {
+			log.Fatalf("Invalid day %q, expected YYYY-MM-DD", day)
+		}
+	}
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	name := *backend
+	if name == "" {
+		if appCfg.Aws != nil {
+			name = "s3"
+		} else if appCfg.LocalFileSystem != nil {
+			name = "filesystem"
+		} else {
+			log.Fatal("Metadata is only stored as is in S3 or local filesystem storage")
+		}
+	}
+	if name != "s3" && name != "filesystem" {
+		log.Fatalf("Backend %q is not an object storage, set s3 or filesystem", name)
+	}
+	storage, err := openBackend(ctx, name, appCfg, secretResolver, log)
+	if err != nil {
+		log.Fatalf("Error initializing %s backend: %v", name, err)
+	}
+
+	upgrade := &MetaUpgrade{
+		Source:    storage.Source,
+		Overwrite: storage.Overwrite,
+		Filter:    ReplayFilter{Since: *since, Until: *until},
+		DryRun:    *dryRun,
+		Log:       log,
+	}
+	log.Infof("Upgrading metadata of %s to version %d", name, metadata.SCHEMA_VERSION)
+	result, err := upgrade.Run(ctx)
+	storage.Close()
+	if result != nil {
+		versions := make([]int, 0, len(result.Versions))
+		for version := range result.Versions {
+			versions = append(versions, version)
+		}
+		sort.Ints(versions)
+		for _, version := range versions {
+			fmt.Printf("Version %d: %d submissions\n", version, result.Versions[version])
+		}
+		verb := "Upgraded"
+		if *dryRun {
+			verb = "Would upgrade"
+		}
+		fmt.Printf("%s %d of %d submissions, %d failed\n", verb, result.Upgraded, result.Submissions, len(result.Failed))
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("Interrupted, run the command again to resume, submissions upgraded are skipped")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Errorf("Upgrade failed: %v", err)
+		os.Exit(1)
+	}
+	if len(result.Failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	. "block_producers_uptime/delegation_backend"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Check submissions and blocks of an object storage for corruption
+func verify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	configFile := flags.String("config", "", CONFIG_FLAG_USAGE)
+	backend := flags.String("backend", "", "Backend to verify (s3 or filesystem), required if both are configured")
+	since := flags.String("since", "", "Verify submissions of this day (YYYY-MM-DD) and later")
+	until := flags.String("until", "", "Verify submissions of this day (YYYY-MM-DD) and earlier")
+	noSignatures := flags.Bool("no-signatures", false, "Don't verify signatures of submissions")
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log := logging.Logger("delegation backend verify")
+	for _, day := range []string{*since, *until} {
+		if _, err := time.Parse("2006-01-02", day); day != "" && err != nil {
+			log.Fatalf("Invalid day %q, expected YYYY-MM-DD", day)
+		}
+	}
+	appCfg, secretResolver := loadResolvedConfig(ctx, *configFile, log)
+	var objectStorages []string
+	for _, name := range configuredBackends(appCfg) {
+		if name == "s3" || name == "filesystem" {
+			objectStorages = append(objectStorages, name)
+		}
+	}
+	name := *backend
+	if name == "" {
+		if len(objectStorages) != 1 {
+			log.Fatal("Set the backend to verify with -backend, s3 or filesystem")
+		}
+		name = objectStorages[0]
+	}
+	found := false
+	for _, c := range objectStorages {
+		found = found || c == name
+	}
+	if !found {
+		log.Fatalf("Backend %q is not a configured object storage, databases don't store blocks", name)
+	}
+
+	source, err := openBackend(ctx, name, appCfg, secretResolver, log)
+	if err != nil {
+		log.Fatalf("Error initializing %s backend: %v", name, err)
+	}
+	check := &IntegrityCheck{
+		Source:           source.Source,
+		Filter:           ReplayFilter{Since: *since, Until: *until},
+		VerifySignatures: !*noSignatures,
+	}
+	if check.VerifySignatures {
+		if check.SignatureScheme, err = appCfg.Signature.Scheme(appCfg.NetworkName); err != nil {
+			log.Fatalf("Error configuring the signature scheme: %v", err)
+		}
+	}
+	report, err := check.Run(ctx)
+	source.Close()
+	if err != nil {
+		log.Fatalf("Verification failed: %v", err)
+	}
+
+	if *jsonOutput {
+		bs, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(bs))
+	} else {
+		counts := make(map[string]int)
+		for _, issue := range report.Issues {
+			counts[issue.Kind]++
+			line := issue.Kind + " " + issue.Path
+			if issue.Detail != "" {
+				line += ": " + issue.Detail
+			}
+			fmt.Println(line)
+		}
+		kinds := make([]string, 0, len(counts))
+		for kind, count := range counts {
+			kinds = append(kinds, fmt.Sprintf("%d %s", count, kind))
+		}
+		sort.Strings(kinds)
+		summary := "no issues"
+		if len(kinds) > 0 {
+			summary = strings.Join(kinds, ", ")
+		}
+		fmt.Printf("Verified %d submissions and %d blocks of %s, %d without signature: %s\n", report.Submissions, report.Blocks, name, report.Unsigned, summary)
+	}
+	if len(report.Issues) > 0 {
+		os.Exit(1)
+	}
+}
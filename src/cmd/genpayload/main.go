@@ -0,0 +1,110 @@
+package main
+
+import (
+	dg "block_producers_uptime/delegation_backend"
+	"block_producers_uptime/payloadgen"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Generates /submit request bodies for a submitter, e.g. for integration
+// tests or to check the setup of a block producer against a local backend.
+//
+// Submissions are signed by the command of -sign-command, which reads the
+// hex-encoded hash to sign from its standard input (with the network id in
+// MINA_NETWORK_ID) and writes the base58check-encoded signature. With
+// -unsigned, signatures are random and the backend has to run with
+// VERIFY_SIGNATURE_DISABLED=1.
+func main() {
+	submitter := flag.String("submitter", "", "base58check public key of the submitter, random with -unsigned by default")
+	signCommand := flag.String("sign-command", "", "command signing submissions with the key of the submitter")
+	unsigned := flag.Bool("unsigned", false, "make random signatures instead of signing")
+	network := flag.String("network", "mainnet", "network name of the signatures (mainnet or another network)")
+	networkId := flag.Int("network-id", -1, "network id of the signatures, of the network name by default")
+	createdAt := flag.String("created-at", "", "created_at (RFC 3339) of the first submission, now by default")
+	interval := flag.Duration("interval", 5*time.Minute, "interval between created_at of successive submissions")
+	count := flag.Int("count", 1, "number of submissions")
+	blockSize := flag.Int("block-size", 1<<20, "size of generated blocks in bytes")
+	blockFile := flag.String("block", "", "file of the block of submissions instead of generated blocks")
+	snarkWorkSize := flag.Int("snark-work-size", 0, "size of generated snark work in bytes, none by default")
+	peerId := flag.String("peer-id", "", "peer id of submissions, random by default")
+	output := flag.String("output", "", "directory to write submissions to (payload-<n>.json), standard output (one per line) by default")
+	seed := flag.Int64("seed", 1, "seed of generated blocks, peer ids and random signatures")
+	payloadVersion := flag.Int("payload-version", 0, "version of the sign payload signed, set in payload_version unless zero (version 1 without payload_version)")
+	flag.Parse()
+	if (*signCommand == "") == !*unsigned || *count <= 0 || (*submitter == "" && !*unsigned) || *networkId > 255 {
+		fmt.Fprintln(os.Stderr, "usage: genpayload -submitter <public key> -sign-command <command> | -unsigned [-network <name> | -network-id <id>] [-created-at <time>] [-count <n>] [-block-size <bytes>] [-output <dir>]")
+		os.Exit(2)
+	}
+
+	random := rand.New(rand.NewSource(*seed))
+	opts := payloadgen.Options{NetworkId: dg.NetworkId(*network), PayloadVersion: *payloadVersion, BlockSize: *blockSize, PeerId: *peerId, Rand: random}
+	if *networkId >= 0 {
+		opts.NetworkId = uint8(*networkId)
+	}
+	if *submitter != "" {
+		if err := dg.StringToPk(&opts.Submitter, *submitter); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid submitter: %v\n", err)
+			os.Exit(2)
+		}
+	} else {
+		random.Read(opts.Submitter[:])
+	}
+	var signer payloadgen.Signer = &payloadgen.RandomSigner{Rand: random}
+	if *signCommand != "" {
+		signer = payloadgen.CommandSigner{Command: strings.Fields(*signCommand)}
+	}
+	opts.CreatedAt = time.Now()
+	if *createdAt != "" {
+		var err error
+		if opts.CreatedAt, err = time.Parse(time.RFC3339, *createdAt); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid created_at %q, expected RFC 3339\n", *createdAt)
+			os.Exit(2)
+		}
+	}
+	if *blockFile != "" {
+		var err error
+		if opts.Block, err = os.ReadFile(*blockFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if *snarkWorkSize > 0 {
+		opts.SnarkWork = make([]byte, *snarkWorkSize)
+		random.Read(opts.SnarkWork)
+	}
+	if *output != "" {
+		if err := os.MkdirAll(*output, 0755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	// Submissions of a node have the same peer id
+	if opts.PeerId == "" {
+		opts.PeerId = payloadgen.RandomPeerId(random)
+	}
+	for i := 0; i < *count; i++ {
+		payload, err := payloadgen.Generate(opts, signer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating submission: %v\n", err)
+			os.Exit(1)
+		}
+		if *output == "" {
+			fmt.Println(string(payload.Body))
+		} else {
+			path := filepath.Join(*output, fmt.Sprintf("payload-%d.json", i+1))
+			if err := os.WriteFile(path, payload.Body, 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "%s: block %s, created at %s\n", path, payload.BlockHash, opts.CreatedAt.UTC().Format(time.RFC3339))
+		}
+		opts.CreatedAt = opts.CreatedAt.Add(*interval)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	dg "block_producers_uptime/delegation_backend"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Generates submissions against a running delegation backend at a given
+// rate and reports the response codes and latencies, for capacity planning.
+//
+// Submissions are generated for random submitters with random blocks and
+// well-formed but invalid signatures, so the backend has to run with
+// VERIFY_SIGNATURE_DISABLED=1 and DELEGATION_WHITELIST_DISABLED=1. To cover
+// signature verification too, submissions signed by nodes can be replayed
+// from a directory of request files instead, with -requests.
+func main() {
+	url := flag.String("url", "http://localhost:8080/v1/submit", "submit endpoint of the backend")
+	submitters := flag.Int("submitters", 100, "number of submitters submissions are generated for")
+	blockSize := flag.Int("block-size", 1<<20, "average size of generated blocks in bytes")
+	blockSizeJitter := flag.Float64("block-size-jitter", 0.2, "variation of block sizes, as a fraction of -block-size")
+	rate := flag.Float64("rate", 10, "submissions per second")
+	duration := flag.Duration("duration", time.Minute, "duration of the test")
+	concurrency := flag.Int("concurrency", 64, "maximum number of submissions in flight")
+	requestsDir := flag.String("requests", "", "directory of signed request files (*.json) to replay instead of generating submissions")
+	seed := flag.Int64("seed", 1, "seed of generated submitters and blocks")
+	flag.Parse()
+	if *rate <= 0 || *submitters <= 0 || *concurrency <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: loadgen [-url <submit url>] [-rate <per second>] [-duration <duration>] [-submitters <count>] [-block-size <bytes>] [-requests <dir>]")
+		os.Exit(2)
+	}
+
+	var next func(i int) ([]byte, error)
+	if *requestsDir != "" {
+		requests, err := readRequests(*requestsDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		next = func(i int) ([]byte, error) { return requests[i%len(requests)], nil }
+		fmt.Printf("Replaying %d signed requests from %s\n", len(requests), *requestsDir)
+	} else {
+		g := newGenerator(*seed, *submitters, *blockSize, *blockSizeJitter)
+		next = g.next
+		fmt.Printf("Generating submissions of %d submitters, blocks of %d bytes on average\n", *submitters, *blockSize)
+	}
+
+	stats := newStats()
+	client := &http.Client{Timeout: time.Minute, Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency}}
+	slots := make(chan struct{}, *concurrency)
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	defer ticker.Stop()
+	progress := time.NewTicker(10 * time.Second)
+	defer progress.Stop()
+	start := time.Now()
+	fmt.Printf("Submitting to %s at %.1f/s for %v\n", *url, *rate, *duration)
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			stats.report(time.Since(start))
+			if stats.failed() {
+				os.Exit(1)
+			}
+			return
+		case <-progress.C:
+			stats.report(time.Since(start))
+		case <-ticker.C:
+			body, err := next(i)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			select {
+			case slots <- struct{}{}:
+			default:
+				// The backend doesn't keep up, the submission is not sent
+				stats.record("dropped (concurrency)", 0)
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				sent := time.Now()
+				resp, err := client.Post(*url, "application/json", bytes.NewReader(body))
+				if err != nil {
+					stats.record("error", time.Since(sent))
+					return
+				}
+				resp.Body.Close()
+				stats.record(fmt.Sprintf("%d", resp.StatusCode), time.Since(sent))
+			}()
+		}
+	}
+}
+
+func readRequests(directory string) ([][]byte, error) {
+	paths, err := filepath.Glob(filepath.Join(directory, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no request files in %s", directory)
+	}
+	requests := make([][]byte, 0, len(paths))
+	for _, path := range paths {
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, bs)
+	}
+	return requests, nil
+}
+
+type submitRequest struct {
+	Data struct {
+		Block     string    `json:"block"`
+		CreatedAt time.Time `json:"created_at"`
+		PeerId    string    `json:"peer_id"`
+	} `json:"data"`
+	Submitter dg.Pk  `json:"submitter"`
+	Sig       dg.Sig `json:"signature"`
+}
+
+type generator struct {
+	mutex      sync.Mutex
+	rand       *rand.Rand
+	submitters []dg.Pk
+	peerIds    []string
+	blockSize  int
+	jitter     float64
+}
+
+func newGenerator(seed int64, submitters int, blockSize int, jitter float64) *generator {
+	g := &generator{rand: rand.New(rand.NewSource(seed)), blockSize: blockSize, jitter: jitter}
+	for i := 0; i < submitters; i++ {
+		var pk dg.Pk
+		g.rand.Read(pk[:])
+		peerId := make([]byte, 30)
+		g.rand.Read(peerId)
+		g.submitters = append(g.submitters, pk)
+		g.peerIds = append(g.peerIds, base64.StdEncoding.EncodeToString(peerId))
+	}
+	return g
+}
+
+// Submission of the next submitter in turn, created now
+func (g *generator) next(i int) ([]byte, error) {
+	g.mutex.Lock()
+	size := g.blockSize + int(float64(g.blockSize)*g.jitter*(2*g.rand.Float64()-1))
+	block := make([]byte, max(size, 1))
+	g.rand.Read(block)
+	var req submitRequest
+	g.rand.Read(req.Sig[:])
+	g.mutex.Unlock()
+	req.Submitter = g.submitters[i%len(g.submitters)]
+	req.Data.Block = base64.StdEncoding.EncodeToString(block)
+	req.Data.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	req.Data.PeerId = g.peerIds[i%len(g.peerIds)]
+	return json.Marshal(req)
+}
+
+type stats struct {
+	mutex     sync.Mutex
+	codes     map[string]int
+	latencies []time.Duration
+}
+
+func newStats() *stats {
+	return &stats{codes: make(map[string]int)}
+}
+
+func (s *stats) record(code string, latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.codes[code]++
+	if latency > 0 {
+		s.latencies = append(s.latencies, latency)
+	}
+}
+
+// Whether any submission wasn't accepted
+func (s *stats) failed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for code, count := range s.codes {
+		if code != "200" && count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *stats) report(elapsed time.Duration) {
+	s.mutex.Lock()
+	latencies := append([]time.Duration(nil), s.latencies...)
+	var codes []string
+	total := 0
+	for code, count := range s.codes {
+		codes = append(codes, fmt.Sprintf("%s: %d", code, count))
+		total += count
+	}
+	s.mutex.Unlock()
+	sort.Strings(codes)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	fmt.Printf("[%v] %d submissions (%.1f/s), %s, latency p50 %v p90 %v p99 %v max %v\n",
+		elapsed.Truncate(time.Second), total, float64(total)/elapsed.Seconds(), strings.Join(codes, ", "),
+		percentile(0.5), percentile(0.9), percentile(0.99), percentile(1))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	dg "block_producers_uptime/delegation_backend"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Verifies a receipt returned by the delegation backend on submission.
+// The receipt is read either from the file given as argument or from stdin,
+// both a bare receipt and the full `/v1/submit` response are accepted.
+func main() {
+	keyFile := flag.String("key", "", "path to the receipt signing key file of the backend")
+	flag.Parse()
+	if *keyFile == "" {
+		fmt.Fprintln(os.Stderr, "usage: verify_receipt -key <key file> [receipt.json]")
+		os.Exit(2)
+	}
+	key, err := dg.LoadReceiptSigningKey(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var input io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		input = f
+	}
+	var doc struct {
+		dg.Receipt
+		Nested *dg.Receipt `json:"receipt"`
+	}
+	if err := json.NewDecoder(input).Decode(&doc); err != nil {
+		fmt.Fprintf(os.Stderr, "Error decoding receipt: %v\n", err)
+		os.Exit(2)
+	}
+	receipt := &doc.Receipt
+	if doc.Nested != nil {
+		receipt = doc.Nested
+	}
+
+	if !dg.VerifyReceipt(receipt, key) {
+		fmt.Println("INVALID receipt")
+		os.Exit(1)
+	}
+	fmt.Printf("VALID receipt: submitter %s submitted block %s at %s (submission %s)\n",
+		receipt.Submitter, receipt.BlockHash, receipt.ReceivedAt, receipt.SubmissionId)
+}
@@ -0,0 +1,263 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the access log
+const (
+	ACCESS_LOG_FORMAT_COMMON   = "common"
+	ACCESS_LOG_FORMAT_COMBINED = "combined"
+	ACCESS_LOG_FORMAT_JSON     = "json"
+)
+
+// Defaults of rotating the access log file
+const (
+	ACCESS_LOG_DEFAULT_MAX_SIZE_MB = 100
+	ACCESS_LOG_DEFAULT_MAX_BACKUPS = 5
+)
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogEntry describes a single HTTP request
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	LatencyMs  float64   `json:"latency_ms"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	// Submitter of a parsed submission
+	Submitter string `json:"submitter,omitempty"`
+}
+
+type accessLogContextKey struct{}
+
+// Records the submitter of the request in its access log entry,
+// if the request is access logged.
+func setAccessLogSubmitter(ctx context.Context, submitter string) {
+	if entry, ok := ctx.Value(accessLogContextKey{}).(*AccessLogEntry); ok {
+		entry.Submitter = submitter
+	}
+}
+
+type accessLogRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *accessLogRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *accessLogRecorder) Write(bs []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(bs)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection
+func (rec *accessLogRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// AccessLog writes a line for every HTTP request, in the Common or
+// Combined Log Format or as JSON, separately from application logs.
+type AccessLog struct {
+	mutex            sync.Mutex
+	out              io.Writer
+	format           string
+	now              nowFunc
+	ClientIPResolver *ClientIPResolver
+}
+
+func NewAccessLog(out io.Writer, format string, now nowFunc) (*AccessLog, error) {
+	if format == "" {
+		format = ACCESS_LOG_FORMAT_COMBINED
+	}
+	switch format {
+	case ACCESS_LOG_FORMAT_COMMON, ACCESS_LOG_FORMAT_COMBINED, ACCESS_LOG_FORMAT_JSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q, expected common, combined or json", format)
+	}
+	return &AccessLog{out: out, format: format, now: now}, nil
+}
+
+// NewAccessLogFromConfig creates the access log writing to stdout
+// or to the configured file, rotated by size.
+func NewAccessLogFromConfig(cfg *AccessLogConfig, now nowFunc) (*AccessLog, error) {
+	var out io.Writer = os.Stdout
+	if cfg.File != "" {
+		maxSizeMB, maxBackups := cfg.MaxSizeMB, cfg.MaxBackups
+		if maxSizeMB <= 0 {
+			maxSizeMB = ACCESS_LOG_DEFAULT_MAX_SIZE_MB
+		}
+		if maxBackups <= 0 {
+			maxBackups = ACCESS_LOG_DEFAULT_MAX_BACKUPS
+		}
+		file, err := NewRotatingFile(cfg.File, int64(maxSizeMB)<<20, maxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("error opening access log file: %w", err)
+		}
+		out = file
+	}
+	return NewAccessLog(out, cfg.Format, now)
+}
+
+func (a *AccessLog) Format() string {
+	return a.format
+}
+
+func clfField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func clfQuoted(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// Format the entry as a line of the access log. The submitter is logged
+// as the authenticated user of the Common and Combined Log Formats,
+// latency is only logged in JSON.
+func (a *AccessLog) formatEntry(e *AccessLogEntry) []byte {
+	if a.format == ACCESS_LOG_FORMAT_JSON {
+		bs, _ := json.Marshal(e)
+		return append(bs, '\n')
+	}
+	bytesStr := "-"
+	if e.Bytes > 0 {
+		bytesStr = fmt.Sprint(e.Bytes)
+	}
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		clfField(hostOf(e.RemoteAddr)), clfField(e.Submitter), e.Time.Format(clfTimeFormat),
+		e.Method, clfQuoted(e.Path), e.Proto, e.Status, bytesStr)
+	if a.format == ACCESS_LOG_FORMAT_COMBINED {
+		line += fmt.Sprintf(" \"%s\" \"%s\"", clfQuoted(clfField(e.Referer)), clfQuoted(clfField(e.UserAgent)))
+	}
+	return []byte(line + "\n")
+}
+
+func (a *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		entry := &AccessLogEntry{
+			Time:       a.now(),
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Proto:      r.Proto,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		}
+		if a.ClientIPResolver != nil {
+			entry.RemoteAddr = a.ClientIPResolver.ClientIP(r)
+		}
+		rec := &accessLogRecorder{ResponseWriter: rw}
+		defer func() {
+			entry.LatencyMs = float64(a.now().Sub(entry.Time).Microseconds()) / 1000
+			entry.Status = rec.status
+			if entry.Status == 0 {
+				entry.Status = http.StatusOK
+			}
+			entry.Bytes = rec.bytes
+			line := a.formatEntry(entry)
+			a.mutex.Lock()
+			_, err := a.out.Write(line)
+			a.mutex.Unlock()
+			if err != nil {
+				incMetric("access_log_write_errors")
+			}
+		}()
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessLogContextKey{}, entry)))
+	})
+}
+
+// RotatingFile is a file rotated once it exceeds the maximum size,
+// keeping up to maxBackups previous files as `<path>.1` (the newest)
+// to `<path>.<maxBackups>`.
+type RotatingFile struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Rotate the file, a file is reopened even if renaming fails
+// so that writes continue.
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+	var err error
+	for i := f.maxBackups - 1; i >= 1 && err == nil; i-- {
+		err = os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err == nil && f.maxBackups > 0 {
+		err = os.Rename(f.path, f.path+".1")
+	} else if err == nil {
+		err = os.Remove(f.path)
+	}
+	if openErr := f.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (f *RotatingFile) Write(bs []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.size > 0 && f.size+int64(len(bs)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, fmt.Errorf("error rotating %s: %w", f.path, err)
+		}
+	}
+	n, err := f.file.Write(bs)
+	f.size += int64(n)
+	return n, err
+}
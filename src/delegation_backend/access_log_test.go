@@ -0,0 +1,87 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	tm := &timeMock{time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		setAccessLogSubmitter(r.Context(), "B62q")
+		rw.WriteHeader(401)
+		_, _ = rw.Write([]byte("unauthorized"))
+	})
+	for format, expected := range map[string]string{
+		ACCESS_LOG_FORMAT_COMMON:   `192.0.2.1 - B62q [01/May/2024:12:00:00 +0000] "POST /v1/submit?x=1 HTTP/1.1" 401 12` + "\n",
+		ACCESS_LOG_FORMAT_COMBINED: `192.0.2.1 - B62q [01/May/2024:12:00:00 +0000] "POST /v1/submit?x=1 HTTP/1.1" 401 12 "-" "mina \"daemon\""` + "\n",
+	} {
+		var out bytes.Buffer
+		accessLog, err := NewAccessLog(&out, format, tm.Now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", "/v1/submit?x=1", nil)
+		req.Header.Set("User-Agent", `mina "daemon"`)
+		accessLog.Middleware(handler).ServeHTTP(httptest.NewRecorder(), req)
+		if out.String() != expected {
+			t.Fatalf("unexpected %s line: %q", format, out.String())
+		}
+	}
+
+	if _, err := NewAccessLog(&bytes.Buffer{}, "xml", tm.Now); err == nil {
+		t.Fatal("expected unknown format refused")
+	}
+}
+
+func TestAccessLogSubmission(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, tm := testSubmitH(10, Whitelist{req.Submitter: true})
+	var out bytes.Buffer
+	accessLog, _ := NewAccessLog(&out, ACCESS_LOG_FORMAT_JSON, tm.Now)
+	rr := httptest.NewRecorder()
+	accessLog.Middleware(sh).ServeHTTP(rr, httptest.NewRequest("POST", v1Submit, bytes.NewReader(body)))
+	if rr.Code != 200 {
+		t.Fatalf("expected submission accepted, got %v", rr)
+	}
+	var entry AccessLogEntry
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Status != 200 || entry.Method != "POST" || entry.Path != "/v1/submit" || entry.Submitter != req.Submitter.String() ||
+		entry.Bytes != int64(rr.Body.Len()) {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := NewRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for suffix, expected := range map[string]string{"": "fourth\n", ".1": "third\n", ".2": "second\n"} {
+		bs, err := os.ReadFile(path + suffix)
+		if err != nil || string(bs) != expected {
+			t.Fatalf("unexpected content of %s%s: %q, %v", path, suffix, bs, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("expected only 2 backups kept")
+	}
+}
@@ -0,0 +1,46 @@
+package delegation_backend
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Prefix of all administrative endpoints
+const ADMIN_API_PREFIX = "/admin/"
+
+// Extract a bearer token from the Authorization header.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// AdminAuth guards the handler with the admin token,
+// which must be provided as a bearer token.
+func AdminAuth(adminToken string, next http.Handler) http.Handler {
+	return AdminAuthFunc(func() string { return adminToken }, next)
+}
+
+// AdminAuthFunc is AdminAuth with the token looked up on every request,
+// so that the token can be rotated.
+func AdminAuthFunc(getAdminToken func() string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		adminToken := getAdminToken()
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			writeJSON(rw, http.StatusUnauthorized, errorResponse{"Invalid admin token"})
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
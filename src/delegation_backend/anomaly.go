@@ -0,0 +1,333 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Storage prefix under which anomaly findings are saved
+const ABUSE_PREFIX = "abuse/"
+
+// Action taken on a submission flagged by an anomaly detector,
+// ordered from the most to the least permissive.
+type AnomalyAction int
+
+const (
+	ANOMALY_ACTION_NONE AnomalyAction = iota
+	// Submission is accepted, the finding is only recorded
+	ANOMALY_ACTION_SHADOW
+	// Submission is rejected with 429
+	ANOMALY_ACTION_THROTTLE
+	// Submission is rejected with 403
+	ANOMALY_ACTION_BLOCK
+)
+
+func (a AnomalyAction) String() string {
+	switch a {
+	case ANOMALY_ACTION_SHADOW:
+		return "shadow"
+	case ANOMALY_ACTION_THROTTLE:
+		return "throttle"
+	case ANOMALY_ACTION_BLOCK:
+		return "block"
+	}
+	return "none"
+}
+
+func (a AnomalyAction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+func (a *AnomalyAction) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return err
+	}
+	if s == "none" {
+		*a = ANOMALY_ACTION_NONE
+		return nil
+	}
+	action, err := ParseAnomalyAction(s)
+	*a = action
+	return err
+}
+
+func ParseAnomalyAction(s string) (AnomalyAction, error) {
+	switch strings.ToLower(s) {
+	case "", "shadow":
+		return ANOMALY_ACTION_SHADOW, nil
+	case "throttle":
+		return ANOMALY_ACTION_THROTTLE, nil
+	case "block":
+		return ANOMALY_ACTION_BLOCK, nil
+	}
+	return ANOMALY_ACTION_NONE, fmt.Errorf("unknown anomaly action %q, expected shadow, throttle or block", s)
+}
+
+// Observation is what detectors learn about an authenticated submission
+type Observation struct {
+	Submitter  Pk
+	RemoteAddr string
+	BlockHash  string
+	At         time.Time
+}
+
+// AnomalyDetector inspects submissions one by one. Observe records the
+// submission and returns a non-empty subject (the IP, block or key the
+// suspicious pattern is about) with details if the pattern is detected.
+type AnomalyDetector interface {
+	Name() string
+	Observe(obs Observation) (subject string, details string)
+}
+
+// Finding is a record of a detected anomaly, saved to storage as JSON
+type Finding struct {
+	Detector   string        `json:"detector"`
+	Subject    string        `json:"subject"`
+	Details    string        `json:"details"`
+	Action     AnomalyAction `json:"action"`
+	Submitter  string        `json:"submitter"`
+	RemoteAddr string        `json:"remote_addr"`
+	BlockHash  string        `json:"block_hash"`
+	DetectedAt time.Time     `json:"detected_at"`
+}
+
+func (f *Finding) path() string {
+	detectedAt := f.DetectedAt.UTC().Format(time.RFC3339)
+	subject := strings.NewReplacer("/", "_", ":", "_").Replace(f.Subject)
+	return ABUSE_PREFIX + detectedAt[:10] + "/" + detectedAt + "-" + f.Detector + "-" + subject + ".json"
+}
+
+type anomalyRule struct {
+	detector AnomalyDetector
+	action   AnomalyAction
+	// Findings are recorded once per window for the same subject
+	window   time.Duration
+	reported map[string]time.Time
+}
+
+// AnomalyMonitor runs the detectors on every submission, saves the
+// findings and decides on the action to take on the submission.
+type AnomalyMonitor struct {
+	mutex sync.Mutex
+	rules []*anomalyRule
+	Save  func(ObjectsToSave)
+	Log   logging.StandardLogger
+}
+
+func NewAnomalyMonitor(save func(ObjectsToSave), log logging.StandardLogger) *AnomalyMonitor {
+	return &AnomalyMonitor{Save: save, Log: log}
+}
+
+// AddDetector registers a detector, findings of the same subject are
+// recorded at most once per window.
+func (m *AnomalyMonitor) AddDetector(detector AnomalyDetector, action AnomalyAction, window time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rules = append(m.rules, &anomalyRule{
+		detector: detector,
+		action:   action,
+		window:   window,
+		reported: make(map[string]time.Time),
+	})
+}
+
+func (m *AnomalyMonitor) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.rules)
+}
+
+// Check runs all detectors on the observation and returns
+// the strictest action of the detectors which fired.
+func (m *AnomalyMonitor) Check(obs Observation) AnomalyAction {
+	m.mutex.Lock()
+	action := ANOMALY_ACTION_NONE
+	toSave := make(ObjectsToSave)
+	for _, rule := range m.rules {
+		subject, details := rule.detector.Observe(obs)
+		if subject == "" {
+			continue
+		}
+		if rule.action > action {
+			action = rule.action
+		}
+		for s, at := range rule.reported {
+			if obs.At.Sub(at) >= rule.window {
+				delete(rule.reported, s)
+			}
+		}
+		if _, reported := rule.reported[subject]; reported {
+			continue
+		}
+		rule.reported[subject] = obs.At
+		finding := Finding{
+			Detector:   rule.detector.Name(),
+			Subject:    subject,
+			Details:    details,
+			Action:     rule.action,
+			Submitter:  obs.Submitter.String(),
+			RemoteAddr: obs.RemoteAddr,
+			BlockHash:  obs.BlockHash,
+			DetectedAt: obs.At.UTC(),
+		}
+		m.Log.Warnf("Anomaly detected by %s (action: %s): %s", finding.Detector, finding.Action, details)
+		bs, err := json.Marshal(finding)
+		if err != nil {
+			m.Log.Errorf("Error marshaling anomaly finding: %v", err)
+			continue
+		}
+		toSave[finding.path()] = bs
+	}
+	m.mutex.Unlock()
+	if len(toSave) > 0 && m.Save != nil {
+		m.Save(toSave)
+	}
+	return action
+}
+
+type windowEvent struct {
+	value string
+	at    time.Time
+}
+
+// windowEvents keeps events grouped by key for the duration of the window
+type windowEvents struct {
+	window    time.Duration
+	events    map[string][]windowEvent
+	lastSweep time.Time
+}
+
+func newWindowEvents(window time.Duration) windowEvents {
+	return windowEvents{window: window, events: make(map[string][]windowEvent)}
+}
+
+// add records the event and returns events of the key within the window
+func (w *windowEvents) add(key, value string, at time.Time) []windowEvent {
+	if at.Sub(w.lastSweep) >= w.window {
+		for k, evs := range w.events {
+			if len(evs) == 0 || at.Sub(evs[len(evs)-1].at) >= w.window {
+				delete(w.events, k)
+			}
+		}
+		w.lastSweep = at
+	}
+	evs := w.events[key]
+	i := 0
+	for i < len(evs) && at.Sub(evs[i].at) >= w.window {
+		i++
+	}
+	evs = append(evs[i:], windowEvent{value, at})
+	w.events[key] = evs
+	return evs
+}
+
+func distinctValues(evs []windowEvent) int {
+	seen := make(map[string]struct{}, len(evs))
+	for _, ev := range evs {
+		seen[ev.value] = struct{}{}
+	}
+	return len(seen)
+}
+
+// IPFanoutDetector flags an IP submitting for more than
+// MaxSubmitters distinct keys within the window.
+type IPFanoutDetector struct {
+	MaxSubmitters int
+	events        windowEvents
+}
+
+func NewIPFanoutDetector(maxSubmitters int, window time.Duration) *IPFanoutDetector {
+	return &IPFanoutDetector{MaxSubmitters: maxSubmitters, events: newWindowEvents(window)}
+}
+
+func (d *IPFanoutDetector) Name() string { return "ip_fanout" }
+
+func (d *IPFanoutDetector) Observe(obs Observation) (string, string) {
+	ip := hostOf(obs.RemoteAddr)
+	n := distinctValues(d.events.add(ip, obs.Submitter.String(), obs.At))
+	if n > d.MaxSubmitters {
+		return ip, fmt.Sprintf("IP %s submitted for %d distinct keys within %v", ip, n, d.events.window)
+	}
+	return "", ""
+}
+
+// SharedBlockDetector flags a block submitted by more than
+// MaxSubmitters distinct keys within the window.
+type SharedBlockDetector struct {
+	MaxSubmitters int
+	events        windowEvents
+}
+
+func NewSharedBlockDetector(maxSubmitters int, window time.Duration) *SharedBlockDetector {
+	return &SharedBlockDetector{MaxSubmitters: maxSubmitters, events: newWindowEvents(window)}
+}
+
+func (d *SharedBlockDetector) Name() string { return "shared_block" }
+
+func (d *SharedBlockDetector) Observe(obs Observation) (string, string) {
+	n := distinctValues(d.events.add(obs.BlockHash, obs.Submitter.String(), obs.At))
+	if n > d.MaxSubmitters {
+		return obs.BlockHash, fmt.Sprintf("Block %s submitted by %d distinct keys within %v", obs.BlockHash, n, d.events.window)
+	}
+	return "", ""
+}
+
+// RateSpikeDetector flags a key making more than
+// MaxSubmissions submissions within the window.
+type RateSpikeDetector struct {
+	MaxSubmissions int
+	events         windowEvents
+}
+
+func NewRateSpikeDetector(maxSubmissions int, window time.Duration) *RateSpikeDetector {
+	return &RateSpikeDetector{MaxSubmissions: maxSubmissions, events: newWindowEvents(window)}
+}
+
+func (d *RateSpikeDetector) Name() string { return "rate_spike" }
+
+func (d *RateSpikeDetector) Observe(obs Observation) (string, string) {
+	submitter := obs.Submitter.String()
+	n := len(d.events.add(submitter, "", obs.At))
+	if n > d.MaxSubmissions {
+		return submitter, fmt.Sprintf("Key %s made %d submissions within %v", submitter, n, d.events.window)
+	}
+	return "", ""
+}
+
+// Window of the anomaly detectors if not configured
+const ANOMALY_DEFAULT_WINDOW = time.Hour
+
+// NewAnomalyMonitorFromConfig creates a monitor with the detectors
+// enabled in the configuration.
+func NewAnomalyMonitorFromConfig(cfg *AnomalyDetectionConfig, save func(ObjectsToSave), log logging.StandardLogger) (*AnomalyMonitor, error) {
+	m := NewAnomalyMonitor(save, log)
+	rules := []struct {
+		cfg *AnomalyRuleConfig
+		mk  func(threshold int, window time.Duration) AnomalyDetector
+	}{
+		{cfg.IPFanout, func(t int, w time.Duration) AnomalyDetector { return NewIPFanoutDetector(t, w) }},
+		{cfg.SharedBlock, func(t int, w time.Duration) AnomalyDetector { return NewSharedBlockDetector(t, w) }},
+		{cfg.RateSpike, func(t int, w time.Duration) AnomalyDetector { return NewRateSpikeDetector(t, w) }},
+	}
+	for _, rule := range rules {
+		if rule.cfg == nil || rule.cfg.Threshold <= 0 {
+			continue
+		}
+		action, err := ParseAnomalyAction(rule.cfg.Action)
+		if err != nil {
+			return nil, err
+		}
+		window := ANOMALY_DEFAULT_WINDOW
+		if rule.cfg.WindowMinutes > 0 {
+			window = time.Duration(rule.cfg.WindowMinutes) * time.Minute
+		}
+		m.AddDetector(rule.mk(rule.cfg.Threshold, window), action, window)
+	}
+	return m, nil
+}
@@ -0,0 +1,103 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func testAnomalyMonitor() (*AnomalyMonitor, ObjectsToSave) {
+	findings := make(ObjectsToSave)
+	m := NewAnomalyMonitor(func(objs ObjectsToSave) {
+		for path, bs := range objs {
+			findings[path] = bs
+		}
+	}, logging.Logger("anomaly test"))
+	return m, findings
+}
+
+func TestIPFanout(t *testing.T) {
+	m, findings := testAnomalyMonitor()
+	m.AddDetector(NewIPFanoutDetector(2, time.Hour), ANOMALY_ACTION_SHADOW, time.Hour)
+	now := time.Now()
+	obs := Observation{RemoteAddr: "10.0.0.1:1234", BlockHash: "b", At: now}
+	for i := 0; i < 2; i++ {
+		obs.Submitter = mkPk()
+		if m.Check(obs) != ANOMALY_ACTION_NONE {
+			t.Fatalf("flagged submission %d", i)
+		}
+	}
+	// Same keys again are not counted twice, a third one is
+	if m.Check(obs) != ANOMALY_ACTION_NONE {
+		t.Fatal("flagged repeated key")
+	}
+	obs.Submitter = mkPk()
+	obs.RemoteAddr = "10.0.0.1:4321"
+	if m.Check(obs) != ANOMALY_ACTION_SHADOW {
+		t.Fatal("fanout not flagged")
+	}
+	if len(findings) != 1 {
+		t.Fatalf("expected one finding, got %d", len(findings))
+	}
+	for path, bs := range findings {
+		var f Finding
+		if err := json.Unmarshal(bs, &f); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(path, ABUSE_PREFIX) || f.Detector != "ip_fanout" || f.Subject != "10.0.0.1" {
+			t.Fatalf("unexpected finding %s: %s", path, bs)
+		}
+	}
+	// Already reported within the window
+	obs.Submitter = mkPk()
+	m.Check(obs)
+	if len(findings) != 1 {
+		t.Fatal("finding recorded twice within the window")
+	}
+	// Window passed
+	obs.At = now.Add(2 * time.Hour)
+	if m.Check(obs) != ANOMALY_ACTION_NONE {
+		t.Fatal("flagged after window passed")
+	}
+}
+
+func TestRateSpikeStrictestAction(t *testing.T) {
+	m, _ := testAnomalyMonitor()
+	m.AddDetector(NewSharedBlockDetector(1, time.Hour), ANOMALY_ACTION_SHADOW, time.Hour)
+	m.AddDetector(NewRateSpikeDetector(2, time.Minute), ANOMALY_ACTION_BLOCK, time.Minute)
+	pk := mkPk()
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if m.Check(Observation{Submitter: pk, BlockHash: "b", At: now}) != ANOMALY_ACTION_NONE {
+			t.Fatalf("flagged submission %d", i)
+		}
+	}
+	if m.Check(Observation{Submitter: mkPk(), BlockHash: "b", At: now}) != ANOMALY_ACTION_SHADOW {
+		t.Fatal("shared block not flagged")
+	}
+	if m.Check(Observation{Submitter: pk, BlockHash: "b", At: now}) != ANOMALY_ACTION_BLOCK {
+		t.Fatal("rate spike not blocked")
+	}
+	if m.Check(Observation{Submitter: pk, BlockHash: "c", At: now.Add(time.Minute)}) != ANOMALY_ACTION_NONE {
+		t.Fatal("flagged after window passed")
+	}
+}
+
+func TestSubmitThrottledByAnomaly(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	storage, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	m, findings := testAnomalyMonitor()
+	m.AddDetector(NewRateSpikeDetector(0, time.Hour), ANOMALY_ACTION_THROTTLE, time.Hour)
+	sh.app.AnomalyMonitor = m
+	rep := sh.testRequest(body)
+	if rep.Code != 429 || len(*storage) != 0 || len(findings) != 1 {
+		t.Fatalf("submission not throttled: %v, stored: %d, findings: %d", rep, len(*storage), len(findings))
+	}
+}
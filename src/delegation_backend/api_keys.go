@@ -0,0 +1,336 @@
+package delegation_backend
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Scopes which can be granted to an API key
+const (
+	SCOPE_READ = "read"
+)
+
+var API_KEY_SCOPES = []string{SCOPE_READ}
+
+const API_KEY_PREFIX = "uk_"
+
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// APIKey is the stored representation of an API key. The key itself is
+// never stored, only its SHA-256 hash.
+type APIKey struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"-"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (key *APIKey) HasScope(scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type APIKeyStore interface {
+	Insert(key *APIKey) error
+	// Returns ErrAPIKeyNotFound if there is no key with the hash
+	Lookup(hash string) (*APIKey, error)
+	List() ([]APIKey, error)
+	// Returns ErrAPIKeyNotFound if there is no active key with the id
+	Revoke(id string, at time.Time) error
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(n int) (string, error) {
+	bs := make([]byte, n)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+// APIKeys issues, revokes and authenticates API keys.
+type APIKeys struct {
+	Store APIKeyStore
+	Now   nowFunc
+}
+
+// Issue creates a new key with the given scopes. The returned string
+// is the key itself, it can't be recovered later.
+func (keys *APIKeys) Issue(name string, scopes []string) (*APIKey, string, error) {
+	for _, scope := range scopes {
+		known := false
+		for _, s := range API_KEY_SCOPES {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("at least one scope is required")
+	}
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, "", err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	key := &APIKey{
+		Id:        hex.EncodeToString(idBytes),
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: keys.Now().UTC(),
+	}
+	raw := API_KEY_PREFIX + key.Id + "_" + secret
+	key.Hash = hashAPIKey(raw)
+	if err := keys.Store.Insert(key); err != nil {
+		return nil, "", err
+	}
+	return key, raw, nil
+}
+
+func (keys *APIKeys) Revoke(id string) error {
+	return keys.Store.Revoke(id, keys.Now().UTC())
+}
+
+// Authenticate returns the active key matching raw if it has the scope.
+func (keys *APIKeys) Authenticate(raw string, scope string) (*APIKey, error) {
+	if !strings.HasPrefix(raw, API_KEY_PREFIX) {
+		return nil, ErrAPIKeyNotFound
+	}
+	key, err := keys.Store.Lookup(hashAPIKey(raw))
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	if !key.HasScope(scope) {
+		return nil, fmt.Errorf("API key %s lacks scope %s", key.Id, scope)
+	}
+	return key, nil
+}
+
+// RequireAPIKey guards the handler with an API key having the scope.
+// The key is accepted as a bearer token or in the X-API-Key header.
+func (keys *APIKeys) RequireAPIKey(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		raw := r.Header.Get("X-API-Key")
+		if raw == "" {
+			raw = bearerToken(r)
+		}
+		if raw == "" {
+			writeJSON(rw, http.StatusUnauthorized, errorResponse{"API key required"})
+			return
+		}
+		_, err := keys.Authenticate(raw, scope)
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			writeJSON(rw, http.StatusUnauthorized, errorResponse{"Invalid API key"})
+			return
+		}
+		if err != nil {
+			writeJSON(rw, http.StatusForbidden, errorResponse{"API key lacks scope " + scope})
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+type issueAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type issueAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// AdminHandler serves the administration of API keys:
+//
+//	GET    /admin/api-keys       lists keys
+//	POST   /admin/api-keys       issues a key, body: {"name": "...", "scopes": ["read"]}
+//	DELETE /admin/api-keys/<id>  revokes a key
+func (keys *APIKeys) AdminHandler() http.Handler {
+	const path = ADMIN_API_PREFIX + "api-keys"
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, path), "/")
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			list, err := keys.Store.List()
+			if err != nil {
+				writeJSON(rw, http.StatusInternalServerError, errorResponse{err.Error()})
+				return
+			}
+			writeJSON(rw, http.StatusOK, list)
+		case r.Method == http.MethodPost && id == "":
+			var req issueAPIKeyRequest
+			if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 1<<16)).Decode(&req); err != nil {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{"Error decoding payload"})
+				return
+			}
+			key, raw, err := keys.Issue(req.Name, req.Scopes)
+			if err != nil {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{err.Error()})
+				return
+			}
+			writeJSON(rw, http.StatusCreated, issueAPIKeyResponse{*key, raw})
+		case r.Method == http.MethodDelete && id != "":
+			err := keys.Revoke(id)
+			if errors.Is(err, ErrAPIKeyNotFound) {
+				writeJSON(rw, http.StatusNotFound, errorResponse{err.Error()})
+				return
+			}
+			if err != nil {
+				writeJSON(rw, http.StatusInternalServerError, errorResponse{err.Error()})
+				return
+			}
+			writeJSON(rw, http.StatusOK, HealthStatus{Status: "ok"})
+		default:
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+		}
+	})
+}
+
+// MemoryAPIKeyStore keeps keys in memory, used when no database is configured.
+type MemoryAPIKeyStore struct {
+	mutex sync.RWMutex
+	keys  []*APIKey
+}
+
+func (store *MemoryAPIKeyStore) Insert(key *APIKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.keys = append(store.keys, key)
+	return nil
+}
+
+func (store *MemoryAPIKeyStore) Lookup(hash string) (*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	for _, key := range store.keys {
+		if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) == 1 {
+			k := *key
+			return &k, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+func (store *MemoryAPIKeyStore) List() ([]APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	res := make([]APIKey, 0, len(store.keys))
+	for _, key := range store.keys {
+		res = append(res, *key)
+	}
+	return res, nil
+}
+
+func (store *MemoryAPIKeyStore) Revoke(id string, at time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, key := range store.keys {
+		if key.Id == id && key.RevokedAt == nil {
+			key.RevokedAt = &at
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+// PostgreSQLAPIKeyStore keeps keys in the `api_keys` table.
+type PostgreSQLAPIKeyStore struct {
+	DB *sql.DB
+}
+
+func (store *PostgreSQLAPIKeyStore) CreateTableIfNotExists() error {
+	_, err := store.DB.Exec(`CREATE TABLE IF NOT EXISTS api_keys (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				key_hash TEXT NOT NULL UNIQUE,
+				scopes TEXT[] NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL,
+				revoked_at TIMESTAMPTZ)`)
+	return err
+}
+
+func (store *PostgreSQLAPIKeyStore) Insert(key *APIKey) error {
+	_, err := store.DB.Exec(`INSERT INTO api_keys (id, name, key_hash, scopes, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		key.Id, key.Name, key.Hash, pq.Array(key.Scopes), key.CreatedAt)
+	return err
+}
+
+func (store *PostgreSQLAPIKeyStore) Lookup(hash string) (*APIKey, error) {
+	var key APIKey
+	var revokedAt sql.NullTime
+	err := store.DB.QueryRow(`SELECT id, name, key_hash, scopes, created_at, revoked_at FROM api_keys WHERE key_hash = $1`, hash).
+		Scan(&key.Id, &key.Name, &key.Hash, pq.Array(&key.Scopes), &key.CreatedAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
+
+func (store *PostgreSQLAPIKeyStore) List() ([]APIKey, error) {
+	rows, err := store.DB.Query(`SELECT id, name, key_hash, scopes, created_at, revoked_at FROM api_keys ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&key.Id, &key.Name, &key.Hash, pq.Array(&key.Scopes), &key.CreatedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+		res = append(res, key)
+	}
+	return res, rows.Err()
+}
+
+func (store *PostgreSQLAPIKeyStore) Revoke(id string, at time.Time) error {
+	res, err := store.DB.Exec(`UPDATE api_keys SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL`, id, at)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return err
+}
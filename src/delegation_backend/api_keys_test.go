@@ -0,0 +1,111 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testAPIKeys() *APIKeys {
+	return &APIKeys{Store: &MemoryAPIKeyStore{}, Now: time.Now}
+}
+
+func TestAPIKeyIssueAndRevoke(t *testing.T) {
+	keys := testAPIKeys()
+	key, raw, err := keys.Issue("scorer", []string{SCOPE_READ})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Authenticate(raw, SCOPE_READ); err != nil {
+		t.Fatalf("valid key rejected: %v", err)
+	}
+	if _, err := keys.Authenticate(raw, "stream"); err == nil {
+		t.Fatal("key accepted for scope it lacks")
+	}
+	if _, err := keys.Authenticate(raw+"x", SCOPE_READ); err != ErrAPIKeyNotFound {
+		t.Fatalf("unexpected error for wrong key: %v", err)
+	}
+	if err := keys.Revoke(key.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Authenticate(raw, SCOPE_READ); err != ErrAPIKeyNotFound {
+		t.Fatalf("revoked key accepted: %v", err)
+	}
+	if err := keys.Revoke(key.Id); err != ErrAPIKeyNotFound {
+		t.Fatalf("unexpected error revoking twice: %v", err)
+	}
+	if _, _, err := keys.Issue("bad", []string{"write"}); err == nil {
+		t.Fatal("unknown scope accepted")
+	}
+	if _, _, err := keys.Issue("streamer", []string{"stream"}); err == nil {
+		t.Fatal("unknown scope accepted")
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	keys := testAPIKeys()
+	_, raw, _ := keys.Issue("scorer", []string{SCOPE_READ})
+	// Keys of scopes no longer granted don't authorize anything
+	rawStream := API_KEY_PREFIX + "streamer"
+	keys.Store.Insert(&APIKey{Id: "streamer", Name: "streamer", Hash: hashAPIKey(rawStream), Scopes: []string{"stream"}, CreatedAt: time.Now()})
+	handler := keys.RequireAPIKey(SCOPE_READ, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	cases := []struct {
+		header, value string
+		expected      int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"X-API-Key", raw, http.StatusOK},
+		{"Authorization", "Bearer " + raw, http.StatusOK},
+		{"X-API-Key", "uk_nope", http.StatusUnauthorized},
+		{"X-API-Key", rawStream, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/v1/anything", nil)
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+		handler.ServeHTTP(rr, req)
+		if rr.Code != c.expected {
+			t.Errorf("%s: expected %d, got %d", c.header, c.expected, rr.Code)
+		}
+	}
+}
+
+func TestAPIKeysAdminHandler(t *testing.T) {
+	keys := testAPIKeys()
+	handler := AdminAuth("secret", keys.AdminHandler())
+	do := func(method, path, token string, body []byte) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := do("GET", "/admin/api-keys", "wrong", nil); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected admin auth to fail: %d", rr.Code)
+	}
+	rr := do("POST", "/admin/api-keys", "secret", []byte(`{"name":"scorer","scopes":["read"]}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("issue failed: %v", rr.Body.String())
+	}
+	var issued issueAPIKeyResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &issued); err != nil || issued.Key == "" {
+		t.Fatalf("unexpected issue response: %s", rr.Body.String())
+	}
+	rr = do("GET", "/admin/api-keys", "secret", nil)
+	if rr.Code != http.StatusOK || bytes.Contains(rr.Body.Bytes(), []byte(issued.Key)) {
+		t.Fatalf("unexpected list response: %s", rr.Body.String())
+	}
+	if rr := do("DELETE", "/admin/api-keys/"+issued.Id, "secret", nil); rr.Code != http.StatusOK {
+		t.Fatalf("revoke failed: %s", rr.Body.String())
+	}
+	if rr := do("DELETE", "/admin/api-keys/"+issued.Id, "secret", nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 revoking twice: %d", rr.Code)
+	}
+}
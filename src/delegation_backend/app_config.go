@@ -1,9 +1,16 @@
 package delegation_backend
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
 	"os"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	logging "github.com/ipfs/go-log/v2"
 )
@@ -15,150 +22,1347 @@ func GetAWSBucketName(config AppConfig) string {
 	return "" // return empty in case AWSConfig is nil
 }
 
+// LoadEnv loads the configuration from the file set by CONFIG_FILE,
+// if any, and from environment variables.
 func LoadEnv(log logging.EventLogger) AppConfig {
-	var config AppConfig
+	return LoadConfig("", log)
+}
+
+// LoadConfig loads the configuration file (JSON, YAML or TOML, by its
+// extension), falling back to the file set by CONFIG_FILE. Environment
+// variables override values of the file, the program terminates if
+// the resulting configuration is invalid.
+func LoadConfig(configFile string, log logging.EventLogger) AppConfig {
+	config, err := loadConfig(configFilePath(configFile), log)
+	if err != nil {
+		log.Fatalf("Error loading configuration, %v", err)
+	}
+	// Set AWS credentials from config file in case we are using AWS S3 or AWS Keyspaces
+	if config.Aws != nil && config.Aws.AccessKeyId != "" {
+		os.Setenv("AWS_ACCESS_KEY_ID", config.Aws.AccessKeyId)
+		os.Setenv("AWS_SECRET_ACCESS_KEY", config.Aws.SecretAccessKey)
+	}
+	return config
+}
+
+// Path of the configuration file, falling back to CONFIG_FILE
+func configFilePath(configFile string) string {
+	if configFile == "" {
+		return os.Getenv("CONFIG_FILE")
+	}
+	return configFile
+}
 
-	configFile := os.Getenv("CONFIG_FILE")
+// Load the configuration file, if any, apply environment variables
+// and defaults, and validate the resulting configuration.
+func loadConfig(configFile string, log logging.EventLogger) (AppConfig, error) {
+	var config AppConfig
 	if configFile != "" {
-		file, err := os.Open(configFile)
+		var unknownKeys []string
+		var err error
+		config, unknownKeys, err = LoadConfigFile(configFile)
+		if err != nil {
+			return config, fmt.Errorf("error loading config file: %w", err)
+		}
+		for _, key := range unknownKeys {
+			log.Warnf("Unknown key %s in config file %s is ignored", key, configFile)
+		}
+	}
+	// Settings of the file and environment variables apply over the profile
+	profile := config.Profile
+	envString(&profile, "PROFILE")
+	if preset, err := ProfilePreset(profile); err == nil {
+		config = preset
+		if configFile != "" {
+			if config, _, err = loadConfigFileOver(configFile, preset); err != nil {
+				return config, fmt.Errorf("error loading config file: %w", err)
+			}
+		}
+	}
+	config.Profile = profile
+	applyEnv(&config, log)
+	config.setDefaults()
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return config, nil
+}
+
+// Override the field with the environment variable, if it is set
+func envString(field *string, variable string) {
+	if value := os.Getenv(variable); value != "" {
+		*field = value
+	}
+}
+
+func envInt(field *int, variable string, log logging.EventLogger) {
+	if valueStr := os.Getenv(variable); valueStr != "" {
+		value, err := strconv.Atoi(valueStr)
 		if err != nil {
-			log.Fatalf("Error loading config file: %s", err)
+			log.Fatalf("Error parsing %s: %v", variable, err)
 		}
-		defer file.Close()
-		decoder := json.NewDecoder(file)
-		err = decoder.Decode(&config)
+		*field = value
+	}
+}
+
+// Override an optional field, whose zero value differs from unset
+func envOptionalInt(field **int, variable string, log logging.EventLogger) {
+	if os.Getenv(variable) != "" {
+		var value int
+		envInt(&value, variable, log)
+		*field = &value
+	}
+}
+
+func envFloat(field *float64, variable string, log logging.EventLogger) {
+	if valueStr := os.Getenv(variable); valueStr != "" {
+		value, err := strconv.ParseFloat(valueStr, 64)
 		if err != nil {
-			log.Fatalf("Error decoding config file: %s", err)
-		}
-		// Set AWS credentials from config file in case we are using AWS S3 or AWS Keyspaces
-		if config.Aws != nil {
-			os.Setenv("AWS_ACCESS_KEY_ID", config.Aws.AccessKeyId)
-			os.Setenv("AWS_SECRET_ACCESS_KEY", config.Aws.SecretAccessKey)
-		}
-	} else {
-		// networkName is used as part of the S3 bucket path and influences networkId
-		// networkName = "mainnet" will result in networkId = 1 else networkId = 0 and this influeces verifySignature
-		networkName := getEnvChecked("CONFIG_NETWORK_NAME", log)
-		verifySignatureDisabled := boolEnvChecked("VERIFY_SIGNATURE_DISABLED", log)
-
-		delegationWhitelistDisabled := boolEnvChecked("DELEGATION_WHITELIST_DISABLED", log)
-		var gsheetId, delegationWhitelistList, delegationWhitelistColumn string
-		if delegationWhitelistDisabled {
-			// If delegation whitelist is disabled, we don't need to load related environment variables
-			// just loading them from env in case they are set, but they won't be used
-			gsheetId = os.Getenv("CONFIG_GSHEET_ID")
-			delegationWhitelistList = os.Getenv("DELEGATION_WHITELIST_LIST")
-			delegationWhitelistColumn = os.Getenv("DELEGATION_WHITELIST_COLUMN")
-		} else {
-			// If delegation whitelist is enabled, we need to load related environment variables
-			// program will terminate if any of them is missing
-			gsheetId = getEnvChecked("CONFIG_GSHEET_ID", log)
-			delegationWhitelistList = getEnvChecked("DELEGATION_WHITELIST_LIST", log)
-			delegationWhitelistColumn = getEnvChecked("DELEGATION_WHITELIST_COLUMN", log)
-		}
-
-		// AWS configurations
-		if bucketNameSuffix := os.Getenv("AWS_BUCKET_NAME_SUFFIX"); bucketNameSuffix != "" {
-			// accessKeyId, secretAccessKey are not mandatory for production set up
-			accessKeyId := os.Getenv("AWS_ACCESS_KEY_ID")
-			secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
-			awsRegion := getEnvChecked("AWS_REGION", log)
-			awsAccountId := getEnvChecked("AWS_ACCOUNT_ID", log)
-			bucketNameSuffix := getEnvChecked("AWS_BUCKET_NAME_SUFFIX", log)
-
-			config.Aws = &AwsConfig{
-				AccountId:        awsAccountId,
-				BucketNameSuffix: bucketNameSuffix,
-				Region:           awsRegion,
-				AccessKeyId:      accessKeyId,
-				SecretAccessKey:  secretAccessKey,
-			}
-		}
-
-		// AWSKeyspace/Cassandra configurations
-		if keyspace := os.Getenv("AWS_KEYSPACE"); keyspace != "" {
-			awsKeyspace := getEnvChecked("AWS_KEYSPACE", log)
-			sslCertificatePath := getEnvChecked("AWS_SSL_CERTIFICATE_PATH", log)
-
-			//service level connection
-			cassandraHost := os.Getenv("CASSANDRA_HOST")
-			cassandraPortStr := os.Getenv("CASSANDRA_PORT")
-			cassandraPort, err := strconv.Atoi(cassandraPortStr)
+			log.Fatalf("Error parsing %s: %v", variable, err)
+		}
+		*field = value
+	}
+}
+
+func envList(field *[]string, variable string) {
+	if value := os.Getenv(variable); value != "" {
+		*field = strings.Split(value, ",")
+	}
+}
+
+func envIntList(field *[]int, variable string, log logging.EventLogger) {
+	if value := os.Getenv(variable); value != "" {
+		var values []int
+		for _, valueStr := range strings.Split(value, ",") {
+			v, err := strconv.Atoi(strings.TrimSpace(valueStr))
 			if err != nil {
-				cassandraPort = 9142
-			}
-			cassandraUsername := os.Getenv("CASSANDRA_USERNAME")
-			cassandraPassword := os.Getenv("CASSANDRA_PASSWORD")
-
-			//aws keyspaces connection
-			awsRegion := os.Getenv("AWS_REGION")
-
-			// if webIdentityTokenFile, roleSessionName and roleArn are set,
-			// we are using AWS STS to assume a role and get temporary credentials
-			// if they are not set, we are using AWS IAM user credentials
-			webIdentityTokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
-			roleSessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
-			roleArn := os.Getenv("AWS_ROLE_ARN")
-			// accessKeyId, secretAccessKey are not mandatory for production set up
-			accessKeyId := os.Getenv("AWS_ACCESS_KEY_ID")
-			secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
-
-			config.AwsKeyspaces = &AwsKeyspacesConfig{
-				Keyspace:             awsKeyspace,
-				CassandraHost:        cassandraHost,
-				CassandraPort:        cassandraPort,
-				CassandraUsername:    cassandraUsername,
-				CassandraPassword:    cassandraPassword,
-				Region:               awsRegion,
-				AccessKeyId:          accessKeyId,
-				SecretAccessKey:      secretAccessKey,
-				WebIdentityTokenFile: webIdentityTokenFile,
-				RoleSessionName:      roleSessionName,
-				RoleArn:              roleArn,
-				SSLCertificatePath:   sslCertificatePath,
-			}
-		}
-
-		// LocalFileSystem configurations
-		if path := os.Getenv("CONFIG_FILESYSTEM_PATH"); path != "" {
-			config.LocalFileSystem = &LocalFileSystemConfig{
-				Path: path,
-			}
-		}
-
-		// PostgreSQL configurations
-		if postgresHost := os.Getenv("POSTGRES_HOST"); postgresHost != "" {
-			postgresUser := getEnvChecked("POSTGRES_USER", log)
-			postgresPassword := getEnvChecked("POSTGRES_PASSWORD", log)
-			postgresDBName := getEnvChecked("POSTGRES_DB", log)
-			postgresPort, err := strconv.Atoi(getEnvChecked("POSTGRES_PORT", log))
+				log.Fatalf("Error parsing %s: %v", variable, err)
+			}
+			values = append(values, v)
+		}
+		*field = values
+	}
+}
+
+func envBool(field *bool, variable string, log logging.EventLogger) {
+	if os.Getenv(variable) != "" {
+		*field = boolEnvChecked(variable, log)
+	}
+}
+
+func anyEnv(variables ...string) bool {
+	for _, variable := range variables {
+		if os.Getenv(variable) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply a 0 or 1 variable enabling an optional section of the
+// configuration, the section is left as is if the variable is not set.
+func envEnabled[T any](section **T, variable string, log logging.EventLogger) {
+	if os.Getenv(variable) == "" {
+		return
+	}
+	if !boolEnvChecked(variable, log) {
+		*section = nil
+	} else if *section == nil {
+		*section = new(T)
+	}
+}
+
+// Create the optional section if any of the variables is set
+func envSection[T any](section **T, variables ...string) {
+	if *section == nil && anyEnv(variables...) {
+		*section = new(T)
+	}
+}
+
+// Override the configuration with environment variables. Optional sections
+// are created once any of their key variables is set.
+func applyEnv(config *AppConfig, log logging.EventLogger) {
+	// networkName is used as part of the S3 bucket path and influences networkId
+	// networkName = "mainnet" will result in networkId = 1 else networkId = 0 and this influeces verifySignature
+	envString(&config.NetworkName, "CONFIG_NETWORK_NAME")
+	envBool(&config.VerifySignatureDisabled, "VERIFY_SIGNATURE_DISABLED", log)
+	envIntList(&config.SignPayloadVersions, "SIGN_PAYLOAD_VERSIONS", log)
+
+	// Delegation whitelist settings are required unless the whitelist is disabled
+	envBool(&config.DelegationWhitelistDisabled, "DELEGATION_WHITELIST_DISABLED", log)
+	envString(&config.GsheetId, "CONFIG_GSHEET_ID")
+	envString(&config.DelegationWhitelistList, "DELEGATION_WHITELIST_LIST")
+	envString(&config.DelegationWhitelistColumn, "DELEGATION_WHITELIST_COLUMN")
+	envString(&config.DelegationWhitelistRateLimitColumn, "DELEGATION_WHITELIST_RATE_LIMIT_COLUMN")
+	envInt(&config.WhitelistRefreshMinutes, "DELEGATION_WHITELIST_REFRESH_INTERVAL", log)
+
+	envSection(&config.RateLimit, "REQUESTS_PER_PK_HOURLY", "REQUESTS_PER_PK_HOURLY_OVERRIDES")
+	if rateLimit := config.RateLimit; rateLimit != nil {
+		envInt(&rateLimit.RequestsPerPkHourly, "REQUESTS_PER_PK_HOURLY", log)
+		if overridesStr := os.Getenv("REQUESTS_PER_PK_HOURLY_OVERRIDES"); overridesStr != "" {
+			overrides, err := ParseRateLimitOverrides(overridesStr)
 			if err != nil {
-				log.Fatalf("Error parsing POSTGRES_PORT: %v", err)
+				log.Fatalf("Error parsing REQUESTS_PER_PK_HOURLY_OVERRIDES: %v", err)
 			}
-			postgresSSLMode := os.Getenv("POSTGRES_SSLMODE")
-			if postgresSSLMode == "" {
-				postgresSSLMode = "require"
+			rateLimit.Overrides = overrides
+		}
+	}
+
+	// AWS configurations
+	envSection(&config.Aws, "AWS_BUCKET_NAME_SUFFIX")
+	if aws := config.Aws; aws != nil {
+		// accessKeyId, secretAccessKey are not mandatory for production set up
+		envString(&aws.AccessKeyId, "AWS_ACCESS_KEY_ID")
+		envString(&aws.SecretAccessKey, "AWS_SECRET_ACCESS_KEY")
+		envString(&aws.Region, "AWS_REGION")
+		envString(&aws.AccountId, "AWS_ACCOUNT_ID")
+		envString(&aws.BucketNameSuffix, "AWS_BUCKET_NAME_SUFFIX")
+	}
+
+	// AWSKeyspace/Cassandra configurations
+	envSection(&config.AwsKeyspaces, "AWS_KEYSPACE")
+	if ks := config.AwsKeyspaces; ks != nil {
+		envString(&ks.Keyspace, "AWS_KEYSPACE")
+		envString(&ks.SSLCertificatePath, "AWS_SSL_CERTIFICATE_PATH")
+
+		//service level connection
+		envString(&ks.CassandraHost, "CASSANDRA_HOST")
+		envInt(&ks.CassandraPort, "CASSANDRA_PORT", log)
+		envString(&ks.CassandraUsername, "CASSANDRA_USERNAME")
+		envString(&ks.CassandraPassword, "CASSANDRA_PASSWORD")
+
+		//aws keyspaces connection
+		envString(&ks.Region, "AWS_REGION")
+
+		// if webIdentityTokenFile, roleSessionName and roleArn are set,
+		// we are using AWS STS to assume a role and get temporary credentials
+		// if they are not set, we are using AWS IAM user credentials
+		envString(&ks.WebIdentityTokenFile, "AWS_WEB_IDENTITY_TOKEN_FILE")
+		envString(&ks.RoleSessionName, "AWS_ROLE_SESSION_NAME")
+		envString(&ks.RoleArn, "AWS_ROLE_ARN")
+		// accessKeyId, secretAccessKey are not mandatory for production set up
+		envString(&ks.AccessKeyId, "AWS_ACCESS_KEY_ID")
+		envString(&ks.SecretAccessKey, "AWS_SECRET_ACCESS_KEY")
+	}
+
+	// LocalFileSystem configurations
+	envSection(&config.LocalFileSystem, "CONFIG_FILESYSTEM_PATH")
+	if config.LocalFileSystem != nil {
+		envString(&config.LocalFileSystem.Path, "CONFIG_FILESYSTEM_PATH")
+	}
+
+	// PostgreSQL configurations
+	envSection(&config.PostgreSQL, "POSTGRES_HOST")
+	if pg := config.PostgreSQL; pg != nil {
+		envString(&pg.Host, "POSTGRES_HOST")
+		envString(&pg.User, "POSTGRES_USER")
+		envString(&pg.Password, "POSTGRES_PASSWORD")
+		envString(&pg.DBName, "POSTGRES_DB")
+		envInt(&pg.Port, "POSTGRES_PORT", log)
+		envString(&pg.SSLMode, "POSTGRES_SSLMODE")
+		envInt(&pg.MaxOpenConns, "POSTGRES_MAX_OPEN_CONNS", log)
+		envInt(&pg.MaxIdleConns, "POSTGRES_MAX_IDLE_CONNS", log)
+		envInt(&pg.ConnMaxLifetimeMinutes, "POSTGRES_CONN_MAX_LIFETIME", log)
+		envBool(&pg.SkipMigrations, "POSTGRES_SKIP_MIGRATIONS", log)
+	}
+
+	// TLS configurations
+	envSection(&config.TLS, "TLS_CERT_FILE", "TLS_AUTOCERT_DOMAINS")
+	if tls := config.TLS; tls != nil {
+		envString(&tls.ListenTo, "TLS_LISTEN_TO")
+		envString(&tls.CertFile, "TLS_CERT_FILE")
+		envString(&tls.KeyFile, "TLS_KEY_FILE")
+		envList(&tls.AutocertDomains, "TLS_AUTOCERT_DOMAINS")
+		envString(&tls.AutocertCacheDir, "TLS_AUTOCERT_CACHE_DIR")
+		envString(&tls.AutocertEmail, "TLS_AUTOCERT_EMAIL")
+		envString(&tls.ClientCAFile, "TLS_CLIENT_CA_FILE")
+		envString(&tls.ClientCertMap, "TLS_CLIENT_CERT_MAP")
+	}
+
+	envEnabled(&config.ReplayProtection, "REPLAY_PROTECTION_ENABLED", log)
+	if config.ReplayProtection != nil {
+		envString(&config.ReplayProtection.StateFile, "REPLAY_PROTECTION_STATE_FILE")
+	}
+
+	envString(&config.AdminToken, "ADMIN_TOKEN")
+	envString(&config.GsheetCredentials, "CONFIG_GSHEET_CREDENTIALS")
+
+	envSection(&config.Secrets, "SECRETS_AWS_REGION", "VAULT_ADDR")
+	if secrets := config.Secrets; secrets != nil {
+		envString(&secrets.AwsRegion, "SECRETS_AWS_REGION")
+		envString(&secrets.VaultAddr, "VAULT_ADDR")
+		envInt(&secrets.RefreshIntervalMinutes, "SECRETS_REFRESH_INTERVAL_MINUTES", log)
+	}
+
+	envEnabled(&config.AnomalyDetection, "ANOMALY_DETECTION_ENABLED", log)
+	if anomaly := config.AnomalyDetection; anomaly != nil {
+		anomalyRuleFromEnv(&anomaly.IPFanout, "ANOMALY_IP_FANOUT", log)
+		anomalyRuleFromEnv(&anomaly.SharedBlock, "ANOMALY_SHARED_BLOCK", log)
+		anomalyRuleFromEnv(&anomaly.RateSpike, "ANOMALY_RATE_SPIKE", log)
+	}
+
+	envSection(&config.Receipts, "RECEIPT_SIGNING_KEY_FILE")
+	if config.Receipts != nil {
+		envString(&config.Receipts.SigningKeyFile, "RECEIPT_SIGNING_KEY_FILE")
+		envString(&config.Receipts.SigningKeyId, "RECEIPT_SIGNING_KEY_ID")
+	}
+
+	envSection(&config.Logging, "LOG_LEVEL", "LOG_FORMAT", "LOG_FILE", "LOG_SUBSYSTEM_LEVELS")
+	if logCfg := config.Logging; logCfg != nil {
+		envString(&logCfg.Level, "LOG_LEVEL")
+		envString(&logCfg.Format, "LOG_FORMAT")
+		envString(&logCfg.File, "LOG_FILE")
+		if logSubsystemLevels := os.Getenv("LOG_SUBSYSTEM_LEVELS"); logSubsystemLevels != "" {
+			subsystemLevels, err := ParseSubsystemLevels(logSubsystemLevels)
+			if err != nil {
+				log.Fatalf("Error parsing LOG_SUBSYSTEM_LEVELS: %v", err)
 			}
+			logCfg.SubsystemLevels = subsystemLevels
+		}
+	}
+
+	envSection(&config.ErrorReporting, "SENTRY_DSN")
+	if reporting := config.ErrorReporting; reporting != nil {
+		envString(&reporting.SentryDSN, "SENTRY_DSN")
+		envString(&reporting.Environment, "SENTRY_ENVIRONMENT")
+		envString(&reporting.Release, "SENTRY_RELEASE")
+		envInt(&reporting.StorageFailureThreshold, "ERROR_REPORTING_STORAGE_FAILURE_THRESHOLD", log)
+	}
+
+	envSection(&config.Alerting, "ALERT_SLACK_WEBHOOK_URL", "ALERT_DISCORD_WEBHOOK_URL", "ALERT_WEBHOOK_URL")
+	if alerting := config.Alerting; alerting != nil {
+		envString(&alerting.SlackWebhookURL, "ALERT_SLACK_WEBHOOK_URL")
+		envString(&alerting.DiscordWebhookURL, "ALERT_DISCORD_WEBHOOK_URL")
+		envString(&alerting.WebhookURL, "ALERT_WEBHOOK_URL")
+		envList(&alerting.Events, "ALERT_EVENTS")
+		envInt(&alerting.CooldownMinutes, "ALERT_COOLDOWN_MINUTES", log)
+		envInt(&alerting.WhitelistFailureThreshold, "ALERT_WHITELIST_FAILURE_THRESHOLD", log)
+		envInt(&alerting.SubmissionsIdleMinutes, "ALERT_SUBMISSIONS_IDLE_MINUTES", log)
+	}
+
+	envSection(&config.SLO, "SLO_LATENCY_TARGET_MS", "SLO_OBJECTIVE", "SLO_WINDOW_MINUTES")
+	if slo := config.SLO; slo != nil {
+		envInt(&slo.LatencyTargetMs, "SLO_LATENCY_TARGET_MS", log)
+		envFloat(&slo.Objective, "SLO_OBJECTIVE", log)
+		envInt(&slo.WindowMinutes, "SLO_WINDOW_MINUTES", log)
+	}
 
-			config.PostgreSQL = &PostgreSQLConfig{
-				Host:     postgresHost,
-				Port:     postgresPort,
-				User:     postgresUser,
-				Password: postgresPassword,
-				DBName:   postgresDBName,
-				SSLMode:  postgresSSLMode,
+	envEnabled(&config.StorageUsage, "STORAGE_USAGE_ENABLED", log)
+	if config.StorageUsage != nil {
+		envInt(&config.StorageUsage.IntervalMinutes, "STORAGE_USAGE_INTERVAL_MINUTES", log)
+	}
+	envEnabled(&config.Index, "INDEX_ENABLED", log)
+	if config.Index != nil {
+		envInt(&config.Index.IntervalMinutes, "INDEX_INTERVAL_MINUTES", log)
+	}
+	envEnabled(&config.Silence, "SILENCE_ENABLED", log)
+	if silence := config.Silence; silence != nil {
+		envInt(&silence.AfterMinutes, "SILENCE_AFTER_MINUTES", log)
+		envInt(&silence.AfterSlots, "SILENCE_AFTER_SLOTS", log)
+	}
+	envEnabled(&config.Scoring, "SCORING_ENABLED", log)
+	if scoring := config.Scoring; scoring != nil {
+		envInt(&scoring.IntervalMinutes, "SCORING_INTERVAL_MINUTES", log)
+		envIntList(&scoring.WindowDays, "SCORING_WINDOW_DAYS", log)
+		envInt(&scoring.SubmissionIntervalMinutes, "SCORING_SUBMISSION_INTERVAL_MINUTES", log)
+		envBool(&scoring.ExcludeOrphaned, "SCORING_EXCLUDE_ORPHANED", log)
+	}
+	envEnabled(&config.Finality, "FINALITY_ENABLED", log)
+	if finality := config.Finality; finality != nil {
+		envString(&finality.ArchiveURL, "FINALITY_ARCHIVE_URL")
+		envInt(&finality.AfterHours, "FINALITY_AFTER_HOURS", log)
+		envInt(&finality.IntervalMinutes, "FINALITY_INTERVAL_MINUTES", log)
+	}
+	envEnabled(&config.SignatureQuarantine, "SIGNATURE_QUARANTINE_ENABLED", log)
+	if quarantine := config.SignatureQuarantine; quarantine != nil {
+		envInt(&quarantine.MaxAgeDays, "SIGNATURE_QUARANTINE_MAX_AGE_DAYS", log)
+	}
+	envEnabled(&config.Leaderboard, "LEADERBOARD_ENABLED", log)
+	if leaderboard := config.Leaderboard; leaderboard != nil {
+		envInt(&leaderboard.HistoryDays, "LEADERBOARD_HISTORY_DAYS", log)
+		if value := os.Getenv("LEADERBOARD_TIERS"); value != "" {
+			leaderboard.Tiers = nil
+			for _, tier := range strings.Split(value, ",") {
+				name, minUptime, found := strings.Cut(strings.TrimSpace(tier), ":")
+				uptime, err := strconv.ParseFloat(minUptime, 64)
+				if !found || err != nil {
+					log.Fatalf("Error parsing LEADERBOARD_TIERS: expected <name>:<min uptime>, got %q", tier)
+				}
+				leaderboard.Tiers = append(leaderboard.Tiers, LeaderboardTier{Name: name, MinUptime: uptime})
 			}
 		}
+	}
+
+	envSection(&config.Retention, "RETENTION_S3_MAX_AGE_DAYS", "RETENTION_KEYSPACES_MAX_AGE_DAYS", "RETENTION_POSTGRESQL_MAX_AGE_DAYS", "RETENTION_FILESYSTEM_MAX_AGE_DAYS")
+	if retention := config.Retention; retention != nil {
+		envInt(&retention.IntervalHours, "RETENTION_INTERVAL_HOURS", log)
+		envSection(&retention.S3, "RETENTION_S3_MAX_AGE_DAYS")
+		if policy := retention.S3; policy != nil {
+			envInt(&policy.MaxAgeDays, "RETENTION_S3_MAX_AGE_DAYS", log)
+			envString(&policy.ArchiveTo, "RETENTION_S3_ARCHIVE_PREFIX")
+			envString(&policy.ArchiveStorageClass, "RETENTION_S3_ARCHIVE_STORAGE_CLASS")
+		}
+		envSection(&retention.AwsKeyspaces, "RETENTION_KEYSPACES_MAX_AGE_DAYS")
+		if policy := retention.AwsKeyspaces; policy != nil {
+			envInt(&policy.MaxAgeDays, "RETENTION_KEYSPACES_MAX_AGE_DAYS", log)
+		}
+		envInt(&retention.KeyspacesLookbackDays, "RETENTION_KEYSPACES_LOOKBACK_DAYS", log)
+		envBool(&retention.OrphanedBlocks, "RETENTION_ORPHANED_BLOCKS", log)
+		envInt(&retention.OrphanedBlocksGraceHours, "RETENTION_ORPHANED_BLOCKS_GRACE_HOURS", log)
+		envSection(&retention.PostgreSQL, "RETENTION_POSTGRESQL_MAX_AGE_DAYS")
+		if policy := retention.PostgreSQL; policy != nil {
+			envInt(&policy.MaxAgeDays, "RETENTION_POSTGRESQL_MAX_AGE_DAYS", log)
+		}
+		envSection(&retention.LocalFileSystem, "RETENTION_FILESYSTEM_MAX_AGE_DAYS")
+		if policy := retention.LocalFileSystem; policy != nil {
+			envInt(&policy.MaxAgeDays, "RETENTION_FILESYSTEM_MAX_AGE_DAYS", log)
+			envString(&policy.ArchiveTo, "RETENTION_FILESYSTEM_ARCHIVE_DIR")
+		}
+	}
 
-		config.NetworkName = networkName
-		config.GsheetId = gsheetId
-		config.DelegationWhitelistList = delegationWhitelistList
-		config.DelegationWhitelistColumn = delegationWhitelistColumn
-		config.DelegationWhitelistDisabled = delegationWhitelistDisabled
-		config.VerifySignatureDisabled = verifySignatureDisabled
+	envSection(&config.Diagnostics, "DIAGNOSTICS_LISTEN_TO")
+	if config.Diagnostics != nil {
+		envString(&config.Diagnostics.ListenTo, "DIAGNOSTICS_LISTEN_TO")
 	}
 
-	return config
+	envEnabled(&config.AccessLog, "ACCESS_LOG_ENABLED", log)
+	if accessLog := config.AccessLog; accessLog != nil {
+		envString(&accessLog.File, "ACCESS_LOG_FILE")
+		envString(&accessLog.Format, "ACCESS_LOG_FORMAT")
+		envInt(&accessLog.MaxSizeMB, "ACCESS_LOG_MAX_SIZE_MB", log)
+		envInt(&accessLog.MaxBackups, "ACCESS_LOG_MAX_BACKUPS", log)
+	}
+
+	envSection(&config.Canary, "CANARY_REQUEST_FILE")
+	if config.Canary != nil {
+		envString(&config.Canary.RequestFile, "CANARY_REQUEST_FILE")
+		envInt(&config.Canary.IntervalMinutes, "CANARY_INTERVAL_MINUTES", log)
+	}
+
+	if os.Getenv("SUBMITTER_STATS_STORAGE_ENABLED") == "1" {
+		envSection(&config.SubmitterStats, "SUBMITTER_STATS_STORAGE_ENABLED")
+	}
+	envSection(&config.SubmitterStats, "SUBMITTER_STATS_STATE_FILE", "SUBMITTER_STATS_WINDOW_DAYS")
+	if stats := config.SubmitterStats; stats != nil {
+		envString(&stats.StateFile, "SUBMITTER_STATS_STATE_FILE")
+		envBool(&stats.Storage, "SUBMITTER_STATS_STORAGE_ENABLED", log)
+		envInt(&stats.WindowDays, "SUBMITTER_STATS_WINDOW_DAYS", log)
+	}
+
+	envEnabled(&config.DailyReports, "DAILY_REPORTS_ENABLED", log)
+	envSection(&config.DailyReports, "DAILY_REPORTS_STATE_FILE")
+	if config.DailyReports != nil {
+		envString(&config.DailyReports.StateFile, "DAILY_REPORTS_STATE_FILE")
+	}
+
+	if os.Getenv("AUDIT_LOG_STORAGE_ENABLED") == "1" {
+		envSection(&config.Audit, "AUDIT_LOG_STORAGE_ENABLED")
+	}
+	envSection(&config.Audit, "AUDIT_LOG_FILE")
+	if audit := config.Audit; audit != nil {
+		envString(&audit.File, "AUDIT_LOG_FILE")
+		envBool(&audit.Storage, "AUDIT_LOG_STORAGE_ENABLED", log)
+	}
+
+	envSection(&config.Tracing, "OTEL_EXPORTER_OTLP_ENDPOINT")
+	if tracing := config.Tracing; tracing != nil {
+		envString(&tracing.OtlpEndpoint, "OTEL_EXPORTER_OTLP_ENDPOINT")
+		envString(&tracing.ServiceName, "OTEL_SERVICE_NAME")
+		if os.Getenv("OTEL_TRACES_SAMPLER_ARG") != "" {
+			var ratio float64
+			envFloat(&ratio, "OTEL_TRACES_SAMPLER_ARG", log)
+			tracing.SampleRatio = &ratio
+		}
+	}
+
+	envString(&config.ListenTo, "LISTEN_TO")
+
+	envEnabled(&config.LeaderElection, "LEADER_ELECTION_ENABLED", log)
+	envSection(&config.LeaderElection, "LEADER_ELECTION_LOCK_ID")
+	if config.LeaderElection != nil {
+		envInt(&config.LeaderElection.LockId, "LEADER_ELECTION_LOCK_ID", log)
+	}
+
+	envEnabled(&config.WriteBatching, "WRITE_BATCH_ENABLED", log)
+	envSection(&config.WriteBatching, "WRITE_BATCH_SIZE", "WRITE_BATCH_INTERVAL_MS")
+	if batching := config.WriteBatching; batching != nil {
+		envInt(&batching.Size, "WRITE_BATCH_SIZE", log)
+		envInt(&batching.IntervalMs, "WRITE_BATCH_INTERVAL_MS", log)
+	}
+
+	envEnabled(&config.LoadShedding, "LOAD_SHEDDING_ENABLED", log)
+	envSection(&config.LoadShedding, "LOAD_SHEDDING_MAX_IN_FLIGHT", "LOAD_SHEDDING_MAX_QUEUE_DEPTH", "LOAD_SHEDDING_MAX_HEAP_MB")
+	if shedding := config.LoadShedding; shedding != nil {
+		envInt(&shedding.MaxInFlight, "LOAD_SHEDDING_MAX_IN_FLIGHT", log)
+		envInt(&shedding.MaxQueueDepth, "LOAD_SHEDDING_MAX_QUEUE_DEPTH", log)
+		envInt(&shedding.MaxHeapMB, "LOAD_SHEDDING_MAX_HEAP_MB", log)
+	}
+
+	envSection(&config.SharedState, "SHARED_STATE")
+	if shared := config.SharedState; shared != nil {
+		envString(&shared.Backend, "SHARED_STATE")
+		envString(&shared.RedisAddr, "SHARED_STATE_REDIS_ADDR")
+		envString(&shared.RedisPassword, "SHARED_STATE_REDIS_PASSWORD")
+		envInt(&shared.RedisDB, "SHARED_STATE_REDIS_DB", log)
+	}
+
+	envSection(&config.Server, "HTTP_MAX_CONNECTIONS", "HTTP_MAX_CONNECTIONS_PER_IP", "HTTP_KEEP_ALIVES_DISABLED", "HTTP2_DISABLED", "HTTP2_H2C", "HTTP2_MAX_CONCURRENT_STREAMS", "HTTP_DRAIN_DELAY_SECONDS",
+		"HTTP_READ_HEADER_TIMEOUT_SECONDS", "SUBMIT_BODY_READ_TIMEOUT_SECONDS", "SUBMIT_TIMEOUT_SECONDS", "HTTP_WRITE_TIMEOUT_SECONDS", "HTTP_IDLE_TIMEOUT_SECONDS")
+	if server := config.Server; server != nil {
+		envInt(&server.ReadHeaderTimeoutSeconds, "HTTP_READ_HEADER_TIMEOUT_SECONDS", log)
+		envInt(&server.BodyReadTimeoutSeconds, "SUBMIT_BODY_READ_TIMEOUT_SECONDS", log)
+		envInt(&server.SubmitTimeoutSeconds, "SUBMIT_TIMEOUT_SECONDS", log)
+		envInt(&server.WriteTimeoutSeconds, "HTTP_WRITE_TIMEOUT_SECONDS", log)
+		envInt(&server.IdleTimeoutSeconds, "HTTP_IDLE_TIMEOUT_SECONDS", log)
+		envInt(&server.MaxConnections, "HTTP_MAX_CONNECTIONS", log)
+		envInt(&server.MaxConnectionsPerIP, "HTTP_MAX_CONNECTIONS_PER_IP", log)
+		envBool(&server.KeepAlivesDisabled, "HTTP_KEEP_ALIVES_DISABLED", log)
+		envBool(&server.HTTP2Disabled, "HTTP2_DISABLED", log)
+		envBool(&server.H2C, "HTTP2_H2C", log)
+		envInt(&server.MaxConcurrentStreams, "HTTP2_MAX_CONCURRENT_STREAMS", log)
+		envInt(&server.DrainDelaySeconds, "HTTP_DRAIN_DELAY_SECONDS", log)
+	}
+
+	// Block validation of the network of the configuration
+	if anyEnv("BLOCK_VALIDATION_FORMAT", "BLOCK_VALIDATION_GENESIS_STATE_HASH", "BLOCK_VALIDATION_MIN_SIZE", "BLOCK_VALIDATION_REPORT_ONLY") {
+		if config.BlockValidation == nil {
+			config.BlockValidation = make(BlockValidationConfigs)
+		}
+		validation := config.BlockValidation[config.NetworkName]
+		if validation == nil {
+			validation = &BlockValidationConfig{}
+			config.BlockValidation[config.NetworkName] = validation
+		}
+		envString(&validation.Format, "BLOCK_VALIDATION_FORMAT")
+		envString(&validation.GenesisStateHash, "BLOCK_VALIDATION_GENESIS_STATE_HASH")
+		envInt(&validation.MinSize, "BLOCK_VALIDATION_MIN_SIZE", log)
+		envBool(&validation.ReportOnly, "BLOCK_VALIDATION_REPORT_ONLY", log)
+	}
+
+	// Network timing of the network of the configuration
+	if anyEnv("GENESIS_TIMESTAMP", "SLOT_DURATION_MS", "SLOTS_PER_EPOCH", "SLOT_MAX_SKEW_SECONDS") {
+		if config.NetworkTiming == nil {
+			config.NetworkTiming = make(NetworkTimingConfigs)
+		}
+		timing := config.NetworkTiming[config.NetworkName]
+		if timing == nil {
+			timing = &NetworkTimingConfig{}
+			config.NetworkTiming[config.NetworkName] = timing
+		}
+		envString(&timing.GenesisTimestamp, "GENESIS_TIMESTAMP")
+		envInt(&timing.SlotDurationMs, "SLOT_DURATION_MS", log)
+		envInt(&timing.SlotsPerEpoch, "SLOTS_PER_EPOCH", log)
+		envInt(&timing.MaxSkewSeconds, "SLOT_MAX_SKEW_SECONDS", log)
+	}
+	envEnabled(&config.SubmissionWindow, "SUBMISSION_WINDOW_ENABLED", log)
+	if window := config.SubmissionWindow; window != nil {
+		envInt(&window.Slots, "SUBMISSION_WINDOW_SLOTS", log)
+		envString(&window.Mode, "SUBMISSION_WINDOW_MODE")
+	}
+
+	// Signature parameters of the network of the configuration
+	if networkId := os.Getenv("SIGNATURE_NETWORK_ID"); networkId != "" {
+		id, err := strconv.Atoi(networkId)
+		if err != nil {
+			log.Fatalf("Error parsing SIGNATURE_NETWORK_ID: %v", err)
+		}
+		if config.Signature == nil {
+			config.Signature = make(SignatureConfigs)
+		}
+		signature := config.Signature[config.NetworkName]
+		if signature == nil {
+			signature = &SignatureConfig{}
+			config.Signature[config.NetworkName] = signature
+		}
+		signature.NetworkId = &id
+	}
+	if scheme := os.Getenv("SIGNATURE_SCHEME"); scheme != "" {
+		if config.Signature == nil {
+			config.Signature = make(SignatureConfigs)
+		}
+		signature := config.Signature[config.NetworkName]
+		if signature == nil {
+			signature = &SignatureConfig{}
+			config.Signature[config.NetworkName] = signature
+		}
+		signature.Scheme = scheme
+	}
+
+	// Events of accepted submissions of the network of the configuration
+	for _, variable := range []string{"SUBMISSION_EVENTS_SNS_TOPIC_ARN", "SUBMISSION_EVENTS_SQS_QUEUE_URL"} {
+		value := os.Getenv(variable)
+		if value == "" {
+			continue
+		}
+		if config.SubmissionEvents == nil {
+			config.SubmissionEvents = make(SubmissionEventsConfigs)
+		}
+		events := config.SubmissionEvents[config.NetworkName]
+		if events == nil {
+			events = &SubmissionEventsConfig{}
+			config.SubmissionEvents[config.NetworkName] = events
+		}
+		if variable == "SUBMISSION_EVENTS_SNS_TOPIC_ARN" {
+			events.SNSTopicArn = value
+		} else {
+			events.SQSQueueURL = value
+		}
+	}
+
+	// Clock skew policy of the network of the configuration
+	if maxFuture := os.Getenv("CREATED_AT_MAX_FUTURE_SECONDS"); maxFuture != "" {
+		seconds, err := strconv.Atoi(maxFuture)
+		if err != nil {
+			log.Fatalf("Error parsing CREATED_AT_MAX_FUTURE_SECONDS: %v", err)
+		}
+		if config.ClockSkew == nil {
+			config.ClockSkew = make(ClockSkewConfigs)
+		}
+		skew := config.ClockSkew[config.NetworkName]
+		if skew == nil {
+			skew = &ClockSkewConfig{}
+			config.ClockSkew[config.NetworkName] = skew
+		}
+		skew.MaxFutureSeconds = &seconds
+	}
+	// Limits of the size of submissions of the network of the configuration
+	for _, variable := range []string{"MAX_SUBMIT_PAYLOAD_SIZE", "MAX_SUBMIT_BLOCK_SIZE"} {
+		value := os.Getenv(variable)
+		if value == "" {
+			continue
+		}
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Fatalf("Error parsing %s: %v", variable, err)
+		}
+		if config.SubmitSize == nil {
+			config.SubmitSize = make(SubmitSizeConfigs)
+		}
+		limits := config.SubmitSize[config.NetworkName]
+		if limits == nil {
+			limits = &SubmitSizeConfig{}
+			config.SubmitSize[config.NetworkName] = limits
+		}
+		if variable == "MAX_SUBMIT_PAYLOAD_SIZE" {
+			limits.MaxPayloadSize = size
+		} else {
+			limits.MaxBlockSize = size
+		}
+	}
+	envEnabled(&config.ClockCheck, "CLOCK_CHECK_ENABLED", log)
+	if check := config.ClockCheck; check != nil {
+		envString(&check.NTPServer, "CLOCK_CHECK_NTP_SERVER")
+		envInt(&check.MaxSkewMs, "CLOCK_CHECK_MAX_SKEW_MS", log)
+		envInt(&check.IntervalMinutes, "CLOCK_CHECK_INTERVAL_MINUTES", log)
+	}
+	envEnabled(&config.ClockGuard, "CLOCK_GUARD_ENABLED", log)
+	if guard := config.ClockGuard; guard != nil {
+		envInt(&guard.MaxJumpMs, "CLOCK_GUARD_MAX_JUMP_MS", log)
+		envInt(&guard.SettleMinutes, "CLOCK_GUARD_SETTLE_MINUTES", log)
+		envString(&guard.Action, "CLOCK_GUARD_ACTION")
+	}
+	envEnabled(&config.LivenessCheck, "LIVENESS_CHECK_ENABLED", log)
+	if check := config.LivenessCheck; check != nil {
+		envInt(&check.TimeoutMs, "LIVENESS_CHECK_TIMEOUT_MS", log)
+		envBool(&check.AllowPrivateAddresses, "LIVENESS_CHECK_ALLOW_PRIVATE_ADDRESSES", log)
+	}
+	envSection(&config.Webhooks, "WEBHOOK_URL")
+	if webhooks := config.Webhooks; webhooks != nil {
+		if webhookURL := os.Getenv("WEBHOOK_URL"); webhookURL != "" {
+			endpoint := WebhookConfig{URL: webhookURL, Secret: os.Getenv("WEBHOOK_SECRET")}
+			envList(&endpoint.Events, "WEBHOOK_EVENTS")
+			webhooks.Endpoints = append(webhooks.Endpoints, endpoint)
+		}
+		envInt(&webhooks.MaxAttempts, "WEBHOOK_MAX_ATTEMPTS", log)
+		envInt(&webhooks.BackoffMs, "WEBHOOK_BACKOFF_MS", log)
+		envString(&webhooks.DeadLetterFile, "WEBHOOK_DEAD_LETTER_FILE")
+	}
+	envEnabled(&config.SheetsStatus, "SHEETS_STATUS_ENABLED", log)
+	if status := config.SheetsStatus; status != nil {
+		envString(&status.Tab, "SHEETS_STATUS_TAB")
+		envInt(&status.IntervalMinutes, "SHEETS_STATUS_INTERVAL_MINUTES", log)
+	}
+	envSection(&config.NATS, "NATS_URL")
+	if nats := config.NATS; nats != nil {
+		envString(&nats.URL, "NATS_URL")
+		envString(&nats.Token, "NATS_TOKEN")
+		envString(&nats.User, "NATS_USER")
+		envString(&nats.Password, "NATS_PASSWORD")
+		envString(&nats.Stream, "NATS_STREAM")
+		envString(&nats.SubjectPrefix, "NATS_SUBJECT_PREFIX")
+		envBool(&nats.PublishBlocks, "NATS_PUBLISH_BLOCKS", log)
+		envInt(&nats.MaxAgeDays, "NATS_MAX_AGE_DAYS", log)
+		envInt(&nats.Replicas, "NATS_REPLICAS", log)
+		envInt(&nats.AckTimeoutMs, "NATS_ACK_TIMEOUT_MS", log)
+		envString(&nats.TLSCAFile, "NATS_TLS_CA_FILE")
+		envString(&nats.TLSCertFile, "NATS_TLS_CERT_FILE")
+		envString(&nats.TLSKeyFile, "NATS_TLS_KEY_FILE")
+	}
+	envBool(&config.MemoryStorage, "MEMORY_STORAGE", log)
+	faultVariables := []string{"FAULT_INJECTION_WHITELIST_ERROR_RATE", "FAULT_INJECTION_CLOCK_SKEW_SECONDS"}
+	for _, backend := range FAULT_INJECTION_BACKENDS {
+		prefix := "FAULT_INJECTION_" + strings.ToUpper(backend)
+		faultVariables = append(faultVariables, prefix+"_LATENCY_MS", prefix+"_LATENCY_JITTER_MS", prefix+"_ERROR_RATE")
+	}
+	envEnabled(&config.FaultInjection, "FAULT_INJECTION_ENABLED", log)
+	envSection(&config.FaultInjection, faultVariables...)
+	if faults := config.FaultInjection; faults != nil {
+		envFloat(&faults.WhitelistErrorRate, "FAULT_INJECTION_WHITELIST_ERROR_RATE", log)
+		envInt(&faults.ClockSkewSeconds, "FAULT_INJECTION_CLOCK_SKEW_SECONDS", log)
+		for _, backend := range FAULT_INJECTION_BACKENDS {
+			prefix := "FAULT_INJECTION_" + strings.ToUpper(backend)
+			if !anyEnv(prefix+"_LATENCY_MS", prefix+"_LATENCY_JITTER_MS", prefix+"_ERROR_RATE") {
+				continue
+			}
+			if faults.Storage == nil {
+				faults.Storage = make(map[string]*StorageFaultConfig)
+			}
+			storage := faults.Storage[backend]
+			if storage == nil {
+				storage = &StorageFaultConfig{}
+				faults.Storage[backend] = storage
+			}
+			envInt(&storage.LatencyMs, prefix+"_LATENCY_MS", log)
+			envInt(&storage.LatencyJitterMs, prefix+"_LATENCY_JITTER_MS", log)
+			envFloat(&storage.ErrorRate, prefix+"_ERROR_RATE", log)
+		}
+	}
+
+	if featureFlagsStr := os.Getenv("FEATURE_FLAGS"); featureFlagsStr != "" {
+		featureFlags, err := ParseFeatureFlags(featureFlagsStr)
+		if err != nil {
+			log.Fatalf("Error parsing FEATURE_FLAGS: %v", err)
+		}
+		if config.FeatureFlags == nil {
+			config.FeatureFlags = make(FeatureFlagConfigs)
+		}
+		// Networks and rollout of the file still apply
+		for name, flag := range featureFlags {
+			cfg := config.FeatureFlags[name]
+			cfg.Enabled = flag.Enabled
+			config.FeatureFlags[name] = cfg
+		}
+	}
+	envSection(&config.SignatureVerify, "SIGNATURE_VERIFY_WORKERS", "SIGNATURE_VERIFY_QUEUE_SIZE", "SIGNATURE_VERIFY_CACHE_TTL_SECONDS")
+	if verify := config.SignatureVerify; verify != nil {
+		envInt(&verify.Workers, "SIGNATURE_VERIFY_WORKERS", log)
+		envInt(&verify.QueueSize, "SIGNATURE_VERIFY_QUEUE_SIZE", log)
+		envOptionalInt(&verify.CacheTTLSeconds, "SIGNATURE_VERIFY_CACHE_TTL_SECONDS", log)
+	}
+	envSection(&config.SignatureLockout, "SIGNATURE_LOCKOUT_THRESHOLD", "SIGNATURE_LOCKOUT_BASE_SECONDS", "SIGNATURE_LOCKOUT_MAX_SECONDS")
+	if lockout := config.SignatureLockout; lockout != nil {
+		envOptionalInt(&lockout.Threshold, "SIGNATURE_LOCKOUT_THRESHOLD", log)
+		envInt(&lockout.BaseSeconds, "SIGNATURE_LOCKOUT_BASE_SECONDS", log)
+		envInt(&lockout.MaxSeconds, "SIGNATURE_LOCKOUT_MAX_SECONDS", log)
+	}
+	envInt(&config.CreatedAtMaxAgeMinutes, "CREATED_AT_MAX_AGE_MINUTES", log)
+	envInt(&config.DelegationMaxTTLMinutes, "DELEGATION_MAX_TTL_MINUTES", log)
+	envOptionalInt(&config.IdempotencyKeyTTLSeconds, "IDEMPOTENCY_KEY_TTL_SECONDS", log)
+	envSection(&config.ChunkedUpload, "CHUNKED_UPLOAD_CHUNK_SIZE", "CHUNKED_UPLOAD_MAX_UPLOADS")
+	if uploads := config.ChunkedUpload; uploads != nil {
+		envInt(&uploads.ChunkSize, "CHUNKED_UPLOAD_CHUNK_SIZE", log)
+		envInt(&uploads.MaxUploads, "CHUNKED_UPLOAD_MAX_UPLOADS", log)
+	}
+	envList(&config.TrustedProxyCIDRs, "TRUSTED_PROXY_CIDRS")
+	envString(&config.ClientIPHeader, "CLIENT_IP_HEADER")
+	envList(&config.IPAllowlist, "IP_ALLOWLIST")
+	envList(&config.IPDenylist, "IP_DENYLIST")
+}
+
+func (config *AppConfig) setDefaults() {
+	if config.PostgreSQL != nil && config.PostgreSQL.SSLMode == "" {
+		config.PostgreSQL.SSLMode = "require"
+	}
+	if config.Server == nil {
+		config.Server = &HTTPServerConfig{}
+	}
+	server := config.Server
+	for _, setting := range []struct {
+		seconds      *int
+		defaultValue time.Duration
+	}{
+		{&server.ReadHeaderTimeoutSeconds, HTTP_READ_HEADER_TIMEOUT},
+		{&server.BodyReadTimeoutSeconds, SUBMIT_BODY_READ_TIMEOUT},
+		{&server.SubmitTimeoutSeconds, SUBMIT_TIMEOUT},
+		{&server.WriteTimeoutSeconds, HTTP_WRITE_TIMEOUT},
+		{&server.IdleTimeoutSeconds, HTTP_IDLE_TIMEOUT},
+	} {
+		if *setting.seconds == 0 {
+			*setting.seconds = int(setting.defaultValue / time.Second)
+		}
+	}
+	if config.SignatureVerify == nil {
+		config.SignatureVerify = &SignatureVerifyConfig{}
+	}
+	verify := config.SignatureVerify
+	if verify.Workers == 0 {
+		verify.Workers = runtime.GOMAXPROCS(0)
+	}
+	if verify.QueueSize == 0 {
+		verify.QueueSize = 16 * verify.Workers
+	}
+	if verify.CacheTTLSeconds == nil {
+		ttl := int(VERIFY_CACHE_TTL / time.Second)
+		verify.CacheTTLSeconds = &ttl
+	}
+	if config.SignatureLockout == nil {
+		config.SignatureLockout = &SignatureLockoutConfig{}
+	}
+	lockout := config.SignatureLockout
+	if lockout.Threshold == nil {
+		threshold := SIGNATURE_LOCKOUT_THRESHOLD
+		lockout.Threshold = &threshold
+	}
+	if lockout.BaseSeconds == 0 {
+		lockout.BaseSeconds = int(SIGNATURE_LOCKOUT_BASE / time.Second)
+	}
+	if lockout.MaxSeconds == 0 {
+		lockout.MaxSeconds = int(SIGNATURE_LOCKOUT_MAX / time.Second)
+	}
+	if config.IdempotencyKeyTTLSeconds == nil {
+		ttl := int(IDEMPOTENCY_KEY_TTL / time.Second)
+		config.IdempotencyKeyTTLSeconds = &ttl
+	}
+	if config.ChunkedUpload == nil {
+		config.ChunkedUpload = &ChunkedUploadConfig{}
+	}
+	if config.ChunkedUpload.ChunkSize == 0 {
+		config.ChunkedUpload.ChunkSize = CHUNKED_UPLOAD_CHUNK_SIZE
+	}
+	if config.ChunkedUpload.MaxUploads == 0 {
+		config.ChunkedUpload.MaxUploads = CHUNKED_UPLOAD_MAX_UPLOADS
+	}
+}
+
+// Validate checks the configuration, the error lists every problem found
+// along with the key of the config file and the environment variable to fix it.
+func (config *AppConfig) Validate() error {
+	var problems []string
+	require := func(value string, key string, variable string) {
+		if value == "" {
+			problems = append(problems, fmt.Sprintf("%s is required, set it in the config file or with %s", key, variable))
+		}
+	}
+	invalid := func(key string, variable string, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s (%s) is invalid: %s", key, variable, fmt.Sprintf(format, args...)))
+	}
+
+	require(config.NetworkName, "network_name", "CONFIG_NETWORK_NAME")
+	problems = append(problems, config.profileProblems()...)
+	if !config.DelegationWhitelistDisabled {
+		require(config.GsheetId, "gsheet_id", "CONFIG_GSHEET_ID")
+		require(config.DelegationWhitelistList, "delegation_whitelist_list", "DELEGATION_WHITELIST_LIST")
+		require(config.DelegationWhitelistColumn, "delegation_whitelist_column", "DELEGATION_WHITELIST_COLUMN")
+	}
+	if config.ListenTo != "" {
+		if _, _, err := parseListenAddress(config.ListenTo); err != nil {
+			invalid("listen_to", "LISTEN_TO", "%v", err)
+		}
+	}
+	if err := validateFeatureFlags(config.FeatureFlags); err != nil {
+		invalid("feature_flags", "FEATURE_FLAGS", "%v", err)
+	}
+	for _, problem := range validateBlockValidation(config.BlockValidation) {
+		invalid("block_validation", "BLOCK_VALIDATION_FORMAT", "%s", problem)
+	}
+	for _, problem := range validateNetworkTimings(config.NetworkTiming) {
+		invalid("network_timing", "GENESIS_TIMESTAMP", "%s", problem)
+	}
+	if window := config.SubmissionWindow; window != nil {
+		if _, err := NewSubmissionWindows(window, nil); err != nil {
+			invalid("submission_window", "SUBMISSION_WINDOW_MODE", "%v", err)
+		}
+		if config.NetworkTiming[config.NetworkName] == nil {
+			problems = append(problems, fmt.Sprintf("submission_window (SUBMISSION_WINDOW_ENABLED) requires the network timing of %s (GENESIS_TIMESTAMP)", config.NetworkName))
+		}
+	}
+	for _, problem := range validateClockSkewConfigs(config.ClockSkew) {
+		invalid("clock_skew", "CREATED_AT_MAX_FUTURE_SECONDS", "%s", problem)
+	}
+	for _, problem := range validateSubmitSizeConfigs(config.SubmitSize) {
+		invalid("submit_size", "MAX_SUBMIT_PAYLOAD_SIZE", "%s", problem)
+	}
+	if check := config.ClockCheck; check != nil {
+		if check.MaxSkewMs < 0 {
+			invalid("clock_check.max_skew_ms", "CLOCK_CHECK_MAX_SKEW_MS", "expected a positive number, got %d", check.MaxSkewMs)
+		}
+		if check.IntervalMinutes < 0 {
+			invalid("clock_check.interval_minutes", "CLOCK_CHECK_INTERVAL_MINUTES", "expected a positive number, got %d", check.IntervalMinutes)
+		}
+	}
+	if guard := config.ClockGuard; guard != nil {
+		if guard.MaxJumpMs < 0 {
+			invalid("clock_guard.max_jump_ms", "CLOCK_GUARD_MAX_JUMP_MS", "expected a positive number, got %d", guard.MaxJumpMs)
+		}
+		if guard.SettleMinutes < 0 {
+			invalid("clock_guard.settle_minutes", "CLOCK_GUARD_SETTLE_MINUTES", "expected a positive number, got %d", guard.SettleMinutes)
+		}
+		if _, err := NewClockGuard(guard, nil, nil, nil); err != nil {
+			invalid("clock_guard.action", "CLOCK_GUARD_ACTION", "%v", err)
+		}
+	}
+	if check := config.LivenessCheck; check != nil && check.TimeoutMs < 0 {
+		invalid("liveness_check.timeout_ms", "LIVENESS_CHECK_TIMEOUT_MS", "expected a positive number, got %d", check.TimeoutMs)
+	}
+	if webhooks := config.Webhooks; webhooks != nil {
+		if len(webhooks.Endpoints) == 0 {
+			problems = append(problems, "webhooks requires endpoints (WEBHOOK_URL)")
+		}
+		for i, endpoint := range webhooks.Endpoints {
+			key := fmt.Sprintf("webhooks.endpoints[%d]", i)
+			if u, err := url.Parse(endpoint.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				invalid(key+".url", "WEBHOOK_URL", "expected an http or https URL, got %q", endpoint.URL)
+			}
+			for _, event := range endpoint.Events {
+				known := false
+				for _, e := range WEBHOOK_EVENTS {
+					known = known || e == event
+				}
+				if !known {
+					invalid(key+".events", "WEBHOOK_EVENTS", "unknown event %q, expected one of %s", event, strings.Join(WEBHOOK_EVENTS, ", "))
+				}
+				if event == WEBHOOK_SUBMITTER_FIRST_SEEN && config.SubmitterStats == nil {
+					invalid(key+".events", "WEBHOOK_EVENTS", "%s requires submitter_stats", event)
+				}
+			}
+		}
+		if webhooks.MaxAttempts < 0 {
+			invalid("webhooks.max_attempts", "WEBHOOK_MAX_ATTEMPTS", "expected a positive number, got %d", webhooks.MaxAttempts)
+		}
+		if webhooks.BackoffMs < 0 {
+			invalid("webhooks.backoff_ms", "WEBHOOK_BACKOFF_MS", "expected a positive number, got %d", webhooks.BackoffMs)
+		}
+	}
+	if status := config.SheetsStatus; status != nil {
+		if config.DelegationWhitelistDisabled {
+			problems = append(problems, "sheets_status (SHEETS_STATUS_ENABLED) requires the delegation whitelist, disabled by delegation_whitelist_disabled (DELEGATION_WHITELIST_DISABLED)")
+		}
+		if config.Index == nil {
+			problems = append(problems, "sheets_status (SHEETS_STATUS_ENABLED) requires index (INDEX_ENABLED)")
+		}
+		if config.NetworkTiming[config.NetworkName] == nil {
+			problems = append(problems, fmt.Sprintf("sheets_status (SHEETS_STATUS_ENABLED) requires the network timing of %s (GENESIS_TIMESTAMP)", config.NetworkName))
+		}
+		if status.IntervalMinutes < 0 {
+			invalid("sheets_status.interval_minutes", "SHEETS_STATUS_INTERVAL_MINUTES", "expected a positive number, got %d", status.IntervalMinutes)
+		}
+	}
+	if nats := config.NATS; nats != nil {
+		require(nats.URL, "nats.url", "NATS_URL")
+		if u, err := url.Parse(nats.URL); nats.URL != "" && (err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "") {
+			invalid("nats.url", "NATS_URL", "expected nats://host:port or tls://host:port, got %q", nats.URL)
+		}
+		if (nats.TLSCertFile == "") != (nats.TLSKeyFile == "") {
+			invalid("nats.tls_cert_file", "NATS_TLS_CERT_FILE", "expected both the client certificate and its key (NATS_TLS_KEY_FILE)")
+		}
+		if strings.ContainsAny(nats.Stream, " .*>") {
+			invalid("nats.stream", "NATS_STREAM", "expected a name without spaces, dots or wildcards, got %q", nats.Stream)
+		}
+		if strings.ContainsAny(nats.SubjectPrefix, " *>") || strings.HasPrefix(nats.SubjectPrefix, ".") || strings.HasSuffix(nats.SubjectPrefix, ".") {
+			invalid("nats.subject_prefix", "NATS_SUBJECT_PREFIX", "expected subject tokens without wildcards, got %q", nats.SubjectPrefix)
+		}
+		if nats.MaxAgeDays < 0 {
+			invalid("nats.max_age_days", "NATS_MAX_AGE_DAYS", "expected a positive number, got %d", nats.MaxAgeDays)
+		}
+		if nats.Replicas < 0 || nats.Replicas > 5 {
+			invalid("nats.replicas", "NATS_REPLICAS", "expected between 1 and 5, got %d", nats.Replicas)
+		}
+		if nats.AckTimeoutMs < 0 {
+			invalid("nats.ack_timeout_ms", "NATS_ACK_TIMEOUT_MS", "expected a positive number, got %d", nats.AckTimeoutMs)
+		}
+	}
+	for _, problem := range validateSignatureConfigs(config.Signature) {
+		invalid("signature", "SIGNATURE_NETWORK_ID", "%s", problem)
+	}
+	if faults := config.FaultInjection; faults != nil {
+		if config.NetworkName == "mainnet" {
+			invalid("fault_injection", "FAULT_INJECTION_ENABLED", "faults are injected for tests only, not on mainnet")
+		}
+		for _, problem := range validateFaultInjectionConfig(faults) {
+			invalid("fault_injection", "FAULT_INJECTION_ENABLED", "%s", problem)
+		}
+	}
+	for _, problem := range validateSubmissionEventsConfigs(config.SubmissionEvents) {
+		invalid("submission_events", "SUBMISSION_EVENTS_SNS_TOPIC_ARN", "%s", problem)
+	}
+	for _, version := range config.SignPayloadVersions {
+		if !isSignPayloadVersion(version) {
+			invalid("sign_payload_versions", "SIGN_PAYLOAD_VERSIONS", "expected versions among %v, got %d", SIGN_PAYLOAD_VERSIONS, version)
+		}
+	}
+	if config.WhitelistRefreshMinutes < 0 {
+		invalid("delegation_whitelist_refresh_interval_minutes", "DELEGATION_WHITELIST_REFRESH_INTERVAL", "expected a positive number, got %d", config.WhitelistRefreshMinutes)
+	}
+	if rateLimit := config.RateLimit; rateLimit != nil {
+		if rateLimit.RequestsPerPkHourly < 0 {
+			invalid("rate_limit.requests_per_pk_hourly", "REQUESTS_PER_PK_HOURLY", "expected a positive number, got %d", rateLimit.RequestsPerPkHourly)
+		}
+		if _, err := rateLimit.PkOverrides(); err != nil {
+			invalid("rate_limit.overrides", "REQUESTS_PER_PK_HOURLY_OVERRIDES", "%v", err)
+		}
+	}
+	if config.SubmitterStats != nil && config.SubmitterStats.WindowDays < 0 {
+		invalid("submitter_stats.window_days", "SUBMITTER_STATS_WINDOW_DAYS", "expected a positive number, got %d", config.SubmitterStats.WindowDays)
+	}
+	if config.Aws == nil && config.AwsKeyspaces == nil && config.LocalFileSystem == nil && config.PostgreSQL == nil && !config.MemoryStorage {
+		problems = append(problems, "no storage backend configured, set aws (AWS_BUCKET_NAME_SUFFIX), aws_keyspaces (AWS_KEYSPACE), filesystem (CONFIG_FILESYSTEM_PATH), postgresql (POSTGRES_HOST) or memory_storage (MEMORY_STORAGE)")
+	}
+	if config.LeaderElection != nil && config.PostgreSQL == nil {
+		problems = append(problems, "leader_election (LEADER_ELECTION_ENABLED) requires postgresql")
+	}
+	if batching := config.WriteBatching; batching != nil {
+		if batching.Size < 0 {
+			invalid("write_batching.size", "WRITE_BATCH_SIZE", "expected a positive number, got %d", batching.Size)
+		}
+		if batching.IntervalMs < 0 {
+			invalid("write_batching.interval_ms", "WRITE_BATCH_INTERVAL_MS", "expected a positive number, got %d", batching.IntervalMs)
+		}
+	}
+	if shedding := config.LoadShedding; shedding != nil {
+		for _, setting := range []struct {
+			key      string
+			variable string
+			value    int
+		}{
+			{"load_shedding.max_in_flight", "LOAD_SHEDDING_MAX_IN_FLIGHT", shedding.MaxInFlight},
+			{"load_shedding.max_queue_depth", "LOAD_SHEDDING_MAX_QUEUE_DEPTH", shedding.MaxQueueDepth},
+			{"load_shedding.max_heap_mb", "LOAD_SHEDDING_MAX_HEAP_MB", shedding.MaxHeapMB},
+		} {
+			if setting.value < 0 {
+				invalid(setting.key, setting.variable, "expected a positive number, got %d", setting.value)
+			}
+		}
+	}
+	if shared := config.SharedState; shared != nil {
+		switch shared.Backend {
+		case SHARED_STATE_POSTGRESQL:
+			if config.PostgreSQL == nil {
+				problems = append(problems, "shared_state (SHARED_STATE) postgresql requires postgresql")
+			}
+		case SHARED_STATE_REDIS:
+			require(shared.RedisAddr, "shared_state.redis_addr", "SHARED_STATE_REDIS_ADDR")
+		default:
+			invalid("shared_state.backend", "SHARED_STATE", "expected %s or %s, got %q", SHARED_STATE_POSTGRESQL, SHARED_STATE_REDIS, shared.Backend)
+		}
+	}
+	if server := config.Server; server != nil {
+		for _, setting := range []struct {
+			key      string
+			variable string
+			value    int
+		}{
+			{"server.max_connections", "HTTP_MAX_CONNECTIONS", server.MaxConnections},
+			{"server.max_connections_per_ip", "HTTP_MAX_CONNECTIONS_PER_IP", server.MaxConnectionsPerIP},
+			{"server.read_header_timeout_seconds", "HTTP_READ_HEADER_TIMEOUT_SECONDS", server.ReadHeaderTimeoutSeconds},
+			{"server.body_read_timeout_seconds", "SUBMIT_BODY_READ_TIMEOUT_SECONDS", server.BodyReadTimeoutSeconds},
+			{"server.submit_timeout_seconds", "SUBMIT_TIMEOUT_SECONDS", server.SubmitTimeoutSeconds},
+			{"server.write_timeout_seconds", "HTTP_WRITE_TIMEOUT_SECONDS", server.WriteTimeoutSeconds},
+			{"server.idle_timeout_seconds", "HTTP_IDLE_TIMEOUT_SECONDS", server.IdleTimeoutSeconds},
+			{"server.http2_max_concurrent_streams", "HTTP2_MAX_CONCURRENT_STREAMS", server.MaxConcurrentStreams},
+			{"server.drain_delay_seconds", "HTTP_DRAIN_DELAY_SECONDS", server.DrainDelaySeconds},
+		} {
+			if setting.value < 0 {
+				invalid(setting.key, setting.variable, "expected a positive number, got %d", setting.value)
+			}
+		}
+		if server.H2C && server.HTTP2Disabled {
+			problems = append(problems, "server.h2c (HTTP2_H2C) requires HTTP/2, disabled by server.http2_disabled (HTTP2_DISABLED)")
+		}
+		// Submissions exceeding their deadline are responded to within the write timeout
+		if server.SubmitTimeoutSeconds > 0 && server.WriteTimeoutSeconds > 0 && server.SubmitTimeoutSeconds >= server.WriteTimeoutSeconds {
+			invalid("server.submit_timeout_seconds", "SUBMIT_TIMEOUT_SECONDS", "expected below server.write_timeout_seconds (HTTP_WRITE_TIMEOUT_SECONDS) of %d, got %d", server.WriteTimeoutSeconds, server.SubmitTimeoutSeconds)
+		}
+	}
+	if verify := config.SignatureVerify; verify != nil {
+		if verify.Workers < 0 {
+			invalid("signature_verify.workers", "SIGNATURE_VERIFY_WORKERS", "expected a positive number, got %d", verify.Workers)
+		}
+		if verify.QueueSize < 0 {
+			invalid("signature_verify.queue_size", "SIGNATURE_VERIFY_QUEUE_SIZE", "expected a positive number, got %d", verify.QueueSize)
+		}
+		if verify.CacheTTLSeconds != nil && *verify.CacheTTLSeconds < 0 {
+			invalid("signature_verify.cache_ttl_seconds", "SIGNATURE_VERIFY_CACHE_TTL_SECONDS", "expected a positive number, got %d", *verify.CacheTTLSeconds)
+		}
+	}
+	if lockout := config.SignatureLockout; lockout != nil {
+		if lockout.Threshold != nil && *lockout.Threshold < 0 {
+			invalid("signature_lockout.threshold", "SIGNATURE_LOCKOUT_THRESHOLD", "expected a positive number, got %d", *lockout.Threshold)
+		}
+		if lockout.BaseSeconds < 0 {
+			invalid("signature_lockout.base_seconds", "SIGNATURE_LOCKOUT_BASE_SECONDS", "expected a positive number, got %d", lockout.BaseSeconds)
+		}
+		if lockout.MaxSeconds < 0 || (lockout.MaxSeconds > 0 && lockout.MaxSeconds < lockout.BaseSeconds) {
+			invalid("signature_lockout.max_seconds", "SIGNATURE_LOCKOUT_MAX_SECONDS", "expected at least signature_lockout.base_seconds (SIGNATURE_LOCKOUT_BASE_SECONDS) of %d, got %d", lockout.BaseSeconds, lockout.MaxSeconds)
+		}
+	}
+	if config.CreatedAtMaxAgeMinutes < 0 {
+		invalid("created_at_max_age_minutes", "CREATED_AT_MAX_AGE_MINUTES", "expected a positive number, got %d", config.CreatedAtMaxAgeMinutes)
+	}
+	if config.DelegationMaxTTLMinutes < 0 {
+		invalid("delegation_max_ttl_minutes", "DELEGATION_MAX_TTL_MINUTES", "expected a positive number, got %d", config.DelegationMaxTTLMinutes)
+	}
+	if ttl := config.IdempotencyKeyTTLSeconds; ttl != nil && *ttl < 0 {
+		invalid("idempotency_key_ttl_seconds", "IDEMPOTENCY_KEY_TTL_SECONDS", "expected a positive number, got %d", *ttl)
+	}
+	if uploads := config.ChunkedUpload; uploads != nil {
+		if uploads.ChunkSize < 0 {
+			invalid("chunked_upload.chunk_size", "CHUNKED_UPLOAD_CHUNK_SIZE", "expected a positive number, got %d", uploads.ChunkSize)
+		}
+		if uploads.MaxUploads < 0 {
+			invalid("chunked_upload.max_uploads", "CHUNKED_UPLOAD_MAX_UPLOADS", "expected a positive number, got %d", uploads.MaxUploads)
+		}
+	}
+	if config.Index != nil && config.Index.IntervalMinutes < 0 {
+		invalid("index.interval_minutes", "INDEX_INTERVAL_MINUTES", "expected a positive number, got %d", config.Index.IntervalMinutes)
+	}
+	if silence := config.Silence; silence != nil {
+		if silence.AfterMinutes < 0 {
+			invalid("silence.after_minutes", "SILENCE_AFTER_MINUTES", "expected a positive number, got %d", silence.AfterMinutes)
+		}
+		if silence.AfterSlots < 0 {
+			invalid("silence.after_slots", "SILENCE_AFTER_SLOTS", "expected a positive number, got %d", silence.AfterSlots)
+		}
+	}
+	if scoring := config.Scoring; scoring != nil {
+		if scoring.IntervalMinutes < 0 {
+			invalid("scoring.interval_minutes", "SCORING_INTERVAL_MINUTES", "expected a positive number, got %d", scoring.IntervalMinutes)
+		}
+		if scoring.SubmissionIntervalMinutes < 0 {
+			invalid("scoring.submission_interval_minutes", "SCORING_SUBMISSION_INTERVAL_MINUTES", "expected a positive number, got %d", scoring.SubmissionIntervalMinutes)
+		}
+		for _, days := range scoring.WindowDays {
+			if days < 1 {
+				invalid("scoring.window_days", "SCORING_WINDOW_DAYS", "expected windows of at least 1 day, got %d", days)
+			}
+		}
+		if scoring.ExcludeOrphaned && config.Finality == nil {
+			problems = append(problems, "scoring.exclude_orphaned (SCORING_EXCLUDE_ORPHANED) requires finality (FINALITY_ENABLED)")
+		}
+	}
+	if finality := config.Finality; finality != nil {
+		if finality.ArchiveURL == "" {
+			problems = append(problems, "finality requires archive_url (FINALITY_ARCHIVE_URL)")
+		}
+		if finality.AfterHours < 0 {
+			invalid("finality.after_hours", "FINALITY_AFTER_HOURS", "expected a positive number, got %d", finality.AfterHours)
+		}
+		if finality.IntervalMinutes < 0 {
+			invalid("finality.interval_minutes", "FINALITY_INTERVAL_MINUTES", "expected a positive number, got %d", finality.IntervalMinutes)
+		}
+	}
+	if quarantine := config.SignatureQuarantine; quarantine != nil && quarantine.MaxAgeDays < 0 {
+		invalid("signature_quarantine.max_age_days", "SIGNATURE_QUARANTINE_MAX_AGE_DAYS", "expected a positive number, got %d", quarantine.MaxAgeDays)
+	}
+	if leaderboard := config.Leaderboard; leaderboard != nil {
+		if config.Scoring == nil {
+			problems = append(problems, "leaderboard (LEADERBOARD_ENABLED) requires scoring (SCORING_ENABLED)")
+		}
+		if leaderboard.HistoryDays < 0 {
+			invalid("leaderboard.history_days", "LEADERBOARD_HISTORY_DAYS", "expected a positive number, got %d", leaderboard.HistoryDays)
+		}
+		names := make(map[string]bool)
+		for _, tier := range leaderboard.Tiers {
+			if tier.Name == "" || names[tier.Name] {
+				invalid("leaderboard.tiers", "LEADERBOARD_TIERS", "expected tiers of distinct names, got %q", tier.Name)
+			}
+			names[tier.Name] = true
+			if tier.MinUptime < 0 || tier.MinUptime > 100 {
+				invalid("leaderboard.tiers", "LEADERBOARD_TIERS", "expected a min_uptime between 0 and 100, got %v", tier.MinUptime)
+			}
+		}
+	}
+	if retention := config.Retention; retention != nil {
+		if retention.IntervalHours < 0 {
+			invalid("retention.interval_hours", "RETENTION_INTERVAL_HOURS", "expected a positive number, got %d", retention.IntervalHours)
+		}
+		if retention.KeyspacesLookbackDays < 0 {
+			invalid("retention.keyspaces_lookback_days", "RETENTION_KEYSPACES_LOOKBACK_DAYS", "expected a positive number, got %d", retention.KeyspacesLookbackDays)
+		}
+		if retention.OrphanedBlocksGraceHours < 0 {
+			invalid("retention.orphaned_blocks_grace_hours", "RETENTION_ORPHANED_BLOCKS_GRACE_HOURS", "expected a positive number, got %d", retention.OrphanedBlocksGraceHours)
+		}
+		if retention.OrphanedBlocks && retention.S3 == nil && retention.LocalFileSystem == nil {
+			problems = append(problems, "retention.orphaned_blocks (RETENTION_ORPHANED_BLOCKS) requires a retention policy of aws or filesystem")
+		}
+		for _, backend := range []struct {
+			name       string
+			variable   string
+			policy     *RetentionPolicy
+			configured bool
+			archive    string
+		}{
+			{"aws", "S3", retention.S3, config.Aws != nil, "RETENTION_S3_ARCHIVE_PREFIX"},
+			{"aws_keyspaces", "KEYSPACES", retention.AwsKeyspaces, config.AwsKeyspaces != nil, ""},
+			{"postgresql", "POSTGRESQL", retention.PostgreSQL, config.PostgreSQL != nil, ""},
+			{"filesystem", "FILESYSTEM", retention.LocalFileSystem, config.LocalFileSystem != nil, "RETENTION_FILESYSTEM_ARCHIVE_DIR"},
+		} {
+			if backend.policy == nil {
+				continue
+			}
+			key := "retention." + backend.name
+			if !backend.configured {
+				problems = append(problems, fmt.Sprintf("%s requires %s storage", key, backend.name))
+			}
+			if backend.policy.MaxAgeDays < 1 {
+				invalid(key+".max_age_days", "RETENTION_"+backend.variable+"_MAX_AGE_DAYS", "expected at least 1 day, got %d", backend.policy.MaxAgeDays)
+			}
+			if backend.policy.ArchiveTo != "" && backend.archive == "" {
+				problems = append(problems, fmt.Sprintf("%s.archive_to is not supported, rows of databases are deleted", key))
+			}
+			if backend.policy.ArchiveStorageClass != "" && backend.name != "aws" {
+				problems = append(problems, fmt.Sprintf("%s.archive_storage_class is only supported by aws", key))
+			}
+		}
+	}
+	// Features saving objects other than submissions need an object storage
+	if config.Aws == nil && config.LocalFileSystem == nil {
+		for _, feature := range []struct {
+			key      string
+			variable string
+			enabled  bool
+		}{
+			{"daily_reports", "DAILY_REPORTS_ENABLED", config.DailyReports != nil},
+			{"index", "INDEX_ENABLED", config.Index != nil},
+			{"scoring", "SCORING_ENABLED", config.Scoring != nil},
+			{"finality", "FINALITY_ENABLED", config.Finality != nil},
+			{"signature_quarantine", "SIGNATURE_QUARANTINE_ENABLED", config.SignatureQuarantine != nil},
+			{"audit.storage", "AUDIT_LOG_STORAGE_ENABLED", config.Audit != nil && config.Audit.Storage},
+			{"submitter_stats.storage", "SUBMITTER_STATS_STORAGE_ENABLED", config.SubmitterStats != nil && config.SubmitterStats.Storage},
+		} {
+			if feature.enabled {
+				problems = append(problems, fmt.Sprintf("%s (%s) requires aws or filesystem storage", feature.key, feature.variable))
+			}
+		}
+	}
+	if aws := config.Aws; aws != nil {
+		require(aws.AccountId, "aws.account_id", "AWS_ACCOUNT_ID")
+		require(aws.BucketNameSuffix, "aws.bucket_name_suffix", "AWS_BUCKET_NAME_SUFFIX")
+		require(aws.Region, "aws.region", "AWS_REGION")
+	}
+	if ks := config.AwsKeyspaces; ks != nil {
+		require(ks.Keyspace, "aws_keyspaces.keyspace", "AWS_KEYSPACE")
+		require(ks.SSLCertificatePath, "aws_keyspaces.ssl_certificate_path", "AWS_SSL_CERTIFICATE_PATH")
+		if ks.CassandraHost == "" {
+			require(ks.Region, "aws_keyspaces.region", "AWS_REGION")
+		}
+	}
+	if config.LocalFileSystem != nil {
+		require(config.LocalFileSystem.Path, "filesystem.path", "CONFIG_FILESYSTEM_PATH")
+	}
+	if pg := config.PostgreSQL; pg != nil {
+		require(pg.Host, "postgresql.host", "POSTGRES_HOST")
+		require(pg.User, "postgresql.user", "POSTGRES_USER")
+		require(pg.Password, "postgresql.password", "POSTGRES_PASSWORD")
+		require(pg.DBName, "postgresql.database", "POSTGRES_DB")
+		if pg.Port <= 0 || pg.Port > 65535 {
+			invalid("postgresql.port", "POSTGRES_PORT", "expected a port number, got %d", pg.Port)
+		}
+		for _, setting := range []struct {
+			key      string
+			variable string
+			value    int
+		}{
+			{"postgresql.max_open_conns", "POSTGRES_MAX_OPEN_CONNS", pg.MaxOpenConns},
+			{"postgresql.max_idle_conns", "POSTGRES_MAX_IDLE_CONNS", pg.MaxIdleConns},
+			{"postgresql.conn_max_lifetime_minutes", "POSTGRES_CONN_MAX_LIFETIME", pg.ConnMaxLifetimeMinutes},
+		} {
+			if setting.value < 0 {
+				invalid(setting.key, setting.variable, "expected a positive number, got %d", setting.value)
+			}
+		}
+	}
+	if tls := config.TLS; tls != nil {
+		switch {
+		case tls.CertFile == "" && len(tls.AutocertDomains) == 0:
+			problems = append(problems, "tls requires cert_file (TLS_CERT_FILE) or autocert_domains (TLS_AUTOCERT_DOMAINS)")
+		case tls.CertFile != "" && len(tls.AutocertDomains) > 0:
+			problems = append(problems, "tls cert_file (TLS_CERT_FILE) and autocert_domains (TLS_AUTOCERT_DOMAINS) are mutually exclusive")
+		case tls.CertFile != "":
+			require(tls.KeyFile, "tls.key_file", "TLS_KEY_FILE")
+		}
+		if tls.ListenTo != "" {
+			if _, _, err := parseListenAddress(tls.ListenTo); err != nil {
+				invalid("tls.listen_to", "TLS_LISTEN_TO", "%v", err)
+			}
+		}
+	}
+	if anomaly := config.AnomalyDetection; anomaly != nil {
+		for _, rule := range []struct {
+			key, prefix string
+			cfg         *AnomalyRuleConfig
+		}{
+			{"anomaly_detection.ip_fanout", "ANOMALY_IP_FANOUT", anomaly.IPFanout},
+			{"anomaly_detection.shared_block", "ANOMALY_SHARED_BLOCK", anomaly.SharedBlock},
+			{"anomaly_detection.rate_spike", "ANOMALY_RATE_SPIKE", anomaly.RateSpike},
+		} {
+			if rule.cfg == nil {
+				continue
+			}
+			if _, err := ParseAnomalyAction(rule.cfg.Action); err != nil {
+				invalid(rule.key+".action", rule.prefix+"_ACTION", "%v", err)
+			}
+			if rule.cfg.Threshold <= 0 {
+				invalid(rule.key+".threshold", rule.prefix+"_THRESHOLD", "expected a positive number, got %d", rule.cfg.Threshold)
+			}
+		}
+	}
+	if config.Receipts != nil {
+		require(config.Receipts.SigningKeyFile, "receipts.signing_key_file", "RECEIPT_SIGNING_KEY_FILE")
+	}
+	if logCfg := config.Logging; logCfg != nil {
+		if _, err := parseLogLevel(logCfg.Level); err != nil {
+			invalid("logging.level", "LOG_LEVEL", "%v", err)
+		}
+		if _, err := parseLogFormat(logCfg.Format); err != nil {
+			invalid("logging.format", "LOG_FORMAT", "%v", err)
+		}
+		for subsystem, level := range logCfg.SubsystemLevels {
+			if _, err := logging.LevelFromString(level); err != nil {
+				invalid("logging.subsystem_levels."+subsystem, "LOG_SUBSYSTEM_LEVELS", "%v", err)
+			}
+		}
+	}
+	if config.ErrorReporting != nil {
+		require(config.ErrorReporting.SentryDSN, "error_reporting.sentry_dsn", "SENTRY_DSN")
+	}
+	if alerting := config.Alerting; alerting != nil {
+		if alerting.SlackWebhookURL == "" && alerting.DiscordWebhookURL == "" && alerting.WebhookURL == "" {
+			problems = append(problems, "alerting requires slack_webhook_url (ALERT_SLACK_WEBHOOK_URL), discord_webhook_url (ALERT_DISCORD_WEBHOOK_URL) or webhook_url (ALERT_WEBHOOK_URL)")
+		}
+		for _, event := range alerting.Events {
+			known := false
+			for _, e := range ALERT_EVENTS {
+				known = known || e == event
+			}
+			if !known {
+				invalid("alerting.events", "ALERT_EVENTS", "unknown event %q, expected one of %s", event, strings.Join(ALERT_EVENTS, ", "))
+			}
+		}
+	}
+	if slo := config.SLO; slo != nil && (slo.Objective < 0 || slo.Objective >= 1) {
+		invalid("slo.objective", "SLO_OBJECTIVE", "expected a ratio between 0 and 1, got %v", slo.Objective)
+	}
+	if accessLog := config.AccessLog; accessLog != nil {
+		switch accessLog.Format {
+		case "", ACCESS_LOG_FORMAT_COMMON, ACCESS_LOG_FORMAT_COMBINED, ACCESS_LOG_FORMAT_JSON:
+		default:
+			invalid("access_log.format", "ACCESS_LOG_FORMAT", "expected common, combined or json, got %q", accessLog.Format)
+		}
+	}
+	if config.Canary != nil {
+		require(config.Canary.RequestFile, "canary.request_file", "CANARY_REQUEST_FILE")
+	}
+	if tracing := config.Tracing; tracing != nil {
+		require(tracing.OtlpEndpoint, "tracing.otlp_endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT")
+		if tracing.SampleRatio != nil && (*tracing.SampleRatio < 0 || *tracing.SampleRatio > 1) {
+			invalid("tracing.sample_ratio", "OTEL_TRACES_SAMPLER_ARG", "expected a ratio between 0 and 1, got %v", *tracing.SampleRatio)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("  - " + strings.Join(problems, "\n  - "))
 }
 
 func getEnvChecked(variable string, log logging.EventLogger) string {
@@ -184,6 +1388,18 @@ func boolEnvChecked(variable string, log logging.EventLogger) bool {
 	}
 }
 
+// Overrides the rule with <prefix>_THRESHOLD, <prefix>_WINDOW_MINUTES and
+// <prefix>_ACTION, the rule is created if threshold is set.
+func anomalyRuleFromEnv(rule **AnomalyRuleConfig, prefix string, log logging.EventLogger) {
+	envSection(rule, prefix+"_THRESHOLD")
+	if *rule == nil {
+		return
+	}
+	envInt(&(*rule).Threshold, prefix+"_THRESHOLD", log)
+	envInt(&(*rule).WindowMinutes, prefix+"_WINDOW_MINUTES", log)
+	envString(&(*rule).Action, prefix+"_ACTION")
+}
+
 type AwsConfig struct {
 	AccountId        string `json:"account_id"`
 	BucketNameSuffix string `json:"bucket_name_suffix"`
@@ -218,17 +1434,711 @@ type PostgreSQLConfig struct {
 	Password string `json:"password"`
 	DBName   string `json:"database"`
 	SSLMode  string `json:"sslmode"`
+	// Connections of the pool [default: 20]
+	MaxOpenConns int `json:"max_open_conns,omitempty"`
+	// Idle connections kept open [default: 10]
+	MaxIdleConns int `json:"max_idle_conns,omitempty"`
+	// Lifetime of connections in minutes [default: 30]
+	ConnMaxLifetimeMinutes int `json:"conn_max_lifetime_minutes,omitempty"`
+	// Don't apply schema migrations on start
+	SkipMigrations bool `json:"skip_migrations,omitempty"`
+}
+
+type TLSConfig struct {
+	ListenTo         string   `json:"listen_to,omitempty"`
+	CertFile         string   `json:"cert_file,omitempty"`
+	KeyFile          string   `json:"key_file,omitempty"`
+	AutocertDomains  []string `json:"autocert_domains,omitempty"`
+	AutocertCacheDir string   `json:"autocert_cache_dir,omitempty"`
+	AutocertEmail    string   `json:"autocert_email,omitempty"`
+	ClientCAFile     string   `json:"client_ca_file,omitempty"`
+	ClientCertMap    string   `json:"client_cert_map,omitempty"`
+}
+
+type ReplayProtectionConfig struct {
+	StateFile string `json:"state_file,omitempty"`
+}
+
+type ReceiptsConfig struct {
+	SigningKeyFile string `json:"signing_key_file"`
+	SigningKeyId   string `json:"signing_key_id,omitempty"`
+}
+
+type AnomalyRuleConfig struct {
+	Threshold     int    `json:"threshold"`
+	WindowMinutes int    `json:"window_minutes,omitempty"`
+	Action        string `json:"action,omitempty"`
+}
+
+type AnomalyDetectionConfig struct {
+	IPFanout    *AnomalyRuleConfig `json:"ip_fanout,omitempty"`
+	SharedBlock *AnomalyRuleConfig `json:"shared_block,omitempty"`
+	RateSpike   *AnomalyRuleConfig `json:"rate_spike,omitempty"`
+}
+
+type SecretsConfig struct {
+	AwsRegion              string `json:"aws_region,omitempty"`
+	VaultAddr              string `json:"vault_addr,omitempty"`
+	RefreshIntervalMinutes int    `json:"refresh_interval_minutes,omitempty"`
+}
+
+type LoggingConfig struct {
+	// debug, info, warn or error, info if not set
+	Level string `json:"level,omitempty"`
+	// json (default), console or color
+	Format string `json:"format,omitempty"`
+	// File logs are written to instead of stderr
+	File            string            `json:"file,omitempty"`
+	SubsystemLevels map[string]string `json:"subsystem_levels,omitempty"`
+}
+
+type ErrorReportingConfig struct {
+	SentryDSN   string `json:"sentry_dsn"`
+	Environment string `json:"environment,omitempty"`
+	Release     string `json:"release,omitempty"`
+	// Storage failures of a backend within a minute reported as a burst
+	StorageFailureThreshold int `json:"storage_failure_threshold,omitempty"`
+}
+
+type AlertingConfig struct {
+	SlackWebhookURL   string `json:"slack_webhook_url,omitempty"`
+	DiscordWebhookURL string `json:"discord_webhook_url,omitempty"`
+	WebhookURL        string `json:"webhook_url,omitempty"`
+	// Events alerted on, all if empty
+	Events []string `json:"events,omitempty"`
+	// Minimum interval between repeated alerts of an event
+	CooldownMinutes int `json:"cooldown_minutes,omitempty"`
+	// Consecutive failures of whitelist refresh alerted on
+	WhitelistFailureThreshold int `json:"whitelist_failure_threshold,omitempty"`
+	// Period without accepted submissions alerted on
+	SubmissionsIdleMinutes int `json:"submissions_idle_minutes,omitempty"`
+}
+
+type SLOConfig struct {
+	// Latency a request must not exceed [default: 1000]
+	LatencyTargetMs int `json:"latency_target_ms,omitempty"`
+	// Ratio of requests to meet the target, e.g. 0.99
+	Objective float64 `json:"objective,omitempty"`
+	// Rolling window the SLO is evaluated over [default: 60]
+	WindowMinutes int `json:"window_minutes,omitempty"`
+}
+
+type StorageUsageConfig struct {
+	IntervalMinutes int `json:"interval_minutes,omitempty"`
+}
+
+// Indexes of days of submissions, maintained by the leader
+type IndexConfig struct {
+	// Minutes between runs of the indexer [default: 15]
+	IntervalMinutes int `json:"interval_minutes,omitempty"`
+}
+
+// Detection of submitters which stopped submitting
+type SilenceConfig struct {
+	// Minutes without submission after which a submitter is silent
+	// [default: 60]
+	AfterMinutes int `json:"after_minutes,omitempty"`
+	// Slots without submission after which a submitter is silent, takes
+	// precedence over after_minutes
+	AfterSlots int `json:"after_slots,omitempty"`
+}
+
+// Uptime scores of submitters, computed by the leader
+type ScoringConfig struct {
+	// Minutes between runs of the scorer [default: 60]
+	IntervalMinutes int `json:"interval_minutes,omitempty"`
+	// Sliding windows of scores in days [default: 90]
+	WindowDays []int `json:"window_days,omitempty"`
+	// Minutes of the intervals a submitter is expected to submit in
+	// [default: 15]
+	SubmissionIntervalMinutes int `json:"submission_interval_minutes,omitempty"`
+	// Submissions of blocks found orphaned by the finality checker aren't
+	// counted, requires finality
+	ExcludeOrphaned bool `json:"exclude_orphaned,omitempty"`
+}
+
+// Lookups of the blocks of submissions in a Mina archive, once final,
+// telling canonical blocks from orphaned ones
+type FinalityConfig struct {
+	// GraphQL endpoint of the archive
+	ArchiveURL string `json:"archive_url"`
+	// Hours after the end of a day after which its blocks are final
+	// [default: 24]
+	AfterHours int `json:"after_hours,omitempty"`
+	// Minutes between runs of the checker [default: 60]
+	IntervalMinutes int `json:"interval_minutes,omitempty"`
+}
+
+// Quarantine of submissions failing signature verification under
+// rejected/ of the object storage, S3 if configured
+type SignatureQuarantineConfig struct {
+	// Days submissions stay quarantined [default: 14]
+	MaxAgeDays int `json:"max_age_days,omitempty"`
+}
+
+// Leaderboard of the uptime scores
+type LeaderboardConfig struct {
+	// Tiers of submitters by min uptime
+	Tiers []LeaderboardTier `json:"tiers,omitempty"`
+	// Days of history of submitters [default: 30]
+	HistoryDays int `json:"history_days,omitempty"`
+}
+
+type DiagnosticsConfig struct {
+	// Address of the diagnostics listener, guarded by the
+	// admin token unless on the loopback interface
+	ListenTo string `json:"listen_to,omitempty"`
+}
+
+type AccessLogConfig struct {
+	// File the access log is written to, stdout if not set
+	File string `json:"file,omitempty"`
+	// common, combined (default) or json
+	Format string `json:"format,omitempty"`
+	// Size the file is rotated at, and number of rotated files kept
+	MaxSizeMB  int `json:"max_size_mb,omitempty"`
+	MaxBackups int `json:"max_backups,omitempty"`
+}
+
+type CanaryConfig struct {
+	// File of a signed submission of the canary key
+	RequestFile     string `json:"request_file"`
+	IntervalMinutes int    `json:"interval_minutes,omitempty"`
+}
+
+type DailyReportsConfig struct {
+	// File counters of days not yet reported are persisted to and restored from
+	StateFile string `json:"state_file,omitempty"`
+}
+
+type SubmitterStatsConfig struct {
+	// File the statistics are persisted to and restored from
+	StateFile string `json:"state_file,omitempty"`
+	// Save snapshots under the stats/submitters/ prefix of S3 and local filesystem storage
+	Storage bool `json:"storage,omitempty"`
+	// Days covered by the statistics [default: 7]
+	WindowDays int `json:"window_days,omitempty"`
+}
+
+type AuditConfig struct {
+	// File the audit records are appended to
+	File string `json:"file,omitempty"`
+	// Save audit records under the audit/ prefix of S3 and local filesystem storage
+	Storage bool `json:"storage,omitempty"`
+}
+
+type TracingConfig struct {
+	OtlpEndpoint string `json:"otlp_endpoint"`
+	// Ratio of traces sampled, 1 if not set
+	SampleRatio *float64          `json:"sample_ratio,omitempty"`
+	ServiceName string            `json:"service_name,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+type RateLimitConfig struct {
+	// Submissions accepted per public key per hour [default: 120]
+	RequestsPerPkHourly int `json:"requests_per_pk_hourly,omitempty"`
+	// Hourly limits of public keys, taking precedence over the default
+	Overrides map[string]int `json:"overrides,omitempty"`
+}
+
+type FeatureFlagConfig struct {
+	Enabled bool `json:"enabled"`
+	// Names of networks the flag is enabled on, all if empty
+	Networks []string `json:"networks,omitempty"`
+	// Percentage of submitters the flag is enabled for [default: 100]
+	RolloutPercent *int `json:"rollout_percent,omitempty"`
+}
+
+type LeaderElectionConfig struct {
+	// Key of the PostgreSQL advisory lock, replicas sharing it elect one leader [default: 7201]
+	LockId int `json:"lock_id,omitempty"`
+}
+
+type WriteBatchingConfig struct {
+	// Submissions written at once, at most 30 on Keyspaces [default: 100]
+	Size int `json:"size,omitempty"`
+	// Longest wait for a batch to fill up [default: 50]
+	IntervalMs int `json:"interval_ms,omitempty"`
+}
+
+type LoadSheddingConfig struct {
+	// Requests handled at once [default: 256]
+	MaxInFlight int `json:"max_in_flight,omitempty"`
+	// Signature verifications waiting for a worker [default: SIGNATURE_VERIFY_QUEUE_SIZE]
+	MaxQueueDepth int `json:"max_queue_depth,omitempty"`
+	// Heap size in MiB, no limit if zero
+	MaxHeapMB int `json:"max_heap_mb,omitempty"`
+}
+
+type SharedStateConfig struct {
+	// postgresql or redis
+	Backend string `json:"backend"`
+	// host:port of the Redis server
+	RedisAddr     string `json:"redis_addr,omitempty"`
+	RedisPassword string `json:"redis_password,omitempty"`
+	RedisDB       int    `json:"redis_db,omitempty"`
+}
+
+// Tuning of the HTTP servers, for clients reconnecting at once e.g. after
+// a restart
+type HTTPServerConfig struct {
+	// Connections open at once, no limit if zero
+	MaxConnections int `json:"max_connections,omitempty"`
+	// Connections open at once per client IP, no limit if zero
+	MaxConnectionsPerIP int `json:"max_connections_per_ip,omitempty"`
+	// Timeouts protecting the service from slow clients, see ServerTimeouts
+	// [defaults: 10, 60, 90, 120 and 120]
+	ReadHeaderTimeoutSeconds int  `json:"read_header_timeout_seconds,omitempty"`
+	BodyReadTimeoutSeconds   int  `json:"body_read_timeout_seconds,omitempty"`
+	SubmitTimeoutSeconds     int  `json:"submit_timeout_seconds,omitempty"`
+	WriteTimeoutSeconds      int  `json:"write_timeout_seconds,omitempty"`
+	IdleTimeoutSeconds       int  `json:"idle_timeout_seconds,omitempty"`
+	KeepAlivesDisabled       bool `json:"keep_alives_disabled,omitempty"`
+	// HTTP/2 is negotiated over TLS unless disabled
+	HTTP2Disabled bool `json:"http2_disabled,omitempty"`
+	// Cleartext HTTP/2 on the plain listener
+	H2C bool `json:"h2c,omitempty"`
+	// Streams per HTTP/2 connection [default: 250]
+	MaxConcurrentStreams int `json:"http2_max_concurrent_streams,omitempty"`
+	// Seconds the instance drains, failing readiness, before shutting down
+	// on SIGTERM, so that load balancers stop routing to it first
+	DrainDelaySeconds int `json:"drain_delay_seconds,omitempty"`
+}
+
+// Workers verifying signatures of submissions, bounding the CPU spent on
+// verification
+type SignatureVerifyConfig struct {
+	// Verifications run at once [default: GOMAXPROCS]
+	Workers int `json:"workers,omitempty"`
+	// Verifications waiting for a worker, others are rejected [default:
+	// 16 per worker]
+	QueueSize int `json:"queue_size,omitempty"`
+	// Seconds results are cached, zero disables the cache [default: 600]
+	CacheTTLSeconds *int `json:"cache_ttl_seconds,omitempty"`
+}
+
+// Uploads of submissions in chunks, see ChunkedUploads
+type ChunkedUploadConfig struct {
+	// Max size in bytes of a chunk [default: 1048576]
+	ChunkSize int `json:"chunk_size,omitempty"`
+	// Uploads in progress at once [default: 64]
+	MaxUploads int `json:"max_uploads,omitempty"`
+}
+
+// Lockout of submitters and client IPs after repeated invalid signatures
+type SignatureLockoutConfig struct {
+	// Invalid signatures within 10 minutes before a lockout, zero disables
+	// lockouts [default: 10]
+	Threshold *int `json:"threshold,omitempty"`
+	// First lockout, doubled with every subsequent one up to the max
+	// [default: 60]
+	BaseSeconds int `json:"base_seconds,omitempty"`
+	// [default: 3600]
+	MaxSeconds int `json:"max_seconds,omitempty"`
+}
+
+// Retention of submissions and blocks by backend, the leader deletes
+// what is older than the maximum age of the backend. Backends without a
+// policy keep everything.
+type RetentionConfig struct {
+	// Hours between cleanups [default: 24]
+	IntervalHours   int              `json:"interval_hours,omitempty"`
+	S3              *RetentionPolicy `json:"aws,omitempty"`
+	AwsKeyspaces    *RetentionPolicy `json:"aws_keyspaces,omitempty"`
+	PostgreSQL      *RetentionPolicy `json:"postgresql,omitempty"`
+	LocalFileSystem *RetentionPolicy `json:"filesystem,omitempty"`
+	// Days before the cutoff whose Keyspaces partitions are deleted [default: 7]
+	KeyspacesLookbackDays int `json:"keyspaces_lookback_days,omitempty"`
+	// Delete blocks referenced by no submission after cleanups of S3 and
+	// local filesystem storage
+	OrphanedBlocks bool `json:"orphaned_blocks,omitempty"`
+	// Hours after being saved during which blocks are kept [default: 48]
+	OrphanedBlocksGraceHours int `json:"orphaned_blocks_grace_hours,omitempty"`
+}
+
+type RetentionPolicy struct {
+	MaxAgeDays int `json:"max_age_days"`
+	// S3 prefix (of the same bucket) or directory (of the same filesystem)
+	// objects are moved to rather than deleted
+	ArchiveTo string `json:"archive_to,omitempty"`
+	// Storage class of objects archived to S3, e.g. GLACIER
+	ArchiveStorageClass string `json:"archive_storage_class,omitempty"`
+}
+
+// Configuration of feature flags by name
+type FeatureFlagConfigs map[string]FeatureFlagConfig
+
+// Sanity checks of the blocks of a network, rejecting submissions whose
+// block isn't a Mina block of the network before they are saved
+type BlockValidationConfig struct {
+	// Serialization of blocks, v1 (with version tags) or v2
+	Format string `json:"format"`
+	// Base58check-encoded genesis state hash, blocks of other chains are
+	// rejected if set
+	GenesisStateHash string `json:"genesis_state_hash,omitempty"`
+	// Min size of blocks in bytes [default: 1024]
+	MinSize int `json:"min_size,omitempty"`
+	// Log and count blocks failing validation without rejecting them
+	ReportOnly bool `json:"report_only,omitempty"`
+}
+
+// Block validation by network name, blocks of networks without one
+// aren't validated
+type BlockValidationConfigs map[string]*BlockValidationConfig
+
+// Timing of the slots of a network, to derive the global slot and the
+// epoch of submissions
+type NetworkTimingConfig struct {
+	// RFC3339 timestamp of the genesis of the network
+	GenesisTimestamp string `json:"genesis_timestamp"`
+	// Duration of slots [default: 180000]
+	SlotDurationMs int `json:"slot_duration_ms,omitempty"`
+	// [default: 7140]
+	SlotsPerEpoch int `json:"slots_per_epoch,omitempty"`
+	// Skew of created_at allowed around the global slot provided by the
+	// client [default: the duration of a slot]
+	MaxSkewSeconds int `json:"max_skew_seconds,omitempty"`
+}
+
+// Network timing by network name, slots of submissions of networks
+// without one aren't recorded
+type NetworkTimingConfigs map[string]*NetworkTimingConfig
+
+// Windows of slots submitters are credited once per
+type SubmissionWindowConfig struct {
+	// Slots of a window [default: 5]
+	Slots int `json:"slots,omitempty"`
+	// reject or deduplicate submissions to a window already credited
+	// [default: reject]
+	Mode string `json:"mode,omitempty"`
+}
+
+// Signature parameters of a network
+type SignatureConfig struct {
+	// Network id passed to the signer, which selects the signature prefix:
+	// 1 for MinaSignatureMainnet, 0 for CodaSignature******* of testnets
+	NetworkId *int `json:"network_id,omitempty"`
+	// Name of the signature scheme in the registry [default: mina_schnorr]
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// Signature parameters by network name, networks without them are of
+// the network id NetworkId of their name and of the Mina Schnorr scheme
+type SignatureConfigs map[string]*SignatureConfig
+
+// NetworkId of signatures of the network
+func (configs SignatureConfigs) NetworkId(networkName string) uint8 {
+	if config := configs[networkName]; config != nil && config.NetworkId != nil {
+		return uint8(*config.NetworkId)
+	}
+	return NetworkId(networkName)
+}
+
+// Scheme of signatures of the network, of its network id
+func (configs SignatureConfigs) Scheme(networkName string) (SignatureScheme, error) {
+	name := SIGNATURE_SCHEME_MINA_SCHNORR
+	if config := configs[networkName]; config != nil && config.Scheme != "" {
+		name = config.Scheme
+	}
+	return NewSignatureScheme(name, configs.NetworkId(networkName))
+}
+
+// Problems of signature parameters, by network
+func validateSignatureConfigs(configs SignatureConfigs) []string {
+	networks := make([]string, 0, len(configs))
+	for network := range configs {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	var problems []string
+	for _, network := range networks {
+		config := configs[network]
+		if config != nil && config.NetworkId != nil && (*config.NetworkId < 0 || *config.NetworkId > math.MaxUint8) {
+			problems = append(problems, fmt.Sprintf("%s: network_id isn't a byte: %d", network, *config.NetworkId))
+		}
+		if config == nil || config.Scheme == "" {
+			continue
+		}
+		if _, err := NewSignatureScheme(config.Scheme, 0); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", network, err))
+		} else if network == "mainnet" && isTestOnlySignatureScheme(config.Scheme) {
+			problems = append(problems, fmt.Sprintf("%s: signature scheme %s is for tests only", network, config.Scheme))
+		}
+	}
+	return problems
+}
+
+// Clock skew policy of a network
+type ClockSkewConfig struct {
+	// Seconds created_at may be ahead of the clock of the backend, as
+	// clocks of submitters drift [default: 300]
+	MaxFutureSeconds *int `json:"max_future_seconds,omitempty"`
+}
+
+// Clock skew policies by network name, networks without one tolerate
+// TIME_DIFF_DELTA
+type ClockSkewConfigs map[string]*ClockSkewConfig
+
+// Problems of clock skew policies, by network
+func validateClockSkewConfigs(configs ClockSkewConfigs) []string {
+	networks := make([]string, 0, len(configs))
+	for network := range configs {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	var problems []string
+	for _, network := range networks {
+		config := configs[network]
+		if config != nil && config.MaxFutureSeconds != nil && *config.MaxFutureSeconds < 0 {
+			problems = append(problems, fmt.Sprintf("%s: max_future_seconds is negative: %d", network, *config.MaxFutureSeconds))
+		}
+	}
+	return problems
+}
+
+// Check of the clock of the host against an NTP server, at start and
+// every interval
+type ClockCheckConfig struct {
+	// host:port of the server, the port defaults to 123 [default:
+	// pool.ntp.org]
+	NTPServer string `json:"ntp_server,omitempty"`
+	// Skew of the clock above which a warning is logged [default: 1000]
+	MaxSkewMs int `json:"max_skew_ms,omitempty"`
+	// Minutes between checks [default: 60]
+	IntervalMinutes int `json:"interval_minutes,omitempty"`
+}
+
+// Guard against jumps of the clock of the host, detected against the
+// monotonic clock
+type ClockGuardConfig struct {
+	// Jump of the clock between readings above which the clock is suspect
+	// [default: 2000]
+	MaxJumpMs int `json:"max_jump_ms,omitempty"`
+	// Minutes the clock stays suspect after a jump, unless the clock check
+	// finds it accurate before [default: 10]
+	SettleMinutes int `json:"settle_minutes,omitempty"`
+	// flag or reject submissions while the clock is suspect [default: flag]
+	Action string `json:"action,omitempty"`
+}
+
+// Destinations of events of accepted submissions of a network, either or
+// both of them
+type SubmissionEventsConfig struct {
+	// ARN of the SNS topic, of a FIFO topic if it ends with .fifo
+	SNSTopicArn string `json:"sns_topic_arn,omitempty"`
+	// URL of the SQS queue, of a FIFO queue if it ends with .fifo
+	SQSQueueURL string `json:"sqs_queue_url,omitempty"`
+}
+
+// Destinations of events by network name, no events are published for
+// other networks
+type SubmissionEventsConfigs map[string]*SubmissionEventsConfig
+
+// Problems of destinations of events, by network
+func validateSubmissionEventsConfigs(configs SubmissionEventsConfigs) []string {
+	networks := make([]string, 0, len(configs))
+	for network := range configs {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	var problems []string
+	for _, network := range networks {
+		config := configs[network]
+		if config == nil || (config.SNSTopicArn == "" && config.SQSQueueURL == "") {
+			problems = append(problems, fmt.Sprintf("%s: expected sns_topic_arn or sqs_queue_url", network))
+			continue
+		}
+		if config.SNSTopicArn != "" && snsTopicRegion(config.SNSTopicArn) == "" {
+			problems = append(problems, fmt.Sprintf("%s: invalid sns_topic_arn %q, expected arn:aws:sns:<region>:<account>:<topic>", network, config.SNSTopicArn))
+		}
+		if config.SQSQueueURL != "" && sqsQueueRegion(config.SQSQueueURL) == "" {
+			problems = append(problems, fmt.Sprintf("%s: invalid sqs_queue_url %q, expected https://sqs.<region>.amazonaws.com/<account>/<queue>", network, config.SQSQueueURL))
+		}
+	}
+	return problems
+}
+
+// Cross-check of the node of submitters in its GraphQL API, at the address
+// of the submission and its graphql_control_port, recorded in metadata
+type LivenessCheckConfig struct {
+	// Timeout of the query of a node, the submission waits for it
+	// [default: 2000]
+	TimeoutMs int `json:"timeout_ms,omitempty"`
+	// Check nodes at loopback, private or link-local addresses too, e.g.
+	// of a private testnet
+	AllowPrivateAddresses bool `json:"allow_private_addresses,omitempty"`
+}
+
+// Endpoint webhooks are fired to
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// Key of HMAC-SHA256 signatures of deliveries, or a secret reference,
+	// deliveries aren't signed if empty
+	Secret string `json:"secret,omitempty"`
+	// Events fired to the endpoint, all if empty
+	Events []string `json:"events,omitempty"`
+}
+
+// Webhooks fired on events of the backend
+type WebhooksConfig struct {
+	Endpoints []WebhookConfig `json:"endpoints"`
+	// Attempts of a delivery before it's dead-lettered [default: 5]
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// Delay before the first retry, doubled on each retry up to 5
+	// minutes [default: 1000]
+	BackoffMs int `json:"backoff_ms,omitempty"`
+	// File deliveries failing every attempt are appended to as JSON
+	// lines, they are logged as errors if empty
+	DeadLetterFile string `json:"dead_letter_file,omitempty"`
+}
+
+// Write-back of the status of block producers of the whitelist to a tab
+// of the whitelist spreadsheet, by the leader. The credentials of the
+// spreadsheet need write access.
+type SheetsStatusConfig struct {
+	// Tab written, created if it doesn't exist and replaced on each write
+	// [default: Status]
+	Tab string `json:"tab,omitempty"`
+	// Minutes between writes [default: 60]
+	IntervalMinutes int `json:"interval_minutes,omitempty"`
+}
+
+// Faults injected into the backend for chaos and integration tests, not
+// on mainnet
+type FaultInjectionConfig struct {
+	// Faults of saving submissions, by storage backend: s3, keyspaces,
+	// postgresql, filesystem, nats or memory
+	Storage map[string]*StorageFaultConfig `json:"storage,omitempty"`
+	// Fraction of refreshes of the delegation whitelist failing, 1 for an
+	// outage of Google Sheets. The whitelist retrieved on start is not
+	// affected.
+	WhitelistErrorRate float64 `json:"whitelist_error_rate,omitempty"`
+	// Seconds the clock of the backend is ahead of the clock of the host,
+	// behind if negative
+	ClockSkewSeconds int `json:"clock_skew_seconds,omitempty"`
+}
+
+type StorageFaultConfig struct {
+	// Latency added to every save
+	LatencyMs int `json:"latency_ms,omitempty"`
+	// Random latency added on top of LatencyMs, up to
+	LatencyJitterMs int `json:"latency_jitter_ms,omitempty"`
+	// Fraction of saves failing, without saving
+	ErrorRate float64 `json:"error_rate,omitempty"`
+}
+
+// Publishing of submissions to NATS JetStream, saving a submission fails
+// unless the stream acknowledged it
+type NATSConfig struct {
+	// nats://host:port, credentials can be given as user:password@ or
+	// token@
+	URL      string `json:"url"`
+	Token    string `json:"token,omitempty"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Stream created or updated on start [default: UPTIME]
+	Stream string `json:"stream,omitempty"`
+	// Prefix of subjects of the stream [default: uptime.<network name>]
+	SubjectPrefix string `json:"subject_prefix,omitempty"`
+	// Publish references of blocks to <subject prefix>.blocks
+	PublishBlocks bool `json:"publish_blocks,omitempty"`
+	// Age of messages the stream discards, unlimited if 0
+	MaxAgeDays int `json:"max_age_days,omitempty"`
+	// Replicas of the stream in a cluster [default: 1]
+	Replicas int `json:"replicas,omitempty"`
+	// Timeout of acknowledgements of the stream [default: 5000]
+	AckTimeoutMs int `json:"ack_timeout_ms,omitempty"`
+	// CA verifying the server, system roots otherwise. Connections use TLS
+	// with tls:// URLs or when the server requires it.
+	TLSCAFile string `json:"tls_ca_file,omitempty"`
+	// Client certificate and key, for servers verifying clients
+	TLSCertFile string `json:"tls_cert_file,omitempty"`
+	TLSKeyFile  string `json:"tls_key_file,omitempty"`
 }
 
 type AppConfig struct {
-	NetworkName                 string                 `json:"network_name"`
-	GsheetId                    string                 `json:"gsheet_id"`
-	DelegationWhitelistList     string                 `json:"delegation_whitelist_list"`
-	DelegationWhitelistColumn   string                 `json:"delegation_whitelist_column"`
-	DelegationWhitelistDisabled bool                   `json:"delegation_whitelist_disabled,omitempty"`
-	VerifySignatureDisabled     bool                   `json:"verify_signature_disabled,omitempty"`
-	Aws                         *AwsConfig             `json:"aws,omitempty"`
-	AwsKeyspaces                *AwsKeyspacesConfig    `json:"aws_keyspaces,omitempty"`
-	LocalFileSystem             *LocalFileSystemConfig `json:"filesystem,omitempty"`
-	PostgreSQL                  *PostgreSQLConfig      `json:"postgresql,omitempty"`
+	NetworkName                 string                  `json:"network_name"`
+	GsheetId                    string                  `json:"gsheet_id"`
+	DelegationWhitelistList     string                  `json:"delegation_whitelist_list"`
+	DelegationWhitelistColumn   string                  `json:"delegation_whitelist_column"`
+	DelegationWhitelistDisabled bool                    `json:"delegation_whitelist_disabled,omitempty"`
+	VerifySignatureDisabled     bool                    `json:"verify_signature_disabled,omitempty"`
+	SignPayloadVersions         []int                   `json:"sign_payload_versions,omitempty"`
+	Aws                         *AwsConfig              `json:"aws,omitempty"`
+	AwsKeyspaces                *AwsKeyspacesConfig     `json:"aws_keyspaces,omitempty"`
+	LocalFileSystem             *LocalFileSystemConfig  `json:"filesystem,omitempty"`
+	PostgreSQL                  *PostgreSQLConfig       `json:"postgresql,omitempty"`
+	TrustedProxyCIDRs           []string                `json:"trusted_proxy_cidrs,omitempty"`
+	ClientIPHeader              string                  `json:"client_ip_header,omitempty"`
+	IPAllowlist                 []string                `json:"ip_allowlist,omitempty"`
+	IPDenylist                  []string                `json:"ip_denylist,omitempty"`
+	TLS                         *TLSConfig              `json:"tls,omitempty"`
+	ReplayProtection            *ReplayProtectionConfig `json:"replay_protection,omitempty"`
+	AdminToken                  string                  `json:"admin_token,omitempty"`
+	Receipts                    *ReceiptsConfig         `json:"receipts,omitempty"`
+	AnomalyDetection            *AnomalyDetectionConfig `json:"anomaly_detection,omitempty"`
+	GsheetCredentials           string                  `json:"gsheet_credentials,omitempty"`
+	Secrets                     *SecretsConfig          `json:"secrets,omitempty"`
+	Tracing                     *TracingConfig          `json:"tracing,omitempty"`
+	Audit                       *AuditConfig            `json:"audit,omitempty"`
+	Logging                     *LoggingConfig          `json:"logging,omitempty"`
+	ErrorReporting              *ErrorReportingConfig   `json:"error_reporting,omitempty"`
+	SubmitterStats              *SubmitterStatsConfig   `json:"submitter_stats,omitempty"`
+	DailyReports                *DailyReportsConfig     `json:"daily_reports,omitempty"`
+	Canary                      *CanaryConfig           `json:"canary,omitempty"`
+	AccessLog                   *AccessLogConfig        `json:"access_log,omitempty"`
+	Diagnostics                 *DiagnosticsConfig      `json:"diagnostics,omitempty"`
+	StorageUsage                *StorageUsageConfig     `json:"storage_usage,omitempty"`
+	Index                       *IndexConfig            `json:"index,omitempty"`
+	Silence                     *SilenceConfig          `json:"silence,omitempty"`
+	Scoring                     *ScoringConfig          `json:"scoring,omitempty"`
+	Leaderboard                 *LeaderboardConfig      `json:"leaderboard,omitempty"`
+	Finality                    *FinalityConfig         `json:"finality,omitempty"`
+	Alerting                    *AlertingConfig         `json:"alerting,omitempty"`
+	SLO                         *SLOConfig              `json:"slo,omitempty"`
+	WhitelistRefreshMinutes     int                     `json:"delegation_whitelist_refresh_interval_minutes,omitempty"`
+	RateLimit                   *RateLimitConfig        `json:"rate_limit,omitempty"`
+	ListenTo                    string                  `json:"listen_to,omitempty"`
+	FeatureFlags                FeatureFlagConfigs      `json:"feature_flags,omitempty"`
+	BlockValidation             BlockValidationConfigs  `json:"block_validation,omitempty"`
+	NetworkTiming               NetworkTimingConfigs    `json:"network_timing,omitempty"`
+	SubmissionWindow            *SubmissionWindowConfig `json:"submission_window,omitempty"`
+	Signature                   SignatureConfigs        `json:"signature,omitempty"`
+	ClockSkew                   ClockSkewConfigs        `json:"clock_skew,omitempty"`
+	ClockCheck                  *ClockCheckConfig       `json:"clock_check,omitempty"`
+	ClockGuard                  *ClockGuardConfig       `json:"clock_guard,omitempty"`
+	LeaderElection              *LeaderElectionConfig   `json:"leader_election,omitempty"`
+	WriteBatching               *WriteBatchingConfig    `json:"write_batching,omitempty"`
+	LoadShedding                *LoadSheddingConfig     `json:"load_shedding,omitempty"`
+	SharedState                 *SharedStateConfig      `json:"shared_state,omitempty"`
+	Server                      *HTTPServerConfig       `json:"server,omitempty"`
+	Retention                   *RetentionConfig        `json:"retention,omitempty"`
+	// dev, testnet or mainnet, presetting the configuration
+	Profile             string                     `json:"profile,omitempty"`
+	SignatureQuarantine *SignatureQuarantineConfig `json:"signature_quarantine,omitempty"`
+	LivenessCheck       *LivenessCheckConfig       `json:"liveness_check,omitempty"`
+	SubmissionEvents    SubmissionEventsConfigs    `json:"submission_events,omitempty"`
+	Webhooks            *WebhooksConfig            `json:"webhooks,omitempty"`
+	NATS                *NATSConfig                `json:"nats,omitempty"`
+	SheetsStatus        *SheetsStatusConfig        `json:"sheets_status,omitempty"`
+	// Submissions kept in memory only and lost on restart, for development
+	MemoryStorage  bool                  `json:"memory_storage,omitempty"`
+	FaultInjection *FaultInjectionConfig `json:"fault_injection,omitempty"`
+	SubmitSize     SubmitSizeConfigs     `json:"submit_size,omitempty"`
+	// Set by setDefaults if not configured
+	SignatureVerify  *SignatureVerifyConfig  `json:"signature_verify,omitempty"`
+	SignatureLockout *SignatureLockoutConfig `json:"signature_lockout,omitempty"`
+	// Max age of created_at of accepted submissions, zero is no limit
+	CreatedAtMaxAgeMinutes int `json:"created_at_max_age_minutes,omitempty"`
+	// Max lifetime of delegation tokens, zero rejects delegated submissions
+	DelegationMaxTTLMinutes int `json:"delegation_max_ttl_minutes,omitempty"`
+	// Seconds responses to submissions with an idempotency key are
+	// repeated to retries, zero ignores idempotency keys [default: 86400]
+	IdempotencyKeyTTLSeconds *int `json:"idempotency_key_ttl_seconds,omitempty"`
+	// Set by setDefaults if not configured
+	ChunkedUpload *ChunkedUploadConfig `json:"chunked_upload,omitempty"`
+	// Column of the whitelist sheet with hourly limits of the keys, the
+	// overrides of the rate limit take precedence
+	DelegationWhitelistRateLimitColumn string `json:"delegation_whitelist_rate_limit_column,omitempty"`
 }
@@ -3,7 +3,10 @@ package delegation_backend
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 type MockLogger struct {
@@ -350,3 +353,268 @@ func TestLoadEnv(t *testing.T) {
 		}
 	})
 }
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yaml": `
+# Uptime backend
+network_name: test_network
+gsheet_id: "test_gsheet_id"
+delegation_whitelist_list: test_list
+delegation_whitelist_column: 'test_column'
+filesystem:
+  path: /tmp/test_path # submissions
+tls:
+  autocert_domains: [uptime.example.com, "backup.example.com"]
+logging:
+  subsystem_levels:
+    submit: debug
+alerting:
+  events:
+    - canary_failure
+    - storage_failure
+`,
+		"config.toml": `
+# Uptime backend
+network_name = "test_network"
+gsheet_id = "test_gsheet_id"
+delegation_whitelist_list = 'test_list'
+delegation_whitelist_column = "test_column"
+
+[filesystem]
+path = "/tmp/test_path" # submissions
+
+[tls]
+autocert_domains = [
+  "uptime.example.com",
+  "backup.example.com",
+]
+
+[logging.subsystem_levels]
+submit = "debug"
+
+[alerting]
+events = ["canary_failure", "storage_failure"]
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			os.WriteFile(path, []byte(content), 0644)
+			config, unknownKeys, err := LoadConfigFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(unknownKeys) != 0 {
+				t.Errorf("Expected no unknown keys but got %v", unknownKeys)
+			}
+			if config.NetworkName != "test_network" || config.GsheetId != "test_gsheet_id" ||
+				config.DelegationWhitelistList != "test_list" || config.DelegationWhitelistColumn != "test_column" {
+				t.Errorf("Unexpected config %+v", config)
+			}
+			if config.LocalFileSystem == nil || config.LocalFileSystem.Path != "/tmp/test_path" {
+				t.Errorf("Expected filesystem path to be /tmp/test_path but got %+v", config.LocalFileSystem)
+			}
+			if config.TLS == nil || len(config.TLS.AutocertDomains) != 2 || config.TLS.AutocertDomains[1] != "backup.example.com" {
+				t.Errorf("Unexpected TLS config %+v", config.TLS)
+			}
+			if config.Logging == nil || config.Logging.SubsystemLevels["submit"] != "debug" {
+				t.Errorf("Unexpected logging config %+v", config.Logging)
+			}
+			if config.Alerting == nil || len(config.Alerting.Events) != 2 || config.Alerting.Events[0] != "canary_failure" {
+				t.Errorf("Unexpected alerting config %+v", config.Alerting)
+			}
+		})
+	}
+
+	t.Run("anchors", func(t *testing.T) {
+		path := filepath.Join(dir, "anchors.yml")
+		os.WriteFile(path, []byte("defaults: &levels\n  submit: debug\nlogging:\n  subsystem_levels: *levels\n"), 0644)
+		config, unknownKeys, err := LoadConfigFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Logging == nil || config.Logging.SubsystemLevels["submit"] != "debug" || strings.Join(unknownKeys, ",") != "defaults" {
+			t.Errorf("Expected the alias resolved, got %+v, unknown keys %v", config.Logging, unknownKeys)
+		}
+	})
+
+	t.Run("unknown keys", func(t *testing.T) {
+		path := filepath.Join(dir, "unknown.yaml")
+		os.WriteFile(path, []byte("network_name: test_network\nnetwrok: typo\nfilesystem:\n  pth: /tmp\n"), 0644)
+		_, unknownKeys, err := LoadConfigFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(unknownKeys, ",") != "filesystem.pth,netwrok" {
+			t.Errorf("Expected unknown keys filesystem.pth,netwrok but got %v", unknownKeys)
+		}
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		path := filepath.Join(dir, "wrong_type.toml")
+		os.WriteFile(path, []byte("[postgresql]\nport = \"5432\"\n"), 0644)
+		_, _, err := LoadConfigFile(path)
+		if err == nil || !strings.Contains(err.Error(), "postgresql.port should be of type int") {
+			t.Errorf("Expected a type error for postgresql.port but got %v", err)
+		}
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		path := filepath.Join(dir, "syntax.toml")
+		os.WriteFile(path, []byte("network_name = \"test_network\"\nnetwork_name = \"other\"\n"), 0644)
+		_, _, err := LoadConfigFile(path)
+		if err == nil || !strings.Contains(err.Error(), "line 2") || !strings.Contains(err.Error(), "network_name") {
+			t.Errorf("Expected a duplicate key error on line 2 but got %v", err)
+		}
+	})
+}
+
+func TestLoadConfigEnvOverride(t *testing.T) {
+	os.Clearenv()
+	mockLogger := &MockLogger{}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+network_name: test_network
+delegation_whitelist_disabled: true
+filesystem:
+  path: /tmp/file_path
+`), 0644)
+	os.Setenv("CONFIG_FILESYSTEM_PATH", "/tmp/env_path")
+	defer os.Clearenv()
+
+	config := LoadConfig(path, mockLogger)
+	if mockLogger.lastMessage != "" {
+		t.Fatalf("Unexpected fatal error: %s", mockLogger.lastMessage)
+	}
+	if config.NetworkName != "test_network" {
+		t.Errorf("Expected network_name to be test_network but got %s", config.NetworkName)
+	}
+	if config.LocalFileSystem == nil || config.LocalFileSystem.Path != "/tmp/env_path" {
+		t.Errorf("Expected filesystem path to be overridden by env but got %+v", config.LocalFileSystem)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	config := AppConfig{
+		DelegationWhitelistList: "test_list",
+		PostgreSQL:              &PostgreSQLConfig{Host: "localhost", Port: 70000},
+		Logging:                 &LoggingConfig{Level: "verbose"},
+		ListenTo:                "localhost",
+		FeatureFlags:            FeatureFlagConfigs{"no_such_flag": {Enabled: true}},
+	}
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("Expected the configuration to be invalid")
+	}
+	for _, problem := range []string{"network_name", "gsheet_id", "delegation_whitelist_column", "postgresql.port", "postgresql.user", "logging.level", "listen_to", "feature_flags"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected a problem with %s in:\n%v", problem, err)
+		}
+	}
+
+	for _, config := range []AppConfig{
+		{NetworkName: "test_network", DelegationWhitelistDisabled: true, LocalFileSystem: &LocalFileSystemConfig{Path: "/tmp"}},
+		{NetworkName: "test_network", DelegationWhitelistDisabled: true, LocalFileSystem: &LocalFileSystemConfig{Path: "/tmp"}, ListenTo: "unix:/run/uptime.sock"},
+		// PostgreSQL is a storage backend of its own
+		{NetworkName: "test_network", DelegationWhitelistDisabled: true, PostgreSQL: &PostgreSQLConfig{Host: "localhost", Port: 5432, User: "postgres", Password: "postgres", DBName: "delegation_program"}},
+	} {
+		if err := config.Validate(); err != nil {
+			t.Errorf("Expected the configuration to be valid but got:\n%v", err)
+		}
+	}
+
+	// Leader election holds a lock in PostgreSQL
+	config = AppConfig{NetworkName: "test_network", DelegationWhitelistDisabled: true, LocalFileSystem: &LocalFileSystemConfig{Path: "/tmp"}, LeaderElection: &LeaderElectionConfig{}}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "leader_election") {
+		t.Errorf("Expected leader election to require PostgreSQL, got: %v", err)
+	}
+
+	// Retention of a backend not configured, and archiving rows of a database
+	config = AppConfig{NetworkName: "test_network", DelegationWhitelistDisabled: true, LocalFileSystem: &LocalFileSystemConfig{Path: "/tmp"},
+		Retention: &RetentionConfig{S3: &RetentionPolicy{MaxAgeDays: 30}, LocalFileSystem: &RetentionPolicy{}, PostgreSQL: &RetentionPolicy{MaxAgeDays: 30, ArchiveTo: "/archive"}}}
+	err = config.Validate()
+	for _, problem := range []string{"retention.aws requires", "retention.filesystem.max_age_days", "retention.postgresql.archive_to"} {
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected a problem with %s in:\n%v", problem, err)
+		}
+	}
+}
+
+func TestSignatureNetworkId(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	mockLogger := &MockLogger{}
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"network_name": "rehearsal", "delegation_whitelist_disabled": true, "filesystem": {"path": "/tmp"},
+		"signature": {"rehearsal": {"network_id": 1}, "devnet": {"network_id": 0}}}`), 0644)
+
+	config := LoadConfig(path, mockLogger)
+	if mockLogger.lastMessage != "" {
+		t.Fatalf("Unexpected fatal error: %s", mockLogger.lastMessage)
+	}
+	for network, networkId := range map[string]uint8{"rehearsal": 1, "devnet": 0, "mainnet": 1, "private": 0} {
+		if got := config.Signature.NetworkId(network); got != networkId {
+			t.Errorf("Expected network id %d of %s, got %d", networkId, network, got)
+		}
+	}
+
+	// The variable sets the network id of the network of the configuration
+	os.Setenv("SIGNATURE_NETWORK_ID", "300")
+	config = LoadConfig(path, mockLogger)
+	if config.Signature.NetworkId("devnet") != 0 || !strings.Contains(mockLogger.lastMessage, "network_id isn't a byte") {
+		t.Errorf("Expected a network id of 300 rejected, got: %s", mockLogger.lastMessage)
+	}
+}
+
+func TestLoadTuningConfig(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	mockLogger := &MockLogger{}
+	os.Setenv("CONFIG_NETWORK_NAME", "test_network")
+	os.Setenv("DELEGATION_WHITELIST_DISABLED", "1")
+	os.Setenv("CONFIG_FILESYSTEM_PATH", "/tmp")
+
+	// Defaults are set when not configured, zero disables the cache
+	os.Setenv("SIGNATURE_VERIFY_CACHE_TTL_SECONDS", "0")
+	os.Setenv("SUBMIT_TIMEOUT_SECONDS", "30")
+	config, err := loadConfig("", mockLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verify := config.SignatureVerify; verify.Workers <= 0 || verify.QueueSize != 16*verify.Workers || *verify.CacheTTLSeconds != 0 {
+		t.Errorf("unexpected signature verification %+v", verify)
+	}
+	if lockout := config.SignatureLockout; *lockout.Threshold != SIGNATURE_LOCKOUT_THRESHOLD || lockout.MaxSeconds != 3600 {
+		t.Errorf("unexpected signature lockout %+v", lockout)
+	}
+	if *config.IdempotencyKeyTTLSeconds != int(IDEMPOTENCY_KEY_TTL/time.Second) {
+		t.Errorf("unexpected idempotency key TTL %d", *config.IdempotencyKeyTTLSeconds)
+	}
+	if uploads := config.ChunkedUpload; uploads.ChunkSize != CHUNKED_UPLOAD_CHUNK_SIZE || uploads.MaxUploads != CHUNKED_UPLOAD_MAX_UPLOADS {
+		t.Errorf("unexpected chunked uploads %+v", uploads)
+	}
+	if timeouts := config.Server.Timeouts(); timeouts.Submit != 30*time.Second || timeouts.Write != HTTP_WRITE_TIMEOUT {
+		t.Errorf("unexpected timeouts %+v", timeouts)
+	}
+
+	// Invalid values fail rather than fall back to defaults
+	for variable, value := range map[string]string{
+		"SIGNATURE_VERIFY_WORKERS":         "-1",
+		"SIGNATURE_LOCKOUT_MAX_SECONDS":    "10",
+		"CREATED_AT_MAX_AGE_MINUTES":       "-5",
+		"DELEGATION_MAX_TTL_MINUTES":       "-5",
+		"SUBMIT_TIMEOUT_SECONDS":           "120",
+		"HTTP_READ_HEADER_TIMEOUT_SECONDS": "-1",
+		"IDEMPOTENCY_KEY_TTL_SECONDS":      "-60",
+		"CHUNKED_UPLOAD_CHUNK_SIZE":        "-1",
+		"CHUNKED_UPLOAD_MAX_UPLOADS":       "-1",
+	} {
+		os.Setenv(variable, value)
+		if _, err := loadConfig("", mockLogger); err == nil || !strings.Contains(err.Error(), variable) {
+			t.Errorf("expected %s=%s invalid, got %v", variable, value, err)
+		}
+		os.Unsetenv(variable)
+	}
+}
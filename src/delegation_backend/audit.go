@@ -0,0 +1,148 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Storage prefix under which audit records are saved
+const AUDIT_PREFIX = "audit/"
+
+// Intervals of writing buffered audit records
+const (
+	AUDIT_FILE_FLUSH_INTERVAL    = time.Second
+	AUDIT_STORAGE_FLUSH_INTERVAL = time.Minute
+)
+
+// Buffered records are written early once the buffer exceeds this size
+const AUDIT_MAX_BUFFER_SIZE = 1 << 20
+
+const (
+	AUDIT_RESULT_ACCEPTED = "accepted"
+	AUDIT_RESULT_REJECTED = "rejected"
+)
+
+// StorageOutcomes are the errors of saving a submission
+// by storage backend, nil for a successful save.
+type StorageOutcomes map[string]error
+
+// AuditRecord describes a single submission attempt, accepted or not.
+// Fields unknown at the time of rejection are left empty.
+type AuditRecord struct {
+	Time       time.Time         `json:"time"`
+	Result     string            `json:"result"`
+	Status     int               `json:"status"`
+	Reason     string            `json:"reason,omitempty"`
+	Submitter  string            `json:"submitter,omitempty"`
+	Delegate   string            `json:"delegate,omitempty"`
+	RemoteAddr string            `json:"remote_addr"`
+	BodySize   int64             `json:"body_size"`
+	BlockSize  int               `json:"block_size,omitempty"`
+	BlockHash  string            `json:"block_hash,omitempty"`
+	LatencyMs  float64           `json:"latency_ms"`
+	Storage    map[string]string `json:"storage,omitempty"`
+	// Whether the submitter is whitelisted or, with the whitelist disabled,
+	// authenticated, statistics are kept only for such submitters
+	authorized bool
+}
+
+// Outcomes as "ok" or "error: <error>" by backend, nil if there are none
+func storageOutcomeStrings(outcomes StorageOutcomes) map[string]string {
+	if len(outcomes) == 0 {
+		return nil
+	}
+	strs := make(map[string]string, len(outcomes))
+	for backend, err := range outcomes {
+		if err != nil {
+			strs[backend] = "error: " + err.Error()
+		} else {
+			strs[backend] = "ok"
+		}
+	}
+	return strs
+}
+
+func (rec *AuditRecord) setStorageOutcomes(outcomes StorageOutcomes) {
+	rec.Storage = storageOutcomeStrings(outcomes)
+}
+
+// AuditLog buffers audit records and writes them as JSON lines,
+// every flush interval (see FlushLoop) or once the buffer is full.
+type AuditLog struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+	write func([]byte) error
+	log   logging.StandardLogger
+}
+
+func NewAuditLog(write func([]byte) error, log logging.StandardLogger) *AuditLog {
+	return &AuditLog{write: write, log: log}
+}
+
+// NewFileAuditLog appends records to the file
+func NewFileAuditLog(path string, log logging.StandardLogger) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return NewAuditLog(func(bs []byte) error {
+		_, err := file.Write(bs)
+		return err
+	}, log), nil
+}
+
+// NewStorageAuditLog saves every flushed batch of records
+// as an object `audit/<date>/<time>.jsonl`.
+func NewStorageAuditLog(save func(ObjectsToSave), now nowFunc, log logging.StandardLogger) *AuditLog {
+	return NewAuditLog(func(bs []byte) error {
+		at := now().UTC().Format(time.RFC3339Nano)
+		save(ObjectsToSave{AUDIT_PREFIX + at[:10] + "/" + at + ".jsonl": bs})
+		return nil
+	}, log)
+}
+
+func (a *AuditLog) Record(rec AuditRecord) {
+	bs, err := json.Marshal(rec)
+	if err != nil {
+		a.log.Errorf("Error marshaling audit record: %v", err)
+		return
+	}
+	incMetric("audit_records")
+	a.mutex.Lock()
+	a.buf.Write(bs)
+	a.buf.WriteByte('\n')
+	full := a.buf.Len() >= AUDIT_MAX_BUFFER_SIZE
+	a.mutex.Unlock()
+	if full {
+		a.Flush()
+	}
+}
+
+// Flush writes the buffered records, records failed
+// to be written are dropped.
+func (a *AuditLog) Flush() {
+	a.mutex.Lock()
+	if a.buf.Len() == 0 {
+		a.mutex.Unlock()
+		return
+	}
+	bs := bytes.Clone(a.buf.Bytes())
+	a.buf.Reset()
+	a.mutex.Unlock()
+	if err := a.write(bs); err != nil {
+		incMetric("audit_write_errors")
+		a.log.Errorf("Error writing audit records: %v", err)
+	}
+}
+
+func (a *AuditLog) FlushLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		a.Flush()
+	}
+}
@@ -0,0 +1,121 @@
+package delegation_backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func readAuditRecords(t *testing.T, bs []byte) []AuditRecord {
+	var records []AuditRecord
+	scanner := bufio.NewScanner(bytes.NewReader(bs))
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("malformed audit record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestSubmitAudit(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, _ := testSubmitH(10, Whitelist{req.Submitter: true})
+	var written bytes.Buffer
+	auditLog := NewAuditLog(func(bs []byte) error {
+		written.Write(bs)
+		return nil
+	}, logging.Logger("test"))
+	sh.app.AuditLogs = []*AuditLog{auditLog}
+	save := sh.app.Save
+	sh.app.Save = func(ctx context.Context, objs ObjectsToSave) StorageOutcomes {
+		save(ctx, objs)
+		return StorageOutcomes{"s3": nil, "keyspaces": errors.New("timeout")}
+	}
+
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected submission accepted, got %v", rep)
+	}
+	badReq := req
+	badReq.Submitter = mkPk()
+	badBody, _ := json.Marshal(badReq)
+	if rep := sh.testRequest(badBody); rep.Code != 401 {
+		t.Fatalf("expected submitter rejected, got %v", rep)
+	}
+	if written.Len() != 0 {
+		t.Fatal("records written before flush")
+	}
+	auditLog.Flush()
+
+	records := readAuditRecords(t, written.Bytes())
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	accepted, rejected := records[0], records[1]
+	if accepted.Result != AUDIT_RESULT_ACCEPTED || accepted.Status != 200 || accepted.Submitter != req.Submitter.String() ||
+		accepted.BodySize != int64(len(body)) || accepted.BlockSize != len(req.Data.Block.data) || accepted.BlockHash != req.GetBlockDataHash() {
+		t.Fatalf("unexpected accepted record: %+v", accepted)
+	}
+	if accepted.Storage["s3"] != "ok" || accepted.Storage["keyspaces"] != "error: timeout" {
+		t.Fatalf("unexpected storage outcomes: %+v", accepted.Storage)
+	}
+	if rejected.Result != AUDIT_RESULT_REJECTED || rejected.Status != 401 || rejected.Submitter != badReq.Submitter.String() ||
+		!strings.HasPrefix(rejected.Reason, "Submitter is not registered") || rejected.BlockHash != "" {
+		t.Fatalf("unexpected rejected record: %+v", rejected)
+	}
+}
+
+func TestFileAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < 2; i++ {
+		// Records are appended to the existing file
+		auditLog, err := NewFileAuditLog(path, logging.Logger("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		auditLog.Record(AuditRecord{Result: AUDIT_RESULT_REJECTED, Status: 411, Reason: "Length Required"})
+		auditLog.Flush()
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := readAuditRecords(t, bs); len(records) != 2 || records[1].Status != 411 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+}
+
+func TestStorageAuditLog(t *testing.T) {
+	storage := make(ObjectsToSave)
+	tm := &timeMock{time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	auditLog := NewStorageAuditLog(func(objs ObjectsToSave) {
+		for path, bs := range objs {
+			storage[path] = bs
+		}
+	}, tm.Now, logging.Logger("test"))
+	auditLog.Flush()
+	if len(storage) != 0 {
+		t.Fatal("empty batch saved")
+	}
+	auditLog.Record(AuditRecord{Result: AUDIT_RESULT_ACCEPTED, Status: 200})
+	auditLog.Record(AuditRecord{Result: AUDIT_RESULT_ACCEPTED, Status: 200})
+	auditLog.Flush()
+	bs, has := storage["audit/2024-05-01/2024-05-01T12:00:00Z.jsonl"]
+	if !has || len(readAuditRecords(t, bs)) != 2 {
+		t.Fatalf("unexpected storage: %v", storage)
+	}
+}
@@ -20,12 +20,19 @@ import (
 
 // InitializeKeyspaceSession creates a new gocql session for Amazon Keyspaces using the provided configuration.
 func InitializeKeyspaceSession(config *AwsKeyspacesConfig) (*gocql.Session, error) {
+	session, _, err := initializeKeyspaceSession(config)
+	return session, err
+}
+
+// Create the session, returning when its credentials expire (zero if they don't)
+func initializeKeyspaceSession(config *AwsKeyspacesConfig) (*gocql.Session, time.Time, error) {
+	var expiresAt time.Time
 	var cluster *gocql.ClusterConfig
 
 	var endpoint string
 	if config.CassandraHost == "" {
 		if config.Region == "" {
-			return nil, fmt.Errorf("AWS_REGION is required when CASSANDRA_HOST is not set")
+			return nil, expiresAt, fmt.Errorf("AWS_REGION is required when CASSANDRA_HOST is not set")
 		}
 		endpoint = "cassandra." + config.Region + ".amazonaws.com"
 	} else {
@@ -49,9 +56,9 @@ func InitializeKeyspaceSession(config *AwsKeyspacesConfig) (*gocql.Session, erro
 			Password: config.CassandraPassword}
 	} else {
 		var err error
-		cluster.Authenticator, err = sigv4Authentication(config)
+		cluster.Authenticator, expiresAt, err = sigv4Authentication(config)
 		if err != nil {
-			return nil, fmt.Errorf("could not create SigV4 authenticator: %w", err)
+			return nil, expiresAt, fmt.Errorf("could not create SigV4 authenticator: %w", err)
 		}
 	}
 
@@ -67,25 +74,28 @@ func InitializeKeyspaceSession(config *AwsKeyspacesConfig) (*gocql.Session, erro
 
 	session, err := cluster.CreateSession()
 	if err != nil {
-		return nil, fmt.Errorf("could not create Cassandra session: %w", err)
+		return nil, expiresAt, fmt.Errorf("could not create Cassandra session: %w", err)
 	}
 
-	return session, nil
+	return session, expiresAt, nil
 }
 
-func sigv4Authentication(config *AwsKeyspacesConfig) (sigv4.AwsAuthenticator, error) {
+// SigV4 authenticator, temporary credentials of an assumed role are
+// returned with their expiry
+func sigv4Authentication(config *AwsKeyspacesConfig) (sigv4.AwsAuthenticator, time.Time, error) {
+	var expiresAt time.Time
 	auth := sigv4.NewAwsAuthenticator()
 	if config.RoleSessionName != "" && config.RoleArn != "" && config.WebIdentityTokenFile != "" {
 		// If role-related env variables are set, use temporary credentials
 		tokenBytes, err := os.ReadFile(config.WebIdentityTokenFile)
 		if err != nil {
-			return auth, fmt.Errorf("error reading web identity token file: %w", err)
+			return auth, expiresAt, fmt.Errorf("error reading web identity token file: %w", err)
 		}
 		webIdentityToken := string(tokenBytes)
 
 		awsSession, err := session.NewSession(&aws.Config{Region: aws.String(config.Region)})
 		if err != nil {
-			return auth, fmt.Errorf("error creating AWS session: %w", err)
+			return auth, expiresAt, fmt.Errorf("error creating AWS session: %w", err)
 		}
 
 		stsSvc := sts.New(awsSession)
@@ -95,27 +105,32 @@ func sigv4Authentication(config *AwsKeyspacesConfig) (sigv4.AwsAuthenticator, er
 			WebIdentityToken: &webIdentityToken,
 		})
 		if err != nil {
-			return auth, fmt.Errorf("unable to assume role: %w", err)
+			return auth, expiresAt, fmt.Errorf("unable to assume role: %w", err)
 		}
 
 		auth.AccessKeyId = *creds.Credentials.AccessKeyId
 		auth.SecretAccessKey = *creds.Credentials.SecretAccessKey
 		auth.SessionToken = *creds.Credentials.SessionToken
 		auth.Region = config.Region
+		if creds.Credentials.Expiration != nil {
+			expiresAt = *creds.Credentials.Expiration
+		}
 	} else {
 		// Otherwise, use credentials from the config
 		auth.AccessKeyId = config.AccessKeyId
 		auth.SecretAccessKey = config.SecretAccessKey
 		auth.Region = config.Region
 	}
-	return auth, nil
+	return auth, expiresAt, nil
 }
 
 type KeyspaceContext struct {
-	Session  *gocql.Session
+	Session  *KeyspaceSession
 	Keyspace string
 	Context  context.Context
 	Log      *logging.ZapEventLogger
+	// Optional, batches inserts of submissions saved concurrently
+	Batcher *WriteBatcher
 }
 
 // calculateShard returns the shard number for a given submission time.
@@ -136,30 +151,59 @@ func calculateBlockSize(rawBlock []byte) int {
 
 // Insert a submission into the Keyspaces database
 func (kc *KeyspaceContext) insertSubmission(submission *Submission) error {
-	return ExponentialBackoff(func() error {
-		if submission.RawBlock == nil {
-			kc.Log.Error("KeyspaceSave: Block is missing in the submission, which is not expected, but inserting without raw_block")
-			if err := kc.insertSubmissionWithoutRawBlock(submission); err != nil {
-				return err
-			}
-		} else if calculateBlockSize(submission.RawBlock) > MAX_BLOCK_SIZE {
-			kc.Log.Infof("KeyspaceSave: Block too large (%d bytes), inserting without raw_block", calculateBlockSize(submission.RawBlock))
-			if err := kc.insertSubmissionWithoutRawBlock(submission); err != nil {
-				return err
-			}
-		} else {
-			if err := kc.insertSubmissionWithRawBlock(submission); err != nil {
-				return err
-			}
+	ctx := kc.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return ExponentialBackoffContext(ctx, func() error {
+		query, values := kc.submissionInsert(submission)
+		return kc.Session.Run(func(session *gocql.Session) error {
+			return session.Query(query, values...).WithContext(ctx).Exec()
+		})
+	}, maxRetries, initialBackoff)
+}
 
-		}
+// WithContext is a copy of the context whose inserts are bound to ctx,
+// e.g. to the deadline of a request
+func (kc *KeyspaceContext) WithContext(ctx context.Context) *KeyspaceContext {
+	c := *kc
+	c.Context = ctx
+	return &c
+}
 
-		return nil
+// InsertSubmissions inserts the submissions in an unlogged batch, which
+// is limited to KEYSPACES_MAX_BATCH_SIZE submissions
+func (kc *KeyspaceContext) InsertSubmissions(submissions []*Submission) error {
+	if len(submissions) == 1 {
+		return kc.insertSubmission(submissions[0])
+	}
+	return ExponentialBackoff(func() error {
+		return kc.Session.Run(func(session *gocql.Session) error {
+			batch := session.NewBatch(gocql.UnloggedBatch)
+			for _, submission := range submissions {
+				query, values := kc.submissionInsert(submission)
+				batch.Query(query, values...)
+			}
+			return session.ExecuteBatch(batch)
+		})
 	}, maxRetries, initialBackoff)
 }
 
-func (kc *KeyspaceContext) insertSubmissionWithoutRawBlock(submission *Submission) error {
-	query := "INSERT INTO " + kc.Keyspace + ".submissions (submitted_at_date, shard, submitted_at, submitter, remote_addr, peer_id, snark_work, block_hash, created_at, graphql_control_port, built_with_commit_sha) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+// Statement inserting the submission, raw_block is left out
+// if the block is missing or too large
+func (kc *KeyspaceContext) submissionInsert(submission *Submission) (string, []interface{}) {
+	if submission.RawBlock == nil {
+		kc.Log.Error("KeyspaceSave: Block is missing in the submission, which is not expected, but inserting without raw_block")
+		return kc.submissionInsertWithoutRawBlock(submission)
+	} else if calculateBlockSize(submission.RawBlock) > MAX_BLOCK_SIZE {
+		kc.Log.Infof("KeyspaceSave: Block too large (%d bytes), inserting without raw_block", calculateBlockSize(submission.RawBlock))
+		return kc.submissionInsertWithoutRawBlock(submission)
+	}
+	return kc.submissionInsertWithRawBlock(submission)
+}
+
+func (kc *KeyspaceContext) submissionInsertWithoutRawBlock(submission *Submission) (string, []interface{}) {
+	query := "INSERT INTO " + kc.Keyspace + ".submissions (submitted_at_date, shard, submitted_at, submitter, remote_addr, peer_id, snark_work, block_hash, created_at, graphql_control_port, built_with_commit_sha, global_slot, epoch, peer_count, sync_status, snark_pool_size, daemon_version) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 	values := []interface{}{
 		submission.SubmittedAtDate,
 		calculateShard(submission.SubmittedAt),
@@ -172,12 +216,14 @@ func (kc *KeyspaceContext) insertSubmissionWithoutRawBlock(submission *Submissio
 		submission.CreatedAt,
 		submission.GraphqlControlPort,
 		submission.BuiltWithCommitSha,
+		submission.GlobalSlot,
+		submission.Epoch,
 	}
-	return kc.Session.Query(query, values...).Exec()
+	return query, append(values, submission.telemetryValues()...)
 }
 
-func (kc *KeyspaceContext) insertSubmissionWithRawBlock(submission *Submission) error {
-	query := "INSERT INTO " + kc.Keyspace + ".submissions (submitted_at_date, shard, submitted_at, submitter, remote_addr, peer_id, snark_work, block_hash, created_at, graphql_control_port, built_with_commit_sha, raw_block) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+func (kc *KeyspaceContext) submissionInsertWithRawBlock(submission *Submission) (string, []interface{}) {
+	query := "INSERT INTO " + kc.Keyspace + ".submissions (submitted_at_date, shard, submitted_at, submitter, remote_addr, peer_id, snark_work, block_hash, created_at, graphql_control_port, built_with_commit_sha, global_slot, epoch, peer_count, sync_status, snark_pool_size, daemon_version, raw_block) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 	values := []interface{}{
 		submission.SubmittedAtDate,
 		calculateShard(submission.SubmittedAt),
@@ -190,22 +236,31 @@ func (kc *KeyspaceContext) insertSubmissionWithRawBlock(submission *Submission)
 		submission.CreatedAt,
 		submission.GraphqlControlPort,
 		submission.BuiltWithCommitSha,
-		submission.RawBlock,
+		submission.GlobalSlot,
+		submission.Epoch,
 	}
-	return kc.Session.Query(query, values...).Exec()
+	values = append(values, submission.telemetryValues()...)
+	return query, append(values, submission.RawBlock)
 }
 
 // KeyspaceSave saves the provided objects into Amazon Keyspaces.
-func (kc *KeyspaceContext) KeyspaceSave(objs ObjectsToSave) {
+func (kc *KeyspaceContext) KeyspaceSave(objs ObjectsToSave) error {
 	submissionToSave, err := objectToSaveToSubmission(objs, kc.Log)
 	if err != nil {
 		kc.Log.Errorf("KeyspaceSave: Error preparing submission for saving: %v", err)
-		return
+		return err
 	}
 	kc.Log.Infof("KeyspaceSave: Saving submission for block: %v, submitter: %v, submitted_at: %v", submissionToSave.BlockHash, submissionToSave.Submitter, submissionToSave.SubmittedAt)
-	if err := kc.insertSubmission(submissionToSave); err != nil {
+	if kc.Batcher != nil {
+		err = kc.Batcher.Save(submissionToSave)
+	} else {
+		err = kc.insertSubmission(submissionToSave)
+	}
+	if err != nil {
 		kc.Log.Errorf("KeyspaceSave: Error saving submission to Keyspaces: %v", err)
+		return err
 	}
+	return nil
 }
 
 func createSchemaMigrationsTableIfNotExists(session *gocql.Session, keyspace string) error {
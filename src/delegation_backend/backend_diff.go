@@ -0,0 +1,244 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/gocql/gocql"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// SubmissionInventory lists the submissions a backend stores of a day,
+// sorted by path. Paths of submissions of databases are the paths they'd
+// have in object storages. Sources of object storages are inventories.
+type SubmissionInventory interface {
+	Submissions(ctx context.Context, day string) ([]string, error)
+}
+
+// DiffedBackend is one of the backends compared by a BackendDiff
+type DiffedBackend struct {
+	Name      string
+	Inventory SubmissionInventory
+	// Reads submissions back to repair the other backend, nil for
+	// databases, whose submissions can't be read back as submitted
+	Source StorageSource
+	// Saves submissions missing from the backend, on repairs
+	Save func(ObjectsToSave) error
+}
+
+// BackendDiff compares the submissions of two backends over a range of
+// days, e.g. after an outage during which writes to one of them failed.
+// With Repair, submissions missing from a backend are copied, along with
+// their block, from the other one if it is an object storage.
+type BackendDiff struct {
+	A DiffedBackend
+	B DiffedBackend
+	// Days of submissions (YYYY-MM-DD), inclusive
+	Since  string
+	Until  string
+	Repair bool
+	Log    logging.StandardLogger
+}
+
+// BackendDiffReport of the submissions missing from every backend
+type BackendDiffReport struct {
+	Since    string                      `json:"since"`
+	Until    string                      `json:"until"`
+	Backends map[string]*DiffedInventory `json:"backends"`
+}
+
+// DiffedInventory of a backend
+type DiffedInventory struct {
+	// Submissions listed
+	Submissions int `json:"submissions"`
+	// Submissions of the other backend missing from this one, sorted
+	Missing []string `json:"missing"`
+	// Submissions missing which were copied from the other backend
+	Repaired int `json:"repaired"`
+	// Submissions missing which failed to be repaired, or can't be
+	Failed []string `json:"failed,omitempty"`
+}
+
+// Submissions missing from either backend which weren't repaired
+func (r *BackendDiffReport) Unrepaired() int {
+	unrepaired := 0
+	for _, inventory := range r.Backends {
+		unrepaired += len(inventory.Missing) - inventory.Repaired
+	}
+	return unrepaired
+}
+
+// Run the comparison day by day until the last day or ctx is done,
+// returning the report of the days compared so far
+func (d *BackendDiff) Run(ctx context.Context) (*BackendDiffReport, error) {
+	since, err := time.Parse("2006-01-02", d.Since)
+	if err != nil {
+		return nil, fmt.Errorf("invalid since %q, expected YYYY-MM-DD", d.Since)
+	}
+	until, err := time.Parse("2006-01-02", d.Until)
+	if err != nil {
+		return nil, fmt.Errorf("invalid until %q, expected YYYY-MM-DD", d.Until)
+	}
+	if until.Before(since) {
+		return nil, fmt.Errorf("until %s is before since %s", d.Until, d.Since)
+	}
+	if d.A.Name == d.B.Name {
+		return nil, fmt.Errorf("backend %s compared with itself", d.A.Name)
+	}
+	if d.Repair {
+		for _, pair := range [][2]DiffedBackend{{d.A, d.B}, {d.B, d.A}} {
+			if pair[0].Source == nil {
+				d.Log.Warnf("Submissions missing from %s can't be repaired from %s, a database", pair[1].Name, pair[0].Name)
+			}
+		}
+	}
+	report := &BackendDiffReport{Since: d.Since, Until: d.Until, Backends: map[string]*DiffedInventory{
+		d.A.Name: {Missing: []string{}},
+		d.B.Name: {Missing: []string{}},
+	}}
+	for _, day := range daysBetween(d.Since, d.Until) {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		a, err := listSubmissions(ctx, d.A, day)
+		if err != nil {
+			return report, err
+		}
+		b, err := listSubmissions(ctx, d.B, day)
+		if err != nil {
+			return report, err
+		}
+		report.Backends[d.A.Name].Submissions += len(a)
+		report.Backends[d.B.Name].Submissions += len(b)
+		onlyA, onlyB := diffSorted(a, b)
+		if len(onlyA) > 0 || len(onlyB) > 0 {
+			d.Log.Infof("%s: %d submissions missing from %s, %d from %s", day, len(onlyA), d.B.Name, len(onlyB), d.A.Name)
+		}
+		d.missing(ctx, report.Backends[d.B.Name], d.B, d.A, onlyA)
+		d.missing(ctx, report.Backends[d.A.Name], d.A, d.B, onlyB)
+	}
+	return report, nil
+}
+
+// Record submissions missing from a backend, copying them from the other
+// one on repairs
+func (d *BackendDiff) missing(ctx context.Context, inventory *DiffedInventory, to DiffedBackend, from DiffedBackend, paths []string) {
+	inventory.Missing = append(inventory.Missing, paths...)
+	if !d.Repair {
+		return
+	}
+	for _, path := range paths {
+		if from.Source == nil {
+			inventory.Failed = append(inventory.Failed, path)
+			continue
+		}
+		objs, missingBlock, err := readStoredSubmission(ctx, from.Source, path)
+		if err == nil {
+			if missingBlock {
+				d.Log.Warnf("Block of submission %s not found in %s, repairing the submission only", path, from.Name)
+			}
+			err = to.Save(objs)
+		}
+		if err != nil {
+			d.Log.Errorf("Failed to repair submission %s of %s: %v", path, to.Name, err)
+			inventory.Failed = append(inventory.Failed, path)
+			continue
+		}
+		inventory.Repaired++
+	}
+}
+
+// Submissions of the day of the backend, sorted, none if the directory of
+// the day doesn't exist
+func listSubmissions(ctx context.Context, backend DiffedBackend, day string) ([]string, error) {
+	paths, err := backend.Inventory.Submissions(ctx, day)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing submissions of %s of %s: %w", day, backend.Name, err)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Paths of sorted lists a and b only in a and only in b
+func diffSorted(a []string, b []string) (onlyA []string, onlyB []string) {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i] < b[j]):
+			onlyA = append(onlyA, a[i])
+			i++
+		case i == len(a) || b[j] < a[i]:
+			onlyB = append(onlyB, b[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return onlyA, onlyB
+}
+
+// Submissions of the day, by the paths they'd have in object storages
+func (ctx *PostgreSQLContext) Submissions(c context.Context, day string) ([]string, error) {
+	rows, err := ctx.DB.QueryContext(c, `SELECT submitted_at, submitter FROM submissions WHERE submitted_at_date = $1`, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var submittedAt time.Time
+		var submitter string
+		if err := rows.Scan(&submittedAt, &submitter); err != nil {
+			return nil, err
+		}
+		var pk Pk
+		if err := StringToPk(&pk, submitter); err != nil {
+			return nil, fmt.Errorf("invalid submitter %s: %w", submitter, err)
+		}
+		paths = append(paths, makePaths(submittedAt, "", pk).Meta)
+	}
+	sort.Strings(paths)
+	return paths, rows.Err()
+}
+
+// Submissions of every shard of the day, by the paths they'd have in
+// object storages
+func (kc *KeyspaceContext) Submissions(ctx context.Context, day string) ([]string, error) {
+	query := "SELECT submitted_at, submitter FROM " + kc.Keyspace + ".submissions WHERE submitted_at_date = ? AND shard = ?"
+	var paths []string
+	for shard := 0; shard < KEYSPACES_SHARDS_PER_DAY; shard++ {
+		var times []time.Time
+		var submitters []string
+		err := kc.Session.Run(func(session *gocql.Session) error {
+			times, submitters = nil, nil
+			iter := session.Query(query, day, shard).WithContext(ctx).Iter()
+			var submittedAt time.Time
+			var submitter string
+			for iter.Scan(&submittedAt, &submitter) {
+				times = append(times, submittedAt)
+				submitters = append(submitters, submitter)
+			}
+			return iter.Close()
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i, submitter := range submitters {
+			var pk Pk
+			if err := StringToPk(&pk, submitter); err != nil {
+				return nil, fmt.Errorf("invalid submitter %s: %w", submitter, err)
+			}
+			paths = append(paths, makePaths(times[i], "", pk).Meta)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,94 @@
+package delegation_backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+type testInventory map[string][]string
+
+func (i testInventory) Submissions(ctx context.Context, day string) ([]string, error) {
+	return i[day], nil
+}
+
+func TestBackendDiff(t *testing.T) {
+	a := migrationTestStorage(t)
+	b := t.TempDir()
+	log := logging.Logger("test")
+	if err := LocalFileSystemSave(ObjectsToSave{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NK1"}`),
+		"submissions/2024-05-03/2024-05-03T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NK4"}`),
+		"blocks/3NK1.dat": []byte("block1"),
+		"blocks/3NK4.dat": []byte("block4"),
+	}, b, log); err != nil {
+		t.Fatal(err)
+	}
+	filesystem := func(name string, dir string) DiffedBackend {
+		source := LocalFileSystemSource{Directory: dir}
+		return DiffedBackend{Name: name, Inventory: source, Source: source, Save: func(objs ObjectsToSave) error {
+			return LocalFileSystemSave(objs, dir, log)
+		}}
+	}
+	diff := &BackendDiff{A: filesystem("s3", a), B: filesystem("filesystem", b), Since: "2024-05-01", Until: "2024-05-03", Log: log}
+
+	report, err := diff.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	missingFromB := []string{
+		"submissions/2024-05-01/2024-05-01T11:00:00Z-B62qb.json",
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json",
+		"submissions/2024-05-02/2024-05-02T11:00:00Z-B62qb.json",
+	}
+	missingFromA := []string{"submissions/2024-05-03/2024-05-03T10:00:00Z-B62qa.json"}
+	if report.Backends["s3"].Submissions != 4 || !reflect.DeepEqual(report.Backends["s3"].Missing, missingFromA) ||
+		report.Backends["filesystem"].Submissions != 2 || !reflect.DeepEqual(report.Backends["filesystem"].Missing, missingFromB) {
+		t.Fatalf("unexpected report %+v %+v", report.Backends["s3"], report.Backends["filesystem"])
+	}
+	if report.Unrepaired() != 4 {
+		t.Fatalf("expected 4 unrepaired, got %d", report.Unrepaired())
+	}
+	if _, err := os.Stat(filepath.Join(b, missingFromB[0])); !os.IsNotExist(err) {
+		t.Fatal("expected nothing repaired without Repair")
+	}
+
+	// Submissions are repaired along with their blocks, both ways
+	diff.Repair = true
+	report, err = diff.Run(context.Background())
+	if err != nil || report.Unrepaired() != 0 || report.Backends["filesystem"].Repaired != 3 || report.Backends["s3"].Repaired != 1 {
+		t.Fatalf("unexpected repair %+v, error: %v", report, err)
+	}
+	if bs, err := os.ReadFile(filepath.Join(b, "blocks", "3NK2.dat")); err != nil || string(bs) != "block2" {
+		t.Fatalf("expected the block repaired: %v", err)
+	}
+	if bs, err := os.ReadFile(filepath.Join(a, "blocks", "3NK4.dat")); err != nil || string(bs) != "block4" {
+		t.Fatalf("expected the block repaired: %v", err)
+	}
+	report, err = diff.Run(context.Background())
+	if err != nil || len(report.Backends["s3"].Missing)+len(report.Backends["filesystem"].Missing) != 0 {
+		t.Fatalf("expected no difference once repaired: %+v, error: %v", report, err)
+	}
+
+	// Submissions missing from object storages can't be repaired from databases
+	diff.B = DiffedBackend{Name: "postgresql", Inventory: testInventory{
+		"2024-05-01": {"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json", "submissions/2024-05-01/2024-05-01T12:00:00Z-B62qc.json"},
+	}, Save: func(objs ObjectsToSave) error { return nil }}
+	diff.Until = "2024-05-01"
+	report, err = diff.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := []string{"submissions/2024-05-01/2024-05-01T12:00:00Z-B62qc.json"}; !reflect.DeepEqual(report.Backends["s3"].Failed, failed) {
+		t.Fatalf("expected %v failed, got %+v", failed, report.Backends["s3"])
+	}
+
+	diff.Since = "2024-05-02"
+	if _, err := diff.Run(context.Background()); err == nil {
+		t.Fatal("expected until before since rejected")
+	}
+}
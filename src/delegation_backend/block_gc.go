@@ -0,0 +1,182 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Blocks saved within the grace period are never collected
+const BLOCK_GC_DEFAULT_GRACE = 48 * time.Hour
+
+// ObjectDeleter deletes (or archives) objects by path, relative to the
+// root of the storage
+type ObjectDeleter interface {
+	DeleteObjects(ctx context.Context, paths []string) error
+}
+
+// BlockGC collects blocks of an object storage referenced by no
+// submission, e.g. blocks of submissions deleted by the retention cleanup,
+// which then leaves blocks alone (see SkipBlocks of cleaners).
+//
+// Blocks saved within the grace period are kept: a block is saved along
+// with its first submission, possibly before it. Submissions saved while
+// submissions are read are read as well before deleting anything, so a
+// block can only be lost by a submission saved between that second read
+// and the deletion.
+type BlockGC struct {
+	// Source of submissions, listing blocks
+	Source interface {
+		StorageSource
+		BlockLister
+	}
+	Deleter ObjectDeleter
+	Grace   time.Duration
+}
+
+// Collect blocks saved before now minus the grace period and referenced
+// by no submission. Collected blocks are counted in the report, under
+// `blocks/` and as orphaned blocks. In dry runs, they are passed to listed
+// and nothing is deleted. Submissions whose block can't be told stop the
+// collection.
+func (g *BlockGC) Collect(ctx context.Context, now time.Time, dryRun bool, report *RetentionReport, listed func(path string)) error {
+	grace := g.Grace
+	if grace <= 0 {
+		grace = BLOCK_GC_DEFAULT_GRACE
+	}
+	blocks, err := g.Source.Blocks(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing blocks: %w", err)
+	}
+	var candidates []StoredBlock
+	for _, block := range blocks {
+		if block.Modified.Before(now.Add(-grace)) {
+			candidates = append(candidates, block)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	referenced := make(map[string]bool)
+	read := make(map[string]bool)
+	if err := g.readReferences(ctx, "", referenced, read); err != nil {
+		return err
+	}
+	// Submissions saved since the start of the first read
+	if err := g.readReferences(ctx, now.UTC().Format("2006-01-02"), referenced, read); err != nil {
+		return err
+	}
+
+	var orphaned []StoredBlock
+	var paths []string
+	for _, block := range candidates {
+		if !referenced[block.Path] {
+			orphaned = append(orphaned, block)
+			paths = append(paths, block.Path)
+		}
+	}
+	if !dryRun {
+		if err := g.Deleter.DeleteObjects(ctx, paths); err != nil {
+			return err
+		}
+	}
+	for _, block := range orphaned {
+		if dryRun {
+			listed(block.Path)
+		}
+		report.add(block.Path, 1, block.Size)
+		report.OrphanedBlocks.Objects++
+		report.OrphanedBlocks.Bytes += block.Size
+	}
+	return nil
+}
+
+// Read the blocks referenced by submissions of days from fromDay on,
+// skipping submissions already read
+func (g *BlockGC) readReferences(ctx context.Context, fromDay string, referenced map[string]bool, read map[string]bool) error {
+	days, err := g.Source.Days(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing days of submissions: %w", err)
+	}
+	for _, day := range days {
+		if day < fromDay {
+			continue
+		}
+		paths, err := g.Source.Submissions(ctx, day)
+		if err != nil {
+			return fmt.Errorf("error listing submissions of %s: %w", day, err)
+		}
+		var unread []string
+		for _, metaPath := range paths {
+			if !read[metaPath] {
+				unread = append(unread, metaPath)
+			}
+		}
+		err = readConcurrently(ctx, g.Source, unread, func(metaPath string, bs []byte, err error) error {
+			hash, err := submissionBlockHash(metaPath, bs, err)
+			if err != nil {
+				return err
+			}
+			read[metaPath] = true
+			if hash != "" {
+				referenced[BLOCKS_PREFIX+hash+".dat"] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Block hash of the submission read at metaPath, empty if it was deleted
+// since it was listed
+func submissionBlockHash(metaPath string, bs []byte, err error) (string, error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading submission %s: %w", metaPath, err)
+	}
+	var meta struct {
+		BlockHash string `json:"block_hash"`
+	}
+	if err := json.Unmarshal(bs, &meta); err != nil || meta.BlockHash == "" {
+		return "", fmt.Errorf("can't tell the block of submission %s, check it with the verify command", metaPath)
+	}
+	return meta.BlockHash, nil
+}
+
+func (c *S3Cleaner) DeleteObjects(ctx context.Context, paths []string) error {
+	keys := make([]types.ObjectIdentifier, 0, len(paths))
+	for _, path := range paths {
+		key := c.Aws.Prefix + "/" + path
+		if err := c.archive(ctx, key, path); err != nil {
+			return err
+		}
+		keys = append(keys, types.ObjectIdentifier{Key: aws.String(key)})
+	}
+	return c.delete(ctx, keys)
+}
+
+func (c LocalFileSystemCleaner) DeleteObjects(ctx context.Context, paths []string) error {
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := c.remove(filepath.Join(c.Directory, filepath.FromSlash(path)), path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package delegation_backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestBlockGC(t *testing.T) {
+	dir := t.TempDir()
+	if err := LocalFileSystemSave(ObjectsToSave{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NKb"}`),
+		"submissions/2024-05-03/2024-05-03T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NKa"}`),
+		"blocks/3NKa.dat": []byte("12345"),
+		"blocks/3NKb.dat": []byte("1234567890"),
+		"blocks/3NKc.dat": []byte("123"),
+		"blocks/3NKd.dat": []byte("1"),
+	}, dir, logging.Logger("test")); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	// Blocks saved within the grace period are kept even if unreferenced
+	for name, saved := range map[string]time.Time{
+		"3NKa.dat": now.Add(-72 * time.Hour),
+		"3NKb.dat": now.Add(-72 * time.Hour),
+		"3NKc.dat": now.Add(-time.Hour),
+		"3NKd.dat": now.Add(-72 * time.Hour),
+	} {
+		if err := os.Chtimes(filepath.Join(dir, "blocks", name), saved, saved); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gc := &BlockGC{Source: LocalFileSystemSource{Directory: dir}, Deleter: LocalFileSystemCleaner{Directory: dir}, Grace: 48 * time.Hour}
+
+	report := newRetentionReport("filesystem", now, true)
+	var listed []string
+	if err := gc.Collect(context.Background(), now, true, report, func(path string) { listed = append(listed, path) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0] != "blocks/3NKd.dat" || report.OrphanedBlocks != (UsageCount{1, 1}) || report.Deleted["blocks/"] != (UsageCount{1, 1}) {
+		t.Fatalf("unexpected dry run: %v %+v", listed, report)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blocks", "3NKd.dat")); err != nil {
+		t.Fatal("expected nothing deleted by a dry run")
+	}
+
+	// Blocks of submissions deleted by the cleanup are collected after it,
+	// blocks of retained submissions are kept whenever they were saved
+	job := NewRetentionJob(func() time.Time { return now }, logging.Logger("test"))
+	job.Add("filesystem", LocalFileSystemCleaner{Directory: dir, SkipBlocks: true}, 24*time.Hour)
+	job.AddBlockGC("filesystem", gc)
+	reports := job.Run(context.Background(), false, nil)
+	if reports[0].Error != "" || reports[0].OrphanedBlocks != (UsageCount{2, 11}) || reports[0].Total() != (UsageCount{3, 32}) {
+		t.Fatalf("unexpected report: %+v", reports[0])
+	}
+	for name, kept := range map[string]bool{"3NKa.dat": true, "3NKb.dat": false, "3NKc.dat": true, "3NKd.dat": false} {
+		if _, err := os.Stat(filepath.Join(dir, "blocks", name)); (err == nil) != kept {
+			t.Errorf("expected %s kept: %v", name, kept)
+		}
+	}
+
+	// Blocks aren't collected when the block of a submission is unknown
+	if err := os.WriteFile(filepath.Join(dir, "submissions", "2024-05-03", "2024-05-03T11:00:00Z-B62qb.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Collect(context.Background(), now.Add(48*time.Hour), false, newRetentionReport("filesystem", now, false), nil); err == nil {
+		t.Fatal("expected an error collecting with a corrupt submission")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blocks", "3NKc.dat")); err != nil {
+		t.Fatal("expected nothing deleted")
+	}
+}
@@ -0,0 +1,185 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// Formats of the bin_prot serialization of blocks
+const (
+	// Every versioned type is prefixed by its version, as blocks of
+	// mainnet before the Berkeley hard fork
+	BLOCK_FORMAT_V1 = "v1"
+	// Without version tags, as of the Berkeley hard fork
+	BLOCK_FORMAT_V2 = "v2"
+)
+
+// Blocks smaller than this can't hold a protocol state along with its proof
+const BLOCK_VALIDATION_MIN_SIZE = 1024
+
+// Reasons of blocks failing validation, counted by the
+// `submit_invalid_block_<reason>` counters
+const (
+	INVALID_BLOCK_TOO_SMALL          = "too_small"
+	INVALID_BLOCK_VERSION_TAGS       = "version_tags"
+	INVALID_BLOCK_STATE_HASH         = "state_hash"
+	INVALID_BLOCK_GENESIS_STATE_HASH = "genesis_state_hash"
+	INVALID_BLOCK_UNEXPECTED_GENESIS = "unexpected_genesis"
+)
+
+// Size of serialized field elements
+const BLOCK_FIELD_SIZE = 32
+
+// Version of the types of v1 blocks, prefixing every versioned type
+const BLOCK_V1_VERSION_TAG = 1
+
+// Version tags of v1 blocks before the previous state hash (block,
+// protocol state, its polymorphic type and the hash) and before the
+// genesis state hash (protocol state body, its polymorphic type and the
+// hash)
+const (
+	blockV1StateTags = 4
+	blockV1BodyTags  = 3
+)
+
+// Modulus of the field of state hashes, the base field of Pallas
+var stateHashFieldModulus = pallasBaseModulus
+
+// InvalidBlockError tells why a block isn't a block of the network
+type InvalidBlockError struct {
+	Reason string
+	Detail string
+}
+
+func (e *InvalidBlockError) Error() string {
+	return e.Reason + ": " + e.Detail
+}
+
+// BlockValidator checks blocks are Mina blocks of the network by parsing
+// the start of their serialization: the version tags of the protocol
+// state (in the v1 format), the previous state hash and the genesis state
+// hash, which must be elements of the field, and the genesis state hash
+// of the network if configured. The rest of the block, from the
+// blockchain state on, isn't parsed.
+type BlockValidator struct {
+	format           string
+	genesisStateHash []byte
+	minSize          int
+	// Blocks failing validation are logged and counted but accepted
+	ReportOnly bool
+}
+
+func NewBlockValidator(config *BlockValidationConfig) (*BlockValidator, error) {
+	v := &BlockValidator{format: config.Format, minSize: config.MinSize, ReportOnly: config.ReportOnly}
+	if v.format != BLOCK_FORMAT_V1 && v.format != BLOCK_FORMAT_V2 {
+		return nil, fmt.Errorf("unknown block format %q, expected %s or %s", config.Format, BLOCK_FORMAT_V1, BLOCK_FORMAT_V2)
+	}
+	if v.minSize == 0 {
+		v.minSize = BLOCK_VALIDATION_MIN_SIZE
+	} else if v.minSize < 0 {
+		return nil, fmt.Errorf("min_size is negative: %d", config.MinSize)
+	}
+	if config.GenesisStateHash != "" {
+		hash, err := decodeStateHash(config.GenesisStateHash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid genesis_state_hash: %w", err)
+		}
+		v.genesisStateHash = hash
+	}
+	return v, nil
+}
+
+// Field of a base58check-encoded state hash, whose payload is the field
+// serialized with its version tag
+func decodeStateHash(s string) ([]byte, error) {
+	payload, version, err := base58.CheckDecode(s)
+	if err != nil {
+		return nil, err
+	}
+	if version != BASE58CHECK_VERSION_STATE_HASH {
+		return nil, fmt.Errorf("version byte %#x isn't the version of state hashes", version)
+	}
+	if len(payload) != 1+BLOCK_FIELD_SIZE || payload[0] != BLOCK_V1_VERSION_TAG {
+		return nil, fmt.Errorf("unexpected payload of %d bytes", len(payload))
+	}
+	return payload[1:], nil
+}
+
+// Check returns why the block isn't a block of the network, nil if it
+// passes validation
+func (v *BlockValidator) Check(block []byte) *InvalidBlockError {
+	if len(block) < v.minSize {
+		return &InvalidBlockError{INVALID_BLOCK_TOO_SMALL, fmt.Sprintf("%d bytes, expected at least %d", len(block), v.minSize)}
+	}
+	r := &blockReader{data: block}
+	if v.format == BLOCK_FORMAT_V1 && !r.versionTags(blockV1StateTags) {
+		return &InvalidBlockError{INVALID_BLOCK_VERSION_TAGS, "expected the version tags of the protocol state"}
+	}
+	if _, ok := r.field(); !ok {
+		return &InvalidBlockError{INVALID_BLOCK_STATE_HASH, "previous state hash isn't an element of the field"}
+	}
+	if v.format == BLOCK_FORMAT_V1 && !r.versionTags(blockV1BodyTags) {
+		return &InvalidBlockError{INVALID_BLOCK_VERSION_TAGS, "expected the version tags of the protocol state body"}
+	}
+	genesis, ok := r.field()
+	if !ok {
+		return &InvalidBlockError{INVALID_BLOCK_GENESIS_STATE_HASH, "genesis state hash isn't an element of the field"}
+	}
+	if v.genesisStateHash != nil && !bytes.Equal(genesis, v.genesisStateHash) {
+		return &InvalidBlockError{INVALID_BLOCK_UNEXPECTED_GENESIS, "block of another chain"}
+	}
+	return nil
+}
+
+// blockReader reads a block serialized by bin_prot
+type blockReader struct {
+	data   []byte
+	offset int
+}
+
+// Read n version tags of the v1 format, nat0 encoded
+func (r *blockReader) versionTags(n int) bool {
+	if r.offset+n > len(r.data) {
+		return false
+	}
+	for _, tag := range r.data[r.offset : r.offset+n] {
+		if tag != BLOCK_V1_VERSION_TAG {
+			return false
+		}
+	}
+	r.offset += n
+	return true
+}
+
+// Read a field element, serialized little-endian on 32 bytes, false
+// unless it is below the modulus
+func (r *blockReader) field() ([]byte, bool) {
+	if r.offset+BLOCK_FIELD_SIZE > len(r.data) {
+		return nil, false
+	}
+	field := r.data[r.offset : r.offset+BLOCK_FIELD_SIZE]
+	r.offset += BLOCK_FIELD_SIZE
+	return field, littleEndianInt(field).Cmp(stateHashFieldModulus) < 0
+}
+
+// Problems of block validation configurations, by network
+func validateBlockValidation(configs BlockValidationConfigs) []string {
+	networks := make([]string, 0, len(configs))
+	for network := range configs {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	var problems []string
+	for _, network := range networks {
+		if configs[network] == nil {
+			continue
+		}
+		if _, err := NewBlockValidator(configs[network]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", network, err))
+		}
+	}
+	return problems
+}
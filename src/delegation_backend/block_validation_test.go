@@ -0,0 +1,85 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// Genesis state hash of mainnet, of the blocks of req-with-snark
+const mainnetGenesisStateHash = "3NKeMoncuHab5ScarV5ViyF16cJPT4taWNSaTLS64Dp67wuXigPZ"
+
+// Genesis state hash of the block of req-v1-with-snark, serialized
+// without version tags
+const testnetGenesisStateHash = "3NKPwamATwyosgEyDo7jP4KmkiVPxRShBRYJRPm7hneZkqFBeDhG"
+
+func testBlock(name string, t *testing.T) []byte {
+	var req submitRequest
+	if err := json.Unmarshal(readTestFile(name, t), &req); err != nil {
+		t.Fatal(err)
+	}
+	return req.Data.Block.data
+}
+
+func TestBlockValidator(t *testing.T) {
+	v1Block := testBlock("req-with-snark", t)
+	v2Block := testBlock("req-v1-with-snark", t)
+	for _, c := range []struct {
+		config BlockValidationConfig
+		block  []byte
+		reason string
+	}{
+		{BlockValidationConfig{Format: BLOCK_FORMAT_V1}, v1Block, ""},
+		{BlockValidationConfig{Format: BLOCK_FORMAT_V1, GenesisStateHash: mainnetGenesisStateHash}, v1Block, ""},
+		{BlockValidationConfig{Format: BLOCK_FORMAT_V1, GenesisStateHash: testnetGenesisStateHash}, v1Block, INVALID_BLOCK_UNEXPECTED_GENESIS},
+		{BlockValidationConfig{Format: BLOCK_FORMAT_V1}, v2Block, INVALID_BLOCK_VERSION_TAGS},
+		{BlockValidationConfig{Format: BLOCK_FORMAT_V2, GenesisStateHash: testnetGenesisStateHash}, v2Block, ""},
+		{BlockValidationConfig{Format: BLOCK_FORMAT_V2, GenesisStateHash: mainnetGenesisStateHash}, v2Block, INVALID_BLOCK_UNEXPECTED_GENESIS},
+		{BlockValidationConfig{Format: BLOCK_FORMAT_V2}, bytes.Repeat([]byte{0xff}, 2048), INVALID_BLOCK_STATE_HASH},
+		{BlockValidationConfig{Format: BLOCK_FORMAT_V2}, append(make([]byte, 32), bytes.Repeat([]byte{0xff}, 2048)...), INVALID_BLOCK_GENESIS_STATE_HASH},
+		{BlockValidationConfig{Format: BLOCK_FORMAT_V2}, v2Block[:100], INVALID_BLOCK_TOO_SMALL},
+		{BlockValidationConfig{Format: BLOCK_FORMAT_V2, MinSize: 64}, v2Block[:100], ""},
+	} {
+		v, err := NewBlockValidator(&c.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		invalid := v.Check(c.block)
+		if (invalid == nil && c.reason != "") || (invalid != nil && invalid.Reason != c.reason) {
+			t.Errorf("expected %+v to fail with %q, got %v", c.config, c.reason, invalid)
+		}
+	}
+
+	for _, config := range []BlockValidationConfig{
+		{Format: "v3"},
+		{Format: BLOCK_FORMAT_V1, MinSize: -1},
+		{Format: BLOCK_FORMAT_V1, GenesisStateHash: "B62qkaKV3BLvLTf7nYXRehSaZAd36NWijt3MEmy2QgHRavboeRGtBMN"},
+	} {
+		if _, err := NewBlockValidator(&config); err == nil {
+			t.Errorf("expected %+v rejected", config)
+		}
+	}
+}
+
+func TestSubmitBlockValidation(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	storage, sh, tm := testSubmitH(2, Whitelist{req.Submitter: true})
+	tm.time = req.Data.CreatedAt
+	validator, err := NewBlockValidator(&BlockValidationConfig{Format: BLOCK_FORMAT_V1, GenesisStateHash: testnetGenesisStateHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.app.BlockValidator = validator
+	if rep := sh.testRequest(body); rep.Code != 400 || len(*storage) != 0 {
+		t.Fatalf("expected the block of another chain rejected, got %d", rep.Code)
+	}
+	// Blocks failing validation are accepted in report only mode
+	validator.ReportOnly = true
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected the block accepted as report only, got %d: %s", rep.Code, rep.Body)
+	}
+}
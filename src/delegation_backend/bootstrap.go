@@ -0,0 +1,126 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gocql/gocql"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Days noncurrent versions of objects of a bootstrapped bucket are kept,
+// objects themselves are deleted by the retention of the backend
+const BOOTSTRAP_S3_NONCURRENT_VERSION_DAYS = 30
+
+// Days before incomplete multipart uploads to a bootstrapped bucket are
+// aborted
+const BOOTSTRAP_S3_ABORT_UPLOADS_DAYS = 7
+
+// Wait for a keyspace created by Amazon Keyspaces, which creates them
+// asynchronously
+const BOOTSTRAP_KEYSPACE_TIMEOUT = 2 * time.Minute
+
+// BootstrapS3Bucket creates the bucket if it doesn't exist, with versioning
+// enabled and a lifecycle expiring noncurrent versions. Buckets which exist
+// are left as they are. Whether the bucket was created is returned.
+func BootstrapS3Bucket(ctx context.Context, client *s3.Client, bucket string, region string, log logging.StandardLogger) (bool, error) {
+	_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		return false, nil
+	}
+	var notFound *types.NotFound
+	if !errors.As(err, &notFound) {
+		return false, fmt.Errorf("error checking bucket %s: %w", bucket, err)
+	}
+
+	log.Infof("Creating S3 bucket %s in %s", bucket, region)
+	input := &s3.CreateBucketInput{Bucket: aws.String(bucket)}
+	// us-east-1 is the default location, which is rejected as a constraint
+	if region != "" && region != "us-east-1" {
+		input.CreateBucketConfiguration = &types.CreateBucketConfiguration{LocationConstraint: types.BucketLocationConstraint(region)}
+	}
+	if _, err := client.CreateBucket(ctx, input); err != nil {
+		var owned *types.BucketAlreadyOwnedByYou
+		if !errors.As(err, &owned) {
+			return false, fmt.Errorf("error creating bucket %s: %w", bucket, err)
+		}
+	}
+	if _, err := client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(bucket),
+		VersioningConfiguration: &types.VersioningConfiguration{Status: types.BucketVersioningStatusEnabled},
+	}); err != nil {
+		return true, fmt.Errorf("error enabling versioning of bucket %s: %w", bucket, err)
+	}
+	if _, err := client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: []types.LifecycleRule{{
+			ID:                             aws.String("uptime-service-backend"),
+			Status:                         types.ExpirationStatusEnabled,
+			Filter:                         &types.LifecycleRuleFilterMemberPrefix{Value: ""},
+			NoncurrentVersionExpiration:    &types.NoncurrentVersionExpiration{NoncurrentDays: BOOTSTRAP_S3_NONCURRENT_VERSION_DAYS},
+			AbortIncompleteMultipartUpload: &types.AbortIncompleteMultipartUpload{DaysAfterInitiation: BOOTSTRAP_S3_ABORT_UPLOADS_DAYS},
+		}}},
+	}); err != nil {
+		return true, fmt.Errorf("error configuring the lifecycle of bucket %s: %w", bucket, err)
+	}
+	return true, nil
+}
+
+// Replication of a bootstrapped keyspace, Amazon Keyspaces only supports
+// its single region strategy
+func keyspaceReplication(config *AwsKeyspacesConfig) string {
+	if config.CassandraHost == "" || strings.HasSuffix(config.CassandraHost, ".amazonaws.com") {
+		return "{'class': 'SingleRegionStrategy'}"
+	}
+	return "{'class': 'SimpleStrategy', 'replication_factor': 1}"
+}
+
+// BootstrapKeyspace creates the keyspace if it doesn't exist and applies
+// the migrations creating its tables
+func BootstrapKeyspace(config *AwsKeyspacesConfig, migrationPath string, log logging.StandardLogger) error {
+	// The session can't use a keyspace which doesn't exist yet
+	withoutKeyspace := *config
+	withoutKeyspace.Keyspace = ""
+	session, err := InitializeKeyspaceSession(&withoutKeyspace)
+	if err != nil {
+		return fmt.Errorf("could not initialize Cassandra session: %w", err)
+	}
+	defer session.Close()
+
+	exists := func() (bool, error) {
+		var name string
+		err := session.Query(`SELECT keyspace_name FROM system_schema.keyspaces WHERE keyspace_name = ?`, config.Keyspace).Scan(&name)
+		if errors.Is(err, gocql.ErrNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	found, err := exists()
+	if err != nil {
+		return fmt.Errorf("error checking keyspace %s: %w", config.Keyspace, err)
+	}
+	if !found {
+		log.Infof("Creating keyspace %s", config.Keyspace)
+		query := fmt.Sprintf(`CREATE KEYSPACE IF NOT EXISTS %s WITH replication = %s;`, config.Keyspace, keyspaceReplication(config))
+		if err := session.Query(query).Exec(); err != nil {
+			return fmt.Errorf("error creating keyspace %s: %w", config.Keyspace, err)
+		}
+		deadline := time.Now().Add(BOOTSTRAP_KEYSPACE_TIMEOUT)
+		for !found {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("keyspace %s not created after %v", config.Keyspace, BOOTSTRAP_KEYSPACE_TIMEOUT)
+			}
+			time.Sleep(time.Second)
+			if found, err = exists(); err != nil {
+				return fmt.Errorf("error checking keyspace %s: %w", config.Keyspace, err)
+			}
+		}
+	}
+	return MigrationUp(config, migrationPath)
+}
@@ -0,0 +1,76 @@
+package delegation_backend
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestBootstrapS3Bucket(t *testing.T) {
+	buckets := map[string]bool{"existing": true}
+	var requests []string
+	var lifecycle, location string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket := strings.TrimPrefix(r.URL.Path, "/")
+		requests = append(requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodHead && !buckets[bucket]:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPut && r.URL.RawQuery == "":
+			buckets[bucket] = true
+			location = string(body)
+		case r.Method == http.MethodPut && r.URL.RawQuery == "lifecycle=":
+			lifecycle = string(body)
+		}
+	}))
+	defer srv.Close()
+	client := s3.New(s3.Options{
+		Region:       "eu-west-1",
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	log := logging.Logger("test")
+
+	if created, err := BootstrapS3Bucket(context.Background(), client, "existing", "eu-west-1", log); err != nil || created {
+		t.Fatalf("expected the existing bucket left as is, got %v, error: %v", created, err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("unexpected requests %q", requests)
+	}
+
+	requests = nil
+	if created, err := BootstrapS3Bucket(context.Background(), client, "uptime", "eu-west-1", log); err != nil || !created {
+		t.Fatalf("expected the bucket created, got %v, error: %v", created, err)
+	}
+	expected := []string{"HEAD /uptime?", "PUT /uptime?", "PUT /uptime?versioning=", "PUT /uptime?lifecycle="}
+	if strings.Join(requests, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected requests %q, expected %q", requests, expected)
+	}
+	if !strings.Contains(location, "<LocationConstraint>eu-west-1</LocationConstraint>") {
+		t.Errorf("expected the bucket created in the region, got %s", location)
+	}
+	if !strings.Contains(lifecycle, "<NoncurrentDays>30</NoncurrentDays>") || !strings.Contains(lifecycle, "<DaysAfterInitiation>7</DaysAfterInitiation>") {
+		t.Errorf("unexpected lifecycle %s", lifecycle)
+	}
+}
+
+func TestKeyspaceReplication(t *testing.T) {
+	for host, expected := range map[string]string{
+		"":                                  "{'class': 'SingleRegionStrategy'}",
+		"cassandra.eu-west-1.amazonaws.com": "{'class': 'SingleRegionStrategy'}",
+		"localhost":                         "{'class': 'SimpleStrategy', 'replication_factor': 1}",
+	} {
+		if replication := keyspaceReplication(&AwsKeyspacesConfig{CassandraHost: host}); replication != expected {
+			t.Errorf("unexpected replication of host %q: %s", host, replication)
+		}
+	}
+}
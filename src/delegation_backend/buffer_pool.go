@@ -0,0 +1,51 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Buffers larger than this are left to the garbage collector rather than
+// pooled, so that a few outsized payloads don't stay in memory
+const BUFFER_POOL_MAX_SIZE = 16 << 20
+
+// BufferPool reuses byte slices across requests, so that payloads of
+// several megabytes aren't allocated anew for every submission.
+type BufferPool struct {
+	pool    sync.Pool
+	maxSize int
+}
+
+func NewBufferPool(maxSize int) *BufferPool {
+	return &BufferPool{maxSize: maxSize}
+}
+
+// Get returns a slice of the given length, backed by a pooled buffer if
+// one is large enough. The content of the slice is undefined.
+func (p *BufferPool) Get(size int) []byte {
+	if ptr, ok := p.pool.Get().(*[]byte); ok {
+		if cap(*ptr) >= size {
+			incMetric("buffer_pool_reuses")
+			return (*ptr)[:size]
+		}
+		// Too small for this payload, likely too small for the next ones
+	}
+	incMetric("buffer_pool_allocations")
+	return make([]byte, size)
+}
+
+// Put returns the slice to the pool, it must not be used afterwards
+func (p *BufferPool) Put(bs []byte) {
+	if cap(bs) == 0 || cap(bs) > p.maxSize {
+		return
+	}
+	p.pool.Put(&bs)
+}
+
+// Pools of the submit path: request bodies, blocks decoded from them,
+// and the metadata saved along with the blocks
+var (
+	bodyBuffers   = NewBufferPool(BUFFER_POOL_MAX_SIZE)
+	base64Buffers = NewBufferPool(BUFFER_POOL_MAX_SIZE)
+	metaBuffers   = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+)
@@ -0,0 +1,25 @@
+package delegation_backend
+
+import "testing"
+
+func TestBufferPool(t *testing.T) {
+	p := NewBufferPool(1 << 10)
+	bs := p.Get(100)
+	if len(bs) != 100 {
+		t.Fatalf("expected a slice of 100 bytes, got %d", len(bs))
+	}
+	p.Put(bs)
+	// A pooled buffer is resliced to the length requested
+	if bs := p.Get(10); len(bs) != 10 {
+		t.Fatalf("expected a slice of 10 bytes, got %d", len(bs))
+	}
+	if bs := p.Get(500); len(bs) != 500 {
+		t.Fatalf("expected a slice of 500 bytes, got %d", len(bs))
+	}
+
+	// Outsized buffers aren't kept
+	p.Put(make([]byte, 2<<10))
+	if ptr, ok := p.pool.Get().(*[]byte); ok {
+		t.Fatalf("expected no pooled buffer, got %d bytes", cap(*ptr))
+	}
+}
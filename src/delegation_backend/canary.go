@@ -0,0 +1,183 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+const CANARY_DEFAULT_INTERVAL = 5 * time.Minute
+
+// Remote address of canary submissions in logs and audit records
+const CANARY_REMOTE_ADDR = "canary:0"
+
+// CanaryResult is the outcome of a canary submission
+type CanaryResult struct {
+	At        time.Time         `json:"at"`
+	Ok        bool              `json:"ok"`
+	Status    int               `json:"status"`
+	Error     string            `json:"error,omitempty"`
+	Storage   map[string]string `json:"storage,omitempty"`
+	LatencyMs float64           `json:"latency_ms"`
+	// Set by the submit handler once the submission is saved
+	outcomes StorageOutcomes
+}
+
+type canaryContextKey struct{}
+
+// Returns the result of the canary run the request is made by,
+// nil for all requests not made by the canary.
+func canaryRunOf(ctx context.Context) *CanaryResult {
+	run, _ := ctx.Value(canaryContextKey{}).(*CanaryResult)
+	return run
+}
+
+// Canary periodically runs a signed submission of a dedicated key
+// through the submit handler and all configured storage backends.
+//
+// The canary request is made in-process and can't be forged by clients,
+// it is exempt from the whitelist, client certificates, rate limiting,
+// the checks of `created_at` and replay protection, so that a single pre-signed
+// request can be reused. Its signature is always verified, bypassing
+// the verification cache.
+type Canary struct {
+	handler   http.Handler
+	body      []byte
+	submitter Pk
+	now       nowFunc
+	log       logging.StandardLogger
+	mutex     sync.Mutex
+	last      *CanaryResult
+	// Optional, called with the result of every run
+	OnResult func(CanaryResult)
+}
+
+func NewCanary(handler http.Handler, body []byte, now nowFunc, log logging.StandardLogger) (*Canary, error) {
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("malformed canary request: %w", err)
+	}
+	if !req.CheckRequiredFields() {
+		return nil, errors.New("canary request misses one of required fields")
+	}
+	c := &Canary{handler: handler, body: body, submitter: req.Submitter, now: now, log: log}
+	metrics.Set("canary_ok", expvar.Func(func() interface{} {
+		if last := c.Last(); last != nil && last.Ok {
+			return 1
+		}
+		return 0
+	}))
+	return c, nil
+}
+
+// LoadCanary creates the canary submitting the request read from the file
+func LoadCanary(handler http.Handler, requestFile string, now nowFunc, log logging.StandardLogger) (*Canary, error) {
+	body, err := os.ReadFile(requestFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading canary request: %w", err)
+	}
+	return NewCanary(handler, body, now, log)
+}
+
+// Response writer keeping the status and the body of the response
+type canaryResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *canaryResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *canaryResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *canaryResponseWriter) Write(bs []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(bs)
+}
+
+// Run submits the canary request, the submission succeeds if it is
+// accepted and saved to all storage backends.
+func (c *Canary) Run() CanaryResult {
+	result := &CanaryResult{At: c.now()}
+	ctx := context.WithValue(context.Background(), canaryContextKey{}, result)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/submit", bytes.NewReader(c.body))
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		r.RemoteAddr = CANARY_REMOTE_ADDR
+		rw := &canaryResponseWriter{header: make(http.Header)}
+		c.handler.ServeHTTP(rw, r)
+		result.Status = rw.status
+		if rw.status != http.StatusOK {
+			var errResp errorResponse
+			_ = json.Unmarshal(rw.body.Bytes(), &errResp)
+			result.Error = fmt.Sprintf("submission rejected with %d %s", rw.status, errResp.Msg)
+		}
+	}
+	result.LatencyMs = float64(c.now().Sub(result.At).Microseconds()) / 1000
+	result.Storage = storageOutcomeStrings(result.outcomes)
+	result.Ok = result.Error == ""
+	for backend, err := range result.outcomes {
+		if err != nil {
+			result.Ok = false
+			if result.Error == "" {
+				result.Error = fmt.Sprintf("saving to %s failed: %v", backend, err)
+			}
+		}
+	}
+
+	incMetric("canary_runs")
+	if result.Ok {
+		metrics.Set("canary_last_success", expvarInt(result.At.Unix()))
+	} else {
+		incMetric("canary_failures")
+		c.log.Errorf("Canary submission of %s failed: %s", c.submitter.String(), result.Error)
+	}
+	c.mutex.Lock()
+	c.last = result
+	c.mutex.Unlock()
+	if c.OnResult != nil {
+		c.OnResult(*result)
+	}
+	return *result
+}
+
+func expvarInt(v int64) *expvar.Int {
+	i := new(expvar.Int)
+	i.Set(v)
+	return i
+}
+
+// Last returns the result of the latest run, nil before the first run
+func (c *Canary) Last() *CanaryResult {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.last == nil {
+		return nil
+	}
+	last := *c.last
+	return &last
+}
+
+// Run the canary now and then every interval.
+func (c *Canary) RunLoop(interval time.Duration) {
+	for {
+		c.Run()
+		time.Sleep(interval)
+	}
+}
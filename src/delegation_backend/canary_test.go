@@ -0,0 +1,99 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestCanary(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	// The canary key is not whitelisted
+	storage, sh, tm := testSubmitH(10, Whitelist{})
+	sh.app.CreatedAtMaxAge = time.Minute
+	tm.time = tm.time.Add(time.Hour)
+	guard, _ := NewReplayGuard("")
+	sh.app.ReplayGuard = guard
+	canary, err := NewCanary(sh, body, tm.Now, logging.Logger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canary.Last() != nil {
+		t.Fatal("unexpected result before the first run")
+	}
+
+	// The same request is accepted on every run
+	for i := 0; i < 2; i++ {
+		if result := canary.Run(); !result.Ok || result.Status != 200 {
+			t.Fatalf("expected canary submission accepted, got %+v", result)
+		}
+	}
+	if len(*storage) == 0 {
+		t.Fatal("canary submission not saved")
+	}
+	if rep := sh.testRequest(body); rep.Code != 401 {
+		t.Fatalf("expected request of a client rejected, got %v", rep)
+	}
+
+	save := sh.app.Save
+	sh.app.Save = func(ctx context.Context, objs ObjectsToSave) StorageOutcomes {
+		save(ctx, objs)
+		return StorageOutcomes{"s3": nil, "keyspaces": errors.New("timeout")}
+	}
+	result := canary.Run()
+	if result.Ok || result.Status != 200 || result.Storage["keyspaces"] != "error: timeout" || result.Error == "" {
+		t.Fatalf("expected storage failure, got %+v", result)
+	}
+	if last := canary.Last(); last == nil || last.Ok {
+		t.Fatalf("unexpected last result: %+v", last)
+	}
+}
+
+func TestCanaryInvalidSignature(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	req.Sig[0] ^= 1
+	badBody, _ := json.Marshal(req)
+	_, sh, tm := testSubmitH(10, Whitelist{})
+	canary, err := NewCanary(sh, badBody, tm.Now, logging.Logger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := canary.Run(); result.Ok || result.Status != 401 {
+		t.Fatalf("expected invalid signature, got %+v", result)
+	}
+	if _, err := NewCanary(sh, []byte("{}"), tm.Now, logging.Logger("test")); err == nil {
+		t.Fatal("expected incomplete canary request refused")
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	_, sh, tm := testSubmitH(10, Whitelist{})
+	canary, _ := NewCanary(sh, body, tm.Now, logging.Logger("test"))
+	ready := func(isReady bool) (int, ReadinessStatus) {
+		rr := httptest.NewRecorder()
+		ReadyzHandler(func() bool { return isReady }, canary, nil).ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+		var status ReadinessStatus
+		_ = json.Unmarshal(rr.Body.Bytes(), &status)
+		return rr.Code, status
+	}
+	if code, _ := ready(true); code != 503 {
+		t.Fatalf("expected unavailable before the first canary run, got %d", code)
+	}
+	canary.Run()
+	if code, status := ready(true); code != 200 || status.Canary == nil || !status.Canary.Ok {
+		t.Fatalf("unexpected readiness %d: %+v", code, status)
+	}
+	if code, _ := ready(false); code != 503 {
+		t.Fatalf("expected unavailable, got %d", code)
+	}
+}
@@ -0,0 +1,324 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Max size of a chunk, below the 1 MiB limit of bodies of common proxies
+	CHUNKED_UPLOAD_CHUNK_SIZE = 1 << 20
+	// Time an upload is kept since its last chunk
+	CHUNKED_UPLOAD_TTL = 10 * time.Minute
+	// Max uploads in progress, each buffering up to the max payload size
+	CHUNKED_UPLOAD_MAX_UPLOADS = 64
+	// Max uploads in progress of a client IP
+	CHUNKED_UPLOAD_MAX_UPLOADS_PER_CLIENT = 4
+)
+
+// Codes of rejections of chunked uploads
+const (
+	CHUNKED_UPLOAD_NOT_FOUND_CODE       = "upload_not_found"
+	CHUNKED_UPLOAD_OFFSET_MISMATCH_CODE = "upload_offset_mismatch"
+	CHUNKED_UPLOAD_INCOMPLETE_CODE      = "upload_incomplete"
+	CHUNKED_UPLOAD_COMPLETING_CODE      = "upload_completing"
+	CHUNKED_UPLOAD_DIGEST_MISMATCH_CODE = "upload_digest_mismatch"
+)
+
+// Request of /submit/init, announcing the body of a submission to upload
+type chunkedUploadInit struct {
+	// Size in bytes of the body of the submission
+	Size int64 `json:"size"`
+	// Hex-encoded SHA-256 digest of the body of the submission
+	Sha256 string `json:"sha256"`
+}
+
+// ChunkedUploadStatus is the response of the chunked upload endpoints
+type ChunkedUploadStatus struct {
+	UploadId string `json:"upload_id"`
+	Size     int64  `json:"size"`
+	// Bytes received so far, the offset of the next chunk
+	Received  int64     `json:"received"`
+	ChunkSize int64     `json:"chunk_size"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type chunkedUpload struct {
+	id      string
+	client  string
+	sha256  [sha256.Size]byte
+	size    int64
+	data    []byte
+	expires time.Time
+	// Set while the submission is handled, so that it is completed once
+	completing bool
+}
+
+// ChunkedUploads keeps the bodies of submissions uploaded in chunks until
+// they are complete, for blocks exceeding limits of the size of bodies of
+// proxies between block producers and the backend. Completed bodies are
+// checked against their digest and go through the submit pipeline as
+// bodies of /submit do, so that the size limits of submissions apply.
+type ChunkedUploads struct {
+	mutex               sync.Mutex
+	uploads             map[string]*chunkedUpload
+	chunkSize           int64
+	ttl                 time.Duration
+	maxUploads          int
+	maxUploadsPerClient int
+	now                 nowFunc
+}
+
+func NewChunkedUploads(chunkSize int64, ttl time.Duration, maxUploads int, maxUploadsPerClient int) *ChunkedUploads {
+	return &ChunkedUploads{
+		uploads:             make(map[string]*chunkedUpload),
+		chunkSize:           chunkSize,
+		ttl:                 ttl,
+		maxUploads:          maxUploads,
+		maxUploadsPerClient: maxUploadsPerClient,
+		now:                 time.Now,
+	}
+}
+
+// Number of uploads in progress
+func (u *ChunkedUploads) Len() int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return len(u.uploads)
+}
+
+// Remove expired uploads, the mutex must be held
+func (u *ChunkedUploads) expire(now time.Time) {
+	for id, upload := range u.uploads {
+		if !upload.completing && !now.Before(upload.expires) {
+			delete(u.uploads, id)
+			incMetric("chunked_uploads_expired")
+		}
+	}
+}
+
+func (u *ChunkedUploads) status(upload *chunkedUpload) ChunkedUploadStatus {
+	return ChunkedUploadStatus{
+		UploadId:  upload.id,
+		Size:      upload.size,
+		Received:  int64(len(upload.data)),
+		ChunkSize: u.chunkSize,
+		ExpiresAt: upload.expires,
+	}
+}
+
+// Handler serves the chunked upload endpoints under the path of the
+// submit handler h, e.g. /v1/submit/:
+//
+//	POST /v1/submit/init                          starts an upload, body `{"size": ..., "sha256": "..."}`
+//	POST /v1/submit/chunk?upload_id=...&offset=N  appends the body to the upload, at most chunk_size bytes
+//	POST /v1/submit/complete?upload_id=...        submits the body uploaded, responded as /v1/submit is
+//
+// Chunks are appended in order, a chunk at another offset than the bytes
+// received is rejected with 409 and the status of the upload, so that the
+// client resumes from there. Retries of chunks already received are
+// accepted.
+func (u *ChunkedUploads) Handler(h *SubmitH) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		switch path.Base(r.URL.Path) {
+		case "init":
+			u.init(h, rw, r)
+		case "chunk":
+			u.chunk(h, rw, r)
+		case "complete":
+			u.complete(h, rw, r)
+		default:
+			writeJSON(rw, http.StatusNotFound, errorResponse{"Not found"})
+		}
+	})
+}
+
+func (u *ChunkedUploads) init(h *SubmitH, rw http.ResponseWriter, r *http.Request) {
+	var req chunkedUploadInit
+	if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeJSON(rw, http.StatusBadRequest, errorResponse{"Expected {\"size\": ..., \"sha256\": \"...\"}"})
+		return
+	}
+	var digest [sha256.Size]byte
+	if n, err := hex.Decode(digest[:], []byte(req.Sha256)); err != nil || n != sha256.Size {
+		writeJSON(rw, http.StatusBadRequest, errorResponse{"Field sha256 isn't a hex-encoded SHA-256 digest"})
+		return
+	}
+	maxPayloadSize := int64(MAX_SUBMIT_PAYLOAD_SIZE)
+	if h.app.SubmitSizes != nil {
+		maxPayloadSize = h.app.SubmitSizes.Limits().MaxPayloadSize
+	}
+	if req.Size <= 0 {
+		writeJSON(rw, http.StatusBadRequest, errorResponse{"Field size must be positive"})
+		return
+	} else if req.Size > maxPayloadSize {
+		incMetric("submit_payload_too_large")
+		h.app.Log.Warnf("Chunked upload too large: %d bytes (max: %d)", req.Size, maxPayloadSize)
+		writeJSON(rw, http.StatusRequestEntityTooLarge, errorResponse{"Field size exceeds the max size of submissions of " + strconv.FormatInt(maxPayloadSize, 10) + " bytes"})
+		return
+	}
+	id, err := randomToken(16)
+	if err != nil {
+		writeJSON(rw, http.StatusInternalServerError, errorResponse{"Unexpected server error"})
+		return
+	}
+	client := hostOf(r.RemoteAddr)
+	if h.app.ClientIPResolver != nil {
+		client = hostOf(h.app.ClientIPResolver.ClientIP(r))
+	}
+
+	u.mutex.Lock()
+	now := u.now()
+	u.expire(now)
+	ofClient := 0
+	for _, upload := range u.uploads {
+		if upload.client == client {
+			ofClient++
+		}
+	}
+	if len(u.uploads) >= u.maxUploads || ofClient >= u.maxUploadsPerClient {
+		u.mutex.Unlock()
+		incMetric("chunked_uploads_rejected")
+		h.app.Log.Warnf("Rejecting chunked upload of %s, %d uploads in progress of which %d of the client", client, len(u.uploads), ofClient)
+		rw.Header().Set("Retry-After", strconv.Itoa(int(u.ttl.Seconds())))
+		writeJSON(rw, http.StatusServiceUnavailable, errorResponse{"Too many uploads in progress"})
+		return
+	}
+	upload := &chunkedUpload{id: id, client: client, sha256: digest, size: req.Size, expires: now.Add(u.ttl)}
+	u.uploads[id] = upload
+	status := u.status(upload)
+	u.mutex.Unlock()
+
+	incMetric("chunked_uploads_started")
+	h.app.Log.Debugf("Started chunked upload %s of %d bytes from %s", id, req.Size, client)
+	writeJSON(rw, http.StatusOK, status)
+}
+
+// Look the upload of the request up, responding with 404 if there is none
+func (u *ChunkedUploads) lookup(rw http.ResponseWriter, r *http.Request) *chunkedUpload {
+	id := r.URL.Query().Get("upload_id")
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.expire(u.now())
+	upload, exists := u.uploads[id]
+	if !exists {
+		writeJSON(rw, http.StatusNotFound, submitErrorResponse{Msg: "Upload not found, it may have expired", Code: CHUNKED_UPLOAD_NOT_FOUND_CODE})
+		return nil
+	}
+	return upload
+}
+
+func (u *ChunkedUploads) chunk(h *SubmitH, rw http.ResponseWriter, r *http.Request) {
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeJSON(rw, http.StatusBadRequest, errorResponse{"Query parameter offset must be a non-negative integer"})
+		return
+	}
+	if r.ContentLength == -1 {
+		writeJSON(rw, http.StatusLengthRequired, errorResponse{"Content-Length required"})
+		return
+	} else if r.ContentLength > u.chunkSize {
+		writeJSON(rw, http.StatusRequestEntityTooLarge, errorResponse{"Chunk exceeds the chunk size of " + strconv.FormatInt(u.chunkSize, 10) + " bytes"})
+		return
+	}
+	upload := u.lookup(rw, r)
+	if upload == nil {
+		return
+	}
+	chunk := bodyBuffers.Get(int(r.ContentLength))
+	defer bodyBuffers.Put(chunk)
+	if _, err := io.ReadFull(r.Body, chunk); err != nil {
+		h.app.Log.Debugf("Error while reading chunk of upload %s: %v", upload.id, err)
+		writeJSON(rw, http.StatusBadRequest, errorResponse{"Error reading the body"})
+		return
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	received := int64(len(upload.data))
+	end := offset + int64(len(chunk))
+	switch {
+	case upload.completing:
+		writeJSON(rw, http.StatusConflict, submitErrorResponse{Msg: "Upload is being completed", Code: CHUNKED_UPLOAD_COMPLETING_CODE})
+		return
+	case end > upload.size:
+		writeJSON(rw, http.StatusRequestEntityTooLarge, errorResponse{"Chunk exceeds the size of the upload"})
+		return
+	case offset == received:
+		if upload.data == nil {
+			upload.data = make([]byte, 0, upload.size)
+		}
+		upload.data = append(upload.data, chunk...)
+	case end <= received && bytes.Equal(upload.data[offset:end], chunk):
+		// Retry of a chunk received
+	default:
+		incMetric("chunked_upload_offset_mismatches")
+		rw.Header().Set("Upload-Offset", strconv.FormatInt(received, 10))
+		writeJSON(rw, http.StatusConflict, submitErrorResponse{Msg: "Expected the chunk at offset " + strconv.FormatInt(received, 10), Code: CHUNKED_UPLOAD_OFFSET_MISMATCH_CODE})
+		return
+	}
+	upload.expires = u.now().Add(u.ttl)
+	writeJSON(rw, http.StatusOK, u.status(upload))
+}
+
+func (u *ChunkedUploads) complete(h *SubmitH, rw http.ResponseWriter, r *http.Request) {
+	upload := u.lookup(rw, r)
+	if upload == nil {
+		return
+	}
+	u.mutex.Lock()
+	if upload.completing {
+		u.mutex.Unlock()
+		writeJSON(rw, http.StatusConflict, submitErrorResponse{Msg: "Upload is being completed", Code: CHUNKED_UPLOAD_COMPLETING_CODE})
+		return
+	}
+	if received := int64(len(upload.data)); received != upload.size {
+		u.mutex.Unlock()
+		rw.Header().Set("Upload-Offset", strconv.FormatInt(received, 10))
+		writeJSON(rw, http.StatusConflict, submitErrorResponse{Msg: "Received " + strconv.FormatInt(received, 10) + " of " + strconv.FormatInt(upload.size, 10) + " bytes", Code: CHUNKED_UPLOAD_INCOMPLETE_CODE})
+		return
+	}
+	if sha256.Sum256(upload.data) != upload.sha256 {
+		delete(u.uploads, upload.id)
+		u.mutex.Unlock()
+		incMetric("chunked_upload_digest_mismatches")
+		h.app.Log.Warnf("Digest of chunked upload %s from %s doesn't match", upload.id, upload.client)
+		writeJSON(rw, http.StatusUnprocessableEntity, submitErrorResponse{Msg: "Body uploaded doesn't match its sha256 digest, the upload is discarded", Code: CHUNKED_UPLOAD_DIGEST_MISMATCH_CODE})
+		return
+	}
+	upload.completing = true
+	u.mutex.Unlock()
+
+	// The body uploaded is submitted as the body of the request, headers
+	// such as Idempotency-Key apply to the submission
+	submit := r.Clone(r.Context())
+	submit.Body = io.NopCloser(bytes.NewReader(upload.data))
+	submit.ContentLength = upload.size
+	rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+	h.ServeHTTP(rec, submit)
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	// Uploads of submissions which failed on the server can be completed again
+	if rec.status >= 500 {
+		upload.completing = false
+		upload.expires = u.now().Add(u.ttl)
+		return
+	}
+	delete(u.uploads, upload.id)
+	if rec.status == http.StatusOK {
+		incMetric("chunked_uploads_completed")
+	}
+}
@@ -0,0 +1,124 @@
+package delegation_backend
+
+import (
+	"block_producers_uptime/client"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestChunkedUpload(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	storage, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	uploads := NewChunkedUploads(1000, time.Minute, 2, 1)
+	handler := uploads.Handler(sh)
+	post := func(path string, reqBody []byte) (*httptest.ResponseRecorder, ChunkedUploadStatus) {
+		rep := httptest.NewRecorder()
+		handler.ServeHTTP(rep, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(reqBody)))
+		var status ChunkedUploadStatus
+		json.Unmarshal(rep.Body.Bytes(), &status)
+		return rep, status
+	}
+	digest := sha256.Sum256(body)
+	init := []byte(`{"size": ` + strconv.Itoa(len(body)) + `, "sha256": "` + hex.EncodeToString(digest[:]) + `"}`)
+
+	rep, status := post("/v1/submit/init", init)
+	if rep.Code != 200 || status.UploadId == "" || status.ChunkSize != 1000 {
+		t.Fatalf("unexpected failure: %d %s", rep.Code, rep.Body.String())
+	}
+	// One upload per client at a time
+	if rep, _ := post("/v1/submit/init", init); rep.Code != 503 {
+		t.Fatalf("expected a second upload of the client rejected, got %d", rep.Code)
+	}
+	chunkPath := func(offset int) string {
+		return "/v1/submit/chunk?upload_id=" + status.UploadId + "&offset=" + strconv.Itoa(offset)
+	}
+	if rep, _ := post(chunkPath(0), body[:1001]); rep.Code != 413 {
+		t.Fatalf("expected a chunk above the chunk size rejected, got %d", rep.Code)
+	}
+	if rep, _ := post("/v1/submit/complete?upload_id="+status.UploadId, nil); rep.Code != 409 {
+		t.Fatalf("expected an incomplete upload rejected, got %d", rep.Code)
+	}
+	for offset := 0; offset < len(body); offset += 1000 {
+		chunk := body[offset:min(len(body), offset+1000)]
+		if rep, status := post(chunkPath(offset), chunk); rep.Code != 200 || status.Received != int64(offset+len(chunk)) {
+			t.Fatalf("unexpected failure of chunk at %d: %d %s", offset, rep.Code, rep.Body.String())
+		}
+		if offset == 1000 {
+			// Retries of chunks received are accepted, chunks out of order aren't
+			if rep, _ := post(chunkPath(offset), chunk); rep.Code != 200 {
+				t.Fatalf("expected a retried chunk accepted, got %d", rep.Code)
+			}
+			if rep, _ := post(chunkPath(offset+2000), chunk); rep.Code != 409 || rep.Header().Get("Upload-Offset") != "2000" {
+				t.Fatalf("expected a chunk out of order rejected, got %d %v", rep.Code, rep.Header())
+			}
+		}
+	}
+	rep, _ = post("/v1/submit/complete?upload_id="+status.UploadId, nil)
+	if rep.Code != 200 {
+		t.Fatalf("unexpected failure: %d %s", rep.Code, rep.Body.String())
+	}
+	if _, saved := (*storage)[makePaths(sh.app.Now(), req.GetBlockDataHash(), req.Submitter).Block]; !saved {
+		t.Fatal("expected the block saved")
+	}
+	if uploads.Len() != 0 {
+		t.Fatalf("expected the upload removed, got %d uploads", uploads.Len())
+	}
+
+	// Bodies not matching their digest are discarded
+	init = []byte(`{"size": 4, "sha256": "` + hex.EncodeToString(digest[:]) + `"}`)
+	_, status = post("/v1/submit/init", init)
+	post(chunkPath(0), []byte("{}{}"))
+	rep, _ = post("/v1/submit/complete?upload_id="+status.UploadId, nil)
+	var resp submitErrorResponse
+	json.Unmarshal(rep.Body.Bytes(), &resp)
+	if rep.Code != 422 || resp.Code != CHUNKED_UPLOAD_DIGEST_MISMATCH_CODE || uploads.Len() != 0 {
+		t.Fatalf("expected the upload discarded, got %d %s", rep.Code, rep.Body.String())
+	}
+}
+
+// The client uploads bodies above its chunk size in chunks
+func TestClientChunkedSubmission(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	storage, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	uploads := NewChunkedUploads(CHUNKED_UPLOAD_CHUNK_SIZE, time.Minute, 2, 1)
+	mux := http.NewServeMux()
+	mux.Handle("/v1/submit", sh)
+	chunks := 0
+	mux.Handle("/v1/submit/", http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/submit/chunk" {
+			chunks++
+		}
+		uploads.Handler(sh).ServeHTTP(rw, r)
+	}))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := client.New(srv.URL)
+	c.ChunkSize = 1000
+	resp, err := c.Submit(context.Background(), &client.Request{Body: body})
+	if err != nil || resp.Status != client.STATUS_OK {
+		t.Fatalf("expected the submission accepted, got %+v, error: %v", resp, err)
+	}
+	if expected := (len(body) + 999) / 1000; chunks != expected {
+		t.Fatalf("expected %d chunks, got %d", expected, chunks)
+	}
+	if _, saved := (*storage)[makePaths(sh.app.Now(), req.GetBlockDataHash(), req.Submitter).Block]; !saved {
+		t.Fatal("expected the block saved")
+	}
+}
@@ -0,0 +1,73 @@
+package delegation_backend
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ClientCertAuth maps verified client certificates to the submitter keys
+// they are allowed to submit for.
+//
+// A certificate is mapped either explicitly by its SHA-256 fingerprint
+// (hex-encoded DER) in the mapping file, or implicitly by having
+// the submitter's public key as its subject common name.
+type ClientCertAuth struct {
+	fingerprints map[string]map[Pk]bool
+}
+
+// LoadClientCertAuth reads the mapping file, a JSON object of
+// `{"<fingerprint>": ["<public key>", ...]}`. Empty path means only
+// common-name mapping is used.
+func LoadClientCertAuth(mapFile string) (*ClientCertAuth, error) {
+	auth := &ClientCertAuth{fingerprints: make(map[string]map[Pk]bool)}
+	if mapFile == "" {
+		return auth, nil
+	}
+	bs, err := os.ReadFile(mapFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client certificate map: %w", err)
+	}
+	var raw map[string][]string
+	if err := json.Unmarshal(bs, &raw); err != nil {
+		return nil, fmt.Errorf("error decoding client certificate map: %w", err)
+	}
+	for fingerprint, pkStrs := range raw {
+		pks := make(map[Pk]bool)
+		for _, pkStr := range pkStrs {
+			var pk Pk
+			if err := StringToPk(&pk, pkStr); err != nil {
+				return nil, fmt.Errorf("invalid public key %s for certificate %s: %w", pkStr, fingerprint, err)
+			}
+			pks[pk] = true
+		}
+		auth.fingerprints[normalizeFingerprint(fingerprint)] = pks
+	}
+	return auth, nil
+}
+
+// Accept both plain hex and colon-separated notation in any case
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+func ClientCertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Authorized checks whether the holder of the certificate may submit for pk.
+func (auth *ClientCertAuth) Authorized(cert *x509.Certificate, pk Pk) bool {
+	if pks, exists := auth.fingerprints[ClientCertFingerprint(cert)]; exists {
+		return pks[pk]
+	}
+	var cnPk Pk
+	if err := StringToPk(&cnPk, cert.Subject.CommonName); err == nil {
+		return cnPk == pk
+	}
+	return false
+}
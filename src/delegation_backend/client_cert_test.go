@@ -0,0 +1,94 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mkClientCert(t *testing.T, cn string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestClientCertAuthorized(t *testing.T) {
+	var pk1, pk2 Pk
+	StringToPk(&pk1, PK1)
+	StringToPk(&pk2, PK2)
+	cnCert := mkClientCert(t, PK1)
+	mappedCert := mkClientCert(t, "exporter.example.com")
+
+	mapFile := filepath.Join(t.TempDir(), "map.json")
+	bs, _ := json.Marshal(map[string][]string{ClientCertFingerprint(mappedCert): {PK2}})
+	os.WriteFile(mapFile, bs, 0600)
+	auth, err := LoadClientCertAuth(mapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !auth.Authorized(cnCert, pk1) || auth.Authorized(cnCert, pk2) {
+		t.Error("common name mapping failed")
+	}
+	if !auth.Authorized(mappedCert, pk2) || auth.Authorized(mappedCert, pk1) {
+		t.Error("fingerprint mapping failed")
+	}
+	if auth.Authorized(mkClientCert(t, "unknown"), pk1) {
+		t.Error("unmapped certificate authorized")
+	}
+}
+
+func TestSubmitClientCertRequired(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.ClientCertAuth, _ = LoadClientCertAuth("")
+
+	if rep := sh.testRequest(body); rep.Code != 401 {
+		t.Fatalf("expected 401 without client certificate: %v", rep)
+	}
+
+	withCert := func(cert *x509.Certificate) int {
+		rep := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", v1Submit, bytes.NewReader(body))
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		sh.ServeHTTP(rep, r)
+		return rep.Code
+	}
+	if code := withCert(mkClientCert(t, PK2)); code != 401 {
+		t.Fatalf("expected 401 for certificate of other submitter, got %d", code)
+	}
+	if code := withCert(mkClientCert(t, req.Submitter.String())); code != 200 {
+		t.Fatalf("expected 200 for matching certificate, got %d", code)
+	}
+}
@@ -0,0 +1,183 @@
+package delegation_backend
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	HEADER_X_FORWARDED_FOR = "X-Forwarded-For"
+	HEADER_X_REAL_IP       = "X-Real-IP"
+	HEADER_FORWARDED       = "Forwarded"
+)
+
+// ClientIPResolver derives the address of the client which made a request.
+// Headers set by proxies are only honored when the request came from
+// one of the trusted proxy networks, otherwise the peer address is used.
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+	header  string
+}
+
+// NewClientIPResolver creates a resolver trusting the given CIDRs (a bare
+// IP address is treated as a single-host network) and reading the client
+// address from the given header. Empty header defaults to X-Forwarded-For.
+func NewClientIPResolver(cidrs []string, header string) (*ClientIPResolver, error) {
+	res := new(ClientIPResolver)
+	switch http.CanonicalHeaderKey(header) {
+	case "", HEADER_X_FORWARDED_FOR:
+		res.header = HEADER_X_FORWARDED_FOR
+	case http.CanonicalHeaderKey(HEADER_X_REAL_IP):
+		res.header = HEADER_X_REAL_IP
+	case HEADER_FORWARDED:
+		res.header = HEADER_FORWARDED
+	default:
+		return nil, fmt.Errorf("unsupported client IP header %q, expected one of %s, %s, %s",
+			header, HEADER_X_FORWARDED_FOR, HEADER_X_REAL_IP, HEADER_FORWARDED)
+	}
+	trusted, err := parseCIDRs(cidrs, "trusted proxy")
+	if err != nil {
+		return nil, err
+	}
+	res.trusted = trusted
+	return res, nil
+}
+
+// Parse CIDRs, a bare IP address is treated as a single-host network.
+// Kind of the networks is used in error messages.
+func parseCIDRs(cidrs []string, kind string) ([]*net.IPNet, error) {
+	var res []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s address %q", kind, cidr)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s CIDR %q: %w", kind, cidr, err)
+		}
+		res = append(res, ipNet)
+	}
+	return res, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (res *ClientIPResolver) isTrusted(ip net.IP) bool {
+	return containsIP(res.trusted, ip)
+}
+
+// Peers connected to a Unix domain socket have no address, they are
+// local proxies (e.g. a sidecar) allowed by permissions of the socket
+func isUnixSocketPeer(remoteAddr string) bool {
+	return remoteAddr == "" || remoteAddr == "@"
+}
+
+// ClientIP returns the address to be recorded for the request. It is the
+// peer address (`ip:port`) unless the peer is a trusted proxy, in which case
+// the right-most untrusted address from the configured header is returned.
+// Peers connected to a Unix domain socket are trusted proxies.
+func (res *ClientIPResolver) ClientIP(r *http.Request) string {
+	if !isUnixSocketPeer(r.RemoteAddr) {
+		peerHost, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			peerHost = r.RemoteAddr
+		}
+		peerIP := net.ParseIP(peerHost)
+		if peerIP == nil || !res.isTrusted(peerIP) {
+			return r.RemoteAddr
+		}
+	}
+
+	var chain []string
+	switch res.header {
+	case HEADER_X_FORWARDED_FOR:
+		for _, value := range r.Header.Values(HEADER_X_FORWARDED_FOR) {
+			for _, addr := range strings.Split(value, ",") {
+				chain = append(chain, strings.TrimSpace(addr))
+			}
+		}
+	case HEADER_X_REAL_IP:
+		if value := strings.TrimSpace(r.Header.Get(HEADER_X_REAL_IP)); value != "" {
+			chain = append(chain, value)
+		}
+	case HEADER_FORWARDED:
+		chain = parseForwardedFor(r.Header.Values(HEADER_FORWARDED))
+	}
+
+	// Walk from the closest hop backwards, skipping our own proxies
+	clientIP := ""
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseHeaderIP(chain[i])
+		if ip == nil {
+			// Malformed or obfuscated hop: nothing before it can be trusted
+			break
+		}
+		clientIP = ip.String()
+		if !res.isTrusted(ip) {
+			break
+		}
+	}
+	if clientIP == "" {
+		return r.RemoteAddr
+	}
+	return clientIP
+}
+
+// Extract `for=` parameters of RFC 7239 Forwarded header values in order.
+func parseForwardedFor(values []string) []string {
+	var res []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					res = append(res, strings.Trim(val, "\""))
+				}
+			}
+		}
+	}
+	return res
+}
+
+// Parse an address as found in proxy headers, which may contain
+// a port and, for IPv6, square brackets.
+func parseHeaderIP(s string) net.IP {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
+
+// Host part of an address which may or may not include a port
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
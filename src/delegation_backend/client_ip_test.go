@@ -0,0 +1,68 @@
+package delegation_backend
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func testClientIP(t *testing.T, res *ClientIPResolver, remoteAddr string, headers map[string]string, expected string) {
+	req := httptest.NewRequest("POST", v1Submit, nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if actual := res.ClientIP(req); actual != expected {
+		t.Errorf("remote_addr=%s headers=%v: expected %s, got %s", remoteAddr, headers, expected, actual)
+	}
+}
+
+func TestClientIPUntrustedPeer(t *testing.T) {
+	res, err := NewClientIPResolver(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testClientIP(t, res, "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "192.0.2.1:1234")
+}
+
+func TestClientIPXForwardedFor(t *testing.T) {
+	res, err := NewClientIPResolver([]string{"10.0.0.0/8", "192.0.2.1"}, "X-Forwarded-For")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testClientIP(t, res, "10.1.2.3:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7")
+	// Spoofed left-most entry is ignored, right-most untrusted wins
+	testClientIP(t, res, "10.1.2.3:1234", map[string]string{"X-Forwarded-For": "1.1.1.1, 203.0.113.7, 192.0.2.1"}, "203.0.113.7")
+	// No header from a trusted proxy
+	testClientIP(t, res, "10.1.2.3:1234", nil, "10.1.2.3:1234")
+	// Garbage in the header
+	testClientIP(t, res, "10.1.2.3:1234", map[string]string{"X-Forwarded-For": "not-an-ip"}, "10.1.2.3:1234")
+	// Untrusted peer sending the header
+	testClientIP(t, res, "198.51.100.2:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "198.51.100.2:1234")
+}
+
+func TestClientIPXRealIP(t *testing.T) {
+	res, err := NewClientIPResolver([]string{"10.0.0.0/8"}, "x-real-ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testClientIP(t, res, "10.1.2.3:1234", map[string]string{"X-Real-IP": "203.0.113.7", "X-Forwarded-For": "1.1.1.1"}, "203.0.113.7")
+}
+
+func TestClientIPForwarded(t *testing.T) {
+	res, err := NewClientIPResolver([]string{"10.0.0.0/8"}, "Forwarded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testClientIP(t, res, "10.1.2.3:1234", map[string]string{"Forwarded": `for=192.0.2.60;proto=http;by=203.0.113.43`}, "192.0.2.60")
+	testClientIP(t, res, "10.1.2.3:1234", map[string]string{"Forwarded": `for=1.1.1.1, for="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17")
+	testClientIP(t, res, "10.1.2.3:1234", map[string]string{"Forwarded": `for=unknown`}, "10.1.2.3:1234")
+}
+
+func TestClientIPInvalidConfig(t *testing.T) {
+	if _, err := NewClientIPResolver([]string{"10.0.0.0/33"}, ""); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+	if _, err := NewClientIPResolver(nil, "X-Client-IP"); err == nil {
+		t.Error("expected error for unsupported header")
+	}
+}
@@ -0,0 +1,293 @@
+package delegation_backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/beevik/ntp"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+const (
+	CLOCK_CHECK_DEFAULT_NTP_SERVER = "pool.ntp.org"
+	CLOCK_CHECK_DEFAULT_MAX_SKEW   = time.Second
+	CLOCK_CHECK_DEFAULT_INTERVAL   = time.Hour
+	CLOCK_CHECK_TIMEOUT            = 5 * time.Second
+)
+
+// Actions of the clock guard on submissions while the clock is suspect
+const (
+	CLOCK_GUARD_FLAG   = "flag"
+	CLOCK_GUARD_REJECT = "reject"
+)
+
+const (
+	CLOCK_GUARD_DEFAULT_MAX_JUMP = 2 * time.Second
+	CLOCK_GUARD_DEFAULT_SETTLE   = 10 * time.Minute
+)
+
+const CLOCK_UNRELIABLE_CODE = "clock_unreliable"
+
+// MaxFuture tolerated of created_at of submissions of the network
+func (configs ClockSkewConfigs) MaxFuture(networkName string) time.Duration {
+	if config := configs[networkName]; config != nil && config.MaxFutureSeconds != nil {
+		return time.Duration(*config.MaxFutureSeconds) * time.Second
+	}
+	return -TIME_DIFF_DELTA
+}
+
+// Skew of the clock above which a warning is logged
+func (cfg *ClockCheckConfig) MaxSkew() time.Duration {
+	if cfg.MaxSkewMs > 0 {
+		return time.Duration(cfg.MaxSkewMs) * time.Millisecond
+	}
+	return CLOCK_CHECK_DEFAULT_MAX_SKEW
+}
+
+func (cfg *ClockCheckConfig) Interval() time.Duration {
+	if cfg.IntervalMinutes > 0 {
+		return time.Duration(cfg.IntervalMinutes) * time.Minute
+	}
+	return CLOCK_CHECK_DEFAULT_INTERVAL
+}
+
+// Jump of the clock above which the clock is suspect
+func (cfg *ClockGuardConfig) MaxJump() time.Duration {
+	if cfg.MaxJumpMs > 0 {
+		return time.Duration(cfg.MaxJumpMs) * time.Millisecond
+	}
+	return CLOCK_GUARD_DEFAULT_MAX_JUMP
+}
+
+func (cfg *ClockGuardConfig) Settle() time.Duration {
+	if cfg.SettleMinutes > 0 {
+		return time.Duration(cfg.SettleMinutes) * time.Minute
+	}
+	return CLOCK_GUARD_DEFAULT_SETTLE
+}
+
+// Skew of the local clock, ahead of the clock of the NTP server if
+// positive. Responses unsuitable for synchronization, e.g. of an
+// unsynchronized server or a kiss-o'-death, are errors.
+func ntpSkew(ctx context.Context, server string) (time.Duration, error) {
+	options := ntp.QueryOptions{
+		// Dialed with the context, which bounds the query
+		Dialer: func(_, remoteAddress string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "udp", remoteAddress)
+		},
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		options.Timeout = time.Until(deadline)
+	}
+	resp, err := ntp.QueryWithOptions(server, options)
+	if err != nil {
+		return 0, err
+	}
+	if err := resp.Validate(); err != nil {
+		return 0, err
+	}
+	// Offset of the server to the local clock, the opposite of the skew
+	return -resp.ClockOffset, nil
+}
+
+// ClockCheck compares the clock of the host to an NTP server, as
+// created_at of submissions is checked against it: a skewed clock rejects
+// submissions of submitters with accurate clocks as made in the future
+type ClockCheck struct {
+	Server  string
+	MaxSkew time.Duration
+	mutex   sync.Mutex
+	skew    time.Duration
+	// Time of the latest successful check, zero until then
+	checkedAt time.Time
+	now       nowFunc
+	log       logging.StandardLogger
+}
+
+func NewClockCheck(config *ClockCheckConfig, now nowFunc, log logging.StandardLogger) *ClockCheck {
+	server := config.NTPServer
+	if server == "" {
+		server = CLOCK_CHECK_DEFAULT_NTP_SERVER
+	}
+	return &ClockCheck{Server: server, MaxSkew: config.MaxSkew(), now: now, log: log}
+}
+
+// Check the skew of the clock, warning if it exceeds the max skew
+func (c *ClockCheck) Check(ctx context.Context) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, CLOCK_CHECK_TIMEOUT)
+	defer cancel()
+	skew, err := ntpSkew(ctx, c.Server)
+	if err != nil {
+		incMetric("clock_check_errors")
+		return 0, fmt.Errorf("error querying %s: %w", c.Server, err)
+	}
+	c.mutex.Lock()
+	c.skew = skew
+	c.checkedAt = c.now()
+	c.mutex.Unlock()
+	if skew > c.MaxSkew || skew < -c.MaxSkew {
+		c.log.Warnf("Clock of the host is skewed by %v from %s, created_at of submissions is checked against it", skew, c.Server)
+	} else {
+		c.log.Debugf("Clock of the host is skewed by %v from %s", skew, c.Server)
+	}
+	return skew, nil
+}
+
+// Check every interval, the first check being made at start
+func (c *ClockCheck) CheckLoop(ctx context.Context, interval time.Duration) {
+	for {
+		if _, err := c.Check(ctx); err != nil {
+			c.log.Warnf("Failed to check the clock of the host: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Skew of the clock as of the latest check, and its time, zero until a
+// check succeeded
+func (c *ClockCheck) Latest() (time.Duration, time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.skew, c.checkedAt
+}
+
+// WritePrometheus writes the skew of the clock as of the latest check,
+// nothing until a check succeeded
+func (c *ClockCheck) WritePrometheus(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.checkedAt.IsZero() {
+		return
+	}
+	fmt.Fprint(w, "# HELP uptime_clock_skew_seconds Skew of the clock of the host from the NTP server, ahead if positive.\n")
+	fmt.Fprint(w, "# TYPE uptime_clock_skew_seconds gauge\n")
+	fmt.Fprintf(w, "uptime_clock_skew_seconds %g\n", c.skew.Seconds())
+	fmt.Fprint(w, "# HELP uptime_clock_check_timestamp_seconds Time of the latest check of the clock.\n")
+	fmt.Fprint(w, "# TYPE uptime_clock_check_timestamp_seconds gauge\n")
+	fmt.Fprintf(w, "uptime_clock_check_timestamp_seconds %d\n", c.checkedAt.Unix())
+}
+
+// ClockGuard watches the wall clock of the host for jumps: between two
+// readings the wall clock should advance as much as the monotonic clock,
+// which steps of the host clock don't affect. Once the clock jumped it is
+// suspect, as created_at of submissions can't be checked against it, until
+// a check against the NTP server finds it accurate or the settle period
+// is over.
+type ClockGuard struct {
+	MaxJump time.Duration
+	Settle  time.Duration
+	// flag or reject submissions while the clock is suspect
+	Action string
+	// Optional, checked once the clock jumped
+	Check *ClockCheck
+	wall  nowFunc
+	// Time elapsed since start, by the monotonic clock
+	elapsed func() time.Duration
+	mutex   sync.Mutex
+	// Latest reading of both clocks
+	lastWall    time.Time
+	lastElapsed time.Duration
+	// Latest jump, ahead if positive, and the readings it was detected at
+	jump         time.Duration
+	jumpedAt     time.Duration
+	jumpedAtWall time.Time
+	suspect      bool
+	log          logging.StandardLogger
+}
+
+func NewClockGuard(config *ClockGuardConfig, wall nowFunc, check *ClockCheck, log logging.StandardLogger) (*ClockGuard, error) {
+	g := &ClockGuard{MaxJump: config.MaxJump(), Settle: config.Settle(), Action: config.Action, Check: check, wall: wall, log: log}
+	switch g.Action {
+	case "":
+		g.Action = CLOCK_GUARD_FLAG
+	case CLOCK_GUARD_FLAG, CLOCK_GUARD_REJECT:
+	default:
+		return nil, fmt.Errorf("unknown action %q, expected %s or %s", config.Action, CLOCK_GUARD_FLAG, CLOCK_GUARD_REJECT)
+	}
+	start := time.Now()
+	g.elapsed = func() time.Duration { return time.Since(start) }
+	return g, nil
+}
+
+// Now is the wall clock, checked against the monotonic clock
+func (g *ClockGuard) Now() time.Time {
+	now := g.wall()
+	// Without its monotonic reading, so that differences are of wall time
+	g.observe(now.Round(0), g.elapsed())
+	return now
+}
+
+func (g *ClockGuard) observe(wall time.Time, elapsed time.Duration) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if !g.lastWall.IsZero() {
+		jump := wall.Sub(g.lastWall) - (elapsed - g.lastElapsed)
+		if jump > g.MaxJump || jump < -g.MaxJump {
+			incMetric("clock_jumps")
+			g.log.Errorf("Clock of the host jumped by %v, created_at of submissions isn't checked against it for up to %v", jump, g.Settle)
+			g.jump, g.jumpedAt, g.jumpedAtWall, g.suspect = jump, elapsed, wall, true
+			if g.Check != nil {
+				go func() {
+					if _, err := g.Check.Check(context.Background()); err != nil {
+						g.log.Warnf("Failed to check the clock of the host once it jumped: %v", err)
+					}
+				}()
+			}
+		}
+	}
+	g.lastWall, g.lastElapsed = wall, elapsed
+}
+
+// Suspect tells whether the clock jumped and is yet to be trusted again,
+// and for how long it stays suspect unless the NTP server finds it
+// accurate before
+func (g *ClockGuard) Suspect() (bool, time.Duration) {
+	elapsed := g.elapsed()
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if !g.suspect {
+		return false, 0
+	}
+	left := g.Settle - (elapsed - g.jumpedAt)
+	if left <= 0 {
+		g.log.Infof("Clock of the host is trusted again, %v after it jumped", g.Settle)
+		g.suspect = false
+		return false, 0
+	}
+	if g.Check != nil {
+		skew, checkedAt := g.Check.Latest()
+		if !checkedAt.Before(g.jumpedAtWall) && skew <= g.Check.MaxSkew && skew >= -g.Check.MaxSkew {
+			g.log.Infof("Clock of the host is trusted again, skewed by %v from %s", skew, g.Check.Server)
+			g.suspect = false
+			return false, 0
+		}
+	}
+	return true, left
+}
+
+// WritePrometheus writes whether the clock is suspect and its latest jump
+func (g *ClockGuard) WritePrometheus(w io.Writer) {
+	suspect, _ := g.Suspect()
+	g.mutex.Lock()
+	jump := g.jump
+	g.mutex.Unlock()
+	fmt.Fprint(w, "# HELP uptime_clock_suspect Whether the clock of the host jumped and is yet to be trusted again.\n")
+	fmt.Fprint(w, "# TYPE uptime_clock_suspect gauge\n")
+	if suspect {
+		fmt.Fprint(w, "uptime_clock_suspect 1\n")
+	} else {
+		fmt.Fprint(w, "uptime_clock_suspect 0\n")
+	}
+	fmt.Fprint(w, "# HELP uptime_clock_last_jump_seconds Latest jump of the clock of the host, ahead if positive.\n")
+	fmt.Fprint(w, "# TYPE uptime_clock_last_jump_seconds gauge\n")
+	fmt.Fprintf(w, "uptime_clock_last_jump_seconds %g\n", jump.Seconds())
+}
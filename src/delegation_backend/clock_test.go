@@ -0,0 +1,199 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Seconds from the NTP epoch (1900) to the Unix epoch
+const ntpEpochOffset = 2208988800
+
+// Size of SNTP packets without extensions
+const ntpPacketSize = 48
+
+// NTP timestamp of the time
+func putNTPTime(bs []byte, t time.Time) {
+	binary.BigEndian.PutUint32(bs[:4], uint32(t.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(bs[4:8], uint32((int64(t.Nanosecond())<<32)/int64(time.Second)))
+}
+
+// Serve SNTP requests with the time of the clock ahead by offset
+func testNTPServer(t *testing.T, offset time.Duration) string {
+	return testNTPServerOfStratum(t, offset, 1)
+}
+
+// Stratum 0 responses are kiss-o'-death packets
+func testNTPServerOfStratum(t *testing.T, offset time.Duration, stratum byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		req := make([]byte, ntpPacketSize)
+		for {
+			_, addr, err := conn.ReadFrom(req)
+			if err != nil {
+				return
+			}
+			resp := make([]byte, ntpPacketSize)
+			// Version 4, server mode
+			resp[0], resp[1] = 4<<3|4, stratum
+			now := time.Now().Add(offset)
+			// Transmit time of the request as the origin time
+			copy(resp[24:32], req[40:48])
+			putNTPTime(resp[16:24], now)
+			putNTPTime(resp[32:40], now)
+			putNTPTime(resp[40:48], now)
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestClockCheck(t *testing.T) {
+	server := testNTPServer(t, -10*time.Second)
+	check := NewClockCheck(&ClockCheckConfig{NTPServer: server}, time.Now, logging.Logger("test"))
+	var metrics strings.Builder
+	check.WritePrometheus(&metrics)
+	if metrics.Len() != 0 {
+		t.Fatalf("expected no metrics before the first check, got:\n%s", metrics.String())
+	}
+	skew, err := check.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skew < 9*time.Second || skew > 11*time.Second {
+		t.Fatalf("expected the clock 10s ahead, got %v", skew)
+	}
+	check.WritePrometheus(&metrics)
+	if !strings.Contains(metrics.String(), "uptime_clock_skew_seconds ") || !strings.Contains(metrics.String(), "uptime_clock_check_timestamp_seconds ") {
+		t.Fatalf("unexpected metrics:\n%s", metrics.String())
+	}
+
+	check = NewClockCheck(&ClockCheckConfig{NTPServer: testNTPServerOfStratum(t, 0, 0)}, time.Now, logging.Logger("test"))
+	if _, err := check.Check(context.Background()); err == nil {
+		t.Fatal("expected a kiss-o'-death response to fail the check")
+	}
+
+	silent, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	defer silent.Close()
+	check = NewClockCheck(&ClockCheckConfig{NTPServer: silent.LocalAddr().String()}, time.Now, logging.Logger("test"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := check.Check(ctx); err == nil {
+		t.Fatal("expected a check against a silent server to fail")
+	}
+}
+
+func TestClockSkewMaxFuture(t *testing.T) {
+	seconds := 30
+	configs := ClockSkewConfigs{"devnet": {MaxFutureSeconds: &seconds}}
+	if configs.MaxFuture("devnet") != 30*time.Second || configs.MaxFuture("mainnet") != 5*time.Minute {
+		t.Fatalf("unexpected max future %v, %v", configs.MaxFuture("devnet"), configs.MaxFuture("mainnet"))
+	}
+
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	_, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	tm.time = req.Data.CreatedAt.Add(-time.Minute)
+	sh.app.CreatedAtMaxFuture = configs.MaxFuture("devnet")
+	if rep := sh.testRequest(body); rep.Code != 400 {
+		t.Fatalf("expected created_at a minute ahead rejected, got %d", rep.Code)
+	}
+	sh.app.CreatedAtMaxFuture = configs.MaxFuture("mainnet")
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected created_at a minute ahead accepted, got %d: %s", rep.Code, rep.Body)
+	}
+}
+
+func TestClockGuard(t *testing.T) {
+	wall := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var elapsed time.Duration
+	guard, err := NewClockGuard(&ClockGuardConfig{}, func() time.Time { return wall }, nil, logging.Logger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard.elapsed = func() time.Duration { return elapsed }
+	advance := func(wallBy, elapsedBy time.Duration) {
+		wall, elapsed = wall.Add(wallBy), elapsed+elapsedBy
+		guard.Now()
+	}
+	advance(0, 0)
+	// Slewing of the clock is tolerated
+	advance(time.Minute+time.Second, time.Minute)
+	if suspect, _ := guard.Suspect(); suspect {
+		t.Fatal("expected the clock trusted")
+	}
+	// The host clock is stepped back by an hour
+	advance(-time.Hour, time.Second)
+	if suspect, left := guard.Suspect(); !suspect || left != CLOCK_GUARD_DEFAULT_SETTLE {
+		t.Fatalf("expected the clock suspect for %v, got %v %v", CLOCK_GUARD_DEFAULT_SETTLE, suspect, left)
+	}
+	var metrics strings.Builder
+	guard.WritePrometheus(&metrics)
+	if !strings.Contains(metrics.String(), "uptime_clock_suspect 1\n") || !strings.Contains(metrics.String(), "uptime_clock_last_jump_seconds -3601\n") {
+		t.Fatalf("unexpected metrics:\n%s", metrics.String())
+	}
+	advance(CLOCK_GUARD_DEFAULT_SETTLE, CLOCK_GUARD_DEFAULT_SETTLE)
+	if suspect, _ := guard.Suspect(); suspect {
+		t.Fatal("expected the clock trusted once settled")
+	}
+
+	// A check against the NTP server after the jump trusts the clock again
+	guard.Check = NewClockCheck(&ClockCheckConfig{NTPServer: testNTPServer(t, 0)}, time.Now, logging.Logger("test"))
+	wall = time.Now()
+	advance(-time.Hour, 0)
+	if _, err := guard.Check.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if suspect, _ := guard.Suspect(); suspect {
+		t.Fatal("expected the clock trusted once checked")
+	}
+
+	if _, err := NewClockGuard(&ClockGuardConfig{Action: "ignore"}, time.Now, nil, nil); err == nil {
+		t.Fatal("expected an unknown action rejected")
+	}
+}
+
+func TestSubmitClockGuard(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	storage, sh, tm := testSubmitH(10, Whitelist{req.Submitter: true})
+	var elapsed time.Duration
+	guard, _ := NewClockGuard(&ClockGuardConfig{Action: CLOCK_GUARD_REJECT}, tm.Now, nil, logging.Logger("test"))
+	guard.elapsed = func() time.Duration { return elapsed }
+	sh.app.ClockGuard, sh.app.Now = guard, guard.Now
+	guard.Now()
+	// The clock is stepped back, created_at is an hour ahead of it
+	tm.time = req.Data.CreatedAt.Add(-time.Hour)
+
+	rep := sh.testRequest(body)
+	var resp submitErrorResponse
+	json.Unmarshal(rep.Body.Bytes(), &resp)
+	if rep.Code != 503 || resp.Code != CLOCK_UNRELIABLE_CODE || rep.Header().Get("Retry-After") != "601" {
+		t.Fatalf("expected the submission rejected, got %d %v %s", rep.Code, rep.Header(), rep.Body.String())
+	}
+
+	guard.Action = CLOCK_GUARD_FLAG
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected the submission accepted, got %d %s", rep.Code, rep.Body.String())
+	}
+	var meta MetaToBeSaved
+	if err := json.Unmarshal((*storage)[makePaths(tm.Now(), req.GetBlockDataHash(), req.Submitter).Meta], &meta); err != nil || !meta.ClockSuspect {
+		t.Fatalf("expected the submission flagged, got %+v (%v)", meta, err)
+	}
+}
@@ -0,0 +1,261 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/nats-io/nats.go"
+	"google.golang.org/api/option"
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Time allowed to every probe of a backend
+const CONFIG_CHECK_TIMEOUT = 30 * time.Second
+
+// ConfigCheck is the outcome of a check of the configuration,
+// Skipped is set for checks not run as an earlier one failed
+type ConfigCheck struct {
+	Name    string
+	Err     error
+	Detail  string
+	Skipped bool
+}
+
+type configChecker struct {
+	checks []ConfigCheck
+}
+
+func (c *configChecker) run(name string, check func() (string, error)) bool {
+	detail, err := check()
+	c.checks = append(c.checks, ConfigCheck{Name: name, Err: err, Detail: detail})
+	return err == nil
+}
+
+func (c *configChecker) skip(name string) {
+	c.checks = append(c.checks, ConfigCheck{Name: name, Skipped: true})
+}
+
+// CheckConfig loads and validates the configuration as on start, resolves
+// its secrets and probes connectivity of the storage backends and of the
+// whitelist spreadsheet, without starting the server.
+func CheckConfig(ctx context.Context, configFile string, log *logging.ZapEventLogger) []ConfigCheck {
+	c := &configChecker{}
+	var cfg AppConfig
+	configFile = configFilePath(configFile)
+	if !c.run("configuration", func() (string, error) {
+		var err error
+		cfg, err = loadConfig(configFile, log)
+		if configFile == "" {
+			return "loaded from environment variables", err
+		}
+		return "loaded from " + configFile, err
+	}) {
+		return c.checks
+	}
+	var resolver *SecretResolver
+	if !c.run("secrets", func() (string, error) {
+		var err error
+		if resolver, err = NewSecretResolver(ctx, cfg.Secrets); err != nil {
+			return "", err
+		}
+		if err := ResolveSecrets(&cfg, resolver); err != nil {
+			return "", err
+		}
+		if _, err := NewSecret(resolver, cfg.AdminToken); err != nil {
+			return "", fmt.Errorf("error resolving admin token: %w", err)
+		}
+		return "", nil
+	}) {
+		for _, name := range backendCheckNames(cfg) {
+			c.skip(name)
+		}
+		return c.checks
+	}
+
+	if cfg.Aws != nil {
+		c.run("storage s3", func() (string, error) { return checkS3(ctx, cfg) })
+	}
+	if cfg.AwsKeyspaces != nil {
+		c.run("storage keyspaces", func() (string, error) { return checkKeyspaces(cfg.AwsKeyspaces) })
+	}
+	if cfg.PostgreSQL != nil {
+		c.run("storage postgresql", func() (string, error) { return checkPostgreSQL(cfg.PostgreSQL, resolver) })
+	}
+	if cfg.LocalFileSystem != nil {
+		c.run("storage filesystem", func() (string, error) { return checkFileSystem(cfg.LocalFileSystem.Path) })
+	}
+	if cfg.NATS != nil {
+		c.run("nats", func() (string, error) { return checkNATS(cfg.NATS, cfg.NetworkName) })
+	}
+	if !cfg.DelegationWhitelistDisabled {
+		c.run("whitelist", func() (string, error) { return checkWhitelist(ctx, cfg, log) })
+	}
+	if cfg.TLS != nil {
+		c.run("tls", func() (string, error) {
+			// Certificate files are loaded only once
+			tlsCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			if _, err := NewServerTLSConfig(tlsCtx, cfg.TLS, log); err != nil {
+				return "", err
+			}
+			if cfg.TLS.ClientCAFile != "" {
+				if _, err := LoadClientCertAuth(cfg.TLS.ClientCertMap); err != nil {
+					return "", err
+				}
+			}
+			return "", nil
+		})
+	}
+	if cfg.Receipts != nil {
+		c.run("receipts", func() (string, error) {
+			_, err := LoadReceiptSigningKey(cfg.Receipts.SigningKeyFile)
+			return "", err
+		})
+	}
+	return c.checks
+}
+
+// Names of the checks of backends, skipped when secrets can't be resolved
+func backendCheckNames(cfg AppConfig) []string {
+	var names []string
+	for _, backend := range []struct {
+		name       string
+		configured bool
+	}{
+		{"storage s3", cfg.Aws != nil},
+		{"storage keyspaces", cfg.AwsKeyspaces != nil},
+		{"storage postgresql", cfg.PostgreSQL != nil},
+		{"storage filesystem", cfg.LocalFileSystem != nil},
+		{"nats", cfg.NATS != nil},
+		{"whitelist", !cfg.DelegationWhitelistDisabled},
+	} {
+		if backend.configured {
+			names = append(names, backend.name)
+		}
+	}
+	return names
+}
+
+func checkS3(ctx context.Context, cfg AppConfig) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, CONFIG_CHECK_TIMEOUT)
+	defer cancel()
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.Aws.Region))
+	if err != nil {
+		return "", fmt.Errorf("error loading AWS configuration: %w", err)
+	}
+	bucket := GetAWSBucketName(cfg)
+	client := s3.NewFromConfig(awsCfg, S3OptionsFromEnv)
+	if _, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		return "", fmt.Errorf("bucket %s is not accessible: %w", bucket, err)
+	}
+	return "bucket " + bucket, nil
+}
+
+func checkKeyspaces(cfg *AwsKeyspacesConfig) (string, error) {
+	session, err := InitializeKeyspaceSession(cfg)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	return "keyspace " + cfg.Keyspace, nil
+}
+
+func checkPostgreSQL(cfg *PostgreSQLConfig, resolver *SecretResolver) (string, error) {
+	password, err := NewSecret(resolver, cfg.Password)
+	if err != nil {
+		return "", fmt.Errorf("error resolving PostgreSQL password: %w", err)
+	}
+	db, err := NewPostgreSQLWithSecret(cfg, password)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	return fmt.Sprintf("database %s at %s:%d", cfg.DBName, cfg.Host, cfg.Port), nil
+}
+
+// The directory must exist and be writable
+func checkFileSystem(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", path)
+	}
+	f, err := os.CreateTemp(path, ".config-check-*")
+	if err != nil {
+		return "", fmt.Errorf("%s is not writable: %w", path, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return "directory " + filepath.Clean(path), nil
+}
+
+// JetStream must be enabled, the stream is created on start if missing
+func checkNATS(cfg *NATSConfig, network string) (string, error) {
+	publisher, err := NewNATSPublisher(cfg, network)
+	if err != nil {
+		return "", err
+	}
+	defer publisher.Close()
+	js, err := publisher.jetStream()
+	if err != nil {
+		return "", err
+	}
+	_, err = js.StreamInfo(publisher.Stream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		return "stream " + publisher.Stream + " to be created at " + publisher.URL, nil
+	}
+	return "stream " + publisher.Stream + " at " + publisher.URL, err
+}
+
+func checkWhitelist(ctx context.Context, cfg AppConfig, log *logging.ZapEventLogger) (string, error) {
+	sheetsOptions := []option.ClientOption{option.WithScopes(sheets.SpreadsheetsReadonlyScope)}
+	if cfg.GsheetCredentials != "" {
+		sheetsOptions = append(sheetsOptions, option.WithCredentialsJSON([]byte(cfg.GsheetCredentials)))
+	}
+	service, err := sheets.NewService(ctx, sheetsOptions...)
+	if err != nil {
+		return "", fmt.Errorf("error creating Sheets service: %w", err)
+	}
+	wl, err := RetrieveWhitelist(service, log, cfg, 1)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d block producers", len(wl)), nil
+}
+
+// WriteConfigReport writes the outcome of the checks, one per line,
+// and returns whether all of them passed.
+func WriteConfigReport(w io.Writer, checks []ConfigCheck) bool {
+	ok := true
+	for _, check := range checks {
+		switch {
+		case check.Skipped:
+			ok = false
+			fmt.Fprintf(w, "[SKIP] %s\n", check.Name)
+		case check.Err != nil:
+			ok = false
+			fmt.Fprintf(w, "[FAIL] %s: %v\n", check.Name, check.Err)
+		case check.Detail != "":
+			fmt.Fprintf(w, "[ OK ] %s: %s\n", check.Name, check.Detail)
+		default:
+			fmt.Fprintf(w, "[ OK ] %s\n", check.Name)
+		}
+	}
+	if ok {
+		fmt.Fprint(w, "Configuration is valid\n")
+	} else {
+		fmt.Fprint(w, "Configuration is invalid\n")
+	}
+	return ok
+}
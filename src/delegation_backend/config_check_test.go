@@ -0,0 +1,50 @@
+package delegation_backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestCheckConfig(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	log := logging.Logger("test")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	check := func(config string) (bool, string) {
+		os.WriteFile(path, []byte(config), 0644)
+		var report strings.Builder
+		ok := WriteConfigReport(&report, CheckConfig(context.Background(), path, log))
+		return ok, report.String()
+	}
+
+	ok, report := check("network_name = \"test_network\"\ndelegation_whitelist_disabled = true\n[filesystem]\npath = \"" + dir + "\"\n")
+	if !ok || !strings.Contains(report, "[ OK ] storage filesystem: directory "+dir+"\n") {
+		t.Fatalf("expected a valid configuration, got:\n%s", report)
+	}
+
+	ok, report = check("network_name = \"test_network\"\ndelegation_whitelist_disabled = true\n[filesystem]\npath = \"" + filepath.Join(dir, "missing") + "\"\n")
+	if ok || !strings.Contains(report, "[FAIL] storage filesystem: ") {
+		t.Fatalf("expected missing directory reported, got:\n%s", report)
+	}
+
+	ok, report = check("network_name = \"test_network\"\n[daily_reports]\n[postgresql]\nhost = \"localhost\"\n")
+	for _, problem := range []string{"gsheet_id is required", "postgresql.user is required", "daily_reports (DAILY_REPORTS_ENABLED) requires aws or filesystem storage"} {
+		if ok || !strings.Contains(report, problem) {
+			t.Fatalf("expected %q reported, got:\n%s", problem, report)
+		}
+	}
+	if strings.Contains(report, "no storage backend") || strings.Contains(report, "[SKIP]") {
+		t.Fatalf("expected only the configuration checked, got:\n%s", report)
+	}
+
+	ok, report = check("network_name = \"test_network\"\ndelegation_whitelist_disabled = true\nadmin_token = \"vault://secret/uptime#token\"\n[filesystem]\npath = \"" + dir + "\"\n")
+	if ok || !strings.Contains(report, "[FAIL] secrets: ") || !strings.Contains(report, "[SKIP] storage filesystem\n") {
+		t.Fatalf("expected unresolved secret reported, got:\n%s", report)
+	}
+}
@@ -0,0 +1,104 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfigFile decodes the configuration file, as JSON, YAML (`.yaml`,
+// `.yml`) or TOML (`.toml`) by its extension. Keys of the file not known
+// to the configuration are returned, so that typos are not silently ignored.
+func LoadConfigFile(path string) (AppConfig, []string, error) {
+	return loadConfigFileOver(path, AppConfig{})
+}
+
+// Load the configuration file over the base configuration, keys set in
+// the file replace settings of the base
+func loadConfigFileOver(path string, config AppConfig) (AppConfig, []string, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return config, nil, err
+	}
+	var doc interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bs, &doc)
+	case ".toml":
+		var table map[string]interface{}
+		_, err = toml.Decode(string(bs), &table)
+		doc = table
+	default:
+		err = json.Unmarshal(bs, &doc)
+	}
+	if err != nil {
+		return config, nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	if doc == nil {
+		// An empty YAML document
+		doc = map[string]interface{}{}
+	}
+	// Files of all formats are decoded through JSON, so that
+	// the json tags of the configuration name the keys
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return config, nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(normalized))
+	if err := decoder.Decode(&config); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return config, nil, fmt.Errorf("error decoding %s: %s should be of type %s, got %s", path, typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return config, nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	var unknownKeys []string
+	collectUnknownKeys(reflect.TypeOf(config), doc, "", &unknownKeys)
+	sort.Strings(unknownKeys)
+	return config, unknownKeys, nil
+}
+
+// Collect keys of the decoded document without a field of the type
+func collectUnknownKeys(t reflect.Type, doc interface{}, prefix string, unknown *[]string) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	if items, ok := doc.([]interface{}); ok {
+		for _, item := range items {
+			collectUnknownKeys(t, item, prefix, unknown)
+		}
+		return
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return
+	}
+	// Keys are matched case-insensitively, as by encoding/json
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[strings.ToLower(name)] = t.Field(i).Type
+		}
+	}
+	for key, value := range obj {
+		fieldType, known := fields[strings.ToLower(key)]
+		if !known {
+			*unknown = append(*unknown, prefix+key)
+			continue
+		}
+		collectUnknownKeys(fieldType, value, prefix+key+".", unknown)
+	}
+}
@@ -0,0 +1,172 @@
+package delegation_backend
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Default of HTTP/2 streams per connection, as of net/http
+const HTTP2_DEFAULT_MAX_CONCURRENT_STREAMS = 250
+
+// ConnLimiter bounds the connections open to the servers sharing it, in
+// total and per client IP. Connections beyond the total limit are not
+// accepted until others close, they wait in the listen backlog instead of
+// being handled half-way. Connections beyond the per-IP limit are closed
+// right away. Per-IP limits apply to TCP peers, so behind a proxy they
+// limit connections of the proxy.
+type ConnLimiter struct {
+	// Zero disables the limit
+	MaxConns int
+	MaxPerIP int
+
+	slots chan struct{}
+	open  atomic.Int64
+	mutex sync.Mutex
+	byIP  map[string]int
+}
+
+func NewConnLimiter(maxConns int, maxPerIP int) *ConnLimiter {
+	l := &ConnLimiter{MaxConns: maxConns, MaxPerIP: maxPerIP, byIP: make(map[string]int)}
+	if maxConns > 0 {
+		l.slots = make(chan struct{}, maxConns)
+	}
+	return l
+}
+
+// Listener limits the connections accepted by inner
+func (l *ConnLimiter) Listener(inner net.Listener) net.Listener {
+	return &limitedListener{Listener: inner, limiter: l, done: make(chan struct{})}
+}
+
+func (l *ConnLimiter) acquireIP(host string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.MaxPerIP > 0 && l.byIP[host] >= l.MaxPerIP {
+		return false
+	}
+	l.byIP[host]++
+	return true
+}
+
+func (l *ConnLimiter) releaseIP(host string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.byIP[host]--; l.byIP[host] <= 0 {
+		delete(l.byIP, host)
+	}
+}
+
+func (l *ConnLimiter) releaseSlot() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+func (l *ConnLimiter) WritePrometheus(w io.Writer) {
+	l.mutex.Lock()
+	clients := len(l.byIP)
+	l.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP uptime_connections_open Connections open to the servers.\n# TYPE uptime_connections_open gauge\nuptime_connections_open %d\n", l.open.Load())
+	fmt.Fprintf(w, "# HELP uptime_connections_clients Client IPs with connections open.\n# TYPE uptime_connections_clients gauge\nuptime_connections_clients %d\n", clients)
+}
+
+type limitedListener struct {
+	net.Listener
+	limiter   *ConnLimiter
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *limitedListener) Accept() (net.Conn, error) {
+	for {
+		if l.limiter.slots != nil {
+			select {
+			case l.limiter.slots <- struct{}{}:
+			case <-l.done:
+				return nil, net.ErrClosed
+			}
+		}
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.limiter.releaseSlot()
+			return nil, err
+		}
+		host := ""
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			host = addr.IP.String()
+			if !l.limiter.acquireIP(host) {
+				incMetric("connections_rejected_per_ip")
+				conn.Close()
+				l.limiter.releaseSlot()
+				continue
+			}
+		}
+		l.limiter.open.Add(1)
+		return &limitedConn{Conn: conn, limiter: l.limiter, host: host}, nil
+	}
+}
+
+func (l *limitedListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+type limitedConn struct {
+	net.Conn
+	limiter   *ConnLimiter
+	host      string
+	closeOnce sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.host != "" {
+			c.limiter.releaseIP(c.host)
+		}
+		c.limiter.open.Add(-1)
+		c.limiter.releaseSlot()
+	})
+	return err
+}
+
+// Apply the keep-alive and HTTP/2 settings to the server, once its TLS
+// configuration (if any) is set. Cleartext HTTP/2 is served on plain
+// listeners if H2C is set, for proxies speaking HTTP/2 to the backend.
+func (cfg *HTTPServerConfig) Apply(server *http.Server, cleartext bool) error {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.IdleTimeoutSeconds > 0 {
+		server.IdleTimeout = time.Duration(cfg.IdleTimeoutSeconds) * time.Second
+	}
+	server.SetKeepAlivesEnabled(!cfg.KeepAlivesDisabled)
+	if cfg.HTTP2Disabled {
+		// A non-nil map disables HTTP/2 over TLS
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return nil
+	}
+	h2 := &http2.Server{
+		MaxConcurrentStreams: HTTP2_DEFAULT_MAX_CONCURRENT_STREAMS,
+		IdleTimeout:          server.IdleTimeout,
+	}
+	if cfg.MaxConcurrentStreams > 0 {
+		h2.MaxConcurrentStreams = uint32(cfg.MaxConcurrentStreams)
+	}
+	if cleartext {
+		if cfg.H2C {
+			server.Handler = h2c.NewHandler(server.Handler, h2)
+		}
+		return nil
+	}
+	return http2.ConfigureServer(server, h2)
+}
@@ -0,0 +1,107 @@
+package delegation_backend
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Listener of the limiter on a local port, accepting in the background
+func listenLimited(t *testing.T, limiter *ConnLimiter) (string, chan net.Conn) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := limiter.Listener(inner)
+	t.Cleanup(func() { listener.Close() })
+	accepted := make(chan net.Conn, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	return inner.Addr().String(), accepted
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestConnLimiterPerIP(t *testing.T) {
+	limiter := NewConnLimiter(0, 2)
+	addr, accepted := listenLimited(t, limiter)
+	dial(t, addr)
+	dial(t, addr)
+	first, second := <-accepted, <-accepted
+	// The third connection of the IP is closed once accepted
+	third := dial(t, addr)
+	third.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := third.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the connection beyond the limit closed")
+	}
+	select {
+	case <-accepted:
+		t.Fatal("connection beyond the limit handed to the server")
+	default:
+	}
+	first.Close()
+	first.Close()
+	dial(t, addr)
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a connection accepted once another closed")
+	}
+	second.Close()
+	if open := limiter.open.Load(); open != 1 {
+		t.Fatalf("expected one connection open, got %d", open)
+	}
+}
+
+func TestConnLimiterTotal(t *testing.T) {
+	limiter := NewConnLimiter(1, 0)
+	addr, accepted := listenLimited(t, limiter)
+	dial(t, addr)
+	first := <-accepted
+	dial(t, addr)
+	select {
+	case <-accepted:
+		t.Fatal("connection beyond the limit accepted")
+	case <-time.After(100 * time.Millisecond):
+	}
+	first.Close()
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the waiting connection accepted once another closed")
+	}
+}
+
+func TestHTTPServerConfigApply(t *testing.T) {
+	server := &http.Server{Handler: http.NotFoundHandler(), IdleTimeout: time.Minute}
+	cfg := &HTTPServerConfig{HTTP2Disabled: true, IdleTimeoutSeconds: 5}
+	if err := cfg.Apply(server, false); err != nil {
+		t.Fatal(err)
+	}
+	if server.TLSNextProto == nil || len(server.TLSNextProto) != 0 || server.IdleTimeout != 5*time.Second {
+		t.Fatal("expected HTTP/2 disabled and the idle timeout set")
+	}
+
+	server = &http.Server{Handler: http.NotFoundHandler()}
+	if err := (&HTTPServerConfig{}).Apply(server, false); err != nil {
+		t.Fatal(err)
+	}
+	if server.TLSNextProto["h2"] == nil {
+		t.Fatal("expected HTTP/2 configured over TLS")
+	}
+}
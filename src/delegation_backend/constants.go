@@ -1,65 +1,92 @@
 package delegation_backend
 
 import (
-	"os"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
-
-	logging "github.com/ipfs/go-log/v2"
 )
 
-const MAX_SUBMIT_PAYLOAD_SIZE = 50000000 // max payload size in bytes
+const MAX_SUBMIT_PAYLOAD_SIZE = 50000000 // default max payload size in bytes, see SubmitSizeConfig
 const DELEGATION_BACKEND_LISTEN_TO = ":8080"
+const DELEGATION_BACKEND_TLS_LISTEN_TO = ":8443"
+
+// Default tolerance of created_at ahead of the clock, see ClockSkewConfig
 const TIME_DIFF_DELTA time.Duration = -5 * 60 * 1000000000 // -5m
 const WHITELIST_REFRESH_INTERVAL = 10 * 60 * 1000000000    // 10m
+const REQUESTS_PER_PK_HOURLY = 120
 
 var PK_PREFIX = [...]byte{1, 1}
 var SIG_PREFIX = [...]byte{1}
 var BLOCK_HASH_PREFIX = [...]byte{1}
 var MAX_BLOCK_SIZE = 1000000 // (1MB) max block size in bytes for Cassandra, blocks larger than this size will be stored in S3 only
 
+// Network ids of signatures, selecting the signature prefix of the signer
+const (
+	NETWORK_ID_TESTNET uint8 = 0
+	NETWORK_ID_MAINNET uint8 = 1
+)
+
+// NetworkId of signatures of the network by default, the network id of
+// mainnet for mainnet and the one of testnets otherwise
 func NetworkId(networkName string) uint8 {
 	if networkName == "mainnet" {
-		return 1
+		return NETWORK_ID_MAINNET
 	}
-	return 0
+	return NETWORK_ID_TESTNET
 }
 
-func SetWhitelistRefreshInterval(log logging.StandardLogger) time.Duration {
-	var defaultValue time.Duration = time.Duration(10) * time.Minute
-	var whitelistRefreshInterval time.Duration
-
-	envVarValue, exists := os.LookupEnv("DELEGATION_WHITELIST_REFRESH_INTERVAL")
-	if exists {
-		minutes, err := strconv.Atoi(envVarValue)
+// ParseRateLimitOverrides parses per-key hourly limits in the
+// `<public key>:<limit>,...` format of REQUESTS_PER_PK_HOURLY_OVERRIDES.
+func ParseRateLimitOverrides(s string) (map[string]int, error) {
+	overrides := make(map[string]int)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pk, limit, err := parseRateLimitOverride(entry)
 		if err != nil {
-			log.Warnf("Error parsing DELEGATION_WHITELIST_REFRESH_INTERVAL, falling back to default value: %v, error: %v", defaultValue, err)
-			whitelistRefreshInterval = defaultValue
+			return nil, fmt.Errorf("malformed entry %q: %w", entry, err)
 		}
-		whitelistRefreshInterval = time.Duration(minutes) * time.Minute
-	} else {
-		whitelistRefreshInterval = defaultValue
+		overrides[pk.String()] = limit
 	}
-	log.Infof("Delegation whitelist refresh interval: %v", whitelistRefreshInterval)
-	return whitelistRefreshInterval
+	return overrides, nil
 }
 
-func SetRequestsPerPkHourly(log logging.StandardLogger) int {
-	var defaultValue = 120
-	var requestsPerPkHourly int
-	var err error
-
-	envVarValue, exists := os.LookupEnv("REQUESTS_PER_PK_HOURLY")
-	if exists {
-		requestsPerPkHourly, err = strconv.Atoi(envVarValue)
-		if err != nil {
-			log.Warnf("Error parsing REQUESTS_PER_PK_HOURLY, falling back to default value: %v, error: %v", defaultValue, err)
-			requestsPerPkHourly = defaultValue
+// PkOverrides returns the per-key hourly limits by public key
+func (cfg *RateLimitConfig) PkOverrides() (map[Pk]int, error) {
+	overrides := make(map[Pk]int)
+	if cfg == nil {
+		return overrides, nil
+	}
+	for pkStr, limit := range cfg.Overrides {
+		var pk Pk
+		if err := StringToPk(&pk, pkStr); err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", pkStr, err)
+		}
+		if limit < 0 {
+			return nil, fmt.Errorf("limit of %s can not be negative", pkStr)
 		}
-	} else {
-		requestsPerPkHourly = defaultValue
+		overrides[pk] = limit
+	}
+	return overrides, nil
+}
+
+func parseRateLimitOverride(entry string) (pk Pk, limit int, err error) {
+	pkStr, limitStr, found := strings.Cut(entry, ":")
+	if !found {
+		err = fmt.Errorf("expected <public key>:<limit>")
+		return
+	}
+	if err = StringToPk(&pk, strings.TrimSpace(pkStr)); err != nil {
+		return
+	}
+	limit, err = strconv.Atoi(strings.TrimSpace(limitStr))
+	if err == nil && limit < 0 {
+		err = fmt.Errorf("limit can not be negative")
 	}
-	return requestsPerPkHourly
+	return
 }
 
 const PK_LENGTH = 33  // one field element (32B) + 1 bit (encoded as full byte)
@@ -69,3 +96,4 @@ const SIG_LENGTH = 64 // one field element (32B) and one scalar (32B)
 const BASE58CHECK_VERSION_BLOCK_HASH byte = 0x10
 const BASE58CHECK_VERSION_PK byte = 0xCB
 const BASE58CHECK_VERSION_SIG byte = 0x9A
+const BASE58CHECK_VERSION_STATE_HASH byte = 0x10
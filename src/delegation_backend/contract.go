@@ -0,0 +1,313 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Requests per key hourly of contract runs, so that cases of a key are
+// never rate limited
+const CONTRACT_REQUESTS_PER_PK_HOURLY = 1 << 20
+
+// ContractCase is a recorded submission replayed through the submit
+// handler, along with the outcome expected of it. Cases of the corpus
+// guard parsing, hashing and signature verification across refactors.
+type ContractCase struct {
+	// Name of the file of the case, or path of the stored submission
+	Name string `json:"-"`
+	// What the case covers
+	Description string `json:"description,omitempty"`
+	// Endpoint the request is submitted to [default: /v1/submit]
+	Path string `json:"path,omitempty"`
+	// Network the submission is signed for [default: mainnet]
+	Network string `json:"network,omitempty"`
+	// Name of the signature scheme [default: mina_schnorr]
+	SignatureScheme string `json:"signature_scheme,omitempty"`
+	// Time of the clock of the backend when the request is submitted
+	SubmittedAt time.Time           `json:"submitted_at"`
+	Request     json.RawMessage     `json:"request"`
+	Expect      ContractExpectation `json:"expect"`
+}
+
+// ContractExpectation is the outcome of a case, fields left empty aren't
+// compared
+type ContractExpectation struct {
+	Status int `json:"status"`
+	// Code of the rejection
+	Code string `json:"code,omitempty"`
+	// Hash of the block saved, of accepted submissions
+	BlockHash string `json:"block_hash,omitempty"`
+	// Hex-encoded hash of the sign payload of the submission saved, of
+	// accepted submissions
+	SignPayloadHash string `json:"sign_payload_hash,omitempty"`
+}
+
+// ContractResult is the outcome of a case replayed, along with how it
+// differs from the expectation of the case
+type ContractResult struct {
+	Name     string              `json:"name"`
+	Actual   ContractExpectation `json:"actual"`
+	Problems []string            `json:"problems,omitempty"`
+}
+
+func (r ContractResult) Passed() bool {
+	return len(r.Problems) == 0
+}
+
+// LoadContractCorpus reads the cases of the corpus, the JSON files of the
+// directory, in order of name
+func LoadContractCorpus(dir string) ([]ContractCase, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	cases := make([]ContractCase, 0, len(paths))
+	for _, path := range paths {
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var c ContractCase
+		if err := json.Unmarshal(bs, &c); err != nil {
+			return nil, fmt.Errorf("error decoding contract case %s: %w", path, err)
+		}
+		c.Name = strings.TrimSuffix(filepath.Base(path), ".json")
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
+
+// WriteContractCase writes the case to the corpus, e.g. once its
+// expectation is recorded
+func WriteContractCase(dir string, c ContractCase) error {
+	bs, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, c.Name+".json"), append(bs, '\n'), 0644)
+}
+
+// ContractCasesFromSource makes cases of the submissions stored by the
+// source, e.g. of a snapshot of a storage, which are expected to be
+// accepted again with the block they were saved with. Submissions saved
+// without their signature or by a delegate, whose delegation token isn't
+// stored, are skipped.
+func ContractCasesFromSource(ctx context.Context, source StorageSource, filter ReplayFilter, network string) ([]ContractCase, error) {
+	days, err := source.Days(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading submissions: %w", err)
+	}
+	var cases []ContractCase
+	for _, day := range days {
+		if !filter.includes(day) {
+			continue
+		}
+		paths, err := source.Submissions(ctx, day)
+		if err != nil {
+			return nil, fmt.Errorf("error reading submissions of %s: %w", day, err)
+		}
+		for _, metaPath := range paths {
+			if !filter.includesSubmission(metaPath) {
+				continue
+			}
+			c, err := contractCaseOfStored(ctx, source, metaPath, network)
+			if err != nil {
+				return nil, fmt.Errorf("error reading submission %s: %w", metaPath, err)
+			}
+			if c != nil {
+				cases = append(cases, *c)
+			}
+		}
+	}
+	return cases, nil
+}
+
+func contractCaseOfStored(ctx context.Context, source StorageSource, metaPath string, network string) (*ContractCase, error) {
+	bs, err := source.Read(ctx, metaPath)
+	if err != nil {
+		return nil, err
+	}
+	var meta MetaToBeSaved
+	if err := json.Unmarshal(bs, &meta); err != nil {
+		return nil, fmt.Errorf("error unmarshaling submission JSON: %w", err)
+	}
+	if meta.Signature == nil || meta.Delegate != "" || meta.BlockHash == "" {
+		return nil, nil
+	}
+	block, err := source.Read(ctx, BLOCKS_PREFIX+meta.BlockHash+".dat")
+	if err != nil {
+		return nil, err
+	}
+	submittedAt, err := SubmittedAtOfPath(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, err := time.Parse(time.RFC3339, meta.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	blockJson, err := json.Marshal(base64.StdEncoding.EncodeToString(block))
+	if err != nil {
+		return nil, err
+	}
+	req := submitRequest{
+		Submitter: meta.Submitter,
+		Sig:       *meta.Signature,
+		Data: submitRequestData{
+			PeerId:             meta.PeerId,
+			Block:              &Base64{data: block, json: blockJson},
+			SnarkWork:          meta.SnarkWork,
+			CreatedAt:          createdAt,
+			GraphqlControlPort: meta.GraphqlControlPort,
+			BuiltWithCommitSha: meta.BuiltWithCommitSha,
+		},
+		PayloadVersion: meta.PayloadVersion,
+		StateHash:      meta.StateHash,
+		Telemetry:      meta.Telemetry,
+	}
+	// The global slot is recorded by the backend, it was sent along with
+	// the state hash only
+	if meta.StateHash != "" {
+		req.GlobalSlot = meta.GlobalSlot
+	}
+	path := "/v1/submit"
+	if meta.Telemetry != nil {
+		path = "/v2/submit"
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return &ContractCase{
+		Name:        metaPath,
+		Path:        path,
+		Network:     network,
+		SubmittedAt: submittedAt,
+		Request:     body,
+		Expect:      ContractExpectation{Status: http.StatusOK, BlockHash: meta.BlockHash},
+	}, nil
+}
+
+// Run the case through the submit handler of an app verifying signatures
+// with the scheme of the case, at the time it was submitted. The whitelist
+// is disabled, other checks apply as configured by default.
+func (c ContractCase) Run() ContractResult {
+	result := ContractResult{Name: c.Name}
+	network := c.Network
+	if network == "" {
+		network = "mainnet"
+	}
+	scheme := c.SignatureScheme
+	if scheme == "" {
+		scheme = SIGNATURE_SCHEME_MINA_SCHNORR
+	}
+	app := new(App)
+	app.Log = logging.Logger("delegation backend contract")
+	var err error
+	if app.SignatureScheme, err = NewSignatureScheme(scheme, NetworkId(network)); err != nil {
+		result.Problems = append(result.Problems, err.Error())
+		return result
+	}
+	saved := make(ObjectsToSave)
+	app.Save = func(_ context.Context, objs ObjectsToSave) StorageOutcomes {
+		// Decoded blocks are pooled once the request is handled
+		for path, value := range objs {
+			saved[path] = append([]byte(nil), value...)
+		}
+		return nil
+	}
+	app.Now = func() time.Time { return c.SubmittedAt }
+	app.SubmitCounter = NewAttemptCounter(CONTRACT_REQUESTS_PER_PK_HOURLY)
+	app.WhitelistDisabled = true
+	app.CreatedAtMaxFuture = ClockSkewConfigs(nil).MaxFuture(network)
+
+	handler := app.NewSubmitH()
+	path := c.Path
+	if path == "" {
+		path = "/v1/submit"
+	}
+	if path == "/v2/submit" {
+		handler = handler.WithSchema(SUBMIT_SCHEMA_V2)
+	}
+	rep := httptest.NewRecorder()
+	handler.ServeHTTP(rep, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(c.Request)))
+	result.Actual = c.outcome(rep, saved)
+
+	expect, actual := c.Expect, result.Actual
+	if actual.Status != expect.Status {
+		result.Problems = append(result.Problems, fmt.Sprintf("status %d, expected %d: %s", actual.Status, expect.Status, strings.TrimSpace(rep.Body.String())))
+	}
+	if expect.Code != "" && actual.Code != expect.Code {
+		result.Problems = append(result.Problems, fmt.Sprintf("code %q, expected %q", actual.Code, expect.Code))
+	}
+	if expect.BlockHash != "" && actual.BlockHash != expect.BlockHash {
+		result.Problems = append(result.Problems, fmt.Sprintf("block hash %s, expected %s", actual.BlockHash, expect.BlockHash))
+	}
+	if expect.SignPayloadHash != "" && actual.SignPayloadHash != expect.SignPayloadHash {
+		result.Problems = append(result.Problems, fmt.Sprintf("sign payload hash %s, expected %s", actual.SignPayloadHash, expect.SignPayloadHash))
+	}
+	return result
+}
+
+// Outcome of the response, along with the hashes of the submission saved
+func (c ContractCase) outcome(rep *httptest.ResponseRecorder, saved ObjectsToSave) ContractExpectation {
+	outcome := ContractExpectation{Status: rep.Code}
+	if rep.Code != http.StatusOK {
+		var resp submitErrorResponse
+		if json.Unmarshal(rep.Body.Bytes(), &resp) == nil {
+			outcome.Code = resp.Code
+		}
+		return outcome
+	}
+	for path, value := range saved {
+		if !strings.HasPrefix(path, SUBMISSIONS_PREFIX) {
+			continue
+		}
+		var meta MetaToBeSaved
+		if json.Unmarshal(value, &meta) != nil {
+			return outcome
+		}
+		block, ok := saved[BLOCKS_PREFIX+meta.BlockHash+".dat"]
+		if !ok {
+			return outcome
+		}
+		// The hash of the block saved, not the one it was saved by
+		outcome.BlockHash = BlockDataHash(block)
+		if hash, err := meta.signPayloadHash(block); err == nil {
+			outcome.SignPayloadHash = hex.EncodeToString(hash)
+		}
+	}
+	return outcome
+}
+
+// RunContract runs the cases, returning the results of all of them and
+// an error if any failed
+func RunContract(cases []ContractCase) ([]ContractResult, error) {
+	results := make([]ContractResult, 0, len(cases))
+	failed := 0
+	for _, c := range cases {
+		result := c.Run()
+		if !result.Passed() {
+			failed++
+		}
+		results = append(results, result)
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d contract cases failed", failed, len(cases))
+	}
+	return results, nil
+}
@@ -0,0 +1,108 @@
+package delegation_backend
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// Corpus of recorded submissions, CONTRACT_UPDATE=1 records the outcomes
+// of its cases as expected
+const CONTRACT_CORPUS_DIR = "../../test/contract"
+
+func TestContractCorpus(t *testing.T) {
+	cases, err := LoadContractCorpus(CONTRACT_CORPUS_DIR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatal("expected contract cases")
+	}
+	update := os.Getenv("CONTRACT_UPDATE") == "1"
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			result := c.Run()
+			if update {
+				c.Expect = result.Actual
+				if err := WriteContractCase(CONTRACT_CORPUS_DIR, c); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			for _, problem := range result.Problems {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+// Submissions of a snapshot of a storage, e.g. synced from S3, are
+// accepted again: CONTRACT_SNAPSHOT_DIR is the directory of the snapshot,
+// CONTRACT_SNAPSHOT_NETWORK the network of its submissions [default:
+// mainnet], CONTRACT_SNAPSHOT_SINCE and CONTRACT_SNAPSHOT_UNTIL select days
+func TestContractSnapshot(t *testing.T) {
+	dir := os.Getenv("CONTRACT_SNAPSHOT_DIR")
+	if dir == "" {
+		t.Skip("CONTRACT_SNAPSHOT_DIR not set")
+	}
+	network := os.Getenv("CONTRACT_SNAPSHOT_NETWORK")
+	if network == "" {
+		network = "mainnet"
+	}
+	filter := ReplayFilter{Since: os.Getenv("CONTRACT_SNAPSHOT_SINCE"), Until: os.Getenv("CONTRACT_SNAPSHOT_UNTIL")}
+	cases, err := ContractCasesFromSource(context.Background(), LocalFileSystemSource{Directory: dir}, filter, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := RunContract(cases)
+	for _, result := range results {
+		for _, problem := range result.Problems {
+			t.Errorf("%s: %s", result.Name, problem)
+		}
+	}
+	if err != nil {
+		t.Error(err)
+	}
+	t.Logf("Replayed %d submissions of %s", len(cases), dir)
+}
+
+// Submissions saved by the handler are cases of the snapshot accepted again
+func TestContractCasesFromSource(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range []struct {
+		name        string
+		submittedAt string
+	}{{"v1-with-snark", "2021-07-17T22:40:00Z"}, {"v1-graphql-port", "2023-06-12T15:16:00Z"}} {
+		cases, err := LoadContractCorpus(CONTRACT_CORPUS_DIR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body []byte
+		for _, corpusCase := range cases {
+			if corpusCase.Name == c.name {
+				body = corpusCase.Request
+			}
+		}
+		storage, sh, tm := testSubmitH(1, nil)
+		sh.app.WhitelistDisabled = true
+		tm.time, _ = time.Parse(time.RFC3339, c.submittedAt)
+		if rep := sh.testRequest(body); rep.Code != 200 {
+			t.Fatalf("expected %s accepted, got %d: %s", c.name, rep.Code, rep.Body)
+		}
+		if err := LocalFileSystemSave(*storage, dir, sh.app.Log); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases, err := ContractCasesFromSource(context.Background(), LocalFileSystemSource{Directory: dir}, ReplayFilter{Since: "2023-01-01"}, "mainnet")
+	if err != nil || len(cases) != 1 {
+		t.Fatalf("expected the submission of 2023, got %d, error: %v", len(cases), err)
+	}
+	if cases[0].Path != "/v1/submit" || cases[0].Expect.BlockHash == "" {
+		t.Fatalf("unexpected case %+v", cases[0])
+	}
+	if results, err := RunContract(cases); err != nil {
+		t.Fatalf("expected the stored submission accepted again, got %+v", results)
+	}
+}
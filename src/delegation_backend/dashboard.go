@@ -0,0 +1,282 @@
+package delegation_backend
+
+import (
+	_ "embed"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Window of submissions kept for the dashboard
+const DASHBOARD_WINDOW = time.Hour
+
+// Submissions kept for the dashboard at most, the oldest are dropped first
+const DASHBOARD_MAX_RECORDS = 20000
+
+// Recent rejections and submissions of a submitter responded
+const DASHBOARD_RECENT = 50
+
+// Page of the dashboard, which reads its data from the admin API with
+// the admin token entered in the page
+//
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// DashboardRate is the number of submissions of a minute
+type DashboardRate struct {
+	Minute   time.Time `json:"minute"`
+	Accepted int       `json:"accepted"`
+	Rejected int       `json:"rejected"`
+}
+
+// DashboardBackend is the health of a storage backend over the window
+type DashboardBackend struct {
+	Name        string     `json:"name"`
+	Saved       int        `json:"saved"`
+	Failed      int        `json:"failed"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// DashboardSubmission is a submission attempt, as recorded in audit logs
+type DashboardSubmission struct {
+	Time       time.Time `json:"time"`
+	Result     string    `json:"result"`
+	Status     int       `json:"status"`
+	Reason     string    `json:"reason,omitempty"`
+	Submitter  string    `json:"submitter,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	BlockHash  string    `json:"block_hash,omitempty"`
+}
+
+type DashboardSummary struct {
+	Network    string `json:"network"`
+	WindowMins int    `json:"window_minutes"`
+	// Nil if the whitelist is disabled or not loaded yet
+	WhitelistSize    *int                  `json:"whitelist_size"`
+	Ready            bool                  `json:"ready"`
+	Accepted         int                   `json:"accepted"`
+	Rejected         int                   `json:"rejected"`
+	Rates            []DashboardRate       `json:"rates"`
+	RejectionReasons map[string]int        `json:"rejection_reasons"`
+	RecentRejections []DashboardSubmission `json:"recent_rejections"`
+	Backends         []DashboardBackend    `json:"backends"`
+}
+
+type DashboardSubmitter struct {
+	Submitter string `json:"submitter"`
+	// Whether the submitter is whitelisted, nil if the whitelist is
+	// disabled or not loaded yet
+	Whitelisted *bool `json:"whitelisted"`
+	// Statistics over the window of submitter statistics, if configured
+	Stats    *SubmitterSummary     `json:"stats,omitempty"`
+	Recent   []DashboardSubmission `json:"recent"`
+	Outcomes []*WriteOutcome       `json:"outcomes,omitempty"`
+}
+
+// Dashboard keeps the submission attempts of the last hour, recorded
+// along with audit logs, for the admin dashboard: rates of submissions,
+// recent rejections, health of storage backends and a drill-down by
+// submitter, so that small deployments can be operated without a
+// monitoring stack.
+type Dashboard struct {
+	mutex   sync.Mutex
+	records []AuditRecord
+	App     *App
+	Network string
+}
+
+func NewDashboard(app *App, network string) *Dashboard {
+	return &Dashboard{App: app, Network: network}
+}
+
+// Record keeps the submission attempt described by the audit record
+func (d *Dashboard) Record(rec *AuditRecord) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.records = append(d.records, *rec)
+	d.prune(rec.Time)
+}
+
+// Drop records older than the window and beyond the maximum, the mutex
+// must be held
+func (d *Dashboard) prune(now time.Time) {
+	cutoff := now.Add(-DASHBOARD_WINDOW)
+	drop := 0
+	for drop < len(d.records) && (d.records[drop].Time.Before(cutoff) || len(d.records)-drop > DASHBOARD_MAX_RECORDS) {
+		drop++
+	}
+	if drop > 0 {
+		d.records = append(d.records[:0], d.records[drop:]...)
+	}
+}
+
+func dashboardSubmission(rec *AuditRecord) DashboardSubmission {
+	return DashboardSubmission{
+		Time:       rec.Time,
+		Result:     rec.Result,
+		Status:     rec.Status,
+		Reason:     rec.Reason,
+		Submitter:  rec.Submitter,
+		RemoteAddr: rec.RemoteAddr,
+		BlockHash:  rec.BlockHash,
+	}
+}
+
+func (d *Dashboard) whitelist() *Whitelist {
+	if d.App.WhitelistDisabled || d.App.Whitelist == nil {
+		return nil
+	}
+	return d.App.Whitelist.ReadWhitelist()
+}
+
+// Summary of the submissions of the window
+func (d *Dashboard) Summary() DashboardSummary {
+	now := d.App.Now()
+	summary := DashboardSummary{
+		Network:          d.Network,
+		WindowMins:       int(DASHBOARD_WINDOW / time.Minute),
+		Ready:            d.App.IsReady,
+		RejectionReasons: make(map[string]int),
+		RecentRejections: []DashboardSubmission{},
+		Backends:         []DashboardBackend{},
+	}
+	if wl := d.whitelist(); wl != nil {
+		size := len(*wl)
+		summary.WhitelistSize = &size
+	}
+
+	// A rate for every minute of the window, including those without submissions
+	start := now.Add(-DASHBOARD_WINDOW).Truncate(time.Minute).Add(time.Minute)
+	summary.Rates = make([]DashboardRate, int(DASHBOARD_WINDOW/time.Minute))
+	for i := range summary.Rates {
+		summary.Rates[i].Minute = start.Add(time.Duration(i) * time.Minute).UTC()
+	}
+	backends := make(map[string]*DashboardBackend)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.prune(now)
+	for i := len(d.records) - 1; i >= 0; i-- {
+		rec := &d.records[i]
+		var rate *DashboardRate
+		if minute := int(rec.Time.Sub(start) / time.Minute); minute >= 0 && minute < len(summary.Rates) {
+			rate = &summary.Rates[minute]
+		}
+		if rec.Result == AUDIT_RESULT_ACCEPTED {
+			summary.Accepted++
+			if rate != nil {
+				rate.Accepted++
+			}
+		} else {
+			summary.Rejected++
+			if rate != nil {
+				rate.Rejected++
+			}
+			summary.RejectionReasons[rejectionReason(rec.Reason)]++
+			if len(summary.RecentRejections) < DASHBOARD_RECENT {
+				summary.RecentRejections = append(summary.RecentRejections, dashboardSubmission(rec))
+			}
+		}
+		for name, outcome := range rec.Storage {
+			backend := backends[name]
+			if backend == nil {
+				backend = &DashboardBackend{Name: name}
+				backends[name] = backend
+			}
+			if outcome == "ok" {
+				backend.Saved++
+				continue
+			}
+			backend.Failed++
+			// Records are read from the newest
+			if backend.LastErrorAt == nil {
+				at := rec.Time
+				backend.LastError, backend.LastErrorAt = outcome, &at
+			}
+		}
+	}
+	for _, backend := range backends {
+		summary.Backends = append(summary.Backends, *backend)
+	}
+	sort.Slice(summary.Backends, func(i, j int) bool { return summary.Backends[i].Name < summary.Backends[j].Name })
+	return summary
+}
+
+// Submitter is the drill-down of a submitter: its statistics, recent
+// submission attempts and outcomes of saving its submissions
+func (d *Dashboard) Submitter(submitter string) (DashboardSubmitter, error) {
+	res := DashboardSubmitter{Submitter: submitter, Recent: []DashboardSubmission{}}
+	if wl := d.whitelist(); wl != nil {
+		var pk Pk
+		whitelisted := false
+		if err := StringToPk(&pk, submitter); err == nil {
+			_, whitelisted = (*wl)[pk]
+		}
+		res.Whitelisted = &whitelisted
+	}
+	if d.App.SubmitterStats != nil {
+		for _, summary := range d.App.SubmitterStats.Summaries() {
+			if summary.Submitter == submitter {
+				res.Stats = &summary
+				break
+			}
+		}
+	}
+	d.mutex.Lock()
+	for i := len(d.records) - 1; i >= 0 && len(res.Recent) < DASHBOARD_RECENT; i-- {
+		if d.records[i].Submitter == submitter {
+			res.Recent = append(res.Recent, dashboardSubmission(&d.records[i]))
+		}
+	}
+	d.mutex.Unlock()
+	if d.App.WriteOutcomes != nil {
+		outcomes, err := d.App.WriteOutcomes.Query(WriteOutcomeQuery{Submitter: submitter, Limit: DASHBOARD_RECENT})
+		if err != nil {
+			return res, err
+		}
+		res.Outcomes = outcomes
+	}
+	return res, nil
+}
+
+// Handler serves the data of the dashboard, guarded by the admin token:
+//
+//	GET /admin/dashboard                     summary of the last hour
+//	GET /admin/dashboard?submitter=<pk>      drill-down of a submitter
+func (d *Dashboard) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		submitter := r.URL.Query().Get("submitter")
+		if submitter == "" {
+			writeJSON(rw, http.StatusOK, d.Summary())
+			return
+		}
+		res, err := d.Submitter(submitter)
+		if err != nil {
+			d.App.Log.Errorf("Error querying write outcomes of %s: %v", submitter, err)
+			writeJSON(rw, http.StatusInternalServerError, errorResponse{"Error querying write outcomes"})
+			return
+		}
+		writeJSON(rw, http.StatusOK, res)
+	})
+}
+
+// PageHandler serves the page of the dashboard. The page holds no data,
+// it is served without the admin token, which it sends to read the data.
+func (d *Dashboard) PageHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		rw.Header().Set("X-Frame-Options", "DENY")
+		_, _ = rw.Write(dashboardHTML)
+	})
+}
@@ -0,0 +1,69 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	var req submitRequest
+	body := readTestFile("req-with-snark", t)
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	_, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	dashboard := NewDashboard(sh.app, "mainnet")
+	sh.app.Dashboard = dashboard
+	sh.app.WriteOutcomes = NewMemoryWriteOutcomeStore(10)
+
+	dashboard.Record(&AuditRecord{Time: tm.Now().Add(-2 * time.Minute), Result: AUDIT_RESULT_ACCEPTED, Storage: map[string]string{"s3": "ok", "postgresql": "error: timeout"}})
+	dashboard.Record(&AuditRecord{Time: tm.Now().Add(-time.Minute), Result: AUDIT_RESULT_REJECTED, Reason: "Invalid signature", Submitter: "B62qother", Storage: map[string]string{"s3": "ok"}})
+	// Accepted, then rejected by the rate limit
+	for i := 0; i < 2; i++ {
+		sh.testRequest(body)
+	}
+
+	summary := dashboard.Summary()
+	if summary.Accepted != 2 || summary.Rejected != 2 || *summary.WhitelistSize != 1 || summary.Network != "mainnet" {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	if len(summary.Rates) != 60 || summary.Rates[59].Accepted != 1 || summary.Rates[59].Rejected != 1 || summary.Rates[58].Rejected != 1 || summary.Rates[57].Accepted != 1 {
+		t.Fatalf("unexpected rates %+v", summary.Rates[57:])
+	}
+	if len(summary.RecentRejections) != 2 || summary.RecentRejections[0].Submitter != req.Submitter.String() || summary.RejectionReasons["Invalid signature"] != 1 {
+		t.Fatalf("unexpected rejections %+v, reasons %v", summary.RecentRejections, summary.RejectionReasons)
+	}
+	if len(summary.Backends) != 2 || summary.Backends[0].Name != "postgresql" || summary.Backends[0].Failed != 1 || summary.Backends[0].LastError != "error: timeout" || summary.Backends[1].Saved != 2 {
+		t.Fatalf("unexpected backends %+v", summary.Backends)
+	}
+
+	// Drill-down of a submitter
+	rep := httptest.NewRecorder()
+	dashboard.Handler().ServeHTTP(rep, httptest.NewRequest(http.MethodGet, "/admin/dashboard?submitter="+req.Submitter.String(), nil))
+	var submitter DashboardSubmitter
+	if err := json.Unmarshal(rep.Body.Bytes(), &submitter); err != nil {
+		t.Fatal(err)
+	}
+	if !*submitter.Whitelisted || len(submitter.Recent) != 2 || submitter.Recent[0].Result != AUDIT_RESULT_REJECTED || len(submitter.Outcomes) != 1 {
+		t.Fatalf("unexpected drill-down %s", rep.Body)
+	}
+
+	// Records older than the window are dropped
+	tm.time = tm.time.Add(DASHBOARD_WINDOW + time.Minute)
+	if summary := dashboard.Summary(); summary.Accepted != 0 || summary.Rejected != 0 {
+		t.Fatalf("expected no submission within the window, got %+v", summary)
+	}
+}
+
+func TestDashboardPage(t *testing.T) {
+	dashboard := NewDashboard(new(App), "mainnet")
+	rep := httptest.NewRecorder()
+	dashboard.PageHandler().ServeHTTP(rep, httptest.NewRequest(http.MethodGet, "/admin/ui/", nil))
+	if rep.Code != http.StatusOK || !strings.HasPrefix(rep.Header().Get("Content-Type"), "text/html") || !strings.Contains(rep.Body.String(), "Admin token") {
+		t.Fatalf("unexpected page %d: %s", rep.Code, rep.Body)
+	}
+}
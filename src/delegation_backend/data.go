@@ -1,11 +1,13 @@
 package delegation_backend
 
 import (
+	"block_producers_uptime/metadata"
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/btcsuite/btcutil/base58"
@@ -17,43 +19,49 @@ func JSONToString(b []byte) (s string, err error) {
 	err = json.Unmarshal(b, &s)
 	return
 }
+
+// StringToPk decodes a base58check-encoded public key, the error of a
+// malformed key is a *KeyEncodingError. The key isn't checked to be a
+// point of the curve, see Pk.Validate.
 func StringToPk(pk *Pk, s string) error {
 	bs, ver, err := base58.CheckDecode(s)
-	if err == nil && ver != BASE58CHECK_VERSION_PK {
-		return errors.New("unexpected base58check version for Pk")
+	if err != nil {
+		return &KeyEncodingError{KEY_KIND_PK, INVALID_KEY_BASE58CHECK, err.Error()}
 	}
-	if err == nil {
-		prefixLen := len(PK_PREFIX)
-		if len(bs) == PK_LENGTH+prefixLen {
-			if bytes.Equal(bs[:prefixLen], PK_PREFIX[:]) {
-				copy(pk[:], bs[prefixLen:])
-			} else {
-				err = errors.New("unexpected prefix of Pk")
-			}
-		} else {
-			err = fmt.Errorf("public key of an unexpected size %d", len(bs))
-		}
+	if ver != BASE58CHECK_VERSION_PK {
+		return &KeyEncodingError{KEY_KIND_PK, INVALID_KEY_VERSION, fmt.Sprintf("unexpected base58check version %#x for Pk", ver)}
 	}
-	return err
+	prefixLen := len(PK_PREFIX)
+	if len(bs) != PK_LENGTH+prefixLen {
+		return &KeyEncodingError{KEY_KIND_PK, INVALID_KEY_LENGTH, fmt.Sprintf("public key of an unexpected size %d", len(bs))}
+	}
+	if !bytes.Equal(bs[:prefixLen], PK_PREFIX[:]) {
+		return &KeyEncodingError{KEY_KIND_PK, INVALID_KEY_PREFIX, "unexpected prefix of Pk"}
+	}
+	copy(pk[:], bs[prefixLen:])
+	return nil
 }
+
+// StringToSig decodes a base58check-encoded signature, the error of a
+// malformed signature is a *KeyEncodingError. Its elements aren't checked
+// to be in their fields, see Sig.Validate.
 func StringToSig(sig *Sig, s string) error {
 	bs, ver, err := base58.CheckDecode(s)
-	if err == nil && ver != BASE58CHECK_VERSION_SIG {
-		return errors.New("unexpected base58check version for Sig")
+	if err != nil {
+		return &KeyEncodingError{KEY_KIND_SIG, INVALID_KEY_BASE58CHECK, err.Error()}
 	}
-	if err == nil {
-		prefixLen := len(SIG_PREFIX)
-		if len(bs) == SIG_LENGTH+prefixLen {
-			if bytes.Equal(bs[:prefixLen], SIG_PREFIX[:]) {
-				copy(sig[:], bs[prefixLen:])
-			} else {
-				err = errors.New("unexpected prefix of signature")
-			}
-		} else {
-			err = fmt.Errorf("signature of an unexpected size %d", len(bs))
-		}
+	if ver != BASE58CHECK_VERSION_SIG {
+		return &KeyEncodingError{KEY_KIND_SIG, INVALID_KEY_VERSION, fmt.Sprintf("unexpected base58check version %#x for Sig", ver)}
 	}
-	return err
+	prefixLen := len(SIG_PREFIX)
+	if len(bs) != SIG_LENGTH+prefixLen {
+		return &KeyEncodingError{KEY_KIND_SIG, INVALID_KEY_LENGTH, fmt.Sprintf("signature of an unexpected size %d", len(bs))}
+	}
+	if !bytes.Equal(bs[:prefixLen], SIG_PREFIX[:]) {
+		return &KeyEncodingError{KEY_KIND_SIG, INVALID_KEY_PREFIX, "unexpected prefix of signature"}
+	}
+	copy(sig[:], bs[prefixLen:])
+	return nil
 }
 
 type Sig [SIG_LENGTH]byte
@@ -66,7 +74,10 @@ func (sig *Sig) UnmarshalJSON(b []byte) error {
 	return err
 }
 func (d Sig) MarshalJSON() ([]byte, error) {
-	return json.Marshal(base58.CheckEncode(append(SIG_PREFIX[:], d[:]...), BASE58CHECK_VERSION_SIG))
+	return json.Marshal(d.String())
+}
+func (d Sig) String() string {
+	return base58.CheckEncode(append(SIG_PREFIX[:], d[:]...), BASE58CHECK_VERSION_SIG)
 }
 
 type Pk [PK_LENGTH]byte
@@ -88,27 +99,49 @@ func (pk Pk) String() string {
 	return base58.CheckEncode(append(PK_PREFIX[:], pk[:]...), BASE58CHECK_VERSION_PK)
 }
 
+// Base64 holds a base64 string of a request decoded exactly once: data is
+// shared as is by hashing and storage, json refers to the string within the
+// request body and is used as is in the sign payload and the metadata.
 type Base64 struct {
 	data []byte
 	json []byte
 }
 
 func (d *Base64) UnmarshalJSON(b []byte) error {
-	var s string
-	if err := json.Unmarshal(b, &s); err != nil {
-		return err
-	}
-	bs, err := base64.StdEncoding.DecodeString(s)
-	if err == nil {
-		d.data = bs
+	// Fast path for strings without escapes, decoding straight from
+	// the JSON input instead of going through an intermediate string
+	if len(b) >= 2 && b[0] == '"' && b[len(b)-1] == '"' && bytes.IndexByte(b, '\\') < 0 {
+		src := b[1 : len(b)-1]
+		bs := base64Buffers.Get(base64.StdEncoding.DecodedLen(len(src)))
+		n, err := base64.StdEncoding.Decode(bs, src)
+		if err != nil {
+			base64Buffers.Put(bs)
+			return err
+		}
+		d.data = bs[:n]
 		d.json = b
+		return nil
 	}
-	return err
+	// Strings with escapes are unescaped and decoded by encoding/json,
+	// without the intermediate string
+	var bs []byte
+	if err := json.Unmarshal(b, &bs); err != nil {
+		return err
+	}
+	d.data = bs
+	d.json = b
+	return nil
 }
 func (d *Base64) MarshalJSON() ([]byte, error) {
 	return d.json, nil
 }
 
+// Return the decoded bytes to the pool, once nothing refers to them
+func (d *Base64) release() {
+	base64Buffers.Put(d.data)
+	d.data = nil
+}
+
 type BufferOrError struct {
 	Buf bytes.Buffer
 	Err error
@@ -128,7 +161,27 @@ func (boe *BufferOrError) Write(b []byte) {
 	}
 }
 
+// WriterOrError is BufferOrError writing to an arbitrary writer
+type WriterOrError struct {
+	W   io.Writer
+	Err error
+}
+
+func (woe *WriterOrError) WriteString(s string) {
+	if woe.Err == nil {
+		_, woe.Err = io.WriteString(woe.W, s)
+	}
+}
+
+func (woe *WriterOrError) Write(b []byte) {
+	if woe.Err == nil {
+		_, woe.Err = woe.W.Write(b)
+	}
+}
+
 type MetaToBeSaved struct {
+	// Version of the schema of the metadata, see package metadata
+	SchemaVersion      int     `json:"schema_version"`
 	CreatedAt          string  `json:"created_at"`
 	PeerId             string  `json:"peer_id"`
 	SnarkWork          *Base64 `json:"snark_work,omitempty"`
@@ -137,6 +190,53 @@ type MetaToBeSaved struct {
 	BlockHash          string  `json:"block_hash"` // is base58check-encoded hash of a block
 	GraphqlControlPort int     `json:"graphql_control_port,omitempty"`
 	BuiltWithCommitSha string  `json:"built_with_commit_sha,omitempty"`
+	// Set for submissions made by a delegate on behalf of the submitter
+	Delegate     string `json:"delegate,omitempty"`
+	DelegationId string `json:"delegation_id,omitempty"`
+	// Build of the backend which saved the submission
+	BackendVersion string `json:"backend_version,omitempty"`
+	BackendCommit  string `json:"backend_commit,omitempty"`
+	// Signature of the submission, to verify it again once saved
+	Signature *Sig `json:"signature,omitempty"`
+	// Version of the sign payload signed, if not version 1
+	PayloadVersion int `json:"payload_version,omitempty"`
+	// Set if the timing of the network is configured
+	GlobalSlot *int `json:"global_slot,omitempty"`
+	Epoch      *int `json:"epoch,omitempty"`
+	// Set if submission windows are enforced
+	WindowId *int `json:"window_id,omitempty"`
+	// State hash of the block reported by the client, as of version 2 of
+	// the schema
+	StateHash string `json:"state_hash,omitempty"`
+	// Reported by clients of /v2/submit
+	Telemetry *metadata.Telemetry `json:"telemetry,omitempty"`
+	// Set if the node of the submitter was checked
+	Liveness *metadata.Liveness `json:"liveness,omitempty"`
+	// Set if the clock of the backend was suspect
+	ClockSuspect bool `json:"clock_suspect,omitempty"`
+}
+
+// Hash of the sign payload of the saved submission with its block, of the
+// version it was signed with. The block is encoded in standard base64, as
+// submitters encode it.
+func (meta MetaToBeSaved) signPayloadHash(block []byte) ([]byte, error) {
+	createdAt, err := time.Parse(time.RFC3339, meta.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	blockJson, err := json.Marshal(base64.StdEncoding.EncodeToString(block))
+	if err != nil {
+		return nil, err
+	}
+	data := submitRequestData{
+		PeerId:             meta.PeerId,
+		Block:              &Base64{data: block, json: blockJson},
+		SnarkWork:          meta.SnarkWork,
+		CreatedAt:          createdAt,
+		GraphqlControlPort: meta.GraphqlControlPort,
+		BuiltWithCommitSha: meta.BuiltWithCommitSha,
+	}
+	return data.VersionedSignPayloadHash(meta.PayloadVersion)
 }
 
 type submitRequestData struct {
@@ -151,20 +251,88 @@ type submitRequest struct {
 	Submitter Pk                `json:"submitter"`
 	Sig       Sig               `json:"signature"`
 	Data      submitRequestData `json:"data"`
+	// Optional delegation token, with it the signature
+	// is made by the delegate instead of the submitter
+	Delegation string `json:"delegation,omitempty"`
+	delegation *Delegation
+	// Optional hash of the block computed by the client, as of version 2
+	// of the schema, checked against the hash the block is saved by. It
+	// isn't signed, signatures of version 1 clients stay valid.
+	BlockHash string `json:"block_hash,omitempty"`
+	// Optional global slot of the submission, as of version 2 of the
+	// schema, checked against created_at
+	GlobalSlot *int `json:"global_slot,omitempty"`
+	// Optional base58check-encoded state hash of the block, as of version
+	// 2 of the schema, by which the block is looked up in an archive to
+	// tell whether it is canonical. The backend can't compute it, it is
+	// only checked to be a state hash.
+	StateHash string `json:"state_hash,omitempty"`
+	// Optional telemetry of the node, as of version 2 of the schema, only
+	// kept for requests to /v2/submit
+	Telemetry *metadata.Telemetry `json:"telemetry,omitempty"`
+	// Optional version of the construction of the sign payload, version 1
+	// if not set. It isn't part of the payload of version 1.
+	PayloadVersion int `json:"payload_version,omitempty"`
+	// Global slot and epoch of the submission, set once validated
+	slot, epoch *int
+	// Submission window credited by the submission
+	window *int
+	// Liveness of the node of the submitter, once checked
+	liveness *metadata.Liveness
+	// Set if created_at wasn't checked as the clock was suspect
+	clockSuspect bool
 }
 
 func (req submitRequest) GetBlockDataHash() string {
-	blockHashBytes := blake2b.Sum256(req.Data.Block.data)
+	return BlockDataHash(req.Data.Block.data)
+}
+
+// BlockDataHash is the base58check-encoded blake2b hash of the block,
+// blocks are saved by it
+func BlockDataHash(block []byte) string {
+	blockHashBytes := blake2b.Sum256(block)
 	return base58.CheckEncode(blockHashBytes[:], BASE58CHECK_VERSION_BLOCK_HASH)
 }
 
 func (req submitRequestData) MakeSignPayload() ([]byte, error) {
+	var buf bytes.Buffer
+	err := req.WriteSignPayload(&buf)
+	return buf.Bytes(), err
+}
+
+// Hash of the sign payload, computed without materializing the payload
+func (req submitRequestData) SignPayloadHash() ([]byte, error) {
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.WriteSignPayload(h); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// SignPayloadHash of the JSON of the data of a /submit request of the
+// version of sign payloads, the hash signed by the submitter, e.g. to sign
+// generated submissions
+func SignPayloadHash(data []byte, version int) ([]byte, error) {
+	var req submitRequestData
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	if req.Block == nil {
+		return nil, errors.New("data has no block")
+	}
+	return req.VersionedSignPayloadHash(version)
+}
+
+func (req submitRequestData) WriteSignPayload(w io.Writer) error {
 	createdAtStr := req.CreatedAt.UTC().Format(time.RFC3339)
 	createdAtJson, err2 := json.Marshal(createdAtStr)
 	if err2 != nil {
-		return nil, err2
+		return err2
 	}
-	signPayload := new(BufferOrError)
+	signPayload := &WriterOrError{W: w}
 	signPayload.WriteString("{\"block\":")
 	signPayload.Write(req.Block.json)
 	signPayload.WriteString(",\"created_at\":")
@@ -186,22 +354,60 @@ func (req submitRequestData) MakeSignPayload() ([]byte, error) {
 		signPayload.WriteString("\"")
 	}
 	signPayload.WriteString("}")
-	return signPayload.Buf.Bytes(), signPayload.Err
+	return signPayload.Err
 }
 
 func (req submitRequest) MakeMetaToBeSaved(remoteAddr string) ([]byte, error) {
+	return json.Marshal(req.metaToBeSaved(remoteAddr, req.GetBlockDataHash()))
+}
+
+// Marshal the metadata into buf, the bytes returned are valid until buf is reused
+func (req submitRequest) writeMetaToBeSaved(buf *bytes.Buffer, remoteAddr string, blockHash string) ([]byte, error) {
+	if err := json.NewEncoder(buf).Encode(req.metaToBeSaved(remoteAddr, blockHash)); err != nil {
+		return nil, err
+	}
+	// The encoder terminates values with a newline, json.Marshal doesn't
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (req submitRequest) metaToBeSaved(remoteAddr string, blockHash string) MetaToBeSaved {
 	meta := MetaToBeSaved{
+		SchemaVersion:      metadata.SCHEMA_VERSION,
 		CreatedAt:          req.Data.CreatedAt.Format(time.RFC3339),
 		PeerId:             req.Data.PeerId,
 		SnarkWork:          req.Data.SnarkWork,
 		RemoteAddr:         remoteAddr,
-		BlockHash:          req.GetBlockDataHash(),
+		BlockHash:          blockHash,
 		Submitter:          req.Submitter,
 		GraphqlControlPort: req.Data.GraphqlControlPort,
 		BuiltWithCommitSha: req.Data.BuiltWithCommitSha,
+		BackendVersion:     buildInfo.Version,
+		BackendCommit:      buildInfo.Commit,
+		Signature:          &req.Sig,
+		PayloadVersion:     req.PayloadVersion,
+		GlobalSlot:         req.slot,
+		Epoch:              req.epoch,
+		WindowId:           req.window,
+		StateHash:          req.StateHash,
+		Telemetry:          req.Telemetry,
+		Liveness:           req.liveness,
+		ClockSuspect:       req.clockSuspect,
+	}
+	if req.delegation != nil {
+		meta.Delegate = req.delegation.Claims.Subject
+		meta.DelegationId = req.delegation.Claims.Id
 	}
+	return meta
+}
 
-	return json.Marshal(meta)
+// Return the decoded payloads to the pool, once the submission is saved
+func (req *submitRequest) release() {
+	if req.Data.Block != nil {
+		req.Data.Block.release()
+	}
+	if req.Data.SnarkWork != nil {
+		req.Data.SnarkWork.release()
+	}
 }
 
 func (req submitRequest) CheckRequiredFields() bool {
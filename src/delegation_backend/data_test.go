@@ -1,8 +1,11 @@
 package delegation_backend
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/blake2b"
 	"math/rand"
 	"reflect"
 	"testing"
@@ -126,3 +129,88 @@ func TestSigJSONUnmarshal(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestBase64JSONUnmarshal(t *testing.T) {
+	f := func(bs []byte, escapeSlash bool) bool {
+		enc, _ := json.Marshal(base64.StdEncoding.EncodeToString(bs))
+		if escapeSlash {
+			enc = bytes.ReplaceAll(enc, []byte("/"), []byte("\\/"))
+		}
+		var d Base64
+		return d.UnmarshalJSON(enc) == nil && bytes.Equal(d.data, bs) && bytes.Equal(d.json, enc)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+	var d Base64
+	if d.UnmarshalJSON([]byte("\"not base64!\"")) == nil {
+		t.Error("invalid base64 accepted")
+	}
+}
+
+func TestBase64SharesRequestBody(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	// The sign payload and the metadata use the string within the body
+	block := req.Data.Block.json
+	start := bytes.Index(body, block)
+	if start < 0 || &body[start] != &block[0] {
+		t.Fatal("expected the block string to refer to the request body")
+	}
+	meta, err := req.MakeMetaToBeSaved("1.2.3.4:5678")
+	if err != nil || !bytes.Contains(meta, req.Data.SnarkWork.json) {
+		t.Fatalf("expected the snark work string in the metadata, error: %v", err)
+	}
+}
+
+func TestSignPayloadHash(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := req.Data.MakeSignPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := req.Data.SignPayloadHash()
+	expected := blake2b.Sum256(payload)
+	if err != nil || !bytes.Equal(hash, expected[:]) {
+		t.Fatal("streamed hash differs from hash of the payload")
+	}
+}
+
+func TestWriteMetaToBeSaved(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := req.MakeMetaToBeSaved("1.2.3.4:5678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	meta, err := req.writeMetaToBeSaved(&buf, "1.2.3.4:5678", req.GetBlockDataHash())
+	if err != nil || !bytes.Equal(meta, expected) {
+		t.Fatalf("expected the metadata of json.Marshal, got %s (error: %v)", meta, err)
+	}
+}
+
+func BenchmarkSignPayloadHash(b *testing.B) {
+	body := readTestFile("req-with-snark", b)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(req.Data.Block.json)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := req.Data.SignPayloadHash(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
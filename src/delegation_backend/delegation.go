@@ -0,0 +1,123 @@
+package delegation_backend
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// JWT algorithm of delegation tokens: Mina Schnorr signature over
+// the blake2b-256 hash of the JWT signing input (`<header>.<claims>`),
+// made the same way as the signature of a submission.
+const DELEGATION_JWT_ALG = "MinaSchnorr"
+
+// Allowed clock skew of `iat` and `nbf` of a delegation
+const DELEGATION_CLOCK_SKEW = time.Minute
+
+type delegationHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// DelegationClaims authorize the delegate (sub) to submit
+// on behalf of the issuer (iss) until the expiration.
+type DelegationClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+	Id        string `json:"jti,omitempty"`
+}
+
+// Delegation is a parsed delegation token. Its signature
+// is not verified by parsing, see SigningHash.
+type Delegation struct {
+	Claims    DelegationClaims
+	Delegator Pk
+	Delegate  Pk
+	Sig       Sig
+	hash      [32]byte
+}
+
+// Make the unsigned token (`<header>.<claims>`) for the claims,
+// the token is complete once `.<base64url signature>` is appended.
+func MakeDelegationSigningInput(claims DelegationClaims) (string, error) {
+	header, err := json.Marshal(delegationHeader{Alg: DELEGATION_JWT_ALG, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+func ParseDelegation(token string) (*Delegation, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed delegation token")
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed delegation header: %w", err)
+	}
+	var header delegationHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("malformed delegation header: %w", err)
+	}
+	if header.Alg != DELEGATION_JWT_ALG {
+		return nil, fmt.Errorf("unsupported delegation algorithm %q", header.Alg)
+	}
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed delegation claims: %w", err)
+	}
+	d := new(Delegation)
+	if err := json.Unmarshal(claimsBytes, &d.Claims); err != nil {
+		return nil, fmt.Errorf("malformed delegation claims: %w", err)
+	}
+	if err := StringToPk(&d.Delegator, d.Claims.Issuer); err != nil {
+		return nil, fmt.Errorf("invalid delegation issuer: %w", err)
+	}
+	if err := StringToPk(&d.Delegate, d.Claims.Subject); err != nil {
+		return nil, fmt.Errorf("invalid delegation subject: %w", err)
+	}
+	sigBytes, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sigBytes) != SIG_LENGTH {
+		return nil, fmt.Errorf("malformed delegation signature")
+	}
+	copy(d.Sig[:], sigBytes)
+	d.hash = blake2b.Sum256([]byte(parts[0] + "." + parts[1]))
+	return d, nil
+}
+
+// Hash of the signing input, to be verified against the delegator's key
+func (d *Delegation) SigningHash() []byte {
+	return d.hash[:]
+}
+
+// Validate checks the validity period of the delegation,
+// which can't be longer than maxTTL.
+func (d *Delegation) Validate(now time.Time, maxTTL time.Duration) error {
+	issuedAt := time.Unix(d.Claims.IssuedAt, 0)
+	expiresAt := time.Unix(d.Claims.ExpiresAt, 0)
+	switch {
+	case d.Claims.IssuedAt == 0 || d.Claims.ExpiresAt == 0:
+		return fmt.Errorf("delegation must have iat and exp claims")
+	case !now.Before(expiresAt):
+		return fmt.Errorf("delegation expired at %v", expiresAt.UTC())
+	case issuedAt.After(now.Add(DELEGATION_CLOCK_SKEW)):
+		return fmt.Errorf("delegation issued in future")
+	case d.Claims.NotBefore != 0 && time.Unix(d.Claims.NotBefore, 0).After(now.Add(DELEGATION_CLOCK_SKEW)):
+		return fmt.Errorf("delegation not valid yet")
+	case expiresAt.Sub(issuedAt) > maxTTL:
+		return fmt.Errorf("delegation lifetime exceeds %v", maxTTL)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package delegation_backend
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mkDelegation(t *testing.T, claims DelegationClaims, sig Sig) string {
+	input, err := MakeDelegationSigningInput(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig[:])
+}
+
+func TestParseDelegation(t *testing.T) {
+	delegator, delegate := mkPk(), mkPk()
+	var sig Sig
+	rand.Read(sig[:])
+	claims := DelegationClaims{Issuer: delegator.String(), Subject: delegate.String(), IssuedAt: 1000, ExpiresAt: 4600, Id: "d1"}
+	d, err := ParseDelegation(mkDelegation(t, claims, sig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Delegator != delegator || d.Delegate != delegate || d.Sig != sig || d.Claims != claims {
+		t.Fatalf("unexpected delegation: %+v", d)
+	}
+	for _, token := range []string{"", "a.b", "a.b.c", mkDelegation(t, claims, sig)[1:]} {
+		if _, err := ParseDelegation(token); err == nil {
+			t.Fatalf("expected error for %q", token)
+		}
+	}
+}
+
+func TestDelegationValidate(t *testing.T) {
+	now := time.Unix(10000, 0)
+	cases := []struct {
+		iat, nbf, exp int64
+		valid         bool
+	}{
+		{9000, 0, 12000, true},
+		{9000, 9500, 12000, true},
+		{9000, 0, 10000, false},
+		{9000, 11000, 12000, false},
+		{10100, 0, 12000, false},
+		{9000, 0, 13000, false},
+		{0, 0, 12000, false},
+	}
+	for _, c := range cases {
+		d := Delegation{Claims: DelegationClaims{IssuedAt: c.iat, NotBefore: c.nbf, ExpiresAt: c.exp}}
+		if err := d.Validate(now, time.Hour); (err == nil) != c.valid {
+			t.Fatalf("unexpected result for %+v: %v", c, err)
+		}
+	}
+}
+
+func TestSubmitDelegated(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	storage, sh, tm := testSubmitH(10, Whitelist{req.Submitter: true})
+	delegate := mkPk()
+	var delegationSig Sig
+	rand.Read(delegationSig[:])
+	// Accepts the delegation signature of the submitter
+	// and the payload signature of the delegate
+	sh.app.VerifyPool = newVerifyPool(1, 10, func(_ SignatureScheme, pk *Pk, sig *Sig, _ []byte) bool {
+		return (*pk == req.Submitter && *sig == delegationSig) || (*pk == delegate && *sig == req.Sig)
+	})
+	now := tm.Now()
+	claims := DelegationClaims{
+		Issuer:    req.Submitter.String(),
+		Subject:   delegate.String(),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		Id:        "delegation-1",
+	}
+	submit := func(claims DelegationClaims, sig Sig) int {
+		delegated := req
+		delegated.Delegation = mkDelegation(t, claims, sig)
+		bs, _ := json.Marshal(delegated)
+		return sh.testRequest(bs).Code
+	}
+
+	if code := submit(claims, delegationSig); code != 400 {
+		t.Fatalf("expected delegation disabled, got %d", code)
+	}
+	sh.app.DelegationMaxTTL = 2 * time.Hour
+	var badSig Sig
+	rand.Read(badSig[:])
+	if code := submit(claims, badSig); code != 401 {
+		t.Fatalf("expected invalid delegation signature, got %d", code)
+	}
+	otherIssuer := claims
+	otherIssuer.Issuer = mkPk().String()
+	if code := submit(otherIssuer, delegationSig); code != 401 {
+		t.Fatalf("expected delegation of other issuer rejected, got %d", code)
+	}
+	tooLong := claims
+	tooLong.ExpiresAt = now.Add(3 * time.Hour).Unix()
+	if code := submit(tooLong, delegationSig); code != 401 {
+		t.Fatalf("expected too long delegation rejected, got %d", code)
+	}
+	// Without the delegation, the delegate's signature is checked against the submitter's key
+	if code := sh.testRequest(body).Code; code != 401 {
+		t.Fatalf("expected payload signature of the submitter rejected, got %d", code)
+	}
+	if code := submit(claims, delegationSig); code != 200 {
+		t.Fatalf("expected delegated submission accepted, got %d", code)
+	}
+	for path, bs := range *storage {
+		if !strings.HasPrefix(path, "submissions/") {
+			continue
+		}
+		var meta MetaToBeSaved
+		if err := json.Unmarshal(bs, &meta); err != nil {
+			t.Fatal(err)
+		}
+		if meta.Submitter != req.Submitter || meta.Delegate != delegate.String() || meta.DelegationId != "delegation-1" {
+			t.Fatalf("unexpected meta: %s", bs)
+		}
+		return
+	}
+	t.Fatal("meta not saved")
+}
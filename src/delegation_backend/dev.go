@@ -0,0 +1,323 @@
+package delegation_backend
+
+import (
+	"context"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Submissions of nodes signed for mainnet, with the hashes of their sign
+// payloads, which -dev accepts without the Mina signer. Their requests
+// are those of test/data.
+//
+//go:embed dev_vectors.json
+var devVectorsJSON []byte
+
+// Time the clock of -dev starts at, just after the newest test vector was
+// created so that all of them are accepted
+const DEV_CLOCK_START = "2023-06-12T15:20:00Z"
+
+// TestVector is a submission signed by a node
+type TestVector struct {
+	// Path of the request in the repository
+	Request         string    `json:"request"`
+	Submitter       Pk        `json:"submitter"`
+	CreatedAt       time.Time `json:"created_at"`
+	SignPayloadHash string    `json:"sign_payload_hash"`
+	Sig             Sig       `json:"signature"`
+}
+
+var devTestVectors = sync.OnceValue(func() []TestVector {
+	var vectors []TestVector
+	if err := json.Unmarshal(devVectorsJSON, &vectors); err != nil {
+		panic(fmt.Sprintf("invalid test vectors: %v", err))
+	}
+	return vectors
+})
+
+// DevTestVectors are the test vectors embedded in the binary
+func DevTestVectors() []TestVector {
+	return devTestVectors()
+}
+
+// DevWhitelist is the static whitelist of -dev: submitters of the test
+// vectors along with the keys
+func DevWhitelist(keys []Pk) Whitelist {
+	wl := make(Whitelist)
+	for _, v := range DevTestVectors() {
+		wl[v.Submitter] = true
+	}
+	for _, pk := range keys {
+		wl[pk] = true
+	}
+	return wl
+}
+
+// TestVectors verifies signatures which are those of test vectors, other
+// signatures are verified by Fallback if set
+type TestVectors struct {
+	Vectors  []TestVector
+	Fallback SignatureScheme
+}
+
+func (TestVectors) Name() string { return SIGNATURE_SCHEME_TEST_VECTORS }
+
+func (s TestVectors) String() string {
+	if s.Fallback == nil {
+		return fmt.Sprintf("%s (%d vectors)", SIGNATURE_SCHEME_TEST_VECTORS, len(s.Vectors))
+	}
+	return fmt.Sprintf("%s (%d vectors, otherwise %s)", SIGNATURE_SCHEME_TEST_VECTORS, len(s.Vectors), s.Fallback)
+}
+
+func (s TestVectors) vector(pk Pk) bool {
+	for _, v := range s.Vectors {
+		if v.Submitter == pk {
+			return true
+		}
+	}
+	return false
+}
+
+func (s TestVectors) Verify(pk *Pk, sig *Sig, data []byte) bool {
+	hash := hex.EncodeToString(data)
+	for _, v := range s.Vectors {
+		if v.Submitter == *pk && v.Sig == *sig && v.SignPayloadHash == hash {
+			return true
+		}
+	}
+	return s.Fallback != nil && s.Fallback.Verify(pk, sig, data)
+}
+
+// Keys of the vectors are Mina keys, whatever the fallback
+func (s TestVectors) ValidatePk(pk Pk) error {
+	if s.vector(pk) || s.Fallback == nil {
+		return pk.Validate()
+	}
+	return s.Fallback.ValidatePk(pk)
+}
+
+func (s TestVectors) ValidateSig(sig Sig) error {
+	if s.Fallback == nil {
+		return sig.Validate()
+	}
+	return s.Fallback.ValidateSig(sig)
+}
+
+// DevPreset is the configuration -dev starts from: the dev profile with
+// submissions kept in memory and signatures verified against the test
+// vectors, or as Ed25519 signatures for keys of developers
+func DevPreset() AppConfig {
+	config, _ := ProfilePreset(PROFILE_DEV)
+	config.Profile = PROFILE_DEV
+	config.LocalFileSystem = nil
+	config.MemoryStorage = true
+	config.VerifySignatureDisabled = false
+	return config
+}
+
+// LoadDevConfig loads the configuration of -dev, settings of the config
+// file and environment variables applied over DevPreset
+func LoadDevConfig(configFile string, log logging.EventLogger) AppConfig {
+	config := DevPreset()
+	if path := configFilePath(configFile); path != "" {
+		var unknownKeys []string
+		var err error
+		if config, unknownKeys, err = loadConfigFileOver(path, config); err != nil {
+			log.Fatalf("Error loading configuration, error loading config file: %v", err)
+		}
+		for _, key := range unknownKeys {
+			log.Warnf("Unknown key %s in config file %s is ignored", key, path)
+		}
+	}
+	applyEnv(&config, log)
+	if _, configured := config.Signature[config.NetworkName]; !configured {
+		if config.Signature == nil {
+			config.Signature = make(SignatureConfigs)
+		}
+		config.Signature[config.NetworkName] = &SignatureConfig{Scheme: SIGNATURE_SCHEME_TEST_VECTORS}
+	}
+	config.setDefaults()
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Error loading configuration, invalid configuration:\n%v", err)
+	}
+	return config
+}
+
+// DevClock is the fake clock of -dev. It runs along with the clock of the
+// host from its start, and is moved with its handler to submit test
+// vectors or to cross windows and epochs without waiting.
+type DevClock struct {
+	mutex  sync.Mutex
+	offset time.Duration
+	now    nowFunc
+}
+
+func NewDevClock(start time.Time, now nowFunc) *DevClock {
+	return &DevClock{offset: start.Sub(now()), now: now}
+}
+
+func (c *DevClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now().Add(c.offset)
+}
+
+// Set the clock to the time
+func (c *DevClock) Set(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.offset = t.Sub(c.now())
+}
+
+// Advance the clock by the duration, which may be negative
+func (c *DevClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.offset += d
+}
+
+type devClockRequest struct {
+	// RFC3339 time to set the clock to
+	Time string `json:"time,omitempty"`
+	// Duration to advance the clock by, e.g. 1h30m
+	Advance string `json:"advance,omitempty"`
+}
+
+type devClockResponse struct {
+	Time time.Time `json:"time"`
+}
+
+// Handler of /dev/clock: GET returns the time of the clock, POST sets it
+// with {"time": ...} or advances it with {"advance": ...}
+func (c *DevClock) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req devClockRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Time == "") == (req.Advance == "") {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{"Expected {\"time\": ...} or {\"advance\": ...}"})
+				return
+			}
+			if req.Time != "" {
+				t, err := time.Parse(time.RFC3339, req.Time)
+				if err != nil {
+					writeJSON(rw, http.StatusBadRequest, errorResponse{fmt.Sprintf("Invalid time: %v", err)})
+					return
+				}
+				c.Set(t)
+			} else {
+				d, err := time.ParseDuration(req.Advance)
+				if err != nil {
+					writeJSON(rw, http.StatusBadRequest, errorResponse{fmt.Sprintf("Invalid duration: %v", err)})
+					return
+				}
+				c.Advance(d)
+			}
+		default:
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		writeJSON(rw, http.StatusOK, devClockResponse{Time: c.Now().UTC()})
+	})
+}
+
+// MemoryStorage keeps objects in memory, as the storage of -dev. It reads
+// submissions back as a StorageSource.
+type MemoryStorage struct {
+	mutex   sync.RWMutex
+	objects map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: make(map[string][]byte)}
+}
+
+func (m *MemoryStorage) Save(objs ObjectsToSave) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	// Values are kept, objects saved along other backends are copied out
+	// of the buffers of the request by SaveToBackends
+	for path, value := range objs {
+		m.objects[path] = value
+	}
+	return nil
+}
+
+// Paths of the objects under the prefix, sorted
+func (m *MemoryStorage) Paths(prefix string) []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var paths []string
+	for path := range m.objects {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (m *MemoryStorage) Days(ctx context.Context) ([]string, error) {
+	var days []string
+	for _, path := range m.Paths(SUBMISSIONS_PREFIX) {
+		day, _, found := strings.Cut(strings.TrimPrefix(path, SUBMISSIONS_PREFIX), "/")
+		if found && (len(days) == 0 || days[len(days)-1] != day) {
+			days = append(days, day)
+		}
+	}
+	return days, nil
+}
+
+func (m *MemoryStorage) Submissions(ctx context.Context, day string) ([]string, error) {
+	var paths []string
+	for _, path := range m.Paths(SUBMISSIONS_PREFIX + day + "/") {
+		if strings.HasSuffix(path, ".json") {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+func (m *MemoryStorage) Read(ctx context.Context, path string) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	value, ok := m.objects[path]
+	if !ok {
+		return nil, fmt.Errorf("object %s: %w", path, fs.ErrNotExist)
+	}
+	return value, nil
+}
+
+// Handler of /dev/storage/: the paths of the objects under the path of
+// the request, or the object at the path
+func (m *MemoryStorage) Handler(prefix string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, prefix)
+		if path == "" || strings.HasSuffix(path, "/") {
+			writeJSON(rw, http.StatusOK, m.Paths(path))
+			return
+		}
+		value, err := m.Read(r.Context(), path)
+		if err != nil {
+			writeJSON(rw, http.StatusNotFound, errorResponse{"Object not found"})
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write(value)
+	})
+}
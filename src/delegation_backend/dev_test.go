@@ -0,0 +1,168 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test vectors are those of the requests of test/data, and are accepted
+// at the start of the clock of dev mode
+func TestDevTestVectors(t *testing.T) {
+	start, err := time.Parse(time.RFC3339, DEV_CLOCK_START)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheme, err := NewSignatureScheme(SIGNATURE_SCHEME_TEST_VECTORS, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vectors := DevTestVectors()
+	if len(vectors) == 0 {
+		t.Fatal("expected test vectors")
+	}
+	for _, v := range vectors {
+		body := readTestFile(strings.TrimSuffix(filepath.Base(v.Request), ".json"), t)
+		var req submitRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := req.signPayloadHash()
+		if err != nil || hex.EncodeToString(hash) != v.SignPayloadHash || req.Sig != v.Sig || req.Submitter != v.Submitter || !req.Data.CreatedAt.Equal(v.CreatedAt) {
+			t.Fatalf("expected the vector of %s, error: %v", v.Request, err)
+		}
+
+		storage, sh, _ := testSubmitH(1, DevWhitelist(nil))
+		sh.app.SignatureScheme = scheme
+		sh.app.Now = NewDevClock(start, time.Now).Now
+		if rep := sh.testRequest(body); rep.Code != 200 || len(*storage) == 0 {
+			t.Fatalf("expected %s accepted, got %d: %s", v.Request, rep.Code, rep.Body)
+		}
+		// Other signatures of the key are verified as Ed25519 signatures
+		req.Sig[0] ^= 1
+		tampered, _ := json.Marshal(req)
+		_, sh, _ = testSubmitH(1, DevWhitelist(nil))
+		sh.app.SignatureScheme = scheme
+		sh.app.Now = NewDevClock(start, time.Now).Now
+		if rep := sh.testRequest(tampered); rep.Code != 401 {
+			t.Fatalf("expected a signature other than the vector rejected, got %d: %s", rep.Code, rep.Body)
+		}
+	}
+}
+
+func TestDevEd25519(t *testing.T) {
+	req, body := ed25519Request(t, SIGN_PAYLOAD_V2)
+	_, sh, tm := testSubmitH(1, DevWhitelist([]Pk{req.Submitter}))
+	tm.time = req.Data.CreatedAt
+	sh.app.SignatureScheme, _ = NewSignatureScheme(SIGNATURE_SCHEME_TEST_VECTORS, 0)
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected the Ed25519 signature of a developer key verified, got %d: %s", rep.Code, rep.Body)
+	}
+}
+
+func TestDevClock(t *testing.T) {
+	host := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	start := time.Date(2023, 6, 12, 15, 20, 0, 0, time.UTC)
+	clock := NewDevClock(start, func() time.Time { return host })
+	if !clock.Now().Equal(start) {
+		t.Fatalf("expected the clock at its start, got %v", clock.Now())
+	}
+	host = host.Add(time.Minute)
+	if !clock.Now().Equal(start.Add(time.Minute)) {
+		t.Fatalf("expected the clock running along with the host, got %v", clock.Now())
+	}
+
+	post := func(body string) (int, time.Time) {
+		rep := httptest.NewRecorder()
+		clock.Handler().ServeHTTP(rep, httptest.NewRequest(http.MethodPost, "/dev/clock", strings.NewReader(body)))
+		var resp devClockResponse
+		json.Unmarshal(rep.Body.Bytes(), &resp)
+		return rep.Code, resp.Time
+	}
+	if code, now := post(`{"advance": "2h"}`); code != 200 || !now.Equal(start.Add(2*time.Hour+time.Minute)) {
+		t.Fatalf("expected the clock advanced, got %d %v", code, now)
+	}
+	if code, now := post(`{"time": "2021-07-17T22:40:00Z"}`); code != 200 || !now.Equal(time.Date(2021, 7, 17, 22, 40, 0, 0, time.UTC)) {
+		t.Fatalf("expected the clock set, got %d %v", code, now)
+	}
+	for _, body := range []string{`{}`, `{"time": "2021-07-17T22:40:00Z", "advance": "1h"}`, `{"advance": "soon"}`} {
+		if code, _ := post(body); code != 400 {
+			t.Errorf("expected %s rejected, got %d", body, code)
+		}
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	m := NewMemoryStorage()
+	m.Save(ObjectsToSave{
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qb.json": []byte(`{"n":3}`),
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": []byte(`{"n":1}`),
+		"submissions/2024-05-01/2024-05-01T11:00:00Z-B62qa.json": []byte(`{"n":2}`),
+		"blocks/3NK.dat": []byte("block"),
+	})
+	ctx := context.Background()
+	days, _ := m.Days(ctx)
+	if strings.Join(days, ",") != "2024-05-01,2024-05-02" {
+		t.Fatalf("unexpected days %v", days)
+	}
+	paths, _ := m.Submissions(ctx, "2024-05-01")
+	if len(paths) != 2 || paths[0] != "submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json" {
+		t.Fatalf("unexpected submissions %v", paths)
+	}
+	if _, err := m.Read(ctx, "blocks/missing.dat"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected a missing object, got %v", err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rep := httptest.NewRecorder()
+		m.Handler("/dev/storage/").ServeHTTP(rep, httptest.NewRequest(http.MethodGet, "/dev/storage/"+path, nil))
+		return rep
+	}
+	var listed []string
+	if rep := get("blocks/"); json.Unmarshal(rep.Body.Bytes(), &listed) != nil || len(listed) != 1 {
+		t.Fatalf("unexpected listing %s", rep.Body)
+	}
+	if rep := get("submissions/2024-05-02/2024-05-02T10:00:00Z-B62qb.json"); rep.Code != 200 || rep.Body.String() != `{"n":3}` {
+		t.Fatalf("unexpected object %d: %s", rep.Code, rep.Body)
+	}
+	if rep := get("blocks/missing.dat"); rep.Code != 404 {
+		t.Fatalf("expected a missing object not found, got %d", rep.Code)
+	}
+}
+
+func TestLoadDevConfig(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	mockLogger := &MockLogger{}
+	config := LoadDevConfig("", mockLogger)
+	if mockLogger.lastMessage != "" {
+		t.Fatalf("Unexpected fatal error: %s", mockLogger.lastMessage)
+	}
+	scheme, err := config.Signature.Scheme(config.NetworkName)
+	if !config.MemoryStorage || config.LocalFileSystem != nil || config.VerifySignatureDisabled || err != nil || scheme.Name() != SIGNATURE_SCHEME_TEST_VECTORS {
+		t.Fatalf("unexpected dev configuration %+v, scheme %v, error: %v", config, scheme, err)
+	}
+
+	// Test vectors aren't signatures of mainnet, nor is memory storage
+	os.Setenv("CONFIG_NETWORK_NAME", "mainnet")
+	os.Setenv("PROFILE", PROFILE_MAINNET)
+	os.Setenv("MEMORY_STORAGE", "1")
+	LoadConfig("", mockLogger)
+	if !strings.Contains(mockLogger.lastMessage, "memory_storage (MEMORY_STORAGE) is invalid") {
+		t.Errorf("Expected memory storage rejected for mainnet, got: %s", mockLogger.lastMessage)
+	}
+	mockLogger.lastMessage = ""
+	LoadDevConfig("", mockLogger)
+	if !strings.Contains(mockLogger.lastMessage, "is for tests only") {
+		t.Errorf("Expected test vectors rejected for mainnet, got: %s", mockLogger.lastMessage)
+	}
+}
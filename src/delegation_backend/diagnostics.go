@@ -0,0 +1,182 @@
+package delegation_backend
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"time"
+)
+
+const DIAGNOSTICS_DEFAULT_LISTEN_TO = "127.0.0.1:6060"
+
+// Longest CPU profile or execution trace served
+const DIAGNOSTICS_MAX_PROFILE_DURATION = 5 * time.Minute
+
+var processStartedAt = time.Now()
+
+// IsLoopbackAddr tells whether the listen address is bound to the loopback
+// interface only, e.g. `127.0.0.1:6060` or `localhost:6060`.
+func IsLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// RuntimeStats are the runtime and GC statistics of the process
+type RuntimeStats struct {
+	GoVersion     string  `json:"go_version"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Goroutines    int     `json:"goroutines"`
+	GOMAXPROCS    int     `json:"gomaxprocs"`
+	NumCPU        int     `json:"num_cpu"`
+	HeapAlloc     uint64  `json:"heap_alloc_bytes"`
+	HeapInuse     uint64  `json:"heap_inuse_bytes"`
+	HeapObjects   uint64  `json:"heap_objects"`
+	Sys           uint64  `json:"sys_bytes"`
+	TotalAlloc    uint64  `json:"total_alloc_bytes"`
+	NumGC         uint32  `json:"num_gc"`
+	GCPauseTotal  float64 `json:"gc_pause_total_ms"`
+	// Zero if there was no GC yet
+	LastGC       time.Time `json:"last_gc"`
+	NextGCTarget uint64    `json:"next_gc_bytes"`
+}
+
+func ReadRuntimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	stats := RuntimeStats{
+		GoVersion:     runtime.Version(),
+		UptimeSeconds: time.Since(processStartedAt).Seconds(),
+		Goroutines:    runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		NumCPU:        runtime.NumCPU(),
+		HeapAlloc:     m.HeapAlloc,
+		HeapInuse:     m.HeapInuse,
+		HeapObjects:   m.HeapObjects,
+		Sys:           m.Sys,
+		TotalAlloc:    m.TotalAlloc,
+		NumGC:         m.NumGC,
+		GCPauseTotal:  float64(m.PauseTotalNs) / 1e6,
+		NextGCTarget:  m.NextGC,
+	}
+	if m.LastGC > 0 {
+		stats.LastGC = time.Unix(0, int64(m.LastGC)).UTC()
+	}
+	return stats
+}
+
+func profileSeconds(r *http.Request) (time.Duration, error) {
+	seconds := 30
+	if s := r.URL.Query().Get("seconds"); s != "" {
+		var err error
+		seconds, err = strconv.Atoi(s)
+		if err != nil || seconds <= 0 {
+			return 0, fmt.Errorf("invalid seconds %q", s)
+		}
+	}
+	d := time.Duration(seconds) * time.Second
+	if d > DIAGNOSTICS_MAX_PROFILE_DURATION {
+		return 0, fmt.Errorf("profile longer than %v", DIAGNOSTICS_MAX_PROFILE_DURATION)
+	}
+	return d, nil
+}
+
+// Serve a CPU profile or an execution trace collected for the requested duration
+func timedProfileHandler(start func(http.ResponseWriter) error, stop func()) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		d, err := profileSeconds(r)
+		if err != nil {
+			writeJSON(rw, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		rw.Header().Set("Content-Type", "application/octet-stream")
+		if err := start(rw); err != nil {
+			writeJSON(rw, http.StatusInternalServerError, errorResponse{"Could not start profiling: " + err.Error()})
+			return
+		}
+		select {
+		case <-time.After(d):
+		case <-r.Context().Done():
+		}
+		stop()
+	}
+}
+
+// Serve a named profile (heap, goroutine, allocs, ...), in the
+// binary format unless `?debug=1` or `?debug=2` asks for text.
+func namedProfileHandler(rw http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[len("/debug/pprof/"):]
+	if name == "" {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintf(rw, "%s %d\n", p.Name(), p.Count())
+		}
+		fmt.Fprintln(rw, "profile (CPU, ?seconds=30)")
+		fmt.Fprintln(rw, "trace (execution trace, ?seconds=30)")
+		return
+	}
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		writeJSON(rw, http.StatusNotFound, errorResponse{"Unknown profile " + name})
+		return
+	}
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if name == "heap" && r.URL.Query().Get("gc") != "" {
+		runtime.GC()
+	}
+	if debug > 0 {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		rw.Header().Set("Content-Type", "application/octet-stream")
+	}
+	_ = profile.WriteTo(rw, debug)
+}
+
+// NewDiagnosticsHandler serves profiles compatible with `go tool pprof`
+// under /debug/pprof/, expvar at /debug/vars and runtime statistics
+// at /debug/runtime.
+//
+// Profiles are served using runtime/pprof, as importing net/http/pprof
+// would register them on the default mux of the public listener.
+func NewDiagnosticsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", namedProfileHandler)
+	mux.Handle("/debug/pprof/profile", timedProfileHandler(
+		func(rw http.ResponseWriter) error { return pprof.StartCPUProfile(rw) }, pprof.StopCPUProfile))
+	mux.Handle("/debug/pprof/trace", timedProfileHandler(
+		func(rw http.ResponseWriter) error { return trace.Start(rw) }, trace.Stop))
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/runtime", func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, http.StatusOK, ReadRuntimeStats())
+	})
+	return mux
+}
+
+// NewDiagnosticsServer creates the server of diagnostics endpoints, which
+// is guarded by the admin token unless bound to the loopback interface.
+// Write timeout is not set as profiles take long to collect.
+func NewDiagnosticsServer(addr string, getAdminToken func() string) (*http.Server, error) {
+	var handler http.Handler = NewDiagnosticsHandler()
+	if !IsLoopbackAddr(addr) {
+		if getAdminToken() == "" {
+			return nil, fmt.Errorf("diagnostics listener %s is not on the loopback interface and no admin token is configured", addr)
+		}
+		handler = AdminAuthFunc(getAdminToken, handler)
+	}
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: HTTP_READ_HEADER_TIMEOUT,
+	}, nil
+}
@@ -0,0 +1,76 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsLoopbackAddr(t *testing.T) {
+	for addr, expected := range map[string]bool{
+		"127.0.0.1:6060": true,
+		"[::1]:6060":     true,
+		"localhost:6060": true,
+		":6060":          false,
+		"0.0.0.0:6060":   false,
+		"10.0.0.1:6060":  false,
+		"127.0.0.1":      false,
+	} {
+		if IsLoopbackAddr(addr) != expected {
+			t.Fatalf("unexpected result for %s", addr)
+		}
+	}
+}
+
+func TestDiagnosticsServer(t *testing.T) {
+	if _, err := NewDiagnosticsServer(":6060", func() string { return "" }); err == nil {
+		t.Fatal("expected unguarded public listener refused")
+	}
+	srv, err := NewDiagnosticsServer(":6060", func() string { return "secret" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		srv.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := get("/debug/runtime", ""); rr.Code != 401 {
+		t.Fatalf("expected unauthorized, got %d", rr.Code)
+	}
+	rr := get("/debug/runtime", "secret")
+	var stats RuntimeStats
+	if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil || rr.Code != 200 || stats.Goroutines == 0 || stats.HeapAlloc == 0 {
+		t.Fatalf("unexpected runtime stats %d: %+v", rr.Code, stats)
+	}
+	if rr := get("/debug/pprof/goroutine?debug=1", "secret"); rr.Code != 200 || !strings.Contains(rr.Body.String(), "goroutine profile") {
+		t.Fatalf("unexpected goroutine profile %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := get("/debug/pprof/", "secret"); !strings.Contains(rr.Body.String(), "heap") {
+		t.Fatalf("unexpected index: %s", rr.Body.String())
+	}
+	if rr := get("/debug/pprof/nothing", "secret"); rr.Code != 404 {
+		t.Fatalf("expected unknown profile, got %d", rr.Code)
+	}
+	if rr := get("/debug/pprof/profile?seconds=3600", "secret"); rr.Code != 400 {
+		t.Fatalf("expected too long profile refused, got %d", rr.Code)
+	}
+	if rr := get("/debug/vars", "secret"); !strings.Contains(rr.Body.String(), "memstats") {
+		t.Fatal("expected expvar served")
+	}
+
+	local, err := NewDiagnosticsServer("127.0.0.1:6060", func() string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	local.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/runtime", nil))
+	if rr.Code != 200 {
+		t.Fatalf("expected loopback listener unguarded, got %d", rr.Code)
+	}
+}
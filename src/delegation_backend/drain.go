@@ -0,0 +1,122 @@
+package delegation_backend
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Drain takes an instance out of load balancing before it is terminated.
+// Once draining, /readyz fails so that load balancers stop routing to the
+// instance, while submissions still routed to it are accepted, and pending
+// writes are flushed. Shutting down drains the instance first.
+type Drain struct {
+	mutex   sync.Mutex
+	since   time.Time
+	flushes []func()
+	now     nowFunc
+	log     logging.StandardLogger
+}
+
+// Time the instance drains before shutting down, none if not configured
+func (s *HTTPServerConfig) DrainDelay() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return time.Duration(s.DrainDelaySeconds) * time.Second
+}
+
+// DrainStatus is the response of the drain admin endpoint
+type DrainStatus struct {
+	Draining bool       `json:"draining"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+func NewDrain(now nowFunc, log logging.StandardLogger) *Drain {
+	return &Drain{now: now, log: log}
+}
+
+// OnDrain adds a flush of pending writes, run once the instance starts
+// draining
+func (d *Drain) OnDrain(flush func()) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.flushes = append(d.flushes, flush)
+}
+
+func (d *Drain) Draining() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return !d.since.IsZero()
+}
+
+func (d *Drain) Status() DrainStatus {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.since.IsZero() {
+		return DrainStatus{}
+	}
+	since := d.since
+	return DrainStatus{Draining: true, Since: &since}
+}
+
+// Start draining, unless already draining, and flush pending writes. It
+// returns once flushed.
+func (d *Drain) Start() DrainStatus {
+	d.mutex.Lock()
+	if !d.since.IsZero() {
+		d.mutex.Unlock()
+		return d.Status()
+	}
+	d.since = d.now()
+	flushes := d.flushes
+	d.mutex.Unlock()
+	incMetric("drains")
+	d.log.Infof("Draining, readiness fails from now on")
+	for _, flush := range flushes {
+		flush()
+	}
+	return d.Status()
+}
+
+// Cancel draining, e.g. once termination of the instance is called off
+func (d *Drain) Cancel() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !d.since.IsZero() {
+		d.since = time.Time{}
+		d.log.Infof("Draining cancelled, the instance is ready again")
+	}
+}
+
+// Wait until the instance has drained for the delay, so that load
+// balancers noticed readiness failing before the server shuts down
+func (d *Drain) Wait(delay time.Duration, sleep func(time.Duration)) {
+	d.mutex.Lock()
+	remaining := delay - d.now().Sub(d.since)
+	d.mutex.Unlock()
+	if remaining > 0 {
+		d.log.Infof("Waiting %v for load balancers to stop routing to the instance", remaining)
+		sleep(remaining)
+	}
+}
+
+// AdminHandler of /admin/drain: GET responds with the status of draining,
+// POST starts draining once pending writes are flushed, DELETE cancels it
+func (d *Drain) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(rw, http.StatusOK, d.Status())
+		case http.MethodPost:
+			writeJSON(rw, http.StatusOK, d.Start())
+		case http.MethodDelete:
+			d.Cancel()
+			writeJSON(rw, http.StatusOK, d.Status())
+		default:
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestDrain(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	drain := NewDrain(func() time.Time { return now }, logging.Logger("delegation backend test"))
+	w := &batchWriter{}
+	b := NewWriteBatcher(100, time.Hour, w.write)
+	drain.OnDrain(b.Flush)
+	saved := make(chan error)
+	go func() { saved <- b.Save(&Submission{Submitter: "a"}) }()
+	// Wait for the submission to be pending
+	for {
+		b.mutex.Lock()
+		pending := b.pending != nil
+		b.mutex.Unlock()
+		if pending {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	readyz := func() (int, string) {
+		rep := httptest.NewRecorder()
+		ReadyzHandler(func() bool { return true }, nil, drain).ServeHTTP(rep, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var status ReadinessStatus
+		json.Unmarshal(rep.Body.Bytes(), &status)
+		return rep.Code, status.Status
+	}
+	admin := func(method string) DrainStatus {
+		rep := httptest.NewRecorder()
+		drain.AdminHandler().ServeHTTP(rep, httptest.NewRequest(method, "/admin/drain", nil))
+		var status DrainStatus
+		json.Unmarshal(rep.Body.Bytes(), &status)
+		return status
+	}
+	if code, status := readyz(); code != 200 || status != "ok" {
+		t.Fatalf("expected the instance ready, got %d %s", code, status)
+	}
+	if status := admin(http.MethodPost); !status.Draining || !status.Since.Equal(now) {
+		t.Fatalf("expected the instance draining, got %+v", status)
+	}
+	if err := <-saved; err != nil || len(w.batches) != 1 {
+		t.Fatalf("expected the pending batch flushed on drain, error: %v", err)
+	}
+	if code, status := readyz(); code != 503 || status != "draining" {
+		t.Fatalf("expected readiness failing while draining, got %d %s", code, status)
+	}
+
+	// Draining again keeps the time draining started, and flushes nothing
+	now = now.Add(20 * time.Second)
+	if status := drain.Start(); !status.Since.Equal(now.Add(-20 * time.Second)) {
+		t.Fatalf("expected draining since the first drain, got %+v", status)
+	}
+	var slept time.Duration
+	drain.Wait(30*time.Second, func(d time.Duration) { slept = d })
+	if slept != 10*time.Second {
+		t.Fatalf("expected the rest of the drain delay waited, got %v", slept)
+	}
+
+	if status := admin(http.MethodDelete); status.Draining {
+		t.Fatalf("expected draining cancelled, got %+v", status)
+	}
+	if code, _ := readyz(); code != 200 {
+		t.Fatalf("expected the instance ready again, got %d", code)
+	}
+}
@@ -0,0 +1,146 @@
+package delegation_backend
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Defaults of reporting storage failure bursts
+const (
+	STORAGE_FAILURE_BURST_THRESHOLD = 5
+	STORAGE_FAILURE_BURST_WINDOW    = time.Minute
+)
+
+const ERROR_REPORT_QUEUE_SIZE = 100
+const ERROR_REPORT_TIMEOUT = 10 * time.Second
+
+// Levels of error events
+const (
+	ERROR_LEVEL_ERROR   = "error"
+	ERROR_LEVEL_WARNING = "warning"
+	ERROR_LEVEL_FATAL   = "fatal"
+)
+
+// ErrorEvent is an error worth paging on, with its context
+type ErrorEvent struct {
+	Message string
+	Err     error
+	Level   string
+	// Context of the error, e.g. submitter, path or backend
+	Tags map[string]string
+	// Stack trace of a panic
+	Stack []byte
+}
+
+// ErrorReporter sends error events to an external service.
+// Report must not block.
+type ErrorReporter interface {
+	Report(ev ErrorEvent)
+}
+
+// MultiReporter reports events to all reporters
+type MultiReporter []ErrorReporter
+
+func (m MultiReporter) Report(ev ErrorEvent) {
+	for _, r := range m {
+		r.Report(ev)
+	}
+}
+
+// SentryReporter sends events to Sentry, queued and sent in the
+// background by the transport of the client.
+type SentryReporter struct {
+	hub *sentry.Hub
+}
+
+func NewSentryReporter(cfg *ErrorReportingConfig) (*SentryReporter, error) {
+	transport := sentry.NewHTTPTransport()
+	transport.BufferSize = ERROR_REPORT_QUEUE_SIZE
+	transport.Timeout = ERROR_REPORT_TIMEOUT
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         cfg.SentryDSN,
+		Environment: cfg.Environment,
+		Release:     cfg.Release,
+		Transport:   transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("malformed Sentry DSN: %w", err)
+	}
+	return &SentryReporter{hub: sentry.CurrentHub()}, nil
+}
+
+func (r *SentryReporter) Report(ev ErrorEvent) {
+	event := sentry.NewEvent()
+	event.Level = sentry.Level(ev.Level)
+	if event.Level == "" {
+		event.Level = sentry.LevelError
+	}
+	event.Logger = "delegation backend"
+	event.Message = ev.Message
+	event.Tags = ev.Tags
+	if ev.Err != nil {
+		event.Exception = []sentry.Exception{{Type: fmt.Sprintf("%T", ev.Err), Value: ev.Err.Error()}}
+	}
+	if ev.Stack != nil {
+		event.Extra = map[string]interface{}{"stack": string(ev.Stack)}
+	}
+	r.hub.CaptureEvent(event)
+}
+
+// Flush waits for the events queued to be sent, up to the timeout,
+// returning whether they were
+func (r *SentryReporter) Flush(timeout time.Duration) bool {
+	return r.hub.Flush(timeout)
+}
+
+// StorageFailureMonitor reports bursts of storage failures: once
+// failures of a backend reach the threshold within the window,
+// they are reported at most once per window.
+type StorageFailureMonitor struct {
+	mutex     sync.Mutex
+	reporter  ErrorReporter
+	threshold int
+	events    windowEvents
+	reported  map[string]time.Time
+	now       nowFunc
+}
+
+func NewStorageFailureMonitor(reporter ErrorReporter, threshold int, window time.Duration, now nowFunc) *StorageFailureMonitor {
+	return &StorageFailureMonitor{
+		reporter:  reporter,
+		threshold: threshold,
+		events:    newWindowEvents(window),
+		reported:  make(map[string]time.Time),
+		now:       now,
+	}
+}
+
+// Record counts the failure of the backend, tags give context of the latest failure
+func (m *StorageFailureMonitor) Record(backend string, err error, tags map[string]string) {
+	m.mutex.Lock()
+	now := m.now()
+	failures := len(m.events.add(backend, "", now))
+	if failures < m.threshold {
+		m.mutex.Unlock()
+		return
+	}
+	if at, reported := m.reported[backend]; reported && now.Sub(at) < m.events.window {
+		m.mutex.Unlock()
+		return
+	}
+	m.reported[backend] = now
+	m.mutex.Unlock()
+
+	eventTags := map[string]string{"backend": backend}
+	for k, v := range tags {
+		eventTags[k] = v
+	}
+	m.reporter.Report(ErrorEvent{
+		Message: fmt.Sprintf("%d failures saving to %s within %v", failures, backend, m.events.window),
+		Err:     err,
+		Tags:    eventTags,
+	})
+}
@@ -0,0 +1,125 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type reporterMock struct {
+	mutex  sync.Mutex
+	events []ErrorEvent
+}
+
+func (r *reporterMock) Report(ev ErrorEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func TestSentryReporter(t *testing.T) {
+	for _, dsn := range []string{"https://sentry.io/1", "https://key@sentry.io/", ":"} {
+		if _, err := NewSentryReporter(&ErrorReportingConfig{SentryDSN: dsn}); err == nil {
+			t.Fatalf("expected error for DSN %q", dsn)
+		}
+	}
+
+	type received struct {
+		path, auth string
+		event      map[string]interface{}
+	}
+	receivedCh := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		var rec received
+		if len(lines) == 3 {
+			_ = json.Unmarshal([]byte(lines[2]), &rec.event)
+		}
+		rec.path, rec.auth = r.URL.Path, r.Header.Get("X-Sentry-Auth")
+		receivedCh <- rec
+	}))
+	defer srv.Close()
+
+	dsn := strings.Replace(srv.URL, "://", "://public@", 1) + "/42"
+	reporter, err := NewSentryReporter(&ErrorReportingConfig{SentryDSN: dsn, Environment: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter.Report(ErrorEvent{Message: "Failed", Err: errors.New("timeout"), Tags: map[string]string{"backend": "s3"}})
+	if !reporter.Flush(5 * time.Second) {
+		t.Fatal("event not sent")
+	}
+	select {
+	case rec := <-receivedCh:
+		if rec.path != "/api/42/envelope/" || !strings.Contains(rec.auth, "sentry_key=public") {
+			t.Fatalf("unexpected request %s, auth %s", rec.path, rec.auth)
+		}
+		ev := rec.event
+		tags, _ := ev["tags"].(map[string]interface{})
+		exception, _ := json.Marshal(ev["exception"])
+		if ev["level"] != ERROR_LEVEL_ERROR || ev["environment"] != "test" || ev["message"] != "Failed" ||
+			tags["backend"] != "s3" || !strings.Contains(string(exception), `"value":"timeout"`) {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+	default:
+		t.Fatal("event not received")
+	}
+}
+
+func TestStorageFailureMonitor(t *testing.T) {
+	reporter := &reporterMock{}
+	tm := &timeMock{time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	monitor := NewStorageFailureMonitor(reporter, 3, time.Minute, tm.Now)
+	failure := errors.New("timeout")
+	for i := 0; i < 5; i++ {
+		monitor.Record("s3", failure, nil)
+		tm.time = tm.time.Add(time.Second)
+	}
+	monitor.Record("keyspaces", failure, nil)
+	if len(reporter.events) != 1 || reporter.events[0].Tags["backend"] != "s3" {
+		t.Fatalf("expected a single burst of s3 reported, got %+v", reporter.events)
+	}
+
+	// The burst is reported again only after the window
+	tm.time = tm.time.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		monitor.Record("s3", failure, map[string]string{"submitter": "B62q"})
+	}
+	if len(reporter.events) != 2 || reporter.events[1].Tags["submitter"] != "B62q" {
+		t.Fatalf("expected burst reported again, got %+v", reporter.events)
+	}
+}
+
+func TestSubmitPanicReported(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, _ := testSubmitH(10, Whitelist{req.Submitter: true})
+	reporter := &reporterMock{}
+	sh.app.ErrorReporter = reporter
+	sh.app.Save = func(context.Context, ObjectsToSave) StorageOutcomes {
+		panic("storage exploded")
+	}
+	rep := sh.testRequest(body)
+	if rep.Code != 500 {
+		t.Fatalf("expected 500, got %v", rep)
+	}
+	if len(reporter.events) != 1 {
+		t.Fatalf("expected panic reported, got %+v", reporter.events)
+	}
+	ev := reporter.events[0]
+	if ev.Level != ERROR_LEVEL_FATAL || !strings.Contains(ev.Message, "storage exploded") ||
+		ev.Tags["submitter"] != req.Submitter.String() || len(ev.Stack) == 0 {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+}
@@ -0,0 +1,258 @@
+package delegation_backend
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Formats of exported datasets
+const (
+	EXPORT_FORMAT_CSV     = "csv"
+	EXPORT_FORMAT_JSONL   = "jsonl"
+	EXPORT_FORMAT_PARQUET = "parquet"
+)
+
+// Rows of a Parquet row group, buffered in memory until written
+const EXPORT_PARQUET_ROW_GROUP_SIZE = 100000
+
+// Submissions read at once from object storages
+const EXPORT_READ_CONCURRENCY = 16
+
+// ExportRecord is a submission as needed by the uptime scoring, the slot
+// is set by the validator in databases and unknown in object storages
+type ExportRecord struct {
+	Submitter string    `json:"submitter"`
+	CreatedAt time.Time `json:"created_at"`
+	BlockHash string    `json:"block_hash"`
+	Slot      *int      `json:"slot"`
+}
+
+// ExportSource reads submissions of a range of days, `YYYY-MM-DD`
+// inclusive, in order of submission
+type ExportSource interface {
+	Export(ctx context.Context, filter ReplayFilter, emit func(ExportRecord) error) error
+}
+
+// ObjectStorageExport reads submissions of S3 or local filesystem storage
+type ObjectStorageExport struct {
+	Source StorageSource
+}
+
+func (o ObjectStorageExport) Export(ctx context.Context, filter ReplayFilter, emit func(ExportRecord) error) error {
+	days, err := o.Source.Days(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing days of submissions: %w", err)
+	}
+	for _, day := range days {
+		if !filter.includes(day) {
+			continue
+		}
+		paths, err := o.Source.Submissions(ctx, day)
+		if err != nil {
+			return fmt.Errorf("error listing submissions of %s: %w", day, err)
+		}
+		// Submissions are read concurrently, and emitted in order
+		for start := 0; start < len(paths); start += EXPORT_READ_CONCURRENCY {
+			batch := paths[start:min(len(paths), start+EXPORT_READ_CONCURRENCY)]
+			records := make([]ExportRecord, len(batch))
+			errs := make([]error, len(batch))
+			var wg sync.WaitGroup
+			for i, path := range batch {
+				wg.Add(1)
+				go func(i int, path string) {
+					defer wg.Done()
+					records[i], errs[i] = o.read(ctx, path)
+				}(i, path)
+			}
+			wg.Wait()
+			for i := range batch {
+				if errs[i] != nil {
+					return fmt.Errorf("error reading submission %s: %w", batch[i], errs[i])
+				}
+				if err := emit(records[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (o ObjectStorageExport) read(ctx context.Context, path string) (ExportRecord, error) {
+	bs, err := o.Source.Read(ctx, path)
+	if err != nil {
+		return ExportRecord{}, err
+	}
+	submission, err := parseSubmissionBytes(bs, path)
+	if err != nil {
+		return ExportRecord{}, err
+	}
+	return ExportRecord{Submitter: submission.Submitter, CreatedAt: submission.CreatedAt, BlockHash: submission.BlockHash}, nil
+}
+
+// Export reads the submissions table, by day of submission
+func (ctx *PostgreSQLContext) Export(c context.Context, filter ReplayFilter, emit func(ExportRecord) error) error {
+	since, until := filter.Since, filter.Until
+	if since == "" {
+		since = "0001-01-01"
+	}
+	if until == "" {
+		until = "9999-12-31"
+	}
+	rows, err := ctx.DB.QueryContext(c, `SELECT submitter, created_at, block_hash, slot FROM submissions
+		WHERE submitted_at_date BETWEEN $1 AND $2 ORDER BY submitted_at, submitter`, since, until)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var record ExportRecord
+		var createdAt sql.NullTime
+		var blockHash sql.NullString
+		if err := rows.Scan(&record.Submitter, &createdAt, &blockHash, &record.Slot); err != nil {
+			return err
+		}
+		record.CreatedAt, record.BlockHash = createdAt.Time, blockHash.String
+		if err := emit(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Export reads the partitions of the submissions table of every day of
+// the filter, both bounds are required as Keyspaces can't list the days
+// stored. Partitions of a day are in order of submission, rows within a
+// partition as well.
+func (kc *KeyspaceContext) Export(ctx context.Context, filter ReplayFilter, emit func(ExportRecord) error) error {
+	since, err := time.Parse("2006-01-02", filter.Since)
+	if err != nil {
+		return fmt.Errorf("first day of the export is required: %w", err)
+	}
+	until, err := time.Parse("2006-01-02", filter.Until)
+	if err != nil {
+		return fmt.Errorf("last day of the export is required: %w", err)
+	}
+	query := "SELECT submitter, created_at, block_hash, slot FROM " + kc.Keyspace + ".submissions WHERE submitted_at_date = ? AND shard = ?"
+	for day := since; !day.After(until); day = day.AddDate(0, 0, 1) {
+		for shard := 0; shard < KEYSPACES_SHARDS_PER_DAY; shard++ {
+			var records []ExportRecord
+			err := kc.Session.Run(func(session *gocql.Session) error {
+				records = records[:0]
+				iter := session.Query(query, day.Format("2006-01-02"), shard).WithContext(ctx).Iter()
+				for {
+					// Slots of submissions not validated yet are null
+					var record ExportRecord
+					if !iter.Scan(&record.Submitter, &record.CreatedAt, &record.BlockHash, &record.Slot) {
+						break
+					}
+					records = append(records, record)
+				}
+				return iter.Close()
+			})
+			if err != nil {
+				return fmt.Errorf("error reading submissions of %s: %w", day.Format("2006-01-02"), err)
+			}
+			for _, record := range records {
+				if err := emit(record); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ExportWriter writes records of an export in a format
+type ExportWriter interface {
+	Write(record ExportRecord) error
+	// Flush what is buffered, the underlying writer is left open
+	Close() error
+}
+
+func NewExportWriter(format string, w io.Writer) (ExportWriter, error) {
+	switch format {
+	case EXPORT_FORMAT_CSV:
+		cw := csv.NewWriter(w)
+		return &csvExportWriter{w: cw}, cw.Write([]string{"submitter", "created_at", "block_hash", "slot"})
+	case EXPORT_FORMAT_JSONL:
+		bw := bufio.NewWriter(w)
+		return &jsonlExportWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case EXPORT_FORMAT_PARQUET:
+		return newParquetExportWriter(w)
+	}
+	return nil, fmt.Errorf("unknown format %q, expected %s, %s or %s", format, EXPORT_FORMAT_CSV, EXPORT_FORMAT_JSONL, EXPORT_FORMAT_PARQUET)
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvExportWriter) Write(record ExportRecord) error {
+	slot := ""
+	if record.Slot != nil {
+		slot = strconv.Itoa(*record.Slot)
+	}
+	return c.w.Write([]string{record.Submitter, record.CreatedAt.UTC().Format(time.RFC3339Nano), record.BlockHash, slot})
+}
+
+func (c *csvExportWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlExportWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (j *jsonlExportWriter) Write(record ExportRecord) error {
+	record.CreatedAt = record.CreatedAt.UTC()
+	return j.enc.Encode(record)
+}
+
+func (j *jsonlExportWriter) Close() error {
+	return j.w.Flush()
+}
+
+// Row of Parquet exports, created_at as milliseconds since the epoch and
+// a nullable slot
+type parquetExportRow struct {
+	Submitter string    `parquet:"submitter"`
+	CreatedAt time.Time `parquet:"created_at,timestamp(millisecond)"`
+	BlockHash string    `parquet:"block_hash"`
+	Slot      *int32    `parquet:"slot,optional"`
+}
+
+type parquetExportWriter struct {
+	w *parquet.GenericWriter[parquetExportRow]
+}
+
+func newParquetExportWriter(w io.Writer) (*parquetExportWriter, error) {
+	return &parquetExportWriter{w: parquet.NewGenericWriter[parquetExportRow](w, parquet.MaxRowsPerRowGroup(EXPORT_PARQUET_ROW_GROUP_SIZE))}, nil
+}
+
+func (e *parquetExportWriter) Write(record ExportRecord) error {
+	row := parquetExportRow{Submitter: record.Submitter, CreatedAt: record.CreatedAt.UTC(), BlockHash: record.BlockHash}
+	if record.Slot != nil {
+		slot := int32(*record.Slot)
+		row.Slot = &slot
+	}
+	_, err := e.w.Write([]parquetExportRow{row})
+	return err
+}
+
+func (e *parquetExportWriter) Close() error {
+	return e.w.Close()
+}
@@ -0,0 +1,92 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestObjectStorageExport(t *testing.T) {
+	dir := t.TempDir()
+	if err := LocalFileSystemSave(ObjectsToSave{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": []byte(`{"submitter":"B62qa","created_at":"2024-05-01T09:59:58Z","block_hash":"3NK1"}`),
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json": []byte(`{"submitter":"B62qa","created_at":"2024-05-02T09:59:58Z","block_hash":"3NK2"}`),
+		"submissions/2024-05-02/2024-05-02T11:00:00Z-B62qb.json": []byte(`{"submitter":"B62qb","created_at":"2024-05-02T10:59:59.5Z","block_hash":"3NK3"}`),
+		"blocks/3NK1.dat": []byte("block1"),
+	}, dir, logging.Logger("test")); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	w, err := NewExportWriter(EXPORT_FORMAT_CSV, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := ObjectStorageExport{Source: LocalFileSystemSource{Directory: dir}}
+	if err := source.Export(context.Background(), ReplayFilter{Since: "2024-05-02"}, w.Write); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "submitter,created_at,block_hash,slot\n" +
+		"B62qa,2024-05-02T09:59:58Z,3NK2,\n" +
+		"B62qb,2024-05-02T10:59:59.5Z,3NK3,\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected export:\n%s", out.String())
+	}
+}
+
+func TestExportWriters(t *testing.T) {
+	slot := 42
+	records := []ExportRecord{
+		{Submitter: "B62qa", CreatedAt: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), BlockHash: "3NK1", Slot: &slot},
+		{Submitter: "B62qb", CreatedAt: time.Date(2024, 5, 1, 10, 0, 1, 0, time.UTC), BlockHash: "3NK2"},
+	}
+	export := func(format string) []byte {
+		var out bytes.Buffer
+		w, err := NewExportWriter(format, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, record := range records {
+			if err := w.Write(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+
+	jsonl := string(export(EXPORT_FORMAT_JSONL))
+	if jsonl != `{"submitter":"B62qa","created_at":"2024-05-01T10:00:00Z","block_hash":"3NK1","slot":42}`+"\n"+
+		`{"submitter":"B62qb","created_at":"2024-05-01T10:00:01Z","block_hash":"3NK2","slot":null}`+"\n" {
+		t.Fatalf("unexpected JSONL:\n%s", jsonl)
+	}
+
+	bs := export(EXPORT_FORMAT_PARQUET)
+	rows, err := parquet.Read[parquetExportRow](bytes.NewReader(bs), int64(len(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Submitter != "B62qa" || !rows[0].CreatedAt.Equal(records[0].CreatedAt) || rows[0].BlockHash != "3NK1" ||
+		rows[0].Slot == nil || *rows[0].Slot != 42 || rows[1].Slot != nil {
+		t.Fatalf("unexpected Parquet rows %+v", rows)
+	}
+	file, err := parquet.OpenFile(bytes.NewReader(bs), int64(len(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if createdAt, _ := file.Schema().Lookup("created_at"); createdAt.Node.Type().LogicalType().Timestamp == nil {
+		t.Fatalf("expected created_at a timestamp, got %v", createdAt.Node.Type())
+	}
+
+	if _, err := NewExportWriter("xlsx", &bytes.Buffer{}); err == nil {
+		t.Fatal("expected an unknown format rejected")
+	}
+}
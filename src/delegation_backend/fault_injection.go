@@ -0,0 +1,164 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Names of the storage backends faults are injected into, as of the
+// outcomes of saving submissions
+var FAULT_INJECTION_BACKENDS = []string{"s3", "keyspaces", "postgresql", "filesystem", "nats", "memory"}
+
+// ErrInjectedFault is the error of faults injected
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultInjector injects the faults of its configuration into saving to
+// storage backends, refreshing the delegation whitelist and the clock of
+// the backend, for chaos and integration tests to exercise error paths
+// without breaking real infrastructure
+type FaultInjector struct {
+	config *FaultInjectionConfig
+	log    logging.StandardLogger
+	// Random number in [0, 1), deciding whether a fault is injected
+	rand func() float64
+	// Sleeps for the duration, unless ctx is done first
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func NewFaultInjector(config *FaultInjectionConfig, log logging.StandardLogger) *FaultInjector {
+	return &FaultInjector{config: config, log: log, rand: rand.Float64, sleep: sleepContext}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Whether a fault of the rate is injected
+func (f *FaultInjector) fails(rate float64) bool {
+	return rate > 0 && f.rand() < rate
+}
+
+func (f *FaultInjector) inject(format string, args ...interface{}) error {
+	incMetric("faults_injected")
+	err := fmt.Errorf("%w: %s", ErrInjectedFault, fmt.Sprintf(format, args...))
+	f.log.Warnf("Fault injection: %v", err)
+	return err
+}
+
+// WrapSave delays saving to the backend, and fails it at the error rate
+// of the backend, objects aren't saved when it fails. Delays end once the
+// context of the save is done, as with a slow backend.
+func (f *FaultInjector) WrapSave(backend string, save func(context.Context, ObjectsToSave) error) func(context.Context, ObjectsToSave) error {
+	config := f.config.Storage[backend]
+	if config == nil {
+		return save
+	}
+	return func(ctx context.Context, objs ObjectsToSave) error {
+		if latency := config.Latency(f.rand); latency > 0 {
+			if err := f.sleep(ctx, latency); err != nil {
+				return err
+			}
+		}
+		if f.fails(config.ErrorRate) {
+			return f.inject("saving %d objects to %s", len(objs), backend)
+		}
+		return save(ctx, objs)
+	}
+}
+
+// WrapWhitelist fails retrievals of the delegation whitelist at the
+// whitelist error rate, as an outage of Google Sheets would
+func (f *FaultInjector) WrapWhitelist(retrieve func(retries int) (Whitelist, error)) func(retries int) (Whitelist, error) {
+	if f.config.WhitelistErrorRate <= 0 {
+		return retrieve
+	}
+	return func(retries int) (Whitelist, error) {
+		if f.fails(f.config.WhitelistErrorRate) {
+			return nil, f.inject("retrieving the delegation whitelist")
+		}
+		return retrieve(retries)
+	}
+}
+
+// Now is the clock skewed by the clock skew of the configuration
+func (f *FaultInjector) Now(now nowFunc) nowFunc {
+	skew := time.Duration(f.config.ClockSkewSeconds) * time.Second
+	if skew == 0 {
+		return now
+	}
+	return func() time.Time { return now().Add(skew) }
+}
+
+func (f *FaultInjector) String() string {
+	var faults []string
+	backends := make([]string, 0, len(f.config.Storage))
+	for backend := range f.config.Storage {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	for _, backend := range backends {
+		config := f.config.Storage[backend]
+		faults = append(faults, fmt.Sprintf("%s latency %dms (+%dms), error rate %v", backend, config.LatencyMs, config.LatencyJitterMs, config.ErrorRate))
+	}
+	if f.config.WhitelistErrorRate > 0 {
+		faults = append(faults, fmt.Sprintf("whitelist error rate %v", f.config.WhitelistErrorRate))
+	}
+	if f.config.ClockSkewSeconds != 0 {
+		faults = append(faults, fmt.Sprintf("clock skew %ds", f.config.ClockSkewSeconds))
+	}
+	if len(faults) == 0 {
+		return "none"
+	}
+	return strings.Join(faults, ", ")
+}
+
+// Latency of a save, the latency and a random part of the jitter
+func (config *StorageFaultConfig) Latency(random func() float64) time.Duration {
+	latency := time.Duration(config.LatencyMs) * time.Millisecond
+	if config.LatencyJitterMs > 0 {
+		latency += time.Duration(random() * float64(time.Duration(config.LatencyJitterMs)*time.Millisecond))
+	}
+	return latency
+}
+
+// Problems of the fault injection configuration
+func validateFaultInjectionConfig(config *FaultInjectionConfig) []string {
+	var problems []string
+	for backend, storage := range config.Storage {
+		known := false
+		for _, name := range FAULT_INJECTION_BACKENDS {
+			known = known || name == backend
+		}
+		if !known {
+			problems = append(problems, fmt.Sprintf("unknown storage backend %q, expected one of %s", backend, strings.Join(FAULT_INJECTION_BACKENDS, ", ")))
+			continue
+		}
+		if storage == nil {
+			continue
+		}
+		if storage.LatencyMs < 0 || storage.LatencyJitterMs < 0 {
+			problems = append(problems, fmt.Sprintf("latency of %s: expected positive numbers, got %dms and %dms of jitter", backend, storage.LatencyMs, storage.LatencyJitterMs))
+		}
+		if storage.ErrorRate < 0 || storage.ErrorRate > 1 {
+			problems = append(problems, fmt.Sprintf("error rate of %s: expected between 0 and 1, got %v", backend, storage.ErrorRate))
+		}
+	}
+	if config.WhitelistErrorRate < 0 || config.WhitelistErrorRate > 1 {
+		problems = append(problems, fmt.Sprintf("whitelist error rate: expected between 0 and 1, got %v", config.WhitelistErrorRate))
+	}
+	return problems
+}
@@ -0,0 +1,115 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func testFaultInjector(config *FaultInjectionConfig, random float64) (*FaultInjector, *time.Duration) {
+	f := NewFaultInjector(config, logging.Logger("delegation backend test"))
+	f.rand = func() float64 { return random }
+	slept := new(time.Duration)
+	f.sleep = func(_ context.Context, d time.Duration) error {
+		*slept += d
+		return nil
+	}
+	return f, slept
+}
+
+func TestFaultInjectionStorage(t *testing.T) {
+	config := &FaultInjectionConfig{Storage: map[string]*StorageFaultConfig{
+		"s3":        {LatencyMs: 200, LatencyJitterMs: 100},
+		"keyspaces": {ErrorRate: 0.5},
+	}}
+	saved := make(map[string]int)
+	backends := map[string]func(context.Context, ObjectsToSave) error{}
+	for _, backend := range []string{"s3", "keyspaces", "filesystem"} {
+		backend := backend
+		backends[backend] = func(_ context.Context, objs ObjectsToSave) error {
+			saved[backend]++
+			return nil
+		}
+	}
+	f, slept := testFaultInjector(config, 0.4)
+	for backend, save := range backends {
+		backends[backend] = f.WrapSave(backend, save)
+	}
+	outcomes := SaveToBackends(context.Background(), ObjectsToSave{"blocks/3NK.dat": []byte("block")}, backends, false, false)
+	if !errors.Is(outcomes["keyspaces"], ErrInjectedFault) || outcomes["s3"] != nil || outcomes["filesystem"] != nil {
+		t.Fatalf("expected saving to keyspaces failed only, got %v", outcomes)
+	}
+	if saved["keyspaces"] != 0 || saved["s3"] != 1 || saved["filesystem"] != 1 {
+		t.Fatalf("expected objects not saved by a failing backend, got %v", saved)
+	}
+	if *slept != 240*time.Millisecond {
+		t.Fatalf("expected saving to s3 delayed by its latency and jitter, got %v", *slept)
+	}
+
+	// Above the error rate, saves go through
+	f, _ = testFaultInjector(config, 0.5)
+	if err := f.WrapSave("keyspaces", backends["filesystem"])(context.Background(), ObjectsToSave{}); err != nil {
+		t.Fatalf("expected the save to go through, got %v", err)
+	}
+}
+
+func TestFaultInjectionWhitelist(t *testing.T) {
+	retrieve := func(retries int) (Whitelist, error) { return Whitelist{Pk{}: nil}, nil }
+	f, _ := testFaultInjector(&FaultInjectionConfig{WhitelistErrorRate: 1}, 0.99)
+	if _, err := f.WrapWhitelist(retrieve)(10); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("expected an outage of the whitelist, got %v", err)
+	}
+	f, _ = testFaultInjector(&FaultInjectionConfig{}, 0)
+	if wl, err := f.WrapWhitelist(retrieve)(10); err != nil || len(wl) != 1 {
+		t.Fatalf("expected the whitelist retrieved, got %v, error: %v", wl, err)
+	}
+}
+
+func TestFaultInjectionClockSkew(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	f, _ := testFaultInjector(&FaultInjectionConfig{ClockSkewSeconds: -90}, 0)
+	if skewed := f.Now(func() time.Time { return now })(); !skewed.Equal(now.Add(-90 * time.Second)) {
+		t.Fatalf("expected the clock behind by 90s, got %v", skewed)
+	}
+}
+
+func TestFaultInjectionConfig(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	mockLogger := &MockLogger{}
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"network_name": "devnet", "delegation_whitelist_disabled": true, "filesystem": {"path": "/tmp"},
+		"fault_injection": {"storage": {"s3": {"latency_ms": 500}}}}`), 0644)
+
+	os.Setenv("FAULT_INJECTION_KEYSPACES_ERROR_RATE", "0.25")
+	os.Setenv("FAULT_INJECTION_CLOCK_SKEW_SECONDS", "-30")
+	config := LoadConfig(path, mockLogger)
+	if mockLogger.lastMessage != "" {
+		t.Fatalf("Unexpected fatal error: %s", mockLogger.lastMessage)
+	}
+	faults := config.FaultInjection
+	if faults.Storage["s3"].LatencyMs != 500 || faults.Storage["keyspaces"].ErrorRate != 0.25 || faults.ClockSkewSeconds != -30 {
+		t.Fatalf("Unexpected fault injection configuration %+v", faults)
+	}
+
+	os.Setenv("FAULT_INJECTION_WHITELIST_ERROR_RATE", "2")
+	os.Setenv("CONFIG_NETWORK_NAME", "mainnet")
+	LoadConfig(path, mockLogger)
+	for _, expected := range []string{"whitelist error rate: expected between 0 and 1, got 2", "faults are injected for tests only"} {
+		if !strings.Contains(mockLogger.lastMessage, expected) {
+			t.Errorf("Expected %s in: %s", expected, mockLogger.lastMessage)
+		}
+	}
+
+	os.Clearenv()
+	os.Setenv("FAULT_INJECTION_ENABLED", "0")
+	if config := LoadConfig(path, mockLogger); config.FaultInjection != nil {
+		t.Fatalf("Expected fault injection disabled, got %+v", config.FaultInjection)
+	}
+}
@@ -0,0 +1,235 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Feature flags, governing behaviors rolled out gradually
+const (
+	FEATURE_BLOCK_DEDUP   = "block_dedup"
+	FEATURE_PARALLEL_SAVE = "parallel_save"
+	FEATURE_VERIFY_CACHE  = "verify_cache"
+)
+
+// FeatureFlag is a known flag, with its state when not configured
+type FeatureFlag struct {
+	Name        string
+	Description string
+	Default     bool
+}
+
+var FEATURE_FLAGS = []FeatureFlag{
+	{FEATURE_BLOCK_DEDUP, "Skip saving blocks already in S3", true},
+	{FEATURE_PARALLEL_SAVE, "Save submissions to storage backends concurrently", false},
+	{FEATURE_VERIFY_CACHE, "Use cached results of signature verification, if configured", true},
+}
+
+func knownFeatureFlag(name string) (FeatureFlag, bool) {
+	for _, flag := range FEATURE_FLAGS {
+		if flag.Name == name {
+			return flag, true
+		}
+	}
+	return FeatureFlag{}, false
+}
+
+// ParseFeatureFlags parses flags in the `flag1=1,flag2=0` format of
+// FEATURE_FLAGS, a flag without a value is enabled.
+func ParseFeatureFlags(s string) (map[string]FeatureFlagConfig, error) {
+	flags := make(map[string]FeatureFlagConfig)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, found := strings.Cut(entry, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		switch {
+		case !found || value == "1":
+			flags[name] = FeatureFlagConfig{Enabled: true}
+		case value == "0":
+			flags[name] = FeatureFlagConfig{Enabled: false}
+		default:
+			return nil, fmt.Errorf("malformed feature flag %q, expected flag=0 or flag=1", entry)
+		}
+	}
+	return flags, nil
+}
+
+func validateFeatureFlags(flags map[string]FeatureFlagConfig) error {
+	for name, cfg := range flags {
+		if _, known := knownFeatureFlag(name); !known {
+			return fmt.Errorf("unknown feature flag %q", name)
+		}
+		if cfg.RolloutPercent != nil && (*cfg.RolloutPercent < 0 || *cfg.RolloutPercent > 100) {
+			return fmt.Errorf("rollout_percent of %s is out of range 0-100: %d", name, *cfg.RolloutPercent)
+		}
+	}
+	return nil
+}
+
+// FeatureFlags evaluates flags of the configuration on the network, flags
+// can be overridden at runtime through the admin API. The zero value (and
+// nil) evaluates every flag to its default.
+type FeatureFlags struct {
+	mutex     sync.Mutex
+	network   string
+	config    map[string]FeatureFlagConfig
+	overrides map[string]bool
+}
+
+// FeatureFlagStatus is the state of a flag, as reported by the admin API
+type FeatureFlagStatus struct {
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	Enabled        bool   `json:"enabled"`
+	RolloutPercent int    `json:"rollout_percent"`
+	// default, config or override
+	Source string `json:"source"`
+}
+
+func NewFeatureFlags(network string, config map[string]FeatureFlagConfig) (*FeatureFlags, error) {
+	f := &FeatureFlags{network: network}
+	if err := f.SetConfig(config); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetConfig replaces the configuration of flags, overrides are kept
+func (f *FeatureFlags) SetConfig(config map[string]FeatureFlagConfig) error {
+	if err := validateFeatureFlags(config); err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.config = config
+	return nil
+}
+
+// Evaluate the flag, returning whether it is enabled, the percentage of
+// subjects it is enabled for and where the state comes from
+func (f *FeatureFlags) evaluate(name string) (bool, int, string) {
+	flag, _ := knownFeatureFlag(name)
+	if f == nil {
+		return flag.Default, 100, "default"
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if enabled, exists := f.overrides[name]; exists {
+		return enabled, 100, "override"
+	}
+	cfg, exists := f.config[name]
+	if !exists {
+		return flag.Default, 100, "default"
+	}
+	enabled := cfg.Enabled
+	if len(cfg.Networks) > 0 {
+		onNetwork := false
+		for _, network := range cfg.Networks {
+			onNetwork = onNetwork || network == f.network
+		}
+		enabled = enabled && onNetwork
+	}
+	percent := 100
+	if cfg.RolloutPercent != nil {
+		percent = *cfg.RolloutPercent
+	}
+	return enabled, percent, "config"
+}
+
+// Enabled returns whether the flag is enabled, flags rolled out to
+// a percentage of submitters are enabled only once fully rolled out
+func (f *FeatureFlags) Enabled(name string) bool {
+	enabled, percent, _ := f.evaluate(name)
+	return enabled && percent >= 100
+}
+
+// EnabledFor returns whether the flag is enabled for the subject (e.g. the
+// public key of a submitter). A flag rolled out to a percentage applies
+// to the same subjects as long as the percentage isn't lowered.
+func (f *FeatureFlags) EnabledFor(name string, subject string) bool {
+	enabled, percent, _ := f.evaluate(name)
+	if !enabled || percent <= 0 {
+		return false
+	}
+	if percent >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name + "/" + subject))
+	return int(h.Sum32()%100) < percent
+}
+
+// Override sets the state of the flag until restart, nil
+// drops the override restoring the configured state
+func (f *FeatureFlags) Override(name string, enabled *bool) error {
+	if _, known := knownFeatureFlag(name); !known {
+		return fmt.Errorf("unknown feature flag %q", name)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if enabled == nil {
+		delete(f.overrides, name)
+		return nil
+	}
+	if f.overrides == nil {
+		f.overrides = make(map[string]bool)
+	}
+	f.overrides[name] = *enabled
+	return nil
+}
+
+func (f *FeatureFlags) Status() []FeatureFlagStatus {
+	statuses := make([]FeatureFlagStatus, 0, len(FEATURE_FLAGS))
+	for _, flag := range FEATURE_FLAGS {
+		enabled, percent, source := f.evaluate(flag.Name)
+		statuses = append(statuses, FeatureFlagStatus{
+			Name:           flag.Name,
+			Description:    flag.Description,
+			Enabled:        enabled,
+			RolloutPercent: percent,
+			Source:         source,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+type featureFlagRequest struct {
+	Flag    string `json:"flag"`
+	Enabled *bool  `json:"enabled"`
+}
+
+// AdminHandler serves the administration of feature flags:
+//
+//	GET /admin/feature-flags  returns the state of every flag
+//	PUT /admin/feature-flags  overrides a flag, body `{"flag": "parallel_save", "enabled": true}`,
+//	                          `"enabled": null` drops the override
+func (f *FeatureFlags) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(rw, http.StatusOK, map[string][]FeatureFlagStatus{"flags": f.Status()})
+		case http.MethodPut:
+			var req featureFlagRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Flag == "" {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{"Expected {\"flag\": ..., \"enabled\": true, false or null}"})
+				return
+			}
+			if err := f.Override(req.Flag, req.Enabled); err != nil {
+				writeJSON(rw, http.StatusNotFound, errorResponse{err.Error()})
+				return
+			}
+			writeJSON(rw, http.StatusOK, map[string][]FeatureFlagStatus{"flags": f.Status()})
+		default:
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseFeatureFlags(t *testing.T) {
+	flags, err := ParseFeatureFlags("parallel_save=1, block_dedup=0,verify_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flags[FEATURE_PARALLEL_SAVE].Enabled || flags[FEATURE_BLOCK_DEDUP].Enabled || !flags[FEATURE_VERIFY_CACHE].Enabled {
+		t.Fatalf("unexpected flags: %+v", flags)
+	}
+	if _, err := ParseFeatureFlags("parallel_save=yes"); err == nil {
+		t.Fatal("expected malformed flag")
+	}
+	if err := validateFeatureFlags(FeatureFlagConfigs{"no_such_flag": {Enabled: true}}); err == nil {
+		t.Fatal("expected unknown flag")
+	}
+	percent := 101
+	if err := validateFeatureFlags(FeatureFlagConfigs{FEATURE_PARALLEL_SAVE: {Enabled: true, RolloutPercent: &percent}}); err == nil {
+		t.Fatal("expected rollout out of range")
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	var unset *FeatureFlags
+	if !unset.Enabled(FEATURE_BLOCK_DEDUP) || unset.Enabled(FEATURE_PARALLEL_SAVE) {
+		t.Fatal("expected defaults without flags")
+	}
+
+	percent := 30
+	flags, err := NewFeatureFlags("mainnet", FeatureFlagConfigs{
+		FEATURE_PARALLEL_SAVE: {Enabled: true, Networks: []string{"devnet"}},
+		FEATURE_BLOCK_DEDUP:   {Enabled: false},
+		FEATURE_VERIFY_CACHE:  {Enabled: true, RolloutPercent: &percent},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Enabled(FEATURE_PARALLEL_SAVE) || flags.Enabled(FEATURE_BLOCK_DEDUP) {
+		t.Fatal("expected flags disabled on mainnet")
+	}
+	if flags.Enabled(FEATURE_VERIFY_CACHE) {
+		t.Fatal("expected partially rolled out flag disabled globally")
+	}
+
+	// Rollout applies to the same subjects on every evaluation
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		subject := fmt.Sprintf("B62q%d", i)
+		first := flags.EnabledFor(FEATURE_VERIFY_CACHE, subject)
+		if first != flags.EnabledFor(FEATURE_VERIFY_CACHE, subject) {
+			t.Fatalf("rollout of %s is not deterministic", subject)
+		}
+		if first {
+			enabled++
+		}
+	}
+	if enabled < 200 || enabled > 400 {
+		t.Fatalf("expected about 30%% of subjects, got %d of 1000", enabled)
+	}
+
+	on, off := true, false
+	if err := flags.Override(FEATURE_PARALLEL_SAVE, &on); err != nil || !flags.Enabled(FEATURE_PARALLEL_SAVE) {
+		t.Fatalf("expected override enabling the flag, error: %v", err)
+	}
+	if err := flags.Override(FEATURE_VERIFY_CACHE, &off); err != nil || flags.EnabledFor(FEATURE_VERIFY_CACHE, "B62q1") {
+		t.Fatalf("expected override disabling the flag, error: %v", err)
+	}
+	// Overrides are kept on reload of the config
+	if err := flags.SetConfig(nil); err != nil || !flags.Enabled(FEATURE_PARALLEL_SAVE) || !flags.Enabled(FEATURE_BLOCK_DEDUP) {
+		t.Fatalf("unexpected flags after reload, error: %v", err)
+	}
+	if err := flags.Override(FEATURE_PARALLEL_SAVE, nil); err != nil || flags.Enabled(FEATURE_PARALLEL_SAVE) {
+		t.Fatalf("expected override dropped, error: %v", err)
+	}
+	if err := flags.Override("no_such_flag", &on); err == nil {
+		t.Fatal("expected unknown flag")
+	}
+}
+
+func TestFeatureFlagsAdminHandler(t *testing.T) {
+	flags, _ := NewFeatureFlags("devnet", nil)
+	handler := flags.AdminHandler()
+	put := func(body string) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("PUT", "/admin/feature-flags", strings.NewReader(body)))
+		return rr.Code
+	}
+	if code := put(`{"flag": "parallel_save", "enabled": true}`); code != 200 {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if code := put(`{"flag": "no_such_flag", "enabled": true}`); code != 404 {
+		t.Fatalf("expected unknown flag, got %d", code)
+	}
+	if code := put(`{"enabled": true}`); code != 400 {
+		t.Fatalf("expected missing flag, got %d", code)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/feature-flags", nil))
+	var res struct {
+		Flags []FeatureFlagStatus `json:"flags"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Flags) != len(FEATURE_FLAGS) {
+		t.Fatalf("expected every flag reported, got %+v", res.Flags)
+	}
+	for _, status := range res.Flags {
+		if status.Name == FEATURE_PARALLEL_SAVE && (!status.Enabled || status.Source != "override") {
+			t.Fatalf("unexpected status: %+v", status)
+		}
+	}
+}
+
+func TestSaveToBackends(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		outcomes := SaveToBackends(context.Background(), ObjectsToSave{"a": nil}, map[string]func(context.Context, ObjectsToSave) error{
+			"ok":     func(context.Context, ObjectsToSave) error { return nil },
+			"failed": func(context.Context, ObjectsToSave) error { return errors.New("unavailable") },
+		}, parallel, false)
+		if len(outcomes) != 2 || outcomes["ok"] != nil || outcomes["failed"] == nil {
+			t.Fatalf("unexpected outcomes (parallel: %v): %v", parallel, outcomes)
+		}
+	}
+
+	// Retained objects are copied once, out of the buffer of the request
+	buf := []byte("block")
+	var saved [][]byte
+	keep := func(_ context.Context, objs ObjectsToSave) error {
+		saved = append(saved, objs["blocks/3NK.dat"])
+		return nil
+	}
+	for _, retained := range []bool{false, true} {
+		saved = nil
+		SaveToBackends(context.Background(), ObjectsToSave{"blocks/3NK.dat": buf}, map[string]func(context.Context, ObjectsToSave) error{
+			"memory": keep,
+			"nats":   keep,
+		}, false, retained)
+		if len(saved) != 2 || &saved[0][0] != &saved[1][0] || (&saved[0][0] == &buf[0]) != !retained {
+			t.Fatalf("unexpected values saved (retained: %v)", retained)
+		}
+	}
+	copy(buf, "reuse")
+	if string(saved[0]) != "block" {
+		t.Fatalf("retained value aliases the buffer: %q", saved[0])
+	}
+}
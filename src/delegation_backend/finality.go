@@ -0,0 +1,421 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Storage prefix under which the statuses of the blocks of days of
+// submissions are saved
+const FINALITY_PREFIX = "finality/"
+
+const FINALITY_DEFAULT_INTERVAL = time.Hour
+
+// Delay after the end of a day after which its blocks are final by
+// default: blocks are final once k (290) blocks are built on top of them,
+// about 15 hours of mainnet slots at full density
+const FINALITY_DEFAULT_AFTER = 24 * time.Hour
+
+// State hashes looked up by query of the archive
+const FINALITY_QUERY_BATCH = 100
+
+const FINALITY_QUERY_TIMEOUT = 30 * time.Second
+
+// Statuses of blocks once final
+const (
+	BLOCK_STATUS_CANONICAL = "canonical"
+	BLOCK_STATUS_ORPHANED  = "orphaned"
+	// Without state hash reported by submitters, or not in the archive
+	BLOCK_STATUS_UNKNOWN = "unknown"
+)
+
+// Query of the canonical status of blocks, of the GraphQL schema of
+// archives as served by MinaExplorer
+const archiveBlocksQuery = `query Blocks($stateHashes: [String!], $limit: Int) {
+  blocks(query: {stateHash_in: $stateHashes}, limit: $limit) { stateHash canonical }
+}`
+
+// DayFinality of the blocks of the submissions of a day, saved as
+// `finality/<day>.json` once the blocks are final. Blocks are told
+// canonical by the state hashes reported by submitters: a block is
+// canonical if any state hash reported for it is, orphaned if every state
+// hash reported for it is in the archive and none is canonical.
+type DayFinality struct {
+	Day       string    `json:"day"`
+	CheckedAt time.Time `json:"checked_at"`
+	// Status by block hash
+	Blocks map[string]string `json:"blocks"`
+	// Times of submission (as in paths) of the submissions of orphaned
+	// blocks by submitter, sorted
+	Orphaned map[string][]string `json:"orphaned"`
+}
+
+// Interval between runs of the checker
+func (cfg *FinalityConfig) Interval() time.Duration {
+	if cfg.IntervalMinutes > 0 {
+		return time.Duration(cfg.IntervalMinutes) * time.Minute
+	}
+	return FINALITY_DEFAULT_INTERVAL
+}
+
+// Delay after the end of a day after which its blocks are checked
+func (cfg *FinalityConfig) After() time.Duration {
+	if cfg.AfterHours > 0 {
+		return time.Duration(cfg.AfterHours) * time.Hour
+	}
+	return FINALITY_DEFAULT_AFTER
+}
+
+// Path of the statuses of the blocks of the day, relative to the root of
+// the storage
+func FinalityPath(day string) string {
+	return FINALITY_PREFIX + day + ".json"
+}
+
+// ReadDayFinality of the day from storage, the error of a day not checked
+// wraps fs.ErrNotExist
+func ReadDayFinality(ctx context.Context, source StorageSource, day string) (*DayFinality, error) {
+	bs, err := source.Read(ctx, FinalityPath(day))
+	if err != nil {
+		return nil, err
+	}
+	var finality DayFinality
+	if err := json.Unmarshal(bs, &finality); err != nil {
+		return nil, fmt.Errorf("error unmarshaling finality of %s: %w", day, err)
+	}
+	return &finality, nil
+}
+
+// ArchiveClient looks up blocks by state hash in the GraphQL API of a Mina
+// archive
+type ArchiveClient struct {
+	URL    string
+	Client *http.Client
+}
+
+type archiveBlocksResponse struct {
+	Data struct {
+		Blocks []struct {
+			StateHash string `json:"stateHash"`
+			Canonical bool   `json:"canonical"`
+		} `json:"blocks"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// Canonical tells which of the blocks of the state hashes are canonical.
+// Blocks not in the archive are missing from the result.
+func (c *ArchiveClient) Canonical(ctx context.Context, stateHashes []string) (map[string]bool, error) {
+	canonical := make(map[string]bool)
+	for start := 0; start < len(stateHashes); start += FINALITY_QUERY_BATCH {
+		batch := stateHashes[start:min(len(stateHashes), start+FINALITY_QUERY_BATCH)]
+		body, err := json.Marshal(map[string]interface{}{
+			"query":     archiveBlocksQuery,
+			"variables": map[string]interface{}{"stateHashes": batch, "limit": len(batch)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var blocks archiveBlocksResponse
+		err = json.NewDecoder(resp.Body).Decode(&blocks)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("archive responded with %s", resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding the response of the archive: %w", err)
+		}
+		if len(blocks.Errors) > 0 {
+			return nil, fmt.Errorf("archive responded with error: %s", blocks.Errors[0].Message)
+		}
+		for _, block := range blocks.Data.Blocks {
+			canonical[block.StateHash] = canonical[block.StateHash] || block.Canonical
+		}
+	}
+	return canonical, nil
+}
+
+// FinalityChecker records whether the blocks of the submissions of an
+// object storage are canonical or orphaned, once final, so that
+// submissions of orphaned forks are told apart. Days are checked once, the
+// leader checks days whose blocks are final and not checked yet.
+type FinalityChecker struct {
+	Source StorageSource
+	// Saves the statuses of the blocks of days
+	Save    func(ObjectsToSave) error
+	Archive *ArchiveClient
+	After   time.Duration
+	mutex   sync.Mutex
+	// Days checked, by this replica or another
+	checked map[string]bool
+	// Blocks checked by status since the start
+	blocks map[string]int64
+	now    nowFunc
+	log    logging.StandardLogger
+}
+
+func NewFinalityChecker(source StorageSource, save func(ObjectsToSave) error, config *FinalityConfig, now nowFunc, log logging.StandardLogger) *FinalityChecker {
+	return &FinalityChecker{
+		Source:  source,
+		Save:    save,
+		Archive: &ArchiveClient{URL: config.ArchiveURL, Client: &http.Client{Timeout: FINALITY_QUERY_TIMEOUT}},
+		After:   config.After(),
+		checked: make(map[string]bool),
+		blocks:  make(map[string]int64),
+		now:     now,
+		log:     log,
+	}
+}
+
+// Run checks the days whose blocks are final and not checked yet,
+// returning the days checked
+func (c *FinalityChecker) Run(ctx context.Context) ([]string, error) {
+	days, err := c.Source.Days(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing days of submissions: %w", err)
+	}
+	now := c.now()
+	var checked []string
+	for _, day := range days {
+		c.mutex.Lock()
+		done := c.checked[day]
+		c.mutex.Unlock()
+		if done {
+			continue
+		}
+		dayStart, err := time.Parse("2006-01-02", day)
+		if err != nil || now.Before(dayStart.Add(24*time.Hour+INDEX_SETTLE_DELAY+c.After)) {
+			continue
+		}
+		_, err = ReadDayFinality(ctx, c.Source, day)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return checked, err
+		}
+		if err != nil {
+			if _, err := c.CheckDay(ctx, day); err != nil {
+				return checked, fmt.Errorf("error checking %s: %w", day, err)
+			}
+			checked = append(checked, day)
+		}
+		c.mutex.Lock()
+		c.checked[day] = true
+		c.mutex.Unlock()
+	}
+	return checked, nil
+}
+
+// CheckDay looks up the blocks of the submissions of the day in the
+// archive and saves their statuses
+func (c *FinalityChecker) CheckDay(ctx context.Context, day string) (*DayFinality, error) {
+	paths, err := c.Source.Submissions(ctx, day)
+	if err != nil {
+		return nil, fmt.Errorf("error listing submissions: %w", err)
+	}
+	// State hashes reported by block hash
+	reported := make(map[string]map[string]bool)
+	// Block hash by path
+	blockOf := make(map[string]string)
+	err = readConcurrently(ctx, c.Source, paths, func(metaPath string, bs []byte, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading submission %s: %w", metaPath, err)
+		}
+		var meta struct {
+			BlockHash string `json:"block_hash"`
+			StateHash string `json:"state_hash"`
+		}
+		if err := json.Unmarshal(bs, &meta); err != nil || meta.BlockHash == "" {
+			c.log.Warnf("Checking %s without its block: can't tell the block of the submission", metaPath)
+			return nil
+		}
+		blockOf[metaPath] = meta.BlockHash
+		if reported[meta.BlockHash] == nil {
+			reported[meta.BlockHash] = make(map[string]bool)
+		}
+		if meta.StateHash != "" {
+			reported[meta.BlockHash][meta.StateHash] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var stateHashes []string
+	for _, hashes := range reported {
+		for stateHash := range hashes {
+			stateHashes = append(stateHashes, stateHash)
+		}
+	}
+	sort.Strings(stateHashes)
+	canonical, err := c.Archive.Canonical(ctx, stateHashes)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up blocks in the archive: %w", err)
+	}
+
+	finality := &DayFinality{
+		Day:       day,
+		CheckedAt: c.now(),
+		Blocks:    make(map[string]string),
+		Orphaned:  make(map[string][]string),
+	}
+	for blockHash, hashes := range reported {
+		status := BLOCK_STATUS_UNKNOWN
+		if len(hashes) > 0 {
+			status = BLOCK_STATUS_ORPHANED
+		}
+		for stateHash := range hashes {
+			isCanonical, found := canonical[stateHash]
+			if isCanonical {
+				status = BLOCK_STATUS_CANONICAL
+				break
+			}
+			if !found {
+				status = BLOCK_STATUS_UNKNOWN
+			}
+		}
+		finality.Blocks[blockHash] = status
+	}
+	for metaPath, blockHash := range blockOf {
+		if finality.Blocks[blockHash] != BLOCK_STATUS_ORPHANED {
+			continue
+		}
+		if t, submitter, ok := splitSubmissionPath(metaPath); ok {
+			finality.Orphaned[submitter] = append(finality.Orphaned[submitter], t)
+		}
+	}
+	for _, times := range finality.Orphaned {
+		sort.Strings(times)
+	}
+	bs, err := json.Marshal(finality)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Save(ObjectsToSave{FinalityPath(day): bs}); err != nil {
+		return nil, fmt.Errorf("error saving finality: %w", err)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, status := range finality.Blocks {
+		c.blocks[status]++
+	}
+	return finality, nil
+}
+
+// Check every interval while leader, so that the archive is queried by a
+// single replica
+func (c *FinalityChecker) RunLoop(ctx context.Context, interval time.Duration, election *LeaderElection) {
+	for {
+		if election.IsLeader() {
+			if checked, err := c.Run(ctx); err != nil {
+				incMetric("finality_errors")
+				c.log.Errorf("Failed to check the finality of blocks: %v", err)
+			} else if len(checked) > 0 {
+				c.log.Debugf("Checked the finality of blocks of %s", strings.Join(checked, ", "))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Summary of the statuses of the blocks of a day
+type dayFinalitySummary struct {
+	Day       string         `json:"day"`
+	CheckedAt time.Time      `json:"checked_at"`
+	Blocks    map[string]int `json:"blocks"`
+	// Submissions of orphaned blocks
+	Orphaned int `json:"orphaned_submissions"`
+}
+
+// Handler serves the statuses of the blocks of days at
+// /v1/finality/<day>: the number of blocks by status, the status of a
+// block with `?block=<hash>` or the submissions of orphaned blocks of a
+// submitter with `?submitter=<public key>`
+func (c *FinalityChecker) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		day := path.Base(r.URL.Path)
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			writeJSON(rw, http.StatusBadRequest, errorResponse{"Invalid day, expected YYYY-MM-DD"})
+			return
+		}
+		finality, err := ReadDayFinality(r.Context(), c.Source, day)
+		if errors.Is(err, fs.ErrNotExist) {
+			writeJSON(rw, http.StatusNotFound, errorResponse{"Blocks of the day not checked"})
+			return
+		}
+		if err != nil {
+			writeJSON(rw, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		query := r.URL.Query()
+		switch {
+		case query.Get("block") != "":
+			status, ok := finality.Blocks[query.Get("block")]
+			if !ok {
+				writeJSON(rw, http.StatusNotFound, errorResponse{"Block not referenced on the day"})
+				return
+			}
+			writeJSON(rw, http.StatusOK, map[string]interface{}{"day": day, "block": query.Get("block"), "status": status})
+		case query.Get("submitter") != "":
+			submitter := query.Get("submitter")
+			orphaned := finality.Orphaned[submitter]
+			if orphaned == nil {
+				orphaned = []string{}
+			}
+			writeJSON(rw, http.StatusOK, map[string]interface{}{"day": day, "submitter": submitter, "orphaned": orphaned})
+		default:
+			summary := dayFinalitySummary{Day: day, CheckedAt: finality.CheckedAt, Blocks: make(map[string]int)}
+			for _, status := range finality.Blocks {
+				summary.Blocks[status]++
+			}
+			for _, times := range finality.Orphaned {
+				summary.Orphaned += len(times)
+			}
+			writeJSON(rw, http.StatusOK, summary)
+		}
+	})
+}
+
+// WritePrometheus writes the blocks checked since the start by status
+func (c *FinalityChecker) WritePrometheus(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	fmt.Fprint(w, "# HELP uptime_finality_blocks_total Blocks of submissions checked against the archive, by status.\n")
+	fmt.Fprint(w, "# TYPE uptime_finality_blocks_total counter\n")
+	for _, status := range []string{BLOCK_STATUS_CANONICAL, BLOCK_STATUS_ORPHANED, BLOCK_STATUS_UNKNOWN} {
+		fmt.Fprintf(w, "uptime_finality_blocks_total{status=\"%s\"} %d\n", status, c.blocks[status])
+	}
+}
@@ -0,0 +1,142 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestFinalityChecker(t *testing.T) {
+	canonical, orphaned := mainnetGenesisStateHash, testnetGenesisStateHash
+	queries := 0
+	archive := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		queries++
+		var req struct {
+			Variables struct {
+				StateHashes []string `json:"stateHashes"`
+			} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		var blocks []map[string]interface{}
+		for _, stateHash := range req.Variables.StateHashes {
+			switch stateHash {
+			case canonical:
+				blocks = append(blocks, map[string]interface{}{"stateHash": stateHash, "canonical": true})
+			case orphaned:
+				blocks = append(blocks, map[string]interface{}{"stateHash": stateHash, "canonical": false})
+			}
+		}
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"blocks": blocks}})
+	}))
+	defer archive.Close()
+
+	dir := t.TempDir()
+	log := logging.Logger("test")
+	save := func(objs ObjectsToSave) error { return LocalFileSystemSave(objs, dir, log) }
+	if err := save(ObjectsToSave{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": []byte(`{"block_hash":"X","state_hash":"` + canonical + `"}`),
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qb.json": []byte(`{"block_hash":"X"}`),
+		"submissions/2024-05-01/2024-05-01T11:00:00Z-B62qa.json": []byte(`{"block_hash":"Y","state_hash":"` + orphaned + `"}`),
+		"submissions/2024-05-01/2024-05-01T12:00:00Z-B62qb.json": []byte(`{"block_hash":"Z"}`),
+		// Not final yet
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json": []byte(`{"block_hash":"Y","state_hash":"` + orphaned + `"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tm := &timeMock{time: time.Date(2024, 5, 3, 2, 0, 0, 0, time.UTC)}
+	checker := NewFinalityChecker(LocalFileSystemSource{Directory: dir}, save, &FinalityConfig{ArchiveURL: archive.URL}, tm.Now, log)
+
+	checked, err := checker.Run(context.Background())
+	if err != nil || !reflect.DeepEqual(checked, []string{"2024-05-01"}) {
+		t.Fatalf("expected 2024-05-01 checked, got %v, error: %v", checked, err)
+	}
+	finality, err := ReadDayFinality(context.Background(), LocalFileSystemSource{Directory: dir}, "2024-05-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"X": BLOCK_STATUS_CANONICAL, "Y": BLOCK_STATUS_ORPHANED, "Z": BLOCK_STATUS_UNKNOWN}
+	if !reflect.DeepEqual(finality.Blocks, expected) {
+		t.Fatalf("unexpected statuses %v", finality.Blocks)
+	}
+	if !reflect.DeepEqual(finality.Orphaned, map[string][]string{"B62qa": {"2024-05-01T11:00:00Z"}}) {
+		t.Fatalf("unexpected orphaned submissions %v", finality.Orphaned)
+	}
+	if checked, err := checker.Run(context.Background()); err != nil || len(checked) != 0 || queries != 1 {
+		t.Fatalf("expected days checked once, checked %v with %d queries, error: %v", checked, queries, err)
+	}
+
+	// Submissions of orphaned blocks aren't scored
+	overwrite := func(objs ObjectsToSave) error { return LocalFileSystemOverwrite(objs, dir) }
+	scorer := NewScorer(LocalFileSystemSource{Directory: dir}, overwrite, &ScoringConfig{WindowDays: []int{2}, SubmissionIntervalMinutes: 60, ExcludeOrphaned: true}, tm.Now, log)
+	snapshot, err := scorer.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score := snapshot.Windows[0].Scores["B62qa"]; score.Intervals != 2 {
+		t.Fatalf("unexpected score %+v", score)
+	}
+
+	for _, test := range []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"/v1/finality/2024-05-01", http.StatusOK, `"orphaned_submissions":1`},
+		{"/v1/finality/2024-05-01?block=Y", http.StatusOK, `"status":"orphaned"`},
+		{"/v1/finality/2024-05-01?submitter=B62qb", http.StatusOK, `"orphaned":[]`},
+		{"/v1/finality/2024-05-02", http.StatusNotFound, ""},
+		{"/v1/finality/May", http.StatusBadRequest, ""},
+	} {
+		rec := httptest.NewRecorder()
+		checker.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if rec.Code != test.status || !strings.Contains(rec.Body.String(), test.body) {
+			t.Errorf("%s: unexpected response %d: %s", test.url, rec.Code, rec.Body)
+		}
+	}
+	var metrics strings.Builder
+	checker.WritePrometheus(&metrics)
+	if !strings.Contains(metrics.String(), "uptime_finality_blocks_total{status=\"orphaned\"} 1\n") {
+		t.Fatalf("unexpected metrics:\n%s", metrics.String())
+	}
+}
+
+func TestSubmitStateHash(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	storage, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	tm.time = req.Data.CreatedAt
+	withStateHash := func(stateHash string) []byte {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			t.Fatal(err)
+		}
+		fields["state_hash"] = stateHash
+		bs, err := json.Marshal(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bs
+	}
+
+	if rep := sh.testRequest(withStateHash("3NK")); rep.Code != 400 || len(*storage) != 0 {
+		t.Fatalf("expected a malformed state hash rejected, got %d", rep.Code)
+	}
+	if rep := sh.testRequest(withStateHash(mainnetGenesisStateHash)); rep.Code != 200 {
+		t.Fatalf("expected the submission accepted, got %d: %s", rep.Code, rep.Body)
+	}
+	if meta := testSavedMeta(storage, t); meta.StateHash != mainnetGenesisStateHash {
+		t.Fatalf("expected the state hash recorded, got %q", meta.StateHash)
+	}
+}
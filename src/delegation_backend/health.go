@@ -22,3 +22,33 @@ func HealthHandler(isReady func() bool) http.HandlerFunc {
 		}
 	}
 }
+
+// ReadinessStatus represents the JSON response structure for the /readyz endpoint
+type ReadinessStatus struct {
+	Status string        `json:"status"`
+	Canary *CanaryResult `json:"canary,omitempty"`
+}
+
+// ReadyzHandler handles the /readyz endpoint, the application is ready once
+// isReady holds and the latest canary submission succeeded (if canary is set),
+// until it is draining (if drain is set).
+func ReadyzHandler(isReady func() bool, canary *Canary, drain *Drain) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		status := ReadinessStatus{Status: "ok"}
+		ready := isReady()
+		if canary != nil {
+			status.Canary = canary.Last()
+			ready = ready && status.Canary != nil && status.Canary.Ok
+		}
+		switch {
+		case drain != nil && drain.Draining():
+			status.Status = "draining"
+			writeJSON(rw, http.StatusServiceUnavailable, status)
+		case ready:
+			writeJSON(rw, http.StatusOK, status)
+		default:
+			status.Status = "unavailable"
+			writeJSON(rw, http.StatusServiceUnavailable, status)
+		}
+	}
+}
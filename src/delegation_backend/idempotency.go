@@ -0,0 +1,201 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Header of submissions naming the submission, so that retries of it are
+// responded to as the submission was, instead of being handled again
+const IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+
+// Header set on responses repeated for a retried submission
+const IDEMPOTENCY_REPLAYED_HEADER = "Idempotent-Replayed"
+
+const (
+	IDEMPOTENCY_KEY_TTL         = 24 * time.Hour
+	IDEMPOTENCY_KEY_MAX_ENTRIES = 100000
+	IDEMPOTENCY_KEY_MAX_LENGTH  = 255
+)
+
+// Codes of rejections of submissions by their idempotency key
+const (
+	IDEMPOTENCY_KEY_INVALID_CODE     = "idempotency_key_invalid"
+	IDEMPOTENCY_KEY_IN_PROGRESS_CODE = "idempotency_key_in_progress"
+	IDEMPOTENCY_KEY_REUSED_CODE      = "idempotency_key_reused"
+)
+
+// Outcome of looking up the idempotency key of a submission
+type idempotencyState int
+
+const (
+	// The key is new, it is reserved for the submission
+	IDEMPOTENCY_NEW idempotencyState = iota
+	// A submission with the key is being handled
+	IDEMPOTENCY_IN_PROGRESS
+	// A submission with the key was accepted, its response is repeated
+	IDEMPOTENCY_COMPLETED
+	// The key was used by a submission with another signature
+	IDEMPOTENCY_REUSED
+)
+
+type idempotencyEntry struct {
+	// Signature of the submission, telling retries from other submissions
+	// reusing the key
+	sig      Sig
+	response *submitResponse
+	expires  time.Time
+}
+
+// Entry of a submission accepted, as kept in the shared state
+type sharedIdempotencyEntry struct {
+	Sig      Sig            `json:"sig"`
+	Response submitResponse `json:"response"`
+}
+
+// IdempotencyStore remembers the responses of accepted submissions by
+// submitter and idempotency key for a TTL, so that exporters retrying
+// after an ambiguous timeout get the original response, without the
+// submission being saved again or counted by the rate limit. Only
+// accepted submissions are remembered, rejected ones are handled again.
+type IdempotencyStore struct {
+	mutex      sync.Mutex
+	entries    map[string]*idempotencyEntry
+	ttl        time.Duration
+	maxEntries int
+	now        nowFunc
+	// Optional, responses are remembered across replicas
+	shared SharedState
+}
+
+func NewIdempotencyStore(ttl time.Duration, maxEntries int) *IdempotencyStore {
+	return &IdempotencyStore{
+		entries:    make(map[string]*idempotencyEntry),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+// Remember responses across replicas. Submissions in progress are known
+// to the replica handling them only, responses are remembered in memory
+// while the shared state fails.
+func (s *IdempotencyStore) SetSharedState(shared SharedState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.shared = shared
+}
+
+// Whether the key is a valid idempotency key, printable ASCII of at most
+// IDEMPOTENCY_KEY_MAX_LENGTH characters
+func ValidIdempotencyKey(key string) bool {
+	if key == "" || len(key) > IDEMPOTENCY_KEY_MAX_LENGTH {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func idempotencyEntryKey(submitter Pk, key string) string {
+	return "idempotency:" + submitter.String() + ":" + key
+}
+
+// Begin looks the key of the submitter up. A new key is reserved for the
+// submission signed with sig until it is completed or released, the
+// response of a completed one is returned.
+func (s *IdempotencyStore) Begin(submitter Pk, key string, sig Sig) (idempotencyState, *submitResponse) {
+	entryKey := idempotencyEntryKey(submitter, key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	if entry, exists := s.entries[entryKey]; exists && now.Before(entry.expires) {
+		return entry.state(sig)
+	}
+	if s.shared != nil {
+		value, found, err := s.shared.Get(entryKey)
+		if err != nil {
+			incMetric("shared_state_errors")
+		}
+		var shared sharedIdempotencyEntry
+		if found && json.Unmarshal([]byte(value), &shared) == nil {
+			entry := &idempotencyEntry{sig: shared.Sig, response: &shared.Response, expires: now.Add(s.ttl)}
+			s.put(entryKey, entry, now)
+			return entry.state(sig)
+		}
+	}
+	s.put(entryKey, &idempotencyEntry{sig: sig, expires: now.Add(s.ttl)}, now)
+	return IDEMPOTENCY_NEW, nil
+}
+
+func (entry *idempotencyEntry) state(sig Sig) (idempotencyState, *submitResponse) {
+	switch {
+	case entry.sig != sig:
+		return IDEMPOTENCY_REUSED, nil
+	case entry.response == nil:
+		return IDEMPOTENCY_IN_PROGRESS, nil
+	default:
+		response := *entry.response
+		return IDEMPOTENCY_COMPLETED, &response
+	}
+}
+
+// Store the entry. When the store is full, expired entries are evicted
+// and if none expired the entry is not stored.
+func (s *IdempotencyStore) put(entryKey string, entry *idempotencyEntry, now time.Time) {
+	if len(s.entries) >= s.maxEntries {
+		for k, e := range s.entries {
+			if !now.Before(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		if len(s.entries) >= s.maxEntries {
+			return
+		}
+	}
+	s.entries[entryKey] = entry
+}
+
+// Complete remembers the response of the submission the key is reserved
+// for
+func (s *IdempotencyStore) Complete(submitter Pk, key string, response submitResponse) {
+	entryKey := idempotencyEntryKey(submitter, key)
+	s.mutex.Lock()
+	entry, exists := s.entries[entryKey]
+	if !exists {
+		s.mutex.Unlock()
+		return
+	}
+	entry.response = &response
+	entry.expires = s.now().Add(s.ttl)
+	shared := s.shared
+	sig := entry.sig
+	s.mutex.Unlock()
+	if shared != nil {
+		bs, _ := json.Marshal(sharedIdempotencyEntry{Sig: sig, Response: response})
+		if err := shared.Set(entryKey, string(bs), s.ttl); err != nil {
+			incMetric("shared_state_errors")
+		}
+	}
+}
+
+// Release the key of a submission which wasn't accepted, so that it can
+// be retried. Keys of completed submissions are kept.
+func (s *IdempotencyStore) Release(submitter Pk, key string) {
+	entryKey := idempotencyEntryKey(submitter, key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if entry, exists := s.entries[entryKey]; exists && entry.response == nil {
+		delete(s.entries, entryKey)
+	}
+}
+
+func (s *IdempotencyStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.entries)
+}
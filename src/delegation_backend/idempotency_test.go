@@ -0,0 +1,93 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSubmitWithIdempotencyKey(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	// One submission an hour, retries don't count
+	_, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.Idempotency = NewIdempotencyStore(time.Hour, 10)
+	saves := 0
+	sh.app.Save = func(context.Context, ObjectsToSave) StorageOutcomes {
+		saves++
+		return nil
+	}
+	submit := func(body []byte, key string) *httptest.ResponseRecorder {
+		rep := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", v1Submit, bytes.NewReader(body))
+		r.Header.Set(IDEMPOTENCY_KEY_HEADER, key)
+		sh.ServeHTTP(rep, r)
+		return rep
+	}
+
+	first := submit(body, "retry-1")
+	if first.Code != 200 || first.Header().Get(IDEMPOTENCY_REPLAYED_HEADER) != "" {
+		t.Fatalf("unexpected failure: %v", first)
+	}
+	retry := submit(body, "retry-1")
+	if retry.Code != 200 || retry.Header().Get(IDEMPOTENCY_REPLAYED_HEADER) != "true" || retry.Body.String() != first.Body.String() {
+		t.Fatalf("expected the original response repeated, got %d %s", retry.Code, retry.Body.String())
+	}
+	if saves != 1 {
+		t.Fatalf("expected the submission saved once, got %d", saves)
+	}
+
+	// Another submission with the key, its signature isn't verified to
+	// make it
+	sh.app.VerifySignatureDisabled = true
+	req.Sig[0] ^= 1
+	other, _ := json.Marshal(req)
+	var resp submitErrorResponse
+	rep := submit(other, "retry-1")
+	json.Unmarshal(rep.Body.Bytes(), &resp)
+	if rep.Code != 422 || resp.Code != IDEMPOTENCY_KEY_REUSED_CODE {
+		t.Fatalf("expected the reused key rejected, got %d %s", rep.Code, rep.Body.String())
+	}
+	sh.app.VerifySignatureDisabled = false
+	rep = submit(body, "retry-\n")
+	json.Unmarshal(rep.Body.Bytes(), &resp)
+	if rep.Code != 400 || resp.Code != IDEMPOTENCY_KEY_INVALID_CODE {
+		t.Fatalf("expected the invalid key rejected, got %d %s", rep.Code, rep.Body.String())
+	}
+	// A new key is a new submission, counted by the rate limit
+	if rep := submit(body, "retry-2"); rep.Code != 429 {
+		t.Fatalf("expected the rate limit to apply, got %d", rep.Code)
+	}
+	if state, _ := sh.app.Idempotency.Begin(req.Submitter, "retry-2", req.Sig); state != IDEMPOTENCY_NEW {
+		t.Fatalf("expected the key of a rejected submission released, got %v", state)
+	}
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := NewIdempotencyStore(time.Minute, 10)
+	store.now = func() time.Time { return now }
+	var pk Pk
+	var sig Sig
+	if state, _ := store.Begin(pk, "a", sig); state != IDEMPOTENCY_NEW {
+		t.Fatalf("expected a new key, got %v", state)
+	}
+	if state, _ := store.Begin(pk, "a", sig); state != IDEMPOTENCY_IN_PROGRESS {
+		t.Fatalf("expected the key in progress, got %v", state)
+	}
+	store.Complete(pk, "a", submitResponse{Status: "ok"})
+	store.Release(pk, "a")
+	if state, resp := store.Begin(pk, "a", sig); state != IDEMPOTENCY_COMPLETED || resp.Status != "ok" {
+		t.Fatalf("expected the response kept, got %v %+v", state, resp)
+	}
+	now = now.Add(time.Minute)
+	if state, _ := store.Begin(pk, "a", sig); state != IDEMPOTENCY_NEW {
+		t.Fatalf("expected the key expired, got %v", state)
+	}
+}
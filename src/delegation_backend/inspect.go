@@ -0,0 +1,169 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Kinds of documents inspected
+const (
+	INSPECT_REQUEST    = "request"
+	INSPECT_SUBMISSION = "submission"
+)
+
+// Inspection of a /submit request body or of a stored submission, fields
+// are empty when the document doesn't have them
+type Inspection struct {
+	Kind               string `json:"kind"`
+	Submitter          string `json:"submitter"`
+	CreatedAt          string `json:"created_at"`
+	PeerId             string `json:"peer_id"`
+	SnarkWorkSize      int    `json:"snark_work_size,omitempty"`
+	GraphqlControlPort int    `json:"graphql_control_port,omitempty"`
+	BuiltWithCommitSha string `json:"built_with_commit_sha,omitempty"`
+	Delegate           string `json:"delegate,omitempty"`
+	DelegationId       string `json:"delegation_id,omitempty"`
+	RemoteAddr         string `json:"remote_addr,omitempty"`
+	BackendVersion     string `json:"backend_version,omitempty"`
+	// Size and hash of the block, if known
+	BlockSize int    `json:"block_size,omitempty"`
+	BlockHash string `json:"block_hash,omitempty"`
+	// Block hash of a stored submission, which BlockHash should match
+	StoredBlockHash string `json:"stored_block_hash,omitempty"`
+	Signature       string `json:"signature,omitempty"`
+	// Scheme the signature is verified with, along with its parameters
+	SignatureScheme string `json:"signature_scheme"`
+	// Validity of the signature on the network, nil if it can't be
+	// verified, as told by SignatureNote
+	SignatureValid *bool  `json:"signature_valid,omitempty"`
+	SignatureNote  string `json:"signature_note,omitempty"`
+	// Paths of the submission and its block in storages
+	MetaPath  string `json:"meta_path,omitempty"`
+	BlockPath string `json:"block_path,omitempty"`
+}
+
+// Inspect a /submit request body, or a stored submission along with its
+// block if found (nil otherwise). The signature is verified with the
+// signature scheme of the network. The paths are the ones of a submission at submittedAt.
+func Inspect(doc []byte, block []byte, scheme SignatureScheme, submittedAt time.Time) (*Inspection, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return nil, fmt.Errorf("error unmarshaling JSON: %w", err)
+	}
+	if _, isRequest := fields["data"]; isRequest {
+		return inspectRequest(doc, scheme, submittedAt)
+	}
+	return inspectSubmission(doc, block, scheme, submittedAt)
+}
+
+func inspectRequest(doc []byte, scheme SignatureScheme, submittedAt time.Time) (*Inspection, error) {
+	value, err := DecodeJSONValue(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling request: %w", err)
+	}
+	if schemaErrs := ValidateSubmission(value, SUBMIT_SCHEMA_V2); len(schemaErrs) > 0 {
+		msgs := make([]string, len(schemaErrs))
+		for i, schemaErr := range schemaErrs {
+			msgs[i] = schemaErr.Error()
+		}
+		return nil, fmt.Errorf("request doesn't match the schema: %s", strings.Join(msgs, "; "))
+	}
+	var req submitRequest
+	if err := json.Unmarshal(doc, &req); err != nil {
+		return nil, fmt.Errorf("error unmarshaling request: %w", err)
+	}
+	if !req.CheckRequiredFields() {
+		return nil, fmt.Errorf("request is missing required fields")
+	}
+	in := &Inspection{
+		Kind:               INSPECT_REQUEST,
+		Submitter:          req.Submitter.String(),
+		CreatedAt:          req.Data.CreatedAt.UTC().Format(time.RFC3339),
+		PeerId:             req.Data.PeerId,
+		GraphqlControlPort: req.Data.GraphqlControlPort,
+		BuiltWithCommitSha: req.Data.BuiltWithCommitSha,
+		BlockSize:          len(req.Data.Block.data),
+		BlockHash:          req.GetBlockDataHash(),
+		Signature:          req.Sig.String(),
+		SignatureScheme:    scheme.String(),
+	}
+	if req.Data.SnarkWork != nil {
+		in.SnarkWorkSize = len(req.Data.SnarkWork.data)
+	}
+	signer := req.Submitter
+	if req.Delegation != "" {
+		delegation, err := ParseDelegation(req.Delegation)
+		if err != nil {
+			in.SignatureNote = "invalid delegation: " + err.Error()
+			return in.withPaths(submittedAt, req.Submitter), nil
+		}
+		signer = delegation.Delegate
+		in.Delegate, in.DelegationId = delegation.Claims.Subject, delegation.Claims.Id
+		in.SignatureNote = "signed by the delegate, the delegation is not verified"
+	}
+	hash, err := req.signPayloadHash()
+	if err != nil {
+		return nil, fmt.Errorf("error making sign payload: %w", err)
+	}
+	valid := scheme.Verify(&signer, &req.Sig, hash)
+	in.SignatureValid = &valid
+	return in.withPaths(submittedAt, req.Submitter), nil
+}
+
+func inspectSubmission(doc []byte, block []byte, scheme SignatureScheme, submittedAt time.Time) (*Inspection, error) {
+	var meta MetaToBeSaved
+	if err := json.Unmarshal(doc, &meta); err != nil {
+		return nil, fmt.Errorf("error unmarshaling submission: %w", err)
+	}
+	in := &Inspection{
+		Kind:               INSPECT_SUBMISSION,
+		Submitter:          meta.Submitter.String(),
+		CreatedAt:          meta.CreatedAt,
+		PeerId:             meta.PeerId,
+		GraphqlControlPort: meta.GraphqlControlPort,
+		BuiltWithCommitSha: meta.BuiltWithCommitSha,
+		Delegate:           meta.Delegate,
+		DelegationId:       meta.DelegationId,
+		RemoteAddr:         meta.RemoteAddr,
+		BackendVersion:     meta.BackendVersion,
+		StoredBlockHash:    meta.BlockHash,
+		SignatureScheme:    scheme.String(),
+	}
+	if meta.SnarkWork != nil {
+		in.SnarkWorkSize = len(meta.SnarkWork.data)
+	}
+	if block != nil {
+		in.BlockSize = len(block)
+		in.BlockHash = BlockDataHash(block)
+	}
+	if meta.Signature != nil {
+		in.Signature = meta.Signature.String()
+	}
+	signer := meta.Submitter
+	switch {
+	case meta.Signature == nil:
+		in.SignatureNote = "saved without signature"
+	case block == nil:
+		in.SignatureNote = "block not found"
+	case meta.Delegate != "" && StringToPk(&signer, meta.Delegate) != nil:
+		in.SignatureNote = "invalid delegate"
+	default:
+		hash, err := meta.signPayloadHash(block)
+		if err != nil {
+			return nil, fmt.Errorf("error making sign payload: %w", err)
+		}
+		valid := scheme.Verify(&signer, meta.Signature, hash)
+		in.SignatureValid = &valid
+	}
+	in.MetaPath = makePaths(submittedAt, meta.BlockHash, meta.Submitter).Meta
+	in.BlockPath = BLOCKS_PREFIX + meta.BlockHash + ".dat"
+	return in, nil
+}
+
+func (in *Inspection) withPaths(submittedAt time.Time, submitter Pk) *Inspection {
+	paths := makePaths(submittedAt, in.BlockHash, submitter)
+	in.MetaPath, in.BlockPath = paths.Meta, paths.Block
+	return in
+}
@@ -0,0 +1,40 @@
+package delegation_backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInspect(t *testing.T) {
+	submittedAt := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	body := readTestFile("req-with-snark", t)
+	in, err := Inspect(body, nil, MinaSchnorr{}, submittedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, meta := testMeta(t, "req-with-snark", func(*MetaToBeSaved) {})
+	paths := makePaths(submittedAt, req.GetBlockDataHash(), req.Submitter)
+	if in.Kind != INSPECT_REQUEST || in.BlockHash != req.GetBlockDataHash() || in.SignatureValid == nil || !*in.SignatureValid ||
+		in.MetaPath != paths.Meta || in.BlockPath != paths.Block || in.SnarkWorkSize != len(req.Data.SnarkWork.data) {
+		t.Fatalf("unexpected inspection of the request %+v", in)
+	}
+
+	// Stored submissions are verified along with their block
+	if in, err = Inspect(meta, req.Data.Block.data, MinaSchnorr{}, submittedAt); err != nil {
+		t.Fatal(err)
+	}
+	if in.Kind != INSPECT_SUBMISSION || in.BlockHash != in.StoredBlockHash || in.SignatureValid == nil || !*in.SignatureValid ||
+		in.MetaPath != paths.Meta || in.RemoteAddr != "192.0.2.1:1234" {
+		t.Fatalf("unexpected inspection of the submission %+v", in)
+	}
+	if in, err = Inspect(meta, []byte("bitrot"), MinaSchnorr{}, submittedAt); err != nil || in.BlockHash == in.StoredBlockHash || in.BlockSize != 6 {
+		t.Fatalf("expected a corrupt block not to match, got %+v, error: %v", in, err)
+	}
+	if in, err = Inspect(meta, nil, MinaSchnorr{}, submittedAt); err != nil || in.SignatureValid != nil || in.SignatureNote != "block not found" {
+		t.Fatalf("expected the signature unknown without block, got %+v, error: %v", in, err)
+	}
+
+	if _, err := Inspect([]byte(`{"data":{}}`), nil, MinaSchnorr{}, submittedAt); err == nil {
+		t.Fatal("expected an error inspecting an incomplete request")
+	}
+}
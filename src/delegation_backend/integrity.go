@@ -0,0 +1,314 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Kinds of issues found by an integrity check
+const (
+	// Submission which isn't valid JSON, or doesn't match its path
+	INTEGRITY_CORRUPT_SUBMISSION = "corrupt_submission"
+	// Block whose hash doesn't match its name
+	INTEGRITY_CORRUPT_BLOCK = "corrupt_block"
+	// Block referenced by a submission, not stored
+	INTEGRITY_MISSING_BLOCK = "missing_block"
+	// Block referenced by no submission
+	INTEGRITY_ORPHANED_BLOCK = "orphaned_block"
+	// Submission whose signature doesn't verify
+	INTEGRITY_INVALID_SIGNATURE = "invalid_signature"
+)
+
+// Submissions checked at once
+const INTEGRITY_READ_CONCURRENCY = 16
+
+// Read the objects at paths, INTEGRITY_READ_CONCURRENCY at once, passing
+// each to fn in the order of paths, along with the error reading it. An
+// error of fn stops reading.
+func readConcurrently(ctx context.Context, source StorageSource, paths []string, fn func(path string, bs []byte, err error) error) error {
+	for start := 0; start < len(paths); start += INTEGRITY_READ_CONCURRENCY {
+		batch := paths[start:min(len(paths), start+INTEGRITY_READ_CONCURRENCY)]
+		contents := make([][]byte, len(batch))
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for i, path := range batch {
+			wg.Add(1)
+			go func(i int, path string) {
+				defer wg.Done()
+				contents[i], errs[i] = source.Read(ctx, path)
+			}(i, path)
+		}
+		wg.Wait()
+		for i, path := range batch {
+			if err := fn(path, contents[i], errs[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// StoredBlock listed by a BlockLister, Modified is the time it was saved
+type StoredBlock struct {
+	Path     string
+	Size     int64
+	Modified time.Time
+}
+
+// BlockLister is implemented by storage sources able to list blocks
+type BlockLister interface {
+	// Blocks stored, sorted by path
+	Blocks(ctx context.Context) ([]StoredBlock, error)
+}
+
+func (l LocalFileSystemSource) Blocks(ctx context.Context) ([]StoredBlock, error) {
+	entries, err := os.ReadDir(filepath.Join(l.Directory, BLOCKS_PREFIX))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var blocks []StoredBlock
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".dat") {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, StoredBlock{Path: BLOCKS_PREFIX + entry.Name(), Size: info.Size(), Modified: info.ModTime()})
+	}
+	return blocks, nil
+}
+
+func (s *S3Source) Blocks(ctx context.Context) ([]StoredBlock, error) {
+	root := s.Aws.Prefix + "/"
+	paginator := s3.NewListObjectsV2Paginator(s.Aws.Client, &s3.ListObjectsV2Input{
+		Bucket: s.Aws.BucketName,
+		Prefix: aws.String(root + BLOCKS_PREFIX),
+	})
+	var blocks []StoredBlock
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			if key := aws.ToString(obj.Key); strings.HasSuffix(key, ".dat") {
+				blocks = append(blocks, StoredBlock{Path: strings.TrimPrefix(key, root), Size: obj.Size, Modified: aws.ToTime(obj.LastModified)})
+			}
+		}
+	}
+	return blocks, nil
+}
+
+// IntegrityIssue of an object of the storage
+type IntegrityIssue struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// IntegrityReport of a check, submissions saved before signatures were
+// saved along with them are counted as unsigned
+type IntegrityReport struct {
+	Submissions int `json:"submissions"`
+	// Blocks read, referenced by submissions or orphaned
+	Blocks   int              `json:"blocks"`
+	Unsigned int              `json:"unsigned"`
+	Issues   []IntegrityIssue `json:"issues,omitempty"`
+}
+
+// IntegrityCheck reads back submissions of an object storage and checks
+// that their blocks are stored, that the blake2b hashes of the blocks
+// match their names and, optionally, that the signatures of submissions
+// verify. Blocks referenced by no submission are reported if the source
+// lists blocks and the check isn't filtered, as blocks referenced by
+// submissions out of the filter are not known then.
+type IntegrityCheck struct {
+	Source StorageSource
+	Filter ReplayFilter
+	// Verify signatures of submissions, with the signature scheme
+	VerifySignatures bool
+	SignatureScheme  SignatureScheme
+}
+
+// Result of the check of a submission
+type submissionIntegrity struct {
+	blockPath string
+	blockRead bool
+	unsigned  bool
+	issues    []IntegrityIssue
+	err       error
+}
+
+// Run the check, errors reading the storage stop it
+func (c *IntegrityCheck) Run(ctx context.Context) (*IntegrityReport, error) {
+	report := &IntegrityReport{}
+	days, err := c.Source.Days(ctx)
+	if err != nil {
+		return report, fmt.Errorf("error listing days of submissions: %w", err)
+	}
+	// Blocks referenced, those checked have their issues reported once
+	referenced := make(map[string]bool)
+	for _, day := range days {
+		if !c.Filter.includes(day) {
+			continue
+		}
+		paths, err := c.Source.Submissions(ctx, day)
+		if err != nil {
+			return report, fmt.Errorf("error listing submissions of %s: %w", day, err)
+		}
+		var selected []string
+		for _, metaPath := range paths {
+			if c.Filter.includesSubmission(metaPath) {
+				selected = append(selected, metaPath)
+			}
+		}
+		for start := 0; start < len(selected); start += INTEGRITY_READ_CONCURRENCY {
+			batch := selected[start:min(len(selected), start+INTEGRITY_READ_CONCURRENCY)]
+			results := make([]submissionIntegrity, len(batch))
+			var wg sync.WaitGroup
+			for i, metaPath := range batch {
+				wg.Add(1)
+				go func(i int, metaPath string) {
+					defer wg.Done()
+					results[i] = c.checkSubmission(ctx, metaPath)
+				}(i, metaPath)
+			}
+			wg.Wait()
+			for i, result := range results {
+				if result.err != nil {
+					return report, fmt.Errorf("error reading submission %s: %w", batch[i], result.err)
+				}
+				report.Submissions++
+				if result.unsigned {
+					report.Unsigned++
+				}
+				for _, issue := range result.issues {
+					// Issues of a block are found by every submission of it
+					if issue.Path == result.blockPath && referenced[result.blockPath] {
+						continue
+					}
+					report.Issues = append(report.Issues, issue)
+				}
+				if result.blockRead && !referenced[result.blockPath] {
+					report.Blocks++
+				}
+				if result.blockPath != "" {
+					referenced[result.blockPath] = true
+				}
+			}
+		}
+	}
+
+	lister, ok := c.Source.(BlockLister)
+	if !ok || c.Filter != (ReplayFilter{}) {
+		return report, nil
+	}
+	blocks, err := lister.Blocks(ctx)
+	if err != nil {
+		return report, fmt.Errorf("error listing blocks: %w", err)
+	}
+	for _, stored := range blocks {
+		blockPath := stored.Path
+		if referenced[blockPath] {
+			continue
+		}
+		report.Blocks++
+		report.Issues = append(report.Issues, IntegrityIssue{Kind: INTEGRITY_ORPHANED_BLOCK, Path: blockPath})
+		block, err := c.Source.Read(ctx, blockPath)
+		if err != nil {
+			return report, fmt.Errorf("error reading block %s: %w", blockPath, err)
+		}
+		if issue := checkBlock(blockPath, block); issue != nil {
+			report.Issues = append(report.Issues, *issue)
+		}
+	}
+	return report, nil
+}
+
+func (c *IntegrityCheck) checkSubmission(ctx context.Context, metaPath string) (result submissionIntegrity) {
+	corrupt := func(format string, args ...interface{}) submissionIntegrity {
+		result.issues = append(result.issues, IntegrityIssue{Kind: INTEGRITY_CORRUPT_SUBMISSION, Path: metaPath, Detail: fmt.Sprintf(format, args...)})
+		return result
+	}
+	bs, err := c.Source.Read(ctx, metaPath)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	var meta MetaToBeSaved
+	if err := json.Unmarshal(bs, &meta); err != nil {
+		return corrupt("error unmarshaling submission JSON: %v", err)
+	}
+	if !strings.HasSuffix(path.Base(metaPath), "-"+meta.Submitter.String()+".json") {
+		return corrupt("submitter %s doesn't match the path", meta.Submitter.String())
+	}
+	if meta.BlockHash == "" {
+		return corrupt("no block hash")
+	}
+	result.blockPath = BLOCKS_PREFIX + meta.BlockHash + ".dat"
+	block, err := c.Source.Read(ctx, result.blockPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		result.issues = append(result.issues, IntegrityIssue{Kind: INTEGRITY_MISSING_BLOCK, Path: result.blockPath, Detail: "referenced by " + metaPath})
+		return result
+	}
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.blockRead = true
+	if issue := checkBlock(result.blockPath, block); issue != nil {
+		// The signature of a corrupt block doesn't verify either
+		result.issues = append(result.issues, *issue)
+		return result
+	}
+	if !c.VerifySignatures {
+		return result
+	}
+	if meta.Signature == nil {
+		result.unsigned = true
+		return result
+	}
+	signer := meta.Submitter
+	if meta.Delegate != "" {
+		if err := StringToPk(&signer, meta.Delegate); err != nil {
+			return corrupt("invalid delegate: %v", err)
+		}
+	}
+	hash, err := meta.signPayloadHash(block)
+	if err != nil {
+		return corrupt("error making sign payload: %v", err)
+	}
+	if !c.SignatureScheme.Verify(&signer, meta.Signature, hash) {
+		result.issues = append(result.issues, IntegrityIssue{Kind: INTEGRITY_INVALID_SIGNATURE, Path: metaPath})
+	}
+	return result
+}
+
+// Check the hash of the block at blockPath against its name
+func checkBlock(blockPath string, block []byte) *IntegrityIssue {
+	name := strings.TrimSuffix(path.Base(blockPath), ".dat")
+	if hash := BlockDataHash(block); hash != name {
+		return &IntegrityIssue{Kind: INTEGRITY_CORRUPT_BLOCK, Path: blockPath, Detail: "hash of the block is " + hash}
+	}
+	return nil
+}
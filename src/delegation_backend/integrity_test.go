@@ -0,0 +1,90 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Metadata of the request of the test file, changed by edit
+func testMeta(t *testing.T, name string, edit func(*MetaToBeSaved)) (submitRequest, []byte) {
+	var req submitRequest
+	if err := json.Unmarshal(readTestFile(name, t), &req); err != nil {
+		t.Fatal(err)
+	}
+	var meta MetaToBeSaved
+	bs, err := req.MakeMetaToBeSaved("192.0.2.1:1234")
+	if err == nil {
+		err = json.Unmarshal(bs, &meta)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(&meta)
+	if bs, err = json.Marshal(meta); err != nil {
+		t.Fatal(err)
+	}
+	return req, bs
+}
+
+func TestIntegrityCheck(t *testing.T) {
+	dir := t.TempDir()
+	submittedAt := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	objs := make(ObjectsToSave)
+	// Submission of the test file saved along with its block, unless
+	// the block hash is edited
+	add := func(name string, edit func(*MetaToBeSaved)) Paths {
+		req, meta := testMeta(t, name, edit)
+		paths := makePaths(submittedAt, req.GetBlockDataHash(), req.Submitter)
+		objs[paths.Meta] = meta
+		objs[paths.Block] = req.Data.Block.data
+		submittedAt = submittedAt.Add(time.Minute)
+		return paths
+	}
+	add("req-with-snark", func(*MetaToBeSaved) {})
+	invalid := add("req-no-snark", func(meta *MetaToBeSaved) { meta.Signature[0] ^= 1 })
+	corrupt := BLOCKS_PREFIX + BlockDataHash([]byte("block")) + ".dat"
+	objs[corrupt] = []byte("bitrot")
+	add("req-with-snark", func(meta *MetaToBeSaved) { meta.BlockHash = BlockDataHash([]byte("block")) })
+	add("req-with-snark", func(meta *MetaToBeSaved) { meta.Signature = nil })
+	missing := add("req-with-snark", func(meta *MetaToBeSaved) { meta.BlockHash = BlockDataHash([]byte("missing")) })
+	objs["submissions/2024-05-02/2024-05-02T10:05:00Z-B62qbad.json"] = []byte("{")
+	orphan := BLOCKS_PREFIX + BlockDataHash([]byte("orphan")) + ".dat"
+	objs[orphan] = []byte("orphan")
+	if err := LocalFileSystemSave(objs, dir, logging.Logger("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	check := &IntegrityCheck{Source: LocalFileSystemSource{Directory: dir}, VerifySignatures: true, SignatureScheme: MinaSchnorr{}}
+	report, err := check.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Submissions != 6 || report.Blocks != 3 || report.Unsigned != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	expected := []IntegrityIssue{
+		{Kind: INTEGRITY_INVALID_SIGNATURE, Path: invalid.Meta},
+		{Kind: INTEGRITY_CORRUPT_BLOCK, Path: corrupt, Detail: "hash of the block is " + BlockDataHash([]byte("bitrot"))},
+		{Kind: INTEGRITY_MISSING_BLOCK, Path: BLOCKS_PREFIX + BlockDataHash([]byte("missing")) + ".dat", Detail: "referenced by " + missing.Meta},
+		{Kind: INTEGRITY_CORRUPT_SUBMISSION, Path: "submissions/2024-05-02/2024-05-02T10:05:00Z-B62qbad.json", Detail: "error unmarshaling submission JSON: unexpected end of JSON input"},
+		{Kind: INTEGRITY_ORPHANED_BLOCK, Path: orphan},
+	}
+	if !reflect.DeepEqual(report.Issues, expected) {
+		t.Fatalf("unexpected issues %+v", report.Issues)
+	}
+
+	// Orphaned blocks are not known by a filtered check
+	check.Filter = ReplayFilter{Since: "2024-05-02T10:02:00Z"}
+	check.VerifySignatures = false
+	if report, err = check.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if report.Submissions != 4 || report.Blocks != 2 || report.Unsigned != 0 || len(report.Issues) != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,104 @@
+package delegation_backend
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Kinds of keys failing validation
+const (
+	KEY_KIND_PK  = "public_key"
+	KEY_KIND_SIG = "signature"
+)
+
+// Reasons of keys failing validation, the codes of rejections are the kind
+// of the key followed by the reason, e.g. `public_key_not_on_curve`
+const (
+	// Not base58, or its checksum doesn't match
+	INVALID_KEY_BASE58CHECK = "base58check"
+	// Version byte of another kind of key
+	INVALID_KEY_VERSION = "version"
+	// Version tags of the serialization aren't the expected ones
+	INVALID_KEY_PREFIX = "prefix"
+	INVALID_KEY_LENGTH = "length"
+	// Field element not below the modulus of the base field
+	INVALID_KEY_FIELD_BOUNDS = "field_bounds"
+	// Scalar not below the modulus of the scalar field
+	INVALID_KEY_SCALAR_BOUNDS = "scalar_bounds"
+	// Parity of the y coordinate of a public key neither 0 nor 1
+	INVALID_KEY_PARITY = "parity"
+	// No point of the curve has the x coordinate of the public key
+	INVALID_KEY_NOT_ON_CURVE = "not_on_curve"
+)
+
+// Size of the x coordinate of public keys and of both halves of signatures
+const KEY_FIELD_SIZE = 32
+
+// Moduli of the base field of Pallas, of public keys and of the r of
+// signatures, and of its scalar field, of the s of signatures
+var (
+	pallasBaseModulus, _   = new(big.Int).SetString("40000000000000000000000000000000224698fc094cf91b992d30ed00000001", 16)
+	pallasScalarModulus, _ = new(big.Int).SetString("40000000000000000000000000000000224698fc0994a8dd8c46eb2100000001", 16)
+)
+
+// Constant b of Pallas, y^2 = x^3 + b
+var pallasB = big.NewInt(5)
+
+// KeyEncodingError tells why a public key or a signature isn't valid, so
+// that encoding problems aren't reported as invalid signatures
+type KeyEncodingError struct {
+	Kind   string
+	Reason string
+	Detail string
+}
+
+func (e *KeyEncodingError) Error() string {
+	return e.Kind + " " + e.Reason + ": " + e.Detail
+}
+
+// Code of rejections of the key
+func (e *KeyEncodingError) Code() string {
+	return e.Kind + "_" + e.Reason
+}
+
+// Integer of a field element serialized little-endian
+func littleEndianInt(bs []byte) *big.Int {
+	bigEndian := make([]byte, len(bs))
+	for i, b := range bs {
+		bigEndian[len(bs)-1-i] = b
+	}
+	return new(big.Int).SetBytes(bigEndian)
+}
+
+// Validate checks the public key is a point of Pallas: its x coordinate
+// is an element of the base field, the parity of its y coordinate is 0 or
+// 1 and x^3 + 5 is a square
+func (pk Pk) Validate() error {
+	x := littleEndianInt(pk[:KEY_FIELD_SIZE])
+	if x.Cmp(pallasBaseModulus) >= 0 {
+		return &KeyEncodingError{KEY_KIND_PK, INVALID_KEY_FIELD_BOUNDS, "x isn't an element of the base field"}
+	}
+	isOdd := pk[KEY_FIELD_SIZE]
+	if isOdd > 1 {
+		return &KeyEncodingError{KEY_KIND_PK, INVALID_KEY_PARITY, fmt.Sprintf("parity of y is %d", isOdd)}
+	}
+	y2 := new(big.Int).Exp(x, big.NewInt(3), pallasBaseModulus)
+	y2.Add(y2, pallasB).Mod(y2, pallasBaseModulus)
+	// y is 0 for the only point of x, which is even
+	if (y2.Sign() == 0 && isOdd == 1) || big.Jacobi(y2, pallasBaseModulus) == -1 {
+		return &KeyEncodingError{KEY_KIND_PK, INVALID_KEY_NOT_ON_CURVE, "x isn't the x coordinate of a point of the curve"}
+	}
+	return nil
+}
+
+// Validate checks the r of the signature is an element of the base field
+// and its s an element of the scalar field
+func (sig Sig) Validate() error {
+	if littleEndianInt(sig[:KEY_FIELD_SIZE]).Cmp(pallasBaseModulus) >= 0 {
+		return &KeyEncodingError{KEY_KIND_SIG, INVALID_KEY_FIELD_BOUNDS, "r isn't an element of the base field"}
+	}
+	if littleEndianInt(sig[KEY_FIELD_SIZE:]).Cmp(pallasScalarModulus) >= 0 {
+		return &KeyEncodingError{KEY_KIND_SIG, INVALID_KEY_SCALAR_BOUNDS, "s isn't an element of the scalar field"}
+	}
+	return nil
+}
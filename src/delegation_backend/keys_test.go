@@ -0,0 +1,118 @@
+package delegation_backend
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// Random signature, of elements of their fields
+func mkSig() Sig {
+	var sig Sig
+	rand.Read(sig[:])
+	sig[KEY_FIELD_SIZE-1] &= 0x3f
+	sig[SIG_LENGTH-1] &= 0x3f
+	return sig
+}
+
+// Little-endian serialization of n on 32 bytes
+func littleEndianBytes(n *big.Int) []byte {
+	bs := make([]byte, KEY_FIELD_SIZE)
+	n.FillBytes(bs)
+	for i, j := 0, len(bs)-1; i < j; i, j = i+1, j-1 {
+		bs[i], bs[j] = bs[j], bs[i]
+	}
+	return bs
+}
+
+func expectKeyError(t *testing.T, err error, code string) {
+	t.Helper()
+	var keyErr *KeyEncodingError
+	if !errors.As(err, &keyErr) || keyErr.Code() != code {
+		t.Errorf("expected %s, got %v", code, err)
+	}
+}
+
+func TestPkValidate(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Submitter.Validate(); err != nil {
+		t.Fatalf("expected the key of the submitter valid: %v", err)
+	}
+	if err := req.Sig.Validate(); err != nil {
+		t.Fatalf("expected the signature of the submitter valid: %v", err)
+	}
+
+	outOfField := req.Submitter
+	copy(outOfField[:], littleEndianBytes(pallasBaseModulus))
+	expectKeyError(t, outOfField.Validate(), "public_key_field_bounds")
+	parity := req.Submitter
+	parity[KEY_FIELD_SIZE] = 2
+	expectKeyError(t, parity.Validate(), "public_key_parity")
+	// x^3 + 5 isn't a square for x = 2
+	var notOnCurve Pk
+	copy(notOnCurve[:], littleEndianBytes(big.NewInt(2)))
+	expectKeyError(t, notOnCurve.Validate(), "public_key_not_on_curve")
+
+	r := req.Sig
+	copy(r[:KEY_FIELD_SIZE], littleEndianBytes(pallasBaseModulus))
+	expectKeyError(t, r.Validate(), "signature_field_bounds")
+	s := req.Sig
+	copy(s[KEY_FIELD_SIZE:], littleEndianBytes(pallasScalarModulus))
+	expectKeyError(t, s.Validate(), "signature_scalar_bounds")
+}
+
+func TestStringToPkErrors(t *testing.T) {
+	var pk Pk
+	valid := mkPk().String()
+	for _, c := range []struct {
+		s    string
+		code string
+	}{
+		{valid[:len(valid)-1] + "x", "public_key_base58check"},
+		{base58.CheckEncode(append(PK_PREFIX[:], pk[:]...), BASE58CHECK_VERSION_SIG), "public_key_version"},
+		{base58.CheckEncode(append([]byte{1, 2}, pk[:]...), BASE58CHECK_VERSION_PK), "public_key_prefix"},
+		{base58.CheckEncode(pk[:], BASE58CHECK_VERSION_PK), "public_key_length"},
+	} {
+		expectKeyError(t, StringToPk(&pk, c.s), c.code)
+	}
+	var sig Sig
+	expectKeyError(t, StringToSig(&sig, valid), "signature_version")
+}
+
+func TestSubmitInvalidKeys(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	_, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+
+	var notOnCurve Pk
+	copy(notOnCurve[:], littleEndianBytes(big.NewInt(2)))
+	bad := req
+	bad.Submitter = notOnCurve
+	badBody, _ := json.Marshal(bad)
+	malformed := strings.Replace(string(body), req.Sig.String(), req.Sig.String()[1:], 1)
+	for _, c := range []struct {
+		body []byte
+		code string
+	}{
+		{badBody, "public_key_not_on_curve"},
+		{[]byte(malformed), "signature_base58check"},
+	} {
+		rep := sh.testRequest(c.body)
+		var resp submitErrorResponse
+		if err := json.Unmarshal(rep.Body.Bytes(), &resp); rep.Code != 400 || err != nil || resp.Code != c.code {
+			t.Errorf("expected rejection with code %s, got %d: %s", c.code, rep.Code, rep.Body)
+		}
+	}
+}
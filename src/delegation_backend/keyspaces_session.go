@@ -0,0 +1,157 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Interval the Keyspaces session is probed at
+const KEYSPACES_PROBE_INTERVAL = 30 * time.Second
+
+// Temporary credentials are refreshed this long before they expire
+const KEYSPACES_CREDENTIALS_REFRESH_MARGIN = 5 * time.Minute
+
+// KeyspaceSession keeps a session to Keyspaces usable for the lifetime
+// of the process: the session is probed periodically and re-established
+// once a probe or a query fails on a stale connection, and before the
+// temporary credentials of SigV4 authentication expire.
+type KeyspaceSession struct {
+	mutex   sync.RWMutex
+	config  *AwsKeyspacesConfig
+	session *gocql.Session
+	// Expiry of the credentials of the session, zero if they don't expire
+	expiresAt time.Time
+	healthy   bool
+	// Serializes reconnections
+	reconnectMutex sync.Mutex
+	connect        func(*AwsKeyspacesConfig) (*gocql.Session, time.Time, error)
+	probe          func(*gocql.Session) error
+	now            nowFunc
+	log            logging.StandardLogger
+}
+
+func NewKeyspaceSession(config *AwsKeyspacesConfig, log logging.StandardLogger) (*KeyspaceSession, error) {
+	s := &KeyspaceSession{
+		config:  config,
+		connect: initializeKeyspaceSession,
+		probe:   probeKeyspaceSession,
+		now:     time.Now,
+		log:     log,
+	}
+	if err := s.Reconnect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func probeKeyspaceSession(session *gocql.Session) error {
+	var now time.Time
+	return session.Query("SELECT now() FROM system.local").Scan(&now)
+}
+
+// Errors of a session which won't recover without being re-established
+func isStaleSessionError(err error) bool {
+	var reqErr gocql.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.Code() == gocql.ErrCodeCredentials
+	}
+	return errors.Is(err, gocql.ErrNoConnections) || errors.Is(err, gocql.ErrSessionClosed) ||
+		errors.Is(err, gocql.ErrConnectionClosed) || errors.Is(err, gocql.ErrNoConnectionsStarted)
+}
+
+// Reconnect establishes a new session, with fresh credentials, replacing
+// the current one once queries running on it are done. The current
+// session is kept if the new one can't be established.
+func (s *KeyspaceSession) Reconnect() error {
+	s.reconnectMutex.Lock()
+	defer s.reconnectMutex.Unlock()
+	session, expiresAt, err := s.connect(s.config)
+	if err != nil {
+		incMetric("keyspaces_reconnect_errors")
+		s.mutex.Lock()
+		s.healthy = false
+		s.mutex.Unlock()
+		return err
+	}
+	s.mutex.Lock()
+	old := s.session
+	s.session, s.expiresAt, s.healthy = session, expiresAt, true
+	s.mutex.Unlock()
+	if old != nil {
+		old.Close()
+		incMetric("keyspaces_reconnects")
+	}
+	return nil
+}
+
+// Run the query on the session, the session is re-established
+// if the query failed on a stale session
+func (s *KeyspaceSession) Run(query func(*gocql.Session) error) error {
+	s.mutex.RLock()
+	err := query(s.session)
+	s.mutex.RUnlock()
+	if err != nil && isStaleSessionError(err) {
+		s.log.Warnf("Keyspaces session is stale, reconnecting: %v", err)
+		if reconnectErr := s.Reconnect(); reconnectErr != nil {
+			s.log.Errorf("Failed to re-establish Keyspaces session: %v", reconnectErr)
+		}
+	}
+	return err
+}
+
+// Check refreshes credentials about to expire, or probes the session
+func (s *KeyspaceSession) Check() {
+	s.mutex.RLock()
+	expiresAt := s.expiresAt
+	s.mutex.RUnlock()
+	if !expiresAt.IsZero() && s.now().Add(KEYSPACES_CREDENTIALS_REFRESH_MARGIN).After(expiresAt) {
+		s.log.Infof("Keyspaces credentials expire at %s, re-establishing the session", expiresAt.UTC().Format(time.RFC3339))
+		if err := s.Reconnect(); err != nil {
+			s.log.Errorf("Failed to refresh Keyspaces credentials: %v", err)
+		}
+		return
+	}
+	err := s.Run(s.probe)
+	s.mutex.Lock()
+	s.healthy = err == nil
+	s.mutex.Unlock()
+	if err != nil {
+		incMetric("keyspaces_probe_failures")
+		s.log.Warnf("Keyspaces health probe failed: %v", err)
+	}
+}
+
+// Healthy returns whether the last probe or reconnection succeeded
+func (s *KeyspaceSession) Healthy() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.healthy
+}
+
+// Periodically check the session, until ctx is done
+func (s *KeyspaceSession) CheckLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Check()
+		}
+	}
+}
+
+func (s *KeyspaceSession) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.session != nil {
+		s.session.Close()
+		s.session = nil
+	}
+}
@@ -0,0 +1,68 @@
+package delegation_backend
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestKeyspaceSession(t *testing.T) {
+	tm := &timeMock{time: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	connects := 0
+	var connectErr, probeErr error
+	s := &KeyspaceSession{
+		config: &AwsKeyspacesConfig{},
+		connect: func(*AwsKeyspacesConfig) (*gocql.Session, time.Time, error) {
+			if connectErr != nil {
+				return nil, time.Time{}, connectErr
+			}
+			connects++
+			// Temporary credentials, valid for an hour
+			return nil, tm.Now().Add(time.Hour), nil
+		},
+		probe: func(*gocql.Session) error { return probeErr },
+		now:   tm.Now,
+		log:   logging.Logger("test"),
+	}
+	if err := s.Reconnect(); err != nil || connects != 1 || !s.Healthy() {
+		t.Fatalf("expected the session established, error: %v", err)
+	}
+
+	s.Check()
+	if connects != 1 || !s.Healthy() {
+		t.Fatalf("expected the session kept, connects: %d", connects)
+	}
+
+	// Credentials are refreshed before they expire
+	tm.Advance(56 * time.Minute)
+	s.Check()
+	if connects != 2 {
+		t.Fatalf("expected the credentials refreshed, connects: %d", connects)
+	}
+
+	// Queries failing on a stale session re-establish it
+	err := s.Run(func(*gocql.Session) error { return gocql.ErrNoConnections })
+	if !errors.Is(err, gocql.ErrNoConnections) || connects != 3 {
+		t.Fatalf("expected a reconnection, error: %v, connects: %d", err, connects)
+	}
+	s.Run(func(*gocql.Session) error { return errors.New("invalid query") })
+	if connects != 3 {
+		t.Fatalf("expected no reconnection on query errors, connects: %d", connects)
+	}
+
+	// Failed probes are reported, the session is kept if it can't be re-established
+	probeErr = gocql.ErrNoConnections
+	connectErr = errors.New("connection refused")
+	s.Check()
+	if s.Healthy() {
+		t.Fatal("expected the session unhealthy")
+	}
+	probeErr, connectErr = nil, nil
+	s.Check()
+	if !s.Healthy() {
+		t.Fatal("expected the session healthy again")
+	}
+}
@@ -0,0 +1,143 @@
+package delegation_backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Default key of the PostgreSQL advisory lock held by the leader
+const LEADER_ELECTION_DEFAULT_LOCK_ID = 7201
+
+// Interval leadership is confirmed, or taken over once the leader is gone
+const LEADER_ELECTION_CHECK_INTERVAL = 15 * time.Second
+
+// LeaderLock is held by at most one replica at a time
+type LeaderLock interface {
+	// TryAcquire acquires the lock unless another replica holds it, or
+	// confirms the lock is still held. It returns whether it is held.
+	TryAcquire(ctx context.Context) (bool, error)
+	Release() error
+}
+
+// PostgreSQLLeaderLock is a session-level advisory lock held on a
+// dedicated connection. PostgreSQL releases the lock once the connection
+// is lost, so that another replica takes over from a crashed leader.
+type PostgreSQLLeaderLock struct {
+	DB     *sql.DB
+	LockId int
+	conn   *sql.Conn
+}
+
+func (l *PostgreSQLLeaderLock) TryAcquire(ctx context.Context) (bool, error) {
+	if l.conn != nil {
+		if err := l.conn.PingContext(ctx); err == nil {
+			return true, nil
+		}
+		// The session holding the lock is gone, and the lock with it
+		l.conn.Close()
+		l.conn = nil
+	}
+	conn, err := l.DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.LockId).Scan(&acquired); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if !acquired {
+		conn.Close()
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+func (l *PostgreSQLLeaderLock) Release() error {
+	if l.conn == nil {
+		return nil
+	}
+	defer func() { l.conn = nil }()
+	defer l.conn.Close()
+	_, err := l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", l.LockId)
+	return err
+}
+
+// LeaderElection elects among replicas sharing the lock the one running
+// singleton background jobs, all replicas keep serving submissions. A nil
+// election is a single replica, always the leader.
+type LeaderElection struct {
+	mutex  sync.Mutex
+	lock   LeaderLock
+	leader bool
+	now    nowFunc
+	log    logging.StandardLogger
+}
+
+func NewLeaderElection(lock LeaderLock, now nowFunc, log logging.StandardLogger) *LeaderElection {
+	return &LeaderElection{lock: lock, now: now, log: log}
+}
+
+func (e *LeaderElection) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.leader
+}
+
+// Check acquires leadership if there is no leader, or confirms it. On
+// error leadership is given up, as the lock may have been lost with it.
+func (e *LeaderElection) Check(ctx context.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	leader, err := e.lock.TryAcquire(ctx)
+	if err != nil {
+		e.log.Warnf("Leader election: error checking the leader lock: %v", err)
+		incMetric("leader_election_errors")
+	}
+	switch {
+	case leader && !e.leader:
+		e.log.Infof("Leader election: this replica is the leader since %s", e.now().UTC().Format(time.RFC3339))
+		incMetric("leader_elections")
+	case !leader && e.leader:
+		e.log.Warnf("Leader election: leadership lost")
+	}
+	e.leader = leader
+}
+
+// Periodically check leadership, the lock is released once ctx is done
+func (e *LeaderElection) RunLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			e.mutex.Lock()
+			defer e.mutex.Unlock()
+			if err := e.lock.Release(); err != nil {
+				e.log.Warnf("Leader election: error releasing the leader lock: %v", err)
+			}
+			e.leader = false
+			return
+		case <-ticker.C:
+			e.Check(ctx)
+		}
+	}
+}
+
+func (e *LeaderElection) WritePrometheus(w io.Writer) {
+	leader := 0
+	if e.IsLeader() {
+		leader = 1
+	}
+	fmt.Fprintf(w, "# HELP uptime_leader Whether the replica is the leader running singleton background jobs.\n# TYPE uptime_leader gauge\nuptime_leader %d\n", leader)
+}
@@ -0,0 +1,95 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Lock shared by replicas of a test, as the advisory lock is
+type testLeaderLock struct {
+	mutex  *sync.Mutex
+	holder *string
+	name   string
+	err    error
+}
+
+func (l *testLeaderLock) TryAcquire(ctx context.Context) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.err != nil {
+		return false, l.err
+	}
+	if *l.holder == "" {
+		*l.holder = l.name
+	}
+	return *l.holder == l.name, nil
+}
+
+func (l *testLeaderLock) Release() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if *l.holder == l.name {
+		*l.holder = ""
+	}
+	return nil
+}
+
+func TestLeaderElection(t *testing.T) {
+	var single *LeaderElection
+	if !single.IsLeader() {
+		t.Fatal("expected a single replica to be the leader")
+	}
+
+	var mutex sync.Mutex
+	var holder string
+	log := logging.Logger("test")
+	tm := &timeMock{time: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	lockA := &testLeaderLock{mutex: &mutex, holder: &holder, name: "a"}
+	a := NewLeaderElection(lockA, tm.Now, log)
+	b := NewLeaderElection(&testLeaderLock{mutex: &mutex, holder: &holder, name: "b"}, tm.Now, log)
+	ctx := context.Background()
+	a.Check(ctx)
+	b.Check(ctx)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("expected a single leader, a: %v, b: %v", a.IsLeader(), b.IsLeader())
+	}
+
+	// The leader losing the database gives up leadership, the lock
+	// is released with its session and another replica takes over
+	lockA.err = errors.New("connection refused")
+	a.Check(ctx)
+	if a.IsLeader() {
+		t.Fatal("expected leadership given up on error")
+	}
+	holder = ""
+	b.Check(ctx)
+	if !b.IsLeader() {
+		t.Fatal("expected b to take over")
+	}
+
+	var buf bytes.Buffer
+	b.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), "uptime_leader 1") {
+		t.Fatalf("unexpected metrics:\n%s", buf.String())
+	}
+
+	// The lock is released on shutdown
+	loopCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		b.RunLoop(loopCtx, time.Hour)
+		close(done)
+	}()
+	cancel()
+	<-done
+	if b.IsLeader() || holder != "" {
+		t.Fatalf("expected the lock released, held by %q", holder)
+	}
+}
@@ -0,0 +1,305 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Days of history of submitters by default
+const LEADERBOARD_DEFAULT_HISTORY_DAYS = 30
+
+// LeaderboardTier of submitters with an uptime of at least MinUptime
+type LeaderboardTier struct {
+	Name      string  `json:"name"`
+	MinUptime float64 `json:"min_uptime"`
+}
+
+// LeaderboardEntry of a submitter ranked by uptime, submitters of equal
+// uptime share their rank
+type LeaderboardEntry struct {
+	Rank      int     `json:"rank"`
+	Submitter string  `json:"submitter"`
+	Uptime    float64 `json:"uptime"`
+	Intervals int     `json:"intervals"`
+	Tier      string  `json:"tier,omitempty"`
+}
+
+// Cutoff of a tier, with the submitters ranked in it
+type leaderboardTierCutoff struct {
+	LeaderboardTier
+	Submitters int `json:"submitters"`
+}
+
+type leaderboardResponse struct {
+	ComputedAt time.Time               `json:"computed_at"`
+	WindowDays int                     `json:"window_days"`
+	Intervals  int                     `json:"intervals"`
+	Tiers      []leaderboardTierCutoff `json:"tiers"`
+	Entries    []LeaderboardEntry      `json:"entries"`
+}
+
+// Entry of a submitter in the latest snapshot of a day
+type leaderboardHistoryEntry struct {
+	Day        string    `json:"day"`
+	ComputedAt time.Time `json:"computed_at"`
+	LeaderboardEntry
+}
+
+type leaderboardHistoryResponse struct {
+	Submitter  string                    `json:"submitter"`
+	WindowDays int                       `json:"window_days"`
+	History    []leaderboardHistoryEntry `json:"history"`
+}
+
+// Days of history of submitters
+func (cfg *LeaderboardConfig) History() int {
+	if cfg.HistoryDays > 0 {
+		return cfg.HistoryDays
+	}
+	return LEADERBOARD_DEFAULT_HISTORY_DAYS
+}
+
+// Leaderboard ranks submitters by the uptime of the snapshots of a scorer.
+// The history of submitters is read from the latest snapshots of past
+// days, which don't change anymore and are kept in memory.
+type Leaderboard struct {
+	Scorer *Scorer
+	// Sorted by decreasing uptime
+	Tiers       []LeaderboardTier
+	HistoryDays int
+	mutex       sync.Mutex
+	// Latest snapshots of past days, nil for days without snapshot
+	days map[string]*ScoreSnapshot
+	now  nowFunc
+}
+
+func NewLeaderboard(scorer *Scorer, config *LeaderboardConfig, now nowFunc) *Leaderboard {
+	tiers := append([]LeaderboardTier(nil), config.Tiers...)
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].MinUptime > tiers[j].MinUptime })
+	return &Leaderboard{
+		Scorer:      scorer,
+		Tiers:       tiers,
+		HistoryDays: config.History(),
+		days:        make(map[string]*ScoreSnapshot),
+		now:         now,
+	}
+}
+
+// Tier of the uptime, empty below the lowest tier
+func (l *Leaderboard) tier(uptime float64) string {
+	for _, tier := range l.Tiers {
+		if uptime >= tier.MinUptime {
+			return tier.Name
+		}
+	}
+	return ""
+}
+
+// Rank the submitters of the window by decreasing uptime, then by public
+// key
+func (l *Leaderboard) Rank(w ScoreWindow) []LeaderboardEntry {
+	entries := make([]LeaderboardEntry, 0, len(w.Scores))
+	for submitter, score := range w.Scores {
+		entries = append(entries, LeaderboardEntry{Submitter: submitter, Uptime: score.Uptime, Intervals: score.Intervals, Tier: l.tier(score.Uptime)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Uptime != entries[j].Uptime {
+			return entries[i].Uptime > entries[j].Uptime
+		}
+		return entries[i].Submitter < entries[j].Submitter
+	})
+	for i := range entries {
+		if i > 0 && entries[i].Uptime == entries[i-1].Uptime {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+	return entries
+}
+
+// Window of the snapshot, the first one if days is 0
+func snapshotWindow(snapshot *ScoreSnapshot, days int) (ScoreWindow, bool) {
+	for _, w := range snapshot.Windows {
+		if days == 0 || w.Days == days {
+			return w, true
+		}
+	}
+	return ScoreWindow{}, false
+}
+
+// History of the submitter over the window of days, from the latest
+// snapshot of each of the past days and the latest snapshot
+func (l *Leaderboard) History(ctx context.Context, submitter string, days int) ([]leaderboardHistoryEntry, error) {
+	today := l.now().UTC()
+	history := make([]leaderboardHistoryEntry, 0, l.HistoryDays)
+	for i := l.HistoryDays - 1; i >= 0; i-- {
+		day := today.Add(-time.Duration(i) * 24 * time.Hour).Format("2006-01-02")
+		var snapshot *ScoreSnapshot
+		var err error
+		if i == 0 {
+			snapshot, err = l.Scorer.Latest(ctx)
+		} else {
+			snapshot, err = l.pastDay(ctx, day)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		// The latest snapshot may be of a past day, without run since
+		if snapshot == nil || snapshot.ComputedAt.UTC().Format("2006-01-02") != day {
+			continue
+		}
+		w, ok := snapshotWindow(snapshot, days)
+		if !ok {
+			continue
+		}
+		for _, entry := range l.Rank(w) {
+			if entry.Submitter == submitter {
+				history = append(history, leaderboardHistoryEntry{Day: day, ComputedAt: snapshot.ComputedAt, LeaderboardEntry: entry})
+				break
+			}
+		}
+	}
+	return history, nil
+}
+
+// Latest snapshot of a past day, nil if the day has none
+func (l *Leaderboard) pastDay(ctx context.Context, day string) (*ScoreSnapshot, error) {
+	l.mutex.Lock()
+	snapshot, ok := l.days[day]
+	l.mutex.Unlock()
+	if ok {
+		return snapshot, nil
+	}
+	snapshot, err := ReadScores(ctx, l.Scorer.Source, ScoresDayPath(day))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.days[day] = snapshot
+	// Forget days out of the history
+	oldest := l.now().UTC().Add(-time.Duration(l.HistoryDays) * 24 * time.Hour).Format("2006-01-02")
+	for d := range l.days {
+		if d < oldest {
+			delete(l.days, d)
+		}
+	}
+	return snapshot, nil
+}
+
+// Handler serves the leaderboard of the latest snapshot at /v1/leaderboard,
+// as JSON or as CSV with `?format=csv`:
+//
+//	GET /v1/leaderboard?window=<days>&limit=<n>   rankings and tier cutoffs
+//	GET /v1/leaderboard?submitter=<pk>           history of a submitter
+func (l *Leaderboard) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format != "" && format != "json" && format != "csv" {
+			writeJSON(rw, http.StatusBadRequest, errorResponse{"Invalid format, expected json or csv"})
+			return
+		}
+		days, limit := 0, 0
+		for _, param := range []struct {
+			name  string
+			value *int
+		}{{"window", &days}, {"limit", &limit}} {
+			if query.Get(param.name) == "" {
+				continue
+			}
+			v, err := strconv.Atoi(query.Get(param.name))
+			if err != nil || v <= 0 {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{"Invalid " + param.name + ", expected a positive number"})
+				return
+			}
+			*param.value = v
+		}
+		snapshot, err := l.Scorer.Latest(r.Context())
+		if errors.Is(err, fs.ErrNotExist) {
+			writeJSON(rw, http.StatusNotFound, errorResponse{"No scores computed yet"})
+			return
+		}
+		if err != nil {
+			writeJSON(rw, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		w, ok := snapshotWindow(snapshot, days)
+		if !ok {
+			writeJSON(rw, http.StatusNotFound, errorResponse{"Window not scored"})
+			return
+		}
+
+		if submitter := query.Get("submitter"); submitter != "" {
+			history, err := l.History(r.Context(), submitter, w.Days)
+			if err != nil {
+				writeJSON(rw, http.StatusInternalServerError, errorResponse{err.Error()})
+				return
+			}
+			if format == "csv" {
+				rows := [][]string{{"day", "computed_at", "rank", "uptime", "intervals", "tier"}}
+				for _, h := range history {
+					rows = append(rows, []string{h.Day, h.ComputedAt.UTC().Format(time.RFC3339), strconv.Itoa(h.Rank),
+						strconv.FormatFloat(h.Uptime, 'f', 2, 64), strconv.Itoa(h.Intervals), h.Tier})
+				}
+				writeCSV(rw, "leaderboard-history.csv", rows)
+				return
+			}
+			writeJSON(rw, http.StatusOK, leaderboardHistoryResponse{Submitter: submitter, WindowDays: w.Days, History: history})
+			return
+		}
+
+		entries := l.Rank(w)
+		tiers := make([]leaderboardTierCutoff, len(l.Tiers))
+		for i, tier := range l.Tiers {
+			tiers[i].LeaderboardTier = tier
+			for _, entry := range entries {
+				if entry.Tier == tier.Name {
+					tiers[i].Submitters++
+				}
+			}
+		}
+		if limit > 0 && len(entries) > limit {
+			entries = entries[:limit]
+		}
+		if format == "csv" {
+			rows := [][]string{{"rank", "submitter", "uptime", "intervals", "tier"}}
+			for _, e := range entries {
+				rows = append(rows, []string{strconv.Itoa(e.Rank), e.Submitter, strconv.FormatFloat(e.Uptime, 'f', 2, 64), strconv.Itoa(e.Intervals), e.Tier})
+			}
+			writeCSV(rw, "leaderboard-"+strconv.Itoa(w.Days)+"d.csv", rows)
+			return
+		}
+		writeJSON(rw, http.StatusOK, leaderboardResponse{
+			ComputedAt: snapshot.ComputedAt,
+			WindowDays: w.Days,
+			Intervals:  w.Intervals,
+			Tiers:      tiers,
+			Entries:    entries,
+		})
+	})
+}
+
+// Write the rows as a CSV attachment
+func writeCSV(rw http.ResponseWriter, filename string, rows [][]string) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	_ = w.WriteAll(rows)
+	rw.Header().Set("Content-Type", "text/csv")
+	rw.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(b.Bytes())
+}
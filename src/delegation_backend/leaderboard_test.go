@@ -0,0 +1,91 @@
+package delegation_backend
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestLeaderboard(t *testing.T) {
+	dir := t.TempDir()
+	log := logging.Logger("test")
+	overwrite := func(objs ObjectsToSave) error { return LocalFileSystemOverwrite(objs, dir) }
+	tm := &timeMock{time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	scorer := NewScorer(LocalFileSystemSource{Directory: dir}, overwrite, &ScoringConfig{WindowDays: []int{1}, SubmissionIntervalMinutes: 60}, tm.Now, log)
+	// Snapshots of two days, saved as by runs of the scorer
+	for _, snapshot := range []ScoreSnapshot{
+		{ComputedAt: tm.time, Windows: []ScoreWindow{{Days: 1, Intervals: 24, Scores: map[string]SubmitterScore{
+			"B62qa": {Intervals: 12, Uptime: 50},
+			"B62qb": {Intervals: 24, Uptime: 100},
+		}}}},
+		{ComputedAt: tm.time.Add(24 * time.Hour), Windows: []ScoreWindow{{Days: 1, Intervals: 24, Scores: map[string]SubmitterScore{
+			"B62qa": {Intervals: 24, Uptime: 100},
+			"B62qb": {Intervals: 24, Uptime: 100},
+			"B62qc": {Intervals: 6, Uptime: 25},
+		}}}},
+	} {
+		bs, _ := json.Marshal(snapshot)
+		if err := overwrite(ObjectsToSave{ScoresDayPath(snapshot.ComputedAt.Format("2006-01-02")): bs, SCORES_LATEST_PATH: bs}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tm.time = tm.time.Add(24 * time.Hour)
+	leaderboard := NewLeaderboard(scorer, &LeaderboardConfig{Tiers: []LeaderboardTier{{"silver", 50}, {"gold", 95}}, HistoryDays: 7}, tm.Now)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		leaderboard.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+	rec := get("/v1/leaderboard")
+	var rankings leaderboardResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &rankings); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body)
+	}
+	expected := []LeaderboardEntry{
+		{Rank: 1, Submitter: "B62qa", Uptime: 100, Intervals: 24, Tier: "gold"},
+		{Rank: 1, Submitter: "B62qb", Uptime: 100, Intervals: 24, Tier: "gold"},
+		{Rank: 3, Submitter: "B62qc", Uptime: 25, Intervals: 6},
+	}
+	if !reflect.DeepEqual(rankings.Entries, expected) {
+		t.Fatalf("unexpected rankings %+v", rankings.Entries)
+	}
+	if rankings.Tiers[0].Name != "gold" || rankings.Tiers[0].Submitters != 2 || rankings.Tiers[1].Submitters != 0 {
+		t.Fatalf("unexpected tiers %+v", rankings.Tiers)
+	}
+
+	rec = get("/v1/leaderboard?submitter=B62qa")
+	var history leaderboardHistoryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &history); rec.Code != http.StatusOK || err != nil || len(history.History) != 2 {
+		t.Fatalf("unexpected history %d: %s", rec.Code, rec.Body)
+	}
+	if first := history.History[0]; first.Day != "2024-05-01" || first.Rank != 2 || first.Tier != "silver" {
+		t.Fatalf("unexpected history of the first day %+v", first)
+	}
+
+	rec = get("/v1/leaderboard?format=csv&limit=1")
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || rec.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("unexpected CSV %s", rec.Body)
+	}
+	if !reflect.DeepEqual(rows, [][]string{{"rank", "submitter", "uptime", "intervals", "tier"}, {"1", "B62qa", "100.00", "24", "gold"}}) {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+
+	for url, status := range map[string]int{
+		"/v1/leaderboard?window=90":     http.StatusNotFound,
+		"/v1/leaderboard?limit=0":       http.StatusBadRequest,
+		"/v1/leaderboard?format=xml":    http.StatusBadRequest,
+		"/v1/leaderboard?submitter=B62": http.StatusOK,
+	} {
+		if rec := get(url); rec.Code != status {
+			t.Errorf("%s: expected status %d, got %d", url, status, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package delegation_backend
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// First file descriptor passed by systemd socket activation
+const SD_LISTEN_FDS_START = 3
+
+// Address the server listens to, DELEGATION_BACKEND_LISTEN_TO if not set
+func (config AppConfig) ListenAddress() string {
+	if config.ListenTo != "" {
+		return config.ListenTo
+	}
+	return DELEGATION_BACKEND_LISTEN_TO
+}
+
+// Parse the listen address into the network and the address on it:
+// `tcp` for `host:port` or a bare port, `unix` for `unix:<path>` and
+// `systemd` for `systemd` or `systemd:<name>`.
+func parseListenAddress(address string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(address, "unix:"):
+		path := strings.TrimPrefix(address, "unix:")
+		if path == "" {
+			return "", "", errors.New("expected unix:<path>")
+		}
+		return "unix", path, nil
+	case address == "systemd":
+		return "systemd", "", nil
+	case strings.HasPrefix(address, "systemd:"):
+		return "systemd", strings.TrimPrefix(address, "systemd:"), nil
+	}
+	if port, err := strconv.Atoi(address); err == nil && port >= 0 && port <= 65535 {
+		return "tcp", ":" + address, nil
+	}
+	if _, port, err := net.SplitHostPort(address); err != nil || port == "" {
+		return "", "", fmt.Errorf("expected host:port, a port, unix:<path> or systemd[:<name>], got %q", address)
+	}
+	return "tcp", address, nil
+}
+
+// Listen creates the listener of the address, one of:
+//
+//	host:port         TCP address, e.g. `:8080` or `127.0.0.1:8080`
+//	port              TCP port on all interfaces, e.g. `8080`
+//	unix:<path>       Unix domain socket, a stale socket file is replaced
+//	systemd           first socket passed by systemd socket activation
+//	systemd:<name>    socket passed by systemd, named by FileDescriptorName=
+func Listen(address string) (net.Listener, error) {
+	network, addr, err := parseListenAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	switch network {
+	case "unix":
+		// A socket left over by a previous run would make listening fail
+		if info, err := os.Stat(addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", addr); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("socket %s is in use", addr)
+			}
+			os.Remove(addr)
+		}
+		return net.Listen("unix", addr)
+	case "systemd":
+		return systemdListener(addr)
+	}
+	return net.Listen(network, addr)
+}
+
+// Listener of the socket passed by systemd, per sd_listen_fds(3)
+func systemdListener(name string) (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd, LISTEN_PID is not set to the process")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, errors.New("no sockets passed by systemd, LISTEN_FDS is not set")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < count; i++ {
+		if name != "" && (i >= len(names) || names[i] != name) {
+			continue
+		}
+		fd := SD_LISTEN_FDS_START + i
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "systemd:"+name)
+		// The listener holds a duplicate of the descriptor
+		defer f.Close()
+		return net.FileListener(f)
+	}
+	return nil, fmt.Errorf("no socket named %s passed by systemd", name)
+}
@@ -0,0 +1,84 @@
+package delegation_backend
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseListenAddress(t *testing.T) {
+	for address, expected := range map[string]string{
+		":8080":             "tcp :8080",
+		"127.0.0.1:8080":    "tcp 127.0.0.1:8080",
+		"[::1]:8080":        "tcp [::1]:8080",
+		"8080":              "tcp :8080",
+		"unix:/run/bp.sock": "unix /run/bp.sock",
+		"systemd":           "systemd ",
+		"systemd:http":      "systemd http",
+	} {
+		network, addr, err := parseListenAddress(address)
+		if err != nil || network+" "+addr != expected {
+			t.Errorf("%s: expected %s, got %s %s (error: %v)", address, expected, network, addr, err)
+		}
+	}
+	for _, address := range []string{"localhost", "localhost:", "unix:", "70000", "tcp://localhost:8080"} {
+		if _, _, err := parseListenAddress(address); err == nil {
+			t.Errorf("%s: expected an error", address)
+		}
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bp.sock")
+	// Stale socket of a previous run
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := Listen("unix:" + path)
+	if err != nil {
+		t.Fatalf("expected the stale socket replaced, got %v", err)
+	}
+	defer listener.Close()
+	if _, err := Listen("unix:" + path); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("expected the socket in use, got %v", err)
+	}
+
+	// Peers of the socket are proxies trusted to set the client address
+	res, _ := NewClientIPResolver(nil, "")
+	server := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write([]byte(res.ClientIP(r)))
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "203.0.113.7" {
+		t.Fatalf("expected the forwarded client address, got %q", body)
+	}
+}
+
+func TestListenSystemd(t *testing.T) {
+	os.Unsetenv("LISTEN_PID")
+	if _, err := Listen("systemd"); err == nil || !strings.Contains(err.Error(), "LISTEN_PID") {
+		t.Fatalf("expected no sockets passed, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"block_producers_uptime/metadata"
+)
+
+const LIVENESS_DEFAULT_TIMEOUT = 2 * time.Second
+
+// Statuses of liveness checks of nodes of submitters
+const (
+	// The daemon reported SYNCED
+	LIVENESS_SYNCED = "synced"
+	// The daemon responded with another sync status, e.g. CATCHUP
+	LIVENESS_NOT_SYNCED = "not_synced"
+	// The GraphQL API of the node couldn't be reached in time
+	LIVENESS_UNREACHABLE = "unreachable"
+	// The node responded with an error or not with the daemon status
+	LIVENESS_ERROR = "error"
+)
+
+const daemonStatusQuery = `query { daemonStatus { syncStatus blockchainLength highestBlockLengthReceived } }`
+
+type daemonStatusResponse struct {
+	Data struct {
+		DaemonStatus *struct {
+			SyncStatus                 string `json:"syncStatus"`
+			BlockchainLength           *int   `json:"blockchainLength"`
+			HighestBlockLengthReceived *int   `json:"highestBlockLengthReceived"`
+		} `json:"daemonStatus"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// LivenessChecker queries the daemon status of the node of a submitter in
+// its GraphQL API, at the address the submission came from and the
+// `graphql_control_port` of the submission, cross-checking that the node
+// is synced at the time of submission so that submissions replayed from
+// dead nodes stand out. Results are recorded in the metadata of
+// submissions, submissions are accepted regardless.
+type LivenessChecker struct {
+	Client *http.Client
+	// Check nodes at loopback, private or link-local addresses too, not
+	// checked by default so that submitters can't make the backend query
+	// hosts of its own network
+	AllowPrivateAddresses bool
+	now                   nowFunc
+}
+
+func NewLivenessChecker(config *LivenessCheckConfig, now nowFunc) *LivenessChecker {
+	return &LivenessChecker{
+		Client:                &http.Client{Timeout: config.Timeout()},
+		AllowPrivateAddresses: config.AllowPrivateAddresses,
+		now:                   now,
+	}
+}
+
+// Timeout of queries of nodes
+func (cfg *LivenessCheckConfig) Timeout() time.Duration {
+	if cfg.TimeoutMs > 0 {
+		return time.Duration(cfg.TimeoutMs) * time.Millisecond
+	}
+	return LIVENESS_DEFAULT_TIMEOUT
+}
+
+// Check the node at the host of remoteAddr, nil if it can't be checked:
+// the port isn't set or the address isn't public
+func (c *LivenessChecker) Check(ctx context.Context, remoteAddr string, port int) *metadata.Liveness {
+	if port <= 0 || port > 65535 {
+		return nil
+	}
+	ip := net.ParseIP(hostOf(remoteAddr))
+	if ip == nil || ip.IsUnspecified() || ip.IsMulticast() {
+		return nil
+	}
+	if !c.AllowPrivateAddresses && (ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()) {
+		return nil
+	}
+	url := "http://" + net.JoinHostPort(ip.String(), strconv.Itoa(port)) + "/graphql"
+	start := c.now()
+	liveness := c.query(ctx, url)
+	liveness.CheckedAt = start.UTC().Format(time.RFC3339)
+	liveness.LatencyMs = c.now().Sub(start).Milliseconds()
+	incMetric("liveness_" + liveness.Status)
+	return liveness
+}
+
+func (c *LivenessChecker) query(ctx context.Context, url string) *metadata.Liveness {
+	body, _ := json.Marshal(map[string]string{"query": daemonStatusQuery})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return &metadata.Liveness{Status: LIVENESS_ERROR, Error: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return &metadata.Liveness{Status: LIVENESS_UNREACHABLE, Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &metadata.Liveness{Status: LIVENESS_ERROR, Error: fmt.Sprintf("node responded with %s", resp.Status)}
+	}
+	var status daemonStatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return &metadata.Liveness{Status: LIVENESS_ERROR, Error: fmt.Sprintf("error decoding the response of the node: %v", err)}
+	}
+	if len(status.Errors) > 0 {
+		return &metadata.Liveness{Status: LIVENESS_ERROR, Error: "node responded with error: " + status.Errors[0].Message}
+	}
+	daemon := status.Data.DaemonStatus
+	if daemon == nil {
+		return &metadata.Liveness{Status: LIVENESS_ERROR, Error: "node responded without daemon status"}
+	}
+	liveness := &metadata.Liveness{
+		Status:                     LIVENESS_NOT_SYNCED,
+		SyncStatus:                 daemon.SyncStatus,
+		BlockchainLength:           daemon.BlockchainLength,
+		HighestBlockLengthReceived: daemon.HighestBlockLengthReceived,
+	}
+	if daemon.SyncStatus == "SYNCED" {
+		liveness.Status = LIVENESS_SYNCED
+	}
+	return liveness
+}
@@ -0,0 +1,84 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// GraphQL API of a node responding with the sync status
+func testNode(t *testing.T, syncStatus string) (port int) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/graphql" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"data":{"daemonStatus":{"syncStatus":"` + syncStatus + `","blockchainLength":1000,"highestBlockLengthReceived":1001}}}`))
+	}))
+	t.Cleanup(node.Close)
+	_, portStr, _ := net.SplitHostPort(node.Listener.Addr().String())
+	port, _ = strconv.Atoi(portStr)
+	return port
+}
+
+func TestLivenessCheck(t *testing.T) {
+	checker := NewLivenessChecker(&LivenessCheckConfig{TimeoutMs: 500, AllowPrivateAddresses: true}, time.Now)
+	ctx := context.Background()
+	liveness := checker.Check(ctx, "127.0.0.1:1234", testNode(t, "SYNCED"))
+	if liveness == nil || liveness.Status != LIVENESS_SYNCED || *liveness.BlockchainLength != 1000 || *liveness.HighestBlockLengthReceived != 1001 {
+		t.Fatalf("expected the node synced, got %+v", liveness)
+	}
+	if liveness := checker.Check(ctx, "127.0.0.1:1234", testNode(t, "CATCHUP")); liveness == nil || liveness.Status != LIVENESS_NOT_SYNCED || liveness.SyncStatus != "CATCHUP" {
+		t.Fatalf("expected the node not synced, got %+v", liveness)
+	}
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	_, portStr, _ := net.SplitHostPort(closed.Listener.Addr().String())
+	closed.Close()
+	port, _ := strconv.Atoi(portStr)
+	if liveness := checker.Check(ctx, "127.0.0.1:1234", port); liveness == nil || liveness.Status != LIVENESS_UNREACHABLE {
+		t.Fatalf("expected the node unreachable, got %+v", liveness)
+	}
+
+	// Nodes without port or in the network of the backend aren't checked
+	if liveness := checker.Check(ctx, "127.0.0.1:1234", 0); liveness != nil {
+		t.Fatalf("expected a node without port not checked, got %+v", liveness)
+	}
+	checker.AllowPrivateAddresses = false
+	for _, addr := range []string{"127.0.0.1:1234", "10.0.0.1:1234", "[fe80::1]:1234"} {
+		if liveness := checker.Check(ctx, addr, 3085); liveness != nil {
+			t.Errorf("expected the node at %s not checked, got %+v", addr, liveness)
+		}
+	}
+}
+
+func TestSubmitLivenessCheck(t *testing.T) {
+	var req submitRequest
+	if err := json.Unmarshal(readTestFile("req-with-snark", t), &req); err != nil {
+		t.Fatal(err)
+	}
+	storage, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.VerifySignatureDisabled = true
+	sh.app.LivenessChecker = NewLivenessChecker(&LivenessCheckConfig{AllowPrivateAddresses: true}, time.Now)
+	req.Data.GraphqlControlPort = testNode(t, "SYNCED")
+	body, _ := json.Marshal(req)
+
+	httpReq := httptest.NewRequest("POST", v1Submit, bytes.NewReader(body))
+	httpReq.RemoteAddr = "127.0.0.1:1234"
+	rep := httptest.NewRecorder()
+	sh.ServeHTTP(rep, httpReq)
+	if rep.Code != 200 {
+		t.Fatalf("expected the submission accepted, got %d: %s", rep.Code, rep.Body)
+	}
+	var meta MetaToBeSaved
+	saved := (*storage)[makePaths(tm.Now(), req.GetBlockDataHash(), req.Submitter).Meta]
+	if err := json.Unmarshal(saved, &meta); err != nil || meta.Liveness == nil || meta.Liveness.Status != LIVENESS_SYNCED {
+		t.Fatalf("expected the liveness of the node saved, got %s", saved)
+	}
+}
@@ -0,0 +1,178 @@
+package delegation_backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	runtimemetrics "runtime/metrics"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of load shedding
+const (
+	LOAD_SHEDDING_DEFAULT_MAX_IN_FLIGHT = 256
+	// Share of the limits beyond which only known clients are admitted
+	LOAD_SHEDDING_PRIORITY_THRESHOLD = 0.8
+	LOAD_SHEDDING_RETRY_AFTER        = 5 * time.Second
+	// Clients of accepted submissions are known for this long
+	LOAD_SHEDDING_KNOWN_CLIENT_TTL = time.Hour
+	// Interval the heap size is sampled and known clients pruned at
+	LOAD_SHEDDING_SAMPLE_INTERVAL = time.Second
+)
+
+// LoadShedder admits requests depending on the load of the server, measured
+// as the highest of the shares of in-flight requests, signature verification
+// queue depth and heap size in their limits. Once the load reaches
+// LOAD_SHEDDING_PRIORITY_THRESHOLD, only requests of known clients are
+// admitted: clients which submitted for a whitelisted submitter recently or
+// presented a verified client certificate. Once it reaches the limits, all
+// requests are rejected. Rejected requests get 503 with Retry-After before
+// their body is read, so that a herd of submissions at a slot boundary is
+// shed before it costs anything.
+type LoadShedder struct {
+	MaxInFlight int
+	// Zero disables the limit
+	MaxQueueDepth int
+	MaxHeapBytes  uint64
+	QueueDepth    func() int
+	// Resolves the client address, peer address is used if nil
+	ClientIPResolver *ClientIPResolver
+	// Paths which are never shed, e.g. health checks
+	ExemptPaths map[string]bool
+
+	inFlight  atomic.Int64
+	heapBytes atomic.Uint64
+	mutex     sync.Mutex
+	// Last accepted submission by client host
+	known    map[string]time.Time
+	now      nowFunc
+	readHeap func() uint64
+}
+
+func NewLoadShedder(maxInFlight int, now nowFunc) *LoadShedder {
+	return &LoadShedder{
+		MaxInFlight: maxInFlight,
+		known:       make(map[string]time.Time),
+		now:         now,
+		readHeap:    readHeapBytes,
+	}
+}
+
+func readHeapBytes() uint64 {
+	sample := []runtimemetrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	runtimemetrics.Read(sample)
+	if sample[0].Value.Kind() != runtimemetrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// Load of the server with the given number of requests in flight,
+// 1 being the limit
+func (s *LoadShedder) load(inFlight int64) float64 {
+	load := float64(inFlight) / float64(s.MaxInFlight)
+	if s.MaxQueueDepth > 0 && s.QueueDepth != nil {
+		load = max(load, float64(s.QueueDepth())/float64(s.MaxQueueDepth))
+	}
+	if s.MaxHeapBytes > 0 {
+		load = max(load, float64(s.heapBytes.Load())/float64(s.MaxHeapBytes))
+	}
+	return load
+}
+
+// Load returns the current load of the server, 1 being the limit
+func (s *LoadShedder) Load() float64 {
+	return s.load(s.inFlight.Load())
+}
+
+// Trust marks the client as known, called once the signature of a
+// submission of a whitelisted submitter is verified
+func (s *LoadShedder) Trust(addr string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.known[hostOf(addr)] = s.now()
+}
+
+func (s *LoadShedder) isKnown(r *http.Request, addr string) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	at, found := s.known[hostOf(addr)]
+	return found && s.now().Sub(at) < LOAD_SHEDDING_KNOWN_CLIENT_TTL
+}
+
+// Middleware rejects requests exceeding the load with 503
+func (s *LoadShedder) Middleware(next http.Handler) http.Handler {
+	retryAfter := strconv.Itoa(int(LOAD_SHEDDING_RETRY_AFTER / time.Second))
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if s.ExemptPaths[r.URL.Path] {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		inFlight := s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		load := s.load(inFlight)
+		if load >= LOAD_SHEDDING_PRIORITY_THRESHOLD {
+			addr := r.RemoteAddr
+			if s.ClientIPResolver != nil {
+				addr = s.ClientIPResolver.ClientIP(r)
+			}
+			known := s.isKnown(r, addr)
+			if load >= 1 || !known {
+				if known {
+					incMetric("load_shed_known")
+				} else {
+					incMetric("load_shed_unknown")
+				}
+				rw.Header().Set("Retry-After", retryAfter)
+				rw.Header().Set("Connection", "close")
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Sample the heap size and forget clients not seen for
+// LOAD_SHEDDING_KNOWN_CLIENT_TTL
+func (s *LoadShedder) Sample() {
+	s.heapBytes.Store(s.readHeap())
+	now := s.now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for host, at := range s.known {
+		if now.Sub(at) >= LOAD_SHEDDING_KNOWN_CLIENT_TTL {
+			delete(s.known, host)
+		}
+	}
+}
+
+// Periodically sample, until ctx is done
+func (s *LoadShedder) SampleLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sample()
+		}
+	}
+}
+
+func (s *LoadShedder) WritePrometheus(w io.Writer) {
+	s.mutex.Lock()
+	known := len(s.known)
+	s.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP uptime_load Load of the server, requests are shed from 0.8 for unknown clients and from 1 for all.\n# TYPE uptime_load gauge\nuptime_load %g\n", s.Load())
+	fmt.Fprintf(w, "# HELP uptime_requests_in_flight Requests being handled.\n# TYPE uptime_requests_in_flight gauge\nuptime_requests_in_flight %d\n", s.inFlight.Load())
+	fmt.Fprintf(w, "# HELP uptime_known_clients Clients admitted under load.\n# TYPE uptime_known_clients gauge\nuptime_known_clients %d\n", known)
+}
@@ -0,0 +1,72 @@
+package delegation_backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadShedder(t *testing.T) {
+	tm := &timeMock{time: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	s := NewLoadShedder(10, tm.Now)
+	queue := 0
+	s.QueueDepth = func() int { return queue }
+	s.MaxQueueDepth = 10
+	s.ExemptPaths = map[string]bool{"/health": true}
+	h := s.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	serve := func(path string, remoteAddr string) int {
+		r := httptest.NewRequest("POST", path, nil)
+		r.RemoteAddr = remoteAddr
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		if rw.Code == http.StatusServiceUnavailable && rw.Header().Get("Retry-After") == "" {
+			t.Fatal("expected Retry-After on shed requests")
+		}
+		return rw.Code
+	}
+	s.Trust("10.0.0.1:1234")
+
+	if code := serve("/v1/submit", "10.0.0.2:1234"); code != 200 {
+		t.Fatalf("expected requests admitted without load, got %d", code)
+	}
+
+	// Under load, only known clients are admitted
+	queue = 8
+	if code := serve("/v1/submit", "10.0.0.2:1234"); code != 503 {
+		t.Fatalf("expected unknown clients shed, got %d", code)
+	}
+	if code := serve("/v1/submit", "10.0.0.1:5678"); code != 200 {
+		t.Fatalf("expected known clients admitted, got %d", code)
+	}
+
+	// Beyond the limits, all are shed but exempt paths
+	queue = 10
+	if code := serve("/v1/submit", "10.0.0.1:5678"); code != 503 {
+		t.Fatalf("expected known clients shed, got %d", code)
+	}
+	if code := serve("/health", "10.0.0.2:1234"); code != 200 {
+		t.Fatalf("expected exempt paths admitted, got %d", code)
+	}
+
+	// Clients are forgotten once not seen for a while
+	queue = 8
+	tm.Advance(LOAD_SHEDDING_KNOWN_CLIENT_TTL)
+	s.Sample()
+	if code := serve("/v1/submit", "10.0.0.1:5678"); code != 503 {
+		t.Fatalf("expected the client forgotten, got %d", code)
+	}
+}
+
+func TestLoadShedderHeap(t *testing.T) {
+	s := NewLoadShedder(10, time.Now)
+	s.MaxHeapBytes = 100
+	s.readHeap = func() uint64 { return 90 }
+	if load := s.Load(); load != 0 {
+		t.Fatalf("expected no load before sampling, got %v", load)
+	}
+	s.Sample()
+	if load := s.Load(); load != 0.9 {
+		t.Fatalf("expected the load of the heap, got %v", load)
+	}
+}
@@ -0,0 +1,226 @@
+package delegation_backend
+
+import (
+	"expvar"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the lockout after repeated invalid signatures
+const (
+	SIGNATURE_LOCKOUT_THRESHOLD = 10
+	SIGNATURE_LOCKOUT_WINDOW    = 10 * time.Minute
+	SIGNATURE_LOCKOUT_BASE      = time.Minute
+	SIGNATURE_LOCKOUT_MAX       = time.Hour
+)
+
+// Prefixes of lockout keys
+const (
+	LOCKOUT_KEY_SUBMITTER = "submitter:"
+	LOCKOUT_KEY_IP        = "ip:"
+)
+
+type lockoutEntry struct {
+	failures     int
+	firstFailure time.Time
+	lastFailure  time.Time
+	lockedUntil  time.Time
+	// Number of lockouts so far, each one doubles the duration of the next
+	level int
+}
+
+// LockoutStatus is the state of a lockout key, as reported by the admin API
+type LockoutStatus struct {
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	Lockouts    int        `json:"lockouts"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// SignatureLockout locks out submitters and IPs repeatedly sending
+// submissions with invalid signatures. After Threshold failures within
+// the window, the key is locked out for Base duration, doubled with every
+// subsequent lockout up to Max. Keys without failures for the window
+// (and not locked out) are forgotten, resetting the escalation.
+type SignatureLockout struct {
+	mutex     sync.Mutex
+	entries   map[string]*lockoutEntry
+	threshold int
+	window    time.Duration
+	base      time.Duration
+	max       time.Duration
+	now       nowFunc
+	lastSweep time.Time
+}
+
+func NewSignatureLockout(threshold int, base time.Duration, max time.Duration, now nowFunc) *SignatureLockout {
+	l := &SignatureLockout{
+		entries:   make(map[string]*lockoutEntry),
+		threshold: threshold,
+		window:    SIGNATURE_LOCKOUT_WINDOW,
+		base:      base,
+		max:       max,
+		now:       now,
+	}
+	metrics.Set("signature_lockouts_active", expvar.Func(func() interface{} { return l.ActiveCount() }))
+	return l
+}
+
+// LockedFor returns for how long the first locked out key remains locked.
+func (l *SignatureLockout) LockedFor(keys ...string) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	for _, key := range keys {
+		if e, has := l.entries[key]; has && now.Before(e.lockedUntil) {
+			return e.lockedUntil.Sub(now), true
+		}
+	}
+	return 0, false
+}
+
+// RecordFailure counts an invalid signature for the keys,
+// locking out keys which reached the threshold.
+func (l *SignatureLockout) RecordFailure(keys ...string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	l.sweep(now)
+	for _, key := range keys {
+		e, has := l.entries[key]
+		if !has {
+			e = &lockoutEntry{}
+			l.entries[key] = e
+		}
+		if e.failures == 0 || now.Sub(e.firstFailure) >= l.window {
+			e.failures = 0
+			e.firstFailure = now
+		}
+		e.failures++
+		e.lastFailure = now
+		if e.failures >= l.threshold {
+			duration := l.base << e.level
+			if duration > l.max || duration <= 0 {
+				duration = l.max
+			} else {
+				e.level++
+			}
+			e.lockedUntil = now.Add(duration)
+			e.failures = 0
+			incMetric("signature_lockouts")
+		}
+	}
+}
+
+// RecordSuccess forgets failures of the key after a valid signature
+func (l *SignatureLockout) RecordSuccess(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.entries, key)
+}
+
+// Unlock removes the key, returns false if the key is not tracked.
+func (l *SignatureLockout) Unlock(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, has := l.entries[key]
+	delete(l.entries, key)
+	return has
+}
+
+func (l *SignatureLockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	for key, e := range l.entries {
+		if now.Sub(e.lastFailure) >= l.window && !now.Before(e.lockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (l *SignatureLockout) ActiveCount() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	n := 0
+	for _, e := range l.entries {
+		if now.Before(e.lockedUntil) {
+			n++
+		}
+	}
+	return n
+}
+
+func (l *SignatureLockout) List() []LockoutStatus {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	res := make([]LockoutStatus, 0, len(l.entries))
+	for key, e := range l.entries {
+		status := LockoutStatus{Key: key, Failures: e.failures, Lockouts: e.level}
+		if now.Before(e.lockedUntil) {
+			lockedUntil := e.lockedUntil
+			status.LockedUntil = &lockedUntil
+		}
+		res = append(res, status)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+// LockoutSnapshot is the state of a lockout key in a snapshot
+type LockoutSnapshot struct {
+	Failures     int       `json:"failures"`
+	FirstFailure time.Time `json:"first_failure"`
+	LastFailure  time.Time `json:"last_failure"`
+	LockedUntil  time.Time `json:"locked_until"`
+	Level        int       `json:"level"`
+}
+
+// Snapshot of the tracked keys
+func (l *SignatureLockout) Snapshot() map[string]LockoutSnapshot {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	res := make(map[string]LockoutSnapshot, len(l.entries))
+	for key, e := range l.entries {
+		res[key] = LockoutSnapshot{Failures: e.failures, FirstFailure: e.firstFailure, LastFailure: e.lastFailure, LockedUntil: e.lockedUntil, Level: e.level}
+	}
+	return res
+}
+
+// Restore the keys of a snapshot, replacing the state tracked of them
+func (l *SignatureLockout) Restore(entries map[string]LockoutSnapshot) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for key, e := range entries {
+		l.entries[key] = &lockoutEntry{failures: e.Failures, firstFailure: e.FirstFailure, lastFailure: e.LastFailure, lockedUntil: e.LockedUntil, level: e.Level}
+	}
+}
+
+// AdminHandler serves the administration of lockouts:
+//
+//	GET    /admin/lockouts        lists tracked keys
+//	DELETE /admin/lockouts/<key>  lifts the lockout of a key, e.g. `submitter:B62q...` or `ip:1.2.3.4`
+func (l *SignatureLockout) AdminHandler() http.Handler {
+	const path = ADMIN_API_PREFIX + "lockouts"
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, path), "/")
+		switch {
+		case r.Method == http.MethodGet && key == "":
+			writeJSON(rw, http.StatusOK, l.List())
+		case r.Method == http.MethodDelete && key != "":
+			if !l.Unlock(key) {
+				writeJSON(rw, http.StatusNotFound, errorResponse{"Lockout key not found"})
+				return
+			}
+			writeJSON(rw, http.StatusOK, HealthStatus{Status: "ok"})
+		default:
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLockoutEscalation(t *testing.T) {
+	tm := &timeMock{time: time.Now()}
+	l := NewSignatureLockout(3, time.Minute, 3*time.Minute, tm.Now)
+	key := "ip:1.2.3.4"
+	l.RecordFailure(key, "submitter:x")
+	l.RecordFailure(key)
+	if _, locked := l.LockedFor(key); locked {
+		t.Fatal("locked out below threshold")
+	}
+	l.RecordFailure(key)
+	if d, locked := l.LockedFor("other", key); !locked || d != time.Minute {
+		t.Fatalf("expected lockout for a minute, got %v", d)
+	}
+	if _, locked := l.LockedFor("submitter:x"); locked {
+		t.Fatal("unrelated key locked out")
+	}
+	durations := []time.Duration{2 * time.Minute, 3 * time.Minute, 3 * time.Minute}
+	for _, expected := range durations {
+		tm.Advance(5 * time.Minute)
+		for i := 0; i < 3; i++ {
+			l.RecordFailure(key)
+		}
+		if d, _ := l.LockedFor(key); d != expected {
+			t.Fatalf("expected lockout for %v, got %v", expected, d)
+		}
+	}
+	// submitter:x had no failures within the window and is forgotten
+	if l.ActiveCount() != 1 || len(l.List()) != 1 {
+		t.Fatalf("unexpected state: %+v", l.List())
+	}
+	if !l.Unlock(key) || l.ActiveCount() != 0 {
+		t.Fatal("unlock failed")
+	}
+	// Escalation resets once the key is forgotten
+	tm.Advance(time.Hour)
+	l.RecordFailure("ip:5.6.7.8")
+	if len(l.List()) != 1 {
+		t.Fatalf("stale keys not forgotten: %+v", l.List())
+	}
+}
+
+func TestSubmitLockedOut(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.SignatureLockout = NewSignatureLockout(2, time.Minute, time.Hour, tm.Now)
+	badReq := req
+	badReq.Sig = mkSig()
+	badBody, _ := json.Marshal(badReq)
+	for i := 0; i < 2; i++ {
+		if rep := sh.testRequest(badBody); rep.Code != 401 {
+			t.Fatalf("expected invalid signature, got %v", rep)
+		}
+	}
+	rep := sh.testRequest(body)
+	if rep.Code != 429 || rep.Header().Get("Retry-After") != "60" {
+		t.Fatalf("expected lockout, got %v", rep)
+	}
+
+	// Admin API lifts the lockout of the submitter, IP remains locked out
+	handler := sh.app.SignatureLockout.AdminHandler()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("DELETE", "/admin/lockouts/submitter:"+req.Submitter.String(), nil))
+	if rr.Code != 200 {
+		t.Fatalf("unlock failed: %v", rr)
+	}
+	if rep := sh.testRequest(body); rep.Code != 429 {
+		t.Fatalf("expected IP lockout, got %v", rep)
+	}
+	tm.Advance(time.Minute)
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("unexpected failure after lockout expired: %v", rep)
+	}
+}
@@ -0,0 +1,199 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+	"go.uber.org/zap/zapcore"
+)
+
+// Defaults of the logging configuration
+const (
+	LOG_DEFAULT_LEVEL  = "info"
+	LOG_DEFAULT_FORMAT = "json"
+)
+
+func parseLogFormat(format string) (logging.LogFormat, error) {
+	switch strings.ToLower(format) {
+	case "", "json":
+		return logging.JSONOutput, nil
+	case "console", "plaintext":
+		return logging.PlaintextOutput, nil
+	case "color", "colorized":
+		return logging.ColorizedOutput, nil
+	}
+	return 0, fmt.Errorf("unknown log format %q, expected json, console or color", format)
+}
+
+func parseLogLevel(level string) (logging.LogLevel, error) {
+	if level == "" {
+		level = LOG_DEFAULT_LEVEL
+	}
+	return logging.LevelFromString(level)
+}
+
+// ParseSubsystemLevels parses levels in the
+// `subsystem1=level1,subsystem2=level2` format of LOG_SUBSYSTEM_LEVELS.
+func ParseSubsystemLevels(s string) (map[string]string, error) {
+	levels := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		subsystem, level, found := strings.Cut(entry, "=")
+		if !found || strings.TrimSpace(subsystem) == "" {
+			return nil, fmt.Errorf("malformed subsystem level %q, expected subsystem=level", entry)
+		}
+		levels[strings.TrimSpace(subsystem)] = strings.TrimSpace(level)
+	}
+	return levels, nil
+}
+
+// LogLevels keeps track of log levels, which can be changed at runtime
+type LogLevels struct {
+	mutex      sync.Mutex
+	level      string
+	subsystems map[string]string
+}
+
+// LogLevelsStatus is the state of log levels, as reported by the admin API
+type LogLevelsStatus struct {
+	Level      string            `json:"level"`
+	Subsystems map[string]string `json:"subsystems,omitempty"`
+}
+
+// ConfigureLogging sets up logging of all subsystems, using
+// JSON output to stderr at info level if not configured.
+func ConfigureLogging(cfg *LoggingConfig) (*LogLevels, error) {
+	if cfg == nil {
+		cfg = &LoggingConfig{}
+	}
+	format, err := parseLogFormat(cfg.Format)
+	if err != nil {
+		return nil, err
+	}
+	level, err := parseLogLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	subsystemLevels := make(map[string]logging.LogLevel, len(cfg.SubsystemLevels))
+	levels := &LogLevels{level: levelName(level), subsystems: make(map[string]string)}
+	for subsystem, s := range cfg.SubsystemLevels {
+		subsystemLevel, err := logging.LevelFromString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level of subsystem %s: %w", subsystem, err)
+		}
+		subsystemLevels[subsystem] = subsystemLevel
+		levels.subsystems[subsystem] = levelName(subsystemLevel)
+	}
+	logging.SetupLogging(logging.Config{
+		Format:          format,
+		Level:           level,
+		SubsystemLevels: subsystemLevels,
+		Stderr:          cfg.File == "",
+		File:            cfg.File,
+	})
+	return levels, nil
+}
+
+func levelName(level logging.LogLevel) string {
+	return zapcore.Level(level).String()
+}
+
+// Set changes the level of the subsystem, or of all
+// subsystems if the subsystem is "*" (dropping overrides).
+func (l *LogLevels) Set(subsystem string, level string) error {
+	lvl, err := logging.LevelFromString(level)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := logging.SetLogLevel(subsystem, level); err != nil {
+		return err
+	}
+	if subsystem == "*" {
+		l.level = levelName(lvl)
+		l.subsystems = make(map[string]string)
+	} else {
+		l.subsystems[subsystem] = levelName(lvl)
+	}
+	return nil
+}
+
+// Apply sets the level and levels of subsystems of the configuration,
+// overrides of subsystems not in the configuration are dropped.
+// Format and file of the logs are not changed.
+func (l *LogLevels) Apply(cfg *LoggingConfig) error {
+	if cfg == nil {
+		cfg = &LoggingConfig{}
+	}
+	level, err := parseLogLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	if err := l.Set("*", levelName(level)); err != nil {
+		return err
+	}
+	for subsystem, level := range cfg.SubsystemLevels {
+		// Subsystems without a logger (not used by this build) are skipped
+		if err := l.Set(subsystem, level); err != nil && !errors.Is(err, logging.ErrNoSuchLogger) {
+			return fmt.Errorf("invalid level of subsystem %s: %w", subsystem, err)
+		}
+	}
+	return nil
+}
+
+func (l *LogLevels) Status() LogLevelsStatus {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	subsystems := make(map[string]string, len(l.subsystems))
+	for subsystem, level := range l.subsystems {
+		subsystems[subsystem] = level
+	}
+	return LogLevelsStatus{Level: l.level, Subsystems: subsystems}
+}
+
+type logLevelRequest struct {
+	Subsystem string `json:"subsystem"`
+	Level     string `json:"level"`
+}
+
+// AdminHandler serves the administration of log levels:
+//
+//	GET /admin/log-level  returns the default level and overrides of subsystems
+//	PUT /admin/log-level  sets the level, body `{"subsystem": "*", "level": "debug"}`
+func (l *LogLevels) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(rw, http.StatusOK, l.Status())
+		case http.MethodPut:
+			var req logLevelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Level == "" {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{"Expected {\"subsystem\": ..., \"level\": ...}"})
+				return
+			}
+			if req.Subsystem == "" {
+				req.Subsystem = "*"
+			}
+			err := l.Set(req.Subsystem, req.Level)
+			if errors.Is(err, logging.ErrNoSuchLogger) {
+				writeJSON(rw, http.StatusNotFound, errorResponse{"Unknown subsystem"})
+				return
+			}
+			if err != nil {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{err.Error()})
+				return
+			}
+			writeJSON(rw, http.StatusOK, l.Status())
+		default:
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+		}
+	})
+}
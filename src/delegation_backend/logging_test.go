@@ -0,0 +1,93 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestConfigureLogging(t *testing.T) {
+	for _, cfg := range []LoggingConfig{
+		{Level: "verbose"},
+		{Format: "xml"},
+		{SubsystemLevels: map[string]string{"x": "loud"}},
+	} {
+		if _, err := ConfigureLogging(&cfg); err == nil {
+			t.Fatalf("expected error for %+v", cfg)
+		}
+	}
+
+	defer logging.SetupLogging(logging.GetConfig())
+	path := filepath.Join(t.TempDir(), "log.json")
+	levels, err := ConfigureLogging(&LoggingConfig{Level: "warn", File: path, SubsystemLevels: map[string]string{"logging test": "debug"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := levels.Status()
+	if status.Level != "warn" || status.Subsystems["logging test"] != "debug" {
+		t.Fatalf("unexpected levels: %+v", status)
+	}
+	logging.Logger("logging test").Debug("logged")
+	logging.Logger("logging test other").Info("not logged")
+	bs, _ := os.ReadFile(path)
+	if !strings.Contains(string(bs), "logged") || strings.Contains(string(bs), "not logged") {
+		t.Fatalf("unexpected log: %s", bs)
+	}
+}
+
+func TestLogLevelsAdmin(t *testing.T) {
+	defer logging.SetupLogging(logging.GetConfig())
+	levels, err := ConfigureLogging(&LoggingConfig{File: filepath.Join(t.TempDir(), "log.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logging.Logger("admin test")
+	handler := levels.AdminHandler()
+	put := func(body string) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("PUT", "/admin/log-level", strings.NewReader(body)))
+		return rr.Code
+	}
+	if code := put(`{"subsystem": "admin test", "level": "debug"}`); code != 200 {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if code := put(`{"subsystem": "no such subsystem", "level": "debug"}`); code != 404 {
+		t.Fatalf("expected unknown subsystem, got %d", code)
+	}
+	if code := put(`{"level": "loud"}`); code != 400 {
+		t.Fatalf("expected invalid level, got %d", code)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/log-level", nil))
+	var status LogLevelsStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Level != "info" || status.Subsystems["admin test"] != "debug" {
+		t.Fatalf("unexpected levels: %+v", status)
+	}
+
+	// Setting all subsystems drops overrides
+	if code := put(`{"subsystem": "*", "level": "error"}`); code != 200 {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if status := levels.Status(); status.Level != "error" || len(status.Subsystems) != 0 {
+		t.Fatalf("unexpected levels: %+v", status)
+	}
+}
+
+func TestParseSubsystemLevels(t *testing.T) {
+	levels, err := ParseSubsystemLevels("delegation backend=debug, rpc = warn")
+	if err != nil || len(levels) != 2 || levels["delegation backend"] != "debug" || levels["rpc"] != "warn" {
+		t.Fatalf("unexpected levels: %v, %v", levels, err)
+	}
+	if _, err := ParseSubsystemLevels("debug"); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,112 @@
+package delegation_backend
+
+import (
+	"block_producers_uptime/metadata"
+	"context"
+	"fmt"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// MetaUpgradeResult counts submissions by the version of the schema of
+// their metadata as read, before upgrades
+type MetaUpgradeResult struct {
+	Submissions int         `json:"submissions"`
+	Versions    map[int]int `json:"versions"`
+	Upgraded    int         `json:"upgraded"`
+	// Submissions which failed to be read, parsed or upgraded
+	Failed []string `json:"failed,omitempty"`
+}
+
+// MetaUpgrade rewrites the metadata of submissions of an object storage
+// saved with an earlier version of the schema to the current one, see
+// package metadata. Metadata of later versions is left alone. Upgrades
+// are idempotent, an upgrade interrupted is run again from the start.
+type MetaUpgrade struct {
+	Source    StorageSource
+	Overwrite func(ObjectsToSave) error
+	Filter    ReplayFilter
+	// Only count submissions to upgrade
+	DryRun bool
+	Log    logging.StandardLogger
+}
+
+// Run the upgrade until all submissions of the days of the filter are
+// upgraded or ctx is done
+func (u *MetaUpgrade) Run(ctx context.Context) (*MetaUpgradeResult, error) {
+	result := &MetaUpgradeResult{Versions: make(map[int]int)}
+	days, err := u.Source.Days(ctx)
+	if err != nil {
+		return result, fmt.Errorf("error listing days of submissions: %w", err)
+	}
+	for _, day := range days {
+		if !u.Filter.includes(day) {
+			continue
+		}
+		paths, err := u.Source.Submissions(ctx, day)
+		if err != nil {
+			return result, fmt.Errorf("error listing submissions of %s: %w", day, err)
+		}
+		pending := make(ObjectsToSave)
+		err = readConcurrently(ctx, u.Source, paths, func(path string, bs []byte, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			result.Submissions++
+			if err == nil {
+				err = u.upgrade(path, bs, result, pending)
+			}
+			if err != nil {
+				u.Log.Errorf("Failed to upgrade submission %s: %v", path, err)
+				result.Failed = append(result.Failed, path)
+			}
+			if len(pending) >= INTEGRITY_READ_CONCURRENCY {
+				return u.flush(pending, result)
+			}
+			return nil
+		})
+		if err == nil {
+			err = u.flush(pending, result)
+		}
+		if err != nil {
+			return result, err
+		}
+		u.Log.Infof("Upgraded metadata of %s, %d submissions upgraded so far", day, result.Upgraded)
+	}
+	return result, nil
+}
+
+// Add the metadata upgraded to pending, if of an earlier version
+func (u *MetaUpgrade) upgrade(path string, bs []byte, result *MetaUpgradeResult, pending ObjectsToSave) error {
+	version, err := metadata.Version(bs)
+	if err != nil {
+		return err
+	}
+	result.Versions[version]++
+	if version >= metadata.SCHEMA_VERSION {
+		return nil
+	}
+	upgraded, err := metadata.Upgrade(bs)
+	if err != nil {
+		return err
+	}
+	pending[path] = upgraded
+	return nil
+}
+
+// Overwrite the pending metadata, emptying pending
+func (u *MetaUpgrade) flush(pending ObjectsToSave, result *MetaUpgradeResult) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	if !u.DryRun {
+		if err := u.Overwrite(pending); err != nil {
+			return fmt.Errorf("error saving upgraded metadata: %w", err)
+		}
+	}
+	result.Upgraded += len(pending)
+	for path := range pending {
+		delete(pending, path)
+	}
+	return nil
+}
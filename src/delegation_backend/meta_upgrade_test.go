@@ -0,0 +1,79 @@
+package delegation_backend
+
+import (
+	"block_producers_uptime/metadata"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestMetaUpgrade(t *testing.T) {
+	dir := migrationTestStorage(t)
+	log := logging.Logger("test")
+	current := `{"schema_version":1,"block_hash":"3NK4"}`
+	later := `{"schema_version":2,"block_hash":"3NK5"}`
+	if err := LocalFileSystemSave(ObjectsToSave{
+		"submissions/2024-05-03/2024-05-03T10:00:00Z-B62qa.json": []byte(current),
+		"submissions/2024-05-03/2024-05-03T11:00:00Z-B62qb.json": []byte(later),
+		"submissions/2024-05-03/2024-05-03T12:00:00Z-B62qc.json": []byte(`{`),
+	}, dir, log); err != nil {
+		t.Fatal(err)
+	}
+	upgrade := &MetaUpgrade{
+		Source: LocalFileSystemSource{Directory: dir},
+		Overwrite: func(objs ObjectsToSave) error {
+			return LocalFileSystemOverwrite(objs, dir)
+		},
+		Filter: ReplayFilter{Since: "2024-05-02"},
+		DryRun: true,
+		Log:    log,
+	}
+
+	result, err := upgrade.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &MetaUpgradeResult{
+		Submissions: 5,
+		Versions:    map[int]int{0: 2, 1: 1, 2: 1},
+		Upgraded:    2,
+		Failed:      []string{"submissions/2024-05-03/2024-05-03T12:00:00Z-B62qc.json"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, result)
+	}
+	bs, _ := os.ReadFile(filepath.Join(dir, "submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json"))
+	if version, _ := metadata.Version(bs); version != 0 {
+		t.Fatal("expected nothing upgraded by a dry run")
+	}
+
+	upgrade.DryRun = false
+	if result, err := upgrade.Run(context.Background()); err != nil || !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %+v, got %+v (error: %v)", expected, result, err)
+	}
+	for path, version := range map[string]int{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": 0,
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json": metadata.SCHEMA_VERSION,
+		"submissions/2024-05-02/2024-05-02T11:00:00Z-B62qb.json": metadata.SCHEMA_VERSION,
+		"submissions/2024-05-03/2024-05-03T11:00:00Z-B62qb.json": 2,
+	} {
+		bs, _ := os.ReadFile(filepath.Join(dir, path))
+		if got, err := metadata.Version(bs); err != nil || got != version {
+			t.Errorf("expected %s of version %d, got %d (error: %v)", path, version, got, err)
+		}
+	}
+	bs, _ = os.ReadFile(filepath.Join(dir, "submissions/2024-05-02/2024-05-02T11:00:00Z-B62qb.json"))
+	meta, err := metadata.Decode(bs)
+	if err != nil || meta.BlockHash != "3NK3" {
+		t.Fatalf("expected fields kept, got %+v (error: %v)", meta, err)
+	}
+
+	// Submissions of later versions aren't parsed
+	if _, err := parseSubmissionBytes([]byte(later), "submissions/2024-05-03/2024-05-03T11:00:00Z-B62qb.json"); err == nil {
+		t.Fatal("expected a submission of a later version rejected")
+	}
+}
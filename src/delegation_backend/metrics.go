@@ -0,0 +1,38 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"expvar"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Counters of the service, exported through expvar
+// (served at /debug/vars) under the `delegation_backend` key.
+var metrics = expvar.NewMap("delegation_backend")
+
+func incMetric(name string) {
+	metrics.Add(name, 1)
+}
+
+// PrometheusCollector writes its metrics in the Prometheus text format
+type PrometheusCollector interface {
+	WritePrometheus(w io.Writer)
+}
+
+func escapePrometheusLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// PrometheusHandler serves the metrics of all collectors
+func PrometheusHandler(collectors ...PrometheusCollector) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var b bytes.Buffer
+		for _, c := range collectors {
+			c.WritePrometheus(&b)
+		}
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = rw.Write(b.Bytes())
+	})
+}
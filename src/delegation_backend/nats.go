@@ -0,0 +1,225 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Defaults of NATS JetStream publishing
+const (
+	NATS_DEFAULT_STREAM         = "UPTIME"
+	NATS_DEFAULT_SUBJECT_PREFIX = "uptime"
+	NATS_DEFAULT_ACK_TIMEOUT    = 5 * time.Second
+	// Publishes awaiting their acknowledgement, across submissions
+	NATS_MAX_PENDING_ACKS = 4096
+)
+
+// Reference of a block, published instead of its data
+type NATSBlockReference struct {
+	BlockHash string `json:"block_hash"`
+	Path      string `json:"path"`
+	Size      int    `json:"size"`
+}
+
+// NATSPublisher publishes submissions to NATS JetStream. Every message is
+// published with the path of its object as Nats-Msg-Id, and a save
+// succeeds only once the stream acknowledged its messages, so that saving
+// a submission again doesn't duplicate them within the duplicate window
+// of the stream. Messages of concurrent saves are published asynchronously
+// over one connection, which reconnects by itself.
+type NATSPublisher struct {
+	// URL of the server, without credentials
+	URL    string
+	Stream string
+	// Subjects are <SubjectPrefix>.submissions and <SubjectPrefix>.blocks
+	SubjectPrefix string
+	// Publish references of blocks, blocks aren't published otherwise
+	PublishBlocks bool
+	MaxAge        time.Duration
+	Replicas      int
+	Timeout       time.Duration
+
+	options []nats.Option
+	mutex   sync.Mutex
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+}
+
+// NewNATSPublisher creates the publisher of submissions of the network,
+// it connects on first use
+func NewNATSPublisher(cfg *NATSConfig, network string) (*NATSPublisher, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") {
+		return nil, fmt.Errorf("invalid NATS URL %q, expected nats://host:port or tls://host:port", cfg.URL)
+	}
+	p := &NATSPublisher{
+		Stream:        cfg.Stream,
+		SubjectPrefix: cfg.SubjectPrefix,
+		PublishBlocks: cfg.PublishBlocks,
+		MaxAge:        time.Duration(cfg.MaxAgeDays) * 24 * time.Hour,
+		Replicas:      cfg.Replicas,
+		Timeout:       NATS_DEFAULT_ACK_TIMEOUT,
+	}
+	if p.Stream == "" {
+		p.Stream = NATS_DEFAULT_STREAM
+	}
+	if p.SubjectPrefix == "" {
+		p.SubjectPrefix = NATS_DEFAULT_SUBJECT_PREFIX + "." + network
+	}
+	if p.Replicas == 0 {
+		p.Replicas = 1
+	}
+	if cfg.AckTimeoutMs > 0 {
+		p.Timeout = time.Duration(cfg.AckTimeoutMs) * time.Millisecond
+	}
+	token, user, password := cfg.Token, cfg.User, cfg.Password
+	if u.User != nil && user == "" && token == "" {
+		if pass, ok := u.User.Password(); ok {
+			user, password = u.User.Username(), pass
+		} else {
+			token = u.User.Username()
+		}
+	}
+	u.User = nil
+	p.URL = u.String()
+	p.options = []nats.Option{
+		nats.Name("uptime-service-backend"),
+		nats.Timeout(p.Timeout),
+		// Keep reconnecting, saves fail meanwhile
+		nats.MaxReconnects(-1),
+	}
+	if token != "" {
+		p.options = append(p.options, nats.Token(token))
+	} else if user != "" {
+		p.options = append(p.options, nats.UserInfo(user, password))
+	}
+	if cfg.TLSCAFile != "" {
+		p.options = append(p.options, nats.RootCAs(cfg.TLSCAFile))
+	}
+	if cfg.TLSCertFile != "" {
+		p.options = append(p.options, nats.ClientCert(cfg.TLSCertFile, cfg.TLSKeyFile))
+	}
+	return p, nil
+}
+
+// JetStream context of the connection, connecting if not connected yet
+func (p *NATSPublisher) jetStream() (nats.JetStreamContext, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.js != nil {
+		return p.js, nil
+	}
+	conn, err := nats.Connect(p.URL, p.options...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := conn.JetStream(nats.PublishAsyncMaxPending(NATS_MAX_PENDING_ACKS))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p.conn, p.js = conn, js
+	return js, nil
+}
+
+// EnsureStream creates the stream of submissions, or updates it to the
+// configuration if it exists
+func (p *NATSPublisher) EnsureStream() error {
+	js, err := p.jetStream()
+	if err != nil {
+		return err
+	}
+	config := &nats.StreamConfig{
+		Name:      p.Stream,
+		Subjects:  []string{p.SubjectPrefix + ".>"},
+		Storage:   nats.FileStorage,
+		Retention: nats.LimitsPolicy,
+		MaxAge:    p.MaxAge,
+		Replicas:  p.Replicas,
+	}
+	_, err = js.StreamInfo(p.Stream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(config)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	_, err = js.UpdateStream(config)
+	return err
+}
+
+// NATSSave publishes the metadata of submissions and, if enabled,
+// references of blocks. Other objects are skipped.
+func (p *NATSPublisher) NATSSave(objs ObjectsToSave) error {
+	return p.NATSSaveContext(context.Background(), objs)
+}
+
+// NATSSaveContext publishes like NATSSave, acknowledgements are awaited
+// until the ack timeout, or the deadline of ctx if sooner
+func (p *NATSPublisher) NATSSaveContext(ctx context.Context, objs ObjectsToSave) error {
+	js, err := p.jetStream()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	type published struct {
+		path string
+		ack  nats.PubAckFuture
+	}
+	var acks []published
+	for path, bs := range objs {
+		var subject string
+		var payload []byte
+		switch {
+		case strings.HasPrefix(path, SUBMISSIONS_PREFIX):
+			// Kept by the client for retries until acknowledged, objects
+			// are copied out of the buffers of the request by SaveToBackends
+			subject, payload = p.SubjectPrefix+".submissions", bs
+		case strings.HasPrefix(path, BLOCKS_PREFIX) && p.PublishBlocks:
+			subject = p.SubjectPrefix + ".blocks"
+			payload, _ = json.Marshal(NATSBlockReference{
+				BlockHash: strings.TrimSuffix(strings.TrimPrefix(path, BLOCKS_PREFIX), ".dat"),
+				Path:      path,
+				Size:      len(bs),
+			})
+		default:
+			continue
+		}
+		ack, err := js.PublishAsync(subject, payload, nats.MsgId(path))
+		if err != nil {
+			return fmt.Errorf("error publishing %s: %w", path, err)
+		}
+		acks = append(acks, published{path, ack})
+	}
+	for _, pub := range acks {
+		select {
+		case <-pub.ack.Ok():
+		case err := <-pub.ack.Err():
+			return fmt.Errorf("error publishing %s: %w", pub.path, err)
+		case <-ctx.Done():
+			return fmt.Errorf("error publishing %s: %w", pub.path, ctx.Err())
+		}
+	}
+	return nil
+}
+
+func (p *NATSPublisher) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	p.conn.Close()
+	p.conn, p.js = nil, nil
+	return nil
+}
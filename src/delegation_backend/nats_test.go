@@ -0,0 +1,192 @@
+package delegation_backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// NATS server with JetStream, requiring the token if set
+func runNATSServer(t *testing.T, token string, tlsConfig *tls.Config) *server.Server {
+	opts := &server.Options{
+		Host:          "127.0.0.1",
+		Port:          -1,
+		JetStream:     true,
+		StoreDir:      t.TempDir(),
+		Authorization: token,
+		NoLog:         true,
+		NoSigs:        true,
+	}
+	if tlsConfig != nil {
+		opts.TLS, opts.TLSConfig = true, tlsConfig
+	}
+	srv, err := server.NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+// Messages of the stream by subject, in order
+func streamMessages(t *testing.T, publisher *NATSPublisher) map[string][]string {
+	js, err := publisher.jetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := js.StreamInfo(publisher.Stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := make(map[string][]string)
+	for seq := info.State.FirstSeq; seq <= info.State.LastSeq && info.State.Msgs > 0; seq++ {
+		msg, err := js.GetMsg(publisher.Stream, seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs[msg.Subject] = append(msgs[msg.Subject], string(msg.Data))
+	}
+	return msgs
+}
+
+func TestNATSPublisher(t *testing.T) {
+	srv := runNATSServer(t, "s3cr3t", nil)
+	publisher, err := NewNATSPublisher(&NATSConfig{URL: srv.ClientURL(), Token: "s3cr3t", PublishBlocks: true, MaxAgeDays: 7}, "mainnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	if err := publisher.EnsureStream(); err != nil {
+		t.Fatalf("expected the stream created, got %v", err)
+	}
+	js, _ := publisher.jetStream()
+	info, err := js.StreamInfo("UPTIME")
+	if err != nil || len(info.Config.Subjects) != 1 || info.Config.Subjects[0] != "uptime.mainnet.>" || info.Config.MaxAge != 7*24*time.Hour {
+		t.Fatalf("unexpected stream %+v, error: %v", info, err)
+	}
+	// Updated if it exists
+	if err := publisher.EnsureStream(); err != nil {
+		t.Fatalf("expected the stream updated, got %v", err)
+	}
+
+	var pk Pk
+	paths := makePaths(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), "3NKd", pk)
+	objs := ObjectsToSave{paths.Meta: []byte(`{"submitter":"B62q"}`), paths.Block: []byte("block"), "audit/2024-05-01.jsonl": []byte("{}")}
+	// Saving again is deduplicated by the stream
+	for i := 0; i < 2; i++ {
+		if err := publisher.NATSSave(objs); err != nil {
+			t.Fatalf("expected the submission published, got %v", err)
+		}
+	}
+	msgs := streamMessages(t, publisher)
+	if subs := msgs["uptime.mainnet.submissions"]; len(subs) != 1 || subs[0] != `{"submitter":"B62q"}` {
+		t.Fatalf("expected the metadata published once, got %q", subs)
+	}
+	var ref NATSBlockReference
+	if blocks := msgs["uptime.mainnet.blocks"]; len(blocks) != 1 || json.Unmarshal([]byte(blocks[0]), &ref) != nil || ref != (NATSBlockReference{BlockHash: "3NKd", Path: paths.Block, Size: 5}) {
+		t.Fatalf("expected the reference of the block published, got %q", blocks)
+	}
+
+	// Publishes the stream doesn't acknowledge fail
+	publisher.SubjectPrefix = "other"
+	if err := publisher.NATSSave(objs); err == nil {
+		t.Fatal("expected the publish failed")
+	}
+	wrong, _ := NewNATSPublisher(&NATSConfig{URL: srv.ClientURL(), Token: "wrong"}, "mainnet")
+	if err := wrong.NATSSave(objs); !errors.Is(err, nats.ErrAuthorization) {
+		t.Fatalf("expected the connection refused, got %v", err)
+	}
+}
+
+// Self-signed certificate of 127.0.0.1, written to dir
+func writeTestServerCert(t *testing.T, dir string) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "nats.crt")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, certFile
+}
+
+func TestNATSPublisherTLS(t *testing.T) {
+	cert, caFile := writeTestServerCert(t, t.TempDir())
+	srv := runNATSServer(t, "", &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	url := strings.Replace(srv.ClientURL(), "nats://", "tls://", 1)
+
+	untrusted, _ := NewNATSPublisher(&NATSConfig{URL: url}, "mainnet")
+	if err := untrusted.EnsureStream(); err == nil {
+		t.Fatal("expected a server of an unknown CA refused")
+	}
+	publisher, err := NewNATSPublisher(&NATSConfig{URL: url, TLSCAFile: caFile}, "mainnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	if err := publisher.EnsureStream(); err != nil {
+		t.Fatalf("expected the stream created over TLS, got %v", err)
+	}
+	var pk Pk
+	paths := makePaths(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), "3NKd", pk)
+	if err := publisher.NATSSave(ObjectsToSave{paths.Meta: []byte(`{}`)}); err != nil {
+		t.Fatalf("expected the submission published over TLS, got %v", err)
+	}
+}
+
+func TestNATSConfig(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	mockLogger := &MockLogger{}
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"network_name": "mainnet", "delegation_whitelist_disabled": true, "filesystem": {"path": "/tmp"},
+		"nats": {"url": "nats://localhost:4222", "stream": "SUBMISSIONS"}}`), 0644)
+
+	os.Setenv("NATS_PUBLISH_BLOCKS", "1")
+	config := LoadConfig(path, mockLogger)
+	if mockLogger.lastMessage != "" {
+		t.Fatalf("Unexpected fatal error: %s", mockLogger.lastMessage)
+	}
+	if config.NATS.Stream != "SUBMISSIONS" || !config.NATS.PublishBlocks {
+		t.Fatalf("Unexpected NATS configuration %+v", config.NATS)
+	}
+
+	os.Setenv("NATS_URL", "localhost:4222")
+	os.Setenv("NATS_SUBJECT_PREFIX", "uptime.>")
+	LoadConfig(path, mockLogger)
+	for _, expected := range []string{`expected nats://host:port or tls://host:port, got "localhost:4222"`, `nats.subject_prefix (NATS_SUBJECT_PREFIX)`} {
+		if !strings.Contains(mockLogger.lastMessage, expected) {
+			t.Errorf("Expected %s in: %s", expected, mockLogger.lastMessage)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package delegation_backend
+
+import (
+	"net"
+	"net/http"
+)
+
+// NetworkFilter rejects requests from clients outside of the allowlist
+// or inside of the denylist. Denylist takes precedence, empty allowlist
+// allows every address not denied.
+type NetworkFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	// Resolves the client address, peer address is used if nil
+	ClientIPResolver *ClientIPResolver
+	// Paths which are never filtered, e.g. health checks
+	ExemptPaths map[string]bool
+}
+
+func NewNetworkFilter(allow []string, deny []string) (*NetworkFilter, error) {
+	allowNets, err := parseCIDRs(allow, "allowlist")
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := parseCIDRs(deny, "denylist")
+	if err != nil {
+		return nil, err
+	}
+	return &NetworkFilter{allow: allowNets, deny: denyNets}, nil
+}
+
+// Check returns the reason for rejecting the address, or empty string
+// if the address is allowed. Unparseable addresses are rejected when
+// any list is configured.
+func (f *NetworkFilter) Check(addr string) string {
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return ""
+	}
+	ip := parseHeaderIP(addr)
+	switch {
+	case ip == nil:
+		return "invalid_address"
+	case containsIP(f.deny, ip):
+		return "denied"
+	case len(f.allow) > 0 && !containsIP(f.allow, ip):
+		return "not_allowed"
+	}
+	return ""
+}
+
+// Middleware rejects filtered requests with 403 before anything
+// of the request body is read.
+func (f *NetworkFilter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if f.ExemptPaths[r.URL.Path] {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		addr := r.RemoteAddr
+		if f.ClientIPResolver != nil {
+			addr = f.ClientIPResolver.ClientIP(r)
+		}
+		if reason := f.Check(addr); reason != "" {
+			incMetric("network_filter_dropped_" + reason)
+			rw.Header().Set("Connection", "close")
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,72 @@
+package delegation_backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNetworkFilterCheck(t *testing.T) {
+	f, err := NewNetworkFilter([]string{"10.0.0.0/8", "192.168.1.1"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"10.0.0.1:1234":    "",
+		"10.0.0.1":         "",
+		"192.168.1.1:80":   "",
+		"192.168.1.2:80":   "not_allowed",
+		"10.1.2.3:1234":    "denied",
+		"[2001:db8::1]:80": "not_allowed",
+		"garbage":          "invalid_address",
+	}
+	for addr, expected := range cases {
+		if reason := f.Check(addr); reason != expected {
+			t.Errorf("%s: expected %q, got %q", addr, expected, reason)
+		}
+	}
+
+	f, err = NewNetworkFilter(nil, []string{"2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Check("[2001:db8::1]:80") != "denied" || f.Check("1.2.3.4:80") != "" {
+		t.Fatal("denylist only filter misbehaves")
+	}
+
+	if _, err := NewNetworkFilter([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Fatal("invalid CIDR accepted")
+	}
+}
+
+func TestNetworkFilterMiddleware(t *testing.T) {
+	f, _ := NewNetworkFilter([]string{"10.0.0.0/8"}, nil)
+	f.ExemptPaths = map[string]bool{"/health": true}
+	reached := false
+	handler := f.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	req := httptest.NewRequest("POST", v1Submit, nil)
+	req.RemoteAddr = "1.2.3.4:5678"
+	rep := httptest.NewRecorder()
+	handler.ServeHTTP(rep, req)
+	if rep.Code != 403 || reached {
+		t.Fatalf("request from outside of allowlist passed: %d", rep.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/health", nil)
+	req.RemoteAddr = "1.2.3.4:5678"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !reached {
+		t.Fatal("exempt path filtered")
+	}
+
+	reached = false
+	req = httptest.NewRequest("POST", v1Submit, nil)
+	req.RemoteAddr = "10.0.0.1:5678"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !reached {
+		t.Fatal("request from allowlist filtered")
+	}
+}
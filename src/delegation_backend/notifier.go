@@ -0,0 +1,356 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Events alerts are sent on
+const (
+	ALERT_WHITELIST_REFRESH_FAILING = "whitelist_refresh_failing"
+	ALERT_SUBMISSIONS_STOPPED       = "submissions_stopped"
+	ALERT_STORAGE_FAILURES          = "storage_failures"
+	ALERT_CANARY_FAILING            = "canary_failing"
+	ALERT_SUBMITTER_SILENT          = "submitter_silent"
+)
+
+var ALERT_EVENTS = []string{ALERT_WHITELIST_REFRESH_FAILING, ALERT_SUBMISSIONS_STOPPED, ALERT_STORAGE_FAILURES, ALERT_CANARY_FAILING, ALERT_SUBMITTER_SILENT}
+
+// Defaults of alerting
+const (
+	ALERT_DEFAULT_COOLDOWN                    = time.Hour
+	ALERT_DEFAULT_WHITELIST_FAILURE_THRESHOLD = 3
+	ALERT_DEFAULT_SUBMISSIONS_IDLE            = 30 * time.Minute
+)
+
+const ALERT_QUEUE_SIZE = 100
+const ALERT_SEND_TIMEOUT = 10 * time.Second
+
+// Alert is an operational event worth notifying operators about
+type Alert struct {
+	Event string `json:"event"`
+	// What the alert is about, e.g. a submitter, alerts of an event are
+	// repeated and resolved by subject
+	Subject string `json:"subject,omitempty"`
+	Message string `json:"message"`
+	// The condition of an earlier alert of the event is over
+	Resolved bool              `json:"resolved"`
+	At       time.Time         `json:"at"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+func (a *Alert) text() string {
+	var b strings.Builder
+	if a.Resolved {
+		b.WriteString("[resolved] ")
+	} else {
+		b.WriteString("[alert] ")
+	}
+	b.WriteString(a.Message)
+	keys := make([]string, 0, len(a.Fields))
+	for k := range a.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n%s: %s", k, a.Fields[k])
+	}
+	return b.String()
+}
+
+// AlertSink delivers alerts to a chat or another service
+type AlertSink interface {
+	Name() string
+	Send(ctx context.Context, alert Alert) error
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// SlackSink posts alerts to a Slack incoming webhook
+type SlackSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *SlackSink) Name() string { return "slack" }
+
+func (s *SlackSink) Send(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, s.Client, s.URL, map[string]string{"text": alert.text()})
+}
+
+// DiscordSink posts alerts to a Discord webhook
+type DiscordSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *DiscordSink) Name() string { return "discord" }
+
+func (s *DiscordSink) Send(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, s.Client, s.URL, map[string]string{"content": alert.text()})
+}
+
+// WebhookSink posts alerts as JSON to any URL
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Send(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, s.Client, s.URL, alert)
+}
+
+// Notifier sends alerts of enabled events to all sinks, in the background.
+// An alert of an event is not repeated within the cooldown unless it was
+// resolved in between, resolutions are sent only for alerted events.
+type Notifier struct {
+	mutex    sync.Mutex
+	sinks    []AlertSink
+	events   map[string]bool
+	cooldown time.Duration
+	alerted  map[string]time.Time
+	queue    chan Alert
+	now      nowFunc
+	log      logging.StandardLogger
+}
+
+// NewNotifier creates the notifier, alerting on all events if events is empty
+func NewNotifier(sinks []AlertSink, events []string, cooldown time.Duration, now nowFunc, log logging.StandardLogger) (*Notifier, error) {
+	n := &Notifier{
+		sinks:    sinks,
+		cooldown: cooldown,
+		alerted:  make(map[string]time.Time),
+		queue:    make(chan Alert, ALERT_QUEUE_SIZE),
+		now:      now,
+		log:      log,
+	}
+	if len(events) > 0 {
+		n.events = make(map[string]bool)
+		for _, event := range events {
+			known := false
+			for _, e := range ALERT_EVENTS {
+				known = known || e == event
+			}
+			if !known {
+				return nil, fmt.Errorf("unknown alert event %q, expected one of %s", event, strings.Join(ALERT_EVENTS, ", "))
+			}
+			n.events[event] = true
+		}
+	}
+	return n, nil
+}
+
+// NewNotifierFromConfig creates the notifier with the sinks configured
+func NewNotifierFromConfig(cfg *AlertingConfig, log logging.StandardLogger) (*Notifier, error) {
+	client := &http.Client{Timeout: ALERT_SEND_TIMEOUT}
+	var sinks []AlertSink
+	if cfg.SlackWebhookURL != "" {
+		sinks = append(sinks, &SlackSink{URL: cfg.SlackWebhookURL, Client: client})
+	}
+	if cfg.DiscordWebhookURL != "" {
+		sinks = append(sinks, &DiscordSink{URL: cfg.DiscordWebhookURL, Client: client})
+	}
+	if cfg.WebhookURL != "" {
+		sinks = append(sinks, &WebhookSink{URL: cfg.WebhookURL, Client: client})
+	}
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("no Slack, Discord or webhook URL configured")
+	}
+	cooldown := ALERT_DEFAULT_COOLDOWN
+	if cfg.CooldownMinutes > 0 {
+		cooldown = time.Duration(cfg.CooldownMinutes) * time.Minute
+	}
+	return NewNotifier(sinks, cfg.Events, cooldown, time.Now, log)
+}
+
+// Notify queues the alert, it never blocks
+func (n *Notifier) Notify(alert Alert) {
+	if n.events != nil && !n.events[alert.Event] {
+		return
+	}
+	now := n.now()
+	key := alert.Event
+	if alert.Subject != "" {
+		key += "/" + alert.Subject
+	}
+	n.mutex.Lock()
+	at, alerted := n.alerted[key]
+	if alert.Resolved {
+		if !alerted {
+			n.mutex.Unlock()
+			return
+		}
+		delete(n.alerted, key)
+	} else {
+		if alerted && now.Sub(at) < n.cooldown {
+			n.mutex.Unlock()
+			return
+		}
+		n.alerted[key] = now
+	}
+	n.mutex.Unlock()
+	alert.At = now
+	select {
+	case n.queue <- alert:
+	default:
+		incMetric("alerts_dropped")
+	}
+}
+
+// Run sends queued alerts until the context is done
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alert := <-n.queue:
+			for _, sink := range n.sinks {
+				sendCtx, cancel := context.WithTimeout(ctx, ALERT_SEND_TIMEOUT)
+				err := sink.Send(sendCtx, alert)
+				cancel()
+				if err != nil {
+					incMetric("alert_send_errors")
+					n.log.Warnf("Failed to send %s alert to %s: %v", alert.Event, sink.Name(), err)
+				} else {
+					incMetric("alerts_sent")
+				}
+			}
+		}
+	}
+}
+
+// Report lets the notifier receive storage failure bursts
+// from StorageFailureMonitor.
+func (n *Notifier) Report(ev ErrorEvent) {
+	alert := Alert{Event: ALERT_STORAGE_FAILURES, Message: ev.Message, Fields: ev.Tags}
+	if ev.Err != nil {
+		alert.Fields = make(map[string]string, len(ev.Tags)+1)
+		for k, v := range ev.Tags {
+			alert.Fields[k] = v
+		}
+		alert.Fields["error"] = ev.Err.Error()
+	}
+	n.Notify(alert)
+}
+
+// FailureStreak alerts once the number of consecutive failures
+// reaches the threshold, and resolves the alert on the next success.
+type FailureStreak struct {
+	mutex     sync.Mutex
+	notifier  *Notifier
+	event     string
+	message   string
+	threshold int
+	failures  int
+}
+
+func NewFailureStreak(notifier *Notifier, event string, message string, threshold int) *FailureStreak {
+	return &FailureStreak{notifier: notifier, event: event, message: message, threshold: threshold}
+}
+
+// Record the outcome of an attempt, nil error for a success
+func (f *FailureStreak) Record(err error) {
+	f.mutex.Lock()
+	failures := f.failures
+	if err == nil {
+		f.failures = 0
+	} else {
+		f.failures++
+	}
+	f.mutex.Unlock()
+	if err == nil {
+		if failures >= f.threshold {
+			f.notifier.Notify(Alert{Event: f.event, Message: f.message + " recovered", Resolved: true})
+		}
+		return
+	}
+	if failures+1 >= f.threshold {
+		f.notifier.Notify(Alert{
+			Event:   f.event,
+			Message: fmt.Sprintf("%s, %d times in a row", f.message, failures+1),
+			Fields:  map[string]string{"error": err.Error()},
+		})
+	}
+}
+
+// IdleWatch alerts when no submission is accepted for the idle period
+type IdleWatch struct {
+	mutex    sync.Mutex
+	notifier *Notifier
+	idle     time.Duration
+	last     time.Time
+	alerted  bool
+	now      nowFunc
+}
+
+func NewIdleWatch(notifier *Notifier, idle time.Duration, now nowFunc) *IdleWatch {
+	return &IdleWatch{notifier: notifier, idle: idle, last: now(), now: now}
+}
+
+// Accepted records an accepted submission
+func (w *IdleWatch) Accepted(at time.Time) {
+	w.mutex.Lock()
+	w.last = at
+	resolved := w.alerted
+	w.alerted = false
+	w.mutex.Unlock()
+	if resolved {
+		w.notifier.Notify(Alert{Event: ALERT_SUBMISSIONS_STOPPED, Message: "Submissions are accepted again", Resolved: true})
+	}
+}
+
+// Check alerts if the idle period passed since the last accepted submission
+func (w *IdleWatch) Check() {
+	w.mutex.Lock()
+	last := w.last
+	idle := w.now().Sub(last) >= w.idle && !w.alerted
+	if idle {
+		w.alerted = true
+	}
+	w.mutex.Unlock()
+	if idle {
+		w.notifier.Notify(Alert{
+			Event:   ALERT_SUBMISSIONS_STOPPED,
+			Message: fmt.Sprintf("No submission accepted for %v", w.idle),
+			Fields:  map[string]string{"last_accepted": last.UTC().Format(time.RFC3339)},
+		})
+	}
+}
+
+// Periodically check for idleness.
+func (w *IdleWatch) CheckLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		w.Check()
+	}
+}
@@ -0,0 +1,161 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Sink collecting alerts sent to it
+type recordingSink struct {
+	alerts chan Alert
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Send(ctx context.Context, alert Alert) error {
+	s.alerts <- alert
+	return nil
+}
+
+func testNotifier(t *testing.T, events []string) (*Notifier, *recordingSink, *timeMock) {
+	tm := &timeMock{time: time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)}
+	sink := &recordingSink{alerts: make(chan Alert, ALERT_QUEUE_SIZE)}
+	notifier, err := NewNotifier([]AlertSink{sink}, events, time.Hour, tm.Now, logging.Logger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go notifier.Run(ctx)
+	return notifier, sink, tm
+}
+
+func expectAlert(t *testing.T, sink *recordingSink, event string, resolved bool) Alert {
+	select {
+	case alert := <-sink.alerts:
+		if alert.Event != event || alert.Resolved != resolved {
+			t.Fatalf("unexpected alert: %+v", alert)
+		}
+		return alert
+	case <-time.After(time.Second):
+		t.Fatalf("no %s alert sent", event)
+	}
+	return Alert{}
+}
+
+func expectNoAlert(t *testing.T, sink *recordingSink) {
+	select {
+	case alert := <-sink.alerts:
+		t.Fatalf("unexpected alert: %+v", alert)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNotifierCooldown(t *testing.T) {
+	notifier, sink, tm := testNotifier(t, nil)
+	notifier.Notify(Alert{Event: ALERT_CANARY_FAILING, Message: "failing"})
+	expectAlert(t, sink, ALERT_CANARY_FAILING, false)
+	notifier.Notify(Alert{Event: ALERT_CANARY_FAILING, Message: "failing"})
+	expectNoAlert(t, sink)
+
+	tm.time = tm.time.Add(time.Hour)
+	notifier.Notify(Alert{Event: ALERT_CANARY_FAILING, Message: "failing"})
+	expectAlert(t, sink, ALERT_CANARY_FAILING, false)
+
+	// Resolution ends the cooldown, only alerted events are resolved
+	notifier.Notify(Alert{Event: ALERT_CANARY_FAILING, Message: "recovered", Resolved: true})
+	expectAlert(t, sink, ALERT_CANARY_FAILING, true)
+	notifier.Notify(Alert{Event: ALERT_CANARY_FAILING, Message: "recovered", Resolved: true})
+	expectNoAlert(t, sink)
+	notifier.Notify(Alert{Event: ALERT_CANARY_FAILING, Message: "failing"})
+	expectAlert(t, sink, ALERT_CANARY_FAILING, false)
+}
+
+func TestNotifierEvents(t *testing.T) {
+	notifier, sink, _ := testNotifier(t, []string{ALERT_SUBMISSIONS_STOPPED})
+	notifier.Notify(Alert{Event: ALERT_CANARY_FAILING, Message: "failing"})
+	expectNoAlert(t, sink)
+	notifier.Notify(Alert{Event: ALERT_SUBMISSIONS_STOPPED, Message: "stopped"})
+	expectAlert(t, sink, ALERT_SUBMISSIONS_STOPPED, false)
+
+	if _, err := NewNotifier(nil, []string{"disk_full"}, time.Hour, time.Now, logging.Logger("test")); err == nil {
+		t.Fatal("expected unknown event to be rejected")
+	}
+}
+
+func TestFailureStreak(t *testing.T) {
+	notifier, sink, _ := testNotifier(t, nil)
+	streak := NewFailureStreak(notifier, ALERT_WHITELIST_REFRESH_FAILING, "Whitelist refresh failing", 3)
+	streak.Record(errors.New("quota exceeded"))
+	streak.Record(errors.New("quota exceeded"))
+	streak.Record(nil)
+	expectNoAlert(t, sink)
+
+	for i := 0; i < 3; i++ {
+		streak.Record(errors.New("quota exceeded"))
+	}
+	alert := expectAlert(t, sink, ALERT_WHITELIST_REFRESH_FAILING, false)
+	if alert.Fields["error"] != "quota exceeded" || !strings.Contains(alert.Message, "3 times") {
+		t.Fatalf("unexpected alert: %+v", alert)
+	}
+	streak.Record(nil)
+	expectAlert(t, sink, ALERT_WHITELIST_REFRESH_FAILING, true)
+}
+
+func TestIdleWatch(t *testing.T) {
+	notifier, sink, tm := testNotifier(t, nil)
+	watch := NewIdleWatch(notifier, 30*time.Minute, tm.Now)
+	tm.time = tm.time.Add(20 * time.Minute)
+	watch.Accepted(tm.time)
+	tm.time = tm.time.Add(20 * time.Minute)
+	watch.Check()
+	expectNoAlert(t, sink)
+
+	tm.time = tm.time.Add(10 * time.Minute)
+	watch.Check()
+	expectAlert(t, sink, ALERT_SUBMISSIONS_STOPPED, false)
+	watch.Check()
+	expectNoAlert(t, sink)
+	watch.Accepted(tm.time)
+	expectAlert(t, sink, ALERT_SUBMISSIONS_STOPPED, true)
+}
+
+func TestSlackSink(t *testing.T) {
+	bodies := make(chan map[string]string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	notifier, err := NewNotifierFromConfig(&AlertingConfig{SlackWebhookURL: server.URL}, logging.Logger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notifier.Run(ctx)
+	notifier.Report(ErrorEvent{Message: "Storage failures", Err: errors.New("timeout"), Tags: map[string]string{"backend": "s3"}})
+
+	select {
+	case body := <-bodies:
+		if body["text"] != "[alert] Storage failures\nbackend: s3\nerror: timeout" {
+			t.Fatalf("unexpected Slack message: %q", body["text"])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message posted to Slack")
+	}
+
+	if _, err := NewNotifierFromConfig(&AlertingConfig{}, logging.Logger("test")); err == nil {
+		t.Fatal("expected configuration without sinks to be rejected")
+	}
+}
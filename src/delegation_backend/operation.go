@@ -1,6 +1,7 @@
 package delegation_backend
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
@@ -15,6 +16,12 @@ const (
 
 // ExponentialBackoff retries the provided operation with an exponential backoff strategy.
 func ExponentialBackoff(operation Operation, maxRetries int, initialBackoff time.Duration) error {
+	return ExponentialBackoffContext(context.Background(), operation, maxRetries, initialBackoff)
+}
+
+// ExponentialBackoffContext retries like ExponentialBackoff, until the
+// context is done, returning the error of the context then.
+func ExponentialBackoffContext(ctx context.Context, operation Operation, maxRetries int, initialBackoff time.Duration) error {
 	backoff := initialBackoff
 	var err error
 	for i := 0; i < maxRetries; i++ {
@@ -22,10 +29,17 @@ func ExponentialBackoff(operation Operation, maxRetries int, initialBackoff time
 		if err == nil {
 			return nil // Success
 		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("operation abandoned after %d tries: %w", i+1, ctxErr)
+		}
 
 		if i < maxRetries-1 {
 			// If not the last retry, wait for a bit
-			time.Sleep(backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return fmt.Errorf("operation abandoned after %d tries: %w", i+1, ctx.Err())
+			}
 			backoff *= 2 // Exponential increase
 		}
 	}
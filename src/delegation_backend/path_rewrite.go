@@ -0,0 +1,435 @@
+package delegation_backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Path of the manifest of a path rewrite, relative to the root of the storage
+const PATH_MANIFEST = "manifests/paths.json"
+
+// Schemes of hashes of blocks in their path
+const (
+	// Base58check-encoded blake2b, as by BlockDataHash
+	BLOCK_HASH_BLAKE2B = "blake2b"
+	// Hex-encoded SHA-256
+	BLOCK_HASH_SHA256 = "sha256"
+)
+
+var templateVariableRe = regexp.MustCompile(`\{[^{}]*\}`)
+
+// PathLayout of the objects of a storage. Templates of paths contain the
+// variables {network}, {day}, {time} (of submission, RFC 3339),
+// {submitter} and {block_hash}.
+type PathLayout struct {
+	Submissions string `json:"submissions"`
+	Blocks      string `json:"blocks"`
+	// Scheme of {block_hash}
+	BlockHash string `json:"block_hash"`
+}
+
+// DefaultPathLayout is the layout of submissions saved by the submit
+// handler, see MakePathsImpl
+func DefaultPathLayout() PathLayout {
+	return PathLayout{
+		Submissions: "submissions/{day}/{time}-{submitter}.json",
+		Blocks:      "blocks/{block_hash}.dat",
+		BlockHash:   BLOCK_HASH_BLAKE2B,
+	}
+}
+
+func (l PathLayout) String() string {
+	return fmt.Sprintf("%s,%s,%s", l.Submissions, l.Blocks, l.BlockHash)
+}
+
+// Validate the templates of the layout: paths of submissions are told
+// apart by time and submitter, paths of blocks by hash
+func (l PathLayout) Validate() error {
+	allowed := map[string]bool{"{network}": true, "{day}": true, "{time}": true, "{submitter}": true, "{block_hash}": true}
+	for _, template := range []string{l.Submissions, l.Blocks} {
+		for _, variable := range templateVariableRe.FindAllString(template, -1) {
+			if !allowed[variable] {
+				return fmt.Errorf("unknown variable %s in path template %q", variable, template)
+			}
+		}
+		if strings.HasPrefix(template, "/") || strings.Contains(template, "..") {
+			return fmt.Errorf("path template %q must be relative to the root of the storage", template)
+		}
+	}
+	if !strings.Contains(l.Submissions, "{time}") || !strings.Contains(l.Submissions, "{submitter}") {
+		return fmt.Errorf("path template of submissions %q must contain {time} and {submitter}", l.Submissions)
+	}
+	if !strings.Contains(l.Blocks, "{block_hash}") {
+		return fmt.Errorf("path template of blocks %q must contain {block_hash}", l.Blocks)
+	}
+	if l.BlockHash != BLOCK_HASH_BLAKE2B && l.BlockHash != BLOCK_HASH_SHA256 {
+		return fmt.Errorf("unknown block hash scheme %q, expected %s or %s", l.BlockHash, BLOCK_HASH_BLAKE2B, BLOCK_HASH_SHA256)
+	}
+	return nil
+}
+
+func (l PathLayout) submissionPath(network string, day string, submittedAt string, submitter string) string {
+	return strings.NewReplacer("{network}", network, "{day}", day, "{time}", submittedAt, "{submitter}", submitter).Replace(l.Submissions)
+}
+
+func (l PathLayout) blockPath(network string, blockHash string) string {
+	return strings.NewReplacer("{network}", network, "{block_hash}", blockHash).Replace(l.Blocks)
+}
+
+// Hash of the block in the scheme of the layout
+func (l PathLayout) hashBlock(block []byte) string {
+	if l.BlockHash == BLOCK_HASH_SHA256 {
+		sum := sha256.Sum256(block)
+		return hex.EncodeToString(sum[:])
+	}
+	return BlockDataHash(block)
+}
+
+// PathManifest of a path rewrite, saved to PATH_MANIFEST in the storage
+// rewritten so that readers of paths of the previous layout resolve them.
+type PathManifest struct {
+	Network string     `json:"network"`
+	From    PathLayout `json:"from"`
+	To      PathLayout `json:"to"`
+	// Hashes of blocks in the scheme of To by hash in the scheme of From,
+	// if the schemes differ
+	Blocks      map[string]string `json:"blocks,omitempty"`
+	StartedAt   time.Time         `json:"started_at"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+	// Objects of the previous layout were deleted, once rewritten, up to
+	// this submission
+	DeletedThrough string `json:"deleted_through,omitempty"`
+}
+
+// ReadPathManifest of the storage, the error of a storage never rewritten
+// wraps fs.ErrNotExist
+func ReadPathManifest(ctx context.Context, source StorageSource) (*PathManifest, error) {
+	bs, err := source.Read(ctx, PATH_MANIFEST)
+	if err != nil {
+		return nil, err
+	}
+	var manifest PathManifest
+	if err := json.Unmarshal(bs, &manifest); err != nil {
+		return nil, fmt.Errorf("error unmarshaling %s: %w", PATH_MANIFEST, err)
+	}
+	return &manifest, nil
+}
+
+// Resolve the path of an object of the previous layout to its path in the
+// new one, false if the object isn't of the previous layout or its block
+// wasn't rewritten yet
+func (m *PathManifest) Resolve(path string) (string, bool) {
+	if day, ok := submissionDay(path); ok {
+		submittedAt, submitter, ok := splitSubmissionPath(path)
+		if !ok {
+			return "", false
+		}
+		return m.To.submissionPath(m.Network, day, submittedAt, submitter), true
+	}
+	if !strings.HasPrefix(path, BLOCKS_PREFIX) || !strings.HasSuffix(path, ".dat") {
+		return "", false
+	}
+	hash := strings.TrimSuffix(strings.TrimPrefix(path, BLOCKS_PREFIX), ".dat")
+	if m.From.BlockHash != m.To.BlockHash {
+		var ok bool
+		if hash, ok = m.Blocks[hash]; !ok {
+			return "", false
+		}
+	}
+	return m.To.blockPath(m.Network, hash), true
+}
+
+// PathResolvingSource reads objects of the previous layout moved by a
+// path rewrite at their new path, once deleted from their previous one
+type PathResolvingSource struct {
+	Source interface {
+		StorageSource
+		BlockLister
+	}
+	Manifest *PathManifest
+}
+
+func (s PathResolvingSource) Days(ctx context.Context) ([]string, error) {
+	return s.Source.Days(ctx)
+}
+
+func (s PathResolvingSource) Submissions(ctx context.Context, day string) ([]string, error) {
+	return s.Source.Submissions(ctx, day)
+}
+
+func (s PathResolvingSource) Blocks(ctx context.Context) ([]StoredBlock, error) {
+	return s.Source.Blocks(ctx)
+}
+
+func (s PathResolvingSource) Read(ctx context.Context, path string) ([]byte, error) {
+	bs, err := s.Source.Read(ctx, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if resolved, ok := s.Manifest.Resolve(path); ok {
+			return s.Source.Read(ctx, resolved)
+		}
+	}
+	return bs, err
+}
+
+// PathRewrite copies submissions and their blocks of an object storage,
+// saved in the layout of the submit handler, to paths of another layout of
+// the same storage, re-hashing blocks (and updating the block hash of
+// submissions) if the hash scheme differs. The manifest of the rewrite is
+// saved along with the progress, which is saved and resumed as by a
+// StorageMigration.
+//
+// The backend keeps saving submissions in its layout: objects of the new
+// layout are left alone by its retention, index and collection of orphaned
+// blocks, and objects of the previous layout are deleted only with a
+// Deleter, once all submissions listed were rewritten.
+type PathRewrite struct {
+	Network string
+	To      PathLayout
+	Source  StorageSource
+	Save    func(ObjectsToSave) error
+	// Saves the manifest, replacing the previous one
+	Overwrite func(ObjectsToSave) error
+	// Optional, deletes objects of the previous layout once rewritten
+	Deleter ObjectDeleter
+	// Optional, file of the progress
+	ProgressFile string
+	Log          logging.StandardLogger
+}
+
+// Run the rewrite until all submissions are rewritten or ctx is done,
+// returning its progress. Submissions failing to be rewritten are logged
+// and listed in the progress, objects of the previous layout are kept if
+// any failed.
+func (r *PathRewrite) Run(ctx context.Context) (*MigrationProgress, error) {
+	from := DefaultPathLayout()
+	if err := r.To.Validate(); err != nil {
+		return nil, err
+	}
+	if r.To == from {
+		return nil, errors.New("the layout to rewrite paths to is the layout of the backend")
+	}
+	sample := ObjectsToSave{
+		r.To.submissionPath(r.Network, "2024-01-01", "2024-01-01T00:00:00Z", "B62q"): nil,
+		r.To.blockPath(r.Network, "3N"):                                              nil,
+	}
+	for path := range sample {
+		if strings.HasPrefix(path, SUBMISSIONS_PREFIX) || strings.HasPrefix(path, BLOCKS_PREFIX) {
+			return nil, fmt.Errorf("paths of the new layout, e.g. %s, must not be under %s or %s where the backend saves", path, SUBMISSIONS_PREFIX, BLOCKS_PREFIX)
+		}
+	}
+	progress, err := loadMigrationProgress(r.ProgressFile, from.String(), r.To.String())
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := ReadPathManifest(ctx, r.Source)
+	if errors.Is(err, fs.ErrNotExist) {
+		manifest = &PathManifest{Network: r.Network, From: from, To: r.To, Blocks: make(map[string]string), StartedAt: time.Now()}
+	} else if err != nil {
+		return nil, err
+	} else if manifest.To != r.To || manifest.Network != r.Network {
+		return nil, fmt.Errorf("paths were rewritten to another layout %s, started at %s", manifest.To, manifest.StartedAt.Format(time.RFC3339))
+	}
+	if manifest.Blocks == nil {
+		manifest.Blocks = make(map[string]string)
+	}
+	if progress.Last != "" {
+		r.Log.Infof("Resuming path rewrite after %s, %d submissions rewritten so far", progress.Last, progress.Submissions)
+	}
+	// Readers resolve objects of the new layout as soon as they're saved
+	if err := r.save(manifest, progress); err != nil {
+		return progress, err
+	}
+
+	days, err := r.Source.Days(ctx)
+	if err != nil {
+		return progress, fmt.Errorf("error listing days of submissions: %w", err)
+	}
+	resumeDay, _ := submissionDay(progress.Last)
+	copiedBlocks := make(map[string]bool)
+	unsaved := 0
+	for _, day := range days {
+		if day < resumeDay {
+			continue
+		}
+		paths, err := r.Source.Submissions(ctx, day)
+		if err != nil {
+			r.save(manifest, progress)
+			return progress, fmt.Errorf("error listing submissions of %s: %w", day, err)
+		}
+		for _, metaPath := range paths {
+			if metaPath <= progress.Last {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				if saveErr := r.save(manifest, progress); saveErr != nil {
+					r.Log.Errorf("Failed to save progress: %v", saveErr)
+				}
+				return progress, err
+			}
+			if err := r.rewrite(ctx, metaPath, manifest, progress, copiedBlocks); err != nil {
+				r.Log.Errorf("Failed to rewrite submission %s: %v", metaPath, err)
+				progress.Failed = append(progress.Failed, metaPath)
+			}
+			progress.Last = metaPath
+			if unsaved++; unsaved >= MIGRATION_PROGRESS_INTERVAL {
+				if err := r.save(manifest, progress); err != nil {
+					return progress, fmt.Errorf("error saving progress: %w", err)
+				}
+				unsaved = 0
+			}
+		}
+	}
+	if len(progress.Failed) > 0 {
+		return progress, r.save(manifest, progress)
+	}
+	completedAt := time.Now()
+	manifest.CompletedAt = &completedAt
+	if err := r.save(manifest, progress); err != nil {
+		return progress, err
+	}
+	if r.Deleter == nil || manifest.DeletedThrough == progress.Last {
+		return progress, nil
+	}
+	if err := r.deleteRewritten(ctx, days, progress.Last); err != nil {
+		return progress, fmt.Errorf("error deleting objects of the previous layout: %w", err)
+	}
+	manifest.DeletedThrough = progress.Last
+	return progress, r.save(manifest, progress)
+}
+
+// Save the manifest, then the progress, so that blocks re-hashed are in
+// the manifest of a rewrite resumed
+func (r *PathRewrite) save(manifest *PathManifest, progress *MigrationProgress) error {
+	bs, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := r.Overwrite(ObjectsToSave{PATH_MANIFEST: bs}); err != nil {
+		return fmt.Errorf("error saving %s: %w", PATH_MANIFEST, err)
+	}
+	return saveMigrationProgress(r.ProgressFile, progress)
+}
+
+// Copy the submission and its block to their paths of the new layout
+func (r *PathRewrite) rewrite(ctx context.Context, metaPath string, manifest *PathManifest, progress *MigrationProgress, copiedBlocks map[string]bool) error {
+	day, _ := submissionDay(metaPath)
+	submittedAt, submitter, ok := splitSubmissionPath(metaPath)
+	if !ok {
+		return errors.New("path doesn't match the layout of the backend")
+	}
+	objs, missingBlock, err := readStoredSubmission(ctx, r.Source, metaPath)
+	if err != nil {
+		return err
+	}
+	meta := objs[metaPath]
+	rewritten := make(ObjectsToSave, len(objs))
+	if missingBlock {
+		r.Log.Warnf("Block of submission %s not found, rewriting the submission only", metaPath)
+		progress.MissingBlocks++
+	}
+	for path, block := range objs {
+		if path == metaPath {
+			continue
+		}
+		hash := strings.TrimSuffix(strings.TrimPrefix(path, BLOCKS_PREFIX), ".dat")
+		if manifest.From.BlockHash != r.To.BlockHash {
+			rehashed := r.To.hashBlock(block)
+			manifest.Blocks[hash] = rehashed
+			if meta, err = replaceBlockHash(meta, rehashed); err != nil {
+				return err
+			}
+			hash = rehashed
+		}
+		blockPath := r.To.blockPath(r.Network, hash)
+		if !copiedBlocks[blockPath] {
+			rewritten[blockPath] = block
+		}
+	}
+	newMetaPath := r.To.submissionPath(r.Network, day, submittedAt, submitter)
+	rewritten[newMetaPath] = meta
+	if err := r.Save(rewritten); err != nil {
+		return err
+	}
+	for path, bs := range rewritten {
+		if path != newMetaPath {
+			copiedBlocks[path] = true
+		}
+		progress.Objects++
+		progress.Bytes += int64(len(bs))
+	}
+	progress.Submissions++
+	return nil
+}
+
+// Replace the block hash of the metadata of a submission, other fields are
+// kept as they are
+func replaceBlockHash(meta []byte, blockHash string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(meta, &fields); err != nil {
+		return nil, fmt.Errorf("error unmarshaling submission JSON: %w", err)
+	}
+	hash, err := json.Marshal(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	fields["block_hash"] = hash
+	return json.Marshal(fields)
+}
+
+// Delete submissions rewritten, up to last, and their blocks, except blocks
+// of submissions saved since by the backend
+func (r *PathRewrite) deleteRewritten(ctx context.Context, days []string, last string) error {
+	var paths []string
+	for _, day := range days {
+		submissions, err := r.Source.Submissions(ctx, day)
+		if err != nil {
+			return fmt.Errorf("error listing submissions of %s: %w", day, err)
+		}
+		paths = append(paths, submissions...)
+	}
+	var deleted []string
+	deletedBlocks := make(map[string]bool)
+	keptBlocks := make(map[string]bool)
+	err := readConcurrently(ctx, r.Source, paths, func(metaPath string, bs []byte, err error) error {
+		if metaPath > last {
+			hash, err := submissionBlockHash(metaPath, bs, err)
+			keptBlocks[hash] = true
+			return err
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading submission %s: %w", metaPath, err)
+		}
+		deleted = append(deleted, metaPath)
+		// Submissions without block were rewritten as well
+		var meta struct {
+			BlockHash string `json:"block_hash"`
+		}
+		if json.Unmarshal(bs, &meta) == nil && meta.BlockHash != "" {
+			deletedBlocks[meta.BlockHash] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for hash := range deletedBlocks {
+		if !keptBlocks[hash] {
+			deleted = append(deleted, BLOCKS_PREFIX+hash+".dat")
+		}
+	}
+	r.Log.Infof("Deleting %d objects of the previous layout", len(deleted))
+	return r.Deleter.DeleteObjects(ctx, deleted)
+}
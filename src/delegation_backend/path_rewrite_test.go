@@ -0,0 +1,84 @@
+package delegation_backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestPathRewrite(t *testing.T) {
+	dir := t.TempDir()
+	log := logging.Logger("test")
+	if err := LocalFileSystemSave(ObjectsToSave{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NKa","remote_addr":"1.2.3.4"}`),
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qb.json": []byte(`{"block_hash":"3NKa"}`),
+		"blocks/3NKa.dat": []byte("block"),
+	}, dir, log); err != nil {
+		t.Fatal(err)
+	}
+	source := LocalFileSystemSource{Directory: dir}
+	rewrite := &PathRewrite{
+		Network: "mainnet",
+		To:      PathLayout{Submissions: "{network}/submissions/{day}/{time}-{submitter}.json", Blocks: "{network}/blocks/{block_hash}.dat", BlockHash: BLOCK_HASH_SHA256},
+		Source:  source,
+		Save:    func(objs ObjectsToSave) error { return LocalFileSystemSave(objs, dir, log) },
+		Overwrite: func(objs ObjectsToSave) error {
+			return LocalFileSystemOverwrite(objs, dir)
+		},
+		Deleter:      LocalFileSystemCleaner{Directory: dir},
+		ProgressFile: filepath.Join(dir, "progress.json"),
+		Log:          log,
+	}
+	progress, err := rewrite.Run(context.Background())
+	if err != nil || progress.Submissions != 2 || progress.Objects != 3 || len(progress.Failed) != 0 {
+		t.Fatalf("unexpected progress %+v, error: %v", progress, err)
+	}
+
+	sum := sha256.Sum256([]byte("block"))
+	hash := hex.EncodeToString(sum[:])
+	if bs, err := source.Read(context.Background(), "mainnet/blocks/"+hash+".dat"); err != nil || string(bs) != "block" {
+		t.Fatalf("expected the block rewritten, error: %v", err)
+	}
+	bs, err := source.Read(context.Background(), "mainnet/submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta map[string]string
+	if err := json.Unmarshal(bs, &meta); err != nil || meta["block_hash"] != hash || meta["remote_addr"] != "1.2.3.4" {
+		t.Fatalf("unexpected submission rewritten %s", bs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blocks", "3NKa.dat")); !os.IsNotExist(err) {
+		t.Fatal("expected objects of the previous layout deleted")
+	}
+
+	// Paths of the previous layout resolve through the manifest
+	manifest, err := ReadPathManifest(context.Background(), source)
+	if err != nil || manifest.CompletedAt == nil || manifest.DeletedThrough != "submissions/2024-05-02/2024-05-02T10:00:00Z-B62qb.json" {
+		t.Fatalf("unexpected manifest %+v, error: %v", manifest, err)
+	}
+	resolving := PathResolvingSource{Source: source, Manifest: manifest}
+	for _, path := range []string{"blocks/3NKa.dat", "submissions/2024-05-02/2024-05-02T10:00:00Z-B62qb.json"} {
+		if _, err := resolving.Read(context.Background(), path); err != nil {
+			t.Errorf("expected %s resolved: %v", path, err)
+		}
+	}
+	if _, err := resolving.Read(context.Background(), "blocks/3NKz.dat"); !os.IsNotExist(err) {
+		t.Errorf("expected a block never rewritten not found, got %v", err)
+	}
+
+	// Rewrites are resumed with the layout they started with only
+	rewrite.To.BlockHash = BLOCK_HASH_BLAKE2B
+	if _, err := rewrite.Run(context.Background()); err == nil {
+		t.Fatal("expected an error rewriting to another layout")
+	}
+	rewrite.To = PathLayout{Submissions: "submissions/{network}/{time}-{submitter}.json", Blocks: "{network}/{block_hash}", BlockHash: BLOCK_HASH_BLAKE2B}
+	if _, err := rewrite.Run(context.Background()); err == nil {
+		t.Fatal("expected an error rewriting to paths of the backend")
+	}
+}
@@ -1,31 +1,152 @@
 package delegation_backend
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	logging "github.com/ipfs/go-log/v2"
 	_ "github.com/lib/pq"
 )
 
+// Defaults of the connection pool
+const (
+	POSTGRES_DEFAULT_MAX_OPEN_CONNS    = 20
+	POSTGRES_DEFAULT_MAX_IDLE_CONNS    = 10
+	POSTGRES_DEFAULT_CONN_MAX_LIFETIME = 30 * time.Minute
+)
+
+const postgreSQLInsertSubmission = `INSERT INTO submissions
+				(submitted_at_date,
+				 submitted_at,
+				 submitter,
+				 created_at,
+				 block_hash,
+				 remote_addr,
+				 peer_id,
+				 graphql_control_port,
+				 built_with_commit_sha,
+				 global_slot,
+				 epoch,
+				 peer_count,
+				 sync_status,
+				 snark_pool_size,
+				 daemon_version)
+			   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+
+const postgreSQLInsertSubmissionWithSnarkWork = `INSERT INTO submissions
+				(submitted_at_date,
+				submitted_at,
+				submitter,
+				created_at,
+				block_hash,
+				remote_addr,
+				peer_id,
+				graphql_control_port,
+				built_with_commit_sha,
+				global_slot,
+				epoch,
+				peer_count,
+				sync_status,
+				snark_pool_size,
+				daemon_version,
+				snark_work)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`
+
 type PostgreSQLContext struct {
 	DB  *sql.DB
 	Log *logging.ZapEventLogger
+	// Optional, batches inserts of submissions saved concurrently
+	Batcher *WriteBatcher
+	// Optional, bounds inserts of submissions, e.g. by the deadline of a
+	// request
+	Context context.Context
+	// Prepared inserts, set by Prepare
+	insertStmt              *sql.Stmt
+	insertWithSnarkWorkStmt *sql.Stmt
+}
+
+// Prepare the inserts of submissions, so that they aren't parsed on
+// every write. The submissions table must exist.
+func (ctx *PostgreSQLContext) Prepare() error {
+	var err error
+	if ctx.insertStmt, err = ctx.DB.Prepare(postgreSQLInsertSubmission); err != nil {
+		return err
+	}
+	ctx.insertWithSnarkWorkStmt, err = ctx.DB.Prepare(postgreSQLInsertSubmissionWithSnarkWork)
+	return err
+}
+
+// Run the prepared statement, or the query if not prepared
+func (ctx *PostgreSQLContext) exec(stmt *sql.Stmt, query string, args ...interface{}) error {
+	c := ctx.Context
+	if c == nil {
+		c = context.Background()
+	}
+	var err error
+	if stmt != nil {
+		_, err = stmt.ExecContext(c, args...)
+	} else {
+		_, err = ctx.DB.ExecContext(c, query, args...)
+	}
+	return err
+}
+
+// WithContext is a copy of the context whose inserts are bound to c
+func (ctx *PostgreSQLContext) WithContext(c context.Context) *PostgreSQLContext {
+	copied := *ctx
+	copied.Context = c
+	return &copied
+}
+
+// Size the connection pool, connections are recycled so that
+// rotated passwords and DNS changes are picked up
+func configurePostgreSQLPool(db *sql.DB, cfg *PostgreSQLConfig) {
+	maxOpen, maxIdle, lifetime := POSTGRES_DEFAULT_MAX_OPEN_CONNS, POSTGRES_DEFAULT_MAX_IDLE_CONNS, POSTGRES_DEFAULT_CONN_MAX_LIFETIME
+	if cfg.MaxOpenConns > 0 {
+		maxOpen = cfg.MaxOpenConns
+	}
+	if cfg.MaxIdleConns > 0 {
+		maxIdle = cfg.MaxIdleConns
+	}
+	if cfg.ConnMaxLifetimeMinutes > 0 {
+		lifetime = time.Duration(cfg.ConnMaxLifetimeMinutes) * time.Minute
+	}
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(min(maxIdle, maxOpen))
+	db.SetConnMaxLifetime(lifetime)
+}
+
+func postgreSQLConnStr(cfg *PostgreSQLConfig, password string) string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		cfg.Host, cfg.Port, cfg.User, password, cfg.DBName, cfg.SSLMode)
 }
 
 func NewPostgreSQL(cfg *PostgreSQLConfig) (*sql.DB, error) {
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
-	db, err := sql.Open("postgres", connStr)
+	db, err := sql.Open("postgres", postgreSQLConnStr(cfg, cfg.Password))
 	if err != nil {
 		return nil, err
 	}
+	configurePostgreSQLPool(db, cfg)
 	if err = db.Ping(); err != nil {
 		return nil, err
 	}
 	return db, nil
 }
 
+// NewPostgreSQLWithSecret connects using the password secret, new
+// connections pick up the password after it is rotated.
+func NewPostgreSQLWithSecret(cfg *PostgreSQLConfig, password *Secret) (*sql.DB, error) {
+	db := sql.OpenDB(&postgreSQLConnector{cfg: cfg, password: password})
+	configurePostgreSQLPool(db, cfg)
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
 func (ctx *PostgreSQLContext) insertSubmission(submission *Submission) error {
 	// if SnarkWork is empty, do not insert it into the database
 	if len(submission.SnarkWork) == 0 {
@@ -35,60 +156,81 @@ func (ctx *PostgreSQLContext) insertSubmission(submission *Submission) error {
 }
 
 func (ctx *PostgreSQLContext) insertSubmissionWithoutSnarkWork(submission *Submission) error {
-	query := `INSERT INTO submissions 
-				(submitted_at_date, 
-				 submitted_at, 
-				 submitter, 
-				 created_at, 
-				 block_hash, 
-				 remote_addr, 
-				 peer_id, 
-				 graphql_control_port,
-				 built_with_commit_sha)
-			   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
-	_, err := ctx.DB.Exec(query, submission.SubmittedAtDate, submission.SubmittedAt,
-		submission.Submitter, submission.CreatedAt, submission.BlockHash,
-		submission.RemoteAddr, submission.PeerId, submission.GraphqlControlPort,
-		submission.BuiltWithCommitSha)
-	return err
+	return ctx.exec(ctx.insertStmt, postgreSQLInsertSubmission, postgreSQLSubmissionValues(submission)...)
 }
 
 func (ctx *PostgreSQLContext) insertSubmissionWithSnarkWork(submission *Submission) error {
-	query := `INSERT INTO submissions 
-				(submitted_at_date, 
-				submitted_at, 
-				submitter, 
-				created_at, 
-				block_hash, 
-				remote_addr, 
-				peer_id, 
-				graphql_control_port,
-				built_with_commit_sha,
-				snark_work) 
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
-	_, err := ctx.DB.Exec(query, submission.SubmittedAtDate, submission.SubmittedAt,
+	return ctx.exec(ctx.insertWithSnarkWorkStmt, postgreSQLInsertSubmissionWithSnarkWork,
+		append(postgreSQLSubmissionValues(submission), submission.SnarkWork)...)
+}
+
+// Values of the columns of the inserts of the submission, but snark_work
+func postgreSQLSubmissionValues(submission *Submission) []interface{} {
+	return append([]interface{}{submission.SubmittedAtDate, submission.SubmittedAt,
 		submission.Submitter, submission.CreatedAt, submission.BlockHash,
 		submission.RemoteAddr, submission.PeerId, submission.GraphqlControlPort,
-		submission.BuiltWithCommitSha, submission.SnarkWork)
-	return err
+		submission.BuiltWithCommitSha, submission.GlobalSlot, submission.Epoch},
+		submission.telemetryValues()...)
 }
 
-func (ctx *PostgreSQLContext) PostgreSQLSave(objs ObjectsToSave) {
+func (ctx *PostgreSQLContext) PostgreSQLSave(objs ObjectsToSave) error {
 	submissionToSave, err := objectToSaveToSubmission(objs, ctx.Log)
 	if err != nil {
 		ctx.Log.Errorf("PostgreSQLSave: Error preparing submission for saving: %v", err)
-		return
+		return err
 	}
 
-	if err := ctx.insertSubmission(submissionToSave); err != nil {
+	if ctx.Batcher != nil {
+		err = ctx.Batcher.Save(submissionToSave)
+	} else {
+		err = ctx.insertSubmission(submissionToSave)
+	}
+	if err != nil {
 		// if err contains uq_submissions_submitter_date then we can ignore it
 		// because it means that the submission is already in the database
 		if err.Error() == "pq: duplicate key value violates unique constraint \"uq_submissions_submitter_date\"" {
 			ctx.Log.Infof("PostgreSQLSave: Submission for submitter: %v at %v already exists", submissionToSave.Submitter, submissionToSave.SubmittedAt)
-			return
+			return nil
 		}
 		ctx.Log.Errorf("PostgreSQLSave: Error saving submission to PostgreSQL: %v", err)
-	} else {
-		ctx.Log.Infof("PostgreSQLSave: Successfully saved submission for submitter: %v at %v", submissionToSave.Submitter, submissionToSave.SubmittedAt)
+		return err
 	}
+	ctx.Log.Infof("PostgreSQLSave: Successfully saved submission for submitter: %v at %v", submissionToSave.Submitter, submissionToSave.SubmittedAt)
+	return nil
+}
+
+// InsertSubmissions inserts the submissions in a single statement,
+// submissions already in the database are skipped
+func (ctx *PostgreSQLContext) InsertSubmissions(submissions []*Submission) error {
+	if len(submissions) == 1 {
+		return ctx.insertSubmission(submissions[0])
+	}
+	var query strings.Builder
+	query.WriteString(`INSERT INTO submissions (submitted_at_date, submitted_at, submitter, created_at, block_hash,
+				remote_addr, peer_id, graphql_control_port, built_with_commit_sha, global_slot, epoch,
+				peer_count, sync_status, snark_pool_size, daemon_version, snark_work) VALUES `)
+	var args []interface{}
+	for i, submission := range submissions {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := len(args)
+		// Without snark work the column is left empty, as by insertSubmission
+		var snarkWork []byte
+		if len(submission.SnarkWork) > 0 {
+			snarkWork = submission.SnarkWork
+		}
+		args = append(append(args, postgreSQLSubmissionValues(submission)...), snarkWork)
+		query.WriteString("(")
+		for j := n + 1; j <= len(args); j++ {
+			if j > n+1 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", j)
+		}
+		query.WriteString(")")
+	}
+	query.WriteString(" ON CONFLICT DO NOTHING")
+	_, err := ctx.DB.Exec(query.String(), args...)
+	return err
 }
@@ -0,0 +1,89 @@
+package delegation_backend
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// Schema migrations of PostgreSQL, named `<version>_<name>.up.sql` and
+// `<version>_<name>.down.sql`. Migrations are embedded in the binary, the
+// version applied is tracked in the `schema_migrations` table.
+//
+//go:embed postgres_migrations/*.sql
+var postgreSQLMigrations embed.FS
+
+// Migrations of the embedded schema, the migrate instance is bound to a
+// single connection of the pool, closed along with the instance
+func newPostgreSQLMigrate(db *sql.DB) (*migrate.Migrate, error) {
+	source, err := iofs.New(postgreSQLMigrations, "postgres_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	// Unlike WithInstance, the pool is left open once the migrations are done
+	driver, err := postgres.WithConnection(context.Background(), conn, &postgres.Config{})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not create PostgreSQL migration driver: %w", err)
+	}
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return nil, fmt.Errorf("migration failed: %w", err)
+	}
+	return m, nil
+}
+
+// PostgreSQLMigrationUp applies the migrations not yet applied, replicas
+// starting together wait for each other through an advisory lock. The
+// version of the schema is returned.
+func PostgreSQLMigrationUp(db *sql.DB) (uint, error) {
+	m, err := newPostgreSQLMigrate(db)
+	if err != nil {
+		return 0, err
+	}
+	defer m.Close()
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return 0, fmt.Errorf("an error occurred while applying migrations: %w", err)
+	}
+	version, _, err := m.Version()
+	return version, err
+}
+
+// PostgreSQLMigrationDown rolls back all migrations.
+func PostgreSQLMigrationDown(db *sql.DB) error {
+	m, err := newPostgreSQLMigrate(db)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err := m.Down(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("an error occurred while rolling back migrations: %w", err)
+	}
+	return nil
+}
+
+// PostgreSQLMigrationVersion returns the version of the schema, zero if no
+// migration was applied, and whether a migration failed halfway through
+func PostgreSQLMigrationVersion(db *sql.DB) (uint, bool, error) {
+	m, err := newPostgreSQLMigrate(db)
+	if err != nil {
+		return 0, false, err
+	}
+	defer m.Close()
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS submissions (
+    id SERIAL PRIMARY KEY,
+    -- filled by uptime_service_backend
+    submitted_at_date DATE NOT NULL,
+    submitted_at TIMESTAMP NOT NULL,
+    submitter TEXT NOT NULL,
+    created_at TIMESTAMP,
+    block_hash TEXT,
+    remote_addr TEXT,
+    peer_id TEXT,
+    snark_work BYTEA,
+    graphql_control_port INT,
+    built_with_commit_sha TEXT,
+    -- filled by zk-validator component
+    state_hash TEXT,
+    parent TEXT,
+    height INTEGER,
+    slot INTEGER,
+    validation_error TEXT,
+    -- was it verified by zk-validator
+    verified BOOLEAN,
+    CONSTRAINT uq_submissions_submitter_date UNIQUE (submitter, submitted_at)
+);
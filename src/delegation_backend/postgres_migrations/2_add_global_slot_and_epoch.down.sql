@@ -0,0 +1,2 @@
+ALTER TABLE submissions DROP COLUMN IF EXISTS global_slot;
+ALTER TABLE submissions DROP COLUMN IF EXISTS epoch;
@@ -0,0 +1,3 @@
+-- filled by uptime_service_backend when the timing of the network is configured
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS global_slot INTEGER;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS epoch INTEGER;
@@ -0,0 +1,4 @@
+ALTER TABLE submissions DROP COLUMN IF EXISTS peer_count;
+ALTER TABLE submissions DROP COLUMN IF EXISTS sync_status;
+ALTER TABLE submissions DROP COLUMN IF EXISTS snark_pool_size;
+ALTER TABLE submissions DROP COLUMN IF EXISTS daemon_version;
@@ -0,0 +1,5 @@
+-- telemetry reported by clients of /v2/submit
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS peer_count INTEGER;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS sync_status TEXT;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS snark_pool_size INTEGER;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS daemon_version TEXT;
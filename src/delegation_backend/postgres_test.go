@@ -0,0 +1,59 @@
+package delegation_backend
+
+import (
+	"database/sql"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+func TestPostgreSQLMigrations(t *testing.T) {
+	source, err := iofs.New(postgreSQLMigrations, "postgres_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	version, err := source.First()
+	if err != nil || version != 1 {
+		t.Fatalf("expected the first migration to be version 1, got %d (error: %v)", version, err)
+	}
+	// Every migration can be rolled back
+	for {
+		for _, read := range []func(uint) (io.ReadCloser, string, error){source.ReadUp, source.ReadDown} {
+			r, _, err := read(version)
+			if err != nil {
+				t.Fatalf("migration %d: %v", version, err)
+			}
+			r.Close()
+		}
+		if version, err = source.Next(version); err != nil {
+			break
+		}
+	}
+
+	r, _, _ := source.ReadUp(1)
+	defer r.Close()
+	bs, _ := io.ReadAll(r)
+	// PostgreSQLSave relies on the constraint to skip duplicate submissions
+	if !strings.Contains(string(bs), "uq_submissions_submitter_date") {
+		t.Fatal("expected the unique constraint of submissions")
+	}
+}
+
+func TestConfigurePostgreSQLPool(t *testing.T) {
+	db, err := sql.Open("postgres", "host=localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	configurePostgreSQLPool(db, &PostgreSQLConfig{})
+	if max := db.Stats().MaxOpenConnections; max != POSTGRES_DEFAULT_MAX_OPEN_CONNS {
+		t.Fatalf("expected the default pool size, got %d", max)
+	}
+	configurePostgreSQLPool(db, &PostgreSQLConfig{MaxOpenConns: 5, MaxIdleConns: 10})
+	if max := db.Stats().MaxOpenConnections; max != 5 {
+		t.Fatalf("expected the configured pool size, got %d", max)
+	}
+}
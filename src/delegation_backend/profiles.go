@@ -0,0 +1,83 @@
+package delegation_backend
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Profiles presetting the configuration, selected by PROFILE
+const (
+	PROFILE_DEV     = "dev"
+	PROFILE_TESTNET = "testnet"
+	PROFILE_MAINNET = "mainnet"
+)
+
+var PROFILES = []string{PROFILE_DEV, PROFILE_TESTNET, PROFILE_MAINNET}
+
+// Directory of the local filesystem storage of the dev profile
+const PROFILE_DEV_FILESYSTEM_PATH = "data"
+
+// Rate limit of the dev profile, high enough to never get in the way
+const PROFILE_DEV_REQUESTS_PER_PK_HOURLY = 3600
+
+// ProfilePreset returns the configuration the profile starts from, settings
+// of the config file and environment variables are applied over it:
+//
+//	dev      local instance: local filesystem storage in ./data, whitelist and
+//	         signature verification disabled, relaxed rate limits, debug logs
+//	testnet  whitelist disabled, signature verification and replay protection
+//	mainnet  network mainnet, replay protection, signature verification
+//	         can't be disabled
+func ProfilePreset(profile string) (AppConfig, error) {
+	switch profile {
+	case PROFILE_DEV:
+		return AppConfig{
+			NetworkName:                 "dev",
+			DelegationWhitelistDisabled: true,
+			VerifySignatureDisabled:     true,
+			LocalFileSystem:             &LocalFileSystemConfig{Path: PROFILE_DEV_FILESYSTEM_PATH},
+			RateLimit:                   &RateLimitConfig{RequestsPerPkHourly: PROFILE_DEV_REQUESTS_PER_PK_HOURLY},
+			Logging:                     &LoggingConfig{Level: "debug", Format: "console"},
+		}, nil
+	case PROFILE_TESTNET:
+		return AppConfig{
+			DelegationWhitelistDisabled: true,
+			ReplayProtection:            &ReplayProtectionConfig{},
+		}, nil
+	case PROFILE_MAINNET:
+		return AppConfig{
+			NetworkName:      "mainnet",
+			ReplayProtection: &ReplayProtectionConfig{},
+		}, nil
+	}
+	return AppConfig{}, fmt.Errorf("unknown profile %q, expected one of %s", profile, strings.Join(PROFILES, ", "))
+}
+
+// Problems of the configuration with the strict settings of its profile
+func (config *AppConfig) profileProblems() []string {
+	var problems []string
+	// Replay protection kept in memory only forgets submissions accepted
+	// before a restart, which can then be replayed
+	if replay := config.ReplayProtection; replay != nil && replay.StateFile == "" && (config.Profile == PROFILE_TESTNET || config.Profile == PROFILE_MAINNET) {
+		problems = append(problems, fmt.Sprintf("replay_protection.state_file is required, set it in the config file or with REPLAY_PROTECTION_STATE_FILE: the %s profile keeps replay protection across restarts", config.Profile))
+	}
+	switch config.Profile {
+	case "", PROFILE_DEV, PROFILE_TESTNET:
+	case PROFILE_MAINNET:
+		if config.NetworkName != "mainnet" {
+			problems = append(problems, fmt.Sprintf("network_name (CONFIG_NETWORK_NAME) is invalid: the mainnet profile requires mainnet, got %q", config.NetworkName))
+		}
+		if networkId := config.Signature.NetworkId(config.NetworkName); networkId != NETWORK_ID_MAINNET {
+			problems = append(problems, fmt.Sprintf("signature (SIGNATURE_NETWORK_ID) is invalid: the mainnet profile requires network id %d, got %d", NETWORK_ID_MAINNET, networkId))
+		}
+		if config.VerifySignatureDisabled {
+			problems = append(problems, "verify_signature_disabled (VERIFY_SIGNATURE_DISABLED) is invalid: signature verification can't be disabled with the mainnet profile")
+		}
+		if config.MemoryStorage {
+			problems = append(problems, "memory_storage (MEMORY_STORAGE) is invalid: submissions can't be kept in memory only with the mainnet profile")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("profile (PROFILE) is invalid: expected one of %s, got %q", strings.Join(PROFILES, ", "), config.Profile))
+	}
+	return problems
+}
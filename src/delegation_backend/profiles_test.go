@@ -0,0 +1,68 @@
+package delegation_backend
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestProfiles(t *testing.T) {
+	log := logging.Logger("test")
+	os.Clearenv()
+
+	// A single variable is enough for a local instance
+	os.Setenv("PROFILE", "dev")
+	config, err := loadConfig("", log)
+	if err != nil {
+		t.Fatalf("expected the dev profile to be valid, got %v", err)
+	}
+	if config.LocalFileSystem == nil || config.LocalFileSystem.Path != PROFILE_DEV_FILESYSTEM_PATH || !config.DelegationWhitelistDisabled {
+		t.Fatalf("unexpected dev configuration: %+v", config)
+	}
+	if config.RequestsPerPkHourly() != PROFILE_DEV_REQUESTS_PER_PK_HOURLY {
+		t.Fatalf("expected relaxed rate limits, got %d", config.RequestsPerPkHourly())
+	}
+
+	// Settings of the file and variables take precedence over the profile
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"profile": "dev", "filesystem": {"path": "/tmp/uptime"}}`), 0644)
+	os.Setenv("PROFILE", "")
+	os.Setenv("VERIFY_SIGNATURE_DISABLED", "0")
+	config, err = loadConfig(path, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Profile != PROFILE_DEV || config.LocalFileSystem.Path != "/tmp/uptime" || config.VerifySignatureDisabled {
+		t.Fatalf("unexpected configuration: %+v", config)
+	}
+	os.Unsetenv("VERIFY_SIGNATURE_DISABLED")
+
+	// Mainnet doesn't allow disabling signature verification
+	os.WriteFile(path, []byte(`{"profile": "mainnet", "network_name": "devnet", "verify_signature_disabled": true, "delegation_whitelist_disabled": true, "filesystem": {"path": "/tmp/uptime"}}`), 0644)
+	_, err = loadConfig(path, log)
+	for _, problem := range []string{"network_name", "verify_signature_disabled"} {
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected a problem with %s, got %v", problem, err)
+		}
+	}
+
+	// Replay protection of the presets is persisted
+	os.WriteFile(path, []byte(`{"profile": "testnet", "network_name": "devnet", "filesystem": {"path": "/tmp/uptime"}}`), 0644)
+	if _, err := loadConfig(path, log); err == nil || !strings.Contains(err.Error(), "replay_protection.state_file") {
+		t.Errorf("expected the replay protection state file required, got %v", err)
+	}
+	os.Setenv("REPLAY_PROTECTION_STATE_FILE", "/tmp/uptime/replay.json")
+	if _, err := loadConfig(path, log); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	os.Unsetenv("REPLAY_PROTECTION_STATE_FILE")
+
+	os.Setenv("PROFILE", "staging")
+	if _, err := loadConfig("", log); err == nil || !strings.Contains(err.Error(), "profile (PROFILE)") {
+		t.Fatalf("expected unknown profile, got %v", err)
+	}
+	os.Unsetenv("PROFILE")
+}
@@ -0,0 +1,331 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gocql/gocql"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Storage prefix of the manifests of purges, `purges/<date>/<time>-<submitter>.json`
+const PURGES_PREFIX = "purges/"
+
+// Submissions quarantined by a purge are moved under this prefix of the
+// bucket (keeping the prefix of the network) or of the directory
+const QUARANTINE_PREFIX = "quarantine/"
+
+var ErrQuarantineUnsupported = errors.New("submissions of databases can't be quarantined, only deleted")
+
+// SubmitterPurge of the submissions of a submitter within a range of days,
+// e.g. of a spam key or on a takedown request
+type SubmitterPurge struct {
+	Submitter string `json:"submitter"`
+	// Days of submissions (YYYY-MM-DD), inclusive
+	Since string `json:"since"`
+	Until string `json:"until"`
+	// Move submissions of object storages under QUARANTINE_PREFIX rather
+	// than deleting them
+	Quarantine bool `json:"quarantine"`
+	// Only list the submissions which would be removed
+	DryRun bool   `json:"dry_run"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (p *SubmitterPurge) Validate() error {
+	var pk Pk
+	if err := StringToPk(&pk, p.Submitter); err != nil {
+		return fmt.Errorf("invalid submitter %q: %w", p.Submitter, err)
+	}
+	since, err := time.Parse("2006-01-02", p.Since)
+	if err != nil {
+		return fmt.Errorf("invalid since %q, expected YYYY-MM-DD", p.Since)
+	}
+	until, err := time.Parse("2006-01-02", p.Until)
+	if err != nil {
+		return fmt.Errorf("invalid until %q, expected YYYY-MM-DD", p.Until)
+	}
+	if until.Before(since) {
+		return fmt.Errorf("until %s is before since %s", p.Until, p.Since)
+	}
+	return nil
+}
+
+func (p *SubmitterPurge) includes(day string) bool {
+	return day >= p.Since && day <= p.Until
+}
+
+// Days of the purge, for databases which can't list days stored
+func (p *SubmitterPurge) days() []string {
+	return daysBetween(p.Since, p.Until)
+}
+
+// Days from since to until (YYYY-MM-DD), inclusive
+func daysBetween(since string, until string) []string {
+	from, _ := time.Parse("2006-01-02", since)
+	to, _ := time.Parse("2006-01-02", until)
+	var days []string
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format("2006-01-02"))
+	}
+	return days
+}
+
+// SubmitterPurger removes the submissions of a submitter from a backend,
+// passing the path of every submission removed (or which would be, in dry
+// runs) to removed. Paths of submissions of databases are the paths they'd
+// have in object storages. Blocks are left to the collection of orphaned
+// blocks, they don't identify submitters.
+type SubmitterPurger interface {
+	PurgeSubmitter(ctx context.Context, purge SubmitterPurge, removed func(path string)) error
+}
+
+// PurgeManifest of what a purge removed from every backend
+type PurgeManifest struct {
+	SubmitterPurge
+	StartedAt   time.Time                 `json:"started_at"`
+	CompletedAt time.Time                 `json:"completed_at"`
+	Backends    map[string]*PurgedBackend `json:"backends"`
+}
+
+// PurgedBackend lists submissions removed from a backend, sorted
+type PurgedBackend struct {
+	Submissions []string `json:"submissions"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// Whether the purge failed on any backend
+func (m *PurgeManifest) Failed() bool {
+	for _, backend := range m.Backends {
+		if backend.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Path of the manifest in object storages
+func (m *PurgeManifest) Path() string {
+	at := m.StartedAt.UTC().Format(time.RFC3339)
+	return PURGES_PREFIX + at[:10] + "/" + at + "-" + m.Submitter + ".json"
+}
+
+// SubmitterPurgeJob purges submitters from every backend. Manifests of
+// purges, dry runs excepted, are saved with Save, if set.
+type SubmitterPurgeJob struct {
+	Purgers map[string]SubmitterPurger
+	Save    func(ObjectsToSave) error
+	Now     nowFunc
+	Log     logging.StandardLogger
+}
+
+// Run the purge on every backend, failures of a backend don't stop the
+// purge of others and are recorded in the manifest
+func (j *SubmitterPurgeJob) Run(ctx context.Context, purge SubmitterPurge) (*PurgeManifest, error) {
+	if err := purge.Validate(); err != nil {
+		return nil, err
+	}
+	manifest := &PurgeManifest{SubmitterPurge: purge, StartedAt: j.Now(), Backends: make(map[string]*PurgedBackend)}
+	names := make([]string, 0, len(j.Purgers))
+	for name := range j.Purgers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		backend := &PurgedBackend{Submissions: []string{}}
+		manifest.Backends[name] = backend
+		err := j.Purgers[name].PurgeSubmitter(ctx, purge, func(path string) {
+			backend.Submissions = append(backend.Submissions, path)
+		})
+		sort.Strings(backend.Submissions)
+		if err != nil {
+			j.Log.Errorf("Failed to purge submissions of %s from %s: %v", purge.Submitter, name, err)
+			backend.Error = err.Error()
+			incMetric("purge_errors")
+		}
+		j.Log.Infof("Purged %d submissions of %s of %s to %s from %s (dry run: %v, quarantine: %v)",
+			len(backend.Submissions), purge.Submitter, purge.Since, purge.Until, name, purge.DryRun, purge.Quarantine)
+	}
+	manifest.CompletedAt = j.Now()
+	if j.Save == nil || purge.DryRun {
+		return manifest, nil
+	}
+	bs, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := j.Save(ObjectsToSave{manifest.Path(): bs}); err != nil {
+		return manifest, fmt.Errorf("error saving manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// AdminHandler serves purges of submitters:
+//
+//	POST /admin/purges  purges a submitter, body `{"submitter": "B62q...", "since": "2024-05-01", "until": "2024-05-31", "quarantine": false, "dry_run": true}`
+//
+// The manifest of the purge is returned once done, with status 500 if it
+// failed on any backend.
+func (j *SubmitterPurgeJob) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		var purge SubmitterPurge
+		if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 1<<16)).Decode(&purge); err != nil {
+			writeJSON(rw, http.StatusBadRequest, errorResponse{"Error decoding payload"})
+			return
+		}
+		if err := purge.Validate(); err != nil {
+			writeJSON(rw, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		manifest, err := j.Run(r.Context(), purge)
+		if err != nil {
+			writeJSON(rw, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		status := http.StatusOK
+		if manifest.Failed() {
+			status = http.StatusInternalServerError
+		}
+		writeJSON(rw, status, manifest)
+	})
+}
+
+// Remove the submissions of the submitter listed by the source, deleting
+// them with deleter
+func purgeObjectStorage(ctx context.Context, source StorageSource, deleter ObjectDeleter, purge SubmitterPurge, removed func(path string)) error {
+	days, err := source.Days(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing days of submissions: %w", err)
+	}
+	for _, day := range days {
+		if !purge.includes(day) {
+			continue
+		}
+		paths, err := source.Submissions(ctx, day)
+		if err != nil {
+			return fmt.Errorf("error listing submissions of %s: %w", day, err)
+		}
+		var purged []string
+		for _, path := range paths {
+			if _, submitter, ok := splitSubmissionPath(path); ok && submitter == purge.Submitter {
+				purged = append(purged, path)
+			}
+		}
+		if len(purged) == 0 {
+			continue
+		}
+		if !purge.DryRun {
+			if err := deleter.DeleteObjects(ctx, purged); err != nil {
+				return err
+			}
+		}
+		for _, path := range purged {
+			removed(path)
+		}
+	}
+	return nil
+}
+
+// PurgeSubmitter deletes submissions of the bucket, ignoring the archive
+// of the retention policy, or moves them under QUARANTINE_PREFIX
+func (c *S3Cleaner) PurgeSubmitter(ctx context.Context, purge SubmitterPurge, removed func(path string)) error {
+	deleter := &S3Cleaner{Aws: c.Aws}
+	if purge.Quarantine {
+		deleter.ArchivePrefix = QUARANTINE_PREFIX
+	}
+	return purgeObjectStorage(ctx, &S3Source{Aws: c.Aws}, deleter, purge, removed)
+}
+
+// PurgeSubmitter deletes submissions of the directory, ignoring the
+// archive of the retention policy, or moves them under QUARANTINE_PREFIX
+func (c LocalFileSystemCleaner) PurgeSubmitter(ctx context.Context, purge SubmitterPurge, removed func(path string)) error {
+	deleter := LocalFileSystemCleaner{Directory: c.Directory}
+	if purge.Quarantine {
+		deleter.ArchiveDirectory = filepath.Join(c.Directory, QUARANTINE_PREFIX)
+	}
+	return purgeObjectStorage(ctx, LocalFileSystemSource{Directory: c.Directory}, deleter, purge, removed)
+}
+
+func (ctx *PostgreSQLContext) PurgeSubmitter(c context.Context, purge SubmitterPurge, removed func(path string)) error {
+	if purge.Quarantine {
+		return ErrQuarantineUnsupported
+	}
+	query := `DELETE FROM submissions WHERE submitter = $1 AND submitted_at_date BETWEEN $2 AND $3 RETURNING submitted_at`
+	if purge.DryRun {
+		query = `SELECT submitted_at FROM submissions WHERE submitter = $1 AND submitted_at_date BETWEEN $2 AND $3`
+	}
+	var pk Pk
+	if err := StringToPk(&pk, purge.Submitter); err != nil {
+		return err
+	}
+	rows, err := ctx.DB.QueryContext(c, query, purge.Submitter, purge.Since, purge.Until)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var submittedAt time.Time
+		if err := rows.Scan(&submittedAt); err != nil {
+			return err
+		}
+		removed(makePaths(submittedAt, "", pk).Meta)
+	}
+	return rows.Err()
+}
+
+// PurgeSubmitter deletes rows of the submitter from every shard of the
+// days of the purge, selected by filtering
+func (c *KeyspacesCleaner) PurgeSubmitter(ctx context.Context, purge SubmitterPurge, removed func(path string)) error {
+	if purge.Quarantine {
+		return ErrQuarantineUnsupported
+	}
+	var pk Pk
+	if err := StringToPk(&pk, purge.Submitter); err != nil {
+		return err
+	}
+	keyspace := c.Keyspaces.Keyspace
+	selectQuery := "SELECT submitted_at FROM " + keyspace + ".submissions WHERE submitted_at_date = ? AND shard = ? AND submitter = ? ALLOW FILTERING"
+	deleteQuery := "DELETE FROM " + keyspace + ".submissions WHERE submitted_at_date = ? AND shard = ? AND submitted_at = ? AND submitter = ?"
+	for _, day := range purge.days() {
+		for shard := 0; shard < KEYSPACES_SHARDS_PER_DAY; shard++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var times []time.Time
+			err := c.Keyspaces.Session.Run(func(session *gocql.Session) error {
+				times = nil
+				iter := session.Query(selectQuery, day, shard, purge.Submitter).WithContext(ctx).Iter()
+				var submittedAt time.Time
+				for iter.Scan(&submittedAt) {
+					times = append(times, submittedAt)
+				}
+				return iter.Close()
+			})
+			if err != nil {
+				return fmt.Errorf("failed to select submissions of %s: %w", day, err)
+			}
+			for _, submittedAt := range times {
+				if !purge.DryRun {
+					err := c.Keyspaces.Session.Run(func(session *gocql.Session) error {
+						return session.Query(deleteQuery, day, shard, submittedAt, purge.Submitter).WithContext(ctx).Exec()
+					})
+					if err != nil {
+						return fmt.Errorf("failed to delete submission of %s: %w", submittedAt.Format(time.RFC3339), err)
+					}
+				}
+				removed(makePaths(submittedAt, "", pk).Meta)
+			}
+		}
+	}
+	return nil
+}
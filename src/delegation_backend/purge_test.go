@@ -0,0 +1,107 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestSubmitterPurge(t *testing.T) {
+	const spammer = "B62qkaKV3BLvLTf7nYXRehSaZAd36NWijt3MEmy2QgHRavboeRGtBMN"
+	const other = "B62qkgvrx8WoDqTzXaUm2vqBRbkiqTts35Z9n4XcrFez1KG3MHKHTbn"
+	dir := t.TempDir()
+	log := logging.Logger("test")
+	if err := LocalFileSystemSave(ObjectsToSave{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-" + spammer + ".json": []byte(`{}`),
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-" + spammer + ".json": []byte(`{}`),
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-" + other + ".json":   []byte(`{}`),
+		"submissions/2024-05-03/2024-05-03T10:00:00Z-" + spammer + ".json": []byte(`{}`),
+		"blocks/3NKa.dat": []byte("block"),
+	}, dir, log); err != nil {
+		t.Fatal(err)
+	}
+	var saved ObjectsToSave
+	job := &SubmitterPurgeJob{
+		Purgers: map[string]SubmitterPurger{
+			"filesystem": LocalFileSystemCleaner{Directory: dir, ArchiveDirectory: filepath.Join(dir, "archive")},
+			"postgresql": &PostgreSQLContext{},
+		},
+		Save: func(objs ObjectsToSave) error { saved = objs; return nil },
+		Now:  func() time.Time { return time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC) },
+		Log:  log,
+	}
+	purged := []string{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-" + spammer + ".json",
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-" + spammer + ".json",
+	}
+
+	// Dry runs list submissions without removing them, nor saving a manifest
+	manifest, err := job.Run(context.Background(), SubmitterPurge{Submitter: spammer, Since: "2024-05-01", Until: "2024-05-02", Quarantine: true, DryRun: true})
+	if err != nil || !reflect.DeepEqual(manifest.Backends["filesystem"].Submissions, purged) || saved != nil {
+		t.Fatalf("unexpected dry run %+v, error: %v", manifest, err)
+	}
+	// Databases can't quarantine, other backends are purged regardless
+	if !manifest.Failed() || manifest.Backends["postgresql"].Error != ErrQuarantineUnsupported.Error() {
+		t.Fatalf("expected quarantine failed on databases: %+v", manifest.Backends["postgresql"])
+	}
+	for _, path := range purged {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Fatalf("expected %s kept by a dry run", path)
+		}
+	}
+
+	// Submissions are quarantined rather than archived
+	delete(job.Purgers, "postgresql")
+	manifest, err = job.Run(context.Background(), SubmitterPurge{Submitter: spammer, Since: "2024-05-01", Until: "2024-05-02", Quarantine: true, Reason: "spam"})
+	if err != nil || manifest.Failed() || !reflect.DeepEqual(manifest.Backends["filesystem"].Submissions, purged) {
+		t.Fatalf("unexpected purge %+v, error: %v", manifest, err)
+	}
+	for _, path := range purged {
+		if _, err := os.Stat(filepath.Join(dir, path)); !os.IsNotExist(err) {
+			t.Errorf("expected %s removed", path)
+		}
+		if _, err := os.Stat(filepath.Join(dir, QUARANTINE_PREFIX, path)); err != nil {
+			t.Errorf("expected %s quarantined", path)
+		}
+	}
+	for _, path := range []string{"submissions/2024-05-02/2024-05-02T10:00:00Z-" + other + ".json", "submissions/2024-05-03/2024-05-03T10:00:00Z-" + spammer + ".json", "blocks/3NKa.dat"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Errorf("expected %s kept", path)
+		}
+	}
+	bs, ok := saved["purges/2024-06-01/2024-06-01T12:00:00Z-"+spammer+".json"]
+	var savedManifest PurgeManifest
+	if !ok || json.Unmarshal(bs, &savedManifest) != nil || savedManifest.Reason != "spam" || len(savedManifest.Backends["filesystem"].Submissions) != 2 {
+		t.Fatalf("unexpected manifest saved %v", saved)
+	}
+
+	for _, test := range []struct {
+		body   string
+		status int
+	}{
+		{`{"submitter": "` + spammer + `", "since": "2024-05-03", "until": "2024-05-03"}`, http.StatusOK},
+		{`{"submitter": "B62qa", "since": "2024-05-03", "until": "2024-05-03"}`, http.StatusBadRequest},
+		{`{"submitter": "` + spammer + `", "since": "2024-05-03", "until": "2024-05-01"}`, http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		job.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, ADMIN_API_PREFIX+"purges", strings.NewReader(test.body)))
+		if rec.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d: %s", test.body, test.status, rec.Code, rec.Body.String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "submissions/2024-05-03/2024-05-03T10:00:00Z-"+spammer+".json")); !os.IsNotExist(err) {
+		t.Error("expected the submission deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "archive")); !os.IsNotExist(err) {
+		t.Error("expected nothing archived by the retention policy")
+	}
+}
@@ -0,0 +1,86 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"time"
+)
+
+const RECEIPT_VERSION = 1
+
+// Receipt is returned to the submitter on successful submission and proves
+// that the backend accepted the submission at the given time.
+type Receipt struct {
+	Version      int       `json:"version"`
+	SubmissionId string    `json:"submission_id"`
+	Submitter    string    `json:"submitter"`
+	BlockHash    string    `json:"block_hash"`
+	ReceivedAt   time.Time `json:"received_at"`
+	KeyId        string    `json:"key_id,omitempty"`
+	Signature    string    `json:"signature"`
+}
+
+// Bytes covered by the signature, fields are newline-separated
+// in the fixed order.
+func (r *Receipt) signingInput() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d\n%s\n%s\n%s\n%s\n%s", r.Version, r.SubmissionId, r.Submitter,
+		r.BlockHash, r.ReceivedAt.UTC().Format(time.RFC3339Nano), r.KeyId)
+	return buf.Bytes()
+}
+
+func receiptMAC(r *Receipt, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(r.signingInput())
+	return mac.Sum(nil)
+}
+
+// ReceiptSigner signs receipts with HMAC-SHA256.
+type ReceiptSigner struct {
+	key   []byte
+	keyId string
+}
+
+func NewReceiptSigner(key []byte, keyId string) *ReceiptSigner {
+	return &ReceiptSigner{key: key, keyId: keyId}
+}
+
+// LoadReceiptSigningKey reads the key from a file, trailing
+// newline is not considered part of the key.
+func LoadReceiptSigningKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading receipt signing key: %w", err)
+	}
+	key = bytes.TrimRight(key, "\r\n")
+	if len(key) < 32 {
+		return nil, fmt.Errorf("receipt signing key must be at least 32 bytes long")
+	}
+	return key, nil
+}
+
+func (s *ReceiptSigner) Issue(submissionId string, submitter Pk, blockHash string, receivedAt time.Time) *Receipt {
+	r := &Receipt{
+		Version:      RECEIPT_VERSION,
+		SubmissionId: submissionId,
+		Submitter:    submitter.String(),
+		BlockHash:    blockHash,
+		ReceivedAt:   receivedAt.UTC(),
+		KeyId:        s.keyId,
+	}
+	r.Signature = base64.StdEncoding.EncodeToString(receiptMAC(r, s.key))
+	return r
+}
+
+// VerifyReceipt checks that the receipt was signed with the key.
+func VerifyReceipt(r *Receipt, key []byte) bool {
+	sig, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(sig, receiptMAC(r, key))
+}
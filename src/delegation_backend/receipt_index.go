@@ -0,0 +1,192 @@
+package delegation_backend
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Receipts of the most recent submissions kept in memory, when no
+// database is configured
+const RECEIPT_INDEX_MEMORY_MAX = 100000
+
+// Crockford's base32 alphabet of ULIDs
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID of the time: 48 bits of milliseconds since the
+// epoch followed by 80 random bits, in Crockford's base32, so that ids
+// sort by time
+func NewULID(t time.Time) (string, error) {
+	var b [16]byte
+	ms := uint64(t.UnixMilli())
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (8 * (5 - i)))
+	}
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	n := new(big.Int).SetBytes(b[:])
+	mask := big.NewInt(31)
+	var id [26]byte
+	for i := len(id) - 1; i >= 0; i-- {
+		id[i] = ulidAlphabet[new(big.Int).And(n, mask).Int64()]
+		n.Rsh(n, 5)
+	}
+	return string(id[:]), nil
+}
+
+// Whether the id is a ULID, in upper case
+func ValidULID(id string) bool {
+	if len(id) != 26 || id[0] > '7' {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if strings.IndexByte(ulidAlphabet, id[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ReceiptEntry maps the receipt id of an accepted submission to the keys
+// the submission is stored by, relative to the prefix of each storage
+// backend
+type ReceiptEntry struct {
+	ReceiptId string `json:"receipt_id"`
+	// Path of the submission metadata, as in receipts
+	SubmissionId string    `json:"submission_id"`
+	BlockPath    string    `json:"block_path"`
+	Submitter    string    `json:"submitter"`
+	BlockHash    string    `json:"block_hash"`
+	SubmittedAt  time.Time `json:"submitted_at"`
+}
+
+// ReceiptIndex looks accepted submissions up by their receipt id, so
+// that a submission acknowledged to a submitter but missing from a
+// storage backend can be traced.
+type ReceiptIndex interface {
+	Insert(entry ReceiptEntry) error
+	// Returns the entry of the receipt id, nil if there is none
+	Get(receiptId string) (*ReceiptEntry, error)
+}
+
+// MemoryReceiptIndex keeps the receipts of the most recent submissions,
+// used when no database is configured.
+type MemoryReceiptIndex struct {
+	mutex   sync.RWMutex
+	entries map[string]*ReceiptEntry
+	// Receipt ids in order of insertion, the oldest are evicted first
+	order []string
+	max   int
+}
+
+func NewMemoryReceiptIndex(max int) *MemoryReceiptIndex {
+	return &MemoryReceiptIndex{entries: make(map[string]*ReceiptEntry), max: max}
+}
+
+func (index *MemoryReceiptIndex) Insert(entry ReceiptEntry) error {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	if len(index.order) >= index.max {
+		evicted := len(index.order) - index.max + 1
+		for _, id := range index.order[:evicted] {
+			delete(index.entries, id)
+		}
+		index.order = append(index.order[:0], index.order[evicted:]...)
+	}
+	index.entries[entry.ReceiptId] = &entry
+	index.order = append(index.order, entry.ReceiptId)
+	return nil
+}
+
+func (index *MemoryReceiptIndex) Get(receiptId string) (*ReceiptEntry, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	return index.entries[receiptId], nil
+}
+
+// PostgreSQLReceiptIndex keeps receipts in the `submission_receipts` table.
+type PostgreSQLReceiptIndex struct {
+	DB *sql.DB
+}
+
+func (index *PostgreSQLReceiptIndex) CreateTableIfNotExists() error {
+	_, err := index.DB.Exec(`CREATE TABLE IF NOT EXISTS submission_receipts (
+				receipt_id TEXT PRIMARY KEY,
+				submission_id TEXT NOT NULL,
+				block_path TEXT NOT NULL,
+				submitter TEXT NOT NULL,
+				block_hash TEXT NOT NULL,
+				submitted_at TIMESTAMPTZ NOT NULL)`)
+	return err
+}
+
+func (index *PostgreSQLReceiptIndex) Insert(entry ReceiptEntry) error {
+	_, err := index.DB.Exec(`INSERT INTO submission_receipts (receipt_id, submission_id, block_path, submitter, block_hash, submitted_at)
+			VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (receipt_id) DO NOTHING`,
+		entry.ReceiptId, entry.SubmissionId, entry.BlockPath, entry.Submitter, entry.BlockHash, entry.SubmittedAt)
+	return err
+}
+
+func (index *PostgreSQLReceiptIndex) Get(receiptId string) (*ReceiptEntry, error) {
+	var entry ReceiptEntry
+	err := index.DB.QueryRow(`SELECT receipt_id, submission_id, block_path, submitter, block_hash, submitted_at
+			FROM submission_receipts WHERE receipt_id = $1`, receiptId).
+		Scan(&entry.ReceiptId, &entry.SubmissionId, &entry.BlockPath, &entry.Submitter, &entry.BlockHash, &entry.SubmittedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ReceiptLookup is the response of /v1/receipts/<id>
+type ReceiptLookup struct {
+	ReceiptEntry
+	// Outcomes of saving the submission by backend, if recorded
+	Backends map[string]BackendWrite `json:"backends,omitempty"`
+}
+
+// ReceiptsHandler serves the entries of receipts at /v1/receipts/<id>,
+// along with the outcomes of saving their submission to each backend
+func ReceiptsHandler(index ReceiptIndex, outcomes WriteOutcomeStore) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		id := strings.ToUpper(r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:])
+		if !ValidULID(id) {
+			writeJSON(rw, http.StatusBadRequest, errorResponse{"Expected a receipt id, a ULID"})
+			return
+		}
+		entry, err := index.Get(id)
+		if err != nil {
+			writeJSON(rw, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		if entry == nil {
+			writeJSON(rw, http.StatusNotFound, errorResponse{"Receipt not found"})
+			return
+		}
+		lookup := ReceiptLookup{ReceiptEntry: *entry}
+		if outcomes != nil {
+			found, err := outcomes.Query(WriteOutcomeQuery{SubmissionId: entry.SubmissionId, Limit: 1})
+			if err != nil {
+				writeJSON(rw, http.StatusInternalServerError, errorResponse{err.Error()})
+				return
+			}
+			if len(found) > 0 {
+				lookup.Backends = found[0].Backends
+			}
+		}
+		writeJSON(rw, http.StatusOK, lookup)
+	})
+}
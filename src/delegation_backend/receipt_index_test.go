@@ -0,0 +1,78 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubmitReceiptIndex(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, tm := testSubmitH(10, Whitelist{req.Submitter: true})
+	index := NewMemoryReceiptIndex(10)
+	outcomes := NewMemoryWriteOutcomeStore(10)
+	sh.app.ReceiptIndex, sh.app.WriteOutcomes = index, outcomes
+	save := sh.app.Save
+	sh.app.Save = func(ctx context.Context, objs ObjectsToSave) StorageOutcomes {
+		save(ctx, objs)
+		return StorageOutcomes{"s3": nil}
+	}
+
+	rep := sh.testRequest(body)
+	var resp submitResponse
+	if err := json.Unmarshal(rep.Body.Bytes(), &resp); err != nil || rep.Code != 200 || !ValidULID(resp.ReceiptId) {
+		t.Fatalf("expected a receipt id, got %d %s", rep.Code, rep.Body.String())
+	}
+
+	lookup := func(id string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ReceiptsHandler(index, outcomes).ServeHTTP(rr, httptest.NewRequest("GET", "/v1/receipts/"+id, nil))
+		return rr
+	}
+	rr := lookup(strings.ToLower(resp.ReceiptId))
+	var found ReceiptLookup
+	json.Unmarshal(rr.Body.Bytes(), &found)
+	paths := makePaths(tm.Now(), req.GetBlockDataHash(), req.Submitter)
+	if rr.Code != 200 || found.ReceiptId != resp.ReceiptId || found.SubmissionId != paths.Meta || found.BlockPath != paths.Block {
+		t.Fatalf("unexpected receipt: %d %s", rr.Code, rr.Body.String())
+	}
+	if write, recorded := found.Backends["s3"]; !recorded || !write.Ok {
+		t.Fatalf("expected the outcome of saving the submission, got %+v", found.Backends)
+	}
+	unknown, _ := NewULID(tm.Now())
+	if rr := lookup(unknown); rr.Code != 404 {
+		t.Fatalf("expected an unknown receipt not found, got %d", rr.Code)
+	}
+	if rr := lookup("submissions"); rr.Code != 400 {
+		t.Fatalf("expected an invalid receipt id rejected, got %d", rr.Code)
+	}
+}
+
+func TestULID(t *testing.T) {
+	earlier, _ := NewULID(time.UnixMilli(1714557600000))
+	later, _ := NewULID(time.UnixMilli(1714557600001))
+	if !ValidULID(earlier) || !ValidULID(later) || earlier[:10] != "01HWSSHG80" || later <= earlier {
+		t.Fatalf("unexpected ULIDs %s and %s", earlier, later)
+	}
+	if ValidULID("81HWSJ0S000000000000000000") || ValidULID("01HWSJ0S00000000000000000U") {
+		t.Fatal("expected invalid ULIDs rejected")
+	}
+
+	index := NewMemoryReceiptIndex(2)
+	for _, id := range []string{"a", "b", "c"} {
+		_ = index.Insert(ReceiptEntry{ReceiptId: id})
+	}
+	if entry, _ := index.Get("a"); entry != nil {
+		t.Fatalf("expected the oldest receipt dropped, got %+v", entry)
+	}
+	if entry, _ := index.Get("c"); entry == nil {
+		t.Fatal("expected the latest receipt kept")
+	}
+}
@@ -0,0 +1,54 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var testReceiptKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestReceiptSignAndVerify(t *testing.T) {
+	signer := NewReceiptSigner(testReceiptKey, "key-1")
+	pk := mkPk()
+	r := signer.Issue("submissions/2024-05-01/x.json", pk, "blockhash", time.Now())
+	if !VerifyReceipt(r, testReceiptKey) {
+		t.Fatal("valid receipt rejected")
+	}
+	// Survives a JSON round trip
+	bs, _ := json.Marshal(r)
+	var decoded Receipt
+	if err := json.Unmarshal(bs, &decoded); err != nil || !VerifyReceipt(&decoded, testReceiptKey) {
+		t.Fatalf("receipt rejected after JSON round trip: %v", err)
+	}
+	if VerifyReceipt(r, []byte("another key another key another!")) {
+		t.Fatal("receipt accepted with wrong key")
+	}
+	tampered := *r
+	tampered.ReceivedAt = tampered.ReceivedAt.Add(-time.Hour)
+	if VerifyReceipt(&tampered, testReceiptKey) {
+		t.Fatal("tampered receipt accepted")
+	}
+}
+
+func TestSubmitReturnsReceipt(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.ReceiptSigner = NewReceiptSigner(testReceiptKey, "")
+	rep := sh.testRequest(body)
+	if rep.Code != 200 {
+		t.Fatalf("unexpected failure: %v", rep)
+	}
+	var resp submitResponse
+	if err := json.Unmarshal(rep.Body.Bytes(), &resp); err != nil || resp.Receipt == nil {
+		t.Fatalf("no receipt in response: %s", rep.Body.String())
+	}
+	expectedPaths := makePaths(tm.Now(), req.GetBlockDataHash(), req.Submitter)
+	if resp.Status != "ok" || resp.Receipt.SubmissionId != expectedPaths.Meta || !VerifyReceipt(resp.Receipt, testReceiptKey) {
+		t.Fatalf("unexpected receipt: %+v", resp.Receipt)
+	}
+}
@@ -0,0 +1,282 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Interval the config file is checked for changes
+const CONFIG_RELOAD_CHECK_INTERVAL = 10 * time.Second
+
+// RequestsPerPkHourly is the default hourly limit of submissions of a key
+func (config AppConfig) RequestsPerPkHourly() int {
+	if config.RateLimit != nil && config.RateLimit.RequestsPerPkHourly > 0 {
+		return config.RateLimit.RequestsPerPkHourly
+	}
+	return REQUESTS_PER_PK_HOURLY
+}
+
+func (config AppConfig) WhitelistRefreshInterval() time.Duration {
+	if config.WhitelistRefreshMinutes > 0 {
+		return time.Duration(config.WhitelistRefreshMinutes) * time.Minute
+	}
+	return WHITELIST_REFRESH_INTERVAL
+}
+
+func (config AppConfig) StatsWindowDays() int {
+	if config.SubmitterStats != nil && config.SubmitterStats.WindowDays > 0 {
+		return config.SubmitterStats.WindowDays
+	}
+	return STATS_WINDOW_DAYS
+}
+
+// Copy of the configuration without the settings applied on reload
+func withoutTunables(config AppConfig) AppConfig {
+	return withTunablesOf(config, AppConfig{})
+}
+
+// Copy of the configuration with the settings applied on reload of from
+func withTunablesOf(config AppConfig, from AppConfig) AppConfig {
+	config.RateLimit = from.RateLimit
+	config.WhitelistRefreshMinutes = from.WhitelistRefreshMinutes
+	config.FeatureFlags = from.FeatureFlags
+	config.SubmitSize = from.SubmitSize
+	var logCfg LoggingConfig
+	if config.Logging != nil {
+		logCfg = *config.Logging
+	}
+	logCfg.Level, logCfg.SubsystemLevels = "", nil
+	if from.Logging != nil {
+		logCfg.Level, logCfg.SubsystemLevels = from.Logging.Level, from.Logging.SubsystemLevels
+	}
+	config.Logging = nil
+	if !reflect.ValueOf(logCfg).IsZero() {
+		config.Logging = &logCfg
+	}
+	var stats SubmitterStatsConfig
+	if config.SubmitterStats != nil {
+		stats = *config.SubmitterStats
+	}
+	stats.WindowDays = 0
+	if from.SubmitterStats != nil {
+		stats.WindowDays = from.SubmitterStats.WindowDays
+	}
+	config.SubmitterStats = nil
+	if stats != (SubmitterStatsConfig{}) {
+		config.SubmitterStats = &stats
+	}
+	return config
+}
+
+// Keys of settings changed between the configurations which
+// are not applied on reload, and require a restart.
+func restartRequired(old AppConfig, new AppConfig) []string {
+	var oldKeys, newKeys map[string]json.RawMessage
+	bs, _ := json.Marshal(withoutTunables(old))
+	_ = json.Unmarshal(bs, &oldKeys)
+	bs, _ = json.Marshal(withoutTunables(new))
+	_ = json.Unmarshal(bs, &newKeys)
+	var keys []string
+	for key, value := range oldKeys {
+		if !bytes.Equal(value, newKeys[key]) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range newKeys {
+		if _, exists := oldKeys[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ConfigReloader reloads the configuration when the config file changes
+// (or on request of the admin API), and applies the settings which are
+// safe to change at runtime: rate limits, whitelist refresh interval,
+// log levels, the window of submitter statistics, feature flags and
+// limits of the size of submissions.
+// Changes of other settings are logged, they take effect on restart.
+type ConfigReloader struct {
+	mutex      sync.Mutex
+	configFile string
+	config     AppConfig
+	modTime    time.Time
+	appliers   []func(AppConfig) error
+	lastReload time.Time
+	// Settings changed since start which are not applied
+	restartRequired []string
+	now             nowFunc
+	log             logging.EventLogger
+}
+
+// ConfigReloaderStatus is the state of the reloader, as reported by the admin API
+type ConfigReloaderStatus struct {
+	ConfigFile                      string         `json:"config_file,omitempty"`
+	LastReload                      *time.Time     `json:"last_reload,omitempty"`
+	RestartRequired                 []string       `json:"restart_required,omitempty"`
+	RequestsPerPkHourly             int            `json:"requests_per_pk_hourly"`
+	RequestsPerPkHourlyOverrides    map[string]int `json:"requests_per_pk_hourly_overrides,omitempty"`
+	WhitelistRefreshIntervalMinutes int            `json:"delegation_whitelist_refresh_interval_minutes"`
+	StatsWindowDays                 int            `json:"stats_window_days"`
+}
+
+// NewConfigReloader creates the reloader of the configuration loaded at
+// start from configFile (or the file set by CONFIG_FILE). It must be
+// created before secrets of the configuration are resolved, as a copy
+// of the configuration is kept to detect changes.
+func NewConfigReloader(configFile string, config AppConfig, now nowFunc, log logging.EventLogger) *ConfigReloader {
+	// Sections are pointers, shared with the configuration until copied
+	var copied AppConfig
+	if bs, err := json.Marshal(config); err == nil && json.Unmarshal(bs, &copied) == nil {
+		config = copied
+	}
+	r := &ConfigReloader{configFile: configFilePath(configFile), config: config, now: now, log: log}
+	if r.configFile != "" {
+		if info, err := os.Stat(r.configFile); err == nil {
+			r.modTime = info.ModTime()
+		}
+	}
+	return r
+}
+
+// OnReload registers a function applying the reloaded configuration
+func (r *ConfigReloader) OnReload(apply func(AppConfig) error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.appliers = append(r.appliers, apply)
+}
+
+// Config returns the current configuration
+func (r *ConfigReloader) Config() AppConfig {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.config
+}
+
+// Reload loads the configuration and applies it, the current configuration
+// is kept if the new one is invalid. Keys of settings changed since start
+// which require a restart are returned.
+func (r *ConfigReloader) Reload() ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.configFile != "" {
+		if info, err := os.Stat(r.configFile); err == nil {
+			r.modTime = info.ModTime()
+		}
+	}
+	config, err := loadConfig(r.configFile, r.log)
+	if err != nil {
+		incMetric("config_reload_errors")
+		return nil, err
+	}
+	var errs []string
+	for _, apply := range r.appliers {
+		if err := apply(config); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	restart := restartRequired(r.config, config)
+	// Settings not applied are kept as loaded at start, so
+	// that later reloads report them until a restart
+	r.config = withTunablesOf(r.config, config)
+	r.restartRequired = restart
+	r.lastReload = r.now()
+	incMetric("config_reloads")
+	if len(errs) > 0 {
+		incMetric("config_reload_errors")
+		return restart, fmt.Errorf("error applying configuration: %s", strings.Join(errs, "; "))
+	}
+	return restart, nil
+}
+
+// Reload and log the outcome
+func (r *ConfigReloader) reloadAndLog() {
+	restart, err := r.Reload()
+	if err != nil {
+		r.log.Errorf("Failed to reload configuration: %v", err)
+		return
+	}
+	r.log.Infof("Configuration reloaded")
+	if len(restart) > 0 {
+		r.log.Warnf("Changes of %s take effect on restart", strings.Join(restart, ", "))
+	}
+}
+
+// Check whether the config file was modified since last reload
+func (r *ConfigReloader) modified() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	info, err := os.Stat(r.configFile)
+	if err != nil {
+		return false
+	}
+	return !info.ModTime().Equal(r.modTime)
+}
+
+// Periodically check the config file, reloading the configuration once
+// modified. There is nothing to watch if there is no config file.
+func (r *ConfigReloader) WatchLoop(interval time.Duration) {
+	if r.configFile == "" {
+		return
+	}
+	for {
+		time.Sleep(interval)
+		if r.modified() {
+			r.reloadAndLog()
+		}
+	}
+}
+
+func (r *ConfigReloader) Status() ConfigReloaderStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	status := ConfigReloaderStatus{
+		ConfigFile:                      r.configFile,
+		RestartRequired:                 r.restartRequired,
+		RequestsPerPkHourly:             r.config.RequestsPerPkHourly(),
+		WhitelistRefreshIntervalMinutes: int(r.config.WhitelistRefreshInterval() / time.Minute),
+		StatsWindowDays:                 r.config.StatsWindowDays(),
+	}
+	if r.config.RateLimit != nil {
+		status.RequestsPerPkHourlyOverrides = r.config.RateLimit.Overrides
+	}
+	if !r.lastReload.IsZero() {
+		lastReload := r.lastReload
+		status.LastReload = &lastReload
+	}
+	return status
+}
+
+// AdminHandler serves the reload of the configuration:
+//
+//	GET  /admin/config  returns the settings applied on reload
+//	POST /admin/config  reloads the configuration
+func (r *ConfigReloader) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			writeJSON(rw, http.StatusOK, r.Status())
+		case http.MethodPost:
+			if _, err := r.Reload(); err != nil {
+				r.log.Errorf("Failed to reload configuration: %v", err)
+				writeJSON(rw, http.StatusBadRequest, errorResponse{err.Error()})
+				return
+			}
+			r.log.Infof("Configuration reloaded by the admin API")
+			writeJSON(rw, http.StatusOK, r.Status())
+		default:
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+		}
+	})
+}
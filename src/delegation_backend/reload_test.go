@@ -0,0 +1,108 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestConfigReloader(t *testing.T) {
+	os.Clearenv()
+	log := logging.Logger("test")
+	tm := &timeMock{time: time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string, modTime time.Time) {
+		os.WriteFile(path, []byte(content), 0644)
+		os.Chtimes(path, modTime, modTime)
+	}
+	write(`
+network_name: test_network
+delegation_whitelist_disabled: true
+filesystem:
+  path: /tmp/submissions
+rate_limit:
+  requests_per_pk_hourly: 10
+`, tm.time)
+	config, err := loadConfig(path, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader := NewConfigReloader(path, config, tm.Now, log)
+	counter := NewAttemptCounter(config.RequestsPerPkHourly())
+	stats, _ := NewSubmitterStats("", tm.Now)
+	reloader.OnReload(func(cfg AppConfig) error {
+		overrides, err := cfg.RateLimit.PkOverrides()
+		if err != nil {
+			return err
+		}
+		counter.SetMaxAttempt(cfg.RequestsPerPkHourly())
+		counter.SetOverrides(overrides)
+		stats.SetWindowDays(cfg.StatsWindowDays())
+		return nil
+	})
+	if reloader.modified() {
+		t.Fatal("expected the config file not modified")
+	}
+
+	write(`
+network_name: test_network
+delegation_whitelist_disabled: true
+filesystem:
+  path: /tmp/other
+rate_limit:
+  requests_per_pk_hourly: 1
+submitter_stats:
+  window_days: 3
+`, tm.time.Add(time.Minute))
+	if !reloader.modified() {
+		t.Fatal("expected the config file modified")
+	}
+	restart, err := reloader.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(restart, ",") != "filesystem" {
+		t.Fatalf("expected filesystem to require a restart, got %v", restart)
+	}
+	var pk Pk
+	if !counter.RecordAttempt(pk) || counter.RecordAttempt(pk) {
+		t.Fatal("expected the reloaded rate limit applied")
+	}
+	if stats.WindowDays() != 3 {
+		t.Fatalf("expected the reloaded window applied, got %d", stats.WindowDays())
+	}
+	if current := reloader.Config(); current.LocalFileSystem.Path != "/tmp/submissions" || current.RequestsPerPkHourly() != 1 {
+		t.Fatalf("unexpected configuration %+v", current)
+	}
+
+	// Invalid configuration is not applied
+	write("network_name: test_network\n", tm.time.Add(2*time.Minute))
+	if _, err := reloader.Reload(); err == nil || !strings.Contains(err.Error(), "no storage backend configured") {
+		t.Fatalf("expected an invalid configuration, got %v", err)
+	}
+	if reloader.Config().RequestsPerPkHourly() != 1 || reloader.modified() {
+		t.Fatal("expected the previous configuration kept")
+	}
+
+	rr := httptest.NewRecorder()
+	reloader.AdminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/admin/config", nil))
+	var status ConfigReloaderStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil || rr.Code != 200 {
+		t.Fatalf("unexpected response %d: %s", rr.Code, rr.Body)
+	}
+	if status.RequestsPerPkHourly != 1 || status.StatsWindowDays != 3 || status.WhitelistRefreshIntervalMinutes != 10 ||
+		strings.Join(status.RestartRequired, ",") != "filesystem" || status.LastReload == nil {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	rr = httptest.NewRecorder()
+	reloader.AdminHandler().ServeHTTP(rr, httptest.NewRequest("POST", "/admin/config", nil))
+	if rr.Code != 400 {
+		t.Fatalf("expected reload of an invalid configuration to fail, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,227 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+const REPLAY_STATE_PERSIST_INTERVAL = time.Minute
+
+// ReplayGuard remembers the latest accepted `created_at` of every submitter
+// and refuses submissions which are not strictly newer, so that captured
+// submissions can't be replayed.
+type ReplayGuard struct {
+	mutex     sync.Mutex
+	latest    map[Pk]time.Time
+	stateFile string
+	dirty     bool
+	// Optional, submissions are compared across replicas
+	shared SharedState
+}
+
+// NewReplayGuard creates a guard, restoring its state from stateFile
+// if the file exists. Empty stateFile keeps the state in memory only.
+func NewReplayGuard(stateFile string) (*ReplayGuard, error) {
+	g := &ReplayGuard{latest: make(map[Pk]time.Time), stateFile: stateFile}
+	if stateFile == "" {
+		return g, nil
+	}
+	bs, err := os.ReadFile(stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading replay protection state: %w", err)
+	}
+	var state map[string]time.Time
+	if err := json.Unmarshal(bs, &state); err != nil {
+		return nil, fmt.Errorf("error decoding replay protection state: %w", err)
+	}
+	for pkStr, createdAt := range state {
+		var pk Pk
+		if err := StringToPk(&pk, pkStr); err != nil {
+			return nil, fmt.Errorf("invalid public key %s in replay protection state: %w", pkStr, err)
+		}
+		g.latest[pk] = createdAt
+	}
+	return g, nil
+}
+
+// Compare submissions with the latest accepted by any replica. Submissions
+// are compared in memory while the shared state fails.
+func (g *ReplayGuard) SetSharedState(shared SharedState) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.shared = shared
+}
+
+// Check returns whether createdAt is later than the `created_at` of the
+// latest accepted submission of pk, recording nothing. Submissions are
+// committed once saved, so that a submission failing to be saved can be
+// retried.
+//
+// Only whole seconds are compared, as this is the precision
+// of `created_at` covered by the signature.
+func (g *ReplayGuard) Check(pk Pk, createdAt time.Time) bool {
+	createdAt = createdAt.UTC().Truncate(time.Second)
+	g.mutex.Lock()
+	shared := g.shared
+	latest, exists := g.latest[pk]
+	g.mutex.Unlock()
+	if exists && !createdAt.After(latest) {
+		return false
+	}
+	if shared != nil {
+		value, found, err := shared.Get("replay:" + pk.String())
+		if err != nil {
+			incMetric("shared_state_errors")
+			return true
+		}
+		if ms, err := strconv.ParseInt(value, 10, 64); found && err == nil && createdAt.UnixMilli() <= ms {
+			return false
+		}
+	}
+	return true
+}
+
+// Commit records createdAt as the latest accepted submission of pk.
+// Returns `false` (and records nothing) if a submission with the same
+// or a later `created_at` was already accepted.
+func (g *ReplayGuard) Commit(pk Pk, createdAt time.Time) bool {
+	createdAt = createdAt.UTC().Truncate(time.Second)
+	g.mutex.Lock()
+	shared := g.shared
+	g.mutex.Unlock()
+	if shared != nil {
+		accepted, err := shared.AdvanceTo("replay:"+pk.String(), createdAt)
+		if err == nil {
+			if accepted {
+				g.mutex.Lock()
+				g.latest[pk] = createdAt
+				g.dirty = true
+				g.mutex.Unlock()
+			}
+			return accepted
+		}
+		incMetric("shared_state_errors")
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if latest, exists := g.latest[pk]; exists && !createdAt.After(latest) {
+		return false
+	}
+	g.latest[pk] = createdAt
+	g.dirty = true
+	return true
+}
+
+// Latest returns the latest accepted `created_at` of pk.
+func (g *ReplayGuard) Latest(pk Pk) (time.Time, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	latest, exists := g.latest[pk]
+	return latest, exists
+}
+
+// Snapshot of the latest accepted `created_at` by public key
+func (g *ReplayGuard) Snapshot() map[string]time.Time {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	state := make(map[string]time.Time, len(g.latest))
+	for pk, createdAt := range g.latest {
+		state[pk.String()] = createdAt
+	}
+	return state
+}
+
+// Restore the state of a snapshot, keeping the later of the restored and
+// the recorded `created_at` of every key
+func (g *ReplayGuard) Restore(state map[string]time.Time) error {
+	restored := make(map[Pk]time.Time, len(state))
+	for pkStr, createdAt := range state {
+		var pk Pk
+		if err := StringToPk(&pk, pkStr); err != nil {
+			return fmt.Errorf("invalid public key %s in replay protection state: %w", pkStr, err)
+		}
+		restored[pk] = createdAt
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for pk, createdAt := range restored {
+		if latest, exists := g.latest[pk]; !exists || createdAt.After(latest) {
+			g.latest[pk] = createdAt
+			g.dirty = true
+		}
+	}
+	return nil
+}
+
+// Persist writes the state to the state file if it changed since last write.
+func (g *ReplayGuard) Persist() error {
+	if g.stateFile == "" {
+		return nil
+	}
+	g.mutex.Lock()
+	if !g.dirty {
+		g.mutex.Unlock()
+		return nil
+	}
+	state := make(map[string]time.Time, len(g.latest))
+	for pk, createdAt := range g.latest {
+		state[pk.String()] = createdAt
+	}
+	g.dirty = false
+	g.mutex.Unlock()
+
+	bs, err := json.Marshal(state)
+	if err == nil {
+		err = writeFileAtomically(g.stateFile, bs)
+	}
+	if err != nil {
+		g.mutex.Lock()
+		g.dirty = true
+		g.mutex.Unlock()
+		return fmt.Errorf("error writing replay protection state: %w", err)
+	}
+	return nil
+}
+
+// Periodically persist the state until the context is done, the state
+// is left to be persisted a last time on shutdown.
+func (g *ReplayGuard) PersistLoop(ctx context.Context, interval time.Duration, log logging.StandardLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := g.Persist(); err != nil {
+				log.Errorf("Failed to persist replay protection state: %v", err)
+			}
+		}
+	}
+}
+
+// Write to a temporary file first so that a crash never leaves
+// a truncated file behind.
+func writeFileAtomically(path string, bs []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
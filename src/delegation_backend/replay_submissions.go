@@ -0,0 +1,153 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// ReplayFilter selects stored submissions by day of submission, days
+// are in `YYYY-MM-DD` format and bounds are inclusive, empty for none.
+// Bounds of a replay may be RFC 3339 times as well, e.g. for a window of
+// an outage, submissions are then selected by time of submission.
+type ReplayFilter struct {
+	Since string
+	Until string
+}
+
+func (f ReplayFilter) includes(day string) bool {
+	return (f.Since == "" || day >= dayOf(f.Since)) && (f.Until == "" || day <= dayOf(f.Until))
+}
+
+// Day of a bound, a day or a time
+func dayOf(bound string) string {
+	if t, err := time.Parse(time.RFC3339, bound); err == nil {
+		return t.UTC().Format("2006-01-02")
+	}
+	return bound
+}
+
+// Whether the submission at metaPath is within the bounds set to times.
+// Paths not named by time of submission are selected by day only.
+func (f ReplayFilter) includesSubmission(metaPath string) bool {
+	submittedAt, err := SubmittedAtOfPath(metaPath)
+	if err != nil {
+		return true
+	}
+	if since, err := time.Parse(time.RFC3339, f.Since); err == nil && submittedAt.Before(since) {
+		return false
+	}
+	if until, err := time.Parse(time.RFC3339, f.Until); err == nil && submittedAt.After(until) {
+		return false
+	}
+	return true
+}
+
+// SubmittedAtOfPath parses the time of submission of the path of a
+// submission, named `<submitted at, RFC 3339>-<submitter>.json` by makePaths
+func SubmittedAtOfPath(metaPath string) (time.Time, error) {
+	name := path.Base(metaPath)
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return time.Time{}, fmt.Errorf("%s is not named by time of submission", name)
+	}
+	return time.Parse(time.RFC3339, name[:i])
+}
+
+// ReplayResult counts submissions replayed, those which failed to be
+// read or saved to any backend and those replayed without a block as it
+// wasn't found. Failures to save are counted by backend as well.
+type ReplayResult struct {
+	Submissions    int            `json:"submissions"`
+	Failed         int            `json:"failed"`
+	MissingBlocks  int            `json:"missing_blocks"`
+	FailedBackends map[string]int `json:"failed_backends,omitempty"`
+}
+
+// ReplaySubmissions reads submissions stored by the source and passes
+// every one of them, along with its block, to save as the submit handler
+// does, e.g. to backfill a backend added later or to recover backends
+// from an outage. Submissions are replayed in order of submission.
+func ReplaySubmissions(ctx context.Context, source StorageSource, filter ReplayFilter, save func(ObjectsToSave) StorageOutcomes, log logging.StandardLogger) (ReplayResult, error) {
+	result := ReplayResult{FailedBackends: make(map[string]int)}
+	days, err := source.Days(ctx)
+	if err != nil {
+		return result, fmt.Errorf("error reading submissions: %w", err)
+	}
+	for _, day := range days {
+		if !filter.includes(day) {
+			continue
+		}
+		paths, err := source.Submissions(ctx, day)
+		if err != nil {
+			return result, fmt.Errorf("error reading submissions of %s: %w", day, err)
+		}
+		for _, metaPath := range paths {
+			if !filter.includesSubmission(metaPath) {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			objs, missingBlock, err := readStoredSubmission(ctx, source, metaPath)
+			if err != nil {
+				log.Errorf("Failed to read submission %s: %v", metaPath, err)
+				result.Failed++
+				continue
+			}
+			if missingBlock {
+				log.Warnf("Block of submission %s not found, replaying the submission only", metaPath)
+				result.MissingBlocks++
+			}
+			failed := false
+			for backend, err := range save(objs) {
+				if err != nil {
+					log.Errorf("Failed to replay submission %s to %s: %v", metaPath, backend, err)
+					result.FailedBackends[backend]++
+					failed = true
+				}
+			}
+			if failed {
+				result.Failed++
+				continue
+			}
+			result.Submissions++
+		}
+	}
+	return result, nil
+}
+
+// Read the submission at metaPath and its block, if found
+func readStoredSubmission(ctx context.Context, source StorageSource, metaPath string) (ObjectsToSave, bool, error) {
+	meta, err := source.Read(ctx, metaPath)
+	if err != nil {
+		return nil, false, err
+	}
+	var submission struct {
+		BlockHash string `json:"block_hash"`
+	}
+	if err := json.Unmarshal(meta, &submission); err != nil {
+		return nil, false, fmt.Errorf("error unmarshaling submission JSON: %w", err)
+	}
+	objs := ObjectsToSave{metaPath: meta}
+	if submission.BlockHash == "" {
+		return objs, true, nil
+	}
+	blockPath := BLOCKS_PREFIX + submission.BlockHash + ".dat"
+	block, err := source.Read(ctx, blockPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return objs, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	objs[blockPath] = block
+	return objs, false, nil
+}
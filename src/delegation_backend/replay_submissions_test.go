@@ -0,0 +1,92 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestReplaySubmissions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	log := logging.Logger("test")
+	if err := LocalFileSystemSave(ObjectsToSave{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NK1"}`),
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NK2","submitter":"B62qa"}`),
+		"submissions/2024-05-02/2024-05-02T11:00:00Z-B62qb.json": []byte(`{"block_hash":"3NK3"}`),
+		"submissions/2024-05-02/2024-05-02T12:00:00Z-B62qc.json": []byte(`{"block_hash":"3NK1"}`),
+		"submissions/2024-05-02/2024-05-02T13:00:00Z-B62qd.json": []byte(`not json`),
+		"submissions/2024-05-03/2024-05-03T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NK1"}`),
+		"blocks/3NK1.dat": []byte("block1"),
+		"blocks/3NK2.dat": []byte("block2"),
+	}, dir, log); err != nil {
+		t.Fatal(err)
+	}
+
+	var replayed []ObjectsToSave
+	source := LocalFileSystemSource{Directory: dir}
+	result, err := ReplaySubmissions(ctx, source, ReplayFilter{Since: "2024-05-02", Until: "2024-05-02"}, func(objs ObjectsToSave) StorageOutcomes {
+		replayed = append(replayed, objs)
+		outcomes := StorageOutcomes{"filesystem": nil, "postgresql": nil}
+		if _, exists := objs["submissions/2024-05-02/2024-05-02T12:00:00Z-B62qc.json"]; exists {
+			outcomes["postgresql"] = errors.New("storage unavailable")
+		}
+		return outcomes
+	}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, ReplayResult{Submissions: 2, Failed: 2, MissingBlocks: 1, FailedBackends: map[string]int{"postgresql": 1}}) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	expected := []ObjectsToSave{
+		{
+			"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NK2","submitter":"B62qa"}`),
+			"blocks/3NK2.dat": []byte("block2"),
+		},
+		{
+			"submissions/2024-05-02/2024-05-02T11:00:00Z-B62qb.json": []byte(`{"block_hash":"3NK3"}`),
+		},
+		{
+			"submissions/2024-05-02/2024-05-02T12:00:00Z-B62qc.json": []byte(`{"block_hash":"3NK1"}`),
+			"blocks/3NK1.dat": []byte("block1"),
+		},
+	}
+	if !reflect.DeepEqual(replayed, expected) {
+		t.Fatalf("unexpected objects replayed %v", replayed)
+	}
+
+	// Replayed objects are parsed by databases as saved by the submit handler
+	submission, err := objectToSaveToSubmission(replayed[0], log)
+	if err != nil || submission.Submitter != "B62qa" || string(submission.RawBlock) != "block2" || submission.SubmittedAtDate != "2024-05-02" {
+		t.Fatalf("unexpected submission %+v, error: %v", submission, err)
+	}
+
+	// Bounds set to times select submissions by time of submission
+	var paths []string
+	result, err = ReplaySubmissions(ctx, source, ReplayFilter{Since: "2024-05-02T11:00:00Z", Until: "2024-05-03T09:00:00+02:00"}, func(objs ObjectsToSave) StorageOutcomes {
+		for path := range objs {
+			if strings.HasPrefix(path, SUBMISSIONS_PREFIX) {
+				paths = append(paths, path)
+			}
+		}
+		return nil
+	}, log)
+	if err != nil || result.Submissions != 2 {
+		t.Fatalf("unexpected result %+v, error: %v", result, err)
+	}
+	if !reflect.DeepEqual(paths, []string{
+		"submissions/2024-05-02/2024-05-02T11:00:00Z-B62qb.json",
+		"submissions/2024-05-02/2024-05-02T12:00:00Z-B62qc.json",
+	}) {
+		t.Fatalf("unexpected submissions replayed %v", paths)
+	}
+
+	if _, err := ReplaySubmissions(ctx, LocalFileSystemSource{Directory: t.TempDir()}, ReplayFilter{}, func(ObjectsToSave) StorageOutcomes { return nil }, log); err == nil {
+		t.Fatal("expected an error replaying a directory without submissions")
+	}
+}
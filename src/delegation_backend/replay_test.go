@@ -0,0 +1,156 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestReplayGuardCommit(t *testing.T) {
+	g, _ := NewReplayGuard("")
+	pk1 := mkPk()
+	pk2 := mkPk()
+	t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if !g.Check(pk1, t0) || !g.Check(pk1, t0) {
+		t.Fatal("checked submission recorded")
+	}
+	if !g.Commit(pk1, t0) {
+		t.Fatal("first submission rejected")
+	}
+	if g.Check(pk1, t0) || !g.Check(pk1, t0.Add(time.Second)) {
+		t.Fatal("unexpected check of a committed submission")
+	}
+	if g.Commit(pk1, t0) {
+		t.Fatal("replayed submission accepted")
+	}
+	if g.Commit(pk1, t0.Add(500*time.Millisecond)) {
+		t.Fatal("submission with altered sub-second part accepted")
+	}
+	if g.Commit(pk1, t0.Add(-time.Minute)) {
+		t.Fatal("older submission accepted")
+	}
+	if !g.Commit(pk2, t0.Add(-time.Minute)) {
+		t.Fatal("submission of other submitter rejected")
+	}
+	if !g.Commit(pk1, t0.Add(time.Second)) {
+		t.Fatal("newer submission rejected")
+	}
+}
+
+func TestReplayGuardPersist(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "replay", "state.json")
+	g, err := NewReplayGuard(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := mkPk()
+	t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	g.Commit(pk, t0)
+	if err := g.Persist(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewReplayGuard(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest, exists := restored.Latest(pk); !exists || !latest.Equal(t0) {
+		t.Fatalf("state not restored: %v %v", latest, exists)
+	}
+	if restored.Commit(pk, t0) {
+		t.Fatal("replay accepted after restart")
+	}
+}
+
+func TestReplayGuardPersistLoop(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	g, _ := NewReplayGuard(stateFile)
+	g.Commit(mkPk(), time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		g.PersistLoop(ctx, time.Millisecond, logging.Logger("test"))
+		close(stopped)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if _, err := os.Stat(stateFile); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("state not persisted by the loop")
+		}
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop not stopped with its context")
+	}
+}
+
+func TestSubmitReplay(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.SubmitCounter = NewAttemptCounter(10)
+	sh.app.ReplayGuard, _ = NewReplayGuard("")
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("unexpected failure: %v", rep)
+	}
+	if rep := sh.testRequest(body); rep.Code != 409 {
+		t.Fatalf("expected replay to be rejected: %v", rep)
+	}
+}
+
+func TestSubmitRetriedAfterPersistFailure(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.SubmitCounter = NewAttemptCounter(10)
+	sh.app.ReplayGuard, _ = NewReplayGuard("")
+	sh.app.Idempotency = NewIdempotencyStore(time.Hour, 10)
+	sh.app.SubmitTimeout = 50 * time.Millisecond
+	slow := true
+	sh.app.Save = func(ctx context.Context, objs ObjectsToSave) StorageOutcomes {
+		return SaveToBackends(ctx, objs, map[string]func(context.Context, ObjectsToSave) error{
+			"s3": func(ctx context.Context, _ ObjectsToSave) error {
+				if slow {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				return nil
+			},
+		}, false, false)
+	}
+	submit := func() *httptest.ResponseRecorder {
+		rep := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", v1Submit, bytes.NewReader(body))
+		r.Header.Set(IDEMPOTENCY_KEY_HEADER, "retry-1")
+		sh.ServeHTTP(rep, r)
+		return rep
+	}
+	if rep := submit(); rep.Code != 504 {
+		t.Fatalf("expected the deadline exceeded, got %d %s", rep.Code, rep.Body.String())
+	}
+	if _, exists := sh.app.ReplayGuard.Latest(req.Submitter); exists {
+		t.Fatal("created_at of a submission not saved committed")
+	}
+	slow = false
+	if rep := submit(); rep.Code != 200 {
+		t.Fatalf("expected the retry accepted, got %d %s", rep.Code, rep.Body.String())
+	}
+	if rep := sh.testRequest(body); rep.Code != 409 {
+		t.Fatalf("expected a replay of the saved submission rejected, got %d", rep.Code)
+	}
+}
@@ -0,0 +1,235 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Storage prefix under which daily reports are saved
+const REPORTS_PREFIX = "reports/"
+
+// Interval of writing reports of completed days and persisting the state
+const REPORTS_CHECK_INTERVAL = 5 * time.Minute
+
+// Number of top error producers listed in a report
+const REPORTS_TOP_ERROR_PRODUCERS = 10
+
+// Counters of a day, accumulated from audit records
+type reportDay struct {
+	Accepted int64            `json:"accepted"`
+	Rejected map[string]int64 `json:"rejected"`
+	// Submitters with an accepted submission
+	Submitters map[string]bool `json:"submitters"`
+	// Rejections by submitter, or by remote host if the submitter is unknown
+	ErrorProducers map[string]int64 `json:"error_producers"`
+	Blocks         map[string]bool  `json:"blocks"`
+	BodyBytes      int64            `json:"body_bytes"`
+	BlockBytes     int64            `json:"block_bytes"`
+}
+
+func newReportDay() *reportDay {
+	return &reportDay{
+		Rejected:       make(map[string]int64),
+		Submitters:     make(map[string]bool),
+		ErrorProducers: make(map[string]int64),
+		Blocks:         make(map[string]bool),
+	}
+}
+
+// ErrorProducer is a submitter or a remote host with rejected submissions
+type ErrorProducer struct {
+	Producer string `json:"producer"`
+	Rejected int64  `json:"rejected"`
+}
+
+// DailyReport summarizes the ingestion of a network over a UTC day
+type DailyReport struct {
+	Network           string           `json:"network"`
+	Date              string           `json:"date"`
+	GeneratedAt       time.Time        `json:"generated_at"`
+	UniqueSubmitters  int              `json:"unique_submitters"`
+	Accepted          int64            `json:"accepted"`
+	Rejected          int64            `json:"rejected"`
+	RejectedByReason  map[string]int64 `json:"rejected_by_reason"`
+	DistinctBlocks    int              `json:"distinct_blocks"`
+	BodyBytes         int64            `json:"body_bytes"`
+	BlockBytes        int64            `json:"block_bytes"`
+	TopErrorProducers []ErrorProducer  `json:"top_error_producers"`
+}
+
+// CSV of the report, as rows of section, key and value
+func (r *DailyReport) CSV() []byte {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	_ = w.Write([]string{"section", "key", "value"})
+	for _, row := range [][2]string{
+		{"network", r.Network},
+		{"date", r.Date},
+		{"generated_at", r.GeneratedAt.UTC().Format(time.RFC3339)},
+		{"unique_submitters", strconv.Itoa(r.UniqueSubmitters)},
+		{"accepted", strconv.FormatInt(r.Accepted, 10)},
+		{"rejected", strconv.FormatInt(r.Rejected, 10)},
+		{"distinct_blocks", strconv.Itoa(r.DistinctBlocks)},
+		{"body_bytes", strconv.FormatInt(r.BodyBytes, 10)},
+		{"block_bytes", strconv.FormatInt(r.BlockBytes, 10)},
+	} {
+		_ = w.Write([]string{"summary", row[0], row[1]})
+	}
+	reasons := make([]string, 0, len(r.RejectedByReason))
+	for reason := range r.RejectedByReason {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		_ = w.Write([]string{"rejected_by_reason", reason, strconv.FormatInt(r.RejectedByReason[reason], 10)})
+	}
+	for _, p := range r.TopErrorProducers {
+		_ = w.Write([]string{"top_error_producers", p.Producer, strconv.FormatInt(p.Rejected, 10)})
+	}
+	w.Flush()
+	return b.Bytes()
+}
+
+// DailyReports accumulates counters of every UTC day from audit records,
+// and writes the summary of each completed day to storage as
+// `reports/<date>/summary.json` and `reports/<date>/summary.csv`.
+// Counters of days not yet reported are persisted to the state file
+// (restored on start), if one is configured.
+type DailyReports struct {
+	mutex     sync.Mutex
+	network   string
+	days      map[string]*reportDay
+	stateFile string
+	now       nowFunc
+}
+
+// NewDailyReports creates the reports, restoring counters from stateFile
+// if the file exists. Empty stateFile keeps the counters in memory only.
+func NewDailyReports(network string, stateFile string, now nowFunc) (*DailyReports, error) {
+	r := &DailyReports{network: network, days: make(map[string]*reportDay), stateFile: stateFile, now: now}
+	if stateFile == "" {
+		return r, nil
+	}
+	bs, err := os.ReadFile(stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading daily report state: %w", err)
+	}
+	if err := json.Unmarshal(bs, &r.days); err != nil {
+		return nil, fmt.Errorf("error decoding daily report state: %w", err)
+	}
+	return r, nil
+}
+
+// Record counts the submission attempt described by the audit record
+func (r *DailyReports) Record(rec *AuditRecord) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	date := statsDay(rec.Time)
+	day, exists := r.days[date]
+	if !exists {
+		day = newReportDay()
+		r.days[date] = day
+	}
+	day.BodyBytes += rec.BodySize
+	if rec.Result == AUDIT_RESULT_ACCEPTED {
+		day.Accepted++
+		day.Submitters[rec.Submitter] = true
+		day.Blocks[rec.BlockHash] = true
+		day.BlockBytes += int64(rec.BlockSize)
+		return
+	}
+	day.Rejected[rejectionReason(rec.Reason)]++
+	producer := rec.Submitter
+	if producer == "" {
+		producer = hostOf(rec.RemoteAddr)
+	}
+	day.ErrorProducers[producer]++
+}
+
+func (r *DailyReports) report(date string, day *reportDay) *DailyReport {
+	report := &DailyReport{
+		Network:           r.network,
+		Date:              date,
+		GeneratedAt:       r.now(),
+		UniqueSubmitters:  len(day.Submitters),
+		Accepted:          day.Accepted,
+		RejectedByReason:  day.Rejected,
+		DistinctBlocks:    len(day.Blocks),
+		BodyBytes:         day.BodyBytes,
+		BlockBytes:        day.BlockBytes,
+		TopErrorProducers: []ErrorProducer{},
+	}
+	for _, n := range day.Rejected {
+		report.Rejected += n
+	}
+	for producer, n := range day.ErrorProducers {
+		report.TopErrorProducers = append(report.TopErrorProducers, ErrorProducer{producer, n})
+	}
+	sort.Slice(report.TopErrorProducers, func(i, j int) bool {
+		a, b := report.TopErrorProducers[i], report.TopErrorProducers[j]
+		return a.Rejected > b.Rejected || (a.Rejected == b.Rejected && a.Producer < b.Producer)
+	})
+	if len(report.TopErrorProducers) > REPORTS_TOP_ERROR_PRODUCERS {
+		report.TopErrorProducers = report.TopErrorProducers[:REPORTS_TOP_ERROR_PRODUCERS]
+	}
+	return report
+}
+
+// WriteCompleted saves reports of all days before today and drops
+// their counters, then persists counters of the days left.
+func (r *DailyReports) WriteCompleted(save func(ObjectsToSave)) error {
+	today := statsDay(r.now())
+	objs := make(ObjectsToSave)
+	r.mutex.Lock()
+	for date, day := range r.days {
+		if date >= today {
+			continue
+		}
+		report := r.report(date, day)
+		bs, err := json.Marshal(report)
+		if err != nil {
+			r.mutex.Unlock()
+			return fmt.Errorf("error encoding daily report: %w", err)
+		}
+		objs[REPORTS_PREFIX+date+"/summary.json"] = bs
+		objs[REPORTS_PREFIX+date+"/summary.csv"] = report.CSV()
+		delete(r.days, date)
+	}
+	state, err := json.Marshal(r.days)
+	r.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding daily report state: %w", err)
+	}
+	if len(objs) > 0 {
+		save(objs)
+	}
+	if r.stateFile != "" {
+		if err := writeFileAtomically(r.stateFile, state); err != nil {
+			return fmt.Errorf("error writing daily report state: %w", err)
+		}
+	}
+	return nil
+}
+
+// Periodically write reports of completed days.
+func (r *DailyReports) WriteLoop(interval time.Duration, save func(ObjectsToSave), log logging.StandardLogger) {
+	for {
+		time.Sleep(interval)
+		if err := r.WriteCompleted(save); err != nil {
+			log.Errorf("Failed to write daily reports: %v", err)
+		}
+	}
+}
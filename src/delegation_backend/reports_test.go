@@ -0,0 +1,74 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDailyReports(t *testing.T) {
+	tm := &timeMock{time: time.Date(2024, 5, 10, 23, 0, 0, 0, time.UTC)}
+	stateFile := filepath.Join(t.TempDir(), "reports.json")
+	reports, err := NewDailyReports("mainnet", stateFile, tm.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range []AuditRecord{
+		{Submitter: "B62qa", Result: AUDIT_RESULT_ACCEPTED, BlockHash: "3NKa", BodySize: 100, BlockSize: 60},
+		{Submitter: "B62qb", Result: AUDIT_RESULT_ACCEPTED, BlockHash: "3NKa", BodySize: 100, BlockSize: 60},
+		{Submitter: "B62qb", Result: AUDIT_RESULT_ACCEPTED, BlockHash: "3NKb", BodySize: 100, BlockSize: 60},
+		{Submitter: "B62qc", Result: AUDIT_RESULT_REJECTED, Reason: "Delegation is not valid: expired", BodySize: 10},
+		{Submitter: "B62qc", Result: AUDIT_RESULT_REJECTED, Reason: "Invalid signature", BodySize: 10},
+		{RemoteAddr: "192.0.2.1:1234", Result: AUDIT_RESULT_REJECTED, Reason: "Error decoding payload", BodySize: 10},
+	} {
+		rec.Time = tm.time
+		reports.Record(&rec)
+	}
+
+	saved := make(ObjectsToSave)
+	save := func(objs ObjectsToSave) {
+		for path, bs := range objs {
+			saved[path] = bs
+		}
+	}
+	if err := reports.WriteCompleted(save); err != nil || len(saved) != 0 {
+		t.Fatalf("expected no report of the current day, got %v, %v", saved, err)
+	}
+
+	// Counters are restored after a restart
+	reports, err = NewDailyReports("mainnet", stateFile, tm.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.time = tm.time.Add(2 * time.Hour)
+	if err := reports.WriteCompleted(save); err != nil {
+		t.Fatal(err)
+	}
+	var report DailyReport
+	if err := json.Unmarshal(saved["reports/2024-05-10/summary.json"], &report); err != nil {
+		t.Fatalf("report not saved: %v", err)
+	}
+	if report.Network != "mainnet" || report.UniqueSubmitters != 2 || report.Accepted != 3 || report.Rejected != 3 ||
+		report.DistinctBlocks != 2 || report.BodyBytes != 330 || report.BlockBytes != 180 ||
+		report.RejectedByReason["Delegation is not valid"] != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(report.TopErrorProducers) != 2 || report.TopErrorProducers[0] != (ErrorProducer{"B62qc", 2}) ||
+		report.TopErrorProducers[1] != (ErrorProducer{"192.0.2.1", 1}) {
+		t.Fatalf("unexpected top error producers: %+v", report.TopErrorProducers)
+	}
+	csv := string(saved["reports/2024-05-10/summary.csv"])
+	for _, row := range []string{"section,key,value\n", "summary,accepted,3\n", "rejected_by_reason,Invalid signature,1\n", "top_error_producers,B62qc,2\n"} {
+		if !strings.Contains(csv, row) {
+			t.Fatalf("row %q missing from CSV:\n%s", row, csv)
+		}
+	}
+
+	// Reported days are dropped
+	saved = make(ObjectsToSave)
+	if err := reports.WriteCompleted(save); err != nil || len(saved) != 0 {
+		t.Fatalf("expected the report written once, got %v, %v", saved, err)
+	}
+}
@@ -0,0 +1,535 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gocql/gocql"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+const RETENTION_DEFAULT_INTERVAL = 24 * time.Hour
+
+// Days before the cutoff whose Keyspaces partitions are deleted, as
+// Keyspaces can't list the days stored
+const RETENTION_KEYSPACES_DEFAULT_LOOKBACK_DAYS = 7
+
+// Shards of a day of the Keyspaces submissions table, see calculateShard
+const KEYSPACES_SHARDS_PER_DAY = 24 * 3600 / 144
+
+// Keys deleted by a single S3 request, the most S3 accepts
+const S3_DELETE_BATCH_SIZE = 1000
+
+// RetentionReport is the outcome of a cleanup of a backend, objects
+// deleted (or archived) are counted by top-level prefix. Rows of the
+// databases are counted under `submissions/`.
+type RetentionReport struct {
+	Backend    string                `json:"backend"`
+	Cutoff     time.Time             `json:"cutoff"`
+	DryRun     bool                  `json:"dry_run,omitempty"`
+	StartedAt  time.Time             `json:"started_at"`
+	DurationMs float64               `json:"duration_ms"`
+	Error      string                `json:"error,omitempty"`
+	Deleted    map[string]UsageCount `json:"deleted"`
+	// Blocks referenced by no submission, counted in Deleted as well
+	OrphanedBlocks UsageCount `json:"orphaned_blocks"`
+}
+
+func newRetentionReport(backend string, cutoff time.Time, dryRun bool) *RetentionReport {
+	return &RetentionReport{Backend: backend, Cutoff: cutoff, DryRun: dryRun, Deleted: make(map[string]UsageCount)}
+}
+
+// Count objects deleted under the path, relative to the root of the storage
+func (r *RetentionReport) add(path string, objects int64, bytes int64) {
+	prefix := ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		prefix = path[:i+1]
+	}
+	c := r.Deleted[prefix]
+	r.Deleted[prefix] = UsageCount{Objects: c.Objects + objects, Bytes: c.Bytes + bytes}
+}
+
+// Total of objects deleted under all prefixes
+func (r *RetentionReport) Total() UsageCount {
+	var total UsageCount
+	for _, c := range r.Deleted {
+		total.Objects += c.Objects
+		total.Bytes += c.Bytes
+	}
+	return total
+}
+
+// Cleaner deletes (or archives) submissions older than the cutoff. Days
+// of submissions are deleted as a whole, the day of the cutoff is kept.
+// Blocks are deleted by the time they were saved: a block is saved with
+// the first submission referencing it, and submissions reference recent
+// blocks, so blocks saved before the day of the cutoff are only
+// referenced by submissions deleted as well. Deduplicated blocks keep the
+// time of their first submission though, they are better collected by a
+// BlockGC. In dry runs, what would be deleted is counted and passed to
+// listed, nothing is deleted.
+type Cleaner interface {
+	Cleanup(ctx context.Context, cutoff time.Time, dryRun bool, report *RetentionReport, listed func(path string)) error
+}
+
+// Day of submissions of a path `submissions/<day>/...`, if any
+func submissionDay(path string) (string, bool) {
+	if !strings.HasPrefix(path, SUBMISSIONS_PREFIX) || len(path) < len(SUBMISSIONS_PREFIX)+10 {
+		return "", false
+	}
+	day := path[len(SUBMISSIONS_PREFIX) : len(SUBMISSIONS_PREFIX)+10]
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		return "", false
+	}
+	return day, true
+}
+
+// Whether the object at path is older than the day of the cutoff,
+// indexes of days are deleted along with their submissions
+func expired(path string, modified time.Time, cutoff time.Time) bool {
+	cutoffDay := cutoff.UTC().Truncate(24 * time.Hour)
+	if day, ok := submissionDay(path); ok {
+		return day < cutoffDay.Format("2006-01-02")
+	}
+	if strings.HasPrefix(path, INDEX_PREFIX) && strings.HasSuffix(path, ".json") {
+		return strings.TrimSuffix(strings.TrimPrefix(path, INDEX_PREFIX), ".json") < cutoffDay.Format("2006-01-02")
+	}
+	return strings.HasPrefix(path, BLOCKS_PREFIX) && modified.Before(cutoffDay)
+}
+
+// Prefixes of the objects deleted by cleaners of object storages
+func cleanedPrefixes(skipBlocks bool) []string {
+	if skipBlocks {
+		return []string{SUBMISSIONS_PREFIX, INDEX_PREFIX}
+	}
+	return []string{SUBMISSIONS_PREFIX, INDEX_PREFIX, BLOCKS_PREFIX}
+}
+
+// S3Cleaner deletes objects of the bucket under the prefix of the
+// network. If ArchivePrefix is set, objects are copied under it in the
+// same bucket (with the storage class, if set) before being deleted.
+// With SkipBlocks, blocks are left to a BlockGC.
+type S3Cleaner struct {
+	Aws           *AwsContext
+	ArchivePrefix string
+	StorageClass  types.StorageClass
+	SkipBlocks    bool
+}
+
+func (c *S3Cleaner) Cleanup(ctx context.Context, cutoff time.Time, dryRun bool, report *RetentionReport, listed func(string)) error {
+	root := c.Aws.Prefix + "/"
+	for _, prefix := range cleanedPrefixes(c.SkipBlocks) {
+		paginator := s3.NewListObjectsV2Paginator(c.Aws.Client, &s3.ListObjectsV2Input{
+			Bucket: c.Aws.BucketName,
+			Prefix: aws.String(root + prefix),
+		})
+		var batch []types.ObjectIdentifier
+		var batchBytes int64
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			for _, obj := range page.Contents {
+				path := strings.TrimPrefix(aws.ToString(obj.Key), root)
+				if !expired(path, aws.ToTime(obj.LastModified), cutoff) {
+					continue
+				}
+				if dryRun {
+					report.add(path, 1, obj.Size)
+					listed(path)
+					continue
+				}
+				if err := c.archive(ctx, aws.ToString(obj.Key), path); err != nil {
+					return err
+				}
+				batch = append(batch, types.ObjectIdentifier{Key: obj.Key})
+				batchBytes += obj.Size
+			}
+			// Keys are deleted page by page, pages hold up to 1000 keys
+			if err := c.delete(ctx, batch); err != nil {
+				return err
+			}
+			if len(batch) > 0 {
+				report.add(prefix, int64(len(batch)), batchBytes)
+			}
+			batch, batchBytes = batch[:0], 0
+		}
+	}
+	return nil
+}
+
+func (c *S3Cleaner) archive(ctx context.Context, key string, path string) error {
+	if c.ArchivePrefix == "" {
+		return nil
+	}
+	_, err := c.Aws.Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:       c.Aws.BucketName,
+		CopySource:   aws.String(aws.ToString(c.Aws.BucketName) + "/" + key),
+		Key:          aws.String(strings.TrimSuffix(c.ArchivePrefix, "/") + "/" + c.Aws.Prefix + "/" + path),
+		StorageClass: c.StorageClass,
+	})
+	return err
+}
+
+func (c *S3Cleaner) delete(ctx context.Context, keys []types.ObjectIdentifier) error {
+	for len(keys) > 0 {
+		n := min(len(keys), S3_DELETE_BATCH_SIZE)
+		out, err := c.Aws.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: c.Aws.BucketName,
+			Delete: &types.Delete{Objects: keys[:n], Quiet: true},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return fmt.Errorf("failed to delete %d objects, e.g. %s: %s", len(out.Errors), aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// LocalFileSystemCleaner deletes files of local filesystem storage. If
+// ArchiveDirectory is set, files are moved there instead, keeping their
+// path relative to the storage directory. Both must be on the same
+// filesystem. With SkipBlocks, blocks are left to a BlockGC.
+type LocalFileSystemCleaner struct {
+	Directory        string
+	ArchiveDirectory string
+	SkipBlocks       bool
+}
+
+func (c LocalFileSystemCleaner) Cleanup(ctx context.Context, cutoff time.Time, dryRun bool, report *RetentionReport, listed func(string)) error {
+	for _, prefix := range cleanedPrefixes(c.SkipBlocks) {
+		err := filepath.WalkDir(filepath.Join(c.Directory, prefix), func(path string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil || d.IsDir() {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(c.Directory, path)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if !expired(rel, info.ModTime(), cutoff) {
+				return nil
+			}
+			if dryRun {
+				listed(rel)
+			} else if err := c.remove(path, rel); err != nil {
+				return err
+			}
+			report.add(rel, 1, info.Size())
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !dryRun {
+		return removeEmptyDays(filepath.Join(c.Directory, SUBMISSIONS_PREFIX))
+	}
+	return nil
+}
+
+func (c LocalFileSystemCleaner) remove(path string, rel string) error {
+	if c.ArchiveDirectory == "" {
+		return os.Remove(path)
+	}
+	target := filepath.Join(c.ArchiveDirectory, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.Rename(path, target)
+}
+
+// Remove directories of days left without submissions
+func removeEmptyDays(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		day := filepath.Join(dir, entry.Name())
+		if files, err := os.ReadDir(day); err == nil && len(files) == 0 {
+			if err := os.Remove(day); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Cleanup deletes rows of the submissions table of days before the
+// cutoff, counting them and their size beforehand
+func (ctx *PostgreSQLContext) Cleanup(c context.Context, cutoff time.Time, dryRun bool, report *RetentionReport, listed func(string)) error {
+	day := cutoff.UTC().Format("2006-01-02")
+	rows, err := ctx.DB.QueryContext(c, `SELECT submitted_at_date, COUNT(*), COALESCE(SUM(pg_column_size(s.*)), 0)
+		FROM submissions s WHERE submitted_at_date < $1 GROUP BY submitted_at_date ORDER BY submitted_at_date`, day)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d time.Time
+		var count, bytes int64
+		if err := rows.Scan(&d, &count, &bytes); err != nil {
+			return err
+		}
+		report.add(SUBMISSIONS_PREFIX, count, bytes)
+		if dryRun {
+			listed(SUBMISSIONS_PREFIX + d.Format("2006-01-02") + "/")
+		}
+	}
+	if err := rows.Err(); err != nil || dryRun {
+		return err
+	}
+	_, err = ctx.DB.ExecContext(c, "DELETE FROM submissions WHERE submitted_at_date < $1", day)
+	return err
+}
+
+// KeyspacesCleaner deletes partitions of the submissions table of the
+// days before the cutoff, up to LookbackDays back, as Keyspaces can't
+// list the days stored. Rows aren't counted, partitions deleted are.
+type KeyspacesCleaner struct {
+	Keyspaces    *KeyspaceContext
+	LookbackDays int
+}
+
+func (c *KeyspacesCleaner) Cleanup(ctx context.Context, cutoff time.Time, dryRun bool, report *RetentionReport, listed func(string)) error {
+	lookback := c.LookbackDays
+	if lookback <= 0 {
+		lookback = RETENTION_KEYSPACES_DEFAULT_LOOKBACK_DAYS
+	}
+	query := "DELETE FROM " + c.Keyspaces.Keyspace + ".submissions WHERE submitted_at_date = ? AND shard = ?"
+	for i := lookback; i > 0; i-- {
+		day := cutoff.UTC().AddDate(0, 0, -i).Format("2006-01-02")
+		if dryRun {
+			listed(SUBMISSIONS_PREFIX + day + "/")
+			report.add(SUBMISSIONS_PREFIX, KEYSPACES_SHARDS_PER_DAY, 0)
+			continue
+		}
+		for shard := 0; shard < KEYSPACES_SHARDS_PER_DAY; shard++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			err := c.Keyspaces.Session.Run(func(session *gocql.Session) error {
+				return session.Query(query, day, shard).WithContext(ctx).Exec()
+			})
+			if err != nil {
+				return fmt.Errorf("failed to delete submissions of %s: %w", day, err)
+			}
+			report.add(SUBMISSIONS_PREFIX, 1, 0)
+		}
+	}
+	return nil
+}
+
+// Policies by name of the backend (s3, keyspaces, postgresql, filesystem)
+func (cfg *RetentionConfig) Policies() map[string]*RetentionPolicy {
+	policies := make(map[string]*RetentionPolicy)
+	for name, policy := range map[string]*RetentionPolicy{
+		"s3":         cfg.S3,
+		"keyspaces":  cfg.AwsKeyspaces,
+		"postgresql": cfg.PostgreSQL,
+		"filesystem": cfg.LocalFileSystem,
+	} {
+		if policy != nil {
+			policies[name] = policy
+		}
+	}
+	return policies
+}
+
+func (policy *RetentionPolicy) MaxAge() time.Duration {
+	return time.Duration(policy.MaxAgeDays) * 24 * time.Hour
+}
+
+// Grace period of the collection of orphaned blocks
+func (cfg *RetentionConfig) OrphanedBlocksGrace() time.Duration {
+	if cfg.OrphanedBlocksGraceHours > 0 {
+		return time.Duration(cfg.OrphanedBlocksGraceHours) * time.Hour
+	}
+	return BLOCK_GC_DEFAULT_GRACE
+}
+
+// Interval between cleanups of the leader
+func (cfg *RetentionConfig) Interval() time.Duration {
+	if cfg.IntervalHours > 0 {
+		return time.Duration(cfg.IntervalHours) * time.Hour
+	}
+	return RETENTION_DEFAULT_INTERVAL
+}
+
+// RetentionJob periodically cleans up backends, each with its own
+// maximum age, and keeps the totals deleted for metrics.
+type RetentionJob struct {
+	mutex    sync.Mutex
+	cleaners map[string]Cleaner
+	maxAge   map[string]time.Duration
+	blockGCs map[string]*BlockGC
+	latest   map[string]*RetentionReport
+	totals   map[string]map[string]UsageCount
+	now      nowFunc
+	log      logging.StandardLogger
+}
+
+func NewRetentionJob(now nowFunc, log logging.StandardLogger) *RetentionJob {
+	return &RetentionJob{
+		cleaners: make(map[string]Cleaner),
+		maxAge:   make(map[string]time.Duration),
+		blockGCs: make(map[string]*BlockGC),
+		latest:   make(map[string]*RetentionReport),
+		totals:   make(map[string]map[string]UsageCount),
+		now:      now,
+		log:      log,
+	}
+}
+
+// Add a backend, deleting what is older than maxAge
+func (j *RetentionJob) Add(backend string, cleaner Cleaner, maxAge time.Duration) {
+	j.cleaners[backend] = cleaner
+	j.maxAge[backend] = maxAge
+}
+
+// Collect orphaned blocks of a backend after each of its cleanups
+func (j *RetentionJob) AddBlockGC(backend string, gc *BlockGC) {
+	j.blockGCs[backend] = gc
+}
+
+// Run cleans up all backends, one after another, returning their reports
+// sorted by backend. Deleted paths are passed to listed in dry runs.
+func (j *RetentionJob) Run(ctx context.Context, dryRun bool, listed func(backend string, path string)) []RetentionReport {
+	backends := make([]string, 0, len(j.cleaners))
+	for backend := range j.cleaners {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	reports := make([]RetentionReport, 0, len(backends))
+	for _, backend := range backends {
+		startedAt := j.now()
+		report := newRetentionReport(backend, startedAt.Add(-j.maxAge[backend]), dryRun)
+		report.StartedAt = startedAt
+		listedPath := func(path string) {
+			if listed != nil {
+				listed(backend, path)
+			}
+		}
+		err := j.cleaners[backend].Cleanup(ctx, report.Cutoff, dryRun, report, listedPath)
+		if gc := j.blockGCs[backend]; gc != nil && err == nil {
+			err = gc.Collect(ctx, startedAt, dryRun, report, listedPath)
+		}
+		if err != nil {
+			incMetric("retention_errors")
+			j.log.Errorf("Failed to clean up %s storage: %v", backend, err)
+			report.Error = err.Error()
+		}
+		report.DurationMs = float64(j.now().Sub(startedAt).Microseconds()) / 1000
+		total := report.Total()
+		if !dryRun {
+			j.log.Infof("Cleaned up %s storage before %s: %d objects, %d bytes", backend, report.Cutoff.Format(time.RFC3339), total.Objects, total.Bytes)
+		}
+		j.record(report)
+		reports = append(reports, *report)
+	}
+	return reports
+}
+
+func (j *RetentionJob) record(report *RetentionReport) {
+	if report.DryRun {
+		return
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.latest[report.Backend] = report
+	totals := j.totals[report.Backend]
+	if totals == nil {
+		totals = make(map[string]UsageCount)
+		j.totals[report.Backend] = totals
+	}
+	for prefix, c := range report.Deleted {
+		t := totals[prefix]
+		totals[prefix] = UsageCount{Objects: t.Objects + c.Objects, Bytes: t.Bytes + c.Bytes}
+	}
+}
+
+// Clean up now and then every interval, while leader. Replicas which
+// aren't the leader skip the cleanup.
+func (j *RetentionJob) RunLoop(ctx context.Context, interval time.Duration, election *LeaderElection) {
+	for {
+		if election.IsLeader() {
+			j.Run(ctx, false, nil)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// WritePrometheus writes the totals deleted since the start, by backend
+// and top-level prefix
+func (j *RetentionJob) WritePrometheus(w io.Writer) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	backends := make([]string, 0, len(j.totals))
+	for backend := range j.totals {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	for _, metric := range []struct {
+		name, help string
+		value      func(UsageCount) int64
+	}{
+		{"uptime_retention_deleted_objects_total", "Objects (or rows) deleted by the retention cleanup, by backend and top-level prefix.", func(c UsageCount) int64 { return c.Objects }},
+		{"uptime_retention_reclaimed_bytes_total", "Bytes reclaimed by the retention cleanup, by backend and top-level prefix.", func(c UsageCount) int64 { return c.Bytes }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name)
+		for _, backend := range backends {
+			prefixes := make([]string, 0, len(j.totals[backend]))
+			for prefix := range j.totals[backend] {
+				prefixes = append(prefixes, prefix)
+			}
+			sort.Strings(prefixes)
+			for _, prefix := range prefixes {
+				fmt.Fprintf(w, "%s{backend=\"%s\",prefix=\"%s\"} %d\n", metric.name,
+					escapePrometheusLabel(backend), escapePrometheusLabel(prefix), metric.value(j.totals[backend][prefix]))
+			}
+		}
+	}
+	fmt.Fprint(w, "# HELP uptime_retention_cleanup_timestamp_seconds Time of the latest retention cleanup.\n")
+	fmt.Fprint(w, "# TYPE uptime_retention_cleanup_timestamp_seconds gauge\n")
+	for _, backend := range backends {
+		fmt.Fprintf(w, "uptime_retention_cleanup_timestamp_seconds{backend=\"%s\"} %d\n", escapePrometheusLabel(backend), j.latest[backend].StartedAt.Unix())
+	}
+}
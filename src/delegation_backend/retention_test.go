@@ -0,0 +1,130 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+type failingCleaner struct{}
+
+func (failingCleaner) Cleanup(context.Context, time.Time, bool, *RetentionReport, func(string)) error {
+	return errors.New("access denied")
+}
+
+// Local filesystem storage with submissions of two days and two blocks,
+// the first block saved a day before the second
+func retentionTestStorage(t *testing.T) string {
+	dir := t.TempDir()
+	if err := LocalFileSystemSave(ObjectsToSave{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": []byte("12345"),
+		"submissions/2024-05-01/2024-05-01T11:00:00Z-B62qb.json": []byte("123"),
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json": []byte("1"),
+		"blocks/3NKa.dat":       []byte("1234567890"),
+		"blocks/3NKb.dat":       []byte("12345"),
+		"stats/2024-05-01.json": []byte("{}"),
+	}, dir, logging.Logger("test")); err != nil {
+		t.Fatal(err)
+	}
+	for name, day := range map[string]int{"3NKa.dat": 1, "3NKb.dat": 2} {
+		saved := time.Date(2024, 5, day, 10, 0, 0, 0, time.UTC)
+		if err := os.Chtimes(filepath.Join(dir, "blocks", name), saved, saved); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLocalFileSystemCleanup(t *testing.T) {
+	dir := retentionTestStorage(t)
+	cutoff := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	cleaner := LocalFileSystemCleaner{Directory: dir}
+
+	report := newRetentionReport("filesystem", cutoff, true)
+	var listed []string
+	if err := cleaner.Cleanup(context.Background(), cutoff, true, report, func(path string) { listed = append(listed, path) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 3 || report.Deleted["submissions/"] != (UsageCount{2, 8}) || report.Deleted["blocks/"] != (UsageCount{1, 10}) {
+		t.Fatalf("unexpected dry run: %v %+v", listed, report.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blocks", "3NKa.dat")); err != nil {
+		t.Fatal("expected nothing deleted by a dry run")
+	}
+
+	report = newRetentionReport("filesystem", cutoff, false)
+	if err := cleaner.Cleanup(context.Background(), cutoff, false, report, nil); err != nil {
+		t.Fatal(err)
+	}
+	if report.Total() != (UsageCount{3, 18}) {
+		t.Fatalf("unexpected report: %+v", report.Deleted)
+	}
+	for path, kept := range map[string]bool{
+		"submissions/2024-05-01": false,
+		"blocks/3NKa.dat":        false,
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json": true,
+		"blocks/3NKb.dat":       true,
+		"stats/2024-05-01.json": true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, path)); (err == nil) != kept {
+			t.Errorf("expected %s kept: %v", path, kept)
+		}
+	}
+}
+
+func TestLocalFileSystemCleanupArchive(t *testing.T) {
+	dir := retentionTestStorage(t)
+	archive := t.TempDir()
+	cutoff := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	report := newRetentionReport("filesystem", cutoff, false)
+	if err := (LocalFileSystemCleaner{Directory: dir, ArchiveDirectory: archive}).Cleanup(context.Background(), cutoff, false, report, nil); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := os.ReadFile(filepath.Join(archive, "submissions", "2024-05-01", "2024-05-01T10:00:00Z-B62qa.json"))
+	if err != nil || string(bs) != "12345" {
+		t.Fatalf("expected the submission moved to the archive: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(archive, "blocks", "3NKa.dat")); err != nil {
+		t.Fatal("expected the block moved to the archive")
+	}
+}
+
+func TestRetentionJob(t *testing.T) {
+	dir := retentionTestStorage(t)
+	tm := &timeMock{time: time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)}
+	job := NewRetentionJob(tm.Now, logging.Logger("test"))
+	job.Add("filesystem", LocalFileSystemCleaner{Directory: dir}, 48*time.Hour)
+	job.Add("s3", failingCleaner{}, 48*time.Hour)
+
+	reports := job.Run(context.Background(), false, nil)
+	if len(reports) != 2 || reports[0].Backend != "filesystem" || reports[1].Error != "access denied" {
+		t.Fatalf("unexpected reports: %+v", reports)
+	}
+	if cutoff := reports[0].Cutoff; !cutoff.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected cutoff %v", cutoff)
+	}
+	// The day of the cutoff is kept, so is the block saved on it
+	if reports[0].Total() != (UsageCount{0, 0}) {
+		t.Fatalf("unexpected report: %+v", reports[0].Deleted)
+	}
+	tm.time = tm.time.Add(24 * time.Hour)
+	job.Run(context.Background(), false, nil)
+
+	var metrics strings.Builder
+	job.WritePrometheus(&metrics)
+	for _, line := range []string{
+		`uptime_retention_deleted_objects_total{backend="filesystem",prefix="submissions/"} 2`,
+		`uptime_retention_reclaimed_bytes_total{backend="filesystem",prefix="blocks/"} 10`,
+		`uptime_retention_cleanup_timestamp_seconds{backend="filesystem"} ` + "1714824000",
+	} {
+		if !strings.Contains(metrics.String(), line+"\n") {
+			t.Errorf("expected %s in:\n%s", line, metrics.String())
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Storage prefix under which snapshots of uptime scores are saved
+const SCORES_PREFIX = "scores/"
+
+// Snapshot of the latest run, replaced by every run
+const SCORES_LATEST_PATH = SCORES_PREFIX + "latest.json"
+
+const (
+	SCORING_DEFAULT_INTERVAL = time.Hour
+	// Sliding window of scores, in days
+	SCORING_DEFAULT_WINDOW_DAYS = 90
+	// A submitter is up over an interval if it submitted during it
+	SCORING_DEFAULT_SUBMISSION_INTERVAL = 15 * time.Minute
+)
+
+// Latest snapshot of each day, replaced by every run of the day
+const SCORES_DAILY_PREFIX = SCORES_PREFIX + "daily/"
+
+// The latest snapshot is read again from storage after this delay
+const SCORES_CACHE_TTL = time.Minute
+
+// ScoreSnapshot of the uptime of submitters, saved as
+// `scores/<day>/<time>.json`, `scores/daily/<day>.json` and
+// `scores/latest.json`
+type ScoreSnapshot struct {
+	ComputedAt time.Time     `json:"computed_at"`
+	Windows    []ScoreWindow `json:"windows"`
+}
+
+// Scores of the submitters over a sliding window ending at the time of the
+// snapshot
+type ScoreWindow struct {
+	Days  int       `json:"days"`
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+	// Submission intervals of the window
+	Intervals int `json:"intervals"`
+	// Scores by submitter, of submitters with a submission in the window
+	Scores map[string]SubmitterScore `json:"scores"`
+}
+
+type SubmitterScore struct {
+	// Submission intervals of the window with a submission
+	Intervals int `json:"intervals"`
+	// Percentage of the submission intervals of the window with a submission
+	Uptime float64 `json:"uptime"`
+}
+
+// Interval between runs of the scorer
+func (cfg *ScoringConfig) Interval() time.Duration {
+	if cfg.IntervalMinutes > 0 {
+		return time.Duration(cfg.IntervalMinutes) * time.Minute
+	}
+	return SCORING_DEFAULT_INTERVAL
+}
+
+// Windows of scores in days
+func (cfg *ScoringConfig) Windows() []int {
+	if len(cfg.WindowDays) > 0 {
+		return cfg.WindowDays
+	}
+	return []int{SCORING_DEFAULT_WINDOW_DAYS}
+}
+
+func (cfg *ScoringConfig) SubmissionInterval() time.Duration {
+	if cfg.SubmissionIntervalMinutes > 0 {
+		return time.Duration(cfg.SubmissionIntervalMinutes) * time.Minute
+	}
+	return SCORING_DEFAULT_SUBMISSION_INTERVAL
+}
+
+// Path of a snapshot, relative to the root of the storage
+func ScoresPath(computedAt time.Time) string {
+	return SCORES_PREFIX + computedAt.UTC().Format("2006-01-02") + "/" + computedAt.UTC().Format(time.RFC3339) + ".json"
+}
+
+// Path of the latest snapshot of the day, relative to the root of the
+// storage
+func ScoresDayPath(day string) string {
+	return SCORES_DAILY_PREFIX + day + ".json"
+}
+
+// ReadScores of the snapshot at the path from storage, the error of a
+// missing snapshot wraps fs.ErrNotExist
+func ReadScores(ctx context.Context, source StorageSource, path string) (*ScoreSnapshot, error) {
+	bs, err := source.Read(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot ScoreSnapshot
+	if err := json.Unmarshal(bs, &snapshot); err != nil {
+		return nil, fmt.Errorf("error unmarshaling scores of %s: %w", path, err)
+	}
+	return &snapshot, nil
+}
+
+// Scorer computes the uptime of submitters over sliding windows from the
+// submissions of an object storage. Submissions are told from their path
+// alone, reading the index of a day when it is complete. Snapshots are
+// served from memory, or from storage for snapshots computed by another
+// replica.
+type Scorer struct {
+	Source StorageSource
+	// Saves snapshots, replacing the latest snapshot
+	Save               func(ObjectsToSave) error
+	Windows            []int
+	SubmissionInterval time.Duration
+	// Submissions of blocks found orphaned by the finality checker aren't
+	// counted
+	ExcludeOrphaned bool
+	mutex           sync.Mutex
+	// Times of submission by submitter of days no submission is saved to
+	// anymore
+	complete map[string]map[string][]time.Time
+	latest   *ScoreSnapshot
+	fetched  time.Time
+	now      nowFunc
+	log      logging.StandardLogger
+}
+
+func NewScorer(source StorageSource, save func(ObjectsToSave) error, config *ScoringConfig, now nowFunc, log logging.StandardLogger) *Scorer {
+	return &Scorer{
+		Source:             source,
+		Save:               save,
+		Windows:            config.Windows(),
+		SubmissionInterval: config.SubmissionInterval(),
+		ExcludeOrphaned:    config.ExcludeOrphaned,
+		complete:           make(map[string]map[string][]time.Time),
+		now:                now,
+		log:                log,
+	}
+}
+
+// Run computes the scores of every window and saves the snapshot
+func (s *Scorer) Run(ctx context.Context) (*ScoreSnapshot, error) {
+	now := s.now().UTC()
+	longest := 0
+	for _, days := range s.Windows {
+		if days > longest {
+			longest = days
+		}
+	}
+	firstDay := now.Add(-time.Duration(longest) * 24 * time.Hour).Format("2006-01-02")
+	days, err := s.Source.Days(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing days of submissions: %w", err)
+	}
+	submitters := make(map[string][]time.Time)
+	for _, day := range days {
+		if day < firstDay {
+			continue
+		}
+		times, err := s.submissionsOfDay(ctx, day, now)
+		if err != nil {
+			return nil, fmt.Errorf("error reading submissions of %s: %w", day, err)
+		}
+		for submitter, ts := range times {
+			submitters[submitter] = append(submitters[submitter], ts...)
+		}
+	}
+	s.mutex.Lock()
+	for day := range s.complete {
+		if day < firstDay {
+			delete(s.complete, day)
+		}
+	}
+	s.mutex.Unlock()
+	snapshot := &ScoreSnapshot{ComputedAt: now}
+	for _, days := range s.Windows {
+		snapshot.Windows = append(snapshot.Windows, s.score(submitters, days, now))
+	}
+	bs, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Save(ObjectsToSave{ScoresPath(now): bs, ScoresDayPath(now.Format("2006-01-02")): bs, SCORES_LATEST_PATH: bs}); err != nil {
+		return nil, fmt.Errorf("error saving scores: %w", err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.latest = snapshot
+	s.fetched = now
+	return snapshot, nil
+}
+
+// Times of submission of the day by submitter, from the index of the day
+// if complete or else listing the day. Days aren't cached until their
+// blocks are checked if orphaned blocks are excluded.
+func (s *Scorer) submissionsOfDay(ctx context.Context, day string, now time.Time) (map[string][]time.Time, error) {
+	s.mutex.Lock()
+	cached, ok := s.complete[day]
+	s.mutex.Unlock()
+	if ok {
+		return cached, nil
+	}
+	times := make(map[string][]time.Time)
+	idx, err := ReadDayIndex(ctx, s.Source, day)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if idx != nil && idx.Complete {
+		for submitter, ts := range idx.Submitters {
+			for _, t := range ts {
+				if submittedAt, err := time.Parse(time.RFC3339, t); err == nil {
+					times[submitter] = append(times[submitter], submittedAt)
+				}
+			}
+		}
+	} else {
+		paths, err := s.Source.Submissions(ctx, day)
+		if err != nil {
+			return nil, err
+		}
+		for _, metaPath := range paths {
+			_, submitter, ok := splitSubmissionPath(metaPath)
+			submittedAt, err := SubmittedAtOfPath(metaPath)
+			if ok && err == nil {
+				times[submitter] = append(times[submitter], submittedAt)
+			}
+		}
+	}
+	final := true
+	if s.ExcludeOrphaned {
+		finality, err := ReadDayFinality(ctx, s.Source, day)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if finality != nil {
+			times = withoutOrphaned(times, finality)
+		} else {
+			final = false
+		}
+	}
+	dayStart, err := time.Parse("2006-01-02", day)
+	if err == nil && final && now.After(dayStart.Add(24*time.Hour+INDEX_SETTLE_DELAY)) {
+		s.mutex.Lock()
+		s.complete[day] = times
+		s.mutex.Unlock()
+	}
+	return times, nil
+}
+
+// Times of submission without the submissions of orphaned blocks
+func withoutOrphaned(times map[string][]time.Time, finality *DayFinality) map[string][]time.Time {
+	for submitter, orphaned := range finality.Orphaned {
+		excluded := make(map[string]bool, len(orphaned))
+		for _, t := range orphaned {
+			excluded[t] = true
+		}
+		var kept []time.Time
+		for _, t := range times[submitter] {
+			if !excluded[t.UTC().Format(time.RFC3339)] {
+				kept = append(kept, t)
+			}
+		}
+		times[submitter] = kept
+	}
+	return times
+}
+
+// Scores of the submitters over the window of days ending now
+func (s *Scorer) score(submitters map[string][]time.Time, days int, now time.Time) ScoreWindow {
+	window := time.Duration(days) * 24 * time.Hour
+	w := ScoreWindow{
+		Days:      days,
+		Since:     now.Add(-window),
+		Until:     now,
+		Intervals: int(window / s.SubmissionInterval),
+		Scores:    make(map[string]SubmitterScore),
+	}
+	for submitter, times := range submitters {
+		intervals := make(map[int64]bool)
+		for _, t := range times {
+			if !t.Before(w.Since) && t.Before(w.Until) {
+				intervals[int64(t.Sub(w.Since)/s.SubmissionInterval)] = true
+			}
+		}
+		if len(intervals) == 0 {
+			continue
+		}
+		uptime := 100 * float64(len(intervals)) / float64(w.Intervals)
+		if uptime > 100 {
+			uptime = 100
+		}
+		w.Scores[submitter] = SubmitterScore{Intervals: len(intervals), Uptime: uptime}
+	}
+	return w
+}
+
+// Latest snapshot of scores, the error of no snapshot computed yet wraps
+// fs.ErrNotExist
+func (s *Scorer) Latest(ctx context.Context) (*ScoreSnapshot, error) {
+	s.mutex.Lock()
+	latest, fetched := s.latest, s.fetched
+	s.mutex.Unlock()
+	if latest != nil && s.now().Sub(fetched) < SCORES_CACHE_TTL {
+		return latest, nil
+	}
+	snapshot, err := ReadScores(ctx, s.Source, SCORES_LATEST_PATH)
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.latest = snapshot
+	s.fetched = s.now()
+	return snapshot, nil
+}
+
+// Score every interval while leader, replicas which aren't the leader
+// only serve snapshots
+func (s *Scorer) RunLoop(ctx context.Context, interval time.Duration, election *LeaderElection) {
+	for {
+		if election.IsLeader() {
+			if snapshot, err := s.Run(ctx); err != nil {
+				incMetric("scoring_errors")
+				s.log.Errorf("Failed to compute uptime scores: %v", err)
+			} else {
+				s.log.Debugf("Computed uptime scores of %s", ScoresPath(snapshot.ComputedAt))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Handler serves the latest snapshot at /v1/scores, restricted to the
+// window of `?window=<days>` and the submitter of `?submitter=<public key>`
+func (s *Scorer) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		query := r.URL.Query()
+		days := 0
+		if query.Get("window") != "" {
+			var err error
+			if days, err = strconv.Atoi(query.Get("window")); err != nil || days <= 0 {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{"Invalid window, expected a number of days"})
+				return
+			}
+		}
+		snapshot, err := s.Latest(r.Context())
+		if errors.Is(err, fs.ErrNotExist) {
+			writeJSON(rw, http.StatusNotFound, errorResponse{"No scores computed yet"})
+			return
+		}
+		if err != nil {
+			writeJSON(rw, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		result := ScoreSnapshot{ComputedAt: snapshot.ComputedAt}
+		for _, w := range snapshot.Windows {
+			if days != 0 && w.Days != days {
+				continue
+			}
+			if submitter := query.Get("submitter"); submitter != "" {
+				scores := make(map[string]SubmitterScore)
+				scores[submitter] = w.Scores[submitter]
+				w.Scores = scores
+			}
+			result.Windows = append(result.Windows, w)
+		}
+		if len(result.Windows) == 0 {
+			writeJSON(rw, http.StatusNotFound, errorResponse{"Window not scored"})
+			return
+		}
+		writeJSON(rw, http.StatusOK, result)
+	})
+}
+
+// WritePrometheus writes the submitters scored by window and the time of
+// the latest snapshot
+func (s *Scorer) WritePrometheus(w io.Writer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.latest == nil {
+		return
+	}
+	windows := append([]ScoreWindow(nil), s.latest.Windows...)
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Days < windows[j].Days })
+	fmt.Fprint(w, "# HELP uptime_scores_submitters Submitters with a submission in the window of scores.\n")
+	fmt.Fprint(w, "# TYPE uptime_scores_submitters gauge\n")
+	for _, window := range windows {
+		fmt.Fprintf(w, "uptime_scores_submitters{window_days=\"%d\"} %d\n", window.Days, len(window.Scores))
+	}
+	fmt.Fprint(w, "# HELP uptime_scores_timestamp_seconds Time of the latest snapshot of scores.\n")
+	fmt.Fprint(w, "# TYPE uptime_scores_timestamp_seconds gauge\n")
+	fmt.Fprintf(w, "uptime_scores_timestamp_seconds %d\n", s.latest.ComputedAt.Unix())
+}
@@ -0,0 +1,89 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestScorer(t *testing.T) {
+	dir := t.TempDir()
+	log := logging.Logger("test")
+	save := func(objs ObjectsToSave) error { return LocalFileSystemSave(objs, dir, log) }
+	overwrite := func(objs ObjectsToSave) error { return LocalFileSystemOverwrite(objs, dir) }
+	if err := save(ObjectsToSave{
+		// Submissions of B62qa in 3 intervals of an hour, twice in one
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": []byte(`{}`),
+		"submissions/2024-05-01/2024-05-01T10:30:00Z-B62qa.json": []byte(`{}`),
+		"submissions/2024-05-01/2024-05-01T23:00:00Z-B62qa.json": []byte(`{}`),
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json": []byte(`{}`),
+		// Out of the longest window
+		"submissions/2024-04-28/2024-04-28T10:00:00Z-B62qb.json": []byte(`{}`),
+		"submissions/2024-05-02/2024-05-02T09:00:00Z-B62qb.json": []byte(`{}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tm := &timeMock{time: time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)}
+	scorer := NewScorer(LocalFileSystemSource{Directory: dir}, overwrite, &ScoringConfig{WindowDays: []int{1, 2}, SubmissionIntervalMinutes: 60}, tm.Now, log)
+
+	if _, err := scorer.Latest(context.Background()); err == nil {
+		t.Fatal("expected no scores before the first run")
+	}
+	snapshot, err := scorer.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	day, twoDays := snapshot.Windows[0], snapshot.Windows[1]
+	if day.Intervals != 24 || twoDays.Intervals != 48 {
+		t.Fatalf("unexpected intervals of windows %d and %d", day.Intervals, twoDays.Intervals)
+	}
+	if score := twoDays.Scores["B62qa"]; score.Intervals != 3 || score.Uptime != 6.25 {
+		t.Fatalf("unexpected score %+v", score)
+	}
+	if score := day.Scores["B62qa"]; score.Intervals != 2 {
+		t.Fatalf("unexpected score %+v", score)
+	}
+	if score := twoDays.Scores["B62qb"]; score.Intervals != 1 {
+		t.Fatalf("unexpected score %+v", score)
+	}
+
+	// Snapshots are read back from storage by other replicas
+	replica := NewScorer(LocalFileSystemSource{Directory: dir}, overwrite, &ScoringConfig{}, tm.Now, log)
+	latest, err := replica.Latest(context.Background())
+	if err != nil || !latest.ComputedAt.Equal(snapshot.ComputedAt) || len(latest.Windows) != 2 {
+		t.Fatalf("unexpected latest snapshot %+v, error: %v", latest, err)
+	}
+	if _, err := (LocalFileSystemSource{Directory: dir}).Read(context.Background(), ScoresPath(snapshot.ComputedAt)); err != nil {
+		t.Fatalf("expected the snapshot saved: %v", err)
+	}
+
+	for _, test := range []struct {
+		url     string
+		status  int
+		windows int
+	}{
+		{"/v1/scores", http.StatusOK, 2},
+		{"/v1/scores?window=2&submitter=B62qa", http.StatusOK, 1},
+		{"/v1/scores?window=90", http.StatusNotFound, 0},
+		{"/v1/scores?window=a", http.StatusBadRequest, 0},
+	} {
+		rec := httptest.NewRecorder()
+		replica.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.url, test.status, rec.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var result ScoreSnapshot
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || len(result.Windows) != test.windows {
+			t.Errorf("%s: unexpected scores %s", test.url, rec.Body)
+		}
+	}
+}
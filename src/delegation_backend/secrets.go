@@ -0,0 +1,323 @@
+package delegation_backend
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	vault "github.com/hashicorp/vault/api"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/lib/pq"
+)
+
+// Prefixes of secret references which can be used in place of
+// sensitive configuration values:
+//
+//	awssm://<secret id>[#<json key>]   AWS Secrets Manager
+//	vault://<path>[#<key>]             HashiCorp Vault (KV v1 or v2)
+//
+// Values without these prefixes are used as is.
+const (
+	AWS_SECRET_REF_PREFIX   = "awssm://"
+	VAULT_SECRET_REF_PREFIX = "vault://"
+)
+
+const SECRET_FETCH_TIMEOUT = 10 * time.Second
+
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, AWS_SECRET_REF_PREFIX) || strings.HasPrefix(value, VAULT_SECRET_REF_PREFIX)
+}
+
+// Split `<location>#<key>` of a reference without prefix
+func splitSecretRef(ref string) (string, string) {
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// Extract the key from a secret holding a JSON object,
+// whole secret is returned if the key is empty.
+func secretJSONField(secret string, key string) (string, error) {
+	if key == "" {
+		return secret, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object: %w", err)
+	}
+	return secretField(fields, key)
+}
+
+func secretField(fields map[string]interface{}, key string) (string, error) {
+	v, has := fields[key]
+	if !has {
+		return "", fmt.Errorf("key %s not found in secret", key)
+	}
+	if s, isStr := v.(string); isStr {
+		return s, nil
+	}
+	// Non-string values (e.g. an embedded service account JSON) are re-encoded
+	bs, err := json.Marshal(v)
+	return string(bs), err
+}
+
+// AWSSecretsManager fetches secrets through the Secrets Manager API.
+type AWSSecretsManager struct {
+	Client *secretsmanager.Client
+}
+
+func NewAWSSecretsManager(ctx context.Context, region string) (*AWSSecretsManager, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+	return &AWSSecretsManager{Client: secretsmanager.NewFromConfig(awsCfg)}, nil
+}
+
+func (sm *AWSSecretsManager) Fetch(ctx context.Context, ref string) (string, error) {
+	secretId, key := splitSecretRef(strings.TrimPrefix(ref, AWS_SECRET_REF_PREFIX))
+	out, err := sm.Client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(secretId)})
+	if err != nil {
+		return "", fmt.Errorf("error fetching %s from AWS Secrets Manager: %w", secretId, err)
+	}
+	return secretJSONField(aws.ToString(out.SecretString), key)
+}
+
+// VaultClient fetches secrets through the Vault API.
+type VaultClient struct {
+	Client *vault.Client
+}
+
+// NewVaultClient creates a client of the Vault at addr. Other settings,
+// e.g. VAULT_CACERT, are read from the environment by the Vault client.
+func NewVaultClient(addr string, token string) (*VaultClient, error) {
+	vaultCfg := vault.DefaultConfig()
+	if vaultCfg.Error != nil {
+		return nil, vaultCfg.Error
+	}
+	vaultCfg.Address = addr
+	client, err := vault.NewClient(vaultCfg)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(token)
+	return &VaultClient{Client: client}, nil
+}
+
+func (vc *VaultClient) Fetch(ctx context.Context, ref string) (string, error) {
+	path, key := splitSecretRef(strings.TrimPrefix(ref, VAULT_SECRET_REF_PREFIX))
+	secret, err := vc.Client.Logical().ReadWithContext(ctx, strings.TrimLeft(path, "/"))
+	if err != nil {
+		return "", fmt.Errorf("error reading %s from Vault: %w", path, err)
+	}
+	if secret == nil {
+		return "", fmt.Errorf("secret %s not found in Vault", path)
+	}
+	fields := secret.Data
+	// KV v2 nests the secret under data.data
+	if nested, isKV2 := fields["data"].(map[string]interface{}); isKV2 {
+		if _, hasMetadata := fields["metadata"]; hasMetadata {
+			fields = nested
+		}
+	}
+	if key == "" {
+		bs, err := json.Marshal(fields)
+		return string(bs), err
+	}
+	return secretField(fields, key)
+}
+
+// SecretResolver resolves secret references using the configured backends.
+type SecretResolver struct {
+	AWS   *AWSSecretsManager
+	Vault *VaultClient
+}
+
+// NewSecretResolver configures the backends for the references found in
+// the configuration. Vault token is read from VAULT_TOKEN or the file
+// in VAULT_TOKEN_FILE, so that it never appears in the configuration.
+func NewSecretResolver(ctx context.Context, cfg *SecretsConfig) (*SecretResolver, error) {
+	r := &SecretResolver{}
+	if cfg == nil {
+		return r, nil
+	}
+	if cfg.AwsRegion != "" {
+		sm, err := NewAWSSecretsManager(ctx, cfg.AwsRegion)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring AWS Secrets Manager: %w", err)
+		}
+		r.AWS = sm
+	}
+	if cfg.VaultAddr != "" {
+		token := os.Getenv("VAULT_TOKEN")
+		if tokenFile := os.Getenv("VAULT_TOKEN_FILE"); token == "" && tokenFile != "" {
+			bs, err := os.ReadFile(tokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading Vault token: %w", err)
+			}
+			token = strings.TrimSpace(string(bs))
+		}
+		if token == "" {
+			return nil, fmt.Errorf("VAULT_TOKEN or VAULT_TOKEN_FILE is required with Vault address")
+		}
+		vc, err := NewVaultClient(cfg.VaultAddr, token)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring Vault: %w", err)
+		}
+		r.Vault = vc
+	}
+	return r, nil
+}
+
+// Resolve returns the secret referenced by value,
+// or value itself if it isn't a reference.
+func (r *SecretResolver) Resolve(value string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), SECRET_FETCH_TIMEOUT)
+	defer cancel()
+	switch {
+	case strings.HasPrefix(value, AWS_SECRET_REF_PREFIX):
+		if r.AWS == nil {
+			return "", fmt.Errorf("AWS Secrets Manager reference %s used, but secrets AWS region is not configured", value)
+		}
+		return r.AWS.Fetch(ctx, value)
+	case strings.HasPrefix(value, VAULT_SECRET_REF_PREFIX):
+		if r.Vault == nil {
+			return "", fmt.Errorf("Vault reference %s used, but Vault address is not configured", value)
+		}
+		return r.Vault.Fetch(ctx, value)
+	}
+	return value, nil
+}
+
+// Secret holds the current value of a (possibly rotating) secret.
+type Secret struct {
+	mutex sync.RWMutex
+	ref   string
+	value string
+}
+
+func NewSecret(r *SecretResolver, value string) (*Secret, error) {
+	resolved, err := r.Resolve(value)
+	if err != nil {
+		return nil, err
+	}
+	return &Secret{ref: value, value: resolved}, nil
+}
+
+func (s *Secret) Value() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.value
+}
+
+// Refresh fetches the secret again, returns whether the value changed.
+func (s *Secret) Refresh(r *SecretResolver) (bool, error) {
+	if !IsSecretRef(s.ref) {
+		return false, nil
+	}
+	value, err := r.Resolve(s.ref)
+	if err != nil {
+		return false, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	changed := value != s.value
+	s.value = value
+	return changed, nil
+}
+
+// Periodically refresh the secrets, keeping the previous values on failure.
+func RefreshSecretsLoop(r *SecretResolver, secrets map[string]*Secret, interval time.Duration, log logging.StandardLogger) {
+	for {
+		time.Sleep(interval)
+		for name, s := range secrets {
+			changed, err := s.Refresh(r)
+			if err != nil {
+				log.Errorf("Failed to refresh secret %s, using previous value, error: %v", name, err)
+			} else if changed {
+				log.Infof("Secret %s rotated", name)
+			}
+		}
+	}
+}
+
+// ResolveSecrets replaces references in the sensitive configuration
+// fields with the values of the secrets. Fields which can rotate at
+// runtime (admin token, PostgreSQL password) are resolved separately
+// with NewSecret.
+//
+// AWS credentials exported to the environment by LoadEnv are updated
+// with the resolved values. They can't be stored in AWS Secrets Manager,
+// as they are needed to access it.
+func ResolveSecrets(cfg *AppConfig, r *SecretResolver) error {
+	fields := map[string]*string{
+		"gsheet_credentials": &cfg.GsheetCredentials,
+	}
+	if cfg.Aws != nil {
+		fields["aws.access_key_id"] = &cfg.Aws.AccessKeyId
+		fields["aws.secret_access_key"] = &cfg.Aws.SecretAccessKey
+	}
+	if cfg.AwsKeyspaces != nil {
+		fields["aws_keyspaces.cassandra_password"] = &cfg.AwsKeyspaces.CassandraPassword
+		fields["aws_keyspaces.access_key_id"] = &cfg.AwsKeyspaces.AccessKeyId
+		fields["aws_keyspaces.secret_access_key"] = &cfg.AwsKeyspaces.SecretAccessKey
+	}
+	if cfg.SharedState != nil {
+		fields["shared_state.redis_password"] = &cfg.SharedState.RedisPassword
+	}
+	if cfg.NATS != nil {
+		fields["nats.token"] = &cfg.NATS.Token
+		fields["nats.password"] = &cfg.NATS.Password
+	}
+	if cfg.Webhooks != nil {
+		for i := range cfg.Webhooks.Endpoints {
+			fields[fmt.Sprintf("webhooks.endpoints[%d].secret", i)] = &cfg.Webhooks.Endpoints[i].Secret
+		}
+	}
+	for name, field := range fields {
+		value, err := r.Resolve(*field)
+		if err != nil {
+			return fmt.Errorf("error resolving %s: %w", name, err)
+		}
+		*field = value
+	}
+	if cfg.Aws != nil {
+		for variable, value := range map[string]string{
+			"AWS_ACCESS_KEY_ID":     cfg.Aws.AccessKeyId,
+			"AWS_SECRET_ACCESS_KEY": cfg.Aws.SecretAccessKey,
+		} {
+			if IsSecretRef(os.Getenv(variable)) {
+				os.Setenv(variable, value)
+			}
+		}
+	}
+	return nil
+}
+
+// Connector opening PostgreSQL connections with the current password,
+// so that a rotated password is used for new connections.
+type postgreSQLConnector struct {
+	cfg      *PostgreSQLConfig
+	password *Secret
+}
+
+func (c *postgreSQLConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	connector, err := pq.NewConnector(postgreSQLConnStr(c.cfg, c.password.Value()))
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *postgreSQLConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
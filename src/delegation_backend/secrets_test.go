@@ -0,0 +1,98 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+func TestVaultSecret(t *testing.T) {
+	password := "pass1"
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" || r.URL.Path != "/v1/secret/data/uptime" {
+			rw.WriteHeader(403)
+			return
+		}
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"postgres_password": password},
+				"metadata": map[string]interface{}{"version": 1},
+			},
+		})
+	}))
+	defer srv.Close()
+	vc, err := NewVaultClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := &SecretResolver{Vault: vc}
+
+	secret, err := NewSecret(resolver, "vault://secret/data/uptime#postgres_password")
+	if err != nil || secret.Value() != "pass1" {
+		t.Fatalf("unexpected secret %q, error: %v", secret.Value(), err)
+	}
+	password = "pass2"
+	changed, err := secret.Refresh(resolver)
+	if err != nil || !changed || secret.Value() != "pass2" {
+		t.Fatalf("secret not rotated: %q, error: %v", secret.Value(), err)
+	}
+	if _, err := resolver.Resolve("vault://secret/data/uptime#missing"); err == nil {
+		t.Fatal("missing key resolved")
+	}
+	if _, err := resolver.Resolve("vault://secret/data/other#key"); err == nil {
+		t.Fatal("forbidden path resolved")
+	}
+}
+
+func TestAWSSecret(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var req struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			req.SecretId != "uptime/admin" {
+			rw.WriteHeader(400)
+			return
+		}
+		json.NewEncoder(rw).Encode(map[string]string{"SecretString": `{"admin_token":"s3cret"}`})
+	}))
+	defer srv.Close()
+	creds := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+	})
+	resolver := &SecretResolver{AWS: &AWSSecretsManager{
+		Client: secretsmanager.New(secretsmanager.Options{Region: "us-west-2", Credentials: creds, BaseEndpoint: aws.String(srv.URL)}),
+	}}
+
+	cfg := AppConfig{AdminToken: "awssm://uptime/admin#admin_token", GsheetCredentials: "awssm://uptime/admin"}
+	if err := ResolveSecrets(&cfg, resolver); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GsheetCredentials != `{"admin_token":"s3cret"}` {
+		t.Fatalf("unexpected whole secret %q", cfg.GsheetCredentials)
+	}
+	token, err := NewSecret(resolver, cfg.AdminToken)
+	if err != nil || token.Value() != "s3cret" {
+		t.Fatalf("unexpected secret %q, error: %v", token.Value(), err)
+	}
+}
+
+func TestLiteralSecret(t *testing.T) {
+	resolver := &SecretResolver{}
+	secret, err := NewSecret(resolver, "plain")
+	if err != nil || secret.Value() != "plain" {
+		t.Fatalf("literal value changed: %q, error: %v", secret.Value(), err)
+	}
+	if changed, err := secret.Refresh(resolver); changed || err != nil {
+		t.Fatal("literal value refreshed")
+	}
+	if _, err := resolver.Resolve("vault://secret/data/uptime#key"); err == nil {
+		t.Fatal("reference resolved without Vault configured")
+	}
+}
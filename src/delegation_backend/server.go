@@ -0,0 +1,60 @@
+package delegation_backend
+
+import (
+	"net/http"
+	"time"
+)
+
+// Defaults protecting the service from slow clients
+const (
+	HTTP_READ_HEADER_TIMEOUT = 10 * time.Second
+	HTTP_WRITE_TIMEOUT       = 2 * time.Minute
+	HTTP_IDLE_TIMEOUT        = 2 * time.Minute
+	HTTP_MAX_HEADER_BYTES    = 64 << 10
+	SUBMIT_BODY_READ_TIMEOUT = time.Minute
+	SUBMIT_TIMEOUT           = 90 * time.Second
+)
+
+// Code of rejections of submissions not handled within SUBMIT_TIMEOUT
+const SUBMIT_DEADLINE_EXCEEDED_CODE = "deadline_exceeded"
+
+// Longest wait for requests in flight once a shutdown is requested
+const SERVER_SHUTDOWN_TIMEOUT = 30 * time.Second
+
+type ServerTimeouts struct {
+	ReadHeader time.Duration
+	// Deadline for reading the body of a submission, counted from the
+	// moment the handler starts reading it
+	BodyRead time.Duration
+	// Deadline for handling a submission, counted from the moment the
+	// handler receives it, bounding verification of signatures and saves
+	Submit time.Duration
+	Write  time.Duration
+	Idle   time.Duration
+}
+
+// Timeouts of the server, defaults are set when the configuration loads
+func (cfg *HTTPServerConfig) Timeouts() ServerTimeouts {
+	return ServerTimeouts{
+		ReadHeader: time.Duration(cfg.ReadHeaderTimeoutSeconds) * time.Second,
+		BodyRead:   time.Duration(cfg.BodyReadTimeoutSeconds) * time.Second,
+		Submit:     time.Duration(cfg.SubmitTimeoutSeconds) * time.Second,
+		Write:      time.Duration(cfg.WriteTimeoutSeconds) * time.Second,
+		Idle:       time.Duration(cfg.IdleTimeoutSeconds) * time.Second,
+	}
+}
+
+// NewHTTPServer creates a server with the timeouts and header size limit
+// applied, so that slow clients can't hold connections indefinitely.
+func NewHTTPServer(addr string, handler http.Handler, timeouts ServerTimeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		// Whole request, the submit handler sets a tighter deadline for the body
+		ReadTimeout:    timeouts.ReadHeader + timeouts.BodyRead,
+		WriteTimeout:   timeouts.Write,
+		IdleTimeout:    timeouts.Idle,
+		MaxHeaderBytes: HTTP_MAX_HEADER_BYTES,
+	}
+}
@@ -0,0 +1,62 @@
+package delegation_backend
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSlowBodyTimesOut(t *testing.T) {
+	_, sh, _ := testSubmitH(1, Whitelist{})
+	sh.app.BodyReadTimeout = 100 * time.Millisecond
+	srv := httptest.NewUnstartedServer(sh)
+	srv.Config = NewHTTPServer("", sh, ServerTimeouts{ReadHeader: time.Second, BodyRead: time.Second, Write: 5 * time.Second, Idle: time.Second})
+	srv.Start()
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Declare a body which is never sent in full
+	fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: test\r\nContent-Length: 1000\r\n\r\n{\"data\":", v1Submit)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 408 {
+		t.Fatalf("expected 408, got %d", resp.StatusCode)
+	}
+}
+
+func TestSlowBackendTimesOut(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.SubmitTimeout = 50 * time.Millisecond
+	sh.app.Save = func(ctx context.Context, objs ObjectsToSave) StorageOutcomes {
+		return SaveToBackends(ctx, objs, map[string]func(context.Context, ObjectsToSave) error{
+			"slow": func(ctx context.Context, _ ObjectsToSave) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}, false, false)
+	}
+	rep := sh.testRequest(body)
+	var resp submitErrorResponse
+	json.Unmarshal(rep.Body.Bytes(), &resp)
+	if rep.Code != 504 || resp.Code != SUBMIT_DEADLINE_EXCEEDED_CODE {
+		t.Fatalf("expected the deadline exceeded, got %d %s", rep.Code, rep.Body.String())
+	}
+}
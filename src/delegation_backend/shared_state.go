@@ -0,0 +1,146 @@
+package delegation_backend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Backends of the shared state
+const (
+	SHARED_STATE_POSTGRESQL = "postgresql"
+	SHARED_STATE_REDIS      = "redis"
+)
+
+const (
+	// Blocks saved are remembered for this long, sparing checks of their existence
+	SHARED_STATE_BLOCK_TTL = 24 * time.Hour
+	// Attempts older than this are pruned from PostgreSQL, longer than any window
+	SHARED_STATE_ATTEMPTS_RETENTION = 24 * time.Hour
+	SHARED_STATE_PRUNE_INTERVAL     = 10 * time.Minute
+)
+
+// SharedState holds the state replicas have to agree on, so that several
+// replicas behave as a single service: attempts counted by the rate limit,
+// latest submissions of replay protection and blocks already saved.
+// Components fall back to their own in-memory state if it fails.
+type SharedState interface {
+	// RecordAttempt records an attempt of key at now, unless limit
+	// attempts were recorded within window before. Returns whether the
+	// attempt was recorded.
+	RecordAttempt(key string, limit int, window time.Duration, now time.Time) (bool, error)
+	// AdvanceTo stores at as the latest time of key if it is later than
+	// the one stored, at millisecond precision. Returns whether it was stored.
+	AdvanceTo(key string, at time.Time) (bool, error)
+	// Get returns the value of key, unless it is missing or expired
+	Get(key string) (string, bool, error)
+	// Set stores the value of key, expiring after ttl
+	Set(key string, value string, ttl time.Duration) error
+}
+
+// PostgreSQLSharedState keeps the shared state in the `shared_attempts`
+// and `shared_values` tables. Keys are prefixed with Prefix, so that
+// deployments of several networks can share a database.
+type PostgreSQLSharedState struct {
+	DB     *sql.DB
+	Prefix string
+}
+
+func (s *PostgreSQLSharedState) CreateTableIfNotExists() error {
+	_, err := s.DB.Exec(`CREATE TABLE IF NOT EXISTS shared_attempts (
+			key TEXT NOT NULL,
+			at TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_shared_attempts_key_at ON shared_attempts (key, at);
+		CREATE TABLE IF NOT EXISTS shared_values (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			expires_at TIMESTAMPTZ
+		)`)
+	return err
+}
+
+func (s *PostgreSQLSharedState) RecordAttempt(key string, limit int, window time.Duration, now time.Time) (bool, error) {
+	key = s.Prefix + key
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	// Attempts of a key are serialized across replicas, the two keys form
+	// of the advisory lock doesn't clash with the leader election lock
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(1, hashtext($1))", key); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM shared_attempts WHERE key = $1 AND at <= $2", key, now.Add(-window)); err != nil {
+		return false, err
+	}
+	var count int
+	if err := tx.QueryRow("SELECT count(*) FROM shared_attempts WHERE key = $1", key).Scan(&count); err != nil {
+		return false, err
+	}
+	if count >= limit {
+		return false, tx.Commit()
+	}
+	if _, err := tx.Exec("INSERT INTO shared_attempts (key, at) VALUES ($1, $2)", key, now); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *PostgreSQLSharedState) AdvanceTo(key string, at time.Time) (bool, error) {
+	var stored int
+	err := s.DB.QueryRow(`INSERT INTO shared_values (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = NULL
+		WHERE shared_values.value::bigint < EXCLUDED.value::bigint
+		RETURNING 1`, s.Prefix+key, strconv.FormatInt(at.UnixMilli(), 10)).Scan(&stored)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *PostgreSQLSharedState) Get(key string) (string, bool, error) {
+	var value string
+	err := s.DB.QueryRow("SELECT value FROM shared_values WHERE key = $1 AND (expires_at IS NULL OR expires_at > now())", s.Prefix+key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+func (s *PostgreSQLSharedState) Set(key string, value string, ttl time.Duration) error {
+	_, err := s.DB.Exec(`INSERT INTO shared_values (key, value, expires_at) VALUES ($1, $2, now() + $3::bigint * interval '1 millisecond')
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at`, s.Prefix+key, value, ttl.Milliseconds())
+	return err
+}
+
+// Prune deletes expired values, and attempts older than
+// SHARED_STATE_ATTEMPTS_RETENTION
+func (s *PostgreSQLSharedState) Prune(now time.Time) error {
+	if _, err := s.DB.Exec("DELETE FROM shared_values WHERE expires_at <= now()"); err != nil {
+		return err
+	}
+	_, err := s.DB.Exec("DELETE FROM shared_attempts WHERE at < $1", now.Add(-SHARED_STATE_ATTEMPTS_RETENTION))
+	return err
+}
+
+// Periodically prune, until ctx is done
+func (s *PostgreSQLSharedState) PruneLoop(ctx context.Context, interval time.Duration, log logging.StandardLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.Prune(now); err != nil {
+				log.Errorf("Failed to prune shared state: %v", err)
+			}
+		}
+	}
+}
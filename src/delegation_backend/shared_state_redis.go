@@ -0,0 +1,83 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	REDIS_TIMEOUT        = 2 * time.Second
+	REDIS_MAX_IDLE_CONNS = 16
+)
+
+// Sliding window of attempts, as a sorted set of attempts scored by time
+var redisRecordAttemptScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return 1`)
+
+var redisAdvanceToScript = redis.NewScript(`
+local latest = redis.call('GET', KEYS[1])
+if latest and tonumber(latest) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return 1`)
+
+// RedisSharedState keeps the shared state in Redis. Keys are prefixed with
+// Prefix, so that deployments of several networks can share a server.
+type RedisSharedState struct {
+	Prefix string
+	client *redis.Client
+}
+
+func NewRedisSharedState(addr string, password string, db int, prefix string) *RedisSharedState {
+	return &RedisSharedState{
+		Prefix: prefix,
+		client: redis.NewClient(&redis.Options{
+			Addr:         addr,
+			Password:     password,
+			DB:           db,
+			DialTimeout:  REDIS_TIMEOUT,
+			ReadTimeout:  REDIS_TIMEOUT,
+			WriteTimeout: REDIS_TIMEOUT,
+			MaxIdleConns: REDIS_MAX_IDLE_CONNS,
+		}),
+	}
+}
+
+func (s *RedisSharedState) RecordAttempt(key string, limit int, window time.Duration, now time.Time) (bool, error) {
+	// Members have to be unique for concurrent attempts to be counted
+	member, err := randomToken(8)
+	if err != nil {
+		return false, err
+	}
+	ms := now.UnixMilli()
+	recorded, err := redisRecordAttemptScript.Run(context.Background(), s.client, []string{s.Prefix + key},
+		ms-window.Milliseconds(), ms, limit, member, window.Milliseconds()).Int()
+	return recorded == 1, err
+}
+
+func (s *RedisSharedState) AdvanceTo(key string, at time.Time) (bool, error) {
+	advanced, err := redisAdvanceToScript.Run(context.Background(), s.client, []string{s.Prefix + key}, at.UnixMilli()).Int()
+	return advanced == 1, err
+}
+
+func (s *RedisSharedState) Get(key string) (string, bool, error) {
+	value, err := s.client.Get(context.Background(), s.Prefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+func (s *RedisSharedState) Set(key string, value string, ttl time.Duration) error {
+	return s.client.Set(context.Background(), s.Prefix+key, value, ttl).Err()
+}
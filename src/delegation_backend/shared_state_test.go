@@ -0,0 +1,151 @@
+package delegation_backend
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// In-memory shared state, failing with err if set
+type memorySharedState struct {
+	mutex    sync.Mutex
+	attempts map[string][]time.Time
+	values   map[string]string
+	err      error
+}
+
+func newMemorySharedState() *memorySharedState {
+	return &memorySharedState{attempts: make(map[string][]time.Time), values: make(map[string]string)}
+}
+
+func (s *memorySharedState) RecordAttempt(key string, limit int, window time.Duration, now time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	var recent []time.Time
+	for _, at := range s.attempts[key] {
+		if at.After(now.Add(-window)) {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= limit {
+		return false, nil
+	}
+	s.attempts[key] = append(recent, now)
+	return true, nil
+}
+
+func (s *memorySharedState) AdvanceTo(key string, at time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	if latest, err := strconv.ParseInt(s.values[key], 10, 64); err == nil && at.UnixMilli() <= latest {
+		return false, nil
+	}
+	s.values[key] = strconv.FormatInt(at.UnixMilli(), 10)
+	return true, nil
+}
+
+func (s *memorySharedState) Get(key string) (string, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	value, found := s.values[key]
+	return value, found, s.err
+}
+
+func (s *memorySharedState) Set(key string, value string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.values[key] = value
+	return s.err
+}
+
+func TestAttemptCounterSharedState(t *testing.T) {
+	shared := newMemorySharedState()
+	// Counters of two replicas
+	a, b := NewAttemptCounter(2), NewAttemptCounter(2)
+	a.SetSharedState(shared)
+	b.SetSharedState(shared)
+	pk := mkPk()
+	if !a.RecordAttempt(pk) || !b.RecordAttempt(pk) {
+		t.Fatal("expected attempts within the limit recorded")
+	}
+	if a.RecordAttempt(pk) || b.RecordAttempt(pk) {
+		t.Fatal("expected the limit enforced across replicas")
+	}
+
+	// Attempts are counted in memory while the shared state fails
+	shared.err = errors.New("connection refused")
+	if !a.RecordAttempt(pk) {
+		t.Fatal("expected the attempt counted in memory")
+	}
+}
+
+func TestReplayGuardSharedState(t *testing.T) {
+	shared := newMemorySharedState()
+	a, _ := NewReplayGuard("")
+	b, _ := NewReplayGuard("")
+	a.SetSharedState(shared)
+	b.SetSharedState(shared)
+	pk := mkPk()
+	t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if !a.Commit(pk, t0) {
+		t.Fatal("first submission rejected")
+	}
+	if b.Check(pk, t0) || b.Commit(pk, t0) {
+		t.Fatal("submission replayed to another replica accepted")
+	}
+	if !b.Check(pk, t0.Add(time.Second)) {
+		t.Fatal("newer submission rejected by another replica")
+	}
+	if latest, found := a.Latest(pk); !found || !latest.Equal(t0) {
+		t.Fatalf("expected the accepted submission kept in memory, got %v", latest)
+	}
+}
+
+func TestRedisSharedState(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("s3cret")
+	s := NewRedisSharedState(server.Addr(), "s3cret", 0, "mainnet:")
+	if _, found, err := s.Get("block:a"); found || err != nil {
+		t.Fatalf("expected a missing value, error: %v", err)
+	}
+	if err := s.Set("block:a", "{\"etag\":\"x\"}", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, found, err := s.Get("block:a"); !found || value != "{\"etag\":\"x\"}" || err != nil {
+		t.Fatalf("expected the value set, got %q (error: %v)", value, err)
+	}
+	if !server.Exists("mainnet:block:a") {
+		t.Fatal("expected the key prefixed")
+	}
+
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i, expected := range []bool{true, true, false} {
+		if recorded, err := s.RecordAttempt("attempts:a", 2, time.Hour, now.Add(time.Duration(i)*time.Second)); recorded != expected || err != nil {
+			t.Fatalf("attempt %d: expected recorded %v, got %v (error: %v)", i, expected, recorded, err)
+		}
+	}
+	if recorded, _ := s.RecordAttempt("attempts:a", 2, time.Hour, now.Add(time.Hour+time.Second)); !recorded {
+		t.Fatal("expected attempts out of the window forgotten")
+	}
+	if advanced, err := s.AdvanceTo("replay:a", now); !advanced || err != nil {
+		t.Fatalf("expected advanced, error: %v", err)
+	}
+	if advanced, _ := s.AdvanceTo("replay:a", now); advanced {
+		t.Fatal("expected the same time not to advance")
+	}
+
+	unauthenticated := NewRedisSharedState(server.Addr(), "", 0, "mainnet:")
+	if _, _, err := unauthenticated.Get("block:a"); err == nil {
+		t.Fatal("expected authentication required")
+	}
+}
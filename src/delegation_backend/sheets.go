@@ -1,15 +1,20 @@
 package delegation_backend
 
 import (
+	"strconv"
+	"strings"
+
 	logging "github.com/ipfs/go-log/v2"
 	sheets "google.golang.org/api/sheets/v4"
 )
 
 // Process rows retrieved from Google spreadsheet
 // and extract public keys from the first column.
-func processRows(rows [][](interface{})) Whitelist {
+// Hourly limits of the keys are read from the first column
+// of the rows of the rate limit column, if any, by row.
+func processRows(rows [][](interface{}), limitRows [][](interface{})) Whitelist {
 	wl := make(Whitelist)
-	for _, row := range rows {
+	for i, row := range rows {
 		if len(row) > 0 {
 			switch v := row[0].(type) {
 			case string:
@@ -17,6 +22,11 @@ func processRows(rows [][](interface{})) Whitelist {
 				err := StringToPk(&pk, v)
 				if err == nil {
 					wl[pk] = true // we need something to be provided as value
+					if i < len(limitRows) {
+						if limit, ok := processLimitRow(limitRows[i]); ok {
+							wl[pk] = limit
+						}
+					}
 				}
 			}
 		}
@@ -24,18 +34,49 @@ func processRows(rows [][](interface{})) Whitelist {
 	return wl
 }
 
+// Hourly limit of a key in the rate limit column, empty cells
+// and cells which are not a non-negative integer are ignored.
+func processLimitRow(row [](interface{})) (int, bool) {
+	if len(row) == 0 {
+		return 0, false
+	}
+	var limit int
+	switch v := row[0].(type) {
+	case string:
+		var err error
+		limit, err = strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, false
+		}
+	case float64:
+		limit = int(v)
+		if float64(limit) != v {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	return limit, limit >= 0
+}
+
 // Retrieve data from delegation program spreadsheet
 // and extract public keys out of the column containing
-// public keys of program participants.
+// public keys of program participants, along with their
+// hourly limits if the rate limit column is configured.
 func RetrieveWhitelist(service *sheets.Service, log *logging.ZapEventLogger, appCfg AppConfig, retries int) (Whitelist, error) {
-	var resp *sheets.ValueRange
+	var resp *sheets.BatchGetValuesResponse
 	var err error
 
+	columnRange := func(col string) string {
+		return appCfg.DelegationWhitelistList + "!" + col + ":" + col
+	}
+	readRanges := []string{columnRange(appCfg.DelegationWhitelistColumn)}
+	if col := appCfg.DelegationWhitelistRateLimitColumn; col != "" {
+		readRanges = append(readRanges, columnRange(col))
+	}
 	operation := func() error {
-		col := appCfg.DelegationWhitelistColumn
-		readRange := appCfg.DelegationWhitelistList + "!" + col + ":" + col
 		spId := appCfg.GsheetId
-		resp, err = service.Spreadsheets.Values.Get(spId, readRange).Do()
+		resp, err = service.Spreadsheets.Values.BatchGet(spId).Ranges(readRanges...).Do()
 		if err != nil {
 			return err
 		}
@@ -47,5 +88,12 @@ func RetrieveWhitelist(service *sheets.Service, log *logging.ZapEventLogger, app
 		return nil, err
 	}
 
-	return processRows(resp.Values), nil
+	var rows, limitRows [][](interface{})
+	if len(resp.ValueRanges) > 0 {
+		rows = resp.ValueRanges[0].Values
+	}
+	if len(resp.ValueRanges) > 1 {
+		limitRows = resp.ValueRanges[1].Values
+	}
+	return processRows(rows, limitRows), nil
 }
@@ -0,0 +1,203 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+const SHEETS_STATUS_DEFAULT_TAB = "Status"
+
+const SHEETS_STATUS_DEFAULT_INTERVAL = time.Hour
+
+// Days scanned for the last submission of block producers at least, also
+// early in an epoch
+const SHEETS_STATUS_MIN_DAYS = 7
+
+// StatusSheet is a spreadsheet the status of block producers is written to
+type StatusSheet interface {
+	// Replace the content of the tab with the rows, creating the tab if
+	// it doesn't exist
+	WriteTab(ctx context.Context, tab string, rows [][]interface{}) error
+}
+
+// GoogleStatusSheet writes to a tab of a Google spreadsheet
+type GoogleStatusSheet struct {
+	Service       *sheets.Service
+	SpreadsheetId string
+}
+
+func (s *GoogleStatusSheet) WriteTab(ctx context.Context, tab string, rows [][]interface{}) error {
+	spreadsheet, err := s.Service.Spreadsheets.Get(s.SpreadsheetId).Fields("sheets.properties.title").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error reading spreadsheet: %w", err)
+	}
+	exists := false
+	for _, sheet := range spreadsheet.Sheets {
+		exists = exists || (sheet.Properties != nil && sheet.Properties.Title == tab)
+	}
+	if !exists {
+		addSheet := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{
+			{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: tab}}},
+		}}
+		if _, err := s.Service.Spreadsheets.BatchUpdate(s.SpreadsheetId, addSheet).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("error adding tab %s: %w", tab, err)
+		}
+	}
+	// Tabs named with spaces or quotes are quoted in ranges
+	quoted := "'" + strings.ReplaceAll(tab, "'", "''") + "'"
+	if _, err := s.Service.Spreadsheets.Values.Clear(s.SpreadsheetId, quoted, &sheets.ClearValuesRequest{}).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("error clearing tab %s: %w", tab, err)
+	}
+	values := &sheets.ValueRange{Values: rows}
+	if _, err := s.Service.Spreadsheets.Values.Update(s.SpreadsheetId, quoted+"!A1", values).ValueInputOption("RAW").Context(ctx).Do(); err != nil {
+		return fmt.Errorf("error writing tab %s: %w", tab, err)
+	}
+	return nil
+}
+
+// SheetsStatusWriter writes the status of the block producers of the
+// whitelist to a tab of the whitelist spreadsheet: the time of their last
+// submission and the number of their submissions in the current epoch,
+// read from the indexes of days of submissions, so that delegation
+// coordinators don't cross-reference the sheet with storage by hand.
+type SheetsStatusWriter struct {
+	Sheet StatusSheet
+	Tab   string
+	// Lookup of the index of a day, the error of a day not indexed wraps
+	// fs.ErrNotExist
+	Lookup    func(ctx context.Context, day string) (*DayIndex, error)
+	Timing    *NetworkTiming
+	Whitelist func() *Whitelist
+	now       nowFunc
+	log       logging.StandardLogger
+}
+
+func NewSheetsStatusWriter(sheet StatusSheet, cfg *SheetsStatusConfig, indexer *SubmissionIndexer, timing *NetworkTiming, whitelist func() *Whitelist, now nowFunc, log logging.StandardLogger) *SheetsStatusWriter {
+	tab := cfg.Tab
+	if tab == "" {
+		tab = SHEETS_STATUS_DEFAULT_TAB
+	}
+	return &SheetsStatusWriter{
+		Sheet:     sheet,
+		Tab:       tab,
+		Lookup:    indexer.Lookup,
+		Timing:    timing,
+		Whitelist: whitelist,
+		now:       now,
+		log:       log,
+	}
+}
+
+// Interval between writes of the status
+func (cfg *SheetsStatusConfig) Interval() time.Duration {
+	if cfg.IntervalMinutes > 0 {
+		return time.Duration(cfg.IntervalMinutes) * time.Minute
+	}
+	return SHEETS_STATUS_DEFAULT_INTERVAL
+}
+
+type producerStatus struct {
+	last      time.Time
+	submitted int
+}
+
+// Rows of the status, a header and a row by block producer of the
+// whitelist, sorted by public key
+func (w *SheetsStatusWriter) Rows(ctx context.Context) ([][]interface{}, error) {
+	now := w.now().UTC()
+	slot, _ := w.Timing.Slot(now)
+	epoch := w.Timing.Epoch(slot)
+	epochStart := w.Timing.EpochStart(epoch)
+	from := epochStart
+	if earliest := now.AddDate(0, 0, -(SHEETS_STATUS_MIN_DAYS - 1)); earliest.Before(from) {
+		from = earliest
+	}
+	statuses := make(map[string]*producerStatus)
+	for day := from.Truncate(24 * time.Hour); !day.After(now); day = day.AddDate(0, 0, 1) {
+		idx, err := w.Lookup(ctx, statsDay(day))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for submitter, times := range idx.Submitters {
+			status := statuses[submitter]
+			if status == nil {
+				status = &producerStatus{}
+				statuses[submitter] = status
+			}
+			for _, t := range times {
+				at, err := time.Parse(time.RFC3339, t)
+				if err != nil {
+					continue
+				}
+				if !at.Before(epochStart) {
+					status.submitted++
+				}
+				if at.After(status.last) {
+					status.last = at
+				}
+			}
+		}
+	}
+
+	updatedAt := now.Format(time.RFC3339)
+	rows := [][]interface{}{{"Public key", "Last submission", fmt.Sprintf("Submissions in epoch %d", epoch), "Updated at"}}
+	var producers []string
+	if wl := w.Whitelist(); wl != nil {
+		for pk := range *wl {
+			producers = append(producers, pk.String())
+		}
+	}
+	sort.Strings(producers)
+	for _, producer := range producers {
+		last, submitted := "", 0
+		if status := statuses[producer]; status != nil {
+			if !status.last.IsZero() {
+				last = status.last.Format(time.RFC3339)
+			}
+			submitted = status.submitted
+		}
+		rows = append(rows, []interface{}{producer, last, submitted, updatedAt})
+	}
+	return rows, nil
+}
+
+// Write the status to the tab
+func (w *SheetsStatusWriter) Write(ctx context.Context) error {
+	rows, err := w.Rows(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading indexes of submissions: %w", err)
+	}
+	if err := w.Sheet.WriteTab(ctx, w.Tab, rows); err != nil {
+		return err
+	}
+	incMetric("sheets_status_writes")
+	return nil
+}
+
+// Write every interval while leader
+func (w *SheetsStatusWriter) RunLoop(ctx context.Context, interval time.Duration, election *LeaderElection) {
+	for {
+		if election.IsLeader() {
+			if err := w.Write(ctx); err != nil {
+				incMetric("sheets_status_errors")
+				w.log.Errorf("Failed to write the status of block producers to tab %s: %v", w.Tab, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
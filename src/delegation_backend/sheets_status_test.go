@@ -0,0 +1,97 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"google.golang.org/api/option"
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+type recordingSheet struct {
+	tab  string
+	rows [][]interface{}
+}
+
+func (s *recordingSheet) WriteTab(ctx context.Context, tab string, rows [][]interface{}) error {
+	s.tab, s.rows = tab, rows
+	return nil
+}
+
+func TestSheetsStatusWriter(t *testing.T) {
+	// Epochs of 1 day, the current one started at 2024-05-10T00:00:00Z
+	timing, err := NewNetworkTiming(&NetworkTimingConfig{GenesisTimestamp: "2024-05-01T00:00:00Z", SlotDurationMs: 3600000, SlotsPerEpoch: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pk1, pk2 Pk
+	pk1[0], pk2[0] = 1, 2
+	indexes := map[string]*DayIndex{
+		"2024-05-05": {Submitters: map[string][]string{pk1.String(): {"2024-05-05T10:00:00Z"}}},
+		"2024-05-10": {Submitters: map[string][]string{pk1.String(): {"2024-05-10T08:00:00Z", "2024-05-10T09:00:00Z"}, "B62qother": {"2024-05-10T09:30:00Z"}}},
+	}
+	sheet := &recordingSheet{}
+	writer := NewSheetsStatusWriter(sheet, &SheetsStatusConfig{}, nil, timing, func() *Whitelist { return &Whitelist{pk1: nil, pk2: nil} },
+		func() time.Time { return time.Date(2024, 5, 10, 10, 0, 0, 0, time.UTC) }, logging.Logger("test"))
+	writer.Lookup = func(ctx context.Context, day string) (*DayIndex, error) {
+		if idx := indexes[day]; idx != nil {
+			return idx, nil
+		}
+		return nil, fs.ErrNotExist
+	}
+	if err := writer.Write(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]interface{}{
+		{"Public key", "Last submission", "Submissions in epoch 9", "Updated at"},
+		{pk1.String(), "2024-05-10T09:00:00Z", 2, "2024-05-10T10:00:00Z"},
+		{pk2.String(), "", 0, "2024-05-10T10:00:00Z"},
+	}
+	if sheet.tab != SHEETS_STATUS_DEFAULT_TAB || !reflect.DeepEqual(sheet.rows, expected) {
+		t.Fatalf("unexpected rows written to %s: %v, expected %v", sheet.tab, sheet.rows, expected)
+	}
+}
+
+func TestGoogleStatusSheet(t *testing.T) {
+	var requests []string
+	var written sheets.ValueRange
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"sheets":[{"properties":{"title":"Whitelist"}}]}`))
+		case r.Method == http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &written)
+			w.Write([]byte(`{}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	service, err := sheets.NewService(context.Background(), option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet := &GoogleStatusSheet{Service: service, SpreadsheetId: "sheet"}
+	if err := sheet.WriteTab(context.Background(), "BP status", [][]interface{}{{"Public key"}, {"B62q"}}); err != nil {
+		t.Fatal(err)
+	}
+	// The missing tab is added before it's cleared and written
+	expected := []string{"GET /v4/spreadsheets/sheet", "POST /v4/spreadsheets/sheet:batchUpdate", "POST /v4/spreadsheets/sheet/values/'BP status':clear", "PUT /v4/spreadsheets/sheet/values/'BP status'!A1"}
+	if strings.Join(requests, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected requests %q, expected %q", requests, expected)
+	}
+	if len(written.Values) != 2 || written.Values[1][0] != "B62q" {
+		t.Fatalf("unexpected values written %v", written.Values)
+	}
+}
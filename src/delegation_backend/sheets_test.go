@@ -66,7 +66,7 @@ func (Rows) Generate(r *rand.Rand, size int) reflect.Value {
 
 func TestProcessRow(t *testing.T) {
 	f := func(rows Rows) bool {
-		actual := processRows(rows.rows)
+		actual := processRows(rows.rows, nil)
 		res := reflect.DeepEqual(map[Pk]unit(rows.expected), map[Pk]unit(actual))
 		if !res {
 			t.Logf("expected: %s", rows.expected)
@@ -78,3 +78,52 @@ func TestProcessRow(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestProcessRowsRateLimits(t *testing.T) {
+	pk1, pk2, pk3, pk4 := mkPk(), mkPk(), mkPk(), mkPk()
+	rows := [][](interface{}){
+		{pk1.String()},
+		{pk2.String()},
+		{"not a key"},
+		{pk3.String()},
+		{pk4.String()},
+	}
+	limitRows := [][](interface{}){
+		{" 1000 "},
+		{},
+		{"500"},
+		{"-1"},
+	}
+	wl := processRows(rows, limitRows)
+	if len(wl) != 4 {
+		t.Fatalf("expected 4 keys, got %d", len(wl))
+	}
+	expected := map[Pk]int{pk1: 1000}
+	if limits := wl.RateLimits(); !reflect.DeepEqual(expected, limits) {
+		t.Fatalf("expected limits %v, got %v", expected, limits)
+	}
+}
+
+func TestReplaceWhitelistRateLimits(t *testing.T) {
+	pk1, pk2 := mkPk(), mkPk()
+	counter, _ := newTestAttemptCounter(1)
+	app := &App{SubmitCounter: counter, Whitelist: new(WhitelistMVar)}
+	app.ReplaceWhitelist(Whitelist{pk1: 3, pk2: true})
+	if counter.Limit(pk1) != 3 || counter.Limit(pk2) != 1 {
+		t.Fatalf("unexpected limits %d and %d", counter.Limit(pk1), counter.Limit(pk2))
+	}
+	// Overrides of the configuration take precedence
+	counter.SetOverrides(map[Pk]int{pk1: 5})
+	if counter.Limit(pk1) != 5 {
+		t.Fatalf("expected override of 5, got %d", counter.Limit(pk1))
+	}
+	counter.SetOverrides(nil)
+	// Limits are refreshed with the whitelist
+	app.ReplaceWhitelist(Whitelist{pk1: true, pk2: 2})
+	if counter.Limit(pk1) != 1 || counter.Limit(pk2) != 2 {
+		t.Fatalf("unexpected limits %d and %d after refresh", counter.Limit(pk1), counter.Limit(pk2))
+	}
+	if _, exists := (*app.Whitelist.ReadWhitelist())[pk2]; !exists {
+		t.Fatal("whitelist was not replaced")
+	}
+}
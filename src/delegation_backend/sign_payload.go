@@ -0,0 +1,140 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Versions of the construction of sign payloads, selected by the
+// `payload_version` of requests
+const (
+	// Fields of the data in a fixed order, strings written as sent, the
+	// version of requests without `payload_version`
+	SIGN_PAYLOAD_V1 = 1
+	// Canonical JSON of the data along with the version, see
+	// writeCanonicalSignPayload
+	SIGN_PAYLOAD_V2 = 2
+)
+
+// Versions of sign payloads known to the backend, all of them accepted
+// unless configured otherwise
+var SIGN_PAYLOAD_VERSIONS = []int{SIGN_PAYLOAD_V1, SIGN_PAYLOAD_V2}
+
+func isSignPayloadVersion(version int) bool {
+	for _, known := range SIGN_PAYLOAD_VERSIONS {
+		if version == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Versions of sign payloads accepted by the app
+func (app *App) signPayloadVersions() []int {
+	if app.SignPayloadVersions == nil {
+		return SIGN_PAYLOAD_VERSIONS
+	}
+	return app.SignPayloadVersions
+}
+
+func (app *App) acceptsSignPayloadVersion(version int) bool {
+	for _, accepted := range app.signPayloadVersions() {
+		if version == accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// Version of the sign payload of a request, version 1 if not set
+func signPayloadVersion(version int) int {
+	if version == 0 {
+		return SIGN_PAYLOAD_V1
+	}
+	return version
+}
+
+// Write the sign payload of the version
+func (req submitRequestData) WriteVersionedSignPayload(w io.Writer, version int) error {
+	switch signPayloadVersion(version) {
+	case SIGN_PAYLOAD_V1:
+		return req.WriteSignPayload(w)
+	case SIGN_PAYLOAD_V2:
+		return req.writeCanonicalSignPayload(w)
+	}
+	return fmt.Errorf("unknown sign payload version %d", version)
+}
+
+// Hash of the sign payload of the version
+func (req submitRequestData) VersionedSignPayloadHash(version int) ([]byte, error) {
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.WriteVersionedSignPayload(h, version); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Hash of the sign payload of the version of the request
+func (req submitRequest) signPayloadHash() ([]byte, error) {
+	return req.Data.VersionedSignPayloadHash(req.PayloadVersion)
+}
+
+// Canonical JSON of the data of version 2: keys in lexicographic order,
+// no whitespace, optional fields omitted when empty, blocks and snark
+// work re-encoded in standard base64 and strings escaped as by
+// encoding/json without HTML escaping, so that the payload doesn't depend
+// on how the client encoded the request. The version is signed along,
+// so that a signature of a version can't be taken for another.
+func (req submitRequestData) writeCanonicalSignPayload(w io.Writer) error {
+	signPayload := &WriterOrError{W: w}
+	signPayload.WriteString("{\"block\":")
+	writeCanonicalBase64(signPayload, req.Block.data)
+	if req.BuiltWithCommitSha != "" {
+		signPayload.WriteString(",\"built_with_commit_sha\":")
+		signPayload.Write(canonicalJSONString(req.BuiltWithCommitSha))
+	}
+	signPayload.WriteString(",\"created_at\":")
+	signPayload.Write(canonicalJSONString(req.CreatedAt.UTC().Format(time.RFC3339)))
+	if req.GraphqlControlPort != 0 {
+		signPayload.WriteString(fmt.Sprintf(",\"graphql_control_port\":%d", req.GraphqlControlPort))
+	}
+	signPayload.WriteString(fmt.Sprintf(",\"payload_version\":%d", SIGN_PAYLOAD_V2))
+	signPayload.WriteString(",\"peer_id\":")
+	signPayload.Write(canonicalJSONString(req.PeerId))
+	if req.SnarkWork != nil {
+		signPayload.WriteString(",\"snark_work\":")
+		writeCanonicalBase64(signPayload, req.SnarkWork.data)
+	}
+	signPayload.WriteString("}")
+	return signPayload.Err
+}
+
+func writeCanonicalBase64(w *WriterOrError, data []byte) {
+	w.WriteString("\"")
+	if w.Err == nil {
+		enc := base64.NewEncoder(base64.StdEncoding, w.W)
+		_, w.Err = enc.Write(data)
+		if err := enc.Close(); w.Err == nil {
+			w.Err = err
+		}
+	}
+	w.WriteString("\"")
+}
+
+func canonicalJSONString(s string) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	// Strings always encode
+	_ = enc.Encode(s)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
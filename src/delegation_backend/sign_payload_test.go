@@ -0,0 +1,106 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"block_producers_uptime/metadata"
+)
+
+func TestCanonicalSignPayload(t *testing.T) {
+	var req submitRequest
+	if err := json.Unmarshal(readTestFile("req-with-snark", t), &req); err != nil {
+		t.Fatal(err)
+	}
+	req.Data.PeerId = `peer"<id>`
+	req.Data.GraphqlControlPort = 3085
+	var payload bytes.Buffer
+	if err := req.Data.WriteVersionedSignPayload(&payload, SIGN_PAYLOAD_V2); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"block":` + string(req.Data.Block.json) + `,"created_at":"2021-07-17T22:39:48Z","graphql_control_port":3085,"payload_version":2,"peer_id":"peer\"<id>","snark_work":` + string(req.Data.SnarkWork.json) + `}`
+	if payload.String() != expected {
+		t.Fatalf("unexpected canonical payload:\n%s\nexpected:\n%s", payload.String(), expected)
+	}
+
+	// The canonical payload doesn't depend on how the block was escaped
+	escaped := req
+	escapedBlock := *req.Data.Block
+	escapedBlock.json = []byte(strings.ReplaceAll(string(req.Data.Block.json), "/", `\/`))
+	escaped.Data.Block = &escapedBlock
+	for version, same := range map[int]bool{SIGN_PAYLOAD_V1: false, SIGN_PAYLOAD_V2: true} {
+		hash, _ := req.Data.VersionedSignPayloadHash(version)
+		escapedHash, _ := escaped.Data.VersionedSignPayloadHash(version)
+		if bytes.Equal(hash, escapedHash) != same {
+			t.Errorf("expected hashes of version %d of escaped blocks equal: %v", version, same)
+		}
+	}
+	if _, err := req.Data.VersionedSignPayloadHash(3); err == nil {
+		t.Error("expected an unknown version rejected")
+	}
+}
+
+// Request of the fixture signed with Ed25519 with the sign payload of
+// the version
+func ed25519Request(t *testing.T, version int) (submitRequest, []byte) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req submitRequest
+	if err := json.Unmarshal(readTestFile("req-with-snark", t), &req); err != nil {
+		t.Fatal(err)
+	}
+	copy(req.Submitter[:], public)
+	req.Submitter[ed25519.PublicKeySize] = 0
+	req.PayloadVersion = version
+	hash, err := req.signPayloadHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(req.Sig[:], ed25519.Sign(private, hash))
+	body, _ := json.Marshal(req)
+	return req, body
+}
+
+func TestSubmitPayloadVersion(t *testing.T) {
+	req, body := ed25519Request(t, SIGN_PAYLOAD_V2)
+	storage, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	tm.time = req.Data.CreatedAt
+	sh.app.SignatureScheme = Ed25519{}
+	sh.app.SignPayloadVersions = []int{SIGN_PAYLOAD_V1}
+	rep := sh.testRequest(body)
+	if rep.Code != 400 || !strings.Contains(rep.Body.String(), "payload_version 2 isn't accepted") {
+		t.Fatalf("expected version 2 rejected, got %d: %s", rep.Code, rep.Body)
+	}
+
+	// Both versions are accepted while exporters move to version 2
+	sh.app.SignPayloadVersions = nil
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected version 2 accepted, got %d: %s", rep.Code, rep.Body)
+	}
+	saved := (*storage)[makePaths(tm.Now(), req.GetBlockDataHash(), req.Submitter).Meta]
+	var meta MetaToBeSaved
+	var savedMeta metadata.Meta
+	if json.Unmarshal(saved, &meta) != nil || json.Unmarshal(saved, &savedMeta) != nil || savedMeta.PayloadVersion != SIGN_PAYLOAD_V2 {
+		t.Fatalf("expected the payload version saved, got %s", saved)
+	}
+	// Saved submissions are verified again with the version they were signed with
+	hash, err := meta.signPayloadHash(req.Data.Block.data)
+	if err != nil || !(Ed25519{}).Verify(&meta.Submitter, meta.Signature, hash) {
+		t.Fatalf("expected the saved submission verified, error: %v", err)
+	}
+
+	// A signature of version 2 isn't one of version 1
+	req.PayloadVersion = 0
+	body, _ = json.Marshal(req)
+	tm.time = tm.time.Add(time.Hour)
+	if rep := sh.testRequest(body); rep.Code != 401 {
+		t.Fatalf("expected the signature of version 2 invalid as version 1, got %d: %s", rep.Code, rep.Body)
+	}
+}
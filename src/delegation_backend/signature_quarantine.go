@@ -0,0 +1,397 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Storage prefix under which submissions failing signature verification
+// are quarantined, apart from QUARANTINE_PREFIX of purges
+const REJECTED_PREFIX = "rejected/"
+
+// Period submissions stay quarantined by default
+const SIGNATURE_QUARANTINE_DEFAULT_MAX_AGE = 14 * 24 * time.Hour
+
+const SIGNATURE_QUARANTINE_PURGE_INTERVAL = time.Hour
+
+// Bounds of the quarantine, as anyone can make submissions failing
+// signature verification: submissions quarantined per hour by submitter,
+// by IP and in total, and the size of requests saved whole
+const (
+	SIGNATURE_QUARANTINE_MAX_PER_KEY      = 10
+	SIGNATURE_QUARANTINE_MAX_PER_HOUR     = 1000
+	SIGNATURE_QUARANTINE_MAX_REQUEST_SIZE = 1 << 20
+)
+
+// Reasons of quarantining submissions
+const (
+	REJECTED_INVALID_SIGNATURE            = "invalid_signature"
+	REJECTED_INVALID_DELEGATION_SIGNATURE = "invalid_delegation_signature"
+)
+
+// RejectedSubmission is a submission quarantined after failing signature
+// verification, saved along with the body of its request so that it can
+// be verified again once the cause is fixed, e.g. a misconfigured
+// signature scheme after a hard fork.
+type RejectedSubmission struct {
+	Reason      string    `json:"reason"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	RemoteAddr  string    `json:"remote_addr"`
+	// Scheme the signature failed to verify with
+	SignatureScheme string          `json:"signature_scheme"`
+	Request         json.RawMessage `json:"request,omitempty"`
+	// Set instead of the request if larger than
+	// SIGNATURE_QUARANTINE_MAX_REQUEST_SIZE, which can't be verified again
+	RequestPrefix []byte `json:"request_prefix,omitempty"`
+	RequestSize   int    `json:"request_size,omitempty"`
+}
+
+// Path of a quarantined submission, relative to the root of the storage
+func RejectedPath(submittedAt time.Time, submitter Pk) string {
+	submittedAtStr := submittedAt.UTC().Format(time.RFC3339)
+	return REJECTED_PREFIX + submittedAtStr[:10] + "/" + submittedAtStr + "-" + submitter.String() + ".json"
+}
+
+// RejectedObject listed by a RejectedLister, Modified is the time it was
+// quarantined
+type RejectedObject struct {
+	Path     string
+	Modified time.Time
+}
+
+// RejectedLister is implemented by storage sources able to list
+// quarantined submissions
+type RejectedLister interface {
+	// Submissions quarantined, sorted by path
+	Rejected(ctx context.Context) ([]RejectedObject, error)
+}
+
+func (l LocalFileSystemSource) Rejected(ctx context.Context) ([]RejectedObject, error) {
+	root := filepath.Join(l.Directory, REJECTED_PREFIX)
+	var rejected []RejectedObject
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			return nil
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.Directory, path)
+		if err != nil {
+			return err
+		}
+		rejected = append(rejected, RejectedObject{Path: filepath.ToSlash(rel), Modified: info.ModTime()})
+		return nil
+	})
+	return rejected, err
+}
+
+func (s *S3Source) Rejected(ctx context.Context) ([]RejectedObject, error) {
+	root := s.Aws.Prefix + "/"
+	paginator := s3.NewListObjectsV2Paginator(s.Aws.Client, &s3.ListObjectsV2Input{
+		Bucket: s.Aws.BucketName,
+		Prefix: aws.String(root + REJECTED_PREFIX),
+	})
+	var rejected []RejectedObject
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			if key := aws.ToString(obj.Key); strings.HasSuffix(key, ".json") {
+				rejected = append(rejected, RejectedObject{Path: strings.TrimPrefix(key, root), Modified: aws.ToTime(obj.LastModified)})
+			}
+		}
+	}
+	return rejected, nil
+}
+
+// SignatureQuarantine saves submissions failing signature verification
+// under REJECTED_PREFIX of an object storage rather than discarding them,
+// for MaxAge after which they are deleted. Quarantined submissions are
+// restored by Reverify once they verify.
+type SignatureQuarantine struct {
+	Source interface {
+		StorageSource
+		RejectedLister
+	}
+	Save    func(ObjectsToSave) error
+	Deleter ObjectDeleter
+	MaxAge  time.Duration
+	now     nowFunc
+	log     logging.StandardLogger
+
+	quarantined atomic.Int64
+	purged      atomic.Int64
+
+	// Submissions quarantined within the current hour, by key and in total
+	mutex       sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+	total       int
+}
+
+func NewSignatureQuarantine(source interface {
+	StorageSource
+	RejectedLister
+}, save func(ObjectsToSave) error, deleter ObjectDeleter, config *SignatureQuarantineConfig, now nowFunc, log logging.StandardLogger) *SignatureQuarantine {
+	return &SignatureQuarantine{Source: source, Save: save, Deleter: deleter, MaxAge: config.MaxAge(), now: now, log: log}
+}
+
+// Period submissions stay quarantined
+func (cfg *SignatureQuarantineConfig) MaxAge() time.Duration {
+	if cfg.MaxAgeDays > 0 {
+		return time.Duration(cfg.MaxAgeDays) * 24 * time.Hour
+	}
+	return SIGNATURE_QUARANTINE_DEFAULT_MAX_AGE
+}
+
+// Whether a submission of the submitter and IP can be quarantined within
+// the bounds of the current hour, counting it if so
+func (q *SignatureQuarantine) admit(submitter Pk, ip string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if now := q.now(); q.counts == nil || now.Sub(q.windowStart) >= time.Hour {
+		q.windowStart, q.counts, q.total = now, make(map[string]int), 0
+	}
+	submitterKey, ipKey := LOCKOUT_KEY_SUBMITTER+submitter.String(), LOCKOUT_KEY_IP+ip
+	if q.total >= SIGNATURE_QUARANTINE_MAX_PER_HOUR || q.counts[submitterKey] >= SIGNATURE_QUARANTINE_MAX_PER_KEY || q.counts[ipKey] >= SIGNATURE_QUARANTINE_MAX_PER_KEY {
+		return false
+	}
+	q.counts[submitterKey]++
+	q.counts[ipKey]++
+	q.total++
+	return true
+}
+
+// Add quarantines the submission, body being the body of its request.
+// Submissions beyond the bounds of the quarantine aren't saved. Failures
+// are logged, the submission is rejected regardless.
+func (q *SignatureQuarantine) Add(reason string, submittedAt time.Time, remoteAddr string, scheme SignatureScheme, submitter Pk, body []byte) {
+	if !q.admit(submitter, hostOf(remoteAddr)) {
+		incMetric("signature_quarantine_skipped")
+		return
+	}
+	submission := RejectedSubmission{
+		Reason:          reason,
+		SubmittedAt:     submittedAt.UTC(),
+		RemoteAddr:      remoteAddr,
+		SignatureScheme: scheme.String(),
+		Request:         body,
+	}
+	if len(body) > SIGNATURE_QUARANTINE_MAX_REQUEST_SIZE {
+		submission.Request = nil
+		submission.RequestPrefix = body[:SIGNATURE_QUARANTINE_MAX_REQUEST_SIZE]
+		submission.RequestSize = len(body)
+	}
+	rejected, err := json.Marshal(submission)
+	if err == nil {
+		path := RejectedPath(submittedAt, submitter)
+		if err = q.Save(ObjectsToSave{path: rejected}); err == nil {
+			q.quarantined.Add(1)
+			q.log.Debugf("Quarantined submission of %s with %s to %s", submitter.String(), reason, path)
+			return
+		}
+	}
+	incMetric("signature_quarantine_errors")
+	q.log.Errorf("Failed to quarantine submission of %s with %s: %v", submitter.String(), reason, err)
+}
+
+// Purge deletes submissions quarantined for longer than MaxAge, returning
+// the paths deleted
+func (q *SignatureQuarantine) Purge(ctx context.Context) ([]string, error) {
+	rejected, err := q.Source.Rejected(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing quarantined submissions: %w", err)
+	}
+	cutoff := q.now().Add(-q.MaxAge)
+	var expired []string
+	for _, obj := range rejected {
+		if obj.Modified.Before(cutoff) {
+			expired = append(expired, obj.Path)
+		}
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	if err := q.Deleter.DeleteObjects(ctx, expired); err != nil {
+		return nil, fmt.Errorf("error deleting quarantined submissions: %w", err)
+	}
+	q.purged.Add(int64(len(expired)))
+	return expired, nil
+}
+
+// Purge every interval while leader
+func (q *SignatureQuarantine) RunLoop(ctx context.Context, interval time.Duration, election *LeaderElection) {
+	for {
+		if election.IsLeader() {
+			if purged, err := q.Purge(ctx); err != nil {
+				incMetric("signature_quarantine_errors")
+				q.log.Errorf("Failed to purge quarantined submissions: %v", err)
+			} else if len(purged) > 0 {
+				q.log.Infof("Deleted %d submissions quarantined for more than %v", len(purged), q.MaxAge)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// WritePrometheus writes the submissions quarantined and purged since the
+// start
+func (q *SignatureQuarantine) WritePrometheus(w io.Writer) {
+	fmt.Fprint(w, "# HELP uptime_signature_quarantine_added_total Submissions quarantined after failing signature verification.\n")
+	fmt.Fprint(w, "# TYPE uptime_signature_quarantine_added_total counter\n")
+	fmt.Fprintf(w, "uptime_signature_quarantine_added_total %d\n", q.quarantined.Load())
+	fmt.Fprint(w, "# HELP uptime_signature_quarantine_purged_total Quarantined submissions deleted once expired.\n")
+	fmt.Fprint(w, "# TYPE uptime_signature_quarantine_purged_total counter\n")
+	fmt.Fprintf(w, "uptime_signature_quarantine_purged_total %d\n", q.purged.Load())
+}
+
+// ReverifyReport of the quarantined submissions verified again: those
+// restored (by path of their metadata), those still invalid and those
+// which failed to be read, restored or deleted
+type ReverifyReport struct {
+	Checked  int      `json:"checked"`
+	Restored []string `json:"restored,omitempty"`
+	Invalid  int      `json:"invalid"`
+	Failed   int      `json:"failed"`
+}
+
+// Reverify verifies the quarantined submissions of the filter again with
+// the scheme, saving those which verify with save as by the submit
+// handler, at the time they were submitted, and deleting them from the
+// quarantine. Other checks of the submit handler (whitelist, rate limits,
+// submission windows) aren't applied again. Nothing is saved or deleted
+// on a dry run.
+func (q *SignatureQuarantine) Reverify(ctx context.Context, scheme SignatureScheme, filter ReplayFilter, dryRun bool, save func(ObjectsToSave) StorageOutcomes) (ReverifyReport, error) {
+	var report ReverifyReport
+	rejected, err := q.Source.Rejected(ctx)
+	if err != nil {
+		return report, fmt.Errorf("error listing quarantined submissions: %w", err)
+	}
+	var paths []string
+	for _, obj := range rejected {
+		if day := strings.SplitN(strings.TrimPrefix(obj.Path, REJECTED_PREFIX), "/", 2)[0]; filter.includes(day) && filter.includesSubmission(obj.Path) {
+			paths = append(paths, obj.Path)
+		}
+	}
+	err = readConcurrently(ctx, q.Source, paths, func(path string, bs []byte, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		report.Checked++
+		if err != nil {
+			report.Failed++
+			q.log.Errorf("Error reading quarantined submission %s: %v", path, err)
+			return nil
+		}
+		objs, err := restoredObjects(scheme, bs)
+		if err != nil {
+			report.Failed++
+			q.log.Errorf("Error verifying quarantined submission %s: %v", path, err)
+			return nil
+		}
+		if objs == nil {
+			report.Invalid++
+			return nil
+		}
+		var metaPath string
+		for objPath := range objs {
+			if strings.HasPrefix(objPath, SUBMISSIONS_PREFIX) {
+				metaPath = objPath
+			}
+		}
+		if !dryRun {
+			for backend, err := range save(objs) {
+				if err != nil {
+					report.Failed++
+					q.log.Errorf("Error restoring quarantined submission %s to %s: %v", path, backend, err)
+					return nil
+				}
+			}
+			if err := q.Deleter.DeleteObjects(ctx, []string{path}); err != nil {
+				report.Failed++
+				q.log.Errorf("Error deleting restored submission %s from the quarantine: %v", path, err)
+				return nil
+			}
+		}
+		report.Restored = append(report.Restored, metaPath)
+		return nil
+	})
+	return report, err
+}
+
+// Objects of the quarantined submission if its signatures verify with the
+// scheme, nil otherwise
+func restoredObjects(scheme SignatureScheme, bs []byte) (ObjectsToSave, error) {
+	var rejected RejectedSubmission
+	if err := json.Unmarshal(bs, &rejected); err != nil {
+		return nil, err
+	}
+	if rejected.RequestSize > 0 {
+		return nil, fmt.Errorf("request of %d bytes truncated when quarantined", rejected.RequestSize)
+	}
+	var req submitRequest
+	var keyErr *KeyEncodingError
+	if err := json.Unmarshal(rejected.Request, &req); errors.As(err, &keyErr) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if scheme.ValidatePk(req.Submitter) != nil || scheme.ValidateSig(req.Sig) != nil {
+		return nil, nil
+	}
+	signer := req.Submitter
+	if req.Delegation != "" {
+		d, err := ParseDelegation(req.Delegation)
+		if err != nil || d.Delegator != req.Submitter || !scheme.Verify(&d.Delegator, &d.Sig, d.SigningHash()) {
+			return nil, nil
+		}
+		req.delegation = d
+		signer = d.Delegate
+	}
+	hash, err := req.signPayloadHash()
+	if err != nil {
+		return nil, err
+	}
+	if !scheme.Verify(&signer, &req.Sig, hash) {
+		return nil, nil
+	}
+	blockHash := req.GetBlockDataHash()
+	paths := makePaths(rejected.SubmittedAt, blockHash, req.Submitter)
+	var metaBuf bytes.Buffer
+	metaBytes, err := req.writeMetaToBeSaved(&metaBuf, rejected.RemoteAddr, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	return ObjectsToSave{paths.Meta: metaBytes, paths.Block: req.Data.Block.data}, nil
+}
@@ -0,0 +1,113 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestSignatureQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	log := logging.Logger("test")
+	req, body := ed25519Request(t, 0)
+	invalidReq, _ := ed25519Request(t, 0)
+	invalidReq.Sig[0] ^= 1
+	invalidBody, _ := json.Marshal(invalidReq)
+
+	_, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true, invalidReq.Submitter: true})
+	tm.time = req.Data.CreatedAt
+	// Signatures fail to verify with a misconfigured scheme
+	sh.app.SignatureScheme = constScheme{false}
+	save := func(objs ObjectsToSave) error { return LocalFileSystemSave(objs, dir, log) }
+	quarantine := NewSignatureQuarantine(LocalFileSystemSource{Directory: dir}, save, LocalFileSystemCleaner{Directory: dir}, &SignatureQuarantineConfig{MaxAgeDays: 1}, tm.Now, log)
+	sh.app.SignatureQuarantine = quarantine
+	for _, b := range [][]byte{body, invalidBody} {
+		if rep := sh.testRequest(b); rep.Code != 401 {
+			t.Fatalf("expected the submission rejected, got %d: %s", rep.Code, rep.Body)
+		}
+	}
+	path := RejectedPath(tm.Now(), req.Submitter)
+	bs, err := os.ReadFile(filepath.Join(dir, path))
+	if err != nil {
+		t.Fatalf("expected the submission quarantined: %v", err)
+	}
+	var rejected RejectedSubmission
+	if err := json.Unmarshal(bs, &rejected); err != nil || rejected.Reason != REJECTED_INVALID_SIGNATURE || !rejected.SubmittedAt.Equal(tm.Now()) {
+		t.Fatalf("unexpected quarantined submission %s, error: %v", bs, err)
+	}
+
+	// Restored once verified with the fixed scheme, at the time submitted
+	saved := make(ObjectsToSave)
+	restore := func(objs ObjectsToSave) StorageOutcomes {
+		for path, bs := range objs {
+			saved[path] = bs
+		}
+		return StorageOutcomes{"memory": nil}
+	}
+	report, err := quarantine.Reverify(context.Background(), Ed25519{}, ReplayFilter{}, true, restore)
+	metaPath := makePaths(tm.Now(), req.GetBlockDataHash(), req.Submitter).Meta
+	if err != nil || !reflect.DeepEqual(report, ReverifyReport{Checked: 2, Restored: []string{metaPath}, Invalid: 1}) || len(saved) != 0 {
+		t.Fatalf("unexpected report of a dry run %+v, error: %v", report, err)
+	}
+	report, err = quarantine.Reverify(context.Background(), Ed25519{}, ReplayFilter{}, false, restore)
+	if err != nil || len(report.Restored) != 1 || saved[metaPath] == nil {
+		t.Fatalf("expected the submission restored, got %+v, error: %v", report, err)
+	}
+	var meta MetaToBeSaved
+	if err := json.Unmarshal(saved[metaPath], &meta); err != nil || meta.Submitter != req.Submitter || meta.RemoteAddr != rejected.RemoteAddr {
+		t.Fatalf("unexpected metadata restored %s, error: %v", saved[metaPath], err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, path)); !os.IsNotExist(err) {
+		t.Fatalf("expected the restored submission deleted from the quarantine, got %v", err)
+	}
+
+	// Submissions still invalid expire
+	if purged, err := quarantine.Purge(context.Background()); err != nil || len(purged) != 0 {
+		t.Fatalf("expected nothing purged yet, got %v, error: %v", purged, err)
+	}
+	tm.time = time.Now().Add(25 * time.Hour)
+	purged, err := quarantine.Purge(context.Background())
+	if expected := []string{RejectedPath(req.Data.CreatedAt, invalidReq.Submitter)}; err != nil || !reflect.DeepEqual(purged, expected) {
+		t.Fatalf("expected %v purged, got %v, error: %v", expected, purged, err)
+	}
+}
+
+func TestSignatureQuarantineBounds(t *testing.T) {
+	dir := t.TempDir()
+	log := logging.Logger("test")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	save := func(objs ObjectsToSave) error { return LocalFileSystemSave(objs, dir, log) }
+	quarantine := NewSignatureQuarantine(LocalFileSystemSource{Directory: dir}, save, LocalFileSystemCleaner{Directory: dir}, &SignatureQuarantineConfig{MaxAgeDays: 1}, func() time.Time { return now }, log)
+	pk := mkPk()
+	// Submissions of the submitter beyond the hourly bound aren't saved
+	for i := 0; i < SIGNATURE_QUARANTINE_MAX_PER_KEY+1; i++ {
+		quarantine.Add(REJECTED_INVALID_SIGNATURE, now.Add(time.Duration(i)*time.Millisecond), "192.0.2.1:1234", Ed25519{}, pk, []byte("{}"))
+	}
+	if added := quarantine.quarantined.Load(); added != SIGNATURE_QUARANTINE_MAX_PER_KEY {
+		t.Fatalf("expected %d submissions quarantined, got %d", SIGNATURE_QUARANTINE_MAX_PER_KEY, added)
+	}
+	// Nor those from the same IP, whatever the submitter
+	quarantine.Add(REJECTED_INVALID_SIGNATURE, now, "192.0.2.1:4321", Ed25519{}, mkPk(), []byte("{}"))
+	if added := quarantine.quarantined.Load(); added != SIGNATURE_QUARANTINE_MAX_PER_KEY {
+		t.Fatalf("expected the submission from the same IP skipped, got %d quarantined", added)
+	}
+
+	// Bounds reset hourly, large requests are truncated
+	now = now.Add(time.Hour)
+	body := make([]byte, SIGNATURE_QUARANTINE_MAX_REQUEST_SIZE+1)
+	quarantine.Add(REJECTED_INVALID_SIGNATURE, now, "192.0.2.1:1234", Ed25519{}, pk, body)
+	bs, err := os.ReadFile(filepath.Join(dir, RejectedPath(now, pk)))
+	if err != nil {
+		t.Fatalf("expected the submission quarantined: %v", err)
+	}
+	var rejected RejectedSubmission
+	if err := json.Unmarshal(bs, &rejected); err != nil || rejected.Request != nil || len(rejected.RequestPrefix) != SIGNATURE_QUARANTINE_MAX_REQUEST_SIZE || rejected.RequestSize != len(body) {
+		t.Fatalf("expected the request truncated, error: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package delegation_backend
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Names of signature schemes in the registry
+const (
+	// Schnorr signatures over Pallas of the Mina signer
+	SIGNATURE_SCHEME_MINA_SCHNORR = "mina_schnorr"
+	// Ed25519 signatures, the public key being the x coordinate of a Mina
+	// public key with a zero parity byte, for tests only
+	SIGNATURE_SCHEME_ED25519 = "ed25519"
+	// Signatures of the test vectors of -dev, otherwise Ed25519 signatures,
+	// for tests only
+	SIGNATURE_SCHEME_TEST_VECTORS = "test_vectors"
+)
+
+// SignatureScheme verifies signatures of submissions and of delegations.
+// Schemes are selected by network, so that a hard fork changing the
+// signatures of a network is a change of its configuration.
+type SignatureScheme interface {
+	// Name of the scheme in the registry
+	Name() string
+	// Name along with the parameters of the scheme, e.g. in logs
+	String() string
+	Verify(pk *Pk, sig *Sig, data []byte) bool
+	// Check a decoded public key is a key of the scheme, returning a
+	// *KeyEncodingError otherwise
+	ValidatePk(pk Pk) error
+	// Check a decoded signature is a signature of the scheme, returning a
+	// *KeyEncodingError otherwise
+	ValidateSig(sig Sig) error
+}
+
+// Makes the scheme of a network of the network id
+type SignatureSchemeFactory func(networkId uint8) SignatureScheme
+
+type signatureSchemeEntry struct {
+	factory SignatureSchemeFactory
+	// Not to be selected for mainnet
+	testOnly bool
+}
+
+var (
+	signatureSchemesMutex sync.RWMutex
+	signatureSchemes      = map[string]signatureSchemeEntry{
+		SIGNATURE_SCHEME_MINA_SCHNORR: {factory: func(networkId uint8) SignatureScheme { return MinaSchnorr{NetworkId: networkId} }},
+		SIGNATURE_SCHEME_ED25519:      {factory: func(uint8) SignatureScheme { return Ed25519{} }, testOnly: true},
+		SIGNATURE_SCHEME_TEST_VECTORS: {factory: func(uint8) SignatureScheme { return TestVectors{Vectors: DevTestVectors(), Fallback: Ed25519{}} }, testOnly: true},
+	}
+)
+
+// RegisterSignatureScheme adds a scheme to the registry, replacing any
+// scheme of the name
+func RegisterSignatureScheme(name string, factory SignatureSchemeFactory) {
+	signatureSchemesMutex.Lock()
+	defer signatureSchemesMutex.Unlock()
+	signatureSchemes[name] = signatureSchemeEntry{factory: factory}
+}
+
+// NewSignatureScheme makes the scheme of the name for the network id
+func NewSignatureScheme(name string, networkId uint8) (SignatureScheme, error) {
+	signatureSchemesMutex.RLock()
+	entry, ok := signatureSchemes[name]
+	signatureSchemesMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signature scheme %q, expected one of %v", name, SignatureSchemeNames())
+	}
+	return entry.factory(networkId), nil
+}
+
+// Names of the schemes of the registry, sorted
+func SignatureSchemeNames() []string {
+	signatureSchemesMutex.RLock()
+	defer signatureSchemesMutex.RUnlock()
+	names := make([]string, 0, len(signatureSchemes))
+	for name := range signatureSchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isTestOnlySignatureScheme(name string) bool {
+	signatureSchemesMutex.RLock()
+	defer signatureSchemesMutex.RUnlock()
+	return signatureSchemes[name].testOnly
+}
+
+// MinaSchnorr verifies signatures with the Mina signer, the network id
+// selecting the signature prefix
+type MinaSchnorr struct {
+	NetworkId uint8
+}
+
+func (MinaSchnorr) Name() string { return SIGNATURE_SCHEME_MINA_SCHNORR }
+
+func (s MinaSchnorr) String() string {
+	return fmt.Sprintf("%s (network id %d)", SIGNATURE_SCHEME_MINA_SCHNORR, s.NetworkId)
+}
+
+func (s MinaSchnorr) Verify(pk *Pk, sig *Sig, data []byte) bool {
+	return verifySig(pk, sig, data, s.NetworkId)
+}
+
+func (MinaSchnorr) ValidatePk(pk Pk) error { return pk.Validate() }
+
+func (MinaSchnorr) ValidateSig(sig Sig) error { return sig.Validate() }
+
+// Ed25519 verifies Ed25519 signatures, so that tests and local setups can
+// sign submissions without the Mina signer
+type Ed25519 struct{}
+
+func (Ed25519) Name() string { return SIGNATURE_SCHEME_ED25519 }
+
+func (Ed25519) String() string { return SIGNATURE_SCHEME_ED25519 }
+
+func (Ed25519) Verify(pk *Pk, sig *Sig, data []byte) bool {
+	return ed25519.Verify(ed25519.PublicKey(pk[:ed25519.PublicKeySize]), data, sig[:])
+}
+
+func (Ed25519) ValidatePk(pk Pk) error {
+	if pk[ed25519.PublicKeySize] != 0 {
+		return &KeyEncodingError{KEY_KIND_PK, INVALID_KEY_PARITY, "Ed25519 keys have no parity, expected 0"}
+	}
+	return nil
+}
+
+func (Ed25519) ValidateSig(Sig) error { return nil }
@@ -0,0 +1,82 @@
+package delegation_backend
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type constScheme struct{ valid bool }
+
+func (constScheme) Name() string                    { return "const" }
+func (constScheme) String() string                  { return "const" }
+func (s constScheme) Verify(*Pk, *Sig, []byte) bool { return s.valid }
+func (constScheme) ValidatePk(Pk) error             { return nil }
+func (constScheme) ValidateSig(Sig) error           { return nil }
+
+func TestSignatureSchemeRegistry(t *testing.T) {
+	scheme, err := NewSignatureScheme(SIGNATURE_SCHEME_MINA_SCHNORR, 1)
+	if err != nil || scheme != (MinaSchnorr{NetworkId: 1}) {
+		t.Fatalf("unexpected scheme %v, error: %v", scheme, err)
+	}
+	if _, err := NewSignatureScheme("hard_fork", 1); err == nil {
+		t.Fatal("expected an unknown scheme rejected")
+	}
+	RegisterSignatureScheme("hard_fork", func(uint8) SignatureScheme { return constScheme{true} })
+	if scheme, err := NewSignatureScheme("hard_fork", 1); err != nil || scheme.Name() != "const" {
+		t.Fatalf("expected the registered scheme, got %v, error: %v", scheme, err)
+	}
+}
+
+func TestSignatureSchemeConfig(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	mockLogger := &MockLogger{}
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"network_name": "devnet", "delegation_whitelist_disabled": true, "filesystem": {"path": "/tmp"},
+		"signature": {"devnet": {"scheme": "ed25519"}}}`), 0644)
+
+	config := LoadConfig(path, mockLogger)
+	if mockLogger.lastMessage != "" {
+		t.Fatalf("Unexpected fatal error: %s", mockLogger.lastMessage)
+	}
+	for network, name := range map[string]string{"devnet": SIGNATURE_SCHEME_ED25519, "mainnet": SIGNATURE_SCHEME_MINA_SCHNORR} {
+		if scheme, err := config.Signature.Scheme(network); err != nil || scheme.Name() != name {
+			t.Errorf("Expected scheme %s of %s, got %v, error: %v", name, network, scheme, err)
+		}
+	}
+
+	// Test-only schemes can't be selected for mainnet
+	os.Setenv("CONFIG_NETWORK_NAME", "mainnet")
+	os.Setenv("SIGNATURE_SCHEME", SIGNATURE_SCHEME_ED25519)
+	LoadConfig(path, mockLogger)
+	if !strings.Contains(mockLogger.lastMessage, "is for tests only") {
+		t.Errorf("Expected ed25519 rejected for mainnet, got: %s", mockLogger.lastMessage)
+	}
+	os.Setenv("SIGNATURE_SCHEME", "unknown")
+	LoadConfig(path, mockLogger)
+	if !strings.Contains(mockLogger.lastMessage, `unknown signature scheme "unknown"`) {
+		t.Errorf("Expected an unknown scheme rejected, got: %s", mockLogger.lastMessage)
+	}
+}
+
+func TestSubmitEd25519(t *testing.T) {
+	req, body := ed25519Request(t, 0)
+	_, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	tm.time = req.Data.CreatedAt
+	sh.app.SignatureScheme = Ed25519{}
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected the Ed25519 signature verified, got %d: %s", rep.Code, rep.Body)
+	}
+	// Keys of Ed25519 have no parity
+	req.Submitter[ed25519.PublicKeySize] = 1
+	body, _ = json.Marshal(req)
+	rep := sh.testRequest(body)
+	var resp submitErrorResponse
+	if err := json.Unmarshal(rep.Body.Bytes(), &resp); rep.Code != 400 || err != nil || resp.Code != "public_key_parity" {
+		t.Fatalf("expected a key with parity rejected, got %d: %s", rep.Code, rep.Body)
+	}
+}
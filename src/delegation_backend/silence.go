@@ -0,0 +1,260 @@
+package delegation_backend
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Time without submission after which a submitter is silent by default
+const SILENCE_DEFAULT_AFTER = time.Hour
+
+const SILENCE_CHECK_INTERVAL = time.Minute
+
+// Latest submissions of submitters are shared with other replicas for
+// this long
+const SILENCE_SHARED_TTL = 30 * 24 * time.Hour
+
+// Time without submission after which a submitter is silent, slots of the
+// given duration take precedence over minutes
+func (cfg *SilenceConfig) After(slotDuration time.Duration) time.Duration {
+	if cfg.AfterSlots > 0 {
+		return time.Duration(cfg.AfterSlots) * slotDuration
+	}
+	if cfg.AfterMinutes > 0 {
+		return time.Duration(cfg.AfterMinutes) * time.Minute
+	}
+	return SILENCE_DEFAULT_AFTER
+}
+
+// SilentSubmitter without accepted submission for the silence period
+type SilentSubmitter struct {
+	Submitter string `json:"submitter"`
+	// Nil if the submitter wasn't seen since the start of the watch
+	LastSubmission *time.Time `json:"last_submission"`
+	SilentFor      float64    `json:"silent_for_seconds"`
+}
+
+type silentSubmittersResponse struct {
+	SilentAfter float64           `json:"silent_after_seconds"`
+	CheckedAt   time.Time         `json:"checked_at"`
+	Submitters  []SilentSubmitter `json:"submitters"`
+}
+
+// SilenceWatch flags whitelisted submitters without accepted submission
+// for a period, e.g. as their exporter died, rather than finding out once
+// the scoring period is over. Submitters are watched from the start of
+// the watch. Submissions accepted by other replicas are known from the
+// shared state.
+type SilenceWatch struct {
+	After time.Duration
+	// Optional, latest submissions accepted by any replica
+	Shared SharedState
+	// Submitters watched, those seen if it returns nil
+	whitelist func() *Whitelist
+	// Optional, alerts on submitters becoming silent
+	notifier  *Notifier
+	mutex     sync.Mutex
+	started   time.Time
+	checkedAt time.Time
+	last      map[string]time.Time
+	// Silent submitters as of the latest check
+	silent map[string]bool
+	now    nowFunc
+	log    logging.StandardLogger
+}
+
+func NewSilenceWatch(after time.Duration, whitelist func() *Whitelist, notifier *Notifier, now nowFunc, log logging.StandardLogger) *SilenceWatch {
+	return &SilenceWatch{
+		After:     after,
+		whitelist: whitelist,
+		notifier:  notifier,
+		started:   now(),
+		last:      make(map[string]time.Time),
+		silent:    make(map[string]bool),
+		now:       now,
+		log:       log,
+	}
+}
+
+func silenceKey(submitter string) string {
+	return "last_submission:" + submitter
+}
+
+// Accepted records an accepted submission of the submitter
+func (w *SilenceWatch) Accepted(submitter string, at time.Time) {
+	w.mutex.Lock()
+	if at.After(w.last[submitter]) {
+		w.last[submitter] = at
+	}
+	w.mutex.Unlock()
+	if w.Shared != nil {
+		if err := w.Shared.Set(silenceKey(submitter), at.UTC().Format(time.RFC3339Nano), SILENCE_SHARED_TTL); err != nil {
+			w.log.Warnf("Failed to share the latest submission of %s: %v", submitter, err)
+		}
+	}
+}
+
+// Latest submission of the submitter, accepted by any replica, false if
+// not seen since the start of the watch
+func (w *SilenceWatch) lastSubmission(submitter string, now time.Time) (time.Time, bool) {
+	w.mutex.Lock()
+	last, seen := w.last[submitter]
+	w.mutex.Unlock()
+	// Other replicas are asked only about submitters silent here
+	if w.Shared == nil || (seen && now.Sub(last) < w.After) {
+		return last, seen
+	}
+	value, found, err := w.Shared.Get(silenceKey(submitter))
+	if err != nil {
+		w.log.Warnf("Failed to read the latest submission of %s: %v", submitter, err)
+		return last, seen
+	}
+	if !found {
+		return last, seen
+	}
+	shared, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil || !shared.After(last) {
+		return last, seen
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if shared.After(w.last[submitter]) {
+		w.last[submitter] = shared
+	}
+	return shared, true
+}
+
+// Submitters watched, sorted
+func (w *SilenceWatch) watched() []string {
+	var submitters []string
+	if wl := w.whitelist(); wl != nil {
+		for pk := range *wl {
+			submitters = append(submitters, pk.String())
+		}
+	} else {
+		w.mutex.Lock()
+		for submitter := range w.last {
+			submitters = append(submitters, submitter)
+		}
+		w.mutex.Unlock()
+	}
+	sort.Strings(submitters)
+	return submitters
+}
+
+// Check which submitters are silent, alerting on submitters becoming
+// silent and on silent submitters submitting again if notify is set.
+// Returns the submitters which became silent.
+func (w *SilenceWatch) Check(notify bool) []string {
+	now := w.now()
+	silent := make(map[string]bool)
+	for _, submitter := range w.watched() {
+		last, seen := w.lastSubmission(submitter, now)
+		if !seen {
+			last = w.started
+		}
+		if now.Sub(last) >= w.After {
+			silent[submitter] = true
+		}
+	}
+	w.mutex.Lock()
+	previous := w.silent
+	w.silent = silent
+	w.checkedAt = now
+	w.mutex.Unlock()
+
+	var became []string
+	for submitter := range silent {
+		if !previous[submitter] {
+			became = append(became, submitter)
+		}
+	}
+	sort.Strings(became)
+	if !notify || w.notifier == nil {
+		return became
+	}
+	for _, submitter := range became {
+		w.notifier.Notify(Alert{
+			Event:   ALERT_SUBMITTER_SILENT,
+			Subject: submitter,
+			Message: fmt.Sprintf("No submission from %s for %v", submitter, w.After),
+			Fields:  map[string]string{"submitter": submitter},
+		})
+	}
+	for submitter := range previous {
+		if !silent[submitter] {
+			w.notifier.Notify(Alert{
+				Event:    ALERT_SUBMITTER_SILENT,
+				Subject:  submitter,
+				Message:  fmt.Sprintf("Submissions from %s are accepted again", submitter),
+				Resolved: true,
+			})
+		}
+	}
+	return became
+}
+
+// Check every interval, alerting only while leader so that alerts aren't
+// repeated by every replica
+func (w *SilenceWatch) CheckLoop(interval time.Duration, election *LeaderElection) {
+	for {
+		if became := w.Check(election.IsLeader()); len(became) > 0 {
+			w.log.Warnf("%d submitters became silent for %v", len(became), w.After)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Silent submitters as of the latest check, sorted by public key
+func (w *SilenceWatch) Silent() []SilentSubmitter {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	submitters := make([]SilentSubmitter, 0, len(w.silent))
+	for submitter := range w.silent {
+		s := SilentSubmitter{Submitter: submitter}
+		since := w.started
+		if last, seen := w.last[submitter]; seen {
+			s.LastSubmission = &last
+			since = last
+		}
+		s.SilentFor = w.checkedAt.Sub(since).Seconds()
+		submitters = append(submitters, s)
+	}
+	sort.Slice(submitters, func(i, j int) bool { return submitters[i].Submitter < submitters[j].Submitter })
+	return submitters
+}
+
+// Handler serves the silent submitters at /v1/submitters/silent
+func (w *SilenceWatch) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		submitters := w.Silent()
+		w.mutex.Lock()
+		checkedAt := w.checkedAt
+		w.mutex.Unlock()
+		writeJSON(rw, http.StatusOK, silentSubmittersResponse{SilentAfter: w.After.Seconds(), CheckedAt: checkedAt, Submitters: submitters})
+	})
+}
+
+// WritePrometheus writes the number of silent submitters and the latest
+// submission of each
+func (w *SilenceWatch) WritePrometheus(out io.Writer) {
+	submitters := w.Silent()
+	fmt.Fprint(out, "# HELP uptime_silent_submitters Submitters without accepted submission for the silence period.\n")
+	fmt.Fprint(out, "# TYPE uptime_silent_submitters gauge\n")
+	fmt.Fprintf(out, "uptime_silent_submitters %d\n", len(submitters))
+	fmt.Fprint(out, "# HELP uptime_silent_submitter_seconds Time since the latest submission of a silent submitter, or the start of the watch.\n")
+	fmt.Fprint(out, "# TYPE uptime_silent_submitter_seconds gauge\n")
+	for _, s := range submitters {
+		fmt.Fprintf(out, "uptime_silent_submitter_seconds{submitter=\"%s\"} %.0f\n", escapePrometheusLabel(s.Submitter), s.SilentFor)
+	}
+}
@@ -0,0 +1,85 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestSilenceWatch(t *testing.T) {
+	notifier, sink, _ := testNotifier(t, nil)
+	log := logging.Logger("test")
+	a, b := Pk{1}, Pk{2}
+	wl := Whitelist{a: nil, b: nil}
+	start := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	tm := &timeMock{time: start}
+	shared := newMemorySharedState()
+	watch := NewSilenceWatch(time.Hour, func() *Whitelist { return &wl }, notifier, tm.Now, log)
+	watch.Shared = shared
+
+	watch.Accepted(a.String(), start.Add(10*time.Minute))
+	tm.time = start.Add(30 * time.Minute)
+	if became := watch.Check(true); len(became) != 0 {
+		t.Fatalf("expected no silent submitter, got %v", became)
+	}
+	// Submitters not seen are silent once the period passed since the start
+	tm.time = start.Add(65 * time.Minute)
+	if became := watch.Check(true); !reflect.DeepEqual(became, []string{b.String()}) {
+		t.Fatalf("expected %s silent, got %v", b, became)
+	}
+	if alert := expectAlert(t, sink, ALERT_SUBMITTER_SILENT, false); alert.Subject != b.String() {
+		t.Fatalf("unexpected alert %+v", alert)
+	}
+
+	// Submissions accepted by another replica are known from the shared state
+	replica := NewSilenceWatch(time.Hour, func() *Whitelist { return &wl }, nil, tm.Now, log)
+	replica.Shared = shared
+	replica.Accepted(b.String(), start.Add(66*time.Minute))
+	tm.time = start.Add(71 * time.Minute)
+	if became := watch.Check(true); !reflect.DeepEqual(became, []string{a.String()}) {
+		t.Fatalf("expected %s silent, got %v", a, became)
+	}
+	if alert := expectAlert(t, sink, ALERT_SUBMITTER_SILENT, false); alert.Subject != a.String() {
+		t.Fatalf("unexpected alert %+v", alert)
+	}
+	if alert := expectAlert(t, sink, ALERT_SUBMITTER_SILENT, true); alert.Subject != b.String() {
+		t.Fatalf("unexpected alert %+v", alert)
+	}
+
+	rec := httptest.NewRecorder()
+	watch.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/submitters/silent", nil))
+	var resp silentSubmittersResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body)
+	}
+	if len(resp.Submitters) != 1 || resp.Submitters[0].Submitter != a.String() || resp.Submitters[0].SilentFor != 61*60 ||
+		!resp.Submitters[0].LastSubmission.Equal(start.Add(10*time.Minute)) {
+		t.Fatalf("unexpected silent submitters %+v", resp.Submitters)
+	}
+	var metrics strings.Builder
+	watch.WritePrometheus(&metrics)
+	if !strings.Contains(metrics.String(), "uptime_silent_submitters 1\n") {
+		t.Fatalf("unexpected metrics:\n%s", metrics.String())
+	}
+}
+
+func TestSilenceConfigAfter(t *testing.T) {
+	for _, c := range []struct {
+		config SilenceConfig
+		after  time.Duration
+	}{
+		{SilenceConfig{}, SILENCE_DEFAULT_AFTER},
+		{SilenceConfig{AfterMinutes: 30}, 30 * time.Minute},
+		{SilenceConfig{AfterMinutes: 30, AfterSlots: 20}, time.Hour},
+	} {
+		if after := c.config.After(SLOT_DURATION_DEFAULT); after != c.after {
+			t.Errorf("expected %+v to be silent after %v, got %v", c.config, c.after, after)
+		}
+	}
+}
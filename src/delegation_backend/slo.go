@@ -0,0 +1,227 @@
+package delegation_backend
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Defaults of the latency SLO: 99% of requests within a second,
+// over the last hour
+const (
+	SLO_DEFAULT_LATENCY_TARGET = time.Second
+	SLO_DEFAULT_OBJECTIVE      = 0.99
+	SLO_DEFAULT_WINDOW         = time.Hour
+)
+
+// Most samples kept per endpoint, the oldest are dropped beyond it
+const SLO_MAX_SAMPLES = 100000
+
+const SLO_REPORT_INTERVAL = time.Hour
+
+type sloSample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// EndpointSLO is the latency and error budget of an endpoint over the window
+type EndpointSLO struct {
+	Endpoint string `json:"endpoint"`
+	Requests int    `json:"requests"`
+	// Requests responded with a 5xx status
+	Errors int `json:"errors"`
+	// Requests slower than the latency target
+	Slow  int     `json:"slow"`
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	P99Ms float64 `json:"p99_ms"`
+	// Ratio of requests neither failed nor slow
+	Compliance float64 `json:"compliance"`
+	// Ratio of the error budget left, negative once exhausted
+	ErrorBudgetRemaining float64 `json:"error_budget_remaining"`
+	MeetingSLO           bool    `json:"meeting_slo"`
+}
+
+// SLOReport is the state of the SLO of all endpoints
+type SLOReport struct {
+	LatencyTargetMs float64       `json:"latency_target_ms"`
+	Objective       float64       `json:"objective"`
+	WindowSeconds   float64       `json:"window_seconds"`
+	Endpoints       []EndpointSLO `json:"endpoints"`
+}
+
+// SLOTracker keeps a rolling window of request latencies per endpoint.
+// A request counts against the error budget if it is responded with
+// a 5xx status or is slower than the latency target.
+type SLOTracker struct {
+	mutex         sync.Mutex
+	latencyTarget time.Duration
+	objective     float64
+	window        time.Duration
+	samples       map[string][]sloSample
+	now           nowFunc
+}
+
+func NewSLOTracker(latencyTarget time.Duration, objective float64, window time.Duration, now nowFunc) *SLOTracker {
+	return &SLOTracker{
+		latencyTarget: latencyTarget,
+		objective:     objective,
+		window:        window,
+		samples:       make(map[string][]sloSample),
+		now:           now,
+	}
+}
+
+// NewSLOTrackerFromConfig creates the tracker, with defaults
+// for settings missing from the config (which can be nil)
+func NewSLOTrackerFromConfig(cfg *SLOConfig, now nowFunc) *SLOTracker {
+	latencyTarget, objective, window := SLO_DEFAULT_LATENCY_TARGET, SLO_DEFAULT_OBJECTIVE, SLO_DEFAULT_WINDOW
+	if cfg != nil {
+		if cfg.LatencyTargetMs > 0 {
+			latencyTarget = time.Duration(cfg.LatencyTargetMs) * time.Millisecond
+		}
+		if cfg.Objective > 0 && cfg.Objective < 1 {
+			objective = cfg.Objective
+		}
+		if cfg.WindowMinutes > 0 {
+			window = time.Duration(cfg.WindowMinutes) * time.Minute
+		}
+	}
+	return NewSLOTracker(latencyTarget, objective, window, now)
+}
+
+// Drop samples that fell out of the window, samples are ordered by time
+func (t *SLOTracker) prune(endpoint string, now time.Time) {
+	samples := t.samples[endpoint]
+	i := sort.Search(len(samples), func(i int) bool { return now.Sub(samples[i].at) < t.window })
+	if i == len(samples) {
+		delete(t.samples, endpoint)
+	} else if i > 0 {
+		t.samples[endpoint] = append(samples[:0], samples[i:]...)
+	}
+}
+
+// Record a request to the endpoint
+func (t *SLOTracker) Record(endpoint string, latency time.Duration, status int) {
+	now := t.now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.prune(endpoint, now)
+	samples := t.samples[endpoint]
+	if len(samples) >= SLO_MAX_SAMPLES {
+		samples = append(samples[:0], samples[len(samples)-SLO_MAX_SAMPLES+1:]...)
+	}
+	t.samples[endpoint] = append(samples, sloSample{at: now, latency: latency, failed: status >= 500})
+}
+
+// Nearest-rank percentile of sorted latencies, in milliseconds
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return float64(sorted[rank-1].Microseconds()) / 1000
+}
+
+// Report computes the SLO of all endpoints with requests in the window
+func (t *SLOTracker) Report() SLOReport {
+	now := t.now()
+	report := SLOReport{
+		LatencyTargetMs: float64(t.latencyTarget.Milliseconds()),
+		Objective:       t.objective,
+		WindowSeconds:   t.window.Seconds(),
+		Endpoints:       []EndpointSLO{},
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for endpoint := range t.samples {
+		t.prune(endpoint, now)
+	}
+	for endpoint, samples := range t.samples {
+		e := EndpointSLO{Endpoint: endpoint, Requests: len(samples)}
+		latencies := make([]time.Duration, len(samples))
+		bad := 0
+		for i, s := range samples {
+			latencies[i] = s.latency
+			slow := s.latency > t.latencyTarget
+			if s.failed {
+				e.Errors++
+			}
+			if slow {
+				e.Slow++
+			}
+			if s.failed || slow {
+				bad++
+			}
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		e.P50Ms = percentileMs(latencies, 0.5)
+		e.P95Ms = percentileMs(latencies, 0.95)
+		e.P99Ms = percentileMs(latencies, 0.99)
+		e.Compliance = float64(e.Requests-bad) / float64(e.Requests)
+		e.ErrorBudgetRemaining = 1 - float64(bad)/((1-t.objective)*float64(e.Requests))
+		e.MeetingSLO = e.Compliance >= t.objective
+		report.Endpoints = append(report.Endpoints, e)
+	}
+	sort.Slice(report.Endpoints, func(i, j int) bool { return report.Endpoints[i].Endpoint < report.Endpoints[j].Endpoint })
+	return report
+}
+
+// Middleware records requests by the pattern of the mux they are routed
+// to, so that endpoints are not multiplied by arbitrary paths.
+func (t *SLOTracker) Middleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, endpoint := mux.Handler(r)
+		start := t.now()
+		rec := &accessLogRecorder{ResponseWriter: rw}
+		defer func() {
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			t.Record(endpoint, t.now().Sub(start), status)
+		}()
+		mux.ServeHTTP(rec, r)
+	})
+}
+
+// Handler serves the SLO report at /v1/stats/slo
+func (t *SLOTracker) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		writeJSON(rw, http.StatusOK, t.Report())
+	})
+}
+
+// Log the SLO of every endpoint
+func (t *SLOTracker) LogReport(log logging.StandardLogger) {
+	report := t.Report()
+	for _, e := range report.Endpoints {
+		msg := "SLO of %s: %d requests, %d errors, %d slow, p50 %.1fms, p95 %.1fms, p99 %.1fms, error budget remaining %.1f%%"
+		args := []interface{}{e.Endpoint, e.Requests, e.Errors, e.Slow, e.P50Ms, e.P95Ms, e.P99Ms, e.ErrorBudgetRemaining * 100}
+		if e.MeetingSLO {
+			log.Infof(msg, args...)
+		} else {
+			log.Warnf(msg, args...)
+		}
+	}
+}
+
+// Log the SLO every interval.
+func (t *SLOTracker) ReportLoop(log logging.StandardLogger, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		t.LogReport(log)
+	}
+}
@@ -0,0 +1,73 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSLOReport(t *testing.T) {
+	tm := &timeMock{time: time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)}
+	tracker := NewSLOTracker(time.Second, 0.9, time.Hour, tm.Now)
+	for i := 1; i <= 100; i++ {
+		tracker.Record("/v1/submit", time.Duration(i*15)*time.Millisecond, http.StatusOK)
+	}
+	tracker.Record("/health", time.Millisecond, http.StatusServiceUnavailable)
+
+	report := tracker.Report()
+	if len(report.Endpoints) != 2 || report.Endpoints[0].Endpoint != "/health" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	health, submit := report.Endpoints[0], report.Endpoints[1]
+	if health.Errors != 1 || health.MeetingSLO || health.ErrorBudgetRemaining >= 0 {
+		t.Fatalf("unexpected SLO of /health: %+v", health)
+	}
+	if submit.P50Ms != 750 || submit.P95Ms != 1425 || submit.P99Ms != 1485 {
+		t.Fatalf("unexpected percentiles: %+v", submit)
+	}
+	// 34 of 100 requests are slower than a second, over the budget of 10
+	if submit.Slow != 34 || submit.Compliance != 0.66 || submit.MeetingSLO {
+		t.Fatalf("unexpected SLO of /v1/submit: %+v", submit)
+	}
+
+	// Samples fall out of the window
+	tm.time = tm.time.Add(30 * time.Minute)
+	tracker.Record("/health", time.Millisecond, http.StatusOK)
+	tm.time = tm.time.Add(45 * time.Minute)
+	report = tracker.Report()
+	if len(report.Endpoints) != 1 || report.Endpoints[0].Requests != 1 || !report.Endpoints[0].MeetingSLO {
+		t.Fatalf("unexpected report after window: %+v", report)
+	}
+	if report.Endpoints[0].ErrorBudgetRemaining != 1 {
+		t.Fatalf("unexpected error budget: %+v", report.Endpoints[0])
+	}
+}
+
+func TestSLOMiddleware(t *testing.T) {
+	tm := &timeMock{time: time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)}
+	tracker := NewSLOTrackerFromConfig(nil, tm.Now)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/submit", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {})
+	handler := tracker.Middleware(mux)
+	for _, path := range []string{"/v1/submit", "/a", "/b/c"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", path, nil))
+	}
+
+	rr := httptest.NewRecorder()
+	tracker.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/stats/slo", nil))
+	var report SLOReport
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.LatencyTargetMs != 1000 || report.Objective != SLO_DEFAULT_OBJECTIVE || len(report.Endpoints) != 2 {
+		t.Fatalf("unexpected report: %s", rr.Body.String())
+	}
+	if report.Endpoints[0].Endpoint != "/" || report.Endpoints[0].Requests != 2 || report.Endpoints[1].Errors != 1 {
+		t.Fatalf("unexpected endpoints: %+v", report.Endpoints)
+	}
+}
@@ -0,0 +1,106 @@
+package delegation_backend
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Consensus constants of mainnet, the defaults of network timings
+const (
+	SLOT_DURATION_DEFAULT   = 3 * time.Minute
+	SLOTS_PER_EPOCH_DEFAULT = 7140
+)
+
+// NetworkTiming derives the global slot and the epoch of submissions from
+// their created_at, with the genesis timestamp of the network
+type NetworkTiming struct {
+	genesis       time.Time
+	slotDuration  time.Duration
+	slotsPerEpoch int
+	// Skew of created_at allowed around the slot claimed by the client
+	maxSkew time.Duration
+}
+
+func NewNetworkTiming(config *NetworkTimingConfig) (*NetworkTiming, error) {
+	if config.GenesisTimestamp == "" {
+		return nil, fmt.Errorf("genesis_timestamp isn't set")
+	}
+	genesis, err := time.Parse(time.RFC3339, config.GenesisTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid genesis_timestamp: %w", err)
+	}
+	t := &NetworkTiming{
+		genesis:       genesis,
+		slotDuration:  time.Duration(config.SlotDurationMs) * time.Millisecond,
+		slotsPerEpoch: config.SlotsPerEpoch,
+		maxSkew:       time.Duration(config.MaxSkewSeconds) * time.Second,
+	}
+	if t.slotDuration == 0 {
+		t.slotDuration = SLOT_DURATION_DEFAULT
+	} else if t.slotDuration < 0 {
+		return nil, fmt.Errorf("slot_duration_ms is negative: %d", config.SlotDurationMs)
+	}
+	if t.slotsPerEpoch == 0 {
+		t.slotsPerEpoch = SLOTS_PER_EPOCH_DEFAULT
+	} else if t.slotsPerEpoch < 0 {
+		return nil, fmt.Errorf("slots_per_epoch is negative: %d", config.SlotsPerEpoch)
+	}
+	if t.maxSkew == 0 {
+		t.maxSkew = t.slotDuration
+	} else if t.maxSkew < 0 {
+		return nil, fmt.Errorf("max_skew_seconds is negative: %d", config.MaxSkewSeconds)
+	}
+	return t, nil
+}
+
+// Slot of the time, false if before the genesis
+func (t *NetworkTiming) Slot(at time.Time) (int, bool) {
+	if at.Before(t.genesis) {
+		return 0, false
+	}
+	return int(at.Sub(t.genesis) / t.slotDuration), true
+}
+
+func (t *NetworkTiming) SlotDuration() time.Duration {
+	return t.slotDuration
+}
+
+func (t *NetworkTiming) Epoch(slot int) int {
+	return slot / t.slotsPerEpoch
+}
+
+// Start of the first slot of the epoch
+func (t *NetworkTiming) EpochStart(epoch int) time.Time {
+	return t.genesis.Add(time.Duration(epoch*t.slotsPerEpoch) * t.slotDuration)
+}
+
+// Plausible tells if a submission created at createdAt may have been made
+// at the slot, within the skew allowed
+func (t *NetworkTiming) Plausible(slot int, createdAt time.Time) bool {
+	if slot < 0 {
+		return false
+	}
+	start := t.genesis.Add(time.Duration(slot) * t.slotDuration)
+	end := start.Add(t.slotDuration)
+	return !createdAt.Before(start.Add(-t.maxSkew)) && createdAt.Before(end.Add(t.maxSkew))
+}
+
+// Problems of network timing configurations, by network
+func validateNetworkTimings(configs NetworkTimingConfigs) []string {
+	networks := make([]string, 0, len(configs))
+	for network := range configs {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	var problems []string
+	for _, network := range networks {
+		if configs[network] == nil {
+			continue
+		}
+		if _, err := NewNetworkTiming(configs[network]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", network, err))
+		}
+	}
+	return problems
+}
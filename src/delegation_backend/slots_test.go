@@ -0,0 +1,104 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Genesis of mainnet, created_at of req-with-snark is in slot 59013 of
+// epoch 8
+const mainnetGenesisTimestamp = "2021-03-17T00:00:00Z"
+
+func TestNetworkTiming(t *testing.T) {
+	timing, err := NewNetworkTiming(&NetworkTimingConfig{GenesisTimestamp: mainnetGenesisTimestamp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2021, 7, 17, 22, 39, 48, 0, time.UTC)
+	if slot, ok := timing.Slot(at); !ok || slot != 59013 || timing.Epoch(slot) != 8 {
+		t.Fatalf("expected slot 59013 of epoch 8, got %d of epoch %d", slot, timing.Epoch(slot))
+	}
+	if _, ok := timing.Slot(time.Date(2021, 3, 16, 0, 0, 0, 0, time.UTC)); ok {
+		t.Fatal("expected no slot before the genesis")
+	}
+	// A slot of skew is allowed by default
+	for slot, plausible := range map[int]bool{59011: false, 59012: true, 59013: true, 59014: true, 59015: false, -1: false} {
+		if timing.Plausible(slot, at) != plausible {
+			t.Errorf("expected slot %d plausible: %v", slot, plausible)
+		}
+	}
+
+	for _, config := range []NetworkTimingConfig{
+		{},
+		{GenesisTimestamp: "2021-03-17"},
+		{GenesisTimestamp: mainnetGenesisTimestamp, SlotDurationMs: -1},
+		{GenesisTimestamp: mainnetGenesisTimestamp, SlotsPerEpoch: -1},
+	} {
+		if _, err := NewNetworkTiming(&config); err == nil {
+			t.Errorf("expected %+v rejected", config)
+		}
+	}
+}
+
+func TestSubmitGlobalSlot(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	storage, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	tm.time = req.Data.CreatedAt
+	timing, err := NewNetworkTiming(&NetworkTimingConfig{GenesisTimestamp: mainnetGenesisTimestamp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.app.NetworkTiming = timing
+
+	slot := 59020
+	req.GlobalSlot = &slot
+	implausible, _ := json.Marshal(req)
+	if rep := sh.testRequest(implausible); rep.Code != 400 || len(*storage) != 0 {
+		t.Fatalf("expected created_at implausible for the slot rejected, got %d", rep.Code)
+	}
+
+	// Derived from created_at without a global slot
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected the submission accepted, got %d: %s", rep.Code, rep.Body)
+	}
+	meta := testSavedMeta(storage, t)
+	if meta.GlobalSlot == nil || *meta.GlobalSlot != 59013 || meta.Epoch == nil || *meta.Epoch != 8 {
+		t.Fatalf("expected slot 59013 of epoch 8 recorded, got %v of epoch %v", meta.GlobalSlot, meta.Epoch)
+	}
+
+	// The slot of the client is recorded, within the skew allowed
+	slot = 59014
+	plausible, _ := json.Marshal(req)
+	// Past the rate limit of the submitter
+	tm.time = tm.time.Add(2 * time.Hour)
+	if rep := sh.testRequest(plausible); rep.Code != 200 {
+		t.Fatalf("expected the submission accepted, got %d: %s", rep.Code, rep.Body)
+	}
+	if meta := testSavedMeta(storage, t); *meta.GlobalSlot != 59014 {
+		t.Fatalf("expected the slot of the client recorded, got %d", *meta.GlobalSlot)
+	}
+}
+
+// Metadata saved by the latest submission, emptying storage
+func testSavedMeta(storage *ObjectsToSave, t *testing.T) *MetaToBeSaved {
+	var meta *MetaToBeSaved
+	for path, bs := range *storage {
+		if strings.HasPrefix(path, "submissions/") {
+			meta = new(MetaToBeSaved)
+			if err := json.Unmarshal(bs, meta); err != nil {
+				t.Fatal(err)
+			}
+		}
+		delete(*storage, path)
+	}
+	if meta == nil {
+		t.Fatal("expected metadata saved")
+	}
+	return meta
+}
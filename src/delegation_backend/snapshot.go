@@ -0,0 +1,153 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Storage prefix of snapshots of the state of the service,
+// `snapshots/<date>/<time>.json`, the latest also saved to SNAPSHOT_LATEST
+const SNAPSHOTS_PREFIX = "snapshots/"
+
+const SNAPSHOT_LATEST = SNAPSHOTS_PREFIX + "latest.json"
+
+// Version of the format of snapshots, snapshots of other versions aren't
+// restored
+const SNAPSHOT_VERSION = 1
+
+// ServiceSnapshot of the operational state an instance keeps in memory, so
+// that an instance restarted or relocated resumes with the limits of the
+// previous one. State kept in the shared state across replicas is already
+// consistent, it isn't part of snapshots. Write batches are flushed on
+// shutdown rather than snapshotted.
+type ServiceSnapshot struct {
+	Version int       `json:"version"`
+	Network string    `json:"network"`
+	TakenAt time.Time `json:"taken_at"`
+	// Attempts of the last hour by submitter, for the hourly limit
+	Attempts map[string][]time.Time `json:"attempts"`
+	// Latest accepted `created_at` by submitter, if replay protection is
+	// enabled
+	Replay map[string]time.Time `json:"replay,omitempty"`
+	// Keys tracked by the signature lockout, if enabled
+	Lockouts map[string]LockoutSnapshot `json:"lockouts,omitempty"`
+	// Public keys of the whitelist, unless disabled or not loaded yet
+	Whitelist []string `json:"whitelist,omitempty"`
+}
+
+// Path of the snapshot in object storages
+func (s *ServiceSnapshot) Path() string {
+	at := s.TakenAt.UTC().Format(time.RFC3339)
+	return SNAPSHOTS_PREFIX + at[:10] + "/" + at + ".json"
+}
+
+// SnapshotRestore counts what was restored from a snapshot
+type SnapshotRestore struct {
+	Attempts  int  `json:"attempts"`
+	Replay    int  `json:"replay"`
+	Lockouts  int  `json:"lockouts"`
+	Whitelist bool `json:"whitelist"`
+}
+
+// ServiceState takes snapshots of the state of the app and restores them
+type ServiceState struct {
+	App     *App
+	Network string
+}
+
+func (s *ServiceState) Snapshot() *ServiceSnapshot {
+	snapshot := &ServiceSnapshot{Version: SNAPSHOT_VERSION, Network: s.Network, TakenAt: s.App.Now().UTC()}
+	if s.App.SubmitCounter != nil {
+		snapshot.Attempts = s.App.SubmitCounter.Snapshot()
+	}
+	if s.App.ReplayGuard != nil {
+		snapshot.Replay = s.App.ReplayGuard.Snapshot()
+	}
+	if s.App.SignatureLockout != nil {
+		snapshot.Lockouts = s.App.SignatureLockout.Snapshot()
+	}
+	if s.App.Whitelist != nil {
+		if wl := s.App.Whitelist.ReadWhitelist(); wl != nil {
+			for pk := range *wl {
+				snapshot.Whitelist = append(snapshot.Whitelist, pk.String())
+			}
+			sort.Strings(snapshot.Whitelist)
+		}
+	}
+	return snapshot
+}
+
+// Restore a snapshot of the same network. The whitelist is restored only
+// if none was loaded yet, a whitelist loaded since is more recent.
+func (s *ServiceState) Restore(snapshot *ServiceSnapshot) (*SnapshotRestore, error) {
+	if snapshot.Version != SNAPSHOT_VERSION {
+		return nil, fmt.Errorf("unsupported snapshot version %d, expected %d", snapshot.Version, SNAPSHOT_VERSION)
+	}
+	if snapshot.Network != s.Network {
+		return nil, fmt.Errorf("snapshot of network %q, expected %q", snapshot.Network, s.Network)
+	}
+	var whitelist Whitelist
+	if len(snapshot.Whitelist) > 0 {
+		whitelist = make(Whitelist, len(snapshot.Whitelist))
+		for _, pkStr := range snapshot.Whitelist {
+			var pk Pk
+			if err := StringToPk(&pk, pkStr); err != nil {
+				return nil, fmt.Errorf("invalid public key %s in whitelist: %w", pkStr, err)
+			}
+			whitelist[pk] = true
+		}
+	}
+	restored := &SnapshotRestore{}
+	if s.App.SubmitCounter != nil {
+		if err := s.App.SubmitCounter.Restore(snapshot.Attempts); err != nil {
+			return nil, err
+		}
+		restored.Attempts = len(snapshot.Attempts)
+	}
+	if s.App.ReplayGuard != nil {
+		if err := s.App.ReplayGuard.Restore(snapshot.Replay); err != nil {
+			return restored, err
+		}
+		restored.Replay = len(snapshot.Replay)
+	}
+	if s.App.SignatureLockout != nil {
+		s.App.SignatureLockout.Restore(snapshot.Lockouts)
+		restored.Lockouts = len(snapshot.Lockouts)
+	}
+	if s.App.Whitelist != nil && whitelist != nil && s.App.Whitelist.ReadWhitelist() == nil {
+		s.App.Whitelist.Replace(&whitelist)
+		restored.Whitelist = true
+	}
+	return restored, nil
+}
+
+// AdminHandler serves snapshots of the state:
+//
+//	GET /admin/snapshot  takes a snapshot
+//	PUT /admin/snapshot  restores the snapshot of the body
+func (s *ServiceState) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(rw, http.StatusOK, s.Snapshot())
+		case http.MethodPut:
+			var snapshot ServiceSnapshot
+			if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 1<<28)).Decode(&snapshot); err != nil {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{"Error decoding payload"})
+				return
+			}
+			restored, err := s.Restore(&snapshot)
+			if err != nil {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{err.Error()})
+				return
+			}
+			incMetric("snapshots_restored")
+			writeJSON(rw, http.StatusOK, restored)
+		default:
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+		}
+	})
+}
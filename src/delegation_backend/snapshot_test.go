@@ -0,0 +1,84 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newSnapshotApp(tm *timeMock) *App {
+	app := &App{Now: tm.Now, SubmitCounter: NewAttemptCounter(2), Whitelist: new(WhitelistMVar)}
+	app.SubmitCounter.now = tm.Now
+	app.ReplayGuard, _ = NewReplayGuard("")
+	app.SignatureLockout = NewSignatureLockout(1, time.Hour, time.Hour, tm.Now)
+	return app
+}
+
+func TestServiceSnapshot(t *testing.T) {
+	var pk, other Pk
+	if err := StringToPk(&pk, "B62qkaKV3BLvLTf7nYXRehSaZAd36NWijt3MEmy2QgHRavboeRGtBMN"); err != nil {
+		t.Fatal(err)
+	}
+	StringToPk(&other, "B62qkgvrx8WoDqTzXaUm2vqBRbkiqTts35Z9n4XcrFez1KG3MHKHTbn")
+	tm := &timeMock{time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	app := newSnapshotApp(tm)
+	app.SubmitCounter.RecordAttempt(pk)
+	tm.time = tm.time.Add(30 * time.Minute)
+	app.SubmitCounter.RecordAttempt(pk)
+	app.ReplayGuard.Commit(pk, tm.time)
+	app.SignatureLockout.RecordFailure(LOCKOUT_KEY_IP + "1.2.3.4")
+	app.Whitelist.Replace(&Whitelist{pk: true, other: true})
+
+	state := &ServiceState{App: app, Network: "mainnet"}
+	rec := httptest.NewRecorder()
+	state.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ADMIN_API_PREFIX+"snapshot", nil))
+	var snapshot ServiceSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Attempts[pk.String()]) != 2 || len(snapshot.Replay) != 1 || len(snapshot.Lockouts) != 1 || len(snapshot.Whitelist) != 2 {
+		t.Fatalf("unexpected snapshot %s", rec.Body.String())
+	}
+	if snapshot.Path() != "snapshots/2024-05-01/2024-05-01T12:30:00Z.json" {
+		t.Fatalf("unexpected path %s", snapshot.Path())
+	}
+
+	// A restarted instance resumes with the limits of the snapshot
+	restarted := newSnapshotApp(tm)
+	body := rec.Body.Bytes()
+	rec = httptest.NewRecorder()
+	(&ServiceState{App: restarted, Network: "mainnet"}).AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, ADMIN_API_PREFIX+"snapshot", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"attempts":1,"replay":1,"lockouts":1,"whitelist":true}`+"\n" {
+		t.Fatalf("unexpected restore %d %s", rec.Code, rec.Body.String())
+	}
+	if restarted.SubmitCounter.RecordAttempt(pk) || !restarted.SubmitCounter.RecordAttempt(other) {
+		t.Error("expected the hourly limit of the snapshot enforced")
+	}
+	if restarted.ReplayGuard.Commit(pk, tm.time) {
+		t.Error("expected a replayed submission rejected")
+	}
+	if _, locked := restarted.SignatureLockout.LockedFor(LOCKOUT_KEY_IP + "1.2.3.4"); !locked {
+		t.Error("expected the lockout restored")
+	}
+	if wl := restarted.Whitelist.ReadWhitelist(); wl == nil || len(*wl) != 2 {
+		t.Error("expected the whitelist restored")
+	}
+
+	// Attempts older than an hour don't count anymore
+	tm.time = tm.time.Add(45 * time.Minute)
+	if !restarted.SubmitCounter.RecordAttempt(pk) {
+		t.Error("expected the first attempt of the snapshot expired")
+	}
+
+	// Whitelists loaded since are more recent than the snapshot
+	restarted.Whitelist.Replace(&Whitelist{other: true})
+	if restored, err := (&ServiceState{App: restarted, Network: "mainnet"}).Restore(&snapshot); err != nil || restored.Whitelist {
+		t.Fatalf("unexpected restore %+v, error: %v", restored, err)
+	}
+	if _, err := (&ServiceState{App: restarted, Network: "devnet"}).Restore(&snapshot); err == nil {
+		t.Fatal("expected an error restoring a snapshot of another network")
+	}
+}
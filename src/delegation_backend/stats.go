@@ -0,0 +1,326 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Submitter statistics cover submissions of the last STATS_WINDOW_DAYS
+// days (including today) unless configured otherwise, submitters not
+// seen within the window are dropped.
+const STATS_WINDOW_DAYS = 7
+
+// Submissions of new submitters are not counted once this many are tracked
+const STATS_MAX_SUBMITTERS = 100000
+
+const STATS_SNAPSHOT_INTERVAL = 10 * time.Minute
+
+// Storage prefix under which snapshots of submitter statistics are saved
+const STATS_PREFIX = "stats/submitters/"
+
+type submitterDay struct {
+	Accepted int64            `json:"accepted,omitempty"`
+	Rejected map[string]int64 `json:"rejected,omitempty"`
+}
+
+type submitterCounters struct {
+	// Counters by UTC date
+	Days         map[string]*submitterDay `json:"days"`
+	LastSeen     time.Time                `json:"last_seen"`
+	LastAccepted time.Time                `json:"last_accepted"`
+}
+
+// SubmitterSummary is the statistics of a submitter over the window
+type SubmitterSummary struct {
+	Submitter    string           `json:"submitter"`
+	Accepted     int64            `json:"accepted"`
+	Rejected     map[string]int64 `json:"rejected,omitempty"`
+	LastSeen     time.Time        `json:"last_seen"`
+	LastAccepted *time.Time       `json:"last_accepted,omitempty"`
+}
+
+type submitterStatsResponse struct {
+	WindowDays int                `json:"window_days"`
+	Submitters []SubmitterSummary `json:"submitters"`
+}
+
+// SubmitterStats keeps daily counters of accepted and rejected
+// submissions of every submitter, with snapshots written to
+// the state file (restored on start) and to storage.
+type SubmitterStats struct {
+	mutex      sync.Mutex
+	submitters map[string]*submitterCounters
+	windowDays int
+	stateFile  string
+	now        nowFunc
+}
+
+// NewSubmitterStats creates the statistics, restoring them from stateFile
+// if the file exists. Empty stateFile keeps the statistics in memory only.
+func NewSubmitterStats(stateFile string, now nowFunc) (*SubmitterStats, error) {
+	s := &SubmitterStats{submitters: make(map[string]*submitterCounters), windowDays: STATS_WINDOW_DAYS, stateFile: stateFile, now: now}
+	if stateFile == "" {
+		return s, nil
+	}
+	bs, err := os.ReadFile(stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading submitter statistics: %w", err)
+	}
+	if err := json.Unmarshal(bs, &s.submitters); err != nil {
+		return nil, fmt.Errorf("error decoding submitter statistics: %w", err)
+	}
+	return s, nil
+}
+
+// Rejection reasons are messages of the error responses, details
+// after a colon are dropped to keep the number of reasons bounded.
+func rejectionReason(reason string) string {
+	reason, _, _ = strings.Cut(reason, ":")
+	return reason
+}
+
+func statsDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// SetWindowDays changes the number of days covered by the statistics,
+// counters of days before a shortened window are dropped on next prune.
+func (s *SubmitterStats) SetWindowDays(days int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.windowDays = days
+}
+
+func (s *SubmitterStats) WindowDays() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.windowDays
+}
+
+// First day of the window ending at now, the mutex must be held
+func (s *SubmitterStats) windowStart() string {
+	return statsDay(s.now().AddDate(0, 0, -(s.windowDays - 1)))
+}
+
+// Record counts the submission attempt described by the audit record
+func (s *SubmitterStats) Record(rec *AuditRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	counters, exists := s.submitters[rec.Submitter]
+	if !exists {
+		if len(s.submitters) >= STATS_MAX_SUBMITTERS {
+			incMetric("stats_submitters_dropped")
+			return
+		}
+		counters = &submitterCounters{Days: make(map[string]*submitterDay)}
+		s.submitters[rec.Submitter] = counters
+	}
+	day := statsDay(rec.Time)
+	dayCounters, exists := counters.Days[day]
+	if !exists {
+		dayCounters = &submitterDay{}
+		counters.Days[day] = dayCounters
+	}
+	if rec.Result == AUDIT_RESULT_ACCEPTED {
+		dayCounters.Accepted++
+		counters.LastAccepted = rec.Time
+	} else {
+		if dayCounters.Rejected == nil {
+			dayCounters.Rejected = make(map[string]int64)
+		}
+		dayCounters.Rejected[rejectionReason(rec.Reason)]++
+	}
+	if rec.Time.After(counters.LastSeen) {
+		counters.LastSeen = rec.Time
+	}
+}
+
+// HasAccepted tells whether an accepted submission of the submitter was
+// recorded within the window
+func (s *SubmitterStats) HasAccepted(submitter string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	counters, exists := s.submitters[submitter]
+	return exists && !counters.LastAccepted.IsZero()
+}
+
+// Prune drops counters of days before the window
+// and submitters not seen within the window.
+func (s *SubmitterStats) Prune() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	start := s.windowStart()
+	for submitter, counters := range s.submitters {
+		for day := range counters.Days {
+			if day < start {
+				delete(counters.Days, day)
+			}
+		}
+		if statsDay(counters.LastSeen) < start {
+			delete(s.submitters, submitter)
+		}
+	}
+}
+
+// Summaries returns the statistics of all submitters, sorted by submitter
+func (s *SubmitterStats) Summaries() []SubmitterSummary {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	start := s.windowStart()
+	summaries := make([]SubmitterSummary, 0, len(s.submitters))
+	for submitter, counters := range s.submitters {
+		if statsDay(counters.LastSeen) < start {
+			continue
+		}
+		summary := SubmitterSummary{Submitter: submitter, LastSeen: counters.LastSeen}
+		if !counters.LastAccepted.IsZero() {
+			lastAccepted := counters.LastAccepted
+			summary.LastAccepted = &lastAccepted
+		}
+		for day, dayCounters := range counters.Days {
+			if day < start {
+				continue
+			}
+			summary.Accepted += dayCounters.Accepted
+			for reason, n := range dayCounters.Rejected {
+				if summary.Rejected == nil {
+					summary.Rejected = make(map[string]int64)
+				}
+				summary.Rejected[reason] += n
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Submitter < summaries[j].Submitter })
+	return summaries
+}
+
+func (s *SubmitterStats) snapshot() ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return json.Marshal(s.submitters)
+}
+
+// Persist prunes the statistics and writes a snapshot to the state
+// file, and to storage as `stats/submitters/<date>/<time>.json`
+// if save is not nil.
+func (s *SubmitterStats) Persist(save func(ObjectsToSave)) error {
+	s.Prune()
+	bs, err := s.snapshot()
+	if err != nil {
+		return fmt.Errorf("error encoding submitter statistics: %w", err)
+	}
+	if save != nil {
+		at := s.now().UTC().Format(time.RFC3339)
+		save(ObjectsToSave{STATS_PREFIX + at[:10] + "/" + at + ".json": bs})
+	}
+	if s.stateFile != "" {
+		if err := writeFileAtomically(s.stateFile, bs); err != nil {
+			return fmt.Errorf("error writing submitter statistics: %w", err)
+		}
+	}
+	return nil
+}
+
+// Periodically persist the statistics until the context is done.
+func (s *SubmitterStats) PersistLoop(ctx context.Context, interval time.Duration, save func(ObjectsToSave), log logging.StandardLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Persist(save); err != nil {
+				log.Errorf("Failed to persist submitter statistics: %v", err)
+			}
+		}
+	}
+}
+
+// Handler serves the statistics of submitters:
+//
+//	GET /v1/stats/submitters                     all submitters seen within the window
+//	GET /v1/stats/submitters?submitter=<pk>      a single submitter
+//	GET /v1/stats/submitters?inactive_for=24h    submitters without accepted submission in the last 24h
+func (s *SubmitterStats) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		query := r.URL.Query()
+		var inactiveSince time.Time
+		if inactiveFor := query.Get("inactive_for"); inactiveFor != "" {
+			d, err := time.ParseDuration(inactiveFor)
+			if err != nil || d <= 0 {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{"Invalid inactive_for, expected a duration like 24h"})
+				return
+			}
+			inactiveSince = s.now().Add(-d)
+		}
+		submitter := query.Get("submitter")
+		summaries := make([]SubmitterSummary, 0)
+		for _, summary := range s.Summaries() {
+			if submitter != "" && summary.Submitter != submitter {
+				continue
+			}
+			if !inactiveSince.IsZero() && summary.LastAccepted != nil && summary.LastAccepted.After(inactiveSince) {
+				continue
+			}
+			summaries = append(summaries, summary)
+		}
+		writeJSON(rw, http.StatusOK, submitterStatsResponse{WindowDays: s.WindowDays(), Submitters: summaries})
+	})
+}
+
+// WritePrometheus writes the statistics in the Prometheus text format
+func (s *SubmitterStats) WritePrometheus(w io.Writer) {
+	summaries := s.Summaries()
+	fmt.Fprint(w, "# HELP uptime_submitter_accepted Submissions accepted within the statistics window.\n")
+	fmt.Fprint(w, "# TYPE uptime_submitter_accepted gauge\n")
+	for _, summary := range summaries {
+		fmt.Fprintf(w, "uptime_submitter_accepted{submitter=\"%s\"} %d\n", escapePrometheusLabel(summary.Submitter), summary.Accepted)
+	}
+	fmt.Fprint(w, "# HELP uptime_submitter_rejected Submissions rejected within the statistics window, by reason.\n")
+	fmt.Fprint(w, "# TYPE uptime_submitter_rejected gauge\n")
+	for _, summary := range summaries {
+		reasons := make([]string, 0, len(summary.Rejected))
+		for reason := range summary.Rejected {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "uptime_submitter_rejected{submitter=\"%s\",reason=\"%s\"} %d\n",
+				escapePrometheusLabel(summary.Submitter), escapePrometheusLabel(reason), summary.Rejected[reason])
+		}
+	}
+	fmt.Fprint(w, "# HELP uptime_submitter_last_seen_timestamp_seconds Time of the latest submission attempt.\n")
+	fmt.Fprint(w, "# TYPE uptime_submitter_last_seen_timestamp_seconds gauge\n")
+	for _, summary := range summaries {
+		fmt.Fprintf(w, "uptime_submitter_last_seen_timestamp_seconds{submitter=\"%s\"} %d\n",
+			escapePrometheusLabel(summary.Submitter), summary.LastSeen.Unix())
+	}
+	fmt.Fprint(w, "# HELP uptime_submitter_last_accepted_timestamp_seconds Time of the latest accepted submission.\n")
+	fmt.Fprint(w, "# TYPE uptime_submitter_last_accepted_timestamp_seconds gauge\n")
+	for _, summary := range summaries {
+		if summary.LastAccepted != nil {
+			fmt.Fprintf(w, "uptime_submitter_last_accepted_timestamp_seconds{submitter=\"%s\"} %d\n",
+				escapePrometheusLabel(summary.Submitter), summary.LastAccepted.Unix())
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubmitterStats(t *testing.T) {
+	tm := &timeMock{time: time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)}
+	stats, err := NewSubmitterStats("", tm.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := tm.time.AddDate(0, 0, -STATS_WINDOW_DAYS)
+	stats.Record(&AuditRecord{Time: old, Submitter: "gone", Result: AUDIT_RESULT_ACCEPTED})
+	stats.Record(&AuditRecord{Time: old, Submitter: "active", Result: AUDIT_RESULT_ACCEPTED})
+	stats.Record(&AuditRecord{Time: tm.time.Add(-time.Hour), Submitter: "active", Result: AUDIT_RESULT_ACCEPTED})
+	stats.Record(&AuditRecord{Time: tm.time, Submitter: "active", Result: AUDIT_RESULT_REJECTED, Reason: "Delegation is not valid: expired"})
+	stats.Record(&AuditRecord{Time: tm.time, Submitter: "active", Result: AUDIT_RESULT_REJECTED, Reason: "Delegation is not valid: not yet valid"})
+
+	summaries := stats.Summaries()
+	if len(summaries) != 1 {
+		t.Fatalf("expected only the active submitter, got %+v", summaries)
+	}
+	s := summaries[0]
+	if s.Accepted != 1 || s.Rejected["Delegation is not valid"] != 2 || !s.LastSeen.Equal(tm.time) ||
+		s.LastAccepted == nil || !s.LastAccepted.Equal(tm.time.Add(-time.Hour)) {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+
+	stats.Prune()
+	if _, has := stats.submitters["gone"]; has {
+		t.Fatal("submitter outside of the window not pruned")
+	}
+	if len(stats.submitters["active"].Days) != 1 {
+		t.Fatalf("days outside of the window not pruned: %v", stats.submitters["active"].Days)
+	}
+}
+
+func TestSubmitterStatsPersist(t *testing.T) {
+	tm := &timeMock{time: time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)}
+	path := filepath.Join(t.TempDir(), "stats.json")
+	stats, err := NewSubmitterStats(path, tm.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats.Record(&AuditRecord{Time: tm.time, Submitter: "B62q", Result: AUDIT_RESULT_ACCEPTED})
+	storage := make(ObjectsToSave)
+	if err := stats.Persist(func(objs ObjectsToSave) {
+		for p, bs := range objs {
+			storage[p] = bs
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, has := storage["stats/submitters/2024-05-10/2024-05-10T12:00:00Z.json"]; !has {
+		t.Fatalf("snapshot not saved: %v", storage)
+	}
+
+	restored, err := NewSubmitterStats(path, tm.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summaries := restored.Summaries(); len(summaries) != 1 || summaries[0].Accepted != 1 {
+		t.Fatalf("unexpected restored statistics: %+v", summaries)
+	}
+}
+
+func TestSubmitterStatsHandlers(t *testing.T) {
+	tm := &timeMock{time: time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)}
+	stats, _ := NewSubmitterStats("", tm.Now)
+	stats.Record(&AuditRecord{Time: tm.time.AddDate(0, 0, -3), Submitter: "stopped", Result: AUDIT_RESULT_ACCEPTED})
+	stats.Record(&AuditRecord{Time: tm.time, Submitter: "stopped", Result: AUDIT_RESULT_REJECTED, Reason: "Invalid signature"})
+	stats.Record(&AuditRecord{Time: tm.time, Submitter: "running", Result: AUDIT_RESULT_ACCEPTED})
+
+	get := func(url string) (int, submitterStatsResponse) {
+		rr := httptest.NewRecorder()
+		stats.Handler().ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		var resp submitterStatsResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+	if code, resp := get("/v1/stats/submitters"); code != 200 || len(resp.Submitters) != 2 || resp.WindowDays != STATS_WINDOW_DAYS {
+		t.Fatalf("unexpected response %d: %+v", code, resp)
+	}
+	if _, resp := get("/v1/stats/submitters?inactive_for=24h"); len(resp.Submitters) != 1 || resp.Submitters[0].Submitter != "stopped" {
+		t.Fatalf("expected only the stopped submitter, got %+v", resp)
+	}
+	if _, resp := get("/v1/stats/submitters?submitter=running"); len(resp.Submitters) != 1 || resp.Submitters[0].Accepted != 1 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if code, _ := get("/v1/stats/submitters?inactive_for=week"); code != 400 {
+		t.Fatalf("expected 400, got %d", code)
+	}
+
+	rr := httptest.NewRecorder()
+	PrometheusHandler(stats).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+	for _, line := range []string{
+		`uptime_submitter_accepted{submitter="running"} 1`,
+		`uptime_submitter_rejected{submitter="stopped",reason="Invalid signature"} 1`,
+		`uptime_submitter_last_seen_timestamp_seconds{submitter="stopped"} 1715342400`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("expected %s in:\n%s", line, body)
+		}
+	}
+}
+
+func TestSubmitRecordsStats(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, tm := testSubmitH(10, Whitelist{req.Submitter: true})
+	stats, _ := NewSubmitterStats("", tm.Now)
+	sh.app.SubmitterStats = stats
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected submission accepted, got %v", rep)
+	}
+	badReq := req
+	badReq.Sig[0] ^= 1
+	badBody, _ := json.Marshal(badReq)
+	if rep := sh.testRequest(badBody); rep.Code != 401 {
+		t.Fatalf("expected invalid signature rejected, got %v", rep)
+	}
+	// Keys of unregistered submitters aren't counted
+	unregistered := req
+	unregistered.Submitter = mkPk()
+	unregisteredBody, _ := json.Marshal(unregistered)
+	if rep := sh.testRequest(unregisteredBody); rep.Code != 401 {
+		t.Fatalf("expected unregistered submitter rejected, got %v", rep)
+	}
+
+	summaries := stats.Summaries()
+	if len(summaries) != 1 || summaries[0].Submitter != req.Submitter.String() || summaries[0].Accepted != 1 || len(summaries[0].Rejected) != 1 {
+		t.Fatalf("unexpected statistics: %+v", summaries)
+	}
+}
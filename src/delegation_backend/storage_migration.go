@@ -0,0 +1,333 @@
+package delegation_backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Submissions copied between saves of the progress of a migration
+const MIGRATION_PROGRESS_INTERVAL = 100
+
+// StorageSource reads submissions and blocks of an object storage,
+// stored as by the submit handler
+type StorageSource interface {
+	// Days of submissions stored, sorted
+	Days(ctx context.Context) ([]string, error)
+	// Paths of the submissions of the day, sorted by time of submission
+	Submissions(ctx context.Context, day string) ([]string, error)
+	// Read the object at the path, relative to the root of the storage.
+	// The error of a missing object wraps fs.ErrNotExist.
+	Read(ctx context.Context, path string) ([]byte, error)
+}
+
+// LocalFileSystemSource reads submissions of local filesystem storage
+type LocalFileSystemSource struct {
+	Directory string
+}
+
+func (l LocalFileSystemSource) Days(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(l.Directory, SUBMISSIONS_PREFIX))
+	if err != nil {
+		return nil, err
+	}
+	// Entries are sorted by name
+	var days []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			days = append(days, entry.Name())
+		}
+	}
+	return days, nil
+}
+
+func (l LocalFileSystemSource) Submissions(ctx context.Context, day string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(l.Directory, SUBMISSIONS_PREFIX, day))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			paths = append(paths, path.Join("submissions", day, entry.Name()))
+		}
+	}
+	return paths, nil
+}
+
+func (l LocalFileSystemSource) Read(ctx context.Context, path string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(l.Directory, filepath.FromSlash(path)))
+}
+
+// S3Source reads submissions of the bucket under the prefix of the network
+type S3Source struct {
+	Aws *AwsContext
+}
+
+func (s *S3Source) Days(ctx context.Context) ([]string, error) {
+	root := s.Aws.Prefix + "/" + SUBMISSIONS_PREFIX
+	paginator := s3.NewListObjectsV2Paginator(s.Aws.Client, &s3.ListObjectsV2Input{
+		Bucket:    s.Aws.BucketName,
+		Prefix:    aws.String(root),
+		Delimiter: aws.String("/"),
+	})
+	var days []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, prefix := range page.CommonPrefixes {
+			days = append(days, strings.TrimSuffix(strings.TrimPrefix(aws.ToString(prefix.Prefix), root), "/"))
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+func (s *S3Source) Submissions(ctx context.Context, day string) ([]string, error) {
+	root := s.Aws.Prefix + "/"
+	paginator := s3.NewListObjectsV2Paginator(s.Aws.Client, &s3.ListObjectsV2Input{
+		Bucket: s.Aws.BucketName,
+		Prefix: aws.String(root + SUBMISSIONS_PREFIX + day + "/"),
+	})
+	var paths []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Keys are listed in order
+		for _, obj := range page.Contents {
+			if key := aws.ToString(obj.Key); strings.HasSuffix(key, ".json") {
+				paths = append(paths, strings.TrimPrefix(key, root))
+			}
+		}
+	}
+	return paths, nil
+}
+
+func (s *S3Source) Read(ctx context.Context, path string) ([]byte, error) {
+	out, err := s.Aws.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: s.Aws.BucketName,
+		Key:    aws.String(s.Aws.Prefix + "/" + path),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+// MigrationProgress of a storage migration, saved to resume it
+type MigrationProgress struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Path of the latest submission copied (or failed), the migration
+	// resumes after it
+	Last          string `json:"last,omitempty"`
+	Submissions   int    `json:"submissions"`
+	Objects       int    `json:"objects"`
+	Bytes         int64  `json:"bytes"`
+	MissingBlocks int    `json:"missing_blocks"`
+	// Submissions which failed to be copied or verified
+	Failed    []string  `json:"failed,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StorageMigration copies submissions, along with their blocks, from a
+// storage to another in order of submission. Progress is saved to a file
+// every MIGRATION_PROGRESS_INTERVAL submissions and once stopped, so that
+// a migration interrupted resumes where it stopped. Submissions copied
+// since the latest save are copied again, saves of every backend are
+// idempotent.
+type StorageMigration struct {
+	From   string
+	To     string
+	Source StorageSource
+	Save   func(ObjectsToSave) error
+	// Optional, objects saved are read back from it and compared by SHA-256
+	Verify StorageSource
+	// Blocks are copied with the first submission referencing them only,
+	// for destinations which don't read the block of every submission
+	DedupBlocks bool
+	// Optional, file of the progress
+	ProgressFile string
+	// Submissions copied per second, no limit if zero
+	Rate   float64
+	Filter ReplayFilter
+	Log    logging.StandardLogger
+}
+
+// Load the progress of the migration, a new one if there is no progress file
+func (m *StorageMigration) loadProgress() (*MigrationProgress, error) {
+	return loadMigrationProgress(m.ProgressFile, m.From, m.To)
+}
+
+func (m *StorageMigration) saveProgress(progress *MigrationProgress) error {
+	return saveMigrationProgress(m.ProgressFile, progress)
+}
+
+// Load the progress of a migration from the progress file, if any, a new
+// progress if there is none
+func loadMigrationProgress(progressFile string, from string, to string) (*MigrationProgress, error) {
+	progress := &MigrationProgress{From: from, To: to}
+	if progressFile == "" {
+		return progress, nil
+	}
+	bs, err := os.ReadFile(progressFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return progress, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, progress); err != nil {
+		return nil, fmt.Errorf("error parsing progress file %s: %w", progressFile, err)
+	}
+	if progress.From != from || progress.To != to {
+		return nil, fmt.Errorf("progress file %s is of a migration from %s to %s", progressFile, progress.From, progress.To)
+	}
+	return progress, nil
+}
+
+func saveMigrationProgress(progressFile string, progress *MigrationProgress) error {
+	if progressFile == "" {
+		return nil
+	}
+	progress.UpdatedAt = time.Now()
+	bs, err := json.MarshalIndent(progress, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(progressFile, bs)
+}
+
+// Run the migration until all submissions are copied or ctx is done,
+// returning its progress. Submissions failing to be copied are logged
+// and listed in the progress, they don't stop the migration.
+func (m *StorageMigration) Run(ctx context.Context) (*MigrationProgress, error) {
+	progress, err := m.loadProgress()
+	if err != nil {
+		return nil, err
+	}
+	if progress.Last != "" {
+		m.Log.Infof("Resuming migration after %s, %d submissions copied so far", progress.Last, progress.Submissions)
+	}
+	days, err := m.Source.Days(ctx)
+	if err != nil {
+		return progress, fmt.Errorf("error listing days of submissions: %w", err)
+	}
+	resumeDay, _ := submissionDay(progress.Last)
+	copiedBlocks := make(map[string]bool)
+	var interval time.Duration
+	if m.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / m.Rate)
+	}
+	next := time.Now()
+	unsaved := 0
+	for _, day := range days {
+		if !m.Filter.includes(day) || day < resumeDay {
+			continue
+		}
+		paths, err := m.Source.Submissions(ctx, day)
+		if err != nil {
+			m.saveProgress(progress)
+			return progress, fmt.Errorf("error listing submissions of %s: %w", day, err)
+		}
+		for _, metaPath := range paths {
+			if metaPath <= progress.Last {
+				continue
+			}
+			if interval > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Until(next)):
+				}
+				next = next.Add(interval)
+				if now := time.Now(); next.Before(now) {
+					next = now
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				if saveErr := m.saveProgress(progress); saveErr != nil {
+					m.Log.Errorf("Failed to save progress: %v", saveErr)
+				}
+				return progress, err
+			}
+			if err := m.copy(ctx, metaPath, progress, copiedBlocks); err != nil {
+				m.Log.Errorf("Failed to copy submission %s: %v", metaPath, err)
+				progress.Failed = append(progress.Failed, metaPath)
+			}
+			progress.Last = metaPath
+			if unsaved++; unsaved >= MIGRATION_PROGRESS_INTERVAL {
+				if err := m.saveProgress(progress); err != nil {
+					return progress, fmt.Errorf("error saving progress: %w", err)
+				}
+				unsaved = 0
+			}
+		}
+	}
+	return progress, m.saveProgress(progress)
+}
+
+// Copy the submission and its block, then verify the objects copied
+func (m *StorageMigration) copy(ctx context.Context, metaPath string, progress *MigrationProgress, copiedBlocks map[string]bool) error {
+	objs, missingBlock, err := readStoredSubmission(ctx, m.Source, metaPath)
+	if err != nil {
+		return err
+	}
+	if missingBlock {
+		m.Log.Warnf("Block of submission %s not found, copying the submission only", metaPath)
+		progress.MissingBlocks++
+	}
+	if m.DedupBlocks {
+		for path := range objs {
+			if copiedBlocks[path] {
+				delete(objs, path)
+			}
+		}
+	}
+	if err := m.Save(objs); err != nil {
+		return err
+	}
+	if m.Verify != nil {
+		for path, bs := range objs {
+			saved, err := m.Verify.Read(ctx, path)
+			if err != nil {
+				return fmt.Errorf("error verifying %s: %w", path, err)
+			}
+			if sha256.Sum256(saved) != sha256.Sum256(bs) {
+				return fmt.Errorf("checksum of %s copied doesn't match", path)
+			}
+		}
+	}
+	for path, bs := range objs {
+		if strings.HasPrefix(path, BLOCKS_PREFIX) {
+			copiedBlocks[path] = true
+		}
+		progress.Objects++
+		progress.Bytes += int64(len(bs))
+	}
+	progress.Submissions++
+	return nil
+}
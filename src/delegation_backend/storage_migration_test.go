@@ -0,0 +1,105 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func migrationTestStorage(t *testing.T) string {
+	dir := t.TempDir()
+	if err := LocalFileSystemSave(ObjectsToSave{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NK1"}`),
+		"submissions/2024-05-01/2024-05-01T11:00:00Z-B62qb.json": []byte(`{"block_hash":"3NK1"}`),
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NK2"}`),
+		"submissions/2024-05-02/2024-05-02T11:00:00Z-B62qb.json": []byte(`{"block_hash":"3NK3"}`),
+		"blocks/3NK1.dat": []byte("block1"),
+		"blocks/3NK2.dat": []byte("block2"),
+	}, dir, logging.Logger("test")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestStorageMigration(t *testing.T) {
+	source := migrationTestStorage(t)
+	destination := t.TempDir()
+	log := logging.Logger("test")
+	migration := &StorageMigration{
+		From:   "s3",
+		To:     "filesystem",
+		Source: LocalFileSystemSource{Directory: source},
+		Save: func(objs ObjectsToSave) error {
+			return LocalFileSystemSave(objs, destination, log)
+		},
+		Verify:       LocalFileSystemSource{Directory: destination},
+		DedupBlocks:  true,
+		ProgressFile: filepath.Join(t.TempDir(), "progress.json"),
+		Log:          log,
+	}
+	progress, err := migration.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first block is copied once
+	if progress.Submissions != 4 || progress.Objects != 6 || progress.MissingBlocks != 1 || len(progress.Failed) != 0 {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+	if bs, err := os.ReadFile(filepath.Join(destination, "blocks", "3NK2.dat")); err != nil || string(bs) != "block2" {
+		t.Fatalf("expected the block copied: %v", err)
+	}
+
+	// Progress of another migration isn't resumed
+	other := *migration
+	other.To = "postgresql"
+	if _, err := other.Run(context.Background()); err == nil {
+		t.Fatal("expected the progress of another migration rejected")
+	}
+}
+
+func TestStorageMigrationResume(t *testing.T) {
+	source := migrationTestStorage(t)
+	var saved []ObjectsToSave
+	failing := true
+	migration := &StorageMigration{
+		From:   "filesystem",
+		To:     "postgresql",
+		Source: LocalFileSystemSource{Directory: source},
+		Save: func(objs ObjectsToSave) error {
+			if _, exists := objs["submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json"]; exists && failing {
+				return errors.New("storage unavailable")
+			}
+			saved = append(saved, objs)
+			return nil
+		},
+		ProgressFile: filepath.Join(t.TempDir(), "progress.json"),
+		Filter:       ReplayFilter{Until: "2024-05-02"},
+		Log:          logging.Logger("test"),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := migration.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the migration interrupted, got %v", err)
+	}
+	progress, err := migration.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Submissions != 3 || len(progress.Failed) != 1 || progress.Last != "submissions/2024-05-02/2024-05-02T11:00:00Z-B62qb.json" {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+	// Without dedup, databases get the block of every submission
+	if len(saved) != 3 || len(saved[1]) != 2 {
+		t.Fatalf("unexpected objects saved %v", saved)
+	}
+
+	// Resumed after the last submission, nothing is left to copy
+	failing = false
+	if progress, err = migration.Run(context.Background()); err != nil || progress.Submissions != 3 || len(saved) != 3 {
+		t.Fatalf("expected nothing copied again, got %+v (error: %v)", progress, err)
+	}
+}
@@ -0,0 +1,228 @@
+package delegation_backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+const STORAGE_USAGE_DEFAULT_INTERVAL = 6 * time.Hour
+
+const SUBMISSIONS_PREFIX = "submissions/"
+
+const BLOCKS_PREFIX = "blocks/"
+
+// UsageCount is the number of objects and their total size
+type UsageCount struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// StorageUsage of a backend, by top-level prefix (e.g. `blocks/`)
+// and by day of submissions.
+type StorageUsage struct {
+	Backend          string                `json:"backend"`
+	MeasuredAt       time.Time             `json:"measured_at"`
+	DurationMs       float64               `json:"duration_ms"`
+	Error            string                `json:"error,omitempty"`
+	Prefixes         map[string]UsageCount `json:"prefixes"`
+	SubmissionsByDay map[string]UsageCount `json:"submissions_by_day"`
+}
+
+func newStorageUsage(backend string) *StorageUsage {
+	return &StorageUsage{
+		Backend:          backend,
+		Prefixes:         make(map[string]UsageCount),
+		SubmissionsByDay: make(map[string]UsageCount),
+	}
+}
+
+// Count objects under the path, relative to the root of the storage
+func (u *StorageUsage) add(path string, objects int64, bytes int64) {
+	prefix := ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		prefix = path[:i+1]
+	}
+	c := u.Prefixes[prefix]
+	u.Prefixes[prefix] = UsageCount{Objects: c.Objects + objects, Bytes: c.Bytes + bytes}
+	if prefix == SUBMISSIONS_PREFIX && len(path) >= len(SUBMISSIONS_PREFIX)+10 {
+		day := path[len(SUBMISSIONS_PREFIX) : len(SUBMISSIONS_PREFIX)+10]
+		c := u.SubmissionsByDay[day]
+		u.SubmissionsByDay[day] = UsageCount{Objects: c.Objects + objects, Bytes: c.Bytes + bytes}
+	}
+}
+
+// UsageMeter measures the usage of a storage backend
+type UsageMeter interface {
+	MeasureUsage(ctx context.Context, usage *StorageUsage) error
+}
+
+// MeasureUsage lists all objects under the prefix of the bucket
+func (ctx *AwsContext) MeasureUsage(c context.Context, usage *StorageUsage) error {
+	root := ctx.Prefix + "/"
+	paginator := s3.NewListObjectsV2Paginator(ctx.Client, &s3.ListObjectsV2Input{
+		Bucket: ctx.BucketName,
+		Prefix: aws.String(root),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(c)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			usage.add(strings.TrimPrefix(aws.ToString(obj.Key), root), 1, obj.Size)
+		}
+	}
+	return nil
+}
+
+// LocalFileSystemUsage measures the files of local filesystem storage
+type LocalFileSystemUsage struct {
+	Directory string
+}
+
+func (l LocalFileSystemUsage) MeasureUsage(ctx context.Context, usage *StorageUsage) error {
+	return filepath.WalkDir(l.Directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.Directory, path)
+		if err != nil {
+			return err
+		}
+		usage.add(filepath.ToSlash(rel), 1, info.Size())
+		return nil
+	})
+}
+
+// MeasureUsage counts rows of the submissions table by day,
+// the size of rows is as stored by PostgreSQL.
+func (ctx *PostgreSQLContext) MeasureUsage(c context.Context, usage *StorageUsage) error {
+	rows, err := ctx.DB.QueryContext(c, `SELECT submitted_at_date, COUNT(*), COALESCE(SUM(pg_column_size(s.*)), 0)
+		FROM submissions s GROUP BY submitted_at_date`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day time.Time
+		var count, bytes int64
+		if err := rows.Scan(&day, &count, &bytes); err != nil {
+			return err
+		}
+		usage.add(SUBMISSIONS_PREFIX+day.Format("2006-01-02")+"/", count, bytes)
+	}
+	return rows.Err()
+}
+
+// StorageUsageMonitor periodically measures the usage of storage backends.
+// AWS Keyspaces is not measured, as counting rows there requires a scan
+// of the whole table.
+type StorageUsageMonitor struct {
+	mutex  sync.Mutex
+	meters map[string]UsageMeter
+	usage  map[string]*StorageUsage
+	now    nowFunc
+	log    logging.StandardLogger
+}
+
+func NewStorageUsageMonitor(meters map[string]UsageMeter, now nowFunc, log logging.StandardLogger) *StorageUsageMonitor {
+	return &StorageUsageMonitor{meters: meters, usage: make(map[string]*StorageUsage), now: now, log: log}
+}
+
+// Measure the usage of all backends, one after another
+func (m *StorageUsageMonitor) Measure(ctx context.Context) {
+	for backend, meter := range m.meters {
+		usage := newStorageUsage(backend)
+		usage.MeasuredAt = m.now()
+		if err := meter.MeasureUsage(ctx, usage); err != nil {
+			incMetric("storage_usage_errors")
+			m.log.Errorf("Failed to measure usage of %s storage: %v", backend, err)
+			usage.Error = err.Error()
+		}
+		usage.DurationMs = float64(m.now().Sub(usage.MeasuredAt).Microseconds()) / 1000
+		m.mutex.Lock()
+		m.usage[backend] = usage
+		m.mutex.Unlock()
+	}
+}
+
+// Measure now and then every interval.
+func (m *StorageUsageMonitor) MeasureLoop(ctx context.Context, interval time.Duration) {
+	for {
+		m.Measure(ctx)
+		time.Sleep(interval)
+	}
+}
+
+// Usage returns the latest usage of all backends, sorted by backend
+func (m *StorageUsageMonitor) Usage() []StorageUsage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	usage := make([]StorageUsage, 0, len(m.usage))
+	for _, u := range m.usage {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Backend < usage[j].Backend })
+	return usage
+}
+
+// Handler serves the latest usage of storage backends at /v1/stats/storage
+func (m *StorageUsageMonitor) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		writeJSON(rw, http.StatusOK, map[string][]StorageUsage{"backends": m.Usage()})
+	})
+}
+
+// WritePrometheus writes usage by top-level prefix in the Prometheus text
+// format, usage by day of submissions is only served by the handler.
+func (m *StorageUsageMonitor) WritePrometheus(w io.Writer) {
+	usage := m.Usage()
+	for _, metric := range []struct {
+		name, help string
+		value      func(UsageCount) int64
+	}{
+		{"uptime_storage_objects", "Objects stored, by backend and top-level prefix.", func(c UsageCount) int64 { return c.Objects }},
+		{"uptime_storage_bytes", "Bytes stored, by backend and top-level prefix.", func(c UsageCount) int64 { return c.Bytes }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", metric.name, metric.help, metric.name)
+		for _, u := range usage {
+			prefixes := make([]string, 0, len(u.Prefixes))
+			for prefix := range u.Prefixes {
+				prefixes = append(prefixes, prefix)
+			}
+			sort.Strings(prefixes)
+			for _, prefix := range prefixes {
+				fmt.Fprintf(w, "%s{backend=\"%s\",prefix=\"%s\"} %d\n", metric.name,
+					escapePrometheusLabel(u.Backend), escapePrometheusLabel(prefix), metric.value(u.Prefixes[prefix]))
+			}
+		}
+	}
+	fmt.Fprint(w, "# HELP uptime_storage_usage_measured_timestamp_seconds Time of the latest measurement of storage usage.\n")
+	fmt.Fprint(w, "# TYPE uptime_storage_usage_measured_timestamp_seconds gauge\n")
+	for _, u := range usage {
+		fmt.Fprintf(w, "uptime_storage_usage_measured_timestamp_seconds{backend=\"%s\"} %d\n", escapePrometheusLabel(u.Backend), u.MeasuredAt.Unix())
+	}
+}
@@ -0,0 +1,68 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+type failingMeter struct{}
+
+func (failingMeter) MeasureUsage(context.Context, *StorageUsage) error {
+	return errors.New("access denied")
+}
+
+func TestStorageUsage(t *testing.T) {
+	dir := t.TempDir()
+	log := logging.Logger("test")
+	if err := LocalFileSystemSave(ObjectsToSave{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": []byte("12345"),
+		"submissions/2024-05-01/2024-05-01T11:00:00Z-B62qb.json": []byte("123"),
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json": []byte("1"),
+		"blocks/3NK.dat": []byte("1234567890"),
+	}, dir, log); err != nil {
+		t.Fatal(err)
+	}
+	tm := &timeMock{time: time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)}
+	monitor := NewStorageUsageMonitor(map[string]UsageMeter{
+		"filesystem": LocalFileSystemUsage{Directory: dir},
+		"s3":         failingMeter{},
+	}, tm.Now, log)
+	monitor.Measure(context.Background())
+
+	usage := monitor.Usage()
+	if len(usage) != 2 || usage[0].Backend != "filesystem" || usage[1].Error != "access denied" {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+	fsUsage := usage[0]
+	if fsUsage.Prefixes["submissions/"] != (UsageCount{3, 9}) || fsUsage.Prefixes["blocks/"] != (UsageCount{1, 10}) {
+		t.Fatalf("unexpected usage by prefix: %+v", fsUsage.Prefixes)
+	}
+	if fsUsage.SubmissionsByDay["2024-05-01"] != (UsageCount{2, 8}) || fsUsage.SubmissionsByDay["2024-05-02"] != (UsageCount{1, 1}) {
+		t.Fatalf("unexpected usage by day: %+v", fsUsage.SubmissionsByDay)
+	}
+
+	rr := httptest.NewRecorder()
+	monitor.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/stats/storage", nil))
+	var resp map[string][]StorageUsage
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || len(resp["backends"]) != 2 {
+		t.Fatalf("unexpected response: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	PrometheusHandler(monitor).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`uptime_storage_objects{backend="filesystem",prefix="submissions/"} 3`,
+		`uptime_storage_bytes{backend="filesystem",prefix="blocks/"} 10`,
+	} {
+		if !strings.Contains(rr.Body.String(), line+"\n") {
+			t.Fatalf("expected %s in:\n%s", line, rr.Body.String())
+		}
+	}
+}
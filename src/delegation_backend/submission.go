@@ -1,6 +1,7 @@
 package delegation_backend
 
 import (
+	"block_producers_uptime/metadata"
 	"encoding/json"
 	"fmt"
 	"path/filepath"
@@ -9,17 +10,38 @@ import (
 )
 
 type Submission struct {
-	BlockHash          string    `json:"block_hash"`
-	SubmittedAtDate    string    // Extracted from filePath
-	SubmittedAt        time.Time // Extracted from filePath and parsed
-	CreatedAt          time.Time `json:"created_at"`
-	RemoteAddr         string    `json:"remote_addr"`
-	PeerId             string    `json:"peer_id"`
-	Submitter          string    `json:"submitter"` // is base58check-encoded submitter's public key
-	RawBlock           []byte    `json:"raw_block,omitempty"`
-	SnarkWork          []byte    `json:"snark_work,omitempty"`
-	GraphqlControlPort int       `json:"graphql_control_port,omitempty"`
-	BuiltWithCommitSha string    `json:"built_with_commit_sha,omitempty"`
+	SchemaVersion      int                 `json:"schema_version,omitempty"`
+	BlockHash          string              `json:"block_hash"`
+	SubmittedAtDate    string              // Extracted from filePath
+	SubmittedAt        time.Time           // Extracted from filePath and parsed
+	CreatedAt          time.Time           `json:"created_at"`
+	RemoteAddr         string              `json:"remote_addr"`
+	PeerId             string              `json:"peer_id"`
+	Submitter          string              `json:"submitter"` // is base58check-encoded submitter's public key
+	RawBlock           []byte              `json:"raw_block,omitempty"`
+	SnarkWork          []byte              `json:"snark_work,omitempty"`
+	GraphqlControlPort int                 `json:"graphql_control_port,omitempty"`
+	BuiltWithCommitSha string              `json:"built_with_commit_sha,omitempty"`
+	GlobalSlot         *int                `json:"global_slot,omitempty"`
+	Epoch              *int                `json:"epoch,omitempty"`
+	Telemetry          *metadata.Telemetry `json:"telemetry,omitempty"`
+}
+
+// Values of the peer_count, sync_status, snark_pool_size and
+// daemon_version columns of databases, nil if not reported
+func (submission *Submission) telemetryValues() []interface{} {
+	t := submission.Telemetry
+	if t == nil {
+		return []interface{}{nil, nil, nil, nil}
+	}
+	values := []interface{}{t.PeerCount, nil, t.SnarkPoolSize, nil}
+	if t.SyncStatus != "" {
+		values[1] = t.SyncStatus
+	}
+	if t.DaemonVersion != "" {
+		values[3] = t.DaemonVersion
+	}
+	return values
 }
 
 type Block struct {
@@ -55,6 +77,10 @@ func parseSubmissionBytes(data []byte, filePath string) (*Submission, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshaling submission JSON: %w", err)
 	}
+	// Fields of later versions of the schema may have changed meaning
+	if submission.SchemaVersion > metadata.SCHEMA_VERSION {
+		return nil, fmt.Errorf("%w %d of submission %s", metadata.ErrUnsupportedVersion, submission.SchemaVersion, filePath)
+	}
 
 	// Populate additional fields from filePath
 	submission.SubmittedAtDate = submittedAtDate
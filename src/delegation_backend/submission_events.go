@@ -0,0 +1,212 @@
+package delegation_backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+const SUBMISSION_EVENT_ACCEPTED = "submission_accepted"
+
+const SUBMISSION_EVENTS_QUEUE_SIZE = 1000
+const SUBMISSION_EVENT_SEND_TIMEOUT = 10 * time.Second
+
+// SubmissionEvent is published once a submission is saved to every
+// backend. Keys are those of the bucket if S3 is configured, paths
+// relative to the root of the storage otherwise.
+type SubmissionEvent struct {
+	Event       string    `json:"event"`
+	Network     string    `json:"network"`
+	Submitter   string    `json:"submitter"`
+	BlockHash   string    `json:"block_hash"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	CreatedAt   string    `json:"created_at"`
+	Bucket      string    `json:"bucket,omitempty"`
+	MetaKey     string    `json:"meta_key"`
+	BlockKey    string    `json:"block_key"`
+}
+
+// EventPublisher delivers events to an AWS service
+type EventPublisher interface {
+	Name() string
+	// Publish the message, groupId and dedupId are set for FIFO
+	// destinations
+	Publish(ctx context.Context, message []byte, groupId string, dedupId string) error
+}
+
+// SNSPublisher publishes events to an SNS topic
+type SNSPublisher struct {
+	TopicArn string
+	Client   *sns.Client
+}
+
+func (p *SNSPublisher) Name() string { return "sns" }
+
+func (p *SNSPublisher) Publish(ctx context.Context, message []byte, groupId string, dedupId string) error {
+	input := &sns.PublishInput{
+		TopicArn: aws.String(p.TopicArn),
+		Message:  aws.String(string(message)),
+	}
+	if strings.HasSuffix(p.TopicArn, ".fifo") {
+		input.MessageGroupId = aws.String(groupId)
+		input.MessageDeduplicationId = aws.String(dedupId)
+	}
+	_, err := p.Client.Publish(ctx, input)
+	return err
+}
+
+// SQSPublisher sends events to an SQS queue
+type SQSPublisher struct {
+	QueueURL string
+	Client   *sqs.Client
+}
+
+func (p *SQSPublisher) Name() string { return "sqs" }
+
+func (p *SQSPublisher) Publish(ctx context.Context, message []byte, groupId string, dedupId string) error {
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(p.QueueURL),
+		MessageBody: aws.String(string(message)),
+	}
+	if strings.HasSuffix(p.QueueURL, ".fifo") {
+		input.MessageGroupId = aws.String(groupId)
+		input.MessageDeduplicationId = aws.String(dedupId)
+	}
+	_, err := p.Client.SendMessage(ctx, input)
+	return err
+}
+
+// Region of an SNS topic, from its ARN
+// (arn:aws:sns:<region>:<account>:<name>)
+func snsTopicRegion(arn string) string {
+	if parts := strings.Split(arn, ":"); len(parts) == 6 && parts[0] == "arn" && parts[2] == "sns" {
+		return parts[3]
+	}
+	return ""
+}
+
+// Region of an SQS queue, from its URL
+// (https://sqs.<region>.amazonaws.com/<account>/<name>)
+func sqsQueueRegion(queueURL string) string {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return ""
+	}
+	if parts := strings.Split(u.Hostname(), "."); len(parts) >= 4 && parts[0] == "sqs" {
+		return parts[1]
+	}
+	return ""
+}
+
+// SubmissionEvents publishes events of accepted submissions to SNS and
+// SQS in the background, so that downstream consumers (scoring Lambdas,
+// analytics) don't have to poll the storage
+type SubmissionEvents struct {
+	publishers []EventPublisher
+	network    string
+	// Bucket and prefix of keys of S3, if configured
+	bucket string
+	prefix string
+	queue  chan SubmissionEvent
+	log    logging.StandardLogger
+}
+
+func NewSubmissionEvents(publishers []EventPublisher, network string, bucket string, prefix string, log logging.StandardLogger) *SubmissionEvents {
+	return &SubmissionEvents{
+		publishers: publishers,
+		network:    network,
+		bucket:     bucket,
+		prefix:     prefix,
+		queue:      make(chan SubmissionEvent, SUBMISSION_EVENTS_QUEUE_SIZE),
+		log:        log,
+	}
+}
+
+// NewSubmissionEventsFromConfig creates the publishers of the
+// configuration of the network, bucket is empty if S3 isn't configured
+func NewSubmissionEventsFromConfig(ctx context.Context, cfg *SubmissionEventsConfig, network string, bucket string, log logging.StandardLogger) (*SubmissionEvents, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Topics and queues may be in a region other than the default one
+	var publishers []EventPublisher
+	if cfg.SNSTopicArn != "" {
+		client := sns.NewFromConfig(awsCfg, func(o *sns.Options) { o.Region = snsTopicRegion(cfg.SNSTopicArn) })
+		publishers = append(publishers, &SNSPublisher{TopicArn: cfg.SNSTopicArn, Client: client})
+	}
+	if cfg.SQSQueueURL != "" {
+		client := sqs.NewFromConfig(awsCfg, func(o *sqs.Options) { o.Region = sqsQueueRegion(cfg.SQSQueueURL) })
+		publishers = append(publishers, &SQSPublisher{QueueURL: cfg.SQSQueueURL, Client: client})
+	}
+	prefix := ""
+	if bucket != "" {
+		prefix = network + "/"
+	}
+	return NewSubmissionEvents(publishers, network, bucket, prefix, log), nil
+}
+
+// Accepted queues the event of the saved submission, it never blocks
+func (e *SubmissionEvents) Accepted(submitter Pk, blockHash string, createdAt time.Time, submittedAt time.Time, paths Paths) {
+	event := SubmissionEvent{
+		Event:       SUBMISSION_EVENT_ACCEPTED,
+		Network:     e.network,
+		Submitter:   submitter.String(),
+		BlockHash:   blockHash,
+		SubmittedAt: submittedAt.UTC(),
+		CreatedAt:   createdAt.UTC().Format(time.RFC3339),
+		Bucket:      e.bucket,
+		MetaKey:     e.prefix + paths.Meta,
+		BlockKey:    e.prefix + paths.Block,
+	}
+	select {
+	case e.queue <- event:
+	default:
+		incMetric("submission_events_dropped")
+	}
+}
+
+// Run publishes queued events until the context is done
+func (e *SubmissionEvents) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-e.queue:
+			e.publish(ctx, event)
+		}
+	}
+}
+
+func (e *SubmissionEvents) publish(ctx context.Context, event SubmissionEvent) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		incMetric("submission_event_errors")
+		e.log.Errorf("Error marshaling event of %s: %v", event.MetaKey, err)
+		return
+	}
+	// Events of a submitter are ordered in FIFO destinations, and
+	// deduplicated by submission
+	dedupHash := sha256.Sum256([]byte(event.MetaKey))
+	for _, publisher := range e.publishers {
+		sendCtx, cancel := context.WithTimeout(ctx, SUBMISSION_EVENT_SEND_TIMEOUT)
+		err := publisher.Publish(sendCtx, message, event.Submitter, hex.EncodeToString(dedupHash[:]))
+		cancel()
+		if err != nil {
+			incMetric("submission_event_errors")
+			e.log.Warnf("Failed to publish event of %s to %s: %v", event.MetaKey, publisher.Name(), err)
+		} else {
+			incMetric("submission_events_published")
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package delegation_backend
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+type recordingPublisher struct {
+	messages []string
+	groupIds []string
+}
+
+func (p *recordingPublisher) Name() string { return "recording" }
+
+func (p *recordingPublisher) Publish(ctx context.Context, message []byte, groupId string, dedupId string) error {
+	p.messages = append(p.messages, string(message))
+	p.groupIds = append(p.groupIds, groupId)
+	return nil
+}
+
+func TestSubmitPublishesEvent(t *testing.T) {
+	var req submitRequest
+	body := readTestFile("req-with-snark", t)
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	_, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	publisher := &recordingPublisher{}
+	events := NewSubmissionEvents([]EventPublisher{publisher}, "mainnet", "uptime-bucket", "mainnet/", logging.Logger("test"))
+	sh.app.SubmissionEvents = events
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected the submission accepted, got %d: %s", rep.Code, rep.Body)
+	}
+	events.publish(context.Background(), <-events.queue)
+
+	paths := makePaths(tm.Now(), req.GetBlockDataHash(), req.Submitter)
+	var event SubmissionEvent
+	if len(publisher.messages) != 1 || json.Unmarshal([]byte(publisher.messages[0]), &event) != nil {
+		t.Fatalf("expected an event published, got %v", publisher.messages)
+	}
+	expected := SubmissionEvent{
+		Event:       SUBMISSION_EVENT_ACCEPTED,
+		Network:     "mainnet",
+		Submitter:   req.Submitter.String(),
+		BlockHash:   req.GetBlockDataHash(),
+		SubmittedAt: tm.Now().UTC(),
+		CreatedAt:   "2021-07-17T22:39:48Z",
+		Bucket:      "uptime-bucket",
+		MetaKey:     "mainnet/" + paths.Meta,
+		BlockKey:    "mainnet/" + paths.Block,
+	}
+	if event != expected || publisher.groupIds[0] != req.Submitter.String() {
+		t.Fatalf("unexpected event %+v, expected %+v", event, expected)
+	}
+}
+
+func TestSubmissionEventPublishers(t *testing.T) {
+	var forms []url.Values
+	var authorized bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.PostForm)
+		authorized = strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/")
+		w.Header().Set("Content-Type", "text/xml")
+		if r.PostForm.Get("Action") == "SendMessage" {
+			// The client verifies the checksum of the body received
+			sum := md5.Sum([]byte(r.PostForm.Get("MessageBody")))
+			fmt.Fprintf(w, `<SendMessageResponse><SendMessageResult><MD5OfMessageBody>%x</MD5OfMessageBody><MessageId>1</MessageId></SendMessageResult></SendMessageResponse>`, sum)
+			return
+		}
+		fmt.Fprint(w, `<PublishResponse><PublishResult><MessageId>1</MessageId></PublishResult></PublishResponse>`)
+	}))
+	defer srv.Close()
+	creds := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+	})
+	snsPublisher := &SNSPublisher{
+		TopicArn: "arn:aws:sns:us-west-2:123456789012:uptime.fifo",
+		Client:   sns.New(sns.Options{Region: "us-west-2", Credentials: creds, BaseEndpoint: aws.String(srv.URL)}),
+	}
+	sqsPublisher := &SQSPublisher{
+		QueueURL: "https://sqs.us-west-2.amazonaws.com/123456789012/uptime",
+		Client:   sqs.New(sqs.Options{Region: "us-west-2", Credentials: creds, BaseEndpoint: aws.String(srv.URL)}),
+	}
+	for _, publisher := range []EventPublisher{snsPublisher, sqsPublisher} {
+		if err := publisher.Publish(context.Background(), []byte(`{"event":"submission_accepted"}`), "B62q", "dedup"); err != nil || !authorized {
+			t.Fatalf("expected the event published to %s, error: %v", publisher.Name(), err)
+		}
+	}
+	if forms[0].Get("Action") != "Publish" || forms[0].Get("Message") != `{"event":"submission_accepted"}` || forms[0].Get("MessageGroupId") != "B62q" {
+		t.Errorf("unexpected SNS request %v", forms[0])
+	}
+	// Queues other than FIFO queues don't take groups
+	if forms[1].Get("Action") != "SendMessage" || forms[1].Get("QueueUrl") != sqsPublisher.QueueURL || forms[1].Get("MessageBody") == "" || forms[1].Has("MessageGroupId") {
+		t.Errorf("unexpected SQS request %v", forms[1])
+	}
+
+	if region := snsTopicRegion(snsPublisher.TopicArn); region != "us-west-2" {
+		t.Errorf("unexpected region of the topic %q", region)
+	}
+	if region := sqsQueueRegion("https://sqs.eu-central-1.amazonaws.com/123456789012/uptime"); region != "eu-central-1" {
+		t.Errorf("unexpected region of the queue %q", region)
+	}
+}
+
+func TestSubmissionEventsConfig(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	mockLogger := &MockLogger{}
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"network_name": "devnet", "delegation_whitelist_disabled": true, "filesystem": {"path": "/tmp"},
+		"submission_events": {"mainnet": {"sns_topic_arn": "arn:aws:sns:us-west-2:123456789012:uptime"}}}`), 0644)
+
+	os.Setenv("SUBMISSION_EVENTS_SQS_QUEUE_URL", "https://sqs.us-west-2.amazonaws.com/123456789012/devnet")
+	config := LoadConfig(path, mockLogger)
+	if mockLogger.lastMessage != "" {
+		t.Fatalf("Unexpected fatal error: %s", mockLogger.lastMessage)
+	}
+	if config.SubmissionEvents["devnet"] == nil || config.SubmissionEvents["devnet"].SQSQueueURL == "" || config.SubmissionEvents["mainnet"].SNSTopicArn == "" {
+		t.Fatalf("Expected events of both networks, got %+v", config.SubmissionEvents)
+	}
+
+	os.Setenv("SUBMISSION_EVENTS_SNS_TOPIC_ARN", "uptime")
+	LoadConfig(path, mockLogger)
+	if !strings.Contains(mockLogger.lastMessage, `devnet: invalid sns_topic_arn "uptime"`) {
+		t.Errorf("Expected an invalid topic rejected, got: %s", mockLogger.lastMessage)
+	}
+}
@@ -0,0 +1,487 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Storage prefix under which indexes of days of submissions are saved
+const INDEX_PREFIX = "index/"
+
+const INDEX_DEFAULT_INTERVAL = 15 * time.Minute
+
+// Delay after the end of a day after which no submission is saved to it,
+// so that its index is complete
+const INDEX_SETTLE_DELAY = time.Hour
+
+// Indexes of days kept in memory for lookups
+const INDEX_CACHE_DAYS = 8
+
+// Incomplete indexes are read again from storage after this delay
+const INDEX_CACHE_TTL = time.Minute
+
+// Days of indexes the references of a block are counted over by default,
+// blocks are referenced by submissions made soon after they are produced
+const BLOCK_REFERENCES_DEFAULT_DAYS = 2
+
+const BLOCK_REFERENCES_MAX_DAYS = 31
+
+// DayIndex of the submissions of a day, saved as `index/<day>.json`, maps
+// submitters to their submissions and blocks to the first submission of
+// the day referencing them and the number of submissions referencing
+// them. Lookups read a single object rather than listing the day.
+type DayIndex struct {
+	Day       string    `json:"day"`
+	IndexedAt time.Time `json:"indexed_at"`
+	// Indexed once no submission is saved to the day anymore, complete
+	// indexes aren't updated
+	Complete    bool `json:"complete"`
+	Submissions int  `json:"submissions"`
+	// Times of submission (as in paths) by submitter, sorted
+	Submitters map[string][]string `json:"submitters"`
+	// Time of the earliest submission of the day by block hash
+	Blocks map[string]time.Time `json:"blocks"`
+	// Submissions of the day referencing the block, by block hash. Nil
+	// for indexes built before blocks were reference counted, which are
+	// built again.
+	References map[string]int `json:"references"`
+}
+
+// Interval between runs of the indexer
+func (cfg *IndexConfig) Interval() time.Duration {
+	if cfg.IntervalMinutes > 0 {
+		return time.Duration(cfg.IntervalMinutes) * time.Minute
+	}
+	return INDEX_DEFAULT_INTERVAL
+}
+
+// Path of the index of the day, relative to the root of the storage
+func IndexPath(day string) string {
+	return INDEX_PREFIX + day + ".json"
+}
+
+// Paths of the submissions of the submitter, sorted by time
+func (idx *DayIndex) Paths(submitter string) []string {
+	times := idx.Submitters[submitter]
+	paths := make([]string, len(times))
+	for i, t := range times {
+		paths[i] = SUBMISSIONS_PREFIX + idx.Day + "/" + t + "-" + submitter + ".json"
+	}
+	return paths
+}
+
+// Time of submission and submitter of a path `submissions/<day>/<time>-<submitter>.json`
+func splitSubmissionPath(metaPath string) (string, string, bool) {
+	name := strings.TrimSuffix(path.Base(metaPath), ".json")
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return "", "", false
+	}
+	return name[:i], name[i+1:], true
+}
+
+// ReadDayIndex of the day from storage, the error of a day not indexed
+// wraps fs.ErrNotExist
+func ReadDayIndex(ctx context.Context, source StorageSource, day string) (*DayIndex, error) {
+	bs, err := source.Read(ctx, IndexPath(day))
+	if err != nil {
+		return nil, err
+	}
+	var idx DayIndex
+	if err := json.Unmarshal(bs, &idx); err != nil {
+		return nil, fmt.Errorf("error unmarshaling index of %s: %w", day, err)
+	}
+	return &idx, nil
+}
+
+type cachedIndex struct {
+	index   *DayIndex
+	fetched time.Time
+}
+
+// SubmissionIndexer maintains indexes of the days of submissions of an
+// object storage, saved along with submissions. Days are indexed
+// incrementally: submissions saved since the latest index of a day are
+// read, to find their block, and added to it. Lookups are served from
+// memory, or from storage for days indexed by another replica.
+type SubmissionIndexer struct {
+	Source StorageSource
+	// Saves indexes, replacing the previous index of their day
+	Save  func(ObjectsToSave) error
+	mutex sync.Mutex
+	cache map[string]cachedIndex
+	// Days with a complete index, not indexed anymore
+	complete map[string]bool
+	indexed  int64
+	latest   time.Time
+	now      nowFunc
+	log      logging.StandardLogger
+}
+
+func NewSubmissionIndexer(source StorageSource, save func(ObjectsToSave) error, now nowFunc, log logging.StandardLogger) *SubmissionIndexer {
+	return &SubmissionIndexer{
+		Source:   source,
+		Save:     save,
+		cache:    make(map[string]cachedIndex),
+		complete: make(map[string]bool),
+		now:      now,
+		log:      log,
+	}
+}
+
+// Run indexes the days of submissions whose index is missing or
+// incomplete, returning the days indexed
+func (x *SubmissionIndexer) Run(ctx context.Context) ([]string, error) {
+	days, err := x.Source.Days(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing days of submissions: %w", err)
+	}
+	var indexed []string
+	for _, day := range days {
+		x.mutex.Lock()
+		complete := x.complete[day]
+		x.mutex.Unlock()
+		if complete {
+			continue
+		}
+		previous, err := x.Lookup(ctx, day)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return indexed, err
+		}
+		if previous != nil && previous.Complete && previous.References != nil {
+			continue
+		}
+		if _, err := x.IndexDay(ctx, day, previous); err != nil {
+			return indexed, fmt.Errorf("error indexing %s: %w", day, err)
+		}
+		indexed = append(indexed, day)
+	}
+	x.mutex.Lock()
+	x.latest = x.now()
+	x.mutex.Unlock()
+	return indexed, nil
+}
+
+// IndexDay adds the submissions of the day missing from the previous
+// index of the day (nil to build it from scratch) and saves the index.
+// Submissions whose block hash can't be told are indexed without it.
+// Indexes built before blocks were reference counted are built again.
+func (x *SubmissionIndexer) IndexDay(ctx context.Context, day string, previous *DayIndex) (*DayIndex, error) {
+	if previous != nil && previous.References == nil {
+		previous = nil
+	}
+	startedAt := x.now()
+	dayEnd, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return nil, fmt.Errorf("invalid day %s", day)
+	}
+	idx := &DayIndex{
+		Day:        day,
+		IndexedAt:  startedAt,
+		Complete:   startedAt.After(dayEnd.Add(24*time.Hour + INDEX_SETTLE_DELAY)),
+		Submitters: make(map[string][]string),
+		Blocks:     make(map[string]time.Time),
+		References: make(map[string]int),
+	}
+	known := make(map[string]bool)
+	if previous != nil {
+		for submitter, times := range previous.Submitters {
+			idx.Submitters[submitter] = append([]string(nil), times...)
+			for _, t := range times {
+				known[t+"-"+submitter] = true
+			}
+		}
+		for hash, firstSeen := range previous.Blocks {
+			idx.Blocks[hash] = firstSeen
+		}
+		for hash, references := range previous.References {
+			idx.References[hash] = references
+		}
+	}
+	paths, err := x.Source.Submissions(ctx, day)
+	if err != nil {
+		return nil, fmt.Errorf("error listing submissions: %w", err)
+	}
+	var unread []string
+	for _, metaPath := range paths {
+		t, submitter, ok := splitSubmissionPath(metaPath)
+		if ok && !known[t+"-"+submitter] {
+			unread = append(unread, metaPath)
+		}
+	}
+	added := 0
+	err = readConcurrently(ctx, x.Source, unread, func(metaPath string, bs []byte, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading submission %s: %w", metaPath, err)
+		}
+		t, submitter, _ := splitSubmissionPath(metaPath)
+		idx.Submitters[submitter] = append(idx.Submitters[submitter], t)
+		added++
+		hash, err := submissionBlockHash(metaPath, bs, nil)
+		if err != nil {
+			x.log.Warnf("Indexing %s without its block: %v", metaPath, err)
+			return nil
+		}
+		idx.References[hash]++
+		submittedAt, err := SubmittedAtOfPath(metaPath)
+		if err != nil {
+			return nil
+		}
+		if firstSeen, seen := idx.Blocks[hash]; !seen || submittedAt.Before(firstSeen) {
+			idx.Blocks[hash] = submittedAt
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, times := range idx.Submitters {
+		sort.Strings(times)
+		idx.Submissions += len(times)
+	}
+	bs, err := json.Marshal(idx)
+	if err != nil {
+		return nil, err
+	}
+	if err := x.Save(ObjectsToSave{IndexPath(day): bs}); err != nil {
+		return nil, fmt.Errorf("error saving index: %w", err)
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.indexed += int64(added)
+	x.remember(idx)
+	return idx, nil
+}
+
+// Remember an index for lookups, forgetting the oldest day beyond
+// INDEX_CACHE_DAYS. Called with the mutex held.
+func (x *SubmissionIndexer) remember(idx *DayIndex) {
+	x.cache[idx.Day] = cachedIndex{index: idx, fetched: x.now()}
+	if idx.Complete && idx.References != nil {
+		x.complete[idx.Day] = true
+	}
+	if len(x.cache) <= INDEX_CACHE_DAYS {
+		return
+	}
+	oldest := idx.Day
+	for day := range x.cache {
+		if day < oldest {
+			oldest = day
+		}
+	}
+	delete(x.cache, oldest)
+}
+
+// Lookup the index of the day, the error of a day not indexed wraps
+// fs.ErrNotExist
+func (x *SubmissionIndexer) Lookup(ctx context.Context, day string) (*DayIndex, error) {
+	x.mutex.Lock()
+	cached, ok := x.cache[day]
+	x.mutex.Unlock()
+	if ok && (cached.index.Complete || x.now().Sub(cached.fetched) < INDEX_CACHE_TTL) {
+		return cached.index, nil
+	}
+	idx, err := ReadDayIndex(ctx, x.Source, day)
+	if err != nil {
+		return nil, err
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.remember(idx)
+	return idx, nil
+}
+
+// Index every interval while leader, replicas which aren't the leader
+// only serve lookups
+func (x *SubmissionIndexer) RunLoop(ctx context.Context, interval time.Duration, election *LeaderElection) {
+	for {
+		if election.IsLeader() {
+			if indexed, err := x.Run(ctx); err != nil {
+				incMetric("index_errors")
+				x.log.Errorf("Failed to index submissions: %v", err)
+			} else if len(indexed) > 0 {
+				x.log.Debugf("Indexed submissions of %s", strings.Join(indexed, ", "))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Summary of an index, without its entries
+type dayIndexSummary struct {
+	Day         string    `json:"day"`
+	IndexedAt   time.Time `json:"indexed_at"`
+	Complete    bool      `json:"complete"`
+	Submissions int       `json:"submissions"`
+	Submitters  int       `json:"submitters"`
+	Blocks      int       `json:"blocks"`
+}
+
+// Handler serves lookups of indexes at /v1/index/<day>: the summary of the
+// index, the submissions of a submitter with `?submitter=<public key>` or
+// the first submission of a block with `?block=<hash>`
+func (x *SubmissionIndexer) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		day := path.Base(r.URL.Path)
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			writeJSON(rw, http.StatusBadRequest, errorResponse{"Invalid day, expected YYYY-MM-DD"})
+			return
+		}
+		idx, err := x.Lookup(r.Context(), day)
+		if errors.Is(err, fs.ErrNotExist) {
+			writeJSON(rw, http.StatusNotFound, errorResponse{"Day not indexed"})
+			return
+		}
+		if err != nil {
+			writeJSON(rw, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		query := r.URL.Query()
+		switch {
+		case query.Get("submitter") != "":
+			submitter := query.Get("submitter")
+			writeJSON(rw, http.StatusOK, map[string]interface{}{
+				"day":         day,
+				"complete":    idx.Complete,
+				"submitter":   submitter,
+				"submissions": idx.Paths(submitter),
+			})
+		case query.Get("block") != "":
+			firstSeen, ok := idx.Blocks[query.Get("block")]
+			if !ok {
+				writeJSON(rw, http.StatusNotFound, errorResponse{"Block not referenced on the day"})
+				return
+			}
+			writeJSON(rw, http.StatusOK, map[string]interface{}{"day": day, "block": query.Get("block"), "first_seen": firstSeen, "references": idx.References[query.Get("block")]})
+		default:
+			writeJSON(rw, http.StatusOK, dayIndexSummary{
+				Day:         day,
+				IndexedAt:   idx.IndexedAt,
+				Complete:    idx.Complete,
+				Submissions: idx.Submissions,
+				Submitters:  len(idx.Submitters),
+				Blocks:      len(idx.Blocks),
+			})
+		}
+	})
+}
+
+// References of a block on a day
+type blockDayReferences struct {
+	Day        string    `json:"day"`
+	FirstSeen  time.Time `json:"first_seen"`
+	References int       `json:"references"`
+}
+
+type blockReferencesResponse struct {
+	Block string `json:"block"`
+	// Earliest submission referencing the block over the days
+	FirstSeen  *time.Time           `json:"first_seen"`
+	References int                  `json:"references"`
+	Days       []blockDayReferences `json:"days"`
+}
+
+// BlockReferences counts the submissions referencing the block on the
+// days, by day. Days not indexed are skipped.
+func (x *SubmissionIndexer) BlockReferences(ctx context.Context, hash string, days []string) ([]blockDayReferences, error) {
+	refs := make([]blockDayReferences, 0)
+	for _, day := range days {
+		idx, err := x.Lookup(ctx, day)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if firstSeen, ok := idx.Blocks[hash]; ok {
+			refs = append(refs, blockDayReferences{Day: day, FirstSeen: firstSeen, References: idx.References[hash]})
+		}
+	}
+	return refs, nil
+}
+
+// BlocksHandler serves the references of blocks at /v1/blocks/<hash>: the
+// submissions referencing the block over the latest days of indexes, 2 by
+// default or set with `?days=<n>`
+func (x *SubmissionIndexer) BlocksHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		hash := path.Base(r.URL.Path)
+		if hash == "" || hash == "blocks" || hash == "/" {
+			writeJSON(rw, http.StatusBadRequest, errorResponse{"Missing block hash"})
+			return
+		}
+		n := BLOCK_REFERENCES_DEFAULT_DAYS
+		if value := r.URL.Query().Get("days"); value != "" {
+			var err error
+			if n, err = strconv.Atoi(value); err != nil || n < 1 || n > BLOCK_REFERENCES_MAX_DAYS {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{fmt.Sprintf("Invalid days, expected a number from 1 to %d", BLOCK_REFERENCES_MAX_DAYS)})
+				return
+			}
+		}
+		today := x.now().UTC()
+		days := make([]string, n)
+		for i := range days {
+			days[i] = today.Add(-time.Duration(n-1-i) * 24 * time.Hour).Format("2006-01-02")
+		}
+		refs, err := x.BlockReferences(r.Context(), hash, days)
+		if err != nil {
+			writeJSON(rw, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		if len(refs) == 0 {
+			writeJSON(rw, http.StatusNotFound, errorResponse{"Block not referenced on the days"})
+			return
+		}
+		resp := blockReferencesResponse{Block: hash, Days: refs}
+		for i := range refs {
+			resp.References += refs[i].References
+			if resp.FirstSeen == nil || refs[i].FirstSeen.Before(*resp.FirstSeen) {
+				resp.FirstSeen = &refs[i].FirstSeen
+			}
+		}
+		writeJSON(rw, http.StatusOK, resp)
+	})
+}
+
+// WritePrometheus writes the submissions indexed since the start and the
+// time of the latest run
+func (x *SubmissionIndexer) WritePrometheus(w io.Writer) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	fmt.Fprint(w, "# HELP uptime_index_submissions_indexed_total Submissions added to indexes of days.\n")
+	fmt.Fprint(w, "# TYPE uptime_index_submissions_indexed_total counter\n")
+	fmt.Fprintf(w, "uptime_index_submissions_indexed_total %d\n", x.indexed)
+	if !x.latest.IsZero() {
+		fmt.Fprint(w, "# HELP uptime_index_run_timestamp_seconds Time of the latest indexing run.\n")
+		fmt.Fprint(w, "# TYPE uptime_index_run_timestamp_seconds gauge\n")
+		fmt.Fprintf(w, "uptime_index_run_timestamp_seconds %d\n", x.latest.Unix())
+	}
+}
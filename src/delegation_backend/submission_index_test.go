@@ -0,0 +1,132 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestSubmissionIndexer(t *testing.T) {
+	dir := t.TempDir()
+	log := logging.Logger("test")
+	save := func(objs ObjectsToSave) error { return LocalFileSystemSave(objs, dir, log) }
+	overwrite := func(objs ObjectsToSave) error { return LocalFileSystemOverwrite(objs, dir) }
+	if err := save(ObjectsToSave{
+		"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NKa"}`),
+		"submissions/2024-05-01/2024-05-01T09:00:00Z-B62qb.json": []byte(`{"block_hash":"3NKa"}`),
+		"submissions/2024-05-01/2024-05-01T11:00:00Z-B62qa.json": []byte(`{`),
+		"submissions/2024-05-02/2024-05-02T10:00:00Z-B62qa.json": []byte(`{"block_hash":"3NKb"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tm := &timeMock{time: time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)}
+	indexer := NewSubmissionIndexer(LocalFileSystemSource{Directory: dir}, overwrite, tm.Now, log)
+
+	indexed, err := indexer.Run(context.Background())
+	if err != nil || !reflect.DeepEqual(indexed, []string{"2024-05-01", "2024-05-02"}) {
+		t.Fatalf("unexpected days indexed %v, error: %v", indexed, err)
+	}
+	// Indexes are read back from storage by other replicas
+	idx, err := ReadDayIndex(context.Background(), LocalFileSystemSource{Directory: dir}, "2024-05-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPaths := []string{"submissions/2024-05-01/2024-05-01T10:00:00Z-B62qa.json", "submissions/2024-05-01/2024-05-01T11:00:00Z-B62qa.json"}
+	if !idx.Complete || idx.Submissions != 3 || !reflect.DeepEqual(idx.Paths("B62qa"), expectedPaths) ||
+		!idx.Blocks["3NKa"].Equal(time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)) || len(idx.Blocks) != 1 {
+		t.Fatalf("unexpected index %+v", idx)
+	}
+
+	// Incomplete days are indexed again, with submissions saved since
+	if err := save(ObjectsToSave{"submissions/2024-05-02/2024-05-02T11:00:00Z-B62qb.json": []byte(`{"block_hash":"3NKb"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	tm.time = time.Date(2024, 5, 3, 2, 0, 0, 0, time.UTC)
+	if indexed, err = indexer.Run(context.Background()); err != nil || !reflect.DeepEqual(indexed, []string{"2024-05-02"}) {
+		t.Fatalf("unexpected days indexed %v, error: %v", indexed, err)
+	}
+	if idx, err = ReadDayIndex(context.Background(), LocalFileSystemSource{Directory: dir}, "2024-05-02"); err != nil || !idx.Complete || idx.Submissions != 2 || len(idx.Submitters) != 2 {
+		t.Fatalf("unexpected index %+v, error: %v", idx, err)
+	}
+
+	for _, test := range []struct {
+		url    string
+		status int
+		body   map[string]interface{}
+	}{
+		{"/v1/index/2024-05-01?submitter=B62qb", http.StatusOK, map[string]interface{}{
+			"day": "2024-05-01", "complete": true, "submitter": "B62qb",
+			"submissions": []interface{}{"submissions/2024-05-01/2024-05-01T09:00:00Z-B62qb.json"},
+		}},
+		{"/v1/index/2024-05-02?block=3NKb", http.StatusOK, map[string]interface{}{"day": "2024-05-02", "block": "3NKb", "first_seen": "2024-05-02T10:00:00Z", "references": float64(2)}},
+		{"/v1/index/2024-05-02?block=3NKa", http.StatusNotFound, nil},
+		{"/v1/index/2024-04-30", http.StatusNotFound, nil},
+		{"/v1/index/yesterday", http.StatusBadRequest, nil},
+	} {
+		rec := httptest.NewRecorder()
+		indexer.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.url, test.status, rec.Code)
+			continue
+		}
+		if test.body == nil {
+			continue
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || !reflect.DeepEqual(body, test.body) {
+			t.Errorf("%s: unexpected body %s", test.url, rec.Body.String())
+		}
+	}
+
+	// Indexes are deleted along with the submissions of their day
+	if !expired(IndexPath("2024-05-01"), tm.time, tm.time) || expired(IndexPath("2024-05-03"), tm.time, tm.time) {
+		t.Fatal("expected the index of a day expired with its submissions")
+	}
+}
+
+func TestBlockReferences(t *testing.T) {
+	dir := t.TempDir()
+	log := logging.Logger("test")
+	overwrite := func(objs ObjectsToSave) error { return LocalFileSystemOverwrite(objs, dir) }
+	if err := overwrite(ObjectsToSave{
+		"submissions/2024-05-01/2024-05-01T23:50:00Z-B62qa.json": []byte(`{"block_hash":"3NKa"}`),
+		"submissions/2024-05-02/2024-05-02T00:10:00Z-B62qb.json": []byte(`{"block_hash":"3NKa"}`),
+		"submissions/2024-05-02/2024-05-02T00:20:00Z-B62qc.json": []byte(`{"block_hash":"3NKa"}`),
+		// Complete index built before blocks were reference counted
+		IndexPath("2024-05-01"): []byte(`{"day":"2024-05-01","complete":true,"submissions":1,"submitters":{"B62qa":["2024-05-01T23:50:00Z"]},"blocks":{"3NKa":"2024-05-01T23:50:00Z"}}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tm := &timeMock{time: time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)}
+	indexer := NewSubmissionIndexer(LocalFileSystemSource{Directory: dir}, overwrite, tm.Now, log)
+	if indexed, err := indexer.Run(context.Background()); err != nil || !reflect.DeepEqual(indexed, []string{"2024-05-01", "2024-05-02"}) {
+		t.Fatalf("expected the index without references built again, got %v, error: %v", indexed, err)
+	}
+
+	rec := httptest.NewRecorder()
+	indexer.BlocksHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/blocks/3NKa", nil))
+	var refs blockReferencesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &refs); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body)
+	}
+	if refs.References != 3 || len(refs.Days) != 2 || refs.Days[0].References != 1 || !refs.FirstSeen.Equal(time.Date(2024, 5, 1, 23, 50, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected references %+v", refs)
+	}
+	for url, status := range map[string]int{
+		"/v1/blocks/3NKa?days=1": http.StatusOK,
+		"/v1/blocks/3NKb":        http.StatusNotFound,
+		"/v1/blocks/3NKa?days=0": http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		indexer.BlocksHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != status {
+			t.Errorf("%s: expected status %d, got %d", url, status, rec.Code)
+		}
+	}
+}
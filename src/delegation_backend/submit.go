@@ -9,13 +9,19 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	logging "github.com/ipfs/go-log/v2"
-	"golang.org/x/crypto/blake2b"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func min(a, b int) int {
@@ -29,9 +35,31 @@ type errorResponse struct {
 	Msg string `json:"error"`
 }
 
-func writeErrorResponse(app *App, w *http.ResponseWriter, msg string) {
-	app.Log.Debugf("Responding with error: %s", msg)
-	bs, err := json.Marshal(errorResponse{msg})
+// Error responses of /submit, with the code of rejections having one
+type submitErrorResponse struct {
+	Msg  string `json:"error"`
+	Code string `json:"code,omitempty"`
+	// Set for submissions not matching the schema
+	Errors []SchemaError `json:"errors,omitempty"`
+	// What the exporter can do about the rejection, if known
+	Hint string `json:"hint,omitempty"`
+}
+
+type submitResponse struct {
+	Status string `json:"status"`
+	// Set if submission windows are enforced
+	WindowId *int     `json:"window_id,omitempty"`
+	Receipt  *Receipt `json:"receipt,omitempty"`
+	// Set if receipts are indexed, see /v1/receipts/<id>
+	ReceiptId string `json:"receipt_id,omitempty"`
+}
+
+func writeErrorResponse(app *App, w *http.ResponseWriter, resp submitErrorResponse) {
+	app.Log.Debugf("Responding with error: %s", resp.Msg)
+	if rec, ok := (*w).(*statusRecorder); ok {
+		rec.errorMsg = resp.Msg
+	}
+	bs, err := json.Marshal(resp)
 	if err == nil {
 		_, err2 := io.Copy(*w, bytes.NewReader(bs))
 		if err2 != nil {
@@ -42,16 +70,38 @@ func writeErrorResponse(app *App, w *http.ResponseWriter, msg string) {
 	}
 }
 
-func (ctx *AwsContext) S3Save(objs ObjectsToSave) {
+// S3Save saves all objects, returning the last error encountered
+func (ctx *AwsContext) S3Save(objs ObjectsToSave) error {
+	return ctx.S3SaveVersioned(objs, nil)
+}
+
+// S3SaveVersioned saves all objects like S3Save, calling saved (if not nil)
+// with the ETag and version id of every object written or found existing
+func (ctx *AwsContext) S3SaveVersioned(objs ObjectsToSave, saved func(path string, version ObjectVersion)) error {
+	var saveErr error
 	for path, bs := range objs {
 		fullKey := aws.String(ctx.Prefix + "/" + path)
-		if strings.HasPrefix(path, "blocks/") {
-			_, err := ctx.Client.HeadObject(ctx.Context, &s3.HeadObjectInput{
+		dedup := strings.HasPrefix(path, "blocks/") && ctx.Flags.Enabled(FEATURE_BLOCK_DEDUP)
+		if dedup && ctx.Shared != nil {
+			if version, found := ctx.savedBlock(path); found {
+				if saved != nil {
+					saved(path, version)
+				}
+				continue
+			}
+		}
+		if dedup {
+			head, err := ctx.Client.HeadObject(ctx.Context, &s3.HeadObjectInput{
 				Bucket: ctx.BucketName,
 				Key:    fullKey,
 			})
 			if err == nil {
 				//block already exists, skipping
+				version := ObjectVersion{ETag: aws.ToString(head.ETag), VersionId: aws.ToString(head.VersionId), Existing: true}
+				ctx.rememberBlock(path, version)
+				if saved != nil {
+					saved(path, version)
+				}
 				continue
 			}
 			if !strings.Contains(err.Error(), "NotFound") {
@@ -60,7 +110,7 @@ func (ctx *AwsContext) S3Save(objs ObjectsToSave) {
 		}
 
 		ctx.Log.Infof("S3Save: saving %s", path)
-		_, err := ctx.Client.PutObject(ctx.Context, &s3.PutObjectInput{
+		put, err := ctx.Client.PutObject(ctx.Context, &s3.PutObjectInput{
 			Bucket:     ctx.BucketName,
 			Key:        fullKey,
 			Body:       bytes.NewReader(bs),
@@ -68,11 +118,56 @@ func (ctx *AwsContext) S3Save(objs ObjectsToSave) {
 		})
 		if err != nil {
 			ctx.Log.Warnf("S3Save: Error while saving metadata: %v", err)
+			saveErr = err
+		} else {
+			version := ObjectVersion{ETag: aws.ToString(put.ETag), VersionId: aws.ToString(put.VersionId)}
+			if dedup {
+				ctx.rememberBlock(path, version)
+			}
+			if saved != nil {
+				saved(path, version)
+			}
 		}
 	}
+	return saveErr
+}
+
+// WithContext is a copy of the context whose requests are bound to ctx,
+// e.g. to the deadline of a request instead of the lifetime of the process
+func (ctx *AwsContext) WithContext(c context.Context) *AwsContext {
+	copied := *ctx
+	copied.Context = c
+	return &copied
 }
 
-func LocalFileSystemSave(objs ObjectsToSave, directory string, log logging.StandardLogger) {
+// Version of the block saved by any replica, found in the shared state
+func (ctx *AwsContext) savedBlock(path string) (ObjectVersion, bool) {
+	value, found, err := ctx.Shared.Get("block:" + path)
+	if err != nil {
+		incMetric("shared_state_errors")
+		return ObjectVersion{}, false
+	}
+	var version ObjectVersion
+	if !found || json.Unmarshal([]byte(value), &version) != nil {
+		return ObjectVersion{}, false
+	}
+	version.Existing = true
+	return version, true
+}
+
+func (ctx *AwsContext) rememberBlock(path string, version ObjectVersion) {
+	if ctx.Shared == nil {
+		return
+	}
+	bs, _ := json.Marshal(version)
+	if err := ctx.Shared.Set("block:"+path, string(bs), SHARED_STATE_BLOCK_TTL); err != nil {
+		incMetric("shared_state_errors")
+	}
+}
+
+// LocalFileSystemSave saves all objects, returning the last error encountered
+func LocalFileSystemSave(objs ObjectsToSave, directory string, log logging.StandardLogger) error {
+	var saveErr error
 	for path, bs := range objs {
 		fullPath := filepath.Join(directory, path)
 
@@ -85,24 +180,54 @@ func LocalFileSystemSave(objs ObjectsToSave, directory string, log logging.Stand
 		err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm)
 		if err != nil {
 			log.Errorf("LocalFileSystemSave: Error creating directories for %s: %v", fullPath, err)
+			saveErr = err
 			continue // skip to the next object
 		}
 		log.Infof("LocalFileSystemSave: saving %s", fullPath)
 		err = os.WriteFile(fullPath, bs, 0644)
 		if err != nil {
 			log.Warnf("Error writing to file %s: %v", fullPath, err)
+			saveErr = err
+		}
+	}
+	return saveErr
+}
+
+// Save objects to the local filesystem, replacing existing files, for
+// objects updated in place such as indexes
+func LocalFileSystemOverwrite(objs ObjectsToSave, directory string) error {
+	for path, bs := range objs {
+		if err := writeFileAtomically(filepath.Join(directory, path), bs); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
+// ObjectsToSave maps paths to the values saved. Values may alias pooled
+// buffers of the request and are only valid for the duration of a save,
+// backends keeping them afterwards must copy them, see Clone.
 type ObjectsToSave map[string][]byte
 
+// Clone copies the objects and their values
+func (objs ObjectsToSave) Clone() ObjectsToSave {
+	clone := make(ObjectsToSave, len(objs))
+	for path, value := range objs {
+		clone[path] = bytes.Clone(value)
+	}
+	return clone
+}
+
 type AwsContext struct {
 	Client     *s3.Client
 	BucketName *string
 	Prefix     string
 	Context    context.Context
 	Log        *logging.ZapEventLogger
+	// Optional, governs skipping blocks already saved
+	Flags *FeatureFlags
+	// Optional, remembers blocks saved by any replica
+	Shared SharedState
 }
 
 type App struct {
@@ -111,14 +236,110 @@ type App struct {
 	Whitelist               *WhitelistMVar
 	WhitelistDisabled       bool
 	VerifySignatureDisabled bool
-	NetworkId               uint8
-	Save                    func(ObjectsToSave)
+	SignatureScheme         SignatureScheme
+	Save                    func(context.Context, ObjectsToSave) StorageOutcomes
 	Now                     nowFunc
 	IsReady                 bool
+	ClientIPResolver        *ClientIPResolver
+	ClientCertAuth          *ClientCertAuth
+	ReplayGuard             *ReplayGuard
+	CreatedAtMaxAge         time.Duration
+	// Time created_at may be ahead of the clock, as of the clock skew
+	// policy of the network
+	CreatedAtMaxFuture time.Duration
+	APIKeys            *APIKeys
+	VerifyPool         *VerifyPool
+	VerifyCache        *VerifyCache
+	ReceiptSigner      *ReceiptSigner
+	AnomalyMonitor     *AnomalyMonitor
+	BodyReadTimeout    time.Duration
+	// Deadline of handling a submission, none if zero
+	SubmitTimeout    time.Duration
+	SignatureLockout *SignatureLockout
+	// Optional, limits of the size of submissions and distributions of
+	// their sizes, MAX_SUBMIT_PAYLOAD_SIZE limits both if nil
+	SubmitSizes *SubmitSizes
+	// Optional, rejects blocks which aren't blocks of the network
+	BlockValidator *BlockValidator
+	// Optional, records the global slot and the epoch of submissions
+	NetworkTiming *NetworkTiming
+	// Optional, credits submitters once per window of slots, requires
+	// NetworkTiming
+	SubmissionWindows *SubmissionWindows
+	// Maximum lifetime of accepted delegation tokens, zero disables delegation
+	DelegationMaxTTL time.Duration
+	AuditLogs        []*AuditLog
+	// Optional, reports panics and storage failure bursts
+	ErrorReporter         ErrorReporter
+	StorageFailureMonitor *StorageFailureMonitor
+	SubmitterStats        *SubmitterStats
+	DailyReports          *DailyReports
+	// Optional, alerts when submissions stop being accepted
+	SubmissionWatch *IdleWatch
+	// Optional, flags submitters which stopped submitting
+	SilenceWatch *SilenceWatch
+	// Optional, records per-backend outcomes of saving submissions
+	WriteOutcomes WriteOutcomeStore
+	// Optional, flags default to their state when not configured
+	FeatureFlags *FeatureFlags
+	// Optional, sheds requests under overload
+	LoadShedder *LoadShedder
+	// Versions of sign payloads accepted, all of SIGN_PAYLOAD_VERSIONS if nil
+	SignPayloadVersions []int
+	// Optional, quarantines submissions failing signature verification
+	SignatureQuarantine *SignatureQuarantine
+	// Optional, checks nodes of submitters are synced
+	LivenessChecker *LivenessChecker
+	// Optional, publishes events of accepted submissions
+	SubmissionEvents *SubmissionEvents
+	// Optional, fires webhooks on events
+	Webhooks *Webhooks
+	// Optional, keeps recent submissions for the admin dashboard
+	Dashboard *Dashboard
+	// Optional, repeats responses to retried submissions
+	Idempotency *IdempotencyStore
+	// Optional, looks accepted submissions up by receipt id
+	ReceiptIndex ReceiptIndex
+	// Optional, flags or rejects submissions once the clock jumped
+	ClockGuard *ClockGuard
+}
+
+// Verify signature of the hash, using cached result if available
+func (app *App) verifySignature(ctx context.Context, pk *Pk, sig *Sig, hash []byte) (bool, error) {
+	_, span := tracer.Start(ctx, "verify_signature")
+	defer span.End()
+	// Canary runs always verify, so that a broken verifier is noticed
+	useCache := app.VerifyCache != nil && canaryRunOf(ctx) == nil && app.FeatureFlags.EnabledFor(FEATURE_VERIFY_CACHE, pk.String())
+	if useCache {
+		if valid, found := app.VerifyCache.Get(pk, sig, hash); found {
+			span.SetAttributes(attribute.Bool("verify.cached", true), attribute.Bool("verify.valid", valid))
+			return valid, nil
+		}
+	}
+	var valid bool
+	if app.VerifyPool != nil {
+		var err error
+		valid, err = app.VerifyPool.Verify(ctx, app.SignatureScheme, pk, sig, hash)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return false, err
+		}
+	} else {
+		valid = app.SignatureScheme.Verify(pk, sig, hash)
+	}
+	if useCache {
+		app.VerifyCache.Put(pk, sig, hash, valid)
+	}
+	span.SetAttributes(attribute.Bool("verify.cached", false), attribute.Bool("verify.valid", valid))
+	return valid, nil
 }
 
 type SubmitH struct {
-	app *App
+	app      *App
+	pipeline *SubmitPipeline
+	// Version of the schema of requests, SUBMIT_SCHEMA_V1 if zero
+	schemaVersion int
 }
 
 type Paths struct {
@@ -141,123 +362,168 @@ var nilSig Sig
 var nilPk Pk
 var nilTime time.Time
 
-func (h *SubmitH) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (h *SubmitH) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "submit", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	if h.app.SubmitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.app.SubmitTimeout)
+		defer cancel()
+	}
+	rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+	var w http.ResponseWriter = rec
+	canary := canaryRunOf(ctx)
+	audit := AuditRecord{Time: h.app.Now(), RemoteAddr: r.RemoteAddr, BodySize: r.ContentLength}
+	defer func() {
+		span.SetAttributes(attribute.Int("http.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+		h.recordAudit(&audit, rec)
+	}()
+	defer func() {
+		if p := recover(); p != nil {
+			h.handlePanic(p, r, &audit)
+			w.WriteHeader(500)
+			writeErrorResponse(h.app, &w, submitErrorResponse{Msg: "Unexpected server error"})
+		}
+	}()
+
 	h.app.Log.Infof("Received request: method=%s path=%s remote_addr=%s content_length=%d", r.Method, r.URL.Path, r.RemoteAddr, r.ContentLength)
 
-	if r.ContentLength == -1 {
-		h.app.Log.Warnf("Request missing Content-Length header")
-		w.WriteHeader(411)
-		return
-	} else if r.ContentLength > MAX_SUBMIT_PAYLOAD_SIZE {
-		h.app.Log.Warnf("Request payload too large: %d bytes (max: %d)", r.ContentLength, MAX_SUBMIT_PAYLOAD_SIZE)
-		w.WriteHeader(413)
-		return
+	remoteAddr := r.RemoteAddr
+	if h.app.ClientIPResolver != nil {
+		remoteAddr = h.app.ClientIPResolver.ClientIP(r)
 	}
-	body, err1 := io.ReadAll(io.LimitReader(r.Body, r.ContentLength))
-	if err1 != nil || int64(len(body)) != r.ContentLength {
-		h.app.Log.Debugf("Error while reading /submit request's body: %v", err1)
-		w.WriteHeader(400)
-		writeErrorResponse(h.app, &w, "Error reading the body")
-		return
+	audit.RemoteAddr = remoteAddr
+	s := &submission{
+		ctx:            ctx,
+		span:           span,
+		canary:         canary,
+		audit:          &audit,
+		remoteAddr:     remoteAddr,
+		body:           r.Body,
+		contentLength:  r.ContentLength,
+		schemaVersion:  h.schemaVersion,
+		idempotencyKey: r.Header.Get(IDEMPOTENCY_KEY_HEADER),
+		setReadDeadline: func(deadline time.Time) error {
+			return http.NewResponseController(w).SetReadDeadline(deadline)
+		},
 	}
-
-	var req submitRequest
-	if err := json.Unmarshal(body, &req); err != nil {
-		h.app.Log.Errorf("Error while unmarshaling JSON of /submit request's body: %v, body preview: %s", err, string(body[:min(len(body), 200)]))
-		w.WriteHeader(400)
-		writeErrorResponse(h.app, &w, "Error decoding payload")
-		return
+	if r.TLS != nil {
+		s.peerCertificates = r.TLS.PeerCertificates
 	}
-
-	h.app.Log.Infof("Successfully parsed submission from submitter: %s", req.Submitter.String())
-
-	if !req.CheckRequiredFields() {
-		h.app.Log.Warnf("Required fields validation failed for submitter: %s", req.Submitter.String())
-		w.WriteHeader(400)
-		writeErrorResponse(h.app, &w, "One of required fields wasn't provided")
+	defer s.done()
+	if rejection := h.pipeline.Run(s); rejection != nil {
+		h.respondRejection(w, rejection)
 		return
 	}
 
-	if !h.app.WhitelistDisabled {
-		wl := h.app.Whitelist.ReadWhitelist()
-		if (*wl)[req.Submitter] == nil {
-			h.app.Log.Warnf("Submitter not in whitelist: %s", req.Submitter.String())
-			w.WriteHeader(401)
-			message := fmt.Sprintf("Submitter is not registered: %s", req.Submitter)
-			writeErrorResponse(h.app, &w, message)
-			return
-		}
-		h.app.Log.Debugf("Submitter %s found in whitelist", req.Submitter.String())
+	if s.replayed != nil {
+		w.Header().Set(IDEMPOTENCY_REPLAYED_HEADER, "true")
+	}
+	respBytes, err := json.Marshal(s.response)
+	if err == nil {
+		_, err = io.Copy(w, bytes.NewReader(respBytes))
+	}
+	if err != nil {
+		h.app.Log.Debugf("Error while responding with ok status to the user: %v", err)
 	} else {
-		h.app.Log.Debugf("Whitelist disabled, accepting submitter: %s", req.Submitter.String())
+		h.app.Log.Infof("Successfully processed submission from %s", s.req.Submitter.String())
 	}
+}
 
-	submittedAt := h.app.Now()
-	if req.Data.CreatedAt.Add(TIME_DIFF_DELTA).After(submittedAt) {
-		h.app.Log.Debugf("Field created_at is a timestamp in future: %v", submittedAt)
-		w.WriteHeader(400)
-		writeErrorResponse(h.app, &w, "Field created_at is a timestamp in future")
-		return
+func (h *SubmitH) respondRejection(w http.ResponseWriter, rejection *Rejection) {
+	if rejection.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(rejection.RetryAfter))
 	}
-
-	if !h.app.VerifySignatureDisabled {
-		payload, err := req.Data.MakeSignPayload()
-		if err != nil {
-			h.app.Log.Errorf("Error while making sign payload: %v", err)
-			w.WriteHeader(500)
-			writeErrorResponse(h.app, &w, "Unexpected server error")
-			return
-		}
-
-		hash := blake2b.Sum256(payload)
-		if !verifySig(&req.Submitter, &req.Sig, hash[:], h.app.NetworkId) {
-			w.WriteHeader(401)
-			writeErrorResponse(h.app, &w, "Invalid signature")
-			return
-		}
+	if rejection.Close {
+		w.Header().Set("Connection", "close")
 	}
-
-	passesAttemptLimit := h.app.SubmitCounter.RecordAttempt(req.Submitter)
-	if !passesAttemptLimit {
-		w.WriteHeader(429)
-		writeErrorResponse(h.app, &w, "Too many requests per hour")
-		return
+	w.WriteHeader(rejection.Status)
+	if rejection.Message != "" {
+		writeErrorResponse(h.app, &w, submitErrorResponse{Msg: rejection.Message, Code: rejection.Code, Errors: rejection.Errors, Hint: rejection.Hint})
 	}
+}
 
-	blockHash := req.GetBlockDataHash()
-	ps := makePaths(submittedAt, blockHash, req.Submitter)
-
-	remoteAddr := r.Header.Get("X-Forwarded-For")
-	if remoteAddr == "" {
-		// If there is no X-Forwarded-For header, use the remote address
-		remoteAddr = r.RemoteAddr
+func (h *SubmitH) handlePanic(p interface{}, r *http.Request, audit *AuditRecord) {
+	stack := debug.Stack()
+	h.app.Log.Errorf("Panic while handling submission from %s: %v\n%s", audit.RemoteAddr, p, stack)
+	if h.app.ErrorReporter != nil {
+		h.app.ErrorReporter.Report(ErrorEvent{
+			Message: fmt.Sprintf("Panic while handling submission: %v", p),
+			Level:   ERROR_LEVEL_FATAL,
+			Tags:    map[string]string{"submitter": audit.Submitter, "path": r.URL.Path, "remote_addr": audit.RemoteAddr},
+			Stack:   stack,
+		})
 	}
+}
 
-	metaBytes, err1 := req.MakeMetaToBeSaved(remoteAddr)
-	if err1 != nil {
-		h.app.Log.Errorf("Error while marshaling JSON for metaToBeSaved: %v", err1)
-		w.WriteHeader(500)
-		writeErrorResponse(h.app, &w, "Unexpected server error")
+func (h *SubmitH) recordAudit(audit *AuditRecord, rec *statusRecorder) {
+	if len(h.app.AuditLogs) == 0 && h.app.SubmitterStats == nil && h.app.DailyReports == nil && h.app.Dashboard == nil {
 		return
 	}
+	audit.LatencyMs = float64(h.app.Now().Sub(audit.Time).Microseconds()) / 1000
+	audit.Status = rec.status
+	if rec.status == http.StatusOK {
+		audit.Result = AUDIT_RESULT_ACCEPTED
+	} else {
+		audit.Result = AUDIT_RESULT_REJECTED
+		audit.Reason = rec.errorMsg
+		if audit.Reason == "" {
+			audit.Reason = http.StatusText(rec.status)
+		}
+	}
+	for _, auditLog := range h.app.AuditLogs {
+		auditLog.Record(*audit)
+	}
+	// Keys of unregistered or unauthenticated submitters, which anyone can
+	// make up, would evict the statistics of submitters
+	if h.app.SubmitterStats != nil && audit.authorized {
+		h.app.SubmitterStats.Record(audit)
+	}
+	if h.app.DailyReports != nil {
+		h.app.DailyReports.Record(audit)
+	}
+	if h.app.Dashboard != nil {
+		h.app.Dashboard.Record(audit)
+	}
+}
 
-	toSave := make(ObjectsToSave)
-	toSave[ps.Meta] = metaBytes
-	toSave[ps.Block] = []byte(req.Data.Block.data)
+// Records the status code and error message of the response
+// for tracing and auditing
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	errorMsg string
+}
 
-	h.app.Log.Infof("Saving submission for submitter %s: block_hash=%s meta_path=%s block_path=%s", req.Submitter.String(), blockHash, ps.Meta, ps.Block)
-	h.app.Save(toSave)
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
 
-	_, err2 := io.Copy(w, bytes.NewReader([]byte("{\"status\":\"ok\"}")))
-	if err2 != nil {
-		h.app.Log.Debugf("Error while responding with ok status to the user: %v", err2)
-	} else {
-		h.app.Log.Infof("Successfully processed submission from %s", req.Submitter.String())
-	}
+// Unwrap lets http.ResponseController reach the underlying connection
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Pipeline returns the stages submissions go through, for their metrics
+func (h *SubmitH) Pipeline() *SubmitPipeline {
+	return h.pipeline
 }
 
 func (app *App) NewSubmitH() *SubmitH {
 	s := new(SubmitH)
 	s.app = app
+	s.pipeline = app.newSubmitPipeline()
+	s.schemaVersion = SUBMIT_SCHEMA_V1
 	return s
 }
+
+// WithSchema returns a handler of requests of another version of the
+// schema, sharing the pipeline of h
+func (h *SubmitH) WithSchema(version int) *SubmitH {
+	return &SubmitH{app: h.app, pipeline: h.pipeline, schemaVersion: version}
+}
@@ -0,0 +1,99 @@
+package delegation_backend
+
+import (
+	"block_producers_uptime/client"
+	"block_producers_uptime/metadata"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// A submission of a node built again by the client, with the signature
+// of the node, is accepted by the backend verifying signatures
+func TestClientSubmission(t *testing.T) {
+	var fixture submitRequest
+	body := readTestFile("req-with-snark", t)
+	if err := json.Unmarshal(body, &fixture); err != nil {
+		t.Fatal(err)
+	}
+	storage, sh, _ := testSubmitH(1, Whitelist{fixture.Submitter: true})
+	srv := httptest.NewServer(sh)
+	defer srv.Close()
+
+	signer := client.SignerFunc(func(hash []byte) (string, error) { return fixture.Sig.String(), nil })
+	req, err := client.Build(client.Submission{
+		Submitter:      fixture.Submitter.String(),
+		Block:          fixture.Data.Block.data,
+		CreatedAt:      fixture.Data.CreatedAt,
+		PeerId:         fixture.Data.PeerId,
+		SnarkWork:      fixture.Data.SnarkWork.data,
+		PayloadVersion: client.SIGN_PAYLOAD_V1,
+	}, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHash, _ := fixture.Data.SignPayloadHash()
+	if !bytes.Equal(req.SignPayloadHash, expectedHash) || req.BlockHash != fixture.GetBlockDataHash() {
+		t.Fatal("expected the sign payload and the block hash of the node")
+	}
+	resp, err := client.New(srv.URL).Submit(context.Background(), req)
+	if err != nil || resp.Status != client.STATUS_OK {
+		t.Fatalf("expected the submission accepted, got %+v, error: %v", resp, err)
+	}
+	if _, saved := (*storage)[makePaths(sh.app.Now(), req.BlockHash, fixture.Submitter).Block]; !saved {
+		t.Fatal("expected the block saved")
+	}
+
+	// Rejections are decoded, rate limiting isn't retried beyond attempts
+	c := client.New(srv.URL)
+	c.MaxAttempts, c.Backoff = 2, time.Millisecond
+	_, err = c.Submit(context.Background(), req)
+	var rejection *client.Error
+	if !errors.As(err, &rejection) || rejection.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected the submission rate limited, got %v", err)
+	}
+}
+
+// Sign payloads of version 2 of the client are those of the backend,
+// strings with characters escaped included
+func TestClientSignPayloadV2(t *testing.T) {
+	port := 3085
+	submission := client.Submission{
+		Submitter:          "B62qoJC4KuLXgTEX2uwQGPNZSnqRTvJHzcEzkWTDFTXMsqdXPNKxJLs",
+		Block:              []byte{0xfb, 0xff, 0xbf, 1, 2, 3},
+		CreatedAt:          time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 7200)),
+		PeerId:             "12D3KooW<peer>&\"id\"",
+		SnarkWork:          []byte{0xff, 0xfe},
+		GraphqlControlPort: port,
+		BuiltWithCommitSha: "449dc6a4 ",
+		Telemetry:          &metadata.Telemetry{PeerCount: &port, SyncStatus: "SYNCED"},
+	}
+	req, err := client.Build(submission, client.SignerFunc(func(hash []byte) (string, error) { return Sig{}.String(), nil }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded submitRequest
+	if err := json.Unmarshal(req.Body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.PayloadVersion != SIGN_PAYLOAD_V2 || decoded.Telemetry == nil || req.Path != client.SUBMIT_PATH_V2 {
+		t.Fatalf("unexpected request %s to %s", req.Body, req.Path)
+	}
+	expectedHash, err := decoded.signPayloadHash()
+	if err != nil || !bytes.Equal(req.SignPayloadHash, expectedHash) {
+		t.Fatalf("expected the sign payload of the backend, error: %v", err)
+	}
+	value, err := DecodeJSONValue(req.Body)
+	if schemaErrs := ValidateSubmission(value, SUBMIT_SCHEMA_V2); err != nil || len(schemaErrs) != 0 {
+		t.Fatalf("expected the request valid, got %v, error: %v", schemaErrs, err)
+	}
+	if base64.StdEncoding.EncodeToString(decoded.Data.Block.data) != base64.StdEncoding.EncodeToString(submission.Block) {
+		t.Fatal("expected the block decoded as built")
+	}
+}
@@ -0,0 +1,218 @@
+package delegation_backend
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// Body of a submission is read and validated in chunks of this size
+	SUBMIT_READ_CHUNK_SIZE = 64 << 10
+	// Max size (in bytes, as sent) of fields other than block and snark work
+	MAX_SUBMIT_FIELD_SIZE = 16 << 10
+	// Objects of a submission are nested two levels deep at most,
+	// e.g. {"data":{"block":...}}, values of unknown fields may be deeper
+	MAX_SUBMIT_DEPTH = 4
+)
+
+// Error of a body rejected by submitScanner as malformed
+var errMalformedSubmission = errors.New("malformed submission")
+
+// Error of a submission exceeding the size limit of a field, the
+// remainder of the body is not read
+type fieldTooLargeError struct {
+	Field string
+	Limit int
+}
+
+func (e *fieldTooLargeError) Error() string {
+	return fmt.Sprintf("field %s exceeds %d bytes", e.Field, e.Limit)
+}
+
+// submitScanner validates the JSON of a submission as its body is read,
+// so that a body exceeding the limits of its fields is rejected before it
+// is buffered fully. It checks the structure and sizes only, values are
+// decoded by json.Unmarshal once the body is read.
+type submitScanner struct {
+	// Max size of the base64 string of the block and the snark work
+	maxBlockField int
+	// Open objects ('{') and arrays ('[')
+	stack []byte
+	// Key of the value being scanned by depth, for the first two levels
+	keys      [2]string
+	key       []byte
+	expectKey bool
+	inString  bool
+	isKey     bool
+	escaped   bool
+	// Length of the string or other value being scanned, and its limit
+	length    int
+	limit     int
+	field     string
+	base64    bool
+	blockSeen bool
+	started   bool
+}
+
+func newSubmitScanner(maxBlockSize int) *submitScanner {
+	return &submitScanner{maxBlockField: base64.StdEncoding.EncodedLen(maxBlockSize)}
+}
+
+// Field of a value starting at the current position and its size limit
+func (s *submitScanner) valueField() (string, int) {
+	depth := len(s.stack)
+	if depth == 0 || s.stack[depth-1] != '{' || depth > len(s.keys) {
+		return "", MAX_SUBMIT_FIELD_SIZE
+	}
+	if depth == 2 && s.keys[0] == "data" {
+		switch s.keys[1] {
+		case "block", "snark_work":
+			return "data." + s.keys[1], s.maxBlockField
+		}
+		return "data." + s.keys[1], MAX_SUBMIT_FIELD_SIZE
+	}
+	return s.keys[depth-1], MAX_SUBMIT_FIELD_SIZE
+}
+
+func (s *submitScanner) grow(n int) error {
+	s.length += n
+	if s.length > s.limit {
+		field := s.field
+		if field == "" {
+			field = "value"
+		}
+		return &fieldTooLargeError{Field: field, Limit: s.limit}
+	}
+	return nil
+}
+
+// Scan the next chunk of the body
+func (s *submitScanner) Scan(chunk []byte) error {
+	for _, c := range chunk {
+		if s.inString {
+			switch {
+			case s.escaped:
+				s.escaped = false
+			case c == '\\':
+				s.escaped = true
+			case c == '"':
+				s.inString = false
+				if s.isKey {
+					if depth := len(s.stack); depth <= len(s.keys) {
+						s.keys[depth-1] = string(s.key)
+					}
+					continue
+				}
+				s.length = 0
+				continue
+			case s.base64 && !isBase64Char(c):
+				return fmt.Errorf("%w: field %s is not base64", errMalformedSubmission, s.field)
+			}
+			if s.isKey && len(s.key) < MAX_SUBMIT_FIELD_SIZE {
+				s.key = append(s.key, c)
+			}
+			if err := s.grow(1); err != nil {
+				return err
+			}
+			continue
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			s.length = 0
+		case '{', '[':
+			if !s.started && c != '{' {
+				return fmt.Errorf("%w: submission is not a JSON object", errMalformedSubmission)
+			}
+			s.started = true
+			if len(s.stack) == MAX_SUBMIT_DEPTH {
+				return fmt.Errorf("%w: submission is nested too deep", errMalformedSubmission)
+			}
+			s.stack = append(s.stack, c)
+			s.expectKey = c == '{'
+			s.length = 0
+		case '}', ']':
+			if len(s.stack) == 0 {
+				return fmt.Errorf("%w: unbalanced submission", errMalformedSubmission)
+			}
+			s.stack = s.stack[:len(s.stack)-1]
+			s.expectKey = false
+			s.length = 0
+		case ',':
+			s.expectKey = len(s.stack) > 0 && s.stack[len(s.stack)-1] == '{'
+			s.length = 0
+		case ':':
+			s.expectKey = false
+			s.length = 0
+		case '"':
+			if !s.started {
+				return fmt.Errorf("%w: submission is not a JSON object", errMalformedSubmission)
+			}
+			s.inString = true
+			s.isKey = s.expectKey
+			s.length = 0
+			s.base64 = false
+			if s.isKey {
+				s.key = s.key[:0]
+				s.field, s.limit = "key", MAX_SUBMIT_FIELD_SIZE
+				continue
+			}
+			s.field, s.limit = s.valueField()
+			s.base64 = s.field == "data.block" || s.field == "data.snark_work"
+			if s.field == "data.block" {
+				// A second block would be buffered for nothing
+				if s.blockSeen {
+					return fmt.Errorf("%w: duplicate field data.block", errMalformedSubmission)
+				}
+				s.blockSeen = true
+			}
+		default:
+			// Numbers and literals
+			if !s.started {
+				return fmt.Errorf("%w: submission is not a JSON object", errMalformedSubmission)
+			}
+			if s.length == 0 {
+				s.field, s.limit = s.valueField()
+				s.limit = min(s.limit, MAX_SUBMIT_FIELD_SIZE)
+			}
+			if err := s.grow(1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Base64 characters, and backslashes of escapes handled by Base64.UnmarshalJSON
+func isBase64Char(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+		c == '+' || c == '/' || c == '=' || c == '\\'
+}
+
+// readSubmitBody reads a body of the declared length into a pooled buffer,
+// scanning it chunk by chunk. The buffer grows as the body arrives rather
+// than being allocated for the declared length upfront, and reading stops
+// at the first chunk the scanner rejects. The buffer read so far is
+// returned in any case, to be returned to bodyBuffers.
+func readSubmitBody(r io.Reader, length int, scanner *submitScanner) ([]byte, error) {
+	body := bodyBuffers.Get(min(length, SUBMIT_READ_CHUNK_SIZE))[:0]
+	for len(body) < length {
+		if len(body) == cap(body) {
+			grown := bodyBuffers.Get(min(length, 2*cap(body)))
+			copy(grown, body)
+			bodyBuffers.Put(body)
+			body = grown[:len(body)]
+		}
+		end := min(cap(body), min(length, len(body)+SUBMIT_READ_CHUNK_SIZE))
+		n, err := io.ReadFull(r, body[len(body):end])
+		if scanErr := scanner.Scan(body[len(body) : len(body)+n]); scanErr != nil {
+			return body[:len(body)+n], scanErr
+		}
+		body = body[:len(body)+n]
+		if err != nil {
+			return body, err
+		}
+	}
+	return body, nil
+}
@@ -0,0 +1,93 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Reader counting the bytes read from it
+type countingReader struct {
+	r    io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestReadSubmitBody(t *testing.T) {
+	for _, f := range []string{"req-no-snark", "req-with-snark", "req-v1-with-snark"} {
+		body := readTestFile(f, t)
+		read, err := readSubmitBody(bytes.NewReader(body), len(body), newSubmitScanner(MAX_SUBMIT_PAYLOAD_SIZE))
+		if err != nil || !bytes.Equal(read, body) {
+			t.Fatalf("failed reading %s: %v", f, err)
+		}
+	}
+}
+
+func TestReadSubmitBodyStopsAtOversizedField(t *testing.T) {
+	body := `{"submitter":"` + strings.Repeat("B", MAX_SUBMIT_FIELD_SIZE+1) + `","data":{"block":"` + strings.Repeat("A", 4*SUBMIT_READ_CHUNK_SIZE) + `"}}`
+	r := &countingReader{r: strings.NewReader(body)}
+	_, err := readSubmitBody(r, len(body), newSubmitScanner(MAX_SUBMIT_PAYLOAD_SIZE))
+	var tooLarge *fieldTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Field != "submitter" {
+		t.Fatalf("expected submitter too large, got %v", err)
+	}
+	if r.read > SUBMIT_READ_CHUNK_SIZE {
+		t.Fatalf("expected reading stopped at the first chunk, read %d bytes", r.read)
+	}
+
+	body = `{"data":{"block":"` + strings.Repeat("A", 4*SUBMIT_READ_CHUNK_SIZE) + `"}}`
+	r = &countingReader{r: strings.NewReader(body)}
+	_, err = readSubmitBody(r, len(body), newSubmitScanner(SUBMIT_READ_CHUNK_SIZE))
+	if !errors.As(err, &tooLarge) || tooLarge.Field != "data.block" {
+		t.Fatalf("expected block too large, got %v", err)
+	}
+	if r.read >= len(body) {
+		t.Fatal("expected the block rejected before it was read fully")
+	}
+}
+
+func TestSubmitScannerMalformed(t *testing.T) {
+	bodies := []string{
+		`["data"]`,
+		`"data"`,
+		`{"data":{"block":"AAAA","block":"AAAA"}}`,
+		`{"data":{"block":"AA AA"}}`,
+		`{"x":[[[[[1]]]]]}`,
+		`{}}`,
+	}
+	for _, body := range bodies {
+		err := newSubmitScanner(MAX_SUBMIT_PAYLOAD_SIZE).Scan([]byte(body))
+		if !errors.Is(err, errMalformedSubmission) {
+			t.Errorf("expected %s rejected as malformed, got %v", body, err)
+		}
+	}
+	// Escapes are left to the decoder
+	if err := newSubmitScanner(MAX_SUBMIT_PAYLOAD_SIZE).Scan([]byte(`{"data":{"block":"AA\/A","peer_id":"a\"b"}}`)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubmitBlockTooLarge(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	_, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.SubmitSizes = NewSubmitSizes(SubmitSizeLimits{MaxPayloadSize: MAX_SUBMIT_PAYLOAD_SIZE, MaxBlockSize: int64(len(req.Data.Block.data) - 3)}, sh.app.Log)
+	if rep := sh.testRequest(body); rep.Code != 413 {
+		t.Fatalf("expected the block rejected, got %v", rep)
+	}
+	sh.app.SubmitSizes.SetLimits(SubmitSizeLimits{MaxPayloadSize: MAX_SUBMIT_PAYLOAD_SIZE, MaxBlockSize: int64(len(req.Data.Block.data))})
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("unexpected failure: %v", rep)
+	}
+}
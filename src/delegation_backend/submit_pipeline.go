@@ -0,0 +1,205 @@
+package delegation_backend
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Stages of the submit pipeline, in the order they run
+const (
+	SUBMIT_STAGE_DECODE       = "decode"
+	SUBMIT_STAGE_VALIDATE     = "validate"
+	SUBMIT_STAGE_AUTHORIZE    = "authorize"
+	SUBMIT_STAGE_AUTHENTICATE = "authenticate"
+	SUBMIT_STAGE_IDEMPOTENCY  = "idempotency"
+	SUBMIT_STAGE_RATE_LIMIT   = "rate_limit"
+	SUBMIT_STAGE_PERSIST      = "persist"
+	SUBMIT_STAGE_RESPOND      = "respond"
+)
+
+// Rejection of a submission by a stage, responded with its status and
+// error message. Rejections without a message are responded without a body.
+type Rejection struct {
+	Status  int
+	Message string
+	// Machine-readable reason of the rejection, if any, responded along
+	// with the message
+	Code string
+	// Values of the submission not matching the schema, if rejected for it
+	Errors []SchemaError
+	// What the exporter can do about the rejection, if known, responded
+	// along with the message
+	Hint string
+	// Seconds the client should wait before retrying, if set
+	RetryAfter int
+	// Connection is closed after the response, e.g. when its body isn't read
+	Close bool
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("%d %s", r.Status, r.Message)
+}
+
+func reject(status int, message string) *Rejection {
+	return &Rejection{Status: status, Message: message}
+}
+
+// withHint sets the hint of the rejection
+func (r *Rejection) withHint(format string, a ...interface{}) *Rejection {
+	r.Hint = fmt.Sprintf(format, a...)
+	return r
+}
+
+// Duration rounded for hints, e.g. 7m or 2h5m
+func hintDuration(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+}
+
+// submission carries a submission through the stages of the pipeline,
+// stages fill in what later stages rely on. It holds no HTTP types, so
+// that submissions received otherwise can go through the same stages.
+type submission struct {
+	ctx    context.Context
+	span   trace.Span
+	canary *CanaryResult
+	audit  *AuditRecord
+
+	// Received by the transport
+	remoteAddr       string
+	peerCertificates []*x509.Certificate
+	body             io.Reader
+	contentLength    int64
+	// Version of the schema of the request, by endpoint
+	schemaVersion int
+	// Optional, sets the deadline of reading the body
+	setReadDeadline func(time.Time) error
+
+	req                 submitRequest
+	submittedAt         time.Time
+	signer              Pk
+	blockHashStr        string
+	paths               Paths
+	ipLockoutKey        string
+	submitterLockoutKey string
+	response            submitResponse
+	// The submission window is already credited, the submission isn't saved
+	duplicate bool
+	// Set by the transport, from the Idempotency-Key header
+	idempotencyKey string
+	// Response to the submission accepted with the idempotency key, for
+	// a retry of it
+	replayed *submitResponse
+	// ULID of the accepted submission, set if receipts are indexed
+	receiptId string
+	// Run once the submission is responded to, e.g. to return pooled buffers
+	cleanups []func()
+	// Body of the request, pooled, valid until the cleanups run
+	rawBody []byte
+}
+
+// Hash of the block, computed once
+func (s *submission) blockHash() string {
+	if s.blockHashStr == "" {
+		s.blockHashStr = s.req.GetBlockDataHash()
+	}
+	return s.blockHashStr
+}
+
+func (s *submission) onDone(cleanup func()) {
+	s.cleanups = append(s.cleanups, cleanup)
+}
+
+// Run the cleanups, last registered first
+func (s *submission) done() {
+	for i := len(s.cleanups) - 1; i >= 0; i-- {
+		s.cleanups[i]()
+	}
+	s.cleanups = nil
+}
+
+// submitStage is a step of handling a submission, it either passes the
+// submission on to the next stage or rejects it
+type submitStage interface {
+	Name() string
+	Process(s *submission) *Rejection
+}
+
+type stageStats struct {
+	processed atomic.Int64
+	rejected  atomic.Int64
+	nanos     atomic.Int64
+}
+
+// SubmitPipeline runs submissions through its stages in order, until one
+// rejects it, and counts submissions processed, rejected and the time
+// spent by stage.
+type SubmitPipeline struct {
+	stages []submitStage
+	stats  []stageStats
+}
+
+func NewSubmitPipeline(stages ...submitStage) *SubmitPipeline {
+	return &SubmitPipeline{stages: stages, stats: make([]stageStats, len(stages))}
+}
+
+// Run the submission through the stages, returning the rejection if any
+func (p *SubmitPipeline) Run(s *submission) *Rejection {
+	for i, stage := range p.stages {
+		start := time.Now()
+		rejection := stage.Process(s)
+		stats := &p.stats[i]
+		stats.nanos.Add(int64(time.Since(start)))
+		stats.processed.Add(1)
+		if rejection != nil {
+			stats.rejected.Add(1)
+			incMetric("submit_rejected_" + stage.Name())
+			return rejection
+		}
+	}
+	return nil
+}
+
+func (p *SubmitPipeline) WritePrometheus(w io.Writer) {
+	fmt.Fprintf(w, "# HELP uptime_submit_stage_processed_total Submissions processed by stage of the submit pipeline.\n# TYPE uptime_submit_stage_processed_total counter\n")
+	for i, stage := range p.stages {
+		fmt.Fprintf(w, "uptime_submit_stage_processed_total{stage=\"%s\"} %d\n", stage.Name(), p.stats[i].processed.Load())
+	}
+	fmt.Fprintf(w, "# HELP uptime_submit_stage_rejected_total Submissions rejected by stage of the submit pipeline.\n# TYPE uptime_submit_stage_rejected_total counter\n")
+	for i, stage := range p.stages {
+		fmt.Fprintf(w, "uptime_submit_stage_rejected_total{stage=\"%s\"} %d\n", stage.Name(), p.stats[i].rejected.Load())
+	}
+	fmt.Fprintf(w, "# HELP uptime_submit_stage_seconds_total Time spent by stage of the submit pipeline.\n# TYPE uptime_submit_stage_seconds_total counter\n")
+	for i, stage := range p.stages {
+		fmt.Fprintf(w, "uptime_submit_stage_seconds_total{stage=\"%s\"} %s\n", stage.Name(), strconv.FormatFloat(time.Duration(p.stats[i].nanos.Load()).Seconds(), 'g', -1, 64))
+	}
+}
+
+// Stages of submissions received by the submit handler. Authorization
+// precedes authentication, so that submissions of submitters which aren't
+// whitelisted don't cost a signature verification.
+func (app *App) newSubmitPipeline() *SubmitPipeline {
+	return NewSubmitPipeline(
+		&decodeStage{app},
+		&validateStage{app},
+		&authorizeStage{app},
+		&authenticateStage{app},
+		&idempotencyStage{app},
+		&rateLimitStage{app},
+		&persistStage{app},
+		&respondStage{app},
+	)
+}
@@ -0,0 +1,175 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type stageMock struct {
+	name      string
+	rejection *Rejection
+	runs      int
+}
+
+func (m *stageMock) Name() string { return m.name }
+
+func (m *stageMock) Process(*submission) *Rejection {
+	m.runs++
+	return m.rejection
+}
+
+// Submission of the body, as received by the submit handler
+func testSubmission(body []byte) *submission {
+	ctx := context.Background()
+	return &submission{
+		ctx:           ctx,
+		span:          trace.SpanFromContext(ctx),
+		audit:         &AuditRecord{},
+		remoteAddr:    "192.0.2.1:1234",
+		body:          bytes.NewReader(body),
+		contentLength: int64(len(body)),
+	}
+}
+
+// Submission of the test file, decoded
+func decodedTestSubmission(t *testing.T, app *App, name string) *submission {
+	s := testSubmission(readTestFile(name, t))
+	t.Cleanup(s.done)
+	if rejection := (&decodeStage{app}).Process(s); rejection != nil {
+		t.Fatalf("failed decoding %s: %v", name, rejection)
+	}
+	return s
+}
+
+func TestSubmitPipeline(t *testing.T) {
+	first := &stageMock{name: "first"}
+	second := &stageMock{name: "second", rejection: reject(429, "Too many")}
+	third := &stageMock{name: "third"}
+	pipeline := NewSubmitPipeline(first, second, third)
+	if rejection := pipeline.Run(testSubmission(nil)); rejection != second.rejection {
+		t.Fatalf("expected the rejection of the second stage, got %v", rejection)
+	}
+	if first.runs != 1 || second.runs != 1 || third.runs != 0 {
+		t.Fatal("expected the stages after the rejection skipped")
+	}
+	var metrics strings.Builder
+	pipeline.WritePrometheus(&metrics)
+	for _, line := range []string{
+		`uptime_submit_stage_processed_total{stage="second"} 1`,
+		`uptime_submit_stage_processed_total{stage="third"} 0`,
+		`uptime_submit_stage_rejected_total{stage="first"} 0`,
+		`uptime_submit_stage_rejected_total{stage="second"} 1`,
+	} {
+		if !strings.Contains(metrics.String(), line+"\n") {
+			t.Errorf("expected %s in:\n%s", line, metrics.String())
+		}
+	}
+}
+
+func TestDecodeStage(t *testing.T) {
+	_, sh, _ := testSubmitH(1, Whitelist{})
+	stage := &decodeStage{sh.app}
+	s := testSubmission([]byte("{}"))
+	s.contentLength = -1
+	if rejection := stage.Process(s); rejection == nil || rejection.Status != 411 || rejection.Hint == "" {
+		t.Fatalf("expected length required, got %v", rejection)
+	}
+	s = testSubmission([]byte("{\"data\":"))
+	defer s.done()
+	if rejection := stage.Process(s); rejection == nil || rejection.Status != 400 {
+		t.Fatalf("expected malformed body rejected, got %v", rejection)
+	}
+
+	s = decodedTestSubmission(t, sh.app, "req-with-snark")
+	if s.req.Data.Block == nil || s.audit.Submitter != s.req.Submitter.String() || s.audit.BlockSize != len(s.req.Data.Block.data) {
+		t.Fatalf("expected the submission decoded and audited, got %+v", s.audit)
+	}
+}
+
+func TestValidateStage(t *testing.T) {
+	_, sh, _ := testSubmitH(1, Whitelist{})
+	s := decodedTestSubmission(t, sh.app, "req-with-snark")
+	if rejection := (&validateStage{sh.app}).Process(s); rejection != nil {
+		t.Fatalf("unexpected rejection: %v", rejection)
+	}
+	s.req.Data.PeerId = ""
+	if rejection := (&validateStage{sh.app}).Process(s); rejection == nil || rejection.Status != 400 {
+		t.Fatalf("expected missing field rejected, got %v", rejection)
+	}
+}
+
+func TestAuthorizeStage(t *testing.T) {
+	_, sh, tm := testSubmitH(1, Whitelist{})
+	stage := &authorizeStage{sh.app}
+	s := decodedTestSubmission(t, sh.app, "req-with-snark")
+	if rejection := stage.Process(s); rejection == nil || rejection.Status != 401 {
+		t.Fatalf("expected submitter not whitelisted rejected, got %v", rejection)
+	}
+	sh.app.Whitelist.Replace(&Whitelist{s.req.Submitter: true})
+	if rejection := stage.Process(s); rejection != nil {
+		t.Fatalf("unexpected rejection: %v", rejection)
+	}
+	if !s.submittedAt.Equal(tm.Now()) {
+		t.Fatalf("expected the time of submission set, got %v", s.submittedAt)
+	}
+	tm.time = s.req.Data.CreatedAt.Add(-time.Hour)
+	if rejection := stage.Process(s); rejection == nil || rejection.Status != 400 {
+		t.Fatalf("expected created_at in future rejected, got %v", rejection)
+	}
+}
+
+func TestAuthenticateStage(t *testing.T) {
+	_, sh, _ := testSubmitH(1, Whitelist{})
+	stage := &authenticateStage{sh.app}
+	s := decodedTestSubmission(t, sh.app, "req-with-snark")
+	if rejection := stage.Process(s); rejection != nil {
+		t.Fatalf("unexpected rejection: %v", rejection)
+	}
+	if s.signer != s.req.Submitter {
+		t.Fatal("expected the submitter to be the signer")
+	}
+	s.req.Sig[0] ^= 1
+	if rejection := stage.Process(s); rejection == nil || rejection.Status != 401 {
+		t.Fatalf("expected invalid signature rejected, got %v", rejection)
+	}
+}
+
+func TestRateLimitStage(t *testing.T) {
+	_, sh, _ := testSubmitH(1, Whitelist{})
+	stage := &rateLimitStage{sh.app}
+	s := decodedTestSubmission(t, sh.app, "req-with-snark")
+	if rejection := stage.Process(s); rejection != nil {
+		t.Fatalf("unexpected rejection: %v", rejection)
+	}
+	if rejection := stage.Process(s); rejection == nil || rejection.Status != 429 {
+		t.Fatalf("expected the hourly limit enforced, got %v", rejection)
+	}
+}
+
+func TestPersistAndRespondStages(t *testing.T) {
+	storage, sh, tm := testSubmitH(1, Whitelist{})
+	s := decodedTestSubmission(t, sh.app, "req-with-snark")
+	s.submittedAt = tm.Now()
+	if rejection := (&persistStage{sh.app}).Process(s); rejection != nil {
+		t.Fatalf("unexpected rejection: %v", rejection)
+	}
+	paths := makePaths(tm.Now(), s.req.GetBlockDataHash(), s.req.Submitter)
+	if s.paths != paths || !bytes.Equal((*storage)[paths.Block], s.req.Data.Block.data) || len((*storage)[paths.Meta]) == 0 {
+		t.Fatalf("expected the submission saved to %+v", paths)
+	}
+	if s.audit.BlockHash != s.req.GetBlockDataHash() {
+		t.Fatal("expected the block hash audited")
+	}
+	if rejection := (&respondStage{sh.app}).Process(s); rejection != nil {
+		t.Fatalf("unexpected rejection: %v", rejection)
+	}
+	if resp, _ := json.Marshal(s.response); string(resp) != `{"status":"ok"}` {
+		t.Fatalf("unexpected response %s", resp)
+	}
+}
@@ -0,0 +1,110 @@
+package delegation_backend
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Max schema errors responded for a submission, the remainder is dropped
+const SUBMIT_SCHEMA_MAX_ERRORS = 16
+
+// Code of rejections of submissions not matching the schema
+const SUBMIT_SCHEMA_ERROR_CODE = "schema"
+
+// JSON Schema of /submit requests, submissions are validated against it
+// before they are decoded into requests, so that clients are told which
+// field is wrong
+//
+//go:embed submit_schema.json
+var submitSchemaJSON []byte
+
+// Schemas of /submit requests by version of the schema of requests
+var submitSchemas = loadSubmitSchemas()
+
+// SchemaError is a value of a submission not matching the schema, at the
+// JSON pointer of the value (e.g. `/data/created_at`)
+type SchemaError struct {
+	Path string `json:"path"`
+	Msg  string `json:"error"`
+}
+
+func (e SchemaError) Error() string {
+	return e.Path + ": " + e.Msg
+}
+
+// Schemas of both versions, compiled once on start
+func loadSubmitSchemas() map[int]*jsonschema.Schema {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(submitSchemaJSON, &doc); err != nil {
+		panic(fmt.Sprintf("invalid submit schema: %v", err))
+	}
+	v2 := compileSubmitSchema("submit_schema_v2.json", submitSchemaJSON)
+	// Telemetry is ignored by /v1/submit, as unknown fields are
+	delete(doc["properties"].(map[string]interface{}), "telemetry")
+	v1JSON, _ := json.Marshal(doc)
+	v1 := compileSubmitSchema("submit_schema_v1.json", v1JSON)
+	return map[int]*jsonschema.Schema{SUBMIT_SCHEMA_V1: v1, SUBMIT_SCHEMA_V2: v2}
+}
+
+func compileSubmitSchema(url string, schemaJSON []byte) *jsonschema.Schema {
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	compiler.AssertFormat = true
+	if err := compiler.AddResource(url, bytes.NewReader(schemaJSON)); err != nil {
+		panic(fmt.Sprintf("invalid submit schema: %v", err))
+	}
+	return compiler.MustCompile(url)
+}
+
+// DecodeJSONValue decodes a JSON document as the value validated against
+// the schema, numbers as json.Number so that integers stay exact
+func DecodeJSONValue(body []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid character after top-level value")
+	}
+	return value, nil
+}
+
+// ValidateSubmission validates a submission decoded with DecodeJSONValue
+// against the schema of its version, returning the values not matching it
+func ValidateSubmission(value interface{}, schemaVersion int) []SchemaError {
+	schema, ok := submitSchemas[schemaVersion]
+	if !ok {
+		schema = submitSchemas[SUBMIT_SCHEMA_V1]
+	}
+	var validationErr *jsonschema.ValidationError
+	if err := schema.Validate(value); !errors.As(err, &validationErr) {
+		return nil
+	}
+	var errs []SchemaError
+	addSchemaErrors(validationErr, &errs)
+	// In a stable order, as properties are validated in any order
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	if len(errs) > SUBMIT_SCHEMA_MAX_ERRORS {
+		errs = errs[:SUBMIT_SCHEMA_MAX_ERRORS]
+	}
+	return errs
+}
+
+// Add the causes of the error which have no causes of their own
+func addSchemaErrors(err *jsonschema.ValidationError, errs *[]SchemaError) {
+	if len(err.Causes) == 0 {
+		*errs = append(*errs, SchemaError{Path: err.InstanceLocation, Msg: err.Message})
+	}
+	for _, cause := range err.Causes {
+		addSchemaErrors(cause, errs)
+	}
+}
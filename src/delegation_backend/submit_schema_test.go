@@ -0,0 +1,78 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateSubmission(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	value, err := DecodeJSONValue(body)
+	if errs := ValidateSubmission(value, SUBMIT_SCHEMA_V2); err != nil || len(errs) != 0 {
+		t.Fatalf("expected the request to match the schema, got %v, error: %v", errs, err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	data := doc["data"].(map[string]interface{})
+	delete(data, "peer_id")
+	data["created_at"] = "yesterday"
+	data["graphql_control_port"] = 3085.5
+	doc["submitter"] = 42
+	doc["telemetry"] = map[string]interface{}{"peer_count": -1}
+	invalidJSON, _ := json.Marshal(doc)
+	invalid, err := DecodeJSONValue(invalidJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		version int
+		paths   []string
+	}{
+		{SUBMIT_SCHEMA_V2, []string{"/data", "/data/created_at", "/data/graphql_control_port", "/submitter", "/telemetry/peer_count"}},
+		// Telemetry is ignored by /v1/submit
+		{SUBMIT_SCHEMA_V1, []string{"/data", "/data/created_at", "/data/graphql_control_port", "/submitter"}},
+	} {
+		errs := ValidateSubmission(invalid, c.version)
+		var paths []string
+		for _, e := range errs {
+			paths = append(paths, e.Path)
+		}
+		if !reflect.DeepEqual(paths, c.paths) {
+			t.Errorf("expected errors at %v for version %d, got %v", c.paths, c.version, errs)
+		}
+	}
+
+	withoutData, _ := DecodeJSONValue([]byte(`{"data":null}`))
+	if errs := ValidateSubmission(withoutData, SUBMIT_SCHEMA_V1); len(errs) != 2 || errs[1].Path != "/data" || errs[1].Msg != "expected object, but got null" {
+		t.Errorf("unexpected errors of a submission without data: %v", errs)
+	}
+	for _, doc := range []string{`{"data":`, `{"data":{}} {}`} {
+		if _, err := DecodeJSONValue([]byte(doc)); err == nil {
+			t.Errorf("expected an error decoding %s", doc)
+		}
+	}
+}
+
+func TestSubmitSchemaErrors(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	_, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	invalid := strings.Replace(string(body), `"peer_id"`, `"peerId"`, 1)
+	rep := sh.testRequest([]byte(invalid))
+	var resp submitErrorResponse
+	if err := json.Unmarshal(rep.Body.Bytes(), &resp); err != nil || rep.Code != 400 || resp.Code != SUBMIT_SCHEMA_ERROR_CODE {
+		t.Fatalf("expected a rejection of the schema, got %d: %s", rep.Code, rep.Body)
+	}
+	expected := []SchemaError{{Path: "/data", Msg: "missing properties: 'peer_id'"}}
+	if !reflect.DeepEqual(resp.Errors, expected) || !strings.Contains(resp.Msg, "peer_id") {
+		t.Fatalf("expected errors %v, got %+v", expected, resp)
+	}
+}
@@ -0,0 +1,225 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Upper bounds (in bytes) of the buckets of the distributions of sizes of
+// submissions
+var SUBMIT_SIZE_BUCKETS = []int64{16 << 10, 64 << 10, 256 << 10, 1 << 20, 2 << 20, 4 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// Limits of the size of submissions of a network
+type SubmitSizeConfig struct {
+	// Max size (in bytes) of the body of a submission [default:
+	// MAX_SUBMIT_PAYLOAD_SIZE]
+	MaxPayloadSize int64 `json:"max_payload_size,omitempty"`
+	// Max size (in bytes) of a decoded block [default: max_payload_size]
+	MaxBlockSize int64 `json:"max_block_size,omitempty"`
+}
+
+// Limits of the size of submissions by network name, so that a hard fork
+// growing blocks is a change of the configuration
+type SubmitSizeConfigs map[string]*SubmitSizeConfig
+
+// Limits of the size of submissions in effect
+type SubmitSizeLimits struct {
+	MaxPayloadSize int64 `json:"max_payload_size"`
+	MaxBlockSize   int64 `json:"max_block_size"`
+}
+
+// Limits of submissions of the network
+func (configs SubmitSizeConfigs) Limits(networkName string) SubmitSizeLimits {
+	limits := SubmitSizeLimits{MaxPayloadSize: MAX_SUBMIT_PAYLOAD_SIZE}
+	config := configs[networkName]
+	if config != nil && config.MaxPayloadSize > 0 {
+		limits.MaxPayloadSize = config.MaxPayloadSize
+	}
+	limits.MaxBlockSize = limits.MaxPayloadSize
+	if config != nil && config.MaxBlockSize > 0 {
+		limits.MaxBlockSize = config.MaxBlockSize
+	}
+	return limits
+}
+
+// Problems of limits of the size of submissions, by network
+func validateSubmitSizeConfigs(configs SubmitSizeConfigs) []string {
+	networks := make([]string, 0, len(configs))
+	for network := range configs {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	var problems []string
+	for _, network := range networks {
+		config := configs[network]
+		if config != nil && (config.MaxPayloadSize < 0 || config.MaxBlockSize < 0) {
+			problems = append(problems, fmt.Sprintf("%s: expected positive sizes, got max_payload_size %d and max_block_size %d", network, config.MaxPayloadSize, config.MaxBlockSize))
+		}
+	}
+	return problems
+}
+
+// Distribution of sizes, by the buckets of SUBMIT_SIZE_BUCKETS
+type sizeHistogram struct {
+	// Sizes within each bucket, the last one counts sizes above all bounds
+	buckets []atomic.Int64
+	sum     atomic.Int64
+	count   atomic.Int64
+}
+
+func newSizeHistogram() *sizeHistogram {
+	return &sizeHistogram{buckets: make([]atomic.Int64, len(SUBMIT_SIZE_BUCKETS)+1)}
+}
+
+func (h *sizeHistogram) Observe(size int64) {
+	i := sort.Search(len(SUBMIT_SIZE_BUCKETS), func(i int) bool { return size <= SUBMIT_SIZE_BUCKETS[i] })
+	h.buckets[i].Add(1)
+	h.sum.Add(size)
+	h.count.Add(1)
+}
+
+// Cumulative counts by upper bound, as of Prometheus histograms
+func (h *sizeHistogram) cumulative() []int64 {
+	counts := make([]int64, len(h.buckets))
+	var total int64
+	for i := range h.buckets {
+		total += h.buckets[i].Load()
+		counts[i] = total
+	}
+	return counts
+}
+
+func (h *sizeHistogram) writePrometheus(w io.Writer, name string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	counts := h.cumulative()
+	for i, bound := range SUBMIT_SIZE_BUCKETS {
+		fmt.Fprintf(w, "%s_bucket{le=\"%d\"} %d\n", name, bound, counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, counts[len(counts)-1])
+	fmt.Fprintf(w, "%s_sum %d\n%s_count %d\n", name, h.sum.Load(), name, h.count.Load())
+}
+
+// Count of sizes up to a bound, as responded by the admin API
+type SizeBucket struct {
+	// Upper bound in bytes, empty for the bucket of all sizes
+	Le    string `json:"le"`
+	Count int64  `json:"count"`
+}
+
+func (h *sizeHistogram) status() []SizeBucket {
+	counts := h.cumulative()
+	buckets := make([]SizeBucket, 0, len(counts))
+	for i, bound := range SUBMIT_SIZE_BUCKETS {
+		buckets = append(buckets, SizeBucket{Le: strconv.FormatInt(bound, 10), Count: counts[i]})
+	}
+	return append(buckets, SizeBucket{Le: "+Inf", Count: counts[len(counts)-1]})
+}
+
+// SubmitSizes holds the limits of the size of submissions, which may be
+// raised at runtime, and the distributions of the sizes of payloads and
+// of blocks submitted, for limits to be set by the sizes seen
+type SubmitSizes struct {
+	maxPayloadSize atomic.Int64
+	maxBlockSize   atomic.Int64
+	payloads       *sizeHistogram
+	blocks         *sizeHistogram
+	log            logging.StandardLogger
+}
+
+func NewSubmitSizes(limits SubmitSizeLimits, log logging.StandardLogger) *SubmitSizes {
+	s := &SubmitSizes{payloads: newSizeHistogram(), blocks: newSizeHistogram(), log: log}
+	s.maxPayloadSize.Store(limits.MaxPayloadSize)
+	s.maxBlockSize.Store(limits.MaxBlockSize)
+	return s
+}
+
+func (s *SubmitSizes) Limits() SubmitSizeLimits {
+	return SubmitSizeLimits{MaxPayloadSize: s.maxPayloadSize.Load(), MaxBlockSize: s.maxBlockSize.Load()}
+}
+
+// SetLimits applies the limits to submissions received from now on
+func (s *SubmitSizes) SetLimits(limits SubmitSizeLimits) error {
+	if limits.MaxPayloadSize <= 0 || limits.MaxBlockSize <= 0 {
+		return fmt.Errorf("expected positive sizes, got max_payload_size %d and max_block_size %d", limits.MaxPayloadSize, limits.MaxBlockSize)
+	}
+	old := s.Limits()
+	s.maxPayloadSize.Store(limits.MaxPayloadSize)
+	s.maxBlockSize.Store(limits.MaxBlockSize)
+	if old != limits {
+		s.log.Infof("Max size of submissions changed from %d to %d bytes, of blocks from %d to %d bytes", old.MaxPayloadSize, limits.MaxPayloadSize, old.MaxBlockSize, limits.MaxBlockSize)
+	}
+	return nil
+}
+
+// RecordPayload records the size of the body of a submission, including
+// submissions rejected for their size
+func (s *SubmitSizes) RecordPayload(size int64) {
+	s.payloads.Observe(size)
+}
+
+// RecordBlock records the size of a decoded block
+func (s *SubmitSizes) RecordBlock(size int) {
+	s.blocks.Observe(int64(size))
+}
+
+func (s *SubmitSizes) WritePrometheus(w io.Writer) {
+	limits := s.Limits()
+	fmt.Fprintf(w, "# HELP uptime_submit_max_payload_bytes Max size of the body of a submission.\n# TYPE uptime_submit_max_payload_bytes gauge\nuptime_submit_max_payload_bytes %d\n", limits.MaxPayloadSize)
+	fmt.Fprintf(w, "# HELP uptime_submit_max_block_bytes Max size of a decoded block.\n# TYPE uptime_submit_max_block_bytes gauge\nuptime_submit_max_block_bytes %d\n", limits.MaxBlockSize)
+	s.payloads.writePrometheus(w, "uptime_submit_payload_bytes", "Sizes of bodies of submissions.")
+	s.blocks.writePrometheus(w, "uptime_submit_block_bytes", "Sizes of decoded blocks of submissions.")
+}
+
+// SubmitSizeStatus is the response of the submit size admin endpoint
+type SubmitSizeStatus struct {
+	SubmitSizeLimits
+	Payloads []SizeBucket `json:"payloads"`
+	Blocks   []SizeBucket `json:"blocks"`
+}
+
+func (s *SubmitSizes) Status() SubmitSizeStatus {
+	return SubmitSizeStatus{SubmitSizeLimits: s.Limits(), Payloads: s.payloads.status(), Blocks: s.blocks.status()}
+}
+
+// AdminHandler serves the limits of the size of submissions:
+//
+//	GET /admin/submit-size  returns the limits and the distributions of sizes
+//	PUT /admin/submit-size  sets the limits, body `{"max_payload_size": 60000000}`,
+//	                        limits left out are kept
+//
+// Limits set are replaced on reload of the configuration.
+func (s *SubmitSizes) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(rw, http.StatusOK, s.Status())
+		case http.MethodPut:
+			var req SubmitSizeConfig
+			if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 1<<16)).Decode(&req); err != nil {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{"Expected {\"max_payload_size\": ..., \"max_block_size\": ...}"})
+				return
+			}
+			limits := s.Limits()
+			if req.MaxPayloadSize != 0 {
+				limits.MaxPayloadSize = req.MaxPayloadSize
+			}
+			if req.MaxBlockSize != 0 {
+				limits.MaxBlockSize = req.MaxBlockSize
+			}
+			if err := s.SetLimits(limits); err != nil {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{err.Error()})
+				return
+			}
+			writeJSON(rw, http.StatusOK, s.Status())
+		default:
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestSubmitSizes(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	_, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	sizes := NewSubmitSizes(SubmitSizeLimits{MaxPayloadSize: int64(len(body) - 1), MaxBlockSize: MAX_SUBMIT_PAYLOAD_SIZE}, logging.Logger("delegation backend test"))
+	sh.app.SubmitSizes = sizes
+	if rep := sh.testRequest(body); rep.Code != 413 {
+		t.Fatalf("expected the payload rejected, got %v", rep)
+	}
+
+	// Raised at runtime
+	admin := func(method string, reqBody string) *httptest.ResponseRecorder {
+		rep := httptest.NewRecorder()
+		sizes.AdminHandler().ServeHTTP(rep, httptest.NewRequest(method, "/admin/submit-size", strings.NewReader(reqBody)))
+		return rep
+	}
+	if rep := admin(http.MethodPut, `{"max_payload_size": -1}`); rep.Code != 400 {
+		t.Fatalf("expected a negative size rejected, got %d", rep.Code)
+	}
+	if rep := admin(http.MethodPut, `{"max_payload_size": 60000000}`); rep.Code != 200 {
+		t.Fatalf("unexpected failure: %d %s", rep.Code, rep.Body.String())
+	}
+	if limits := sizes.Limits(); limits.MaxPayloadSize != 60000000 || limits.MaxBlockSize != MAX_SUBMIT_PAYLOAD_SIZE {
+		t.Fatalf("expected the payload limit raised only, got %+v", limits)
+	}
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("unexpected failure: %v", rep)
+	}
+
+	var status SubmitSizeStatus
+	json.Unmarshal(admin(http.MethodGet, "").Body.Bytes(), &status)
+	if last := status.Payloads[len(status.Payloads)-1]; last.Le != "+Inf" || last.Count != 2 {
+		t.Fatalf("expected both payloads recorded, got %+v", status.Payloads)
+	}
+	var metrics bytes.Buffer
+	sizes.WritePrometheus(&metrics)
+	block := len(req.Data.Block.data)
+	for _, expected := range []string{
+		"uptime_submit_max_payload_bytes 60000000\n",
+		"uptime_submit_payload_bytes_count 2\n",
+		"uptime_submit_block_bytes_bucket{le=\"+Inf\"} 1\n",
+		"uptime_submit_block_bytes_sum " + strconv.Itoa(block) + "\n",
+	} {
+		if !strings.Contains(metrics.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, metrics.String())
+		}
+	}
+}
+
+func TestSubmitSizeConfig(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	mockLogger := &MockLogger{}
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"network_name": "devnet", "delegation_whitelist_disabled": true, "filesystem": {"path": "/tmp"},
+		"submit_size": {"mainnet": {"max_payload_size": 1000}, "devnet": {"max_payload_size": 2000}}}`), 0644)
+
+	os.Setenv("MAX_SUBMIT_BLOCK_SIZE", "1500")
+	config := LoadConfig(path, mockLogger)
+	if mockLogger.lastMessage != "" {
+		t.Fatalf("Unexpected fatal error: %s", mockLogger.lastMessage)
+	}
+	if limits := config.SubmitSize.Limits("devnet"); limits != (SubmitSizeLimits{MaxPayloadSize: 2000, MaxBlockSize: 1500}) {
+		t.Fatalf("Unexpected limits of devnet %+v", limits)
+	}
+	if limits := config.SubmitSize.Limits("mainnet"); limits != (SubmitSizeLimits{MaxPayloadSize: 1000, MaxBlockSize: 1000}) {
+		t.Fatalf("Unexpected limits of mainnet %+v", limits)
+	}
+	if limits := config.SubmitSize.Limits("testnet"); limits.MaxPayloadSize != MAX_SUBMIT_PAYLOAD_SIZE {
+		t.Fatalf("Unexpected default limits %+v", limits)
+	}
+
+	os.Setenv("MAX_SUBMIT_PAYLOAD_SIZE", "-5")
+	LoadConfig(path, mockLogger)
+	if !strings.Contains(mockLogger.lastMessage, "devnet: expected positive sizes") {
+		t.Errorf("Expected negative sizes rejected, got: %s", mockLogger.lastMessage)
+	}
+}
@@ -0,0 +1,696 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Respond with 429 if any of the keys is locked out
+// after repeated invalid signatures
+func (app *App) lockedOut(keys ...string) *Rejection {
+	lockedFor, locked := app.SignatureLockout.LockedFor(keys...)
+	if !locked {
+		return nil
+	}
+	incMetric("signature_lockout_rejections")
+	app.Log.Debugf("Rejecting locked out request (%v) for %v", keys, lockedFor)
+	return (&Rejection{Status: 429, Message: "Too many invalid signatures, locked out temporarily", RetryAfter: int(math.Ceil(lockedFor.Seconds()))}).
+		withHint("Locked out for %s, check the exporter signs with the key of the submitter before retrying, invalid signatures extend the lockout", hintDuration(lockedFor))
+}
+
+// decodeStage admits the submission and decodes its body, once validated
+// against the schema of submissions. Checks not requiring the body run
+// first, so that rejected bodies aren't read.
+type decodeStage struct{ app *App }
+
+func (*decodeStage) Name() string { return SUBMIT_STAGE_DECODE }
+
+func (st *decodeStage) Process(s *submission) *Rejection {
+	app := st.app
+	if app.ClientCertAuth != nil && s.canary == nil && len(s.peerCertificates) == 0 {
+		app.Log.Warnf("Request without client certificate from %s", s.remoteAddr)
+		return reject(401, "Client certificate required").withHint("Configure the exporter with the client certificate issued for the block producer")
+	}
+	s.ipLockoutKey = LOCKOUT_KEY_IP + hostOf(s.remoteAddr)
+	if app.SignatureLockout != nil {
+		if rejection := app.lockedOut(s.ipLockoutKey); rejection != nil {
+			return rejection
+		}
+	}
+
+	limits := SubmitSizeLimits{MaxPayloadSize: MAX_SUBMIT_PAYLOAD_SIZE, MaxBlockSize: MAX_SUBMIT_PAYLOAD_SIZE}
+	if app.SubmitSizes != nil {
+		limits = app.SubmitSizes.Limits()
+		if s.contentLength >= 0 {
+			app.SubmitSizes.RecordPayload(s.contentLength)
+		}
+	}
+	if s.contentLength == -1 {
+		app.Log.Warnf("Request missing Content-Length header")
+		return (&Rejection{Status: 411, Message: http.StatusText(411)}).withHint("Send the body with a Content-Length header rather than with chunked transfer encoding")
+	} else if s.contentLength > limits.MaxPayloadSize {
+		incMetric("submit_payload_too_large")
+		app.Log.Warnf("Request payload too large: %d bytes (max: %d)", s.contentLength, limits.MaxPayloadSize)
+		return (&Rejection{Status: 413, Message: http.StatusText(413)}).
+			withHint("The body is %d bytes, above the max of %d bytes, upload submissions this large in chunks with POST /v1/submit/init", s.contentLength, limits.MaxPayloadSize)
+	}
+	// The decoded block and the sign payload hash are derived from the body in place
+	if app.BodyReadTimeout > 0 && s.setReadDeadline != nil {
+		// Within the deadline of the submission, if sooner
+		deadline := time.Now().Add(app.BodyReadTimeout)
+		if submitDeadline, ok := s.ctx.Deadline(); ok && submitDeadline.Before(deadline) {
+			deadline = submitDeadline
+		}
+		// Not supported by test recorders, the server-wide read timeout still applies then
+		_ = s.setReadDeadline(deadline)
+	}
+	// Buffers of the submit path are pooled, they are returned once the
+	// submission is saved, as storage backends write synchronously. The
+	// body is validated as it is read, oversized fields are rejected
+	// before the remainder is buffered.
+	body, err := readSubmitBody(s.body, int(s.contentLength), newSubmitScanner(int(limits.MaxBlockSize)))
+	s.onDone(func() { bodyBuffers.Put(body) })
+	var tooLarge *fieldTooLargeError
+	if errors.As(err, &tooLarge) {
+		app.Log.Warnf("Rejecting /submit request from %s: %v", s.remoteAddr, err)
+		incMetric("submit_field_too_large")
+		return (&Rejection{Status: 413, Message: "Field " + tooLarge.Field + " is too large", Close: true}).
+			withHint("Field %s exceeds its max size of %d bytes, check the exporter submits the field of the node", tooLarge.Field, tooLarge.Limit)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		app.Log.Warnf("Timed out reading /submit request's body from %s", s.remoteAddr)
+		return reject(408, "Timed out reading the body").
+			withHint("The body wasn't received within %s, check the upload bandwidth of the node and of proxies in between", hintDuration(app.BodyReadTimeout))
+	}
+	if errors.Is(err, errMalformedSubmission) {
+		app.Log.Debugf("Malformed /submit request's body from %s: %v", s.remoteAddr, err)
+		return &Rejection{Status: 400, Message: "Error decoding payload", Close: true}
+	}
+	if err != nil {
+		app.Log.Debugf("Error while reading /submit request's body: %v", err)
+		return reject(400, "Error reading the body")
+	}
+
+	value, err := DecodeJSONValue(body)
+	if err != nil {
+		app.Log.Debugf("Malformed /submit request's body from %s: %v", s.remoteAddr, err)
+		return reject(400, "Error decoding payload")
+	}
+	if schemaErrs := ValidateSubmission(value, s.schemaVersion); len(schemaErrs) > 0 {
+		incMetric("submit_invalid_schema")
+		app.Log.Warnf("Rejecting /submit request from %s not matching the schema: %v", s.remoteAddr, schemaErrs)
+		return &Rejection{Status: 400, Message: "Submission doesn't match the schema: " + schemaErrs[0].Error(), Code: SUBMIT_SCHEMA_ERROR_CODE, Errors: schemaErrs}
+	}
+
+	s.rawBody = body
+	s.onDone(s.req.release)
+	var keyErr *KeyEncodingError
+	if err := json.Unmarshal(body, &s.req); errors.As(err, &keyErr) {
+		return rejectKey(app, s, keyErr)
+	} else if err != nil {
+		app.Log.Errorf("Error while unmarshaling JSON of /submit request's body: %v, body preview: %s", err, string(body[:min(len(body), 200)]))
+		return reject(400, "Error decoding payload")
+	}
+
+	req := &s.req
+	if app.SubmitSizes != nil && req.Data.Block != nil {
+		app.SubmitSizes.RecordBlock(len(req.Data.Block.data))
+	}
+	// Keys are validated before the signature is verified, so that
+	// encoding problems aren't reported as invalid signatures
+	if req.Submitter != nilPk {
+		if err := app.SignatureScheme.ValidatePk(req.Submitter); errors.As(err, &keyErr) {
+			return rejectKey(app, s, keyErr)
+		}
+	}
+	if req.Sig != nilSig {
+		if err := app.SignatureScheme.ValidateSig(req.Sig); errors.As(err, &keyErr) {
+			return rejectKey(app, s, keyErr)
+		}
+	}
+	if s.schemaVersion < SUBMIT_SCHEMA_V2 {
+		// Fields of later versions are ignored, as unknown fields are
+		req.Telemetry = nil
+	}
+	app.Log.Infof("Successfully parsed submission from submitter: %s", req.Submitter.String())
+	s.span.SetAttributes(attribute.String("submission.submitter", req.Submitter.String()), attribute.Int("submission.size", len(body)))
+	if req.Submitter != nilPk {
+		s.audit.Submitter = req.Submitter.String()
+		setAccessLogSubmitter(s.ctx, s.audit.Submitter)
+	}
+	if req.Data.Block != nil {
+		s.audit.BlockSize = len(req.Data.Block.data)
+	}
+	return nil
+}
+
+// Reject a submission whose public key or signature is malformed with the
+// code of the problem, counted in `submit_invalid_<code>`
+func rejectKey(app *App, s *submission, keyErr *KeyEncodingError) *Rejection {
+	incMetric("submit_invalid_" + keyErr.Code())
+	app.Log.Warnf("Rejecting /submit request from %s: %v", s.remoteAddr, keyErr)
+	field, hint := "submitter", "Set submitter to the base58check-encoded public key of the block producer, starting with B62q"
+	if keyErr.Kind == KEY_KIND_SIG {
+		field, hint = "signature", "Set signature to the base58check-encoded signature made by the Mina signer, as output by the node"
+	}
+	return (&Rejection{Status: 400, Message: fmt.Sprintf("Field %s is invalid: %s", field, keyErr.Detail), Code: keyErr.Code()}).withHint(hint)
+}
+
+// validateStage checks the required fields of the submission are set,
+// its sign payload is of an accepted version,
+// the block matches its hash and created_at its global slot if provided,
+// the state hash is a state hash, the telemetry is plausible, and the
+// block is a block of the network if block validation is enabled
+type validateStage struct{ app *App }
+
+func (*validateStage) Name() string { return SUBMIT_STAGE_VALIDATE }
+
+func (st *validateStage) Process(s *submission) *Rejection {
+	app := st.app
+	req := &s.req
+	if !req.CheckRequiredFields() {
+		app.Log.Warnf("Required fields validation failed for submitter: %s", req.Submitter.String())
+		return reject(400, "One of required fields wasn't provided").
+			withHint("Set submitter, signature, data.block, data.created_at and data.peer_id")
+	}
+	version := signPayloadVersion(req.PayloadVersion)
+	if !app.acceptsSignPayloadVersion(version) {
+		incMetric("submit_unaccepted_payload_version")
+		app.Log.Warnf("Rejecting payload version %d of submitter %s", version, req.Submitter.String())
+		versions := app.signPayloadVersions()
+		return reject(400, fmt.Sprintf("Field payload_version %d isn't accepted, expected one of %v", version, versions)).
+			withHint("Upgrade the exporter to sign a payload of version %d", versions[len(versions)-1])
+	}
+	incMetric(fmt.Sprintf("submit_payload_version_%d", version))
+	if req.BlockHash != "" && req.BlockHash != s.blockHash() {
+		incMetric("submit_block_hash_mismatch")
+		app.Log.Warnf("Block hash %s of submitter %s doesn't match the hash of its block %s", req.BlockHash, req.Submitter.String(), s.blockHash())
+		return reject(422, "Field block_hash doesn't match the hash of the block").
+			withHint("The hash of the block received is %s, check block_hash is computed from the decoded block submitted", s.blockHash())
+	}
+	if req.StateHash != "" {
+		if _, err := decodeStateHash(req.StateHash); err != nil {
+			incMetric("submit_invalid_state_hash")
+			app.Log.Warnf("Field state_hash %q of submitter %s isn't a state hash: %v", req.StateHash, req.Submitter.String(), err)
+			return reject(400, "Field state_hash isn't a state hash").
+				withHint("Set state_hash to the base58check-encoded state hash of the block, starting with 3N")
+		}
+	}
+	if req.Telemetry != nil {
+		if err := validateTelemetry(req.Telemetry); err != nil {
+			incMetric("submit_invalid_telemetry")
+			app.Log.Warnf("Rejecting telemetry of submitter %s: %v", req.Submitter.String(), err)
+			return reject(400, "Invalid telemetry: "+err.Error())
+		}
+	}
+	if app.NetworkTiming != nil {
+		if rejection := st.checkSlot(s); rejection != nil {
+			return rejection
+		}
+	}
+	if app.BlockValidator != nil {
+		if invalid := app.BlockValidator.Check(req.Data.Block.data); invalid != nil {
+			incMetric("submit_invalid_block_" + invalid.Reason)
+			if app.BlockValidator.ReportOnly {
+				app.Log.Warnf("Block of submitter %s failed validation, accepted as report only: %v", req.Submitter.String(), invalid)
+			} else {
+				app.Log.Warnf("Rejecting block of submitter %s: %v", req.Submitter.String(), invalid)
+				return reject(400, "Block isn't a valid block of the network").
+					withHint("Check the node runs on the network of the backend and the exporter submits the block as serialized by the node")
+			}
+		}
+	}
+	return nil
+}
+
+// Set the global slot and the epoch of the submission, of created_at
+// unless provided by the client
+func (st *validateStage) checkSlot(s *submission) *Rejection {
+	app := st.app
+	req := &s.req
+	slot, ok := app.NetworkTiming.Slot(req.Data.CreatedAt)
+	if req.GlobalSlot != nil {
+		if !app.NetworkTiming.Plausible(*req.GlobalSlot, req.Data.CreatedAt) {
+			incMetric("submit_implausible_slot")
+			app.Log.Warnf("Field created_at %v of submitter %s isn't plausible for global slot %d", req.Data.CreatedAt, req.Submitter.String(), *req.GlobalSlot)
+			return reject(400, "Field created_at isn't plausible for global_slot").
+				withHint("The slot of created_at is %d, check the clock of the node is synchronized with NTP", slot)
+		}
+		slot, ok = *req.GlobalSlot, true
+	}
+	if !ok {
+		app.Log.Warnf("Field created_at %v of submitter %s is before the genesis", req.Data.CreatedAt, req.Submitter.String())
+		return reject(400, "Field created_at is before the genesis of the network").
+			withHint("Check the clock of the node is synchronized with NTP and the node runs on the network of the backend")
+	}
+	epoch := app.NetworkTiming.Epoch(slot)
+	req.slot, req.epoch = &slot, &epoch
+	return nil
+}
+
+// authorizeStage checks the submitter is whitelisted, and the submission
+// is made within the window around its creation time
+type authorizeStage struct{ app *App }
+
+func (*authorizeStage) Name() string { return SUBMIT_STAGE_AUTHORIZE }
+
+func (st *authorizeStage) Process(s *submission) *Rejection {
+	app := st.app
+	submitter := s.req.Submitter
+	if s.canary != nil {
+		app.Log.Debugf("Canary submission, accepting submitter: %s", submitter.String())
+	} else if !app.WhitelistDisabled {
+		wl := app.Whitelist.ReadWhitelist()
+		if (*wl)[submitter] == nil {
+			app.Log.Warnf("Submitter not in whitelist: %s", submitter.String())
+			return reject(401, fmt.Sprintf("Submitter is not registered: %s", submitter)).withHint(st.unregisteredHint())
+		}
+		app.Log.Debugf("Submitter %s found in whitelist", submitter.String())
+		s.audit.authorized = true
+	} else {
+		app.Log.Debugf("Whitelist disabled, accepting submitter: %s", submitter.String())
+	}
+
+	createdAt := s.req.Data.CreatedAt
+	s.submittedAt = app.Now()
+	if app.ClockGuard != nil {
+		if suspect, left := app.ClockGuard.Suspect(); suspect {
+			if app.ClockGuard.Action == CLOCK_GUARD_REJECT {
+				incMetric("submit_clock_rejected")
+				return (&Rejection{Status: 503, Message: "Clock of the backend is unreliable, retry later", Code: CLOCK_UNRELIABLE_CODE, RetryAfter: int(left.Seconds()) + 1}).
+					withHint("The clock of the backend jumped, submissions are accepted again once it is trusted, within %s", hintDuration(left))
+			}
+			// created_at can't be checked against the clock, the submission
+			// is accepted and flagged in its metadata instead
+			incMetric("submit_clock_flagged")
+			app.Log.Debugf("Clock is suspect, accepting created_at %v unchecked, submitted at: %v", createdAt, s.submittedAt)
+			s.req.clockSuspect = true
+			return nil
+		}
+	}
+	if s.canary == nil && createdAt.Add(-app.CreatedAtMaxFuture).After(s.submittedAt) {
+		incMetric("submit_created_at_in_future")
+		app.Log.Debugf("Field created_at is a timestamp in future: %v, submitted at: %v", createdAt, s.submittedAt)
+		return reject(400, "Field created_at is a timestamp in future").
+			withHint("created_at is %s in the future, check the clock of the node is synchronized with NTP", hintDuration(createdAt.Sub(s.submittedAt)))
+	}
+	if s.canary == nil && app.CreatedAtMaxAge > 0 && createdAt.Before(s.submittedAt.Add(-app.CreatedAtMaxAge)) {
+		app.Log.Debugf("Field created_at is too old: %v, submitted at: %v", createdAt, s.submittedAt)
+		return reject(400, fmt.Sprintf("Field created_at is older than the maximum allowed age of %v", app.CreatedAtMaxAge)).
+			withHint("created_at is %s in the past, check the clock of the node is synchronized with NTP and the exporter doesn't hold submissions back", hintDuration(s.submittedAt.Sub(createdAt)))
+	}
+	return nil
+}
+
+// Hint of a submitter missing from the whitelist, as of its last refresh
+func (st *authorizeStage) unregisteredHint() string {
+	refreshedAt := st.app.Whitelist.ReplacedAt()
+	if refreshedAt.IsZero() {
+		return "Register the key of the block producer for the uptime service"
+	}
+	return fmt.Sprintf("Key not found in the whitelist as of its refresh at %s, register the key of the block producer, registrations are picked up by the next refresh", refreshedAt.UTC().Format("2006-01-02T15:04Z"))
+}
+
+// authenticateStage checks the submission is made by the submitter: its
+// client certificate, delegation token and signature
+type authenticateStage struct{ app *App }
+
+func (*authenticateStage) Name() string { return SUBMIT_STAGE_AUTHENTICATE }
+
+func (st *authenticateStage) Process(s *submission) *Rejection {
+	app := st.app
+	req := &s.req
+	if app.ClientCertAuth != nil && s.canary == nil && !app.ClientCertAuth.Authorized(s.peerCertificates[0], req.Submitter) {
+		app.Log.Warnf("Client certificate %s is not mapped to submitter %s", ClientCertFingerprint(s.peerCertificates[0]), req.Submitter.String())
+		return reject(401, "Client certificate does not match submitter").
+			withHint("Configure the exporter with the client certificate issued for %s", req.Submitter)
+	}
+
+	s.submitterLockoutKey = LOCKOUT_KEY_SUBMITTER + req.Submitter.String()
+	if app.SignatureLockout != nil {
+		if rejection := app.lockedOut(s.submitterLockoutKey, s.ipLockoutKey); rejection != nil {
+			return rejection
+		}
+	}
+
+	s.signer = req.Submitter
+	if req.Delegation != "" {
+		if rejection := st.checkDelegation(s); rejection != nil {
+			return rejection
+		}
+		s.signer = req.delegation.Delegate
+		s.audit.Delegate = req.delegation.Claims.Subject
+	}
+
+	if !app.VerifySignatureDisabled {
+		hash, err := req.signPayloadHash()
+		if err != nil {
+			app.Log.Errorf("Error while making sign payload: %v", err)
+			return reject(500, "Unexpected server error")
+		}
+
+		valid, err := app.verifySignature(s.ctx, &s.signer, &req.Sig, hash)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return app.deadlineExceeded(s, "verifying the signature")
+		}
+		if err != nil {
+			app.Log.Warnf("Rejecting submission from %s: %v", req.Submitter.String(), err)
+			return &Rejection{Status: 503, Message: "Server is overloaded, retry later", RetryAfter: 1}
+		}
+		if app.SignatureLockout != nil {
+			if valid {
+				app.SignatureLockout.RecordSuccess(s.submitterLockoutKey)
+			} else {
+				app.SignatureLockout.RecordFailure(s.submitterLockoutKey, s.ipLockoutKey)
+			}
+		}
+		if !valid {
+			st.quarantine(s, REJECTED_INVALID_SIGNATURE)
+			return reject(401, "Invalid signature").withHint(st.invalidSignatureHint(s))
+		}
+	}
+	if app.LoadShedder != nil && s.canary == nil {
+		// Clients of verified submissions are admitted under load
+		app.LoadShedder.Trust(s.remoteAddr)
+	}
+	s.audit.authorized = true
+	return nil
+}
+
+// Hint of a submission failing signature verification
+func (st *authenticateStage) invalidSignatureHint(s *submission) string {
+	version := signPayloadVersion(s.req.PayloadVersion)
+	if s.req.Delegation != "" {
+		return fmt.Sprintf("Check the submission is signed with the key of the delegate %s over the sign payload of version %d, for the network of the backend", s.signer, version)
+	}
+	return fmt.Sprintf("Check the submission is signed with the key of %s over the sign payload of version %d, for the network of the backend", s.req.Submitter, version)
+}
+
+// Quarantine the submission failing signature verification, if enabled,
+// canaries excepted
+func (st *authenticateStage) quarantine(s *submission, reason string) {
+	if st.app.SignatureQuarantine == nil || s.canary != nil {
+		return
+	}
+	st.app.SignatureQuarantine.Add(reason, s.submittedAt, s.remoteAddr, st.app.SignatureScheme, s.req.Submitter, s.rawBody)
+}
+
+// Check the delegation token of the request, it has to be issued
+// by the submitter and valid at the time of submission.
+func (st *authenticateStage) checkDelegation(s *submission) *Rejection {
+	app := st.app
+	req := &s.req
+	if app.DelegationMaxTTL <= 0 {
+		return reject(400, "Delegated submissions are not accepted").
+			withHint("Sign submissions with the key of the submitter, without a delegation token")
+	}
+	d, err := ParseDelegation(req.Delegation)
+	if err != nil {
+		app.Log.Debugf("Invalid delegation token from %s: %v", req.Submitter.String(), err)
+		return reject(400, "Invalid delegation token")
+	}
+	if d.Delegator != req.Submitter {
+		app.Log.Warnf("Delegation issued by %s used for submitter %s", d.Claims.Issuer, req.Submitter.String())
+		return reject(401, "Delegation is not issued by the submitter").
+			withHint("The delegation token is issued by %s, set submitter to the key issuing it", d.Delegator)
+	}
+	if err := d.Validate(s.submittedAt, app.DelegationMaxTTL); err != nil {
+		app.Log.Debugf("Rejecting delegation of %s to %s: %v", d.Claims.Issuer, d.Claims.Subject, err)
+		return reject(401, "Delegation is not valid: "+err.Error()).
+			withHint("Issue a new delegation token valid for at most %s", hintDuration(app.DelegationMaxTTL))
+	}
+	if !app.VerifySignatureDisabled {
+		valid, err := app.verifySignature(s.ctx, &d.Delegator, &d.Sig, d.SigningHash())
+		if errors.Is(err, context.DeadlineExceeded) {
+			return app.deadlineExceeded(s, "verifying the delegation")
+		}
+		if err != nil {
+			app.Log.Warnf("Rejecting submission from %s: %v", req.Submitter.String(), err)
+			return &Rejection{Status: 503, Message: "Server is overloaded, retry later", RetryAfter: 1}
+		}
+		if !valid {
+			if app.SignatureLockout != nil {
+				app.SignatureLockout.RecordFailure(s.submitterLockoutKey, s.ipLockoutKey)
+			}
+			st.quarantine(s, REJECTED_INVALID_DELEGATION_SIGNATURE)
+			return reject(401, "Invalid delegation signature")
+		}
+	}
+	req.delegation = d
+	return nil
+}
+
+// idempotencyStage looks the idempotency key of the submission up, once
+// the submitter is authenticated. Retries of an accepted submission skip
+// the remaining stages but responding.
+type idempotencyStage struct{ app *App }
+
+func (*idempotencyStage) Name() string { return SUBMIT_STAGE_IDEMPOTENCY }
+
+func (st *idempotencyStage) Process(s *submission) *Rejection {
+	app := st.app
+	key := s.idempotencyKey
+	if app.Idempotency == nil || key == "" || s.canary != nil {
+		return nil
+	}
+	if !ValidIdempotencyKey(key) {
+		return &Rejection{Status: 400, Message: fmt.Sprintf("Header %s is invalid: expected printable ASCII of at most %d characters", IDEMPOTENCY_KEY_HEADER, IDEMPOTENCY_KEY_MAX_LENGTH), Code: IDEMPOTENCY_KEY_INVALID_CODE}
+	}
+	submitter := s.req.Submitter
+	state, response := app.Idempotency.Begin(submitter, key, s.req.Sig)
+	switch state {
+	case IDEMPOTENCY_IN_PROGRESS:
+		incMetric("submit_idempotency_in_progress")
+		return &Rejection{Status: 409, Message: "A submission with the idempotency key is in progress, retry later", Code: IDEMPOTENCY_KEY_IN_PROGRESS_CODE, RetryAfter: 1}
+	case IDEMPOTENCY_REUSED:
+		incMetric("submit_idempotency_key_reused")
+		return (&Rejection{Status: 422, Message: "The idempotency key was used by another submission", Code: IDEMPOTENCY_KEY_REUSED_CODE}).
+			withHint("Use a new idempotency key for every submission, and the key of the submission for its retries")
+	case IDEMPOTENCY_COMPLETED:
+		incMetric("submit_idempotency_replayed")
+		app.Log.Infof("Repeating the response to submission %s of %s", key, submitter.String())
+		s.replayed = response
+		return nil
+	}
+	// Released unless accepted, so that the submission can be retried
+	s.onDone(func() { app.Idempotency.Release(submitter, key) })
+	return nil
+}
+
+// rateLimitStage applies the hourly limit of the submitter, replay
+// protection, submission windows and anomaly detection
+type rateLimitStage struct{ app *App }
+
+func (*rateLimitStage) Name() string { return SUBMIT_STAGE_RATE_LIMIT }
+
+func (st *rateLimitStage) Process(s *submission) *Rejection {
+	if s.replayed != nil {
+		return nil
+	}
+	app := st.app
+	req := &s.req
+	if s.canary == nil && !app.SubmitCounter.RecordAttempt(req.Submitter) {
+		return reject(429, "Too many requests per hour").
+			withHint("At most %d submissions of the submitter are accepted per hour, submit less often", app.SubmitCounter.Limit(req.Submitter))
+	}
+
+	if app.ReplayGuard != nil && s.canary == nil && !app.ReplayGuard.Check(req.Submitter, req.Data.CreatedAt) {
+		app.Log.Warnf("Replayed or out-of-order submission from %s, created_at: %v", req.Submitter.String(), req.Data.CreatedAt)
+		return reject(409, "Field created_at is not newer than of the last accepted submission").
+			withHint("Check the clock of the node didn't go back, and set the Idempotency-Key header to retry a submission")
+	}
+
+	if windows := app.SubmissionWindows; windows != nil && s.canary == nil && req.slot != nil {
+		window := windows.Window(*req.slot)
+		req.window = &window
+		if !windows.Credit(req.Submitter, window) {
+			incMetric("submit_window_already_credited")
+			if windows.Mode == SUBMISSION_WINDOW_REJECT {
+				return reject(409, fmt.Sprintf("Submission window %d is already credited to the submitter", window)).
+					withHint("Submit once per window, the next one starts at %s", windows.start(window+1).UTC().Format(time.RFC3339))
+			}
+			s.duplicate = true
+		}
+	}
+
+	if app.AnomalyMonitor != nil {
+		obs := Observation{Submitter: req.Submitter, RemoteAddr: s.remoteAddr, BlockHash: s.blockHash(), At: s.submittedAt}
+		switch app.AnomalyMonitor.Check(obs) {
+		case ANOMALY_ACTION_THROTTLE:
+			return reject(429, "Submission throttled")
+		case ANOMALY_ACTION_BLOCK:
+			return reject(403, "Submission blocked")
+		}
+	}
+	return nil
+}
+
+// persistStage saves the metadata and the block of the submission to the
+// storage backends and records their outcomes
+type persistStage struct{ app *App }
+
+func (*persistStage) Name() string { return SUBMIT_STAGE_PERSIST }
+
+func (st *persistStage) Process(s *submission) *Rejection {
+	if s.duplicate || s.replayed != nil {
+		return nil
+	}
+	app := st.app
+	if errors.Is(s.ctx.Err(), context.DeadlineExceeded) {
+		return app.deadlineExceeded(s, "waiting to be saved")
+	}
+	req := &s.req
+	blockHash := s.blockHash()
+	s.paths = makePaths(s.submittedAt, blockHash, req.Submitter)
+	ps := s.paths
+	s.span.SetAttributes(attribute.String("submission.block_hash", blockHash))
+	s.audit.BlockHash = blockHash
+
+	if app.LivenessChecker != nil && s.canary == nil {
+		livenessCtx, livenessSpan := tracer.Start(s.ctx, "liveness")
+		req.liveness = app.LivenessChecker.Check(livenessCtx, s.remoteAddr, req.Data.GraphqlControlPort)
+		livenessSpan.End()
+	}
+
+	// Returned to the pool once the request is handled, objects saved
+	// alias it and are only valid until then, see ObjectsToSave
+	metaBuf := metaBuffers.Get().(*bytes.Buffer)
+	metaBuf.Reset()
+	s.onDone(func() { metaBuffers.Put(metaBuf) })
+	metaBytes, err := req.writeMetaToBeSaved(metaBuf, s.remoteAddr, blockHash)
+	if err != nil {
+		app.Log.Errorf("Error while marshaling JSON for metaToBeSaved: %v", err)
+		return reject(500, "Unexpected server error")
+	}
+
+	toSave := make(ObjectsToSave)
+	toSave[ps.Meta] = metaBytes
+	// Shared with the hash computed above, not copied
+	toSave[ps.Block] = req.Data.Block.data
+
+	if app.ReceiptIndex != nil && s.canary == nil {
+		receiptId, err := NewULID(s.submittedAt)
+		if err != nil {
+			app.Log.Errorf("Error while making receipt id: %v", err)
+			return reject(500, "Unexpected server error")
+		}
+		s.receiptId = receiptId
+	}
+
+	app.Log.Infof("Saving submission for submitter %s: block_hash=%s meta_path=%s block_path=%s receipt_id=%s", req.Submitter.String(), blockHash, ps.Meta, ps.Block, s.receiptId)
+	saveCtx, saveSpan := tracer.Start(s.ctx, "save")
+	var writeOutcome *WriteOutcome
+	if app.WriteOutcomes != nil && s.canary == nil {
+		writeOutcome = &WriteOutcome{
+			SubmissionId: ps.Meta,
+			Submitter:    req.Submitter.String(),
+			BlockHash:    blockHash,
+			SubmittedAt:  s.submittedAt,
+			Backends:     make(map[string]BackendWrite),
+		}
+		saveCtx = withWriteOutcome(saveCtx, writeOutcome)
+	}
+	outcomes := app.Save(saveCtx, toSave)
+	saveSpan.End()
+	s.audit.setStorageOutcomes(outcomes)
+	if writeOutcome != nil {
+		writeOutcome.setStorageOutcomes(outcomes)
+		if err := app.WriteOutcomes.Insert(writeOutcome); err != nil {
+			incMetric("write_outcome_errors")
+			app.Log.Errorf("Error recording storage outcomes of %s: %v", ps.Meta, err)
+		}
+	}
+	if app.StorageFailureMonitor != nil {
+		for backend, err := range outcomes {
+			if err != nil {
+				app.StorageFailureMonitor.Record(backend, err, map[string]string{"submitter": s.audit.Submitter, "path": ps.Meta})
+			}
+		}
+	}
+	// Submissions saved to every backend are accepted even if late
+	if !savedToAll(outcomes) && errors.Is(s.ctx.Err(), context.DeadlineExceeded) {
+		return app.deadlineExceeded(s, "saving")
+	}
+	if s.receiptId != "" {
+		entry := ReceiptEntry{ReceiptId: s.receiptId, SubmissionId: ps.Meta, BlockPath: ps.Block, Submitter: req.Submitter.String(), BlockHash: blockHash, SubmittedAt: s.submittedAt}
+		if err := app.ReceiptIndex.Insert(entry); err != nil {
+			incMetric("receipt_index_errors")
+			app.Log.Errorf("Error indexing receipt %s of %s: %v", s.receiptId, ps.Meta, err)
+		}
+	}
+	if s.canary != nil {
+		s.canary.outcomes = outcomes
+	} else {
+		if app.SubmissionWatch != nil {
+			app.SubmissionWatch.Accepted(s.submittedAt)
+		}
+		if app.SilenceWatch != nil {
+			app.SilenceWatch.Accepted(req.Submitter.String(), s.submittedAt)
+		}
+		if app.SubmissionEvents != nil && savedToAll(outcomes) {
+			app.SubmissionEvents.Accepted(req.Submitter, blockHash, req.Data.CreatedAt, s.submittedAt, ps)
+		}
+		if app.Webhooks != nil && savedToAll(outcomes) {
+			// Submissions are counted once responded to, after this stage
+			firstSeen := app.SubmitterStats != nil && !app.SubmitterStats.HasAccepted(req.Submitter.String())
+			app.Webhooks.SubmissionAccepted(req.Submitter, blockHash, req.Data.CreatedAt, s.submittedAt, ps.Meta, firstSeen)
+		}
+	}
+	return nil
+}
+
+// Rejection of a submission whose deadline is exceeded, e.g. as a storage
+// backend is slow, so that the handler isn't held beyond SUBMIT_TIMEOUT
+func (app *App) deadlineExceeded(s *submission, during string) *Rejection {
+	incMetric("submit_deadline_exceeded")
+	app.Log.Warnf("Deadline of the submission from %s exceeded while %s", s.remoteAddr, during)
+	return (&Rejection{Status: 504, Message: "Timed out handling the submission, retry later", Code: SUBMIT_DEADLINE_EXCEEDED_CODE}).
+		withHint("Retry with the Idempotency-Key header of the submission, so that a submission saved isn't rejected as a replay")
+}
+
+// Whether the submission was saved to every backend, events aren't
+// published for submissions to be repaired
+func savedToAll(outcomes StorageOutcomes) bool {
+	for _, err := range outcomes {
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// respondStage makes the response to the accepted submission
+type respondStage struct{ app *App }
+
+func (*respondStage) Name() string { return SUBMIT_STAGE_RESPOND }
+
+func (st *respondStage) Process(s *submission) *Rejection {
+	if s.replayed != nil {
+		s.response = *s.replayed
+		return nil
+	}
+	// Committed once saved rather than when checked, so that the retry of
+	// a submission which failed to be saved isn't taken for a replay
+	if st.app.ReplayGuard != nil && s.canary == nil {
+		st.app.ReplayGuard.Commit(s.req.Submitter, s.req.Data.CreatedAt)
+	}
+	s.response = submitResponse{Status: "ok", WindowId: s.req.window, ReceiptId: s.receiptId}
+	if s.duplicate {
+		s.response.Status = "duplicate"
+	} else if st.app.ReceiptSigner != nil {
+		s.response.Receipt = st.app.ReceiptSigner.Issue(s.paths.Meta, s.req.Submitter, s.blockHash(), s.submittedAt)
+	}
+	if st.app.Idempotency != nil && s.idempotencyKey != "" && s.canary == nil {
+		st.app.Idempotency.Complete(s.req.Submitter, s.idempotencyKey, s.response)
+	}
+	return nil
+}
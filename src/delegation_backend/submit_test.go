@@ -1,12 +1,15 @@
 package delegation_backend
 
 import (
+	"block_producers_uptime/metadata"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"math/rand"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -51,10 +54,11 @@ func testSubmitH(maxAttempt int, initWl Whitelist) (*ObjectsToSave, *SubmitH, *t
 	log := logging.Logger("delegation backend test")
 	app := new(App)
 	app.Log = log
-	app.Save = func(objs ObjectsToSave) {
+	app.Save = func(_ context.Context, objs ObjectsToSave) StorageOutcomes {
 		for path, value := range objs {
 			storage[path] = value
 		}
+		return nil
 	}
 	counter, tm := newTestAttemptCounter(1)
 	app.SubmitCounter = counter
@@ -62,7 +66,8 @@ func testSubmitH(maxAttempt int, initWl Whitelist) (*ObjectsToSave, *SubmitH, *t
 	wlMvar := new(WhitelistMVar)
 	wlMvar.Replace(&initWl)
 	app.Whitelist = wlMvar
-	app.NetworkId = 1
+	app.SignatureScheme = MinaSchnorr{NetworkId: 1}
+	app.CreatedAtMaxFuture = -TIME_DIFF_DELTA
 	return &storage, app.NewSubmitH(), tm
 }
 
@@ -75,7 +80,7 @@ func (sh *SubmitH) testRequest(body []byte) *httptest.ResponseRecorder {
 	return recorder
 }
 
-func readTestFile(n string, t *testing.T) []byte {
+func readTestFile(n string, t testing.TB) []byte {
 	body, err := os.ReadFile("../../test/data/" + n + ".json")
 	if err != nil {
 		t.Log("can not read test file")
@@ -192,12 +197,16 @@ func TestSuccess(t *testing.T) {
 		bhStr := req.GetBlockDataHash()
 		paths := makePaths(tm.Now(), bhStr, req.Submitter)
 		var meta MetaToBeSaved
+		meta.SchemaVersion = metadata.SCHEMA_VERSION
 		meta.CreatedAt = req.Data.CreatedAt.Format(time.RFC3339)
 		meta.PeerId = req.Data.PeerId
 		meta.SnarkWork = req.Data.SnarkWork
 		meta.RemoteAddr = "192.0.2.1:1234"
 		meta.BlockHash = bhStr
 		meta.Submitter = req.Submitter
+		meta.BackendVersion = GetBuildInfo().Version
+		meta.BackendCommit = GetBuildInfo().Commit
+		meta.Signature = &req.Sig
 		metaBytes, err2 := json.Marshal(meta)
 		if err2 != nil || !bytes.Equal((*objs)[paths.Meta], metaBytes) ||
 			!bytes.Equal((*objs)[paths.Block], req.Data.Block.data) {
@@ -240,10 +249,8 @@ func Test40x(t *testing.T) {
 		t.FailNow()
 	}
 	//4. Invalid signatures
-	var badSig Sig
-	rand.Read(badSig[:])
 	req2 = req
-	req2.Sig = badSig
+	req2.Sig = mkSig()
 	body2, err2 = json.Marshal(req2)
 	if rep := sh.testRequest(body2); err2 != nil || rep.Code != 401 {
 		t.Log("Bad signature check failed")
@@ -256,3 +263,114 @@ func Test40x(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestCreatedAtTooOld(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Log("failed decoding test file")
+		t.FailNow()
+	}
+	_, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.CreatedAtMaxAge = 10 * time.Minute
+	tm.time = req.Data.CreatedAt.Add(11 * time.Minute)
+	rep := sh.testRequest(body)
+	if rep.Code != 400 || !bytes.Contains(rep.Body.Bytes(), []byte("10m0s")) {
+		t.Logf("Failed to test created_at too old: %v", rep)
+		t.FailNow()
+	}
+	tm.time = req.Data.CreatedAt.Add(9 * time.Minute)
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Logf("Unexpected failure: %v", rep)
+		t.FailNow()
+	}
+}
+
+func TestRejectionHints(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	_, sh, tm := testSubmitH(1, Whitelist{})
+	hint := func(rep *httptest.ResponseRecorder) string {
+		var resp submitErrorResponse
+		json.Unmarshal(rep.Body.Bytes(), &resp)
+		return resp.Hint
+	}
+	if h := hint(sh.testRequest(body)); !strings.Contains(h, "Key not found in the whitelist as of its refresh at") {
+		t.Fatalf("unexpected hint of an unregistered submitter: %q", h)
+	}
+
+	sh.app.Whitelist.Replace(&Whitelist{req.Submitter: true})
+	tm.time = req.Data.CreatedAt.Add(-7 * time.Minute)
+	if h := hint(sh.testRequest(body)); !strings.HasPrefix(h, "created_at is 7m in the future") {
+		t.Fatalf("unexpected hint of created_at in the future: %q", h)
+	}
+	tm.time = req.Data.CreatedAt
+	sh.testRequest(body)
+	if h := hint(sh.testRequest(body)); !strings.HasPrefix(h, "At most 1 submissions of the submitter are accepted per hour") {
+		t.Fatalf("unexpected hint of the rate limit: %q", h)
+	}
+}
+
+func TestBlockHashMismatch(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	storage, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	tm.time = req.Data.CreatedAt
+	req.BlockHash = BlockDataHash(append([]byte{0}, req.Data.Block.data...))
+	body, _ = json.Marshal(req)
+	if rep := sh.testRequest(body); rep.Code != 422 || len(*storage) != 0 {
+		t.Fatalf("expected the block hash mismatch rejected with 422, got %d", rep.Code)
+	}
+	req.BlockHash = BlockDataHash(req.Data.Block.data)
+	body, _ = json.Marshal(req)
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected the matching block hash accepted, got %d: %s", rep.Code, rep.Body)
+	}
+}
+
+func BenchmarkSubmit(b *testing.B) {
+	body := readTestFile("req-with-snark", b)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		b.Fatal(err)
+	}
+	_, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.SubmitCounter = NewAttemptCounter(b.N + 1)
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rep := sh.testRequest(body); rep.Code != 200 {
+			b.Fatalf("unexpected failure: %v", rep)
+		}
+	}
+}
+
+func BenchmarkLocalFileSystemSave(b *testing.B) {
+	body := readTestFile("req-with-snark", b)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		b.Fatal(err)
+	}
+	meta, err := req.MakeMetaToBeSaved("192.0.2.1:1234")
+	if err != nil {
+		b.Fatal(err)
+	}
+	directory := b.TempDir()
+	log := logging.Logger("delegation backend test")
+	submittedAt := time.Now()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Distinct paths, saving over existing files is skipped
+		paths := makePaths(submittedAt.Add(time.Duration(i)*time.Second), req.GetBlockDataHash(), req.Submitter)
+		objs := ObjectsToSave{paths.Meta: meta, paths.Block: req.Data.Block.data}
+		if err := LocalFileSystemSave(objs, directory, log); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package delegation_backend
+
+import (
+	"block_producers_uptime/metadata"
+	"fmt"
+	"unicode"
+)
+
+// Versions of the schema of /submit requests, by endpoint
+const (
+	SUBMIT_SCHEMA_V1 = 1
+	// Adds the telemetry of the node
+	SUBMIT_SCHEMA_V2 = 2
+)
+
+// Max length of the daemon version reported in telemetry
+const TELEMETRY_DAEMON_VERSION_MAX_LENGTH = 128
+
+// Sync statuses of Mina daemons
+var telemetrySyncStatuses = map[string]bool{
+	"CONNECTING": true,
+	"LISTENING":  true,
+	"OFFLINE":    true,
+	"BOOTSTRAP":  true,
+	"SYNCED":     true,
+	"CATCHUP":    true,
+}
+
+// Check the telemetry reported by a node is plausible before it is saved
+func validateTelemetry(t *metadata.Telemetry) error {
+	if t.PeerCount != nil && *t.PeerCount < 0 {
+		return fmt.Errorf("peer_count is negative: %d", *t.PeerCount)
+	}
+	if t.SnarkPoolSize != nil && *t.SnarkPoolSize < 0 {
+		return fmt.Errorf("snark_pool_size is negative: %d", *t.SnarkPoolSize)
+	}
+	if t.SyncStatus != "" && !telemetrySyncStatuses[t.SyncStatus] {
+		return fmt.Errorf("unknown sync_status %q", t.SyncStatus)
+	}
+	if len(t.DaemonVersion) > TELEMETRY_DAEMON_VERSION_MAX_LENGTH {
+		return fmt.Errorf("daemon_version is longer than %d bytes", TELEMETRY_DAEMON_VERSION_MAX_LENGTH)
+	}
+	for _, r := range t.DaemonVersion {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("daemon_version has a non-printable character %q", r)
+		}
+	}
+	return nil
+}
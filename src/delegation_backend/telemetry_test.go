@@ -0,0 +1,65 @@
+package delegation_backend
+
+import (
+	"block_producers_uptime/metadata"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTelemetry(t *testing.T) {
+	peers, negative := 42, -1
+	valid := &metadata.Telemetry{PeerCount: &peers, SyncStatus: "SYNCED", SnarkPoolSize: &peers, DaemonVersion: "3.0.0-f872d85"}
+	if err := validateTelemetry(valid); err != nil {
+		t.Fatal(err)
+	}
+	for _, telemetry := range []*metadata.Telemetry{
+		{PeerCount: &negative},
+		{SnarkPoolSize: &negative},
+		{SyncStatus: "synced"},
+		{DaemonVersion: strings.Repeat("1", TELEMETRY_DAEMON_VERSION_MAX_LENGTH+1)},
+		{DaemonVersion: "3.0.0\n"},
+	} {
+		if err := validateTelemetry(telemetry); err == nil {
+			t.Errorf("expected %+v rejected", telemetry)
+		}
+	}
+}
+
+func TestSubmitTelemetry(t *testing.T) {
+	var req submitRequest
+	if err := json.Unmarshal(readTestFile("req-with-snark", t), &req); err != nil {
+		t.Fatal(err)
+	}
+	storage, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	tm.time = req.Data.CreatedAt
+	shV2 := sh.WithSchema(SUBMIT_SCHEMA_V2)
+
+	peers := -1
+	req.Telemetry = &metadata.Telemetry{PeerCount: &peers, SyncStatus: "SYNCED"}
+	body, _ := json.Marshal(req)
+	if rep := shV2.testRequest(body); rep.Code != 400 || len(*storage) != 0 {
+		t.Fatalf("expected invalid telemetry rejected, got %d", rep.Code)
+	}
+	peers = 12
+	body, _ = json.Marshal(req)
+	if rep := shV2.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected the submission accepted, got %d: %s", rep.Code, rep.Body)
+	}
+	telemetry := testSavedMeta(storage, t).Telemetry
+	if telemetry == nil || *telemetry.PeerCount != 12 || telemetry.SyncStatus != "SYNCED" {
+		t.Fatalf("expected the telemetry saved, got %+v", telemetry)
+	}
+
+	// Ignored by /v1/submit, even if invalid
+	peers = -1
+	body, _ = json.Marshal(req)
+	tm.time = tm.time.Add(2 * time.Hour)
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected the submission accepted, got %d: %s", rep.Code, rep.Body)
+	}
+	if telemetry := testSavedMeta(storage, t).Telemetry; telemetry != nil {
+		t.Fatalf("expected the telemetry of a v1 submission ignored, got %+v", telemetry)
+	}
+}
@@ -2,6 +2,8 @@ package delegation_backend
 
 import (
 	"container/heap"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 )
@@ -14,8 +16,13 @@ type nowFunc = func() time.Time
 type AttemptCounter struct {
 	attempts   map[Pk]*timeHeap
 	maxAttempt int
-	mutex      sync.Mutex
-	now        nowFunc
+	overrides  map[Pk]int
+	// Limits of the delegation whitelist, overrides take precedence
+	whitelistOverrides map[Pk]int
+	mutex              sync.Mutex
+	now                nowFunc
+	// Optional, attempts are counted across replicas
+	shared SharedState
 }
 
 func (h timeHeap) Len() int {
@@ -46,17 +53,78 @@ func NewAttemptCounter(maxAttemptPerHour int) *AttemptCounter {
 	th := new(AttemptCounter)
 	th.maxAttempt = maxAttemptPerHour
 	th.attempts = make(map[Pk]*timeHeap)
+	th.overrides = make(map[Pk]int)
+	th.whitelistOverrides = make(map[Pk]int)
 	th.now = func() time.Time { return time.Now() }
 	return th
 }
 
+// Replace per-key limits which take precedence over the default
+// hourly limit. Keys not present in the map use the default.
+func (h *AttemptCounter) SetOverrides(overrides map[Pk]int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.overrides = overrides
+}
+
+// Replace per-key limits read from the delegation whitelist, they take
+// precedence over the default hourly limit but not over the overrides.
+func (h *AttemptCounter) SetWhitelistOverrides(overrides map[Pk]int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.whitelistOverrides = overrides
+}
+
+// Change the default hourly limit, attempts already
+// recorded count towards the new limit.
+func (h *AttemptCounter) SetMaxAttempt(maxAttemptPerHour int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.maxAttempt = maxAttemptPerHour
+}
+
+// Count attempts in the shared state, so that replicas enforce the limit
+// together. Attempts are counted in memory while the shared state fails.
+func (h *AttemptCounter) SetSharedState(shared SharedState) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.shared = shared
+}
+
+func (h *AttemptCounter) maxAttemptFor(pk Pk) int {
+	if limit, exists := h.overrides[pk]; exists {
+		return limit
+	}
+	if limit, exists := h.whitelistOverrides[pk]; exists {
+		return limit
+	}
+	return h.maxAttempt
+}
+
+// Hourly limit of the key
+func (h *AttemptCounter) Limit(pk Pk) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.maxAttemptFor(pk)
+}
+
 // Record attempt to access the service
 // Returns `true` if attempt was successfully recorded
 // or `false` if amount of attempts per Pk per hour exceeded.
 func (h *AttemptCounter) RecordAttempt(pk Pk) bool {
+	h.mutex.Lock()
+	shared, curTime, maxAttempt := h.shared, h.now(), h.maxAttemptFor(pk)
+	h.mutex.Unlock()
+	if shared != nil {
+		recorded, err := shared.RecordAttempt("attempts:"+pk.String(), maxAttempt, time.Hour, curTime)
+		if err == nil {
+			return recorded
+		}
+		incMetric("shared_state_errors")
+	}
+
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
-	curTime := h.now()
 	if h.attempts[pk] == nil {
 		t := timeHeap(make([]time.Time, 0, h.maxAttempt))
 		h.attempts[pk] = &t
@@ -68,9 +136,52 @@ func (h *AttemptCounter) RecordAttempt(pk Pk) bool {
 		}
 		_ = heap.Pop(t)
 	}
-	if len(*t) >= h.maxAttempt {
+	if len(*t) >= maxAttempt {
 		return false
 	}
 	heap.Push(t, curTime)
 	return true
 }
+
+// Snapshot of the attempts of the last hour by public key, counted in
+// memory
+func (h *AttemptCounter) Snapshot() map[string][]time.Time {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	since := h.now().Add(minusOneHour)
+	res := make(map[string][]time.Time, len(h.attempts))
+	for pk, t := range h.attempts {
+		var times []time.Time
+		for _, at := range *t {
+			if at.After(since) {
+				times = append(times, at)
+			}
+		}
+		if len(times) > 0 {
+			sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+			res[pk.String()] = times
+		}
+	}
+	return res
+}
+
+// Restore attempts of a snapshot, replacing the attempts counted of the
+// keys of the snapshot
+func (h *AttemptCounter) Restore(attempts map[string][]time.Time) error {
+	restored := make(map[Pk]*timeHeap, len(attempts))
+	for pkStr, times := range attempts {
+		var pk Pk
+		if err := StringToPk(&pk, pkStr); err != nil {
+			return fmt.Errorf("invalid public key %s in attempts: %w", pkStr, err)
+		}
+		t := timeHeap(append([]time.Time(nil), times...))
+		heap.Init(&t)
+		restored[pk] = &t
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for pk, t := range restored {
+		h.attempts[pk] = t
+	}
+	return nil
+}
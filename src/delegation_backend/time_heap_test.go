@@ -46,10 +46,17 @@ func newTestAttemptCounter(maxAttemptPerHour int) (*AttemptCounter, *timeMock) {
 	return th, tm
 }
 
+// Random public key, a point of the curve
 func mkPk() Pk {
-	var a Pk
-	rand.Read(a[:])
-	return a
+	for {
+		var a Pk
+		rand.Read(a[:])
+		a[KEY_FIELD_SIZE-1] &= 0x3f
+		a[KEY_FIELD_SIZE] &= 1
+		if a.Validate() == nil {
+			return a
+		}
+	}
 }
 
 func TestZeroMaxAttempt(t *testing.T) {
@@ -136,3 +143,40 @@ func TestTwoPks(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestOverride(t *testing.T) {
+	pk1 := mkPk()
+	pk2 := mkPk()
+	counter, timeMock := newTestAttemptCounter(1)
+	counter.SetOverrides(map[Pk]int{pk1: 3})
+	for i := 0; i < 3; i++ {
+		if !counter.RecordAttempt(pk1) {
+			t.FailNow()
+		}
+	}
+	if counter.RecordAttempt(pk1) {
+		t.FailNow()
+	}
+	if !counter.RecordAttempt(pk2) {
+		t.FailNow()
+	}
+	if counter.RecordAttempt(pk2) {
+		t.FailNow()
+	}
+	timeMock.Advance(h)
+	if !counter.RecordAttempt(pk1) {
+		t.FailNow()
+	}
+}
+
+func TestParseRateLimitOverride(t *testing.T) {
+	pk, limit, err := parseRateLimitOverride(PK1 + ":500")
+	if err != nil || limit != 500 || pk.String() != PK1 {
+		t.Fatalf("unexpected result: %v %v %v", pk, limit, err)
+	}
+	for _, bad := range []string{PK1, PK1 + ":abc", PK1 + ":-1", "B62qbad:10"} {
+		if _, _, err := parseRateLimitOverride(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package delegation_backend
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const TLS_CERT_RELOAD_INTERVAL = time.Minute
+
+// CertReloader serves a certificate loaded from disk and reloads it
+// whenever the certificate or key file changes, so that renewed
+// certificates are picked up without restarting the service.
+type CertReloader struct {
+	certFile    string
+	keyFile     string
+	mutex       sync.RWMutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload loads the key pair if any of the files changed since the last load.
+// Returns `true` if a new certificate was loaded.
+func (cr *CertReloader) Reload() (bool, error) {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return false, fmt.Errorf("error reading TLS certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("error reading TLS key: %w", err)
+	}
+
+	cr.mutex.RLock()
+	unchanged := cr.cert != nil && certInfo.ModTime().Equal(cr.certModTime) && keyInfo.ModTime().Equal(cr.keyModTime)
+	cr.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("error loading TLS key pair: %w", err)
+	}
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	cr.cert = &cert
+	cr.certModTime = certInfo.ModTime()
+	cr.keyModTime = keyInfo.ModTime()
+	return true, nil
+}
+
+// Periodically reload the certificate, keeping the previous one on
+// failure, until the context is done.
+func (cr *CertReloader) Watch(ctx context.Context, interval time.Duration, log logging.StandardLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := cr.Reload()
+		if err != nil {
+			log.Errorf("Failed to reload TLS certificate, using previous one, error: %v", err)
+		} else if reloaded {
+			log.Infof("TLS certificate reloaded from %s", cr.certFile)
+		}
+	}
+}
+
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.cert, nil
+}
+
+// NewServerTLSConfig builds the TLS configuration of the HTTPS listener,
+// either from certificate files or from ACME (Let's Encrypt) autocert.
+// Certificate files are reloaded until the context is done.
+func NewServerTLSConfig(ctx context.Context, cfg *TLSConfig, log logging.StandardLogger) (*tls.Config, error) {
+	tlsCfg, err := newServerTLSConfig(ctx, cfg, log)
+	if err != nil || cfg.ClientCAFile == "" {
+		return tlsCfg, err
+	}
+	// Client certificates are verified if given, the submit handler then
+	// rejects requests without one, which keeps /health usable over TLS.
+	caPem, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading TLS client CA: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPem) {
+		return nil, fmt.Errorf("no certificates found in TLS client CA file %s", cfg.ClientCAFile)
+	}
+	tlsCfg.ClientCAs = clientCAs
+	tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsCfg, nil
+}
+
+func newServerTLSConfig(ctx context.Context, cfg *TLSConfig, log logging.StandardLogger) (*tls.Config, error) {
+	if len(cfg.AutocertDomains) > 0 {
+		if cfg.CertFile != "" || cfg.KeyFile != "" {
+			return nil, fmt.Errorf("TLS certificate files and autocert domains are mutually exclusive")
+		}
+		if cfg.AutocertCacheDir == "" {
+			return nil, fmt.Errorf("TLS autocert requires a cache directory")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		tlsCfg := m.TLSConfig()
+		tlsCfg.MinVersion = tls.VersionTLS12
+		return tlsCfg, nil
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("both TLS certificate and key files are required")
+	}
+	cr, err := NewCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	go cr.Watch(ctx, TLS_CERT_RELOAD_INTERVAL, log)
+	return &tls.Config{
+		GetCertificate: cr.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,139 @@
+package delegation_backend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func writeTestKeyPair(t *testing.T, certFile, keyFile string, serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	os.Chtimes(certFile, modTime, modTime)
+	os.Chtimes(keyFile, modTime, modTime)
+}
+
+func certSerial(t *testing.T, cr *CertReloader) int64 {
+	cert, _ := cr.GetCertificate(nil)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Minute)
+	writeTestKeyPair(t, certFile, keyFile, 1, start)
+
+	cr, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certSerial(t, cr) != 1 {
+		t.Fatal("unexpected initial certificate")
+	}
+	if reloaded, err := cr.Reload(); err != nil || reloaded {
+		t.Fatalf("unexpected reload of unchanged files: %v %v", reloaded, err)
+	}
+
+	writeTestKeyPair(t, certFile, keyFile, 2, start.Add(time.Second))
+	if reloaded, err := cr.Reload(); err != nil || !reloaded {
+		t.Fatalf("expected reload: %v %v", reloaded, err)
+	}
+	if certSerial(t, cr) != 2 {
+		t.Fatal("renewed certificate not served")
+	}
+
+	// Broken renewal keeps the previous certificate
+	os.WriteFile(certFile, []byte("garbage"), 0600)
+	os.Chtimes(certFile, start.Add(2*time.Second), start.Add(2*time.Second))
+	if _, err := cr.Reload(); err == nil {
+		t.Fatal("expected error for broken certificate")
+	}
+	if certSerial(t, cr) != 2 {
+		t.Fatal("previous certificate should be kept")
+	}
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Minute)
+	writeTestKeyPair(t, certFile, keyFile, 1, start)
+	cr, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		cr.Watch(ctx, 10*time.Millisecond, logging.Logger("test"))
+		close(stopped)
+	}()
+
+	writeTestKeyPair(t, certFile, keyFile, 2, start.Add(time.Second))
+	deadline := time.Now().Add(5 * time.Second)
+	for certSerial(t, cr) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("renewed certificate not reloaded by the watch")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch not stopped with its context")
+	}
+}
+
+func TestServerTLSConfigValidation(t *testing.T) {
+	log := logging.Logger("tls test")
+	if _, err := NewServerTLSConfig(context.Background(), &TLSConfig{CertFile: "cert.pem"}, log); err == nil {
+		t.Error("expected error for missing key file")
+	}
+	if _, err := NewServerTLSConfig(context.Background(), &TLSConfig{AutocertDomains: []string{"example.com"}}, log); err == nil {
+		t.Error("expected error for missing autocert cache dir")
+	}
+	if _, err := NewServerTLSConfig(context.Background(), &TLSConfig{CertFile: "c", KeyFile: "k", AutocertDomains: []string{"example.com"}}, log); err == nil {
+		t.Error("expected error for conflicting options")
+	}
+	if cfg, err := NewServerTLSConfig(context.Background(), &TLSConfig{AutocertDomains: []string{"example.com"}, AutocertCacheDir: t.TempDir()}, log); err != nil || cfg.GetCertificate == nil {
+		t.Errorf("unexpected autocert config: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package delegation_backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans of the submit pipeline are made with the OpenTelemetry API and,
+// once the provider of NewOTLPTracerProvider is installed as the global
+// tracer provider, exported in batches to an OTLP/HTTP collector.
+var tracer = otel.Tracer("block_producers_uptime/delegation_backend")
+
+const (
+	TRACING_DEFAULT_SERVICE_NAME = "uptime-service-backend"
+	TRACING_QUEUE_SIZE           = 4096
+	TRACING_BATCH_SIZE           = 512
+	TRACING_EXPORT_INTERVAL      = 5 * time.Second
+	TRACING_EXPORT_TIMEOUT       = 10 * time.Second
+)
+
+// TraceSave runs the save of a storage backend within a span, unless ctx
+// is already done
+func TraceSave(ctx context.Context, backend string, objs ObjectsToSave, save func(context.Context, ObjectsToSave) error) error {
+	ctx, span := tracer.Start(ctx, "save "+backend, trace.WithAttributes(
+		attribute.String("storage.backend", backend),
+		attribute.Int("storage.objects", len(objs)),
+	))
+	defer span.End()
+	err := ctx.Err()
+	if err == nil {
+		err = save(ctx, objs)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// SaveToBackends saves the objects to every backend with TraceSave, one
+// after the other or, if parallel, concurrently. Values of the objects
+// are only valid for the duration of the call, retained is set if any
+// backend keeps them past its save, e.g. memory storage or asynchronous
+// publishing, so that they are copied once for all backends.
+func SaveToBackends(ctx context.Context, objs ObjectsToSave, backends map[string]func(context.Context, ObjectsToSave) error, parallel bool, retained bool) StorageOutcomes {
+	if retained {
+		objs = objs.Clone()
+	}
+	outcomes := make(StorageOutcomes)
+	if !parallel {
+		for backend, save := range backends {
+			outcomes[backend] = TraceSave(ctx, backend, objs, save)
+		}
+		return outcomes
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for backend, save := range backends {
+		wg.Add(1)
+		go func(backend string, save func(context.Context, ObjectsToSave) error) {
+			defer wg.Done()
+			err := TraceSave(ctx, backend, objs, save)
+			mutex.Lock()
+			outcomes[backend] = err
+			mutex.Unlock()
+		}(backend, save)
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// NewOTLPTracerProvider makes the tracer provider exporting spans in
+// batches to the OTLP/HTTP collector. Traces are sampled by the ratio of
+// trace IDs, respecting the decision of a remote parent.
+func NewOTLPTracerProvider(cfg *TracingConfig, log logging.StandardLogger) (*sdktrace.TracerProvider, error) {
+	if cfg.OtlpEndpoint == "" {
+		return nil, fmt.Errorf("OTLP endpoint is not configured")
+	}
+	ratio := 1.0
+	if cfg.SampleRatio != nil {
+		ratio = *cfg.SampleRatio
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("sample ratio %v is out of range [0, 1]", ratio)
+	}
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = TRACING_DEFAULT_SERVICE_NAME
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.OtlpEndpoint, "/") + "/v1/traces"),
+		otlptracehttp.WithTimeout(TRACING_EXPORT_TIMEOUT),
+	}
+	// Other settings of the exporter, e.g. OTEL_EXPORTER_OTLP_COMPRESSION,
+	// are read from the environment by the exporter, headers of the
+	// environment take precedence over the ones of the configuration
+	if len(cfg.Headers) > 0 && os.Getenv("OTEL_EXPORTER_OTLP_HEADERS") == "" {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(&countingSpanExporter{SpanExporter: exporter, endpoint: cfg.OtlpEndpoint, log: log},
+			sdktrace.WithMaxQueueSize(TRACING_QUEUE_SIZE),
+			sdktrace.WithMaxExportBatchSize(TRACING_BATCH_SIZE),
+			sdktrace.WithBatchTimeout(TRACING_EXPORT_INTERVAL),
+			sdktrace.WithExportTimeout(TRACING_EXPORT_TIMEOUT),
+		),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// countingSpanExporter counts and logs failed exports, which the batcher
+// only hands to the global error handler otherwise
+type countingSpanExporter struct {
+	sdktrace.SpanExporter
+	endpoint string
+	log      logging.StandardLogger
+}
+
+func (e *countingSpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil {
+		incMetric("tracing_export_errors")
+		e.log.Warnf("Error exporting %d spans to %s: %v", len(spans), e.endpoint, err)
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	logging "github.com/ipfs/go-log/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+type testCollector struct {
+	mutex   sync.Mutex
+	spans   []*tracepb.Span
+	service string
+	headers http.Header
+}
+
+func newTestCollector(t *testing.T) (*testCollector, *httptest.Server) {
+	c := &testCollector{}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			t.Errorf("malformed export request: %v", err)
+		}
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.headers = r.Header
+		for _, rs := range req.ResourceSpans {
+			for _, attr := range rs.Resource.Attributes {
+				if attr.Key == "service.name" {
+					c.service = attr.Value.GetStringValue()
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+		rw.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	return c, srv
+}
+
+func TestOTLPTracerProviderExport(t *testing.T) {
+	c, srv := newTestCollector(t)
+	defer srv.Close()
+	tp, err := NewOTLPTracerProvider(&TracingConfig{OtlpEndpoint: srv.URL + "/", Headers: map[string]string{"X-Token": "t"}}, logging.Logger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tp.Tracer("test")
+	ctx, parent := tr.Start(context.Background(), "parent", trace.WithSpanKind(trace.SpanKindServer))
+	_, child := tr.Start(ctx, "child", trace.WithAttributes(attribute.Int("n", 3)))
+	child.SetStatus(codes.Error, "failed")
+	child.End()
+	parent.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.spans) != 2 || c.headers.Get("X-Token") != "t" || c.service != TRACING_DEFAULT_SERVICE_NAME {
+		t.Fatalf("unexpected export of %s: %+v", c.service, c.spans)
+	}
+	ch, p := c.spans[0], c.spans[1]
+	if ch.Name != "child" || p.Name != "parent" || hex.EncodeToString(ch.TraceId) != hex.EncodeToString(p.TraceId) ||
+		hex.EncodeToString(ch.ParentSpanId) != hex.EncodeToString(p.SpanId) || len(p.ParentSpanId) != 0 {
+		t.Fatalf("unexpected spans: %+v", c.spans)
+	}
+	if p.Kind != tracepb.Span_SPAN_KIND_SERVER || ch.Status.Code != tracepb.Status_STATUS_CODE_ERROR || ch.Status.Message != "failed" {
+		t.Fatalf("unexpected kind or status: %+v", c.spans)
+	}
+	if len(ch.Attributes) != 1 || !proto.Equal(ch.Attributes[0], &commonpb.KeyValue{Key: "n", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 3}}}) {
+		t.Fatalf("unexpected attributes: %+v", ch.Attributes)
+	}
+}
+
+func TestOTLPTracerProviderSampling(t *testing.T) {
+	c, srv := newTestCollector(t)
+	defer srv.Close()
+	ratio := 0.0
+	tp, err := NewOTLPTracerProvider(&TracingConfig{OtlpEndpoint: srv.URL, SampleRatio: &ratio}, logging.Logger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tp.Tracer("test")
+	_, unsampled := tr.Start(context.Background(), "unsampled")
+	if unsampled.IsRecording() || unsampled.SpanContext().IsSampled() {
+		t.Fatal("span sampled with ratio 0")
+	}
+	unsampled.End()
+
+	// Decision of the remote parent is respected
+	remote := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	_, sampled := tr.Start(trace.ContextWithRemoteSpanContext(context.Background(), remote), "sampled")
+	sampled.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.spans) != 1 || c.spans[0].Name != "sampled" || hex.EncodeToString(c.spans[0].TraceId) != remote.TraceID().String() {
+		t.Fatalf("unexpected export: %+v", c.spans)
+	}
+
+	invalid := 1.5
+	if _, err := NewOTLPTracerProvider(&TracingConfig{OtlpEndpoint: srv.URL, SampleRatio: &invalid}, logging.Logger("test")); err == nil {
+		t.Fatal("expected error for sample ratio out of range")
+	}
+}
@@ -0,0 +1,104 @@
+package delegation_backend
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const VERIFY_CACHE_TTL = 10 * time.Minute
+const VERIFY_CACHE_MAX_ENTRIES = 100000
+
+type verifyCacheKey struct {
+	pk   Pk
+	sig  Sig
+	hash [32]byte
+}
+
+type verifyCacheEntry struct {
+	key     verifyCacheKey
+	valid   bool
+	expires time.Time
+}
+
+// VerifyCache remembers signature verification results for a short time,
+// so that retried submissions don't pay for verification again.
+type VerifyCache struct {
+	mutex   sync.Mutex
+	entries map[verifyCacheKey]*list.Element
+	// Entries by expiry, the earliest at the front. Entries are cached for
+	// the same ttl, so the latest put expires last.
+	expiry     *list.List
+	ttl        time.Duration
+	maxEntries int
+	now        nowFunc
+}
+
+func NewVerifyCache(ttl time.Duration, maxEntries int) *VerifyCache {
+	return &VerifyCache{
+		entries:    make(map[verifyCacheKey]*list.Element),
+		expiry:     list.New(),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+func mkVerifyCacheKey(pk *Pk, sig *Sig, hash []byte) (key verifyCacheKey) {
+	key.pk = *pk
+	key.sig = *sig
+	copy(key.hash[:], hash)
+	return
+}
+
+// Get returns the cached result of verification and whether it was found.
+func (c *VerifyCache) Get(pk *Pk, sig *Sig, hash []byte) (valid bool, found bool) {
+	key := mkVerifyCacheKey(pk, sig, hash)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, exists := c.entries[key]
+	if !exists {
+		return false, false
+	}
+	entry := elem.Value.(*verifyCacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(elem)
+		return false, false
+	}
+	return entry.valid, true
+}
+
+// Put caches the result of verification. Expired entries are evicted
+// first, when the cache is full and none expired the result is not cached.
+func (c *VerifyCache) Put(pk *Pk, sig *Sig, hash []byte, valid bool) {
+	key := mkVerifyCacheKey(pk, sig, hash)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.now()
+	// Each entry is evicted once, so puts are constant time on average
+	for elem := c.expiry.Front(); elem != nil; elem = c.expiry.Front() {
+		if now.Before(elem.Value.(*verifyCacheEntry).expires) {
+			break
+		}
+		c.remove(elem)
+	}
+	if elem, exists := c.entries[key]; exists {
+		c.remove(elem)
+	}
+	if len(c.entries) >= c.maxEntries {
+		return
+	}
+	entry := &verifyCacheEntry{key: key, valid: valid, expires: now.Add(c.ttl)}
+	c.entries[key] = c.expiry.PushBack(entry)
+}
+
+func (c *VerifyCache) remove(elem *list.Element) {
+	c.expiry.Remove(elem)
+	delete(c.entries, elem.Value.(*verifyCacheEntry).key)
+}
+
+func (c *VerifyCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries)
+}
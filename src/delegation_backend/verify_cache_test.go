@@ -0,0 +1,104 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestVerifyCacheExpiry(t *testing.T) {
+	c := NewVerifyCache(time.Minute, 2)
+	tm := new(timeMock)
+	tm.time = time.Now()
+	c.now = tm.Now
+	pk := mkPk()
+	var sig Sig
+	hash := make([]byte, 32)
+	if _, found := c.Get(&pk, &sig, hash); found {
+		t.Fatal("unexpected cache hit")
+	}
+	c.Put(&pk, &sig, hash, true)
+	if valid, found := c.Get(&pk, &sig, hash); !found || !valid {
+		t.Fatal("expected cache hit")
+	}
+	otherHash := make([]byte, 32)
+	otherHash[0] = 1
+	if _, found := c.Get(&pk, &sig, otherHash); found {
+		t.Fatal("unexpected cache hit for other payload")
+	}
+	tm.Advance(time.Minute)
+	if _, found := c.Get(&pk, &sig, hash); found {
+		t.Fatal("expired entry returned")
+	}
+}
+
+func TestVerifyCacheFull(t *testing.T) {
+	c := NewVerifyCache(time.Minute, 1)
+	tm := new(timeMock)
+	tm.time = time.Now()
+	c.now = tm.Now
+	pk1, pk2 := mkPk(), mkPk()
+	var sig Sig
+	hash := make([]byte, 32)
+	c.Put(&pk1, &sig, hash, true)
+	c.Put(&pk2, &sig, hash, true)
+	if _, found := c.Get(&pk2, &sig, hash); found || c.Len() != 1 {
+		t.Fatal("entry cached beyond capacity")
+	}
+	tm.Advance(time.Minute)
+	c.Put(&pk2, &sig, hash, false)
+	if valid, found := c.Get(&pk2, &sig, hash); !found || valid {
+		t.Fatal("expired entries not evicted")
+	}
+}
+
+func TestVerifyCacheEvictsByExpiry(t *testing.T) {
+	c := NewVerifyCache(time.Minute, 3)
+	tm := new(timeMock)
+	tm.time = time.Now()
+	c.now = tm.Now
+	pk1, pk2, pk3 := mkPk(), mkPk(), mkPk()
+	var sig Sig
+	hash := make([]byte, 32)
+	c.Put(&pk1, &sig, hash, true)
+	tm.Advance(30 * time.Second)
+	c.Put(&pk2, &sig, hash, true)
+	// Putting again refreshes the entry rather than adding one
+	c.Put(&pk2, &sig, hash, false)
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", c.Len())
+	}
+	// Only the entry of pk1 expired, it is evicted by the next put
+	tm.Advance(30 * time.Second)
+	c.Put(&pk3, &sig, hash, true)
+	if _, found := c.Get(&pk1, &sig, hash); found || c.Len() != 2 {
+		t.Fatalf("expected the expired entry to be evicted, %d entries", c.Len())
+	}
+	if valid, found := c.Get(&pk2, &sig, hash); !found || valid {
+		t.Fatal("expected the latest result of pk2")
+	}
+}
+
+func TestSubmitUsesVerifyCache(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.SubmitCounter = NewAttemptCounter(10)
+	sh.app.VerifyCache = NewVerifyCache(time.Minute, 10)
+	calls := 0
+	sh.app.VerifyPool = newVerifyPool(1, 1, func(scheme SignatureScheme, pk *Pk, sig *Sig, data []byte) bool {
+		calls++
+		return scheme.Verify(pk, sig, data)
+	})
+	for i := 0; i < 3; i++ {
+		if rep := sh.testRequest(body); rep.Code != 200 {
+			t.Fatalf("unexpected failure: %v", rep)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected one verification, got %d", calls)
+	}
+}
@@ -0,0 +1,76 @@
+package delegation_backend
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrVerifyPoolSaturated = errors.New("signature verification queue is full")
+
+type verifyJob struct {
+	ctx    context.Context
+	scheme SignatureScheme
+	pk     *Pk
+	sig    *Sig
+	data   []byte
+	result chan bool
+}
+
+// VerifyPool runs signature verification on a fixed number of workers,
+// so that a burst of submissions can't use more CPU than budgeted.
+// Jobs exceeding the queue capacity are rejected instead of waiting.
+type VerifyPool struct {
+	jobs   chan verifyJob
+	verify func(scheme SignatureScheme, pk *Pk, sig *Sig, data []byte) bool
+}
+
+func NewVerifyPool(workers int, queueSize int) *VerifyPool {
+	return newVerifyPool(workers, queueSize, func(scheme SignatureScheme, pk *Pk, sig *Sig, data []byte) bool {
+		return scheme.Verify(pk, sig, data)
+	})
+}
+
+func newVerifyPool(workers int, queueSize int, verify func(SignatureScheme, *Pk, *Sig, []byte) bool) *VerifyPool {
+	pool := &VerifyPool{
+		jobs:   make(chan verifyJob, queueSize),
+		verify: verify,
+	}
+	for i := 0; i < workers; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+func (pool *VerifyPool) work() {
+	for job := range pool.jobs {
+		// Nobody waits for the result of jobs whose context is done
+		if job.ctx.Err() != nil {
+			job.result <- false
+			continue
+		}
+		job.result <- pool.verify(job.scheme, job.pk, job.sig, job.data)
+	}
+}
+
+// Verify enqueues the verification with the scheme and waits for its
+// result, or until ctx is done. Returns ErrVerifyPoolSaturated if the
+// queue is full.
+func (pool *VerifyPool) Verify(ctx context.Context, scheme SignatureScheme, pk *Pk, sig *Sig, data []byte) (bool, error) {
+	job := verifyJob{ctx, scheme, pk, sig, data, make(chan bool, 1)}
+	select {
+	case pool.jobs <- job:
+	default:
+		return false, ErrVerifyPoolSaturated
+	}
+	select {
+	case valid := <-job.result:
+		return valid, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// Number of verifications waiting for a worker
+func (pool *VerifyPool) QueueLength() int {
+	return len(pool.jobs)
+}
@@ -0,0 +1,83 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"testing"
+)
+
+func TestVerifyPoolSaturation(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	pool := newVerifyPool(1, 1, func(SignatureScheme, *Pk, *Sig, []byte) bool {
+		started <- struct{}{}
+		<-release
+		return true
+	})
+	var pk Pk
+	var sig Sig
+	results := make(chan bool, 2)
+	go func() {
+		ok, _ := pool.Verify(context.Background(), MinaSchnorr{}, &pk, &sig, nil)
+		results <- ok
+	}()
+	<-started // worker is busy
+	go func() {
+		ok, _ := pool.Verify(context.Background(), MinaSchnorr{}, &pk, &sig, nil)
+		results <- ok
+	}()
+	for pool.QueueLength() != 1 {
+		runtime.Gosched()
+	}
+	if _, err := pool.Verify(context.Background(), MinaSchnorr{}, &pk, &sig, nil); err != ErrVerifyPoolSaturated {
+		t.Fatalf("expected saturation, got %v", err)
+	}
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+	if !<-results || !<-results {
+		t.Fatal("queued verification failed")
+	}
+}
+
+func TestSubmitWithVerifyPool(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, _ := testSubmitH(1, Whitelist{req.Submitter: true})
+	sh.app.VerifyPool = NewVerifyPool(2, 2)
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("unexpected failure: %v", rep)
+	}
+	var badSig Sig
+	req.Sig = badSig
+	req.Sig[0] = 1
+	body2, _ := json.Marshal(req)
+	if rep := sh.testRequest(body2); rep.Code != 401 {
+		t.Fatalf("expected invalid signature: %v", rep)
+	}
+}
+
+func BenchmarkVerifySignature(b *testing.B) {
+	body := readTestFile("req-with-snark", b)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		b.Fatal(err)
+	}
+	hash, err := req.Data.SignPayloadHash()
+	if err != nil {
+		b.Fatal(err)
+	}
+	pool := NewVerifyPool(runtime.GOMAXPROCS(0), 1024)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if ok, err := pool.Verify(context.Background(), MinaSchnorr{NetworkId: 1}, &req.Submitter, &req.Sig, hash); !ok || err != nil {
+				b.Fatalf("verification failed, error: %v", err)
+			}
+		}
+	})
+}
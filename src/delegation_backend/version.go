@@ -0,0 +1,74 @@
+package delegation_backend
+
+import (
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// Build information, set at build time with `-ldflags`, e.g.
+// `-X block_producers_uptime/delegation_backend.Version=1.2.3`
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// BuildInfo identifies the build of the backend, it is returned
+// by /version and recorded in metadata of saved submissions
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version,omitempty"`
+}
+
+var buildInfo = readBuildInfo()
+
+// Commit and build date not set at build time are taken
+// from the VCS information stamped by the Go toolchain
+func readBuildInfo() BuildInfo {
+	info := BuildInfo{Version: Version, Commit: Commit, BuildDate: BuildDate}
+	goInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = goInfo.GoVersion
+	for _, setting := range goInfo.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && info.Commit == "":
+			info.Commit = setting.Value
+		case setting.Key == "vcs.time" && info.BuildDate == "":
+			info.BuildDate = setting.Value
+		}
+	}
+	return info
+}
+
+func GetBuildInfo() BuildInfo {
+	return buildInfo
+}
+
+func (info BuildInfo) String() string {
+	var details []string
+	if info.Commit != "" {
+		details = append(details, "commit "+info.Commit)
+	}
+	if info.BuildDate != "" {
+		details = append(details, "built "+info.BuildDate)
+	}
+	if info.GoVersion != "" {
+		details = append(details, info.GoVersion)
+	}
+	if len(details) == 0 {
+		return info.Version
+	}
+	return info.Version + " (" + strings.Join(details, ", ") + ")"
+}
+
+// VersionHandler handles the /version endpoint
+func VersionHandler() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, http.StatusOK, buildInfo)
+	}
+}
@@ -0,0 +1,29 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuildInfoString(t *testing.T) {
+	info := BuildInfo{Version: "1.2.3", Commit: "449dc6a", BuildDate: "2024-05-10T12:00:00Z", GoVersion: "go1.22.3"}
+	if s := info.String(); s != "1.2.3 (commit 449dc6a, built 2024-05-10T12:00:00Z, go1.22.3)" {
+		t.Fatalf("unexpected version %s", s)
+	}
+	if s := (BuildInfo{Version: "dev"}).String(); s != "dev" {
+		t.Fatalf("unexpected version %s", s)
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	VersionHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/version", nil))
+	var info BuildInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil || rr.Code != 200 {
+		t.Fatalf("unexpected response %d: %s", rr.Code, rr.Body)
+	}
+	if info != GetBuildInfo() || info.Version != Version {
+		t.Fatalf("unexpected build info %+v", info)
+	}
+}
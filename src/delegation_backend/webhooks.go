@@ -0,0 +1,342 @@
+package delegation_backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Events webhooks are fired on
+const (
+	WEBHOOK_SUBMISSION_ACCEPTED  = "submission_accepted"
+	WEBHOOK_SUBMITTER_FIRST_SEEN = "submitter_first_seen"
+	WEBHOOK_BACKEND_FAILURE      = "backend_failure"
+	WEBHOOK_WHITELIST_CHANGED    = "whitelist_changed"
+)
+
+var WEBHOOK_EVENTS = []string{WEBHOOK_SUBMISSION_ACCEPTED, WEBHOOK_SUBMITTER_FIRST_SEEN, WEBHOOK_BACKEND_FAILURE, WEBHOOK_WHITELIST_CHANGED}
+
+// Defaults of delivery
+const (
+	WEBHOOK_DEFAULT_MAX_ATTEMPTS = 5
+	WEBHOOK_DEFAULT_BACKOFF      = time.Second
+	WEBHOOK_MAX_BACKOFF          = 5 * time.Minute
+)
+
+const WEBHOOK_QUEUE_SIZE = 1000
+const WEBHOOK_SEND_TIMEOUT = 10 * time.Second
+
+// Headers of deliveries
+const (
+	WEBHOOK_SIGNATURE_HEADER = "X-Uptime-Signature"
+	WEBHOOK_TIMESTAMP_HEADER = "X-Uptime-Timestamp"
+	WEBHOOK_EVENT_HEADER     = "X-Uptime-Event"
+	WEBHOOK_DELIVERY_HEADER  = "X-Uptime-Delivery"
+)
+
+// WebhookPayload is the body posted to webhooks
+type WebhookPayload struct {
+	// Unique by event, the same in retries of a delivery
+	Id    string      `json:"id"`
+	Event string      `json:"event"`
+	At    time.Time   `json:"at"`
+	Data  interface{} `json:"data"`
+}
+
+// Data of submission_accepted and submitter_first_seen
+type WebhookSubmission struct {
+	Network     string    `json:"network"`
+	Submitter   string    `json:"submitter"`
+	BlockHash   string    `json:"block_hash"`
+	CreatedAt   string    `json:"created_at"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	MetaPath    string    `json:"meta_path"`
+}
+
+// Data of backend_failure
+type WebhookBackendFailure struct {
+	Message string            `json:"message"`
+	Error   string            `json:"error,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+}
+
+// Data of whitelist_changed
+type WebhookWhitelistChange struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Size    int      `json:"size"`
+}
+
+// WebhookSignature signs the timestamp and the body of a delivery with
+// HMAC-SHA256 of the secret, as `sha256=<hex>` of `<timestamp>.<body>`
+func WebhookSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// A delivery exhausting its attempts, appended to the dead-letter log
+type WebhookDeadLetter struct {
+	URL      string          `json:"url"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failed_at"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+type webhookEndpoint struct {
+	url    string
+	secret string
+	// Events fired to the endpoint, all if nil
+	events map[string]bool
+	queue  chan []byte
+}
+
+// Webhooks posts events to the configured endpoints in the background,
+// with a queue and a worker per endpoint so that a slow endpoint doesn't
+// hold back others. Failed deliveries are retried with exponential
+// backoff, those failing every attempt are appended to the dead-letter
+// log. Deliveries are at least once: receivers deduplicate by id.
+type Webhooks struct {
+	endpoints   []*webhookEndpoint
+	client      *http.Client
+	network     string
+	maxAttempts int
+	backoff     time.Duration
+	// JSON lines of dead letters, logged only if empty
+	deadLetterFile  string
+	deadLetterMutex sync.Mutex
+	now             nowFunc
+	log             logging.StandardLogger
+}
+
+// NewWebhooksFromConfig creates the endpoints of the configuration
+func NewWebhooksFromConfig(cfg *WebhooksConfig, network string, now nowFunc, log logging.StandardLogger) *Webhooks {
+	w := &Webhooks{
+		client:         &http.Client{Timeout: WEBHOOK_SEND_TIMEOUT},
+		network:        network,
+		maxAttempts:    WEBHOOK_DEFAULT_MAX_ATTEMPTS,
+		backoff:        WEBHOOK_DEFAULT_BACKOFF,
+		deadLetterFile: cfg.DeadLetterFile,
+		now:            now,
+		log:            log,
+	}
+	if cfg.MaxAttempts > 0 {
+		w.maxAttempts = cfg.MaxAttempts
+	}
+	if cfg.BackoffMs > 0 {
+		w.backoff = time.Duration(cfg.BackoffMs) * time.Millisecond
+	}
+	for _, endpoint := range cfg.Endpoints {
+		e := &webhookEndpoint{
+			url:    endpoint.URL,
+			secret: endpoint.Secret,
+			queue:  make(chan []byte, WEBHOOK_QUEUE_SIZE),
+		}
+		if len(endpoint.Events) > 0 {
+			e.events = make(map[string]bool)
+			for _, event := range endpoint.Events {
+				e.events[event] = true
+			}
+		}
+		w.endpoints = append(w.endpoints, e)
+	}
+	return w
+}
+
+// Fire queues the event to the endpoints subscribed to it, it never blocks
+func (w *Webhooks) Fire(event string, data interface{}) {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	body, err := json.Marshal(WebhookPayload{Id: hex.EncodeToString(id[:]), Event: event, At: w.now().UTC(), Data: data})
+	if err != nil {
+		incMetric("webhook_delivery_errors")
+		w.log.Errorf("Error marshaling webhook event %s: %v", event, err)
+		return
+	}
+	for _, e := range w.endpoints {
+		if e.events != nil && !e.events[event] {
+			continue
+		}
+		select {
+		case e.queue <- body:
+		default:
+			incMetric("webhooks_dropped")
+		}
+	}
+}
+
+// SubmissionAccepted fires submission_accepted, and submitter_first_seen
+// if the submitter had no accepted submission before
+func (w *Webhooks) SubmissionAccepted(submitter Pk, blockHash string, createdAt time.Time, submittedAt time.Time, metaPath string, firstSeen bool) {
+	data := WebhookSubmission{
+		Network:     w.network,
+		Submitter:   submitter.String(),
+		BlockHash:   blockHash,
+		CreatedAt:   createdAt.UTC().Format(time.RFC3339),
+		SubmittedAt: submittedAt.UTC(),
+		MetaPath:    metaPath,
+	}
+	if firstSeen {
+		w.Fire(WEBHOOK_SUBMITTER_FIRST_SEEN, data)
+	}
+	w.Fire(WEBHOOK_SUBMISSION_ACCEPTED, data)
+}
+
+// Report lets webhooks receive storage failure bursts
+// from StorageFailureMonitor.
+func (w *Webhooks) Report(ev ErrorEvent) {
+	failure := WebhookBackendFailure{Message: ev.Message, Tags: ev.Tags}
+	if ev.Err != nil {
+		failure.Error = ev.Err.Error()
+	}
+	w.Fire(WEBHOOK_BACKEND_FAILURE, failure)
+}
+
+// WhitelistChanged fires whitelist_changed if submitters were added to or
+// removed from the whitelist
+func (w *Webhooks) WhitelistChanged(previous Whitelist, current Whitelist) {
+	change := WebhookWhitelistChange{Added: []string{}, Removed: []string{}, Size: len(current)}
+	for pk := range current {
+		if _, ok := previous[pk]; !ok {
+			change.Added = append(change.Added, pk.String())
+		}
+	}
+	for pk := range previous {
+		if _, ok := current[pk]; !ok {
+			change.Removed = append(change.Removed, pk.String())
+		}
+	}
+	if len(change.Added) == 0 && len(change.Removed) == 0 {
+		return
+	}
+	sort.Strings(change.Added)
+	sort.Strings(change.Removed)
+	w.Fire(WEBHOOK_WHITELIST_CHANGED, change)
+}
+
+// Run delivers queued events until the context is done
+func (w *Webhooks) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range w.endpoints {
+		wg.Add(1)
+		go func(e *webhookEndpoint) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case body := <-e.queue:
+					w.deliver(ctx, e, body)
+				}
+			}
+		}(e)
+	}
+	wg.Wait()
+}
+
+// Deliver the event, retrying with exponential backoff
+func (w *Webhooks) deliver(ctx context.Context, e *webhookEndpoint, body []byte) {
+	backoff := w.backoff
+	var err error
+	attempts := 0
+	for attempts < w.maxAttempts {
+		if attempts > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > WEBHOOK_MAX_BACKOFF {
+				backoff = WEBHOOK_MAX_BACKOFF
+			}
+		}
+		attempts++
+		var retryable bool
+		retryable, err = w.post(ctx, e, body)
+		if err == nil {
+			incMetric("webhook_deliveries")
+			return
+		}
+		incMetric("webhook_delivery_errors")
+		w.log.Warnf("Webhook delivery to %s failed (attempt %d of %d): %v", e.url, attempts, w.maxAttempts, err)
+		if !retryable {
+			break
+		}
+	}
+	w.deadLetter(e, body, attempts, err)
+}
+
+// Post the delivery, the error is retryable unless the endpoint rejected
+// the request
+func (w *Webhooks) post(ctx context.Context, e *webhookEndpoint, body []byte) (bool, error) {
+	var payload struct {
+		Id    string `json:"id"`
+		Event string `json:"event"`
+	}
+	_ = json.Unmarshal(body, &payload)
+	sendCtx, cancel := context.WithTimeout(ctx, WEBHOOK_SEND_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(sendCtx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(w.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WEBHOOK_TIMESTAMP_HEADER, timestamp)
+	req.Header.Set(WEBHOOK_EVENT_HEADER, payload.Event)
+	req.Header.Set(WEBHOOK_DELIVERY_HEADER, payload.Id)
+	if e.secret != "" {
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, WebhookSignature(e.secret, timestamp, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+		return retryable, fmt.Errorf("responded with %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return false, nil
+}
+
+func (w *Webhooks) deadLetter(e *webhookEndpoint, body []byte, attempts int, err error) {
+	incMetric("webhook_dead_letters")
+	letter := WebhookDeadLetter{URL: e.url, Attempts: attempts, Error: err.Error(), FailedAt: w.now().UTC(), Payload: body}
+	line, _ := json.Marshal(letter)
+	if w.deadLetterFile == "" {
+		w.log.Errorf("Webhook delivery to %s dropped after %d attempts: %s", e.url, attempts, line)
+		return
+	}
+	w.deadLetterMutex.Lock()
+	defer w.deadLetterMutex.Unlock()
+	f, ferr := os.OpenFile(w.deadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if ferr == nil {
+		_, ferr = f.Write(append(line, '\n'))
+		if cerr := f.Close(); ferr == nil {
+			ferr = cerr
+		}
+	}
+	if ferr != nil {
+		w.log.Errorf("Error writing dead letter of webhook %s to %s: %v, dropped: %s", e.url, w.deadLetterFile, ferr, line)
+	}
+}
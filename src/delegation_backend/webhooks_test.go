@@ -0,0 +1,165 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	var bodies [][]byte
+	var signatures []string
+	failures := 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if WebhookSignature("s3cr3t", r.Header.Get(WEBHOOK_TIMESTAMP_HEADER), body) != r.Header.Get(WEBHOOK_SIGNATURE_HEADER) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		bodies = append(bodies, body)
+		signatures = append(signatures, r.Header.Get(WEBHOOK_DELIVERY_HEADER))
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	deadLetters := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	webhooks := NewWebhooksFromConfig(&WebhooksConfig{
+		Endpoints:      []WebhookConfig{{URL: srv.URL, Secret: "s3cr3t", Events: []string{WEBHOOK_BACKEND_FAILURE}}},
+		MaxAttempts:    3,
+		BackoffMs:      1,
+		DeadLetterFile: deadLetters,
+	}, "mainnet", time.Now, logging.Logger("test"))
+	endpoint := webhooks.endpoints[0]
+
+	// Retried until delivered, with the same id
+	webhooks.Report(ErrorEvent{Message: "Storage failures", Err: errors.New("timeout"), Tags: map[string]string{"backend": "s3"}})
+	webhooks.Fire(WEBHOOK_WHITELIST_CHANGED, WebhookWhitelistChange{})
+	if len(endpoint.queue) != 1 {
+		t.Fatalf("expected only the subscribed event queued, got %d", len(endpoint.queue))
+	}
+	webhooks.deliver(context.Background(), endpoint, <-endpoint.queue)
+	var payload struct {
+		WebhookPayload
+		Data WebhookBackendFailure `json:"data"`
+	}
+	if len(bodies) != 3 || json.Unmarshal(bodies[2], &payload) != nil {
+		t.Fatalf("expected the event delivered on the third attempt, got %q", bodies)
+	}
+	if payload.Event != WEBHOOK_BACKEND_FAILURE || payload.Data.Error != "timeout" || payload.Data.Tags["backend"] != "s3" || signatures[0] != payload.Id || signatures[2] != payload.Id {
+		t.Fatalf("unexpected payload %s", bodies[2])
+	}
+
+	// Dead-lettered once attempts are exhausted
+	failures = 3
+	webhooks.Report(ErrorEvent{Message: "Storage failures"})
+	webhooks.deliver(context.Background(), endpoint, <-endpoint.queue)
+	bs, err := os.ReadFile(deadLetters)
+	var letter WebhookDeadLetter
+	if err != nil || json.Unmarshal(bs, &letter) != nil {
+		t.Fatalf("expected a dead letter, got %s, error: %v", bs, err)
+	}
+	if letter.URL != srv.URL || letter.Attempts != 3 || !strings.Contains(letter.Error, "503") || !strings.Contains(string(letter.Payload), `"message":"Storage failures"`) {
+		t.Fatalf("unexpected dead letter %s", bs)
+	}
+
+	// Requests rejected by the endpoint aren't retried
+	endpoint.secret = "wrong"
+	webhooks.Report(ErrorEvent{Message: "Storage failures"})
+	webhooks.deliver(context.Background(), endpoint, <-endpoint.queue)
+	if bs, _ := os.ReadFile(deadLetters); strings.Count(string(bs), "\n") != 2 || !strings.Contains(string(bs), `"attempts":1`) {
+		t.Fatalf("expected the rejected delivery dead-lettered, got %s", bs)
+	}
+}
+
+func TestWebhookWhitelistChanged(t *testing.T) {
+	var pk1, pk2, pk3 Pk
+	pk1[0], pk2[0], pk3[0] = 1, 2, 3
+	webhooks := NewWebhooksFromConfig(&WebhooksConfig{Endpoints: []WebhookConfig{{URL: "http://localhost"}}}, "mainnet", time.Now, logging.Logger("test"))
+	endpoint := webhooks.endpoints[0]
+	webhooks.WhitelistChanged(Whitelist{pk1: nil, pk2: nil}, Whitelist{pk2: nil, pk1: nil})
+	if len(endpoint.queue) != 0 {
+		t.Fatalf("expected no event of an unchanged whitelist")
+	}
+	webhooks.WhitelistChanged(Whitelist{pk1: nil, pk2: nil}, Whitelist{pk2: nil, pk3: nil})
+	var payload struct {
+		Data WebhookWhitelistChange `json:"data"`
+	}
+	json.Unmarshal(<-endpoint.queue, &payload)
+	expected := WebhookWhitelistChange{Added: []string{pk3.String()}, Removed: []string{pk1.String()}, Size: 2}
+	if !reflect.DeepEqual(payload.Data, expected) {
+		t.Fatalf("unexpected change %+v, expected %+v", payload.Data, expected)
+	}
+}
+
+func TestSubmitFiresWebhooks(t *testing.T) {
+	var req submitRequest
+	body := readTestFile("req-with-snark", t)
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	_, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	stats, _ := NewSubmitterStats("", tm.Now)
+	sh.app.SubmitterStats = stats
+	webhooks := NewWebhooksFromConfig(&WebhooksConfig{Endpoints: []WebhookConfig{{URL: "http://localhost"}}}, "mainnet", tm.Now, logging.Logger("test"))
+	sh.app.Webhooks = webhooks
+	queue := webhooks.endpoints[0].queue
+	events := func() (events []string) {
+		for len(queue) > 0 {
+			var payload WebhookPayload
+			json.Unmarshal(<-queue, &payload)
+			events = append(events, payload.Event)
+		}
+		return events
+	}
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected the submission accepted, got %d: %s", rep.Code, rep.Body)
+	}
+	if fired := events(); !reflect.DeepEqual(fired, []string{WEBHOOK_SUBMITTER_FIRST_SEEN, WEBHOOK_SUBMISSION_ACCEPTED}) {
+		t.Fatalf("unexpected events of the first submission %v", fired)
+	}
+	tm.time = tm.time.Add(time.Hour)
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected the submission accepted, got %d: %s", rep.Code, rep.Body)
+	}
+	if fired := events(); !reflect.DeepEqual(fired, []string{WEBHOOK_SUBMISSION_ACCEPTED}) {
+		t.Fatalf("unexpected events of the second submission %v", fired)
+	}
+}
+
+func TestWebhooksConfig(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	mockLogger := &MockLogger{}
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"network_name": "mainnet", "delegation_whitelist_disabled": true, "filesystem": {"path": "/tmp"},
+		"webhooks": {"endpoints": [{"url": "https://scoring.example.com/hook", "events": ["submission_accepted"]}], "max_attempts": 3}}`), 0644)
+
+	os.Setenv("WEBHOOK_URL", "https://analytics.example.com/hook")
+	os.Setenv("WEBHOOK_SECRET", "s3cr3t")
+	config := LoadConfig(path, mockLogger)
+	if mockLogger.lastMessage != "" {
+		t.Fatalf("Unexpected fatal error: %s", mockLogger.lastMessage)
+	}
+	if endpoints := config.Webhooks.Endpoints; len(endpoints) != 2 || endpoints[1].Secret != "s3cr3t" || config.Webhooks.MaxAttempts != 3 {
+		t.Fatalf("Expected both endpoints, got %+v", config.Webhooks)
+	}
+
+	os.Setenv("WEBHOOK_EVENTS", "submitter_first_seen")
+	LoadConfig(path, mockLogger)
+	if !strings.Contains(mockLogger.lastMessage, "submitter_first_seen requires submitter_stats") {
+		t.Errorf("Expected first-seen events rejected without statistics, got: %s", mockLogger.lastMessage)
+	}
+}
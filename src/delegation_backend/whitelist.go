@@ -1,19 +1,46 @@
 package delegation_backend
 
-import "sync"
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
 
 type unit = interface{}
 type Whitelist map[Pk]unit
 
+// Hourly limits of the keys read along with the whitelist, the
+// value of the entry of a key with a limit
+func (wl Whitelist) RateLimits() map[Pk]int {
+	limits := make(map[Pk]int)
+	for pk, v := range wl {
+		if limit, ok := v.(int); ok {
+			limits[pk] = limit
+		}
+	}
+	return limits
+}
+
 type WhitelistMVar struct {
 	whitelistMutex sync.RWMutex
 	whitelistSet   *Whitelist
+	replacedAt     time.Time
 }
 
 func (mvar *WhitelistMVar) Replace(wl *Whitelist) {
 	mvar.whitelistMutex.Lock()
 	defer mvar.whitelistMutex.Unlock()
 	mvar.whitelistSet = wl
+	mvar.replacedAt = time.Now()
+}
+
+// Time the whitelist was last replaced, zero if it never was
+func (mvar *WhitelistMVar) ReplacedAt() time.Time {
+	mvar.whitelistMutex.RLock()
+	defer mvar.whitelistMutex.RUnlock()
+	return mvar.replacedAt
 }
 
 func (mvar *WhitelistMVar) ReadWhitelist() (wl *Whitelist) {
@@ -21,3 +48,89 @@ func (mvar *WhitelistMVar) ReadWhitelist() (wl *Whitelist) {
 	defer mvar.whitelistMutex.RUnlock()
 	return mvar.whitelistSet
 }
+
+// Replace the whitelist of the app, along with the hourly limits of its keys
+func (app *App) ReplaceWhitelist(wl Whitelist) {
+	app.Whitelist.Replace(&wl)
+	app.SubmitCounter.SetWhitelistOverrides(wl.RateLimits())
+}
+
+// PostgreSQLWhitelistStore shares the whitelist retrieved by the leader
+// with the other replicas, in the `delegation_whitelist` table.
+type PostgreSQLWhitelistStore struct {
+	DB *sql.DB
+}
+
+func (store *PostgreSQLWhitelistStore) CreateTableIfNotExists() error {
+	_, err := store.DB.Exec(`CREATE TABLE IF NOT EXISTS delegation_whitelist (
+				id INT PRIMARY KEY,
+				public_keys JSONB NOT NULL,
+				updated_at TIMESTAMPTZ NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	// Hourly limits of the keys, added after the table
+	_, err = store.DB.Exec(`ALTER TABLE delegation_whitelist ADD COLUMN IF NOT EXISTS rate_limits JSONB`)
+	return err
+}
+
+func (store *PostgreSQLWhitelistStore) Save(wl Whitelist, updatedAt time.Time) error {
+	pks := make([]Pk, 0, len(wl))
+	for pk := range wl {
+		pks = append(pks, pk)
+	}
+	bs, err := json.Marshal(pks)
+	if err != nil {
+		return err
+	}
+	limits := make(map[string]int)
+	for pk, limit := range wl.RateLimits() {
+		limits[pk.String()] = limit
+	}
+	limitsBs, err := json.Marshal(limits)
+	if err != nil {
+		return err
+	}
+	_, err = store.DB.Exec(`INSERT INTO delegation_whitelist (id, public_keys, rate_limits, updated_at) VALUES (1, $1, $2, $3)
+			ON CONFLICT (id) DO UPDATE SET public_keys = EXCLUDED.public_keys, rate_limits = EXCLUDED.rate_limits, updated_at = EXCLUDED.updated_at`,
+		bs, limitsBs, updatedAt)
+	return err
+}
+
+// Load returns the shared whitelist and the time it was retrieved
+func (store *PostgreSQLWhitelistStore) Load() (Whitelist, time.Time, error) {
+	var bs, limitsBs []byte
+	var updatedAt time.Time
+	err := store.DB.QueryRow(`SELECT public_keys, rate_limits, updated_at FROM delegation_whitelist WHERE id = 1`).Scan(&bs, &limitsBs, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, errors.New("no whitelist shared by the leader yet")
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var pks []Pk
+	if err := json.Unmarshal(bs, &pks); err != nil {
+		return nil, time.Time{}, err
+	}
+	wl := make(Whitelist, len(pks))
+	for _, pk := range pks {
+		wl[pk] = true
+	}
+	// Not set by leaders saving the whitelist before rate limits were shared
+	if limitsBs != nil {
+		var limits map[string]int
+		if err := json.Unmarshal(limitsBs, &limits); err != nil {
+			return nil, time.Time{}, err
+		}
+		for pkStr, limit := range limits {
+			var pk Pk
+			if err := StringToPk(&pk, pkStr); err != nil {
+				return nil, time.Time{}, err
+			}
+			if _, exists := wl[pk]; exists {
+				wl[pk] = limit
+			}
+		}
+	}
+	return wl, updatedAt, nil
+}
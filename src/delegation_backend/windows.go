@@ -0,0 +1,96 @@
+package delegation_backend
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// What is done with submissions to a window already credited to their
+// submitter
+const (
+	// Rejected with 409 Conflict
+	SUBMISSION_WINDOW_REJECT = "reject"
+	// Responded to with status `duplicate` without being saved
+	SUBMISSION_WINDOW_DEDUPLICATE = "deduplicate"
+)
+
+// Slots of a submission window by default, 15 minutes of mainnet slots
+const SUBMISSION_WINDOW_DEFAULT_SLOTS = 5
+
+// SubmissionWindows credits each submitter at most once per window of
+// global slots, aligned to the genesis of the network. Windows before the
+// latest window credited to a submitter aren't credited either.
+type SubmissionWindows struct {
+	Slots int
+	Mode  string
+	// Timing of the network, windows start at its slots
+	timing   *NetworkTiming
+	mutex    sync.Mutex
+	credited map[Pk]int
+	// Optional, windows are credited across replicas
+	shared SharedState
+}
+
+func NewSubmissionWindows(config *SubmissionWindowConfig, timing *NetworkTiming) (*SubmissionWindows, error) {
+	w := &SubmissionWindows{Slots: config.Slots, Mode: config.Mode, timing: timing, credited: make(map[Pk]int)}
+	if w.Slots == 0 {
+		w.Slots = SUBMISSION_WINDOW_DEFAULT_SLOTS
+	} else if w.Slots < 0 {
+		return nil, fmt.Errorf("slots is negative: %d", config.Slots)
+	}
+	switch w.Mode {
+	case "":
+		w.Mode = SUBMISSION_WINDOW_REJECT
+	case SUBMISSION_WINDOW_REJECT, SUBMISSION_WINDOW_DEDUPLICATE:
+	default:
+		return nil, fmt.Errorf("unknown mode %q, expected %s or %s", config.Mode, SUBMISSION_WINDOW_REJECT, SUBMISSION_WINDOW_DEDUPLICATE)
+	}
+	return w, nil
+}
+
+// Credit windows across replicas. Windows are credited in memory while
+// the shared state fails.
+func (w *SubmissionWindows) SetSharedState(shared SharedState) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.shared = shared
+}
+
+// Window of the global slot
+func (w *SubmissionWindows) Window(slot int) int {
+	return slot / w.Slots
+}
+
+// Start of the window, the time recorded in the shared state
+func (w *SubmissionWindows) start(window int) time.Time {
+	return w.timing.genesis.Add(time.Duration(window*w.Slots) * w.timing.slotDuration)
+}
+
+// Credit records the window as credited to pk. Returns `false` (and
+// records nothing) if the window or a later one was already credited.
+func (w *SubmissionWindows) Credit(pk Pk, window int) bool {
+	w.mutex.Lock()
+	shared := w.shared
+	w.mutex.Unlock()
+	if shared != nil {
+		credited, err := shared.AdvanceTo("window:"+pk.String(), w.start(window))
+		if err == nil {
+			if credited {
+				w.mutex.Lock()
+				w.credited[pk] = window
+				w.mutex.Unlock()
+			}
+			return credited
+		}
+		incMetric("shared_state_errors")
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if latest, exists := w.credited[pk]; exists && window <= latest {
+		return false
+	}
+	w.credited[pk] = window
+	return true
+}
@@ -0,0 +1,81 @@
+package delegation_backend
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSubmissionWindowsCredit(t *testing.T) {
+	timing, err := NewNetworkTiming(&NetworkTimingConfig{GenesisTimestamp: mainnetGenesisTimestamp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	windows, err := NewSubmissionWindows(&SubmissionWindowConfig{}, timing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if windows.Window(59013) != 11802 || windows.Window(59015) != 11803 {
+		t.Fatalf("unexpected windows of slots 59013 and 59015: %d, %d", windows.Window(59013), windows.Window(59015))
+	}
+	for _, shared := range []SharedState{nil, newMemorySharedState()} {
+		windows.SetSharedState(shared)
+		pk := Pk{1}
+		for i, c := range []struct {
+			window   int
+			credited bool
+		}{{10, true}, {10, false}, {9, false}, {11, true}} {
+			if windows.Credit(pk, c.window) != c.credited {
+				t.Errorf("%d: expected window %d credited: %v", i, c.window, c.credited)
+			}
+		}
+		windows.credited = make(map[Pk]int)
+	}
+
+	for _, config := range []SubmissionWindowConfig{{Slots: -1}, {Mode: "drop"}} {
+		if _, err := NewSubmissionWindows(&config, timing); err == nil {
+			t.Errorf("expected %+v rejected", config)
+		}
+	}
+}
+
+func TestSubmitSubmissionWindow(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	storage, sh, tm := testSubmitH(1, Whitelist{req.Submitter: true})
+	tm.time = req.Data.CreatedAt
+	timing, err := NewNetworkTiming(&NetworkTimingConfig{GenesisTimestamp: mainnetGenesisTimestamp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	windows, err := NewSubmissionWindows(&SubmissionWindowConfig{}, timing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.app.NetworkTiming = timing
+	sh.app.SubmissionWindows = windows
+
+	rep := sh.testRequest(body)
+	var resp submitResponse
+	if err := json.Unmarshal(rep.Body.Bytes(), &resp); rep.Code != 200 || err != nil || resp.WindowId == nil || *resp.WindowId != 11802 {
+		t.Fatalf("expected the submission credited to window 11802, got %d: %s", rep.Code, rep.Body)
+	}
+	if meta := testSavedMeta(storage, t); meta.WindowId == nil || *meta.WindowId != 11802 {
+		t.Fatalf("expected window 11802 recorded, got %v", meta.WindowId)
+	}
+
+	// Past the rate limit of the submitter, in the same window
+	tm.time = tm.time.Add(2 * time.Hour)
+	if rep := sh.testRequest(body); rep.Code != 409 || len(*storage) != 0 {
+		t.Fatalf("expected the submission to a credited window rejected, got %d", rep.Code)
+	}
+	windows.Mode = SUBMISSION_WINDOW_DEDUPLICATE
+	tm.time = tm.time.Add(2 * time.Hour)
+	rep = sh.testRequest(body)
+	if err := json.Unmarshal(rep.Body.Bytes(), &resp); rep.Code != 200 || err != nil || resp.Status != "duplicate" || len(*storage) != 0 {
+		t.Fatalf("expected the submission to a credited window deduplicated, got %d: %s", rep.Code, rep.Body)
+	}
+}
@@ -0,0 +1,118 @@
+package delegation_backend
+
+import (
+	"sync"
+	"time"
+)
+
+// Defaults of write batching
+const (
+	WRITE_BATCH_DEFAULT_SIZE     = 100
+	WRITE_BATCH_DEFAULT_INTERVAL = 50 * time.Millisecond
+)
+
+// Statements of an AWS Keyspaces batch are limited to 30
+const KEYSPACES_MAX_BATCH_SIZE = 30
+
+// WriteBatcher groups submissions saved concurrently into batched writes. A
+// batch is written once it holds size submissions, or interval after its
+// first submission was added. Save blocks until the batch holding the
+// submission is written, so that the outcome of the write is known to
+// the submitter as without batching.
+type WriteBatcher struct {
+	mutex    sync.Mutex
+	size     int
+	interval time.Duration
+	write    func([]*Submission) error
+	pending  *writeBatch
+	closed   bool
+	// Batches being written
+	writing sync.WaitGroup
+}
+
+type writeBatch struct {
+	submissions []*Submission
+	errs        []error
+	timer       *time.Timer
+	done        chan struct{}
+}
+
+func NewWriteBatcher(size int, interval time.Duration, write func([]*Submission) error) *WriteBatcher {
+	return &WriteBatcher{size: size, interval: interval, write: write}
+}
+
+// Save adds the submission to the pending batch and waits for the batch to
+// be written. Once the batcher is closed submissions are written one by one.
+func (b *WriteBatcher) Save(submission *Submission) error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return b.write([]*Submission{submission})
+	}
+	batch := b.pending
+	if batch == nil {
+		batch = &writeBatch{done: make(chan struct{})}
+		batch.timer = time.AfterFunc(b.interval, func() { b.flush(batch) })
+		b.pending = batch
+	}
+	i := len(batch.submissions)
+	batch.submissions = append(batch.submissions, submission)
+	full := len(batch.submissions) >= b.size
+	b.mutex.Unlock()
+	if full {
+		b.flush(batch)
+	}
+	<-batch.done
+	return batch.errs[i]
+}
+
+// Write the batch unless it is already being written
+func (b *WriteBatcher) flush(batch *writeBatch) {
+	b.mutex.Lock()
+	if b.pending != batch {
+		b.mutex.Unlock()
+		return
+	}
+	b.pending = nil
+	b.writing.Add(1)
+	b.mutex.Unlock()
+	defer b.writing.Done()
+	batch.timer.Stop()
+
+	batch.errs = make([]error, len(batch.submissions))
+	incMetric("write_batches")
+	if err := b.write(batch.submissions); err != nil && len(batch.submissions) > 1 {
+		// A single faulty submission fails the whole batch, the
+		// submissions are written one by one to isolate it
+		incMetric("write_batch_failures")
+		for i, submission := range batch.submissions {
+			batch.errs[i] = b.write([]*Submission{submission})
+		}
+	} else {
+		for i := range batch.errs {
+			batch.errs[i] = err
+		}
+	}
+	close(batch.done)
+}
+
+// Flush writes the pending batch and waits for batches being written,
+// submissions saved afterwards are batched again
+func (b *WriteBatcher) Flush() {
+	b.mutex.Lock()
+	batch := b.pending
+	b.mutex.Unlock()
+	if batch != nil {
+		b.flush(batch)
+	}
+	b.writing.Wait()
+}
+
+// Close writes the pending batch and waits for batches being written,
+// submissions saved afterwards are written one by one.
+func (b *WriteBatcher) Close() {
+	b.mutex.Lock()
+	b.closed = true
+	b.mutex.Unlock()
+	b.Flush()
+}
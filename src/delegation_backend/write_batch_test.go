@@ -0,0 +1,110 @@
+package delegation_backend
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type batchWriter struct {
+	mutex   sync.Mutex
+	batches [][]*Submission
+	// Submissions failing the batches holding them
+	faulty map[string]bool
+}
+
+func (w *batchWriter) write(submissions []*Submission) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.batches = append(w.batches, submissions)
+	for _, submission := range submissions {
+		if w.faulty[submission.Submitter] {
+			return errors.New("invalid submission")
+		}
+	}
+	return nil
+}
+
+func saveConcurrently(b *WriteBatcher, submitters ...string) []error {
+	errs := make([]error, len(submitters))
+	var wg sync.WaitGroup
+	for i, submitter := range submitters {
+		wg.Add(1)
+		go func(i int, submitter string) {
+			defer wg.Done()
+			errs[i] = b.Save(&Submission{Submitter: submitter})
+		}(i, submitter)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestWriteBatcherSizeTrigger(t *testing.T) {
+	w := &batchWriter{}
+	// The interval is never reached, batches are written once full
+	b := NewWriteBatcher(2, time.Hour, w.write)
+	for _, err := range saveConcurrently(b, "a", "b", "c", "d") {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(w.batches) != 2 || len(w.batches[0]) != 2 || len(w.batches[1]) != 2 {
+		t.Fatalf("expected two batches of two submissions, got %d batches", len(w.batches))
+	}
+}
+
+func TestWriteBatcherIntervalTrigger(t *testing.T) {
+	w := &batchWriter{}
+	b := NewWriteBatcher(100, 10*time.Millisecond, w.write)
+	if err := b.Save(&Submission{Submitter: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.batches) != 1 || len(w.batches[0]) != 1 {
+		t.Fatalf("expected the partial batch written, got %d batches", len(w.batches))
+	}
+}
+
+func TestWriteBatcherIsolatesFaultySubmission(t *testing.T) {
+	w := &batchWriter{faulty: map[string]bool{"b": true}}
+	b := NewWriteBatcher(3, time.Hour, w.write)
+	errs := saveConcurrently(b, "a", "b", "c")
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("expected only the faulty submission to fail, got %d failures", failed)
+	}
+	// The failed batch, then each submission on its own
+	if len(w.batches) != 4 {
+		t.Fatalf("expected the batch retried submission by submission, got %d writes", len(w.batches))
+	}
+}
+
+func TestWriteBatcherClose(t *testing.T) {
+	w := &batchWriter{}
+	b := NewWriteBatcher(100, time.Hour, w.write)
+	saved := make(chan error)
+	go func() { saved <- b.Save(&Submission{Submitter: "a"}) }()
+	// Wait for the submission to be pending
+	for {
+		b.mutex.Lock()
+		pending := b.pending != nil
+		b.mutex.Unlock()
+		if pending {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	b.Close()
+	if err := <-saved; err != nil || len(w.batches) != 1 {
+		t.Fatalf("expected the pending batch flushed on close, error: %v", err)
+	}
+	// Once closed submissions are written right away
+	if err := b.Save(&Submission{Submitter: "b"}); err != nil || len(w.batches) != 2 {
+		t.Fatalf("expected the submission written on its own, error: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package delegation_backend
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Outcomes of the most recent submissions kept in memory,
+// when no database is configured
+const WRITE_OUTCOMES_MEMORY_MAX = 100000
+
+const (
+	WRITE_OUTCOMES_DEFAULT_LIMIT = 100
+	WRITE_OUTCOMES_MAX_LIMIT     = 1000
+)
+
+// ObjectVersion identifies an object as written to a storage backend
+type ObjectVersion struct {
+	ETag      string `json:"etag,omitempty"`
+	VersionId string `json:"version_id,omitempty"`
+	// The object existed already and was not written again
+	Existing bool `json:"existing,omitempty"`
+}
+
+// BackendWrite is the outcome of saving a submission to a storage backend
+type BackendWrite struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// Objects by path, for backends reporting versions of written objects
+	Objects map[string]ObjectVersion `json:"objects,omitempty"`
+}
+
+// WriteOutcome records whether a submission was written to each
+// storage backend, so that a block missing from a backend can be told
+// apart from a block never submitted.
+type WriteOutcome struct {
+	// Path of the submission metadata, as in receipts
+	SubmissionId string                  `json:"submission_id"`
+	Submitter    string                  `json:"submitter"`
+	BlockHash    string                  `json:"block_hash"`
+	SubmittedAt  time.Time               `json:"submitted_at"`
+	Backends     map[string]BackendWrite `json:"backends"`
+	mutex        sync.Mutex
+}
+
+type writeOutcomeContextKey struct{}
+
+func withWriteOutcome(ctx context.Context, outcome *WriteOutcome) context.Context {
+	return context.WithValue(ctx, writeOutcomeContextKey{}, outcome)
+}
+
+// ObjectVersionRecorder returns the function recording versions of objects
+// written to the backend into the write outcome of the context, nil if
+// the context has no write outcome.
+func ObjectVersionRecorder(ctx context.Context, backend string) func(path string, version ObjectVersion) {
+	outcome, ok := ctx.Value(writeOutcomeContextKey{}).(*WriteOutcome)
+	if !ok {
+		return nil
+	}
+	return func(path string, version ObjectVersion) {
+		outcome.mutex.Lock()
+		defer outcome.mutex.Unlock()
+		write := outcome.Backends[backend]
+		if write.Objects == nil {
+			write.Objects = make(map[string]ObjectVersion)
+		}
+		write.Objects[path] = version
+		outcome.Backends[backend] = write
+	}
+}
+
+// Set the success or failure of every backend saved to
+func (o *WriteOutcome) setStorageOutcomes(outcomes StorageOutcomes) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for backend, err := range outcomes {
+		write := o.Backends[backend]
+		write.Ok = err == nil
+		if err != nil {
+			write.Error = err.Error()
+		}
+		o.Backends[backend] = write
+	}
+}
+
+// WriteOutcomeQuery selects outcomes by any of the set fields
+type WriteOutcomeQuery struct {
+	SubmissionId string
+	Submitter    string
+	BlockHash    string
+	Limit        int
+}
+
+func (q *WriteOutcomeQuery) matches(o *WriteOutcome) bool {
+	return (q.SubmissionId == "" || q.SubmissionId == o.SubmissionId) &&
+		(q.Submitter == "" || q.Submitter == o.Submitter) &&
+		(q.BlockHash == "" || q.BlockHash == o.BlockHash)
+}
+
+type WriteOutcomeStore interface {
+	Insert(outcome *WriteOutcome) error
+	// Returns outcomes matching the query, the newest first
+	Query(q WriteOutcomeQuery) ([]*WriteOutcome, error)
+}
+
+// MemoryWriteOutcomeStore keeps outcomes of the most recent submissions,
+// used when no database is configured.
+type MemoryWriteOutcomeStore struct {
+	mutex    sync.RWMutex
+	outcomes []*WriteOutcome
+	max      int
+}
+
+func NewMemoryWriteOutcomeStore(max int) *MemoryWriteOutcomeStore {
+	return &MemoryWriteOutcomeStore{max: max}
+}
+
+func (store *MemoryWriteOutcomeStore) Insert(outcome *WriteOutcome) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if len(store.outcomes) >= store.max {
+		store.outcomes = append(store.outcomes[:0], store.outcomes[len(store.outcomes)-store.max+1:]...)
+	}
+	store.outcomes = append(store.outcomes, outcome)
+	return nil
+}
+
+func (store *MemoryWriteOutcomeStore) Query(q WriteOutcomeQuery) ([]*WriteOutcome, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	res := []*WriteOutcome{}
+	for i := len(store.outcomes) - 1; i >= 0 && len(res) < q.Limit; i-- {
+		if q.matches(store.outcomes[i]) {
+			res = append(res, store.outcomes[i])
+		}
+	}
+	return res, nil
+}
+
+// PostgreSQLWriteOutcomeStore keeps outcomes in the `storage_write_outcomes` table.
+type PostgreSQLWriteOutcomeStore struct {
+	DB *sql.DB
+}
+
+func (store *PostgreSQLWriteOutcomeStore) CreateTableIfNotExists() error {
+	_, err := store.DB.Exec(`CREATE TABLE IF NOT EXISTS storage_write_outcomes (
+				submission_id TEXT PRIMARY KEY,
+				submitter TEXT NOT NULL,
+				block_hash TEXT NOT NULL,
+				submitted_at TIMESTAMPTZ NOT NULL,
+				backends JSONB NOT NULL);
+			CREATE INDEX IF NOT EXISTS storage_write_outcomes_submitter ON storage_write_outcomes (submitter, submitted_at);
+			CREATE INDEX IF NOT EXISTS storage_write_outcomes_block_hash ON storage_write_outcomes (block_hash)`)
+	return err
+}
+
+func (store *PostgreSQLWriteOutcomeStore) Insert(outcome *WriteOutcome) error {
+	backends, err := json.Marshal(outcome.Backends)
+	if err != nil {
+		return err
+	}
+	_, err = store.DB.Exec(`INSERT INTO storage_write_outcomes (submission_id, submitter, block_hash, submitted_at, backends)
+			VALUES ($1, $2, $3, $4, $5) ON CONFLICT (submission_id) DO UPDATE SET backends = EXCLUDED.backends`,
+		outcome.SubmissionId, outcome.Submitter, outcome.BlockHash, outcome.SubmittedAt, backends)
+	return err
+}
+
+func (store *PostgreSQLWriteOutcomeStore) Query(q WriteOutcomeQuery) ([]*WriteOutcome, error) {
+	rows, err := store.DB.Query(`SELECT submission_id, submitter, block_hash, submitted_at, backends FROM storage_write_outcomes
+			WHERE ($1 = '' OR submission_id = $1) AND ($2 = '' OR submitter = $2) AND ($3 = '' OR block_hash = $3)
+			ORDER BY submitted_at DESC LIMIT $4`, q.SubmissionId, q.Submitter, q.BlockHash, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []*WriteOutcome{}
+	for rows.Next() {
+		var outcome WriteOutcome
+		var backends []byte
+		if err := rows.Scan(&outcome.SubmissionId, &outcome.Submitter, &outcome.BlockHash, &outcome.SubmittedAt, &backends); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(backends, &outcome.Backends); err != nil {
+			return nil, err
+		}
+		res = append(res, &outcome)
+	}
+	return res, rows.Err()
+}
+
+// WriteOutcomesHandler serves outcomes at /v1/storage-outcomes, selected
+// by `?submission_id=`, `?submitter=` or `?block_hash=`.
+func WriteOutcomesHandler(store WriteOutcomeStore) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{"Method not allowed"})
+			return
+		}
+		query := r.URL.Query()
+		q := WriteOutcomeQuery{
+			SubmissionId: query.Get("submission_id"),
+			Submitter:    query.Get("submitter"),
+			BlockHash:    query.Get("block_hash"),
+			Limit:        WRITE_OUTCOMES_DEFAULT_LIMIT,
+		}
+		if q.SubmissionId == "" && q.Submitter == "" && q.BlockHash == "" {
+			writeJSON(rw, http.StatusBadRequest, errorResponse{"One of submission_id, submitter or block_hash is required"})
+			return
+		}
+		if limitStr := query.Get("limit"); limitStr != "" {
+			limit, err := strconv.Atoi(limitStr)
+			if err != nil || limit <= 0 || limit > WRITE_OUTCOMES_MAX_LIMIT {
+				writeJSON(rw, http.StatusBadRequest, errorResponse{"Invalid limit"})
+				return
+			}
+			q.Limit = limit
+		}
+		outcomes, err := store.Query(q)
+		if err != nil {
+			writeJSON(rw, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		writeJSON(rw, http.StatusOK, map[string][]*WriteOutcome{"outcomes": outcomes})
+	})
+}
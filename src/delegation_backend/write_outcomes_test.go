@@ -0,0 +1,71 @@
+package delegation_backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSubmitWriteOutcomes(t *testing.T) {
+	body := readTestFile("req-with-snark", t)
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal("failed decoding test file")
+	}
+	_, sh, tm := testSubmitH(10, Whitelist{req.Submitter: true})
+	store := NewMemoryWriteOutcomeStore(10)
+	sh.app.WriteOutcomes = store
+	save := sh.app.Save
+	sh.app.Save = func(ctx context.Context, objs ObjectsToSave) StorageOutcomes {
+		save(ctx, objs)
+		record := ObjectVersionRecorder(ctx, "s3")
+		for path := range objs {
+			record(path, ObjectVersion{ETag: `"etag"`, VersionId: "v1"})
+		}
+		return StorageOutcomes{"s3": nil, "postgresql": errors.New("connection refused")}
+	}
+
+	if rep := sh.testRequest(body); rep.Code != 200 {
+		t.Fatalf("expected submission accepted, got %v", rep)
+	}
+	paths := makePaths(tm.Now(), req.GetBlockDataHash(), req.Submitter)
+	outcomes, _ := store.Query(WriteOutcomeQuery{BlockHash: req.GetBlockDataHash(), Limit: 10})
+	if len(outcomes) != 1 || outcomes[0].SubmissionId != paths.Meta || outcomes[0].Submitter != req.Submitter.String() {
+		t.Fatalf("unexpected outcomes: %+v", outcomes)
+	}
+	s3Write, pgWrite := outcomes[0].Backends["s3"], outcomes[0].Backends["postgresql"]
+	if !s3Write.Ok || s3Write.Objects[paths.Block] != (ObjectVersion{ETag: `"etag"`, VersionId: "v1"}) || len(s3Write.Objects) != 2 {
+		t.Fatalf("unexpected s3 outcome: %+v", s3Write)
+	}
+	if pgWrite.Ok || pgWrite.Error != "connection refused" || pgWrite.Objects != nil {
+		t.Fatalf("unexpected postgresql outcome: %+v", pgWrite)
+	}
+
+	rr := httptest.NewRecorder()
+	WriteOutcomesHandler(store).ServeHTTP(rr, httptest.NewRequest("GET", "/v1/storage-outcomes?submitter="+req.Submitter.String(), nil))
+	var resp map[string][]WriteOutcome
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || len(resp["outcomes"]) != 1 {
+		t.Fatalf("unexpected response: %s", rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	WriteOutcomesHandler(store).ServeHTTP(rr, httptest.NewRequest("GET", "/v1/storage-outcomes", nil))
+	if rr.Code != 400 {
+		t.Fatalf("expected query without filter rejected, got %d", rr.Code)
+	}
+}
+
+func TestMemoryWriteOutcomeStore(t *testing.T) {
+	store := NewMemoryWriteOutcomeStore(3)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		_ = store.Insert(&WriteOutcome{SubmissionId: id, Submitter: "B62q"})
+	}
+	outcomes, _ := store.Query(WriteOutcomeQuery{Submitter: "B62q", Limit: 2})
+	if len(outcomes) != 2 || outcomes[0].SubmissionId != "d" || outcomes[1].SubmissionId != "c" {
+		t.Fatalf("unexpected outcomes: %+v", outcomes)
+	}
+	if outcomes, _ := store.Query(WriteOutcomeQuery{SubmissionId: "a", Limit: 10}); len(outcomes) != 0 {
+		t.Fatalf("expected the oldest outcome dropped, got %+v", outcomes)
+	}
+}
@@ -35,75 +35,56 @@ func IsIdentityInArray(id string, identities []Identity) bool {
 // Identity is constructed based on the payload that the BP sends which may hold pubkey, ip address and graphqlport
 func CreateIdentities(config AppConfig, ctx dg.AwsContext, log *logging.ZapEventLogger) []Identity {
 
-    day := config.Period.Start.Format("2006-01-02")
-
-    prefixCurrent := strings.Join([]string{ctx.Prefix, "submissions", day}, "/")
-
     var identities []Identity // Create an empty array of Identity types
 
     var submissionData dg.MetaToBeSaved
 
-    input := &s3.ListObjectsV2Input{
-        Bucket: ctx.BucketName,
-        Prefix: &prefixCurrent,
-    }
-
-    // Paginate through ListObjects results
-
-    paginator := s3.NewListObjectsV2Paginator(ctx.Client, input)
-    for paginator.HasMorePages() {
-        page, err := paginator.NextPage(ctx.Context)
+    for _, key := range SubmissionKeys(config, ctx, log, "") {
+        submissionTime, err := GetSubmissionTime(key)
         if err != nil {
-            log.Fatalf("Getting next page of paginator (BPU bucket): %v\n", err)
+            log.Fatalf("Error parsing time: %v\n", err)
         }
 
-        for _, obj := range page.Contents {
-            submissionTime, err := GetSubmissionTime(*obj.Key)
-            if err != nil {
-                log.Fatalf("Error parsing time: %v\n", err)
-            }
-
-            if (submissionTime.After(config.Period.Start)) && (submissionTime.Before(config.Period.End)) {
+        if (submissionTime.After(config.Period.Start)) && (submissionTime.Before(config.Period.End)) {
 
-                var identity Identity
+            var identity Identity
 
-                objHandle, err := ctx.Client.GetObject(ctx.Context, &s3.GetObjectInput{
-                    Bucket: ctx.BucketName,
-                    Key:    obj.Key,
-                })
+            objHandle, err := ctx.Client.GetObject(ctx.Context, &s3.GetObjectInput{
+                Bucket: ctx.BucketName,
+                Key:    &key,
+            })
 
-                if err != nil {
-                    log.Fatalf("Error getting object from bucket: %v\n", err)
-                }
+            if err != nil {
+                log.Fatalf("Error getting object from bucket: %v\n", err)
+            }
 
-                defer objHandle.Body.Close()
+            defer objHandle.Body.Close()
 
-                objContents, err := io.ReadAll(objHandle.Body)
-                if err != nil {
-                    log.Fatalf("Error getting creating reader for json: %v\n", err)
-                }
+            objContents, err := io.ReadAll(objHandle.Body)
+            if err != nil {
+                log.Fatalf("Error getting creating reader for json: %v\n", err)
+            }
 
-                err = json.Unmarshal(objContents, &submissionData)
-                if err != nil {
-                    log.Fatalf("Error unmarshaling bucket content: %v\n", err)
-                }
+            err = json.Unmarshal(objContents, &submissionData)
+            if err != nil {
+                log.Fatalf("Error unmarshaling bucket content: %v\n", err)
+            }
 
-                var remoteAddr string
-                if config.IgnoreIPs {
-                    remoteAddr = ""
-                } else {
-                    remoteAddr = submissionData.RemoteAddr
-                }
+            var remoteAddr string
+            if config.IgnoreIPs {
+                remoteAddr = ""
+            } else {
+                remoteAddr = submissionData.RemoteAddr
+            }
 
-                if (!config.IgnoreIPs && submissionData.GraphqlControlPort != 0) {
-                    identity = GetFullIdentity(submissionData.Submitter.String(), remoteAddr, strconv.Itoa(submissionData.GraphqlControlPort))
-                } else {
-                    identity = GetPartialIdentity(submissionData.Submitter.String(), remoteAddr)
-                }
+            if (!config.IgnoreIPs && submissionData.GraphqlControlPort != 0) {
+                identity = GetFullIdentity(submissionData.Submitter.String(), remoteAddr, strconv.Itoa(submissionData.GraphqlControlPort))
+            } else {
+                identity = GetPartialIdentity(submissionData.Submitter.String(), remoteAddr)
+            }
 
-                if !IsIdentityInArray(identity.id, identities) {
-                    identities = append(identities, identity)
-                }
+            if !IsIdentityInArray(identity.id, identities) {
+                identities = append(identities, identity)
             }
         }
     }
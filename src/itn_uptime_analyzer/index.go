@@ -0,0 +1,54 @@
+package itn_uptime_analyzer
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+	"strings"
+
+	dg "block_producers_uptime/delegation_backend"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// Returns the S3 keys of the submissions of the day of the period, sorted,
+// of the submitter only if not empty. Keys are taken from the index of the
+// day maintained by the backend if it was built after the end of the
+// period, otherwise the day is listed.
+func SubmissionKeys(config AppConfig, ctx dg.AwsContext, log *logging.ZapEventLogger, submitter string) []string {
+	day := config.Period.Start.Format("2006-01-02")
+	index, err := dg.ReadDayIndex(ctx.Context, &dg.S3Source{Aws: &ctx}, day)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warnf("Error reading index of %s, listing submissions: %v\n", day, err)
+	}
+	var keys []string
+	if err == nil && !index.IndexedAt.Before(config.Period.End) {
+		for pk := range index.Submitters {
+			if submitter != "" && pk != submitter {
+				continue
+			}
+			for _, path := range index.Paths(pk) {
+				keys = append(keys, ctx.Prefix+"/"+path)
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	prefix := strings.Join([]string{ctx.Prefix, "submissions", day}, "/")
+	paginator := s3.NewListObjectsV2Paginator(ctx.Client, &s3.ListObjectsV2Input{
+		Bucket: ctx.BucketName,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx.Context)
+		if err != nil {
+			log.Fatalf("Getting next page of paginator (BPU bucket): %v\n", err)
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, *obj.Key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,156 @@
+// Package metadata decodes the metadata of submissions saved by the
+// backend, `submissions/<date>/<time>-<submitter>.json` of object
+// storages, of every version of its schema.
+//
+// Metadata carries the version of its schema in `schema_version`, metadata
+// saved before versioning has none and is of version 0. Fields may be
+// added to a version, consumers must ignore fields they don't know.
+// Removing a field, or changing its type or meaning, bumps SCHEMA_VERSION
+// along with an upgrade from the previous version, so that metadata of
+// every version is decoded as of the current one.
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Version of the schema of metadata saved by this build
+const SCHEMA_VERSION = 1
+
+var ErrUnsupportedVersion = errors.New("unsupported schema version")
+
+// Meta of a submission, as of the current version of the schema
+type Meta struct {
+	SchemaVersion int    `json:"schema_version"`
+	CreatedAt     string `json:"created_at"`
+	PeerId        string `json:"peer_id"`
+	// Decoded from base64
+	SnarkWork  []byte `json:"snark_work,omitempty"`
+	RemoteAddr string `json:"remote_addr"`
+	// Base58check-encoded public key of the submitter
+	Submitter string `json:"submitter"`
+	// Base58check-encoded hash of the block, the name of the block in
+	// `blocks/`
+	BlockHash          string `json:"block_hash"`
+	GraphqlControlPort int    `json:"graphql_control_port,omitempty"`
+	BuiltWithCommitSha string `json:"built_with_commit_sha,omitempty"`
+	// Set for submissions made by a delegate on behalf of the submitter
+	Delegate     string `json:"delegate,omitempty"`
+	DelegationId string `json:"delegation_id,omitempty"`
+	// Build of the backend which saved the submission
+	BackendVersion string `json:"backend_version,omitempty"`
+	BackendCommit  string `json:"backend_commit,omitempty"`
+	// Base58check-encoded signature of the submission
+	Signature string `json:"signature,omitempty"`
+	// Version of the sign payload the signature is of, version 1 if not set
+	PayloadVersion int `json:"payload_version,omitempty"`
+	// Global slot and epoch of the submission, set if the backend knew the
+	// timing of the network
+	GlobalSlot *int `json:"global_slot,omitempty"`
+	Epoch      *int `json:"epoch,omitempty"`
+	// Submission window credited by the submission, set if the backend
+	// enforced submission windows
+	WindowId *int `json:"window_id,omitempty"`
+	// Base58check-encoded state hash of the block, as reported by the
+	// client
+	StateHash string `json:"state_hash,omitempty"`
+	// Reported by clients of /v2/submit
+	Telemetry *Telemetry `json:"telemetry,omitempty"`
+	// Set if the backend checked the node of the submitter
+	Liveness *Liveness `json:"liveness,omitempty"`
+	// Set if the clock of the backend had jumped, created_at wasn't
+	// checked against it
+	ClockSuspect bool `json:"clock_suspect,omitempty"`
+}
+
+// Telemetry of the node of the submitter, fields are unset when the node
+// doesn't report them
+type Telemetry struct {
+	PeerCount *int `json:"peer_count,omitempty"`
+	// Sync status of the daemon, e.g. SYNCED or CATCHUP
+	SyncStatus    string `json:"sync_status,omitempty"`
+	SnarkPoolSize *int   `json:"snark_pool_size,omitempty"`
+	DaemonVersion string `json:"daemon_version,omitempty"`
+}
+
+// Liveness of the node of the submitter, as queried by the backend in the
+// GraphQL API of the node at the time of submission
+type Liveness struct {
+	// synced, not_synced, unreachable or error
+	Status    string `json:"status"`
+	CheckedAt string `json:"checked_at"`
+	LatencyMs int64  `json:"latency_ms"`
+	// Sync status of the daemon, e.g. SYNCED or CATCHUP
+	SyncStatus                 string `json:"sync_status,omitempty"`
+	BlockchainLength           *int   `json:"blockchain_length,omitempty"`
+	HighestBlockLengthReceived *int   `json:"highest_block_length_received,omitempty"`
+	// Why the node couldn't be checked
+	Error string `json:"error,omitempty"`
+}
+
+// Upgrades of metadata of version i to version i+1, by i
+var upgrades = []func(fields map[string]json.RawMessage) error{
+	// Fields of version 0 are those of version 1, all of them added
+	// without breaking earlier consumers
+	func(fields map[string]json.RawMessage) error { return nil },
+}
+
+// Version of the schema of the metadata
+func Version(data []byte) (int, error) {
+	var versioned struct {
+		SchemaVersion *int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &versioned); err != nil {
+		return 0, fmt.Errorf("error unmarshaling metadata: %w", err)
+	}
+	if versioned.SchemaVersion == nil {
+		return 0, nil
+	}
+	if *versioned.SchemaVersion < 0 {
+		return 0, fmt.Errorf("%w %d", ErrUnsupportedVersion, *versioned.SchemaVersion)
+	}
+	return *versioned.SchemaVersion, nil
+}
+
+// Upgrade metadata of an earlier version to the current one, keeping the
+// fields the upgrades don't change. Metadata of the current version is
+// returned as is, metadata of a later version is rejected with
+// ErrUnsupportedVersion.
+func Upgrade(data []byte) ([]byte, error) {
+	version, err := Version(data)
+	if err != nil {
+		return nil, err
+	}
+	if version > SCHEMA_VERSION {
+		return nil, fmt.Errorf("%w %d, the latest supported is %d", ErrUnsupportedVersion, version, SCHEMA_VERSION)
+	}
+	if version == SCHEMA_VERSION {
+		return data, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("error unmarshaling metadata: %w", err)
+	}
+	for ; version < SCHEMA_VERSION; version++ {
+		if err := upgrades[version](fields); err != nil {
+			return nil, fmt.Errorf("error upgrading metadata of version %d: %w", version, err)
+		}
+	}
+	fields["schema_version"] = json.RawMessage(fmt.Sprint(SCHEMA_VERSION))
+	return json.Marshal(fields)
+}
+
+// Decode metadata of any supported version as of the current one
+func Decode(data []byte) (*Meta, error) {
+	upgraded, err := Upgrade(data)
+	if err != nil {
+		return nil, err
+	}
+	var meta Meta
+	if err := json.Unmarshal(upgraded, &meta); err != nil {
+		return nil, fmt.Errorf("error unmarshaling metadata: %w", err)
+	}
+	return &meta, nil
+}
@@ -0,0 +1,58 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// Metadata saved before versioning, with a field of a later build
+const unversioned = `{"created_at":"2024-05-01T10:00:00Z","peer_id":"12D3KooW","snark_work":"AQI=","remote_addr":"192.0.2.1:1234","submitter":"B62qkaKV3BLvLTf7nYXRehSaZAd36NWijt3MEmy2QgHRavboeRGtBMN","block_hash":"3NK1","extra":{"a":1}}`
+
+func TestDecode(t *testing.T) {
+	meta, err := Decode([]byte(unversioned))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.SchemaVersion != SCHEMA_VERSION || meta.BlockHash != "3NK1" || string(meta.SnarkWork) != "\x01\x02" || meta.RemoteAddr != "192.0.2.1:1234" {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+
+	current := `{"schema_version":1,"created_at":"2024-05-01T10:00:00Z","submitter":"B62q","block_hash":"3NK1","delegate":"B62qd","signature":"7mX"}`
+	meta, err = Decode([]byte(current))
+	if err != nil || meta.Delegate != "B62qd" || meta.Signature != "7mX" {
+		t.Fatalf("unexpected metadata %+v, error: %v", meta, err)
+	}
+
+	if _, err := Decode([]byte(`{"schema_version":2,"submitter":"B62q"}`)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected later versions rejected, got %v", err)
+	}
+	if _, err := Decode([]byte(`{"schema_version":-1}`)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected negative versions rejected, got %v", err)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	upgraded, err := Upgrade([]byte(unversioned))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, err := Version(upgraded); err != nil || version != SCHEMA_VERSION {
+		t.Fatalf("expected version %d, got %d (error: %v)", SCHEMA_VERSION, version, err)
+	}
+	// Fields are kept, unknown ones as well
+	var before, after map[string]json.RawMessage
+	json.Unmarshal([]byte(unversioned), &before)
+	json.Unmarshal(upgraded, &after)
+	for field, value := range before {
+		if string(after[field]) != string(value) {
+			t.Errorf("expected %s kept as %s, got %s", field, value, after[field])
+		}
+	}
+
+	// Metadata of the current version is left as is
+	current := []byte(`{"schema_version":1, "submitter":"B62q"}`)
+	if upgraded, err := Upgrade(current); err != nil || string(upgraded) != string(current) {
+		t.Fatalf("expected the metadata unchanged, got %s (error: %v)", upgraded, err)
+	}
+}
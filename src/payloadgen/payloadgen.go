@@ -0,0 +1,144 @@
+// Package payloadgen generates /submit request bodies signed as Mina nodes
+// sign them, for tests and for block producers checking their setup
+// against a local backend. Requests are built by package client.
+//
+// Signatures are made by a Signer. The backend only links the verifying
+// half of the Mina signer, so signing with the key of a submitter is left
+// to an external command (CommandSigner). RandomSigner makes well-formed
+// but invalid signatures, for backends running with signature
+// verification disabled.
+package payloadgen
+
+import (
+	"block_producers_uptime/client"
+	dg "block_producers_uptime/delegation_backend"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signer signs the blake2b hash of the sign payload of a submission, see
+// dg.SignPayloadHash, with the key of the submitter
+type Signer interface {
+	Sign(hash []byte, networkId uint8) (dg.Sig, error)
+}
+
+// CommandSigner runs a command to sign each submission. The command reads
+// the hash, hex-encoded, from its standard input, with the network id in
+// MINA_NETWORK_ID, and writes the base58check-encoded signature to its
+// standard output.
+type CommandSigner struct {
+	Command []string
+}
+
+func (c CommandSigner) Sign(hash []byte, networkId uint8) (sig dg.Sig, err error) {
+	cmd := exec.Command(c.Command[0], c.Command[1:]...)
+	cmd.Env = append(os.Environ(), "MINA_NETWORK_ID="+strconv.Itoa(int(networkId)))
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(hash) + "\n")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return sig, fmt.Errorf("error running %s: %w", c.Command[0], err)
+	}
+	if err := dg.StringToSig(&sig, strings.TrimSpace(string(out))); err != nil {
+		return sig, fmt.Errorf("invalid signature from %s: %w", c.Command[0], err)
+	}
+	return sig, nil
+}
+
+// RandomSigner makes random signatures, rejected by backends verifying them
+type RandomSigner struct {
+	mutex sync.Mutex
+	Rand  *rand.Rand
+}
+
+func (r *RandomSigner) Sign(hash []byte, networkId uint8) (sig dg.Sig, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Rand.Read(sig[:])
+	return sig, nil
+}
+
+// Options of a generated submission, zero values are generated
+type Options struct {
+	Submitter dg.Pk
+	NetworkId uint8
+	// Version of the sign payload, set in payload_version unless zero
+	PayloadVersion int
+	// Now by default, submissions have a precision of one second
+	CreatedAt time.Time
+	PeerId    string
+	// Random bytes of BlockSize by default
+	Block              []byte
+	BlockSize          int
+	SnarkWork          []byte
+	GraphqlControlPort int
+	BuiltWithCommitSha string
+	// Source of random blocks and peer ids
+	Rand *rand.Rand
+}
+
+// Payload generated, along with the hashes the backend derives from it
+type Payload struct {
+	Body            []byte
+	BlockHash       string
+	SignPayloadHash []byte
+}
+
+// RandomPeerId of the length of libp2p peer ids
+func RandomPeerId(r *rand.Rand) string {
+	peerId := make([]byte, 30)
+	r.Read(peerId)
+	return base64.StdEncoding.EncodeToString(peerId)
+}
+
+// Generate a submission of the options, signed by signer
+func Generate(opts Options, signer Signer) (*Payload, error) {
+	if opts.Rand == nil {
+		opts.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	block := opts.Block
+	if block == nil {
+		if opts.BlockSize <= 0 {
+			return nil, fmt.Errorf("either a block or a block size is required")
+		}
+		block = make([]byte, opts.BlockSize)
+		opts.Rand.Read(block)
+	}
+	if opts.CreatedAt.IsZero() {
+		opts.CreatedAt = time.Now()
+	}
+	if opts.PeerId == "" {
+		opts.PeerId = RandomPeerId(opts.Rand)
+	}
+	// Version 1 unless set, as requests without payload_version
+	version := opts.PayloadVersion
+	if version == 0 {
+		version = client.SIGN_PAYLOAD_V1
+	}
+	sign := client.SignerFunc(func(hash []byte) (string, error) {
+		sig, err := signer.Sign(hash, opts.NetworkId)
+		return sig.String(), err
+	})
+	req, err := client.Build(client.Submission{
+		Submitter:          opts.Submitter.String(),
+		Block:              block,
+		CreatedAt:          opts.CreatedAt,
+		PeerId:             opts.PeerId,
+		SnarkWork:          opts.SnarkWork,
+		GraphqlControlPort: opts.GraphqlControlPort,
+		BuiltWithCommitSha: opts.BuiltWithCommitSha,
+		PayloadVersion:     version,
+	}, sign)
+	if err != nil {
+		return nil, err
+	}
+	return &Payload{Body: req.Body, BlockHash: req.BlockHash, SignPayloadHash: req.SignPayloadHash}, nil
+}
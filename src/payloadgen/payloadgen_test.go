@@ -0,0 +1,90 @@
+package payloadgen
+
+import (
+	dg "block_producers_uptime/delegation_backend"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+type fixtureSigner struct {
+	sig   dg.Sig
+	hash  []byte
+	calls int
+}
+
+func (f *fixtureSigner) Sign(hash []byte, networkId uint8) (dg.Sig, error) {
+	f.hash = hash
+	f.calls++
+	return f.sig, nil
+}
+
+// A submission of a node is generated again from its fields, with the
+// signature of the node
+func TestGenerate(t *testing.T) {
+	body, err := os.ReadFile("../../test/data/req-with-snark.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture struct {
+		Submitter dg.Pk           `json:"submitter"`
+		Sig       dg.Sig          `json:"signature"`
+		Data      json.RawMessage `json:"data"`
+	}
+	var data struct {
+		Block     string    `json:"block"`
+		CreatedAt time.Time `json:"created_at"`
+		PeerId    string    `json:"peer_id"`
+		SnarkWork string    `json:"snark_work"`
+	}
+	if err := json.Unmarshal(body, &fixture); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(fixture.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	block, _ := base64.StdEncoding.DecodeString(data.Block)
+	snarkWork, _ := base64.StdEncoding.DecodeString(data.SnarkWork)
+	signer := &fixtureSigner{sig: fixture.Sig}
+	payload, err := Generate(Options{
+		Submitter: fixture.Submitter,
+		CreatedAt: data.CreatedAt,
+		PeerId:    data.PeerId,
+		Block:     block,
+		SnarkWork: snarkWork,
+	}, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHash, err := dg.SignPayloadHash(fixture.Data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(signer.hash, expectedHash) || !bytes.Equal(payload.SignPayloadHash, expectedHash) {
+		t.Fatal("expected the sign payload of the node signed")
+	}
+	in, err := dg.Inspect(payload.Body, nil, dg.MinaSchnorr{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Submitter != fixture.Submitter.String() || in.BlockHash != payload.BlockHash || in.SignatureValid == nil || !*in.SignatureValid {
+		t.Fatalf("unexpected submission generated %+v", in)
+	}
+}
+
+func TestCommandSigner(t *testing.T) {
+	var sig dg.Sig
+	sig[0] = 1
+	encoded, _ := json.Marshal(sig)
+	signer := CommandSigner{Command: []string{"sh", "-c", `test "$(cat)" = 0a0b -a "$MINA_NETWORK_ID" = 1 && echo ` + string(encoded)}}
+	signed, err := signer.Sign([]byte{10, 11}, 1)
+	if err != nil || signed != sig {
+		t.Fatalf("unexpected signature %v, error: %v", signed, err)
+	}
+	if _, err := signer.Sign([]byte{10, 12}, 1); err == nil {
+		t.Fatal("expected an error of a failed command")
+	}
+}